@@ -0,0 +1,203 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// Package bpf loads eBPF maps and programs, and assembles the programs from instructions.
+package bpf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+)
+
+// Register is an eBPF register. R10 is the read-only frame pointer.
+type Register uint8
+
+const (
+	R0 Register = iota
+	R1
+	R2
+	R3
+	R4
+	R5
+	R6
+	R7
+	R8
+	R9
+	R10
+)
+
+// Instruction classes.
+const (
+	classLd    = 0x00
+	classLdx   = 0x01
+	classSt    = 0x02
+	classStx   = 0x03
+	classJmp   = 0x05
+	classAlu64 = 0x07
+)
+
+// Sizes of memory accesses.
+const (
+	SizeW  = 0x00
+	SizeH  = 0x08
+	SizeB  = 0x10
+	SizeDW = 0x18
+)
+
+// Arithmetic operations.
+const (
+	OpAdd = 0x00
+	OpSub = 0x10
+	OpOr  = 0x40
+	OpAnd = 0x50
+	OpLsh = 0x60
+	OpRsh = 0x70
+	OpMov = 0xb0
+)
+
+// Jump operations.
+const (
+	JumpAlways  = 0x00
+	JumpEq      = 0x10
+	JumpGt      = 0x20
+	JumpGe      = 0x30
+	JumpNe      = 0x50
+	jumpCall    = 0x80
+	jumpExit    = 0x90
+	modeImm     = 0x00
+	modeMem     = 0x60
+	sourceImm   = 0x00
+	sourceReg   = 0x08
+	pseudoMapFd = 1
+)
+
+// Instruction is an eBPF instruction.
+type Instruction struct {
+	OpCode uint8
+	Dst    Register
+	Src    Register
+	Off    int16
+	Imm    int32
+}
+
+// Assembler builds a program from instructions, resolving the jumps to labels.
+type Assembler struct {
+	insns  []Instruction
+	labels map[string]int
+	jumps  map[int]string
+}
+
+// NewAssembler creates an assembler for a new program.
+func NewAssembler() *Assembler {
+	return &Assembler{
+		labels: make(map[string]int),
+		jumps:  make(map[int]string),
+	}
+}
+
+func (a *Assembler) emit(insn Instruction) {
+	a.insns = append(a.insns, insn)
+}
+
+// Label marks the position of the next instruction as a jump target.
+func (a *Assembler) Label(name string) {
+	a.labels[name] = len(a.insns)
+}
+
+// AluImm applies an arithmetic operation with an immediate value to a register.
+func (a *Assembler) AluImm(op uint8, dst Register, imm int32) {
+	a.emit(Instruction{OpCode: classAlu64 | op | sourceImm, Dst: dst, Imm: imm})
+}
+
+// AluReg applies an arithmetic operation with a register to a register.
+func (a *Assembler) AluReg(op uint8, dst Register, src Register) {
+	a.emit(Instruction{OpCode: classAlu64 | op | sourceReg, Dst: dst, Src: src})
+}
+
+// Load loads the value of the given size at src+off into a register.
+func (a *Assembler) Load(size uint8, dst Register, src Register, off int16) {
+	a.emit(Instruction{OpCode: classLdx | modeMem | size, Dst: dst, Src: src, Off: off})
+}
+
+// Store stores the value of the given size of a register at dst+off.
+func (a *Assembler) Store(size uint8, dst Register, off int16, src Register) {
+	a.emit(Instruction{OpCode: classStx | modeMem | size, Dst: dst, Src: src, Off: off})
+}
+
+// StoreImm stores an immediate value of the given size at dst+off.
+func (a *Assembler) StoreImm(size uint8, dst Register, off int16, imm int32) {
+	a.emit(Instruction{OpCode: classSt | modeMem | size, Dst: dst, Off: off, Imm: imm})
+}
+
+// LoadMap loads the map with the given file descriptor into a register, e.g. for a helper call.
+func (a *Assembler) LoadMap(dst Register, fd int) {
+	a.emit(Instruction{OpCode: classLd | modeImm | SizeDW, Dst: dst, Src: pseudoMapFd, Imm: int32(fd)})
+	a.emit(Instruction{})
+}
+
+// JumpImm jumps to a label if a register compares to an immediate value.
+func (a *Assembler) JumpImm(op uint8, dst Register, imm int32, label string) {
+	a.jumps[len(a.insns)] = label
+	a.emit(Instruction{OpCode: classJmp | op | sourceImm, Dst: dst, Imm: imm})
+}
+
+// JumpReg jumps to a label if a register compares to another register.
+func (a *Assembler) JumpReg(op uint8, dst Register, src Register, label string) {
+	a.jumps[len(a.insns)] = label
+	a.emit(Instruction{OpCode: classJmp | op | sourceReg, Dst: dst, Src: src})
+}
+
+// Jump jumps to a label.
+func (a *Assembler) Jump(label string) {
+	a.JumpImm(JumpAlways, R0, 0, label)
+}
+
+// Call calls a helper function. Arguments are passed in R1 to R5, and the result returned in R0.
+func (a *Assembler) Call(helper int32) {
+	a.emit(Instruction{OpCode: classJmp | jumpCall, Imm: helper})
+}
+
+// Exit returns R0 from the program.
+func (a *Assembler) Exit() {
+	a.emit(Instruction{OpCode: classJmp | jumpExit})
+}
+
+// Assemble returns the encoded instructions of the program.
+func (a *Assembler) Assemble() ([]byte, error) {
+	b := make([]byte, 8*len(a.insns))
+
+	for i, insn := range a.insns {
+		if label, isJump := a.jumps[i]; isJump {
+			target, exists := a.labels[label]
+			if !exists {
+				return nil, fmt.Errorf("Undefined label %s", label)
+			}
+			insn.Off = int16(target - i - 1)
+		}
+
+		b[8*i] = insn.OpCode
+		b[8*i+1] = uint8(insn.Src)<<4 | uint8(insn.Dst)
+		encoder.PutUint16(b[8*i+2:], uint16(insn.Off))
+		encoder.PutUint32(b[8*i+4:], uint32(insn.Imm))
+	}
+
+	return b, nil
+}
+
+// Instructions and map entries are in the native byte order.
+var encoder binary.ByteOrder
+
+func init() {
+	i := uint16(1)
+	if *(*byte)(unsafe.Pointer(&i)) == 1 {
+		encoder = binary.LittleEndian
+	} else {
+		encoder = binary.BigEndian
+	}
+}
+
+// NativeEndian returns the byte order of the map keys and values the programs read as integers.
+func NativeEndian() binary.ByteOrder {
+	return encoder
+}
@@ -0,0 +1,302 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build linux
+
+package bpf
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// bpf syscall commands.
+const (
+	cmdMapCreate     = 0
+	cmdMapLookupElem = 1
+	cmdMapUpdateElem = 2
+	cmdMapDeleteElem = 3
+	cmdMapGetNextKey = 4
+	cmdProgLoad      = 5
+	cmdProgTestRun   = 10
+)
+
+// Map types.
+const (
+	MapTypeHash    = 1
+	MapTypeLRUHash = 9
+	MapTypeLPMTrie = 11
+)
+
+// Program types.
+const (
+	ProgTypeSchedCLS = 3
+)
+
+// Helper functions.
+const (
+	FuncMapLookupElem = 1
+	FuncMapUpdateElem = 2
+	FuncKtimeGetNs    = 5
+	FuncSkbLoadBytes  = 26
+)
+
+const (
+	// LPM tries must be created without preallocated entries.
+	mapFlagNoPrealloc = 1
+
+	// Size of the verifier log returned when a program fails to load.
+	verifierLogSize = 64 * 1024
+)
+
+// mapCreateAttr is the bpf_attr of the map create command.
+type mapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+}
+
+// mapElemAttr is the bpf_attr of the map element commands.
+type mapElemAttr struct {
+	mapFd uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+// progLoadAttr is the bpf_attr of the program load command.
+type progLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+// progTestRunAttr is the bpf_attr of the program test run command.
+type progTestRunAttr struct {
+	progFd      uint32
+	retval      uint32
+	dataSizeIn  uint32
+	dataSizeOut uint32
+	dataIn      uint64
+	dataOut     uint64
+	repeat      uint32
+	duration    uint32
+}
+
+// bpf invokes the bpf syscall.
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int(r), nil
+}
+
+// pointer returns the address of the first byte of b as a bpf_attr pointer.
+func pointer(b []byte) uint64 {
+	if len(b) == 0 {
+		return 0
+	}
+
+	return uint64(uintptr(unsafe.Pointer(&b[0])))
+}
+
+// RemoveMemlockLimit lifts the locked memory limit, which maps and programs are charged to on older kernels.
+func RemoveMemlockLimit() error {
+	return unix.Setrlimit(unix.RLIMIT_MEMLOCK, &unix.Rlimit{Cur: unix.RLIM_INFINITY, Max: unix.RLIM_INFINITY})
+}
+
+// Map is an eBPF map.
+type Map struct {
+	fd        int
+	KeySize   int
+	ValueSize int
+}
+
+// NewMap creates an eBPF map.
+func NewMap(mapType uint32, keySize int, valueSize int, maxEntries int) (*Map, error) {
+	attr := mapCreateAttr{
+		mapType:    mapType,
+		keySize:    uint32(keySize),
+		valueSize:  uint32(valueSize),
+		maxEntries: uint32(maxEntries),
+	}
+
+	if mapType == MapTypeLPMTrie {
+		attr.mapFlags = mapFlagNoPrealloc
+	}
+
+	fd, err := bpf(cmdMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create map of type %d, err:%v", mapType, err)
+	}
+
+	return &Map{fd: fd, KeySize: keySize, ValueSize: valueSize}, nil
+}
+
+// FD returns the file descriptor of the map.
+func (m *Map) FD() int {
+	return m.fd
+}
+
+// Close releases the map. Programs using it keep it alive.
+func (m *Map) Close() error {
+	return unix.Close(m.fd)
+}
+
+// elem invokes a map element command.
+func (m *Map) elem(cmd int, key []byte, value []byte, flags uint64) error {
+	if len(key) != m.KeySize {
+		return fmt.Errorf("Invalid key size %d, expected %d", len(key), m.KeySize)
+	}
+
+	attr := mapElemAttr{
+		mapFd: uint32(m.fd),
+		key:   pointer(key),
+		value: pointer(value),
+		flags: flags,
+	}
+
+	_, err := bpf(cmd, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+
+	return err
+}
+
+// Update creates or updates the element of a key.
+func (m *Map) Update(key []byte, value []byte) error {
+	if len(value) != m.ValueSize {
+		return fmt.Errorf("Invalid value size %d, expected %d", len(value), m.ValueSize)
+	}
+
+	return m.elem(cmdMapUpdateElem, key, value, 0)
+}
+
+// Lookup returns the value of a key. It fails with ENOENT if the key doesn't exist.
+func (m *Map) Lookup(key []byte) ([]byte, error) {
+	value := make([]byte, m.ValueSize)
+	if err := m.elem(cmdMapLookupElem, key, value, 0); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Delete deletes the element of a key. It fails with ENOENT if the key doesn't exist.
+func (m *Map) Delete(key []byte) error {
+	return m.elem(cmdMapDeleteElem, key, nil, 0)
+}
+
+// Keys returns the keys of the map. Keys updated while iterating may be missed.
+func (m *Map) Keys() ([][]byte, error) {
+	var keys [][]byte
+
+	// A nil key starts the iteration from the first key.
+	var key []byte
+	for {
+		next := make([]byte, m.KeySize)
+		attr := mapElemAttr{
+			mapFd: uint32(m.fd),
+			key:   pointer(key),
+			value: pointer(next),
+		}
+
+		_, err := bpf(cmdMapGetNextKey, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+		runtime.KeepAlive(key)
+		runtime.KeepAlive(next)
+
+		if err == unix.ENOENT {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, next)
+		key = next
+	}
+}
+
+// Program is a loaded eBPF program.
+type Program struct {
+	fd int
+}
+
+// LoadProgram loads an eBPF program. If the verifier rejects it, the error includes the verifier log.
+func LoadProgram(progType uint32, insns []byte, license string) (*Program, error) {
+	licenseBytes := append([]byte(license), 0)
+	logBuf := make([]byte, verifierLogSize)
+
+	attr := progLoadAttr{
+		progType: progType,
+		insnCnt:  uint32(len(insns) / 8),
+		insns:    pointer(insns),
+		license:  pointer(licenseBytes),
+		logLevel: 1,
+		logSize:  uint32(len(logBuf)),
+		logBuf:   pointer(logBuf),
+	}
+
+	fd, err := bpf(cmdProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(licenseBytes)
+	runtime.KeepAlive(logBuf)
+
+	if err != nil {
+		if i := bytes.IndexByte(logBuf, 0); i >= 0 {
+			logBuf = logBuf[:i]
+		}
+		return nil, fmt.Errorf("Failed to load program, err:%v, verifier log:\n%s", err, logBuf)
+	}
+
+	return &Program{fd: fd}, nil
+}
+
+// FD returns the file descriptor of the program.
+func (p *Program) FD() int {
+	return p.fd
+}
+
+// Close releases the program. Hooks it is attached to keep it alive.
+func (p *Program) Close() error {
+	return unix.Close(p.fd)
+}
+
+// TestRun runs the program once on a packet starting at its Ethernet header, and returns its result.
+func (p *Program) TestRun(packet []byte) (uint32, error) {
+	out := make([]byte, len(packet)+256)
+
+	attr := progTestRunAttr{
+		progFd:      uint32(p.fd),
+		dataSizeIn:  uint32(len(packet)),
+		dataSizeOut: uint32(len(out)),
+		dataIn:      pointer(packet),
+		dataOut:     pointer(out),
+		repeat:      1,
+	}
+
+	_, err := bpf(cmdProgTestRun, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(packet)
+	runtime.KeepAlive(out)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return attr.retval, nil
+}
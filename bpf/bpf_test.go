@@ -0,0 +1,138 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build linux
+
+package bpf
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAssemble(t *testing.T) {
+	a := NewAssembler()
+	a.JumpImm(JumpEq, R1, 0, "zero")
+	a.AluImm(OpMov, R0, 1)
+	a.Exit()
+	a.Label("zero")
+	a.AluImm(OpMov, R0, 0)
+	a.Exit()
+
+	insns, err := a.Assemble()
+	if err != nil {
+		t.Fatalf("Assemble failed, err:%v", err)
+	}
+
+	// The jump skips the two instructions after it.
+	want := []byte{0x15, 0x01}
+	want = append(want, encodeUint16(2)...)
+	want = append(want, 0, 0, 0, 0)
+	if !bytes.Equal(insns[:8], want) {
+		t.Errorf("Unexpected jump encoding %x, expected %x", insns[:8], want)
+	}
+
+	a.Jump("missing")
+	if _, err := a.Assemble(); err == nil {
+		t.Errorf("Assemble succeeded with an undefined label")
+	}
+}
+
+func encodeUint16(v uint16) []byte {
+	b := make([]byte, 2)
+	NativeEndian().PutUint16(b, v)
+	return b
+}
+
+func TestMap(t *testing.T) {
+	RemoveMemlockLimit()
+
+	m, err := NewMap(MapTypeHash, 4, 2, 16)
+	if err != nil {
+		t.Fatalf("NewMap failed, err:%v", err)
+	}
+	defer m.Close()
+
+	key := []byte{10, 0, 0, 1}
+	if err := m.Update(key, []byte{1, 2}); err != nil {
+		t.Fatalf("Update failed, err:%v", err)
+	}
+
+	value, err := m.Lookup(key)
+	if err != nil || !bytes.Equal(value, []byte{1, 2}) {
+		t.Errorf("Lookup returned %v, %v", value, err)
+	}
+
+	keys, err := m.Keys()
+	if err != nil || len(keys) != 1 || !bytes.Equal(keys[0], key) {
+		t.Errorf("Keys returned %v, %v", keys, err)
+	}
+
+	if err := m.Delete(key); err != nil {
+		t.Errorf("Delete failed, err:%v", err)
+	}
+
+	if _, err := m.Lookup(key); err != unix.ENOENT {
+		t.Errorf("Lookup of a deleted key returned %v", err)
+	}
+
+	if err := m.Update([]byte{1}, []byte{1, 2}); err == nil {
+		t.Errorf("Update succeeded with an invalid key size")
+	}
+}
+
+func TestProgram(t *testing.T) {
+	RemoveMemlockLimit()
+
+	m, err := NewMap(MapTypeHash, 4, 4, 16)
+	if err != nil {
+		t.Fatalf("NewMap failed, err:%v", err)
+	}
+	defer m.Close()
+
+	value := make([]byte, 4)
+	NativeEndian().PutUint32(value, 7)
+	m.Update([]byte{0, 0, 0, 0}, value)
+
+	// Return the value of the zero key, or 1 if it doesn't exist.
+	a := NewAssembler()
+	a.StoreImm(SizeW, R10, -4, 0)
+	a.LoadMap(R1, m.FD())
+	a.AluReg(OpMov, R2, R10)
+	a.AluImm(OpAdd, R2, -4)
+	a.Call(FuncMapLookupElem)
+	a.JumpImm(JumpEq, R0, 0, "missing")
+	a.Load(SizeW, R0, R0, 0)
+	a.Exit()
+	a.Label("missing")
+	a.AluImm(OpMov, R0, 1)
+	a.Exit()
+
+	insns, err := a.Assemble()
+	if err != nil {
+		t.Fatalf("Assemble failed, err:%v", err)
+	}
+
+	prog, err := LoadProgram(ProgTypeSchedCLS, insns, "MIT")
+	if err != nil {
+		t.Fatalf("LoadProgram failed, err:%v", err)
+	}
+	defer prog.Close()
+
+	packet := make([]byte, 64)
+	if result, err := prog.TestRun(packet); err != nil || result != 7 {
+		t.Errorf("TestRun returned %d, %v, expected 7", result, err)
+	}
+
+	m.Delete([]byte{0, 0, 0, 0})
+	if result, err := prog.TestRun(packet); err != nil || result != 1 {
+		t.Errorf("TestRun returned %d, %v, expected 1", result, err)
+	}
+
+	// The verifier rejects programs without an exit.
+	if _, err := LoadProgram(ProgTypeSchedCLS, insns[:8], "MIT"); err == nil {
+		t.Errorf("LoadProgram succeeded with an invalid program")
+	}
+}
@@ -16,7 +16,8 @@ const (
 	CmdUpdate = "UPDATE"
 
 	// CNI errors.
-	ErrRuntime = 100
+	ErrRuntime            = 100
+	ErrGatewayUnreachable = 101
 
 	// DefaultVersion is the CNI version used when no version is specified in a network config file.
 	defaultVersion = "0.2.0"
@@ -0,0 +1,109 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+)
+
+// NetworkConfigList is a CNI network configuration list, whose plugins are chained in order.
+// Plugin configurations are kept as is, so that plugins azure-vnet doesn't know keep all their fields.
+type NetworkConfigList struct {
+	CNIVersion string            `json:"cniVersion"`
+	Name       string            `json:"name"`
+	Plugins    []json.RawMessage `json:"plugins"`
+}
+
+// IsNetworkConfigList checks if network configuration bytes hold a configuration list.
+func IsNetworkConfigList(b []byte) bool {
+	var list struct {
+		Plugins json.RawMessage `json:"plugins"`
+	}
+
+	return json.Unmarshal(b, &list) == nil && list.Plugins != nil
+}
+
+// ParseNetworkConfigList unmarshals a network configuration list from bytes.
+func ParseNetworkConfigList(b []byte) (*NetworkConfigList, error) {
+	list := NetworkConfigList{}
+
+	err := json.Unmarshal(b, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(list.Plugins) == 0 {
+		return nil, fmt.Errorf("Network configuration list %s has no plugins", list.Name)
+	}
+
+	if list.CNIVersion == "" {
+		list.CNIVersion = defaultVersion
+	}
+
+	return &list, nil
+}
+
+// GetPluginType returns the type of the plugin at an index of the list.
+func (list *NetworkConfigList) GetPluginType(index int) (string, error) {
+	var plugin struct {
+		Type string `json:"type"`
+	}
+
+	if err := json.Unmarshal(list.Plugins[index], &plugin); err != nil {
+		return "", err
+	}
+
+	if plugin.Type == "" {
+		return "", fmt.Errorf("Plugin %d of network configuration list %s has no type", index, list.Name)
+	}
+
+	return plugin.Type, nil
+}
+
+// FindPlugin returns the index of the first plugin of a type in the list, -1 if there is none.
+func (list *NetworkConfigList) FindPlugin(pluginType string) int {
+	for i := range list.Plugins {
+		if t, err := list.GetPluginType(i); err == nil && t == pluginType {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// GetPluginConfig returns the configuration of the plugin at an index of the list as the runtime
+// passes it, with the name and CNI version of the list and the result of the preceding plugins.
+func (list *NetworkConfigList) GetPluginConfig(index int, prevResult cniTypes.Result) ([]byte, error) {
+	var config map[string]interface{}
+	if err := json.Unmarshal(list.Plugins[index], &config); err != nil {
+		return nil, err
+	}
+
+	config["name"] = list.Name
+	config["cniVersion"] = list.CNIVersion
+
+	if prevResult != nil {
+		versioned, err := prevResult.GetAsVersion(list.CNIVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := json.Marshal(versioned)
+		if err != nil {
+			return nil, err
+		}
+
+		var result map[string]interface{}
+		if err = json.Unmarshal(b, &result); err != nil {
+			return nil, err
+		}
+
+		config["prevResult"] = result
+	}
+
+	return json.Marshal(config)
+}
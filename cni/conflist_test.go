@@ -0,0 +1,73 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package cni
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
+)
+
+var conflist = []byte(`{
+	"cniVersion": "0.3.0",
+	"name": "azure",
+	"plugins": [
+		{"type": "azure-vnet", "mode": "bridge", "ipam": {"type": "azure-vnet-ipam"}},
+		{"type": "portmap", "capabilities": {"portMappings": true}, "snat": true}
+	]
+}`)
+
+// Tests that chained plugins are kept with all their fields.
+func TestGetPluginConfigKeepsChainedPlugins(t *testing.T) {
+	if !IsNetworkConfigList(conflist) || IsNetworkConfigList([]byte(`{"name": "azure", "type": "azure-vnet"}`)) {
+		t.Fatalf("Network configuration lists not told apart from plugin configurations")
+	}
+
+	list, err := ParseNetworkConfigList(conflist)
+	if err != nil {
+		t.Fatalf("Failed to parse network configuration list: %v", err)
+	}
+
+	if index := list.FindPlugin("portmap"); index != 1 {
+		t.Fatalf("Expected portmap at index 1, got %d", index)
+	}
+
+	address := net.IPNet{IP: net.ParseIP("10.0.0.4").To4(), Mask: net.CIDRMask(24, 32)}
+	result := &cniTypesCurr.Result{IPs: []*cniTypesCurr.IPConfig{{Version: "4", Address: address}}}
+
+	b, err := list.GetPluginConfig(1, result)
+	if err != nil {
+		t.Fatalf("Failed to get plugin configuration: %v", err)
+	}
+
+	var config struct {
+		Name       string
+		CNIVersion string `json:"cniVersion"`
+		Snat       bool
+		PrevResult struct {
+			IPs []struct{ Address string }
+		}
+	}
+
+	if err = json.Unmarshal(b, &config); err != nil {
+		t.Fatalf("Failed to unmarshal plugin configuration: %v", err)
+	}
+
+	if config.Name != "azure" || config.CNIVersion != "0.3.0" || !config.Snat {
+		t.Errorf("Unexpected plugin configuration %s", b)
+	}
+
+	if len(config.PrevResult.IPs) != 1 || config.PrevResult.IPs[0].Address != "10.0.0.4/24" {
+		t.Errorf("Unexpected previous result in plugin configuration %s", b)
+	}
+
+	// The platform plugin is flattened with the fields of the list.
+	b, _ = list.GetPluginConfig(0, nil)
+	nwCfg, err := ParseNetworkConfig(b)
+	if err != nil || nwCfg.Name != "azure" || nwCfg.Mode != "bridge" || nwCfg.Ipam.Type != "azure-vnet-ipam" {
+		t.Errorf("Unexpected network configuration %+v, err:%v", nwCfg, err)
+	}
+}
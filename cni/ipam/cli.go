@@ -0,0 +1,36 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// PrintState prints the address pools and allocations of the plugin for troubleshooting.
+func (plugin *ipamPlugin) PrintState(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(w, "POOL\tADDRESSES\tALLOCATED\tFREE\tUNHEALTHY\tFAILURES\n")
+	for _, pm := range plugin.am.GetMetrics().Pools {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n",
+			pm.PoolId, pm.Total, pm.Allocated, pm.Free, pm.Unhealthy, pm.AllocationFailures)
+	}
+
+	fmt.Fprintf(w, "\nADDRESS\tPOOL\tIN USE\tID\tSANDBOX\n")
+	for _, ai := range plugin.am.GetAddresses() {
+		if !ai.InUse && ai.ID == "" {
+			continue
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", ai.Address, ai.PoolId, ai.InUse, ai.ID, ai.Sandbox)
+	}
+
+	return w.Flush()
+}
+
+// ForceReleaseAddress releases the given address regardless of the container owning it.
+func (plugin *ipamPlugin) ForceReleaseAddress(address string) error {
+	return plugin.am.ForceReleaseAddress(address)
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/Azure/azure-container-networking/cni"
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/cnsclient"
+	"github.com/Azure/azure-container-networking/log"
+
+	cniSkel "github.com/containernetworking/cni/pkg/skel"
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
+)
+
+// Error returned when the local CNS is too old to allocate pod addresses.
+var errCNSIPConfigNotSupported = fmt.Errorf("CNS does not support %s, upgrade CNS to use it for IPAM", cns.FeatureRequestIPConfig)
+
+// getPodInterfaceID returns the ID under which CNS tracks the address of a pod interface.
+func getPodInterfaceID(args *cniSkel.CmdArgs) string {
+	return fmt.Sprintf("%v-%v", args.ContainerID, args.IfName)
+}
+
+// getOrchestratorContext returns the orchestrator context of the pod, if the CNI args describe one.
+func getOrchestratorContext(args *cniSkel.CmdArgs) []byte {
+	podCfg, err := cni.ParseCniArgs(args.Args)
+	if err != nil || podCfg.K8S_POD_NAME == "" {
+		return nil
+	}
+
+	podInfo := cns.KubernetesPodInfo{
+		PodName:      string(podCfg.K8S_POD_NAME),
+		PodNamespace: string(podCfg.K8S_POD_NAMESPACE),
+	}
+
+	orchestratorContext, err := json.Marshal(podInfo)
+	if err != nil {
+		log.Printf("[cni-ipam] Marshalling KubernetesPodInfo failed with %v", err)
+		return nil
+	}
+
+	return orchestratorContext
+}
+
+// requestAddressFromCNS requests an address for the pod interface from the local CNS.
+func (plugin *ipamPlugin) requestAddressFromCNS(args *cniSkel.CmdArgs, nwCfg *cni.NetworkConfig) (*cniTypesCurr.Result, error) {
+	cnsClient, err := cnsclient.NewCnsClient(nwCfg.CNSUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail the request instead of calling an API an older CNS doesn't serve.
+	supported, err := cnsClient.SupportsFeature(cns.FeatureRequestIPConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if !supported {
+		return nil, errCNSIPConfigNotSupported
+	}
+
+	resp, err := cnsClient.RequestIPAddress(getPodInterfaceID(args), getOrchestratorContext(args))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[cni-ipam] Received ip configuration %+v from CNS.", resp.IPConfiguration)
+
+	ipConfig := resp.IPConfiguration
+	ip := net.ParseIP(ipConfig.IPSubnet.IPAddress)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid address %v", ipConfig.IPSubnet.IPAddress)
+	}
+
+	version, bits, defaultRouteDstPrefix := "4", 32, ipv4DefaultRouteDstPrefix
+	if ip.To4() == nil {
+		version, bits, defaultRouteDstPrefix = "6", 128, ipv6DefaultRouteDstPrefix
+	}
+
+	gateway := net.ParseIP(ipConfig.GatewayIPAddress)
+	address := net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(int(ipConfig.IPSubnet.PrefixLength), bits),
+	}
+
+	result := &cniTypesCurr.Result{
+		IPs: []*cniTypesCurr.IPConfig{
+			{
+				Version: version,
+				Address: address,
+				Gateway: gateway,
+			},
+		},
+	}
+
+	// Pods served from the pod CIDR of the node are routed through the node, whose address is
+	// outside of the pod CIDR and is reached on-link.
+	if gateway != nil && !address.Contains(gateway) {
+		result.Routes = append(result.Routes, &cniTypes.Route{
+			Dst: net.IPNet{IP: gateway, Mask: net.CIDRMask(bits, bits)},
+		})
+	}
+
+	result.Routes = append(result.Routes, &cniTypes.Route{
+		Dst: defaultRouteDstPrefix,
+		GW:  gateway,
+	})
+
+	result.DNS.Nameservers = ipConfig.DNSServers
+
+	return result, nil
+}
+
+// releaseAddressToCNS releases the address held by the pod interface back to the local CNS.
+func (plugin *ipamPlugin) releaseAddressToCNS(args *cniSkel.CmdArgs, nwCfg *cni.NetworkConfig) error {
+	cnsClient, err := cnsclient.NewCnsClient(nwCfg.CNSUrl)
+	if err != nil {
+		return err
+	}
+
+	// An older CNS can't have allocated the address.
+	supported, err := cnsClient.SupportsFeature(cns.FeatureRequestIPConfig)
+	if err != nil || !supported {
+		return err
+	}
+
+	return cnsClient.ReleaseIPAddress(getPodInterfaceID(args), getOrchestratorContext(args))
+}
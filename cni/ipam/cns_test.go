@@ -0,0 +1,126 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/cni"
+	"github.com/Azure/azure-container-networking/cns"
+	acn "github.com/Azure/azure-container-networking/common"
+
+	cniSkel "github.com/containernetworking/cni/pkg/skel"
+)
+
+// newFakeCNS creates a CNS serving the given ip configuration to pod interfaces.
+func newFakeCNS(ipConfig cns.IPConfiguration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp interface{}
+
+		switch r.URL.Path {
+		case acn.CapabilitiesPath:
+			resp = &acn.CapabilitiesResponse{
+				Plugins: []acn.Capabilities{
+					{Name: cns.ServiceName, Features: []string{cns.FeatureRequestIPConfig}},
+				},
+			}
+		case cns.RequestIPConfigPath:
+			resp = &cns.IPConfigResponse{IPConfiguration: ipConfig}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+// Tests that addresses of both families are requested from CNS.
+func TestRequestAddressFromCNS(t *testing.T) {
+	tests := []struct {
+		name     string
+		ipConfig cns.IPConfiguration
+		version  string
+		address  string
+		routes   []string
+	}{
+		{
+			name: "IPv4",
+			ipConfig: cns.IPConfiguration{
+				IPSubnet:         cns.IPSubnet{IPAddress: "10.240.0.5", PrefixLength: 16},
+				GatewayIPAddress: "10.240.0.1",
+			},
+			version: "4",
+			address: "10.240.0.5/16",
+			routes:  []string{"0.0.0.0/0"},
+		},
+		{
+			name: "IPv4 pod CIDR",
+			ipConfig: cns.IPConfiguration{
+				IPSubnet:         cns.IPSubnet{IPAddress: "10.244.1.5", PrefixLength: 24},
+				GatewayIPAddress: "10.240.0.4",
+			},
+			version: "4",
+			address: "10.244.1.5/24",
+			routes:  []string{"10.240.0.4/32", "0.0.0.0/0"},
+		},
+		{
+			name: "IPv6",
+			ipConfig: cns.IPConfiguration{
+				IPSubnet:         cns.IPSubnet{IPAddress: "fd00::5", PrefixLength: 64},
+				GatewayIPAddress: "fd00::1",
+			},
+			version: "6",
+			address: "fd00::5/64",
+			routes:  []string{"::/0"},
+		},
+		{
+			name: "IPv6 pod CIDR",
+			ipConfig: cns.IPConfiguration{
+				IPSubnet:         cns.IPSubnet{IPAddress: "fd01:0:0:1::5", PrefixLength: 64},
+				GatewayIPAddress: "fd00::4",
+			},
+			version: "6",
+			address: "fd01:0:0:1::5/64",
+			routes:  []string{"fd00::4/128", "::/0"},
+		},
+	}
+
+	args := &cniSkel.CmdArgs{ContainerID: "container0", IfName: "eth0"}
+
+	for _, test := range tests {
+		server := newFakeCNS(test.ipConfig)
+
+		result, err := plugin.requestAddressFromCNS(args, &cni.NetworkConfig{CNSUrl: server.URL})
+		server.Close()
+
+		if err != nil {
+			t.Errorf("%s: requestAddressFromCNS failed: %v", test.name, err)
+			continue
+		}
+
+		if len(result.IPs) != 1 || result.IPs[0].Version != test.version || result.IPs[0].Address.String() != test.address {
+			t.Errorf("%s: Unexpected addresses %+v", test.name, result.IPs)
+			continue
+		}
+
+		if len(result.Routes) != len(test.routes) {
+			t.Errorf("%s: Expected routes %v, got %+v", test.name, test.routes, result.Routes)
+			continue
+		}
+
+		for i, route := range result.Routes {
+			if route.Dst.String() != test.routes[i] {
+				t.Errorf("%s: Expected route %v, got %v", test.name, test.routes[i], route.Dst.String())
+			}
+		}
+
+		if last := result.Routes[len(result.Routes)-1]; !last.GW.Equal(result.IPs[0].Gateway) {
+			t.Errorf("%s: Default route is not through the gateway, got %v", test.name, last.GW)
+		}
+	}
+}
@@ -5,14 +5,18 @@ package ipam
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 	"strconv"
+	"strings"
 
 	"github.com/Azure/azure-container-networking/cni"
+	"github.com/Azure/azure-container-networking/cns/cnsclient"
 	"github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/ipam"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/platform"
+	"github.com/Azure/azure-container-networking/store"
 
 	cniSkel "github.com/containernetworking/cni/pkg/skel"
 	cniTypes "github.com/containernetworking/cni/pkg/types"
@@ -22,10 +26,17 @@ import (
 const (
 	// Plugin name.
 	name = "azure-vnet-ipam"
+
+	// Address store backends.
+	storeTypeFile   = "file"
+	storeTypeMemory = "memory"
+	storeTypeCNS    = "cns"
+	storeTypeBolt   = "bolt"
 )
 
 var (
-	ipv4DefaultRouteDstPrefix = net.IPNet{net.IPv4zero, net.IPv4Mask(0, 0, 0, 0)}
+	ipv4DefaultRouteDstPrefix = net.IPNet{IP: net.IPv4zero, Mask: net.IPv4Mask(0, 0, 0, 0)}
+	ipv6DefaultRouteDstPrefix = net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
 )
 
 // IpamPlugin represents the CNI IPAM plugin.
@@ -72,6 +83,9 @@ func (plugin *ipamPlugin) Start(config *common.PluginConfig) error {
 	log.Printf("[cni-ipam] Plugin %v version %v.", plugin.Name, plugin.Version)
 	log.Printf("[cni-ipam] Running on %v", platform.GetOSInfo())
 
+	// Publish pool utilization metrics next to the plugin state.
+	plugin.SetOption(common.OptIpamMetricsPath, platform.CNIRuntimePath+plugin.Name+"-metrics.json")
+
 	// Initialize address manager.
 	err = plugin.am.Initialize(config, plugin.Options)
 	if err != nil {
@@ -115,11 +129,28 @@ func (plugin *ipamPlugin) Configure(stdinData []byte) (*cni.NetworkConfig, error
 		plugin.SetOption(common.OptIpamQueryInterval, i)
 	}
 
-	err = plugin.am.StartSource(plugin.Options)
+	// Serve the subnet of the network configuration in the static environment.
+	if nwCfg.Ipam.Environment == common.OptEnvironmentStatic {
+		plugin.SetOption(common.OptIpamSubnet, nwCfg.Ipam.Subnet)
+		plugin.SetOption(common.OptIpamGateway, nwCfg.Ipam.Gateway)
+		plugin.SetOption(common.OptIpamRangeStart, nwCfg.Ipam.RangeStart)
+		plugin.SetOption(common.OptIpamRangeEnd, nwCfg.Ipam.RangeEnd)
+	}
+
+	// Select the address store backend.
+	err = plugin.setAddressStore(nwCfg)
 	if err != nil {
 		return nil, err
 	}
 
+	// Addresses are owned by CNS in delegated mode, so no local source is started.
+	if nwCfg.Ipam.Environment != common.OptEnvironmentCNS {
+		err = plugin.am.StartSource(plugin.Options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Set default address space if not specified.
 	if nwCfg.Ipam.AddrSpace == "" {
 		nwCfg.Ipam.AddrSpace = ipam.LocalDefaultAddressSpaceId
@@ -128,6 +159,40 @@ func (plugin *ipamPlugin) Configure(stdinData []byte) (*cni.NetworkConfig, error
 	return nwCfg, nil
 }
 
+// setAddressStore switches the address manager to the store backend requested in the network configuration.
+func (plugin *ipamPlugin) setAddressStore(nwCfg *cni.NetworkConfig) error {
+	var kvs store.KeyValueStore
+	var err error
+
+	switch nwCfg.Ipam.Store {
+	case "", storeTypeFile:
+		// The JSON file store opened at startup is used by default.
+		return nil
+
+	case storeTypeMemory:
+		kvs = store.NewMemoryStore()
+
+	case storeTypeCNS:
+		kvs, err = cnsclient.NewCnsStore(nwCfg.CNSUrl)
+		if err != nil {
+			return err
+		}
+
+	case storeTypeBolt:
+		kvs, err = store.NewBoltStore(platform.CNIRuntimePath + plugin.Name + ".db")
+		if err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("Invalid address store %v", nwCfg.Ipam.Store)
+	}
+
+	log.Printf("[cni-ipam] Using %v address store.", nwCfg.Ipam.Store)
+
+	return plugin.am.SetStore(kvs)
+}
+
 //
 // CNI implementation
 // https://github.com/containernetworking/cni/blob/master/SPEC.md
@@ -150,6 +215,18 @@ func (plugin *ipamPlugin) Add(args *cniSkel.CmdArgs) error {
 		return err
 	}
 
+	// Request the address from CNS when running in delegated mode.
+	if nwCfg.Ipam.Environment == common.OptEnvironmentCNS {
+		result, err = plugin.requestAddressFromCNS(args, nwCfg)
+		if err != nil {
+			err = plugin.Errorf("Failed to allocate address from CNS: %v", err)
+			return err
+		}
+
+		err = plugin.writeResult(args, nwCfg, result)
+		return err
+	}
+
 	// Check if an address pool is specified.
 	if nwCfg.Ipam.Subnet == "" {
 		var poolID string
@@ -159,8 +236,8 @@ func (plugin *ipamPlugin) Add(args *cniSkel.CmdArgs) error {
 		options := make(map[string]string)
 		options[ipam.OptInterfaceName] = nwCfg.Master
 
-		// Allocate an address pool.
-		poolID, subnet, err = plugin.am.RequestPool(nwCfg.Ipam.AddrSpace, "", "", options, false)
+		// Allocate an address pool of the requested address family.
+		poolID, subnet, err = plugin.am.RequestPool(nwCfg.Ipam.AddrSpace, "", "", options, nwCfg.Ipam.IPv6)
 		if err != nil {
 			err = plugin.Errorf("Failed to allocate pool: %v", err)
 			return err
@@ -178,9 +255,37 @@ func (plugin *ipamPlugin) Add(args *cniSkel.CmdArgs) error {
 		log.Printf("[cni-ipam] Allocated address poolID %v with subnet %v.", poolID, subnet)
 	}
 
+	// Record the sandbox owning the address so that it can be reclaimed if the sandbox disappears.
+	options := make(map[string]string)
+	options[ipam.OptAddressSandbox] = args.Netns
+
+	// Continue allocating from prefixes added to the subnet once the network's pool is exhausted.
+	options[ipam.OptAddressPoolSpan] = "true"
+
+	// Never hand out addresses reserved by the operator.
+	if len(nwCfg.Ipam.Exclude) > 0 {
+		options[ipam.OptAddressExclusions] = strings.Join(nwCfg.Ipam.Exclude, ",")
+	}
+
 	// Allocate an address for the endpoint.
-	address, err := plugin.am.RequestAddress(nwCfg.Ipam.AddrSpace, nwCfg.Ipam.Subnet, nwCfg.Ipam.Address, nil)
+	address, err := plugin.am.RequestAddress(nwCfg.Ipam.AddrSpace, nwCfg.Ipam.Subnet, nwCfg.Ipam.Address, options)
 	if err != nil {
+		// Reclaim addresses leaked by sandboxes that are gone and retry once.
+		released, gcErr := plugin.am.ReleaseOrphanedAddresses(nwCfg.Ipam.AddrSpace, isSandboxAlive)
+		if gcErr != nil {
+			log.Printf("[cni-ipam] Failed to release orphaned addresses, err:%v.", gcErr)
+		} else if len(released) > 0 {
+			log.Printf("[cni-ipam] Released orphaned addresses %v.", released)
+			address, err = plugin.am.RequestAddress(nwCfg.Ipam.AddrSpace, nwCfg.Ipam.Subnet, nwCfg.Ipam.Address, options)
+		}
+	}
+
+	if err != nil {
+		// Surface exhausted pools as structured events, not just as a failed ADD.
+		if exhaustedErr, ok := err.(*ipam.PoolExhaustedError); ok {
+			plugin.reportPoolExhausted(exhaustedErr)
+		}
+
 		err = plugin.Errorf("Failed to allocate address: %v", err)
 		return err
 	}
@@ -203,24 +308,33 @@ func (plugin *ipamPlugin) Add(args *cniSkel.CmdArgs) error {
 	}
 
 	// Query pool information for gateways and DNS servers.
-	apInfo, err := plugin.am.GetPoolInfo(nwCfg.Ipam.AddrSpace, nwCfg.Ipam.Subnet)
+	// The address may come from a pool extending the network's subnet.
+	poolID := (&net.IPNet{IP: ipAddress.IP.Mask(ipAddress.Mask), Mask: ipAddress.Mask}).String()
+	apInfo, err := plugin.am.GetPoolInfo(nwCfg.Ipam.AddrSpace, poolID)
 	if err != nil {
 		err = plugin.Errorf("Failed to get pool information: %v", err)
 		return err
 	}
 
-	// Populate result.
+	// Populate result for the address family of the pool.
+	version := "4"
+	defaultRouteDstPrefix := ipv4DefaultRouteDstPrefix
+	if apInfo.IsIPv6 {
+		version = "6"
+		defaultRouteDstPrefix = ipv6DefaultRouteDstPrefix
+	}
+
 	result = &cniTypesCurr.Result{
 		IPs: []*cniTypesCurr.IPConfig{
 			{
-				Version: "4",
+				Version: version,
 				Address: *ipAddress,
 				Gateway: apInfo.Gateway,
 			},
 		},
 		Routes: []*cniTypes.Route{
 			{
-				Dst: ipv4DefaultRouteDstPrefix,
+				Dst: defaultRouteDstPrefix,
 				GW:  apInfo.Gateway,
 			},
 		},
@@ -231,11 +345,17 @@ func (plugin *ipamPlugin) Add(args *cniSkel.CmdArgs) error {
 		result.DNS.Nameservers = append(result.DNS.Nameservers, dnsServer.String())
 	}
 
+	err = plugin.writeResult(args, nwCfg, result)
+
+	return err
+}
+
+// writeResult converts the result to the requested CNI version and passes it back to the caller.
+func (plugin *ipamPlugin) writeResult(args *cniSkel.CmdArgs, nwCfg *cni.NetworkConfig, result *cniTypesCurr.Result) error {
 	// Convert result to the requested CNI version.
 	res, err := result.GetAsVersion(nwCfg.CNIVersion)
 	if err != nil {
-		err = plugin.Errorf("Failed to convert result: %v", err)
-		return err
+		return plugin.Errorf("Failed to convert result: %v", err)
 	}
 
 	// Output the result.
@@ -271,6 +391,18 @@ func (plugin *ipamPlugin) Delete(args *cniSkel.CmdArgs) error {
 		return err
 	}
 
+	// Pools are owned by CNS in delegated mode, so only address releases are forwarded.
+	// CNS tracks addresses by pod interface, so the address is released even if the caller doesn't pass it.
+	if nwCfg.Ipam.Environment == common.OptEnvironmentCNS {
+		err = plugin.releaseAddressToCNS(args, nwCfg)
+		if err != nil {
+			err = plugin.Errorf("Failed to release address to CNS: %v", err)
+			return err
+		}
+
+		return nil
+	}
+
 	// If an address is specified, release that address. Otherwise, release the pool.
 	if nwCfg.Ipam.Address != "" {
 		// Release the address.
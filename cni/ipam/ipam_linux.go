@@ -0,0 +1,14 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"os"
+)
+
+// isSandboxAlive returns whether the network namespace of a container still exists.
+func isSandboxAlive(netNs string) bool {
+	_, err := os.Stat(netNs)
+	return !os.IsNotExist(err)
+}
@@ -12,29 +12,77 @@ import (
 	"github.com/Azure/azure-container-networking/common"
 )
 
+const (
+	// Troubleshooting options.
+	optState               = "state"
+	optStateAlias          = "s"
+	optReleaseAddress      = "release-address"
+	optReleaseAddressAlias = "r"
+)
+
 // Version is populated by make during build.
 var version string
 
+// Command line arguments for CNI IPAM plugin.
+var args = common.ArgumentList{
+	{
+		Name:         common.OptVersion,
+		Shorthand:    common.OptVersionAlias,
+		Description:  "Print version information",
+		Type:         "bool",
+		DefaultValue: false,
+	},
+	{
+		Name:         optState,
+		Shorthand:    optStateAlias,
+		Description:  "Print address pools and the containers owning each address",
+		Type:         "bool",
+		DefaultValue: false,
+	},
+	{
+		Name:         optReleaseAddress,
+		Shorthand:    optReleaseAddressAlias,
+		Description:  "Force release the given address",
+		Type:         "string",
+		DefaultValue: "",
+	},
+}
+
+// Prints version information.
+func printVersion() {
+	fmt.Printf("Azure CNI IPAM Version %v\n", version)
+}
+
 // Main is the entry point for CNI IPAM plugin.
 func main() {
 	var config common.PluginConfig
 	config.Version = version
 
+	// Initialize and parse command line arguments.
+	common.ParseArgs(&args, printVersion)
+	vers := common.GetArg(common.OptVersion).(bool)
+	showState := common.GetArg(optState).(bool)
+	releaseAddress := common.GetArg(optReleaseAddress).(string)
+
+	if vers {
+		printVersion()
+		os.Exit(0)
+	}
+
 	ipamPlugin, err := ipam.NewPlugin(&config)
 	if err != nil {
 		fmt.Printf("Failed to create IPAM plugin, err:%v.\n", err)
 		os.Exit(1)
 	}
 
-	if err := ipamPlugin.Plugin.InitializeKeyValueStore(&config); err != nil {
+	// The address manager locks the pools it updates, so requests for different pools don't wait for each other.
+	if err := ipamPlugin.Plugin.OpenKeyValueStore(&config); err != nil {
 		fmt.Printf("Failed to initialize key-value store of ipam plugin, err:%v.\n", err)
 		os.Exit(1)
 	}
 
 	defer func() {
-		if errUninit := ipamPlugin.Plugin.UninitializeKeyValueStore(); errUninit != nil {
-			fmt.Printf("Failed to uninitialize key-value store of ipam plugin, err:%v.\n", err)
-		}
+		ipamPlugin.Plugin.CloseKeyValueStore()
 
 		if recover() != nil {
 			os.Exit(1)
@@ -47,7 +95,19 @@ func main() {
 		panic("ipam plugin fatal error")
 	}
 
-	err = ipamPlugin.Execute(cni.PluginApi(ipamPlugin))
+	// Handle troubleshooting commands instead of a CNI command if requested.
+	if releaseAddress != "" {
+		err = ipamPlugin.ForceReleaseAddress(releaseAddress)
+		if err != nil {
+			fmt.Printf("Failed to release address %v, err:%v.\n", releaseAddress, err)
+		} else {
+			fmt.Printf("Released address %v.\n", releaseAddress)
+		}
+	} else if showState {
+		err = ipamPlugin.PrintState(os.Stdout)
+	} else {
+		err = ipamPlugin.Execute(cni.PluginApi(ipamPlugin))
+	}
 
 	ipamPlugin.Stop()
 
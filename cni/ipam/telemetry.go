@@ -0,0 +1,52 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/ipam"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/telemetry"
+)
+
+const (
+	hostNetAgentURL = "http://169.254.169.254/machine/plugins?comp=netagent&type=cnireport"
+)
+
+// reportPoolExhausted sends a structured telemetry event describing an exhausted address pool.
+func (plugin *ipamPlugin) reportPoolExhausted(exhaustedErr *ipam.PoolExhaustedError) {
+	// There is no host agent to report to in the static environment.
+	if plugin.GetOption(common.OptEnvironment) == common.OptEnvironmentStatic {
+		log.Printf("[cni-ipam] %v.", exhaustedErr)
+		return
+	}
+
+	report := &telemetry.IPAMReport{
+		EventMessage: exhaustedErr.Error(),
+		AddressSpace: exhaustedErr.AddressSpace,
+		PoolId:       exhaustedErr.PoolId,
+		Capacity:     exhaustedErr.Capacity,
+		Allocated:    exhaustedErr.Allocated,
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+	}
+
+	for _, consumer := range exhaustedErr.TopConsumers {
+		report.TopConsumers = append(report.TopConsumers, fmt.Sprintf("%v:%v", consumer.Owner, consumer.Addresses))
+	}
+
+	reportManager := &telemetry.ReportManager{
+		HostNetAgentURL: hostNetAgentURL,
+		ContentType:     telemetry.ContentType,
+		Report:          report,
+	}
+
+	reportManager.GetHostMetadata()
+
+	if err := reportManager.SendReport(); err != nil {
+		log.Printf("[cni-ipam] Failed to send pool exhaustion report, err:%v.", err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"strings"
 
+	"github.com/Azure/azure-container-networking/cns"
 	"github.com/Azure/azure-container-networking/network/policy"
 
 	cniTypes "github.com/containernetworking/cni/pkg/types"
@@ -29,8 +30,19 @@ type PortMapping struct {
 	HostIp        string `json:"hostIP,omitempty"`
 }
 
+// GatewayCheck configures the check that the gateway of the network responds before endpoints are added.
+type GatewayCheck struct {
+	Enable    bool `json:"enable"`
+	Retries   int  `json:"retries,omitempty"`
+	TimeoutMs int  `json:"timeoutMs,omitempty"`
+}
+
 type RuntimeConfig struct {
 	PortMappings []PortMapping `json:"portMappings,omitempty"`
+
+	// Network container of the pod, injected by the runtime through the networkContainer capability
+	// so that ADD doesn't query CNS for it.
+	NetworkContainer *cns.GetNetworkContainerResponse `json:"networkContainer,omitempty"`
 }
 
 // NetworkConfig represents Azure CNI plugin network configuration.
@@ -39,6 +51,10 @@ type NetworkConfig struct {
 	Name                       string   `json:"name"`
 	Type                       string   `json:"type"`
 	Mode                       string   `json:"mode"`
+	NetworkType                string   `json:"networkType,omitempty"`
+	VxlanId                    int      `json:"vxlanId,omitempty"`
+	OverlayEncap               string   `json:"overlayEncap,omitempty"`
+	GeneveTenantId             string   `json:"geneveTenantId,omitempty"`
 	Master                     string   `json:"master"`
 	Bridge                     string   `json:"bridge,omitempty"`
 	LogLevel                   string   `json:"logLevel,omitempty"`
@@ -47,18 +63,36 @@ type NetworkConfig struct {
 	PodNamespaceForDualNetwork []string `json:"podNamespaceForDualNetwork,omitempty"`
 	MultiTenancy               bool     `json:"multiTenancy,omitempty"`
 	EnableSnatOnHost           bool     `json:"enableSnatOnHost,omitempty"`
+	SnatBridgeName             string   `json:"snatBridgeName,omitempty"`
+	SnatBridgeSubnet           string   `json:"snatBridgeSubnet,omitempty"`
+	SnatTraffic                []string `json:"snatTraffic,omitempty"`
+	MasqueradeExclusions       []string `json:"masqueradeExclusions,omitempty"`
+	EnableVrfIsolation         bool     `json:"enableVrfIsolation,omitempty"`
+	EnableConntrack            bool     `json:"enableConntrack,omitempty"`
+	EnableHardwareOffload      bool     `json:"enableHardwareOffload,omitempty"`
+	BridgeDatapath             string   `json:"bridgeDatapath,omitempty"`
 	EnableExactMatchForPodName bool     `json:"enableExactMatchForPodName,omitempty"`
 	CNSUrl                     string   `json:"cnsurl,omitempty"`
+	OutboundNatExceptions      []string `json:"outboundNatExceptions,omitempty"`
+	EnableLoopbackDSR          bool     `json:"enableLoopbackDSR,omitempty"`
+	ChainingMode               string   `json:"chainingMode,omitempty"`
 	Ipam                       struct {
-		Type          string `json:"type"`
-		Environment   string `json:"environment,omitempty"`
-		AddrSpace     string `json:"addressSpace,omitempty"`
-		Subnet        string `json:"subnet,omitempty"`
-		Address       string `json:"ipAddress,omitempty"`
-		QueryInterval string `json:"queryInterval,omitempty"`
+		Type          string   `json:"type"`
+		Environment   string   `json:"environment,omitempty"`
+		AddrSpace     string   `json:"addressSpace,omitempty"`
+		Subnet        string   `json:"subnet,omitempty"`
+		Gateway       string   `json:"gateway,omitempty"`
+		RangeStart    string   `json:"rangeStart,omitempty"`
+		RangeEnd      string   `json:"rangeEnd,omitempty"`
+		Address       string   `json:"ipAddress,omitempty"`
+		QueryInterval string   `json:"queryInterval,omitempty"`
+		Store         string   `json:"store,omitempty"`
+		IPv6          bool     `json:"ipv6,omitempty"`
+		Exclude       []string `json:"exclude,omitempty"`
 	}
 	DNS            cniTypes.DNS  `json:"dns"`
 	RuntimeConfig  RuntimeConfig `json:"runtimeConfig"`
+	GatewayCheck   *GatewayCheck `json:"gatewayCheck,omitempty"`
 	AdditionalArgs []KVPair
 }
 
@@ -67,6 +101,8 @@ type K8SPodEnvArgs struct {
 	K8S_POD_NAMESPACE          cniTypes.UnmarshallableString `json:"K8S_POD_NAMESPACE,omitempty"`
 	K8S_POD_NAME               cniTypes.UnmarshallableString `json:"K8S_POD_NAME,omitempty"`
 	K8S_POD_INFRA_CONTAINER_ID cniTypes.UnmarshallableString `json:"K8S_POD_INFRA_CONTAINER_ID,omitempty"`
+	TRACEPARENT                cniTypes.UnmarshallableString `json:"TRACEPARENT,omitempty"`
+	EGRESS_IP                  cniTypes.UnmarshallableString `json:"EGRESS_IP,omitempty"`
 }
 
 // ParseCniArgs unmarshals cni arguments.
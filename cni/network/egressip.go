@@ -0,0 +1,74 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/Azure/azure-container-networking/cni"
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/cnsclient"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/trace"
+	cniSkel "github.com/containernetworking/cni/pkg/skel"
+)
+
+// Error returned when a pod requests an egress address from a CNS too old to reserve it.
+var errEgressIPNotSupported = errors.New("CNS does not support egress IPs, upgrade CNS to use them")
+
+// getPodInterfaceID returns the ID under which CNS tracks the egress address of a pod interface.
+func getPodInterfaceID(args *cniSkel.CmdArgs) string {
+	return fmt.Sprintf("%v-%v", args.ContainerID, args.IfName)
+}
+
+// reserveEgressIP reserves the egress address a pod requests in its CNI args, if any, with CNS,
+// which checks that it's a secondary address of the node no other pod uses.
+func reserveEgressIP(nwCfg *cni.NetworkConfig, args *cniSkel.CmdArgs, spanContext trace.SpanContext) (net.IP, error) {
+	podCfg, err := cni.ParseCniArgs(args.Args)
+	if err != nil || podCfg.EGRESS_IP == "" {
+		return nil, nil
+	}
+
+	egressIP := net.ParseIP(string(podCfg.EGRESS_IP))
+	if egressIP == nil || egressIP.To4() == nil {
+		return nil, fmt.Errorf("Invalid egress IP %q", podCfg.EGRESS_IP)
+	}
+
+	cnsClient, err := cnsclient.NewCnsClient(nwCfg.CNSUrl)
+	if err != nil {
+		log.Printf("Initializing CNS client error %v", err)
+		return nil, err
+	}
+
+	cnsClient.SetSpanContext(spanContext)
+
+	supported, err := cnsClient.SupportsFeature(cns.FeatureEgressIP)
+	if err != nil {
+		return nil, err
+	}
+
+	if !supported {
+		return nil, errEgressIPNotSupported
+	}
+
+	log.Printf("[cni-net] Reserving egress IP %v for %v.", egressIP, getPodInterfaceID(args))
+	if err = cnsClient.ReserveEgressIP(getPodInterfaceID(args), egressIP.String()); err != nil {
+		return nil, err
+	}
+
+	return egressIP, nil
+}
+
+// releaseEgressIP releases the egress address of a pod interface.
+func releaseEgressIP(nwCfg *cni.NetworkConfig, args *cniSkel.CmdArgs, spanContext trace.SpanContext) error {
+	cnsClient, err := cnsclient.NewCnsClient(nwCfg.CNSUrl)
+	if err != nil {
+		log.Printf("Initializing CNS client error %v", err)
+		return err
+	}
+
+	cnsClient.SetSpanContext(spanContext)
+
+	log.Printf("[cni-net] Releasing egress IP of %v.", getPodInterfaceID(args))
+	return cnsClient.ReleaseEgressIP(getPodInterfaceID(args))
+}
@@ -0,0 +1,29 @@
+package network
+
+import (
+	"fmt"
+	"net"
+)
+
+const (
+	// Retries of the gateway check if the network configuration doesn't set them.
+	defaultGatewayCheckRetries = 3
+
+	// Time the gateway has to respond to each attempt if the network configuration doesn't set it.
+	defaultGatewayCheckTimeoutMs = 1000
+)
+
+// GatewayUnreachableError is a gateway that didn't respond to the gateway check.
+type GatewayUnreachableError struct {
+	Gateway  net.IP
+	Attempts int
+	Err      error
+}
+
+func (e *GatewayUnreachableError) Error() string {
+	return fmt.Sprintf("Gateway %v is unreachable after %d attempts: %v", e.Gateway, e.Attempts, e.Err)
+}
+
+func (e *GatewayUnreachableError) Unwrap() error {
+	return e.Err
+}
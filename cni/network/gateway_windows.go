@@ -0,0 +1,69 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cni"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/platform"
+	"github.com/Azure/azure-container-networking/retry"
+)
+
+// checkGateway checks that the gateway of an endpoint responds, if the network configuration asks to.
+// A gateway responds if it answers a ping, or answers the ARP request of the ping when it drops ICMP.
+func checkGateway(nwCfg *cni.NetworkConfig, gateway net.IP) error {
+	check := nwCfg.GatewayCheck
+	if check == nil || !check.Enable || gateway == nil {
+		return nil
+	}
+
+	retries := check.Retries
+	if retries <= 0 {
+		retries = defaultGatewayCheckRetries
+	}
+
+	timeoutMs := check.TimeoutMs
+	if timeoutMs <= 0 {
+		timeoutMs = defaultGatewayCheckTimeoutMs
+	}
+
+	policy := &retry.Policy{
+		MaxAttempts:  retries + 1,
+		InitialDelay: time.Duration(timeoutMs) * time.Millisecond,
+		Multiplier:   1,
+	}
+
+	attempts := 0
+	err := retry.Do(context.Background(), policy, func() error {
+		attempts++
+		return pingGateway(gateway, timeoutMs)
+	})
+	if err != nil {
+		return &GatewayUnreachableError{Gateway: gateway, Attempts: attempts, Err: err}
+	}
+
+	log.Printf("[cni-net] Gateway %v responded after %d attempts.", gateway, attempts)
+
+	return nil
+}
+
+// pingGateway pings a gateway once, and looks it up in the ARP table if it doesn't answer.
+func pingGateway(gateway net.IP, timeoutMs int) error {
+	out, err := platform.ExecuteCommandContext(context.Background(), nil, "ping", "-n", "1", "-w", strconv.Itoa(timeoutMs), gateway.String())
+	// Ping exits successfully on replies of other hosts saying the gateway is unreachable.
+	if err == nil && strings.Contains(out.Stdout, "TTL=") {
+		return nil
+	}
+
+	out, err = platform.ExecuteCommandContext(context.Background(), nil, "arp", "-a", gateway.String())
+	if err == nil && strings.Contains(out.Stdout, gateway.String()) {
+		return nil
+	}
+
+	return fmt.Errorf("No reply to ping or ARP request")
+}
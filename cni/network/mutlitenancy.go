@@ -13,6 +13,7 @@ import (
 	"github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/network"
+	"github.com/Azure/azure-container-networking/trace"
 	cniTypes "github.com/containernetworking/cni/pkg/types"
 	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
 )
@@ -26,7 +27,7 @@ func SetupRoutingForMultitenancy(
 	// Adding default gateway
 	if nwCfg.MultiTenancy {
 		// if snat enabled, add 169.254.0.1 as default gateway
-		if nwCfg.EnableSnatOnHost {
+		if nwCfg.EnableSnatOnHost && network.IsSnatTrafficEnabled(nwCfg.SnatTraffic, network.SnatTrafficInternet) {
 			log.Printf("add default route for multitenancy.snat on host enabled")
 			addDefaultRoute(cnsNetworkConfig.LocalIPConfiguration.GatewayIPAddress, epInfo, result)
 		} else {
@@ -35,18 +36,85 @@ func SetupRoutingForMultitenancy(
 			gwIP := net.ParseIP(cnsNetworkConfig.IPConfiguration.GatewayIPAddress)
 			epInfo.Routes = append(epInfo.Routes, network.RouteInfo{Dst: dstIP, Gw: gwIP})
 			result.Routes = append(result.Routes, &cniTypes.Route{Dst: dstIP, GW: gwIP})
+
+			// Infra and host traffic can still be SNATed when internet traffic isn't.
+			if nwCfg.EnableSnatOnHost {
+				addSnatRoutes(nwCfg, cnsNetworkConfig, epInfo, result)
+			}
 		}
 
 		setupInfraVnetRoutingForMultitenancy(nwCfg, azIpamResult, epInfo, result)
 	}
 }
 
+// releaseContainerNetworkConfiguration releases the delegated NIC network container CNS reserved
+// for a pod, if any. An older CNS can't have reserved one.
+func releaseContainerNetworkConfiguration(
+	nwCfg *cni.NetworkConfig,
+	podName string,
+	podNamespace string,
+	spanContext trace.SpanContext) error {
+	if !nwCfg.EnableExactMatchForPodName {
+		podName = getPodNameWithoutSuffix(podName)
+	}
+
+	cnsClient, err := cnsclient.NewCnsClient(nwCfg.CNSUrl)
+	if err != nil {
+		log.Printf("Initializing CNS client error %v", err)
+		return err
+	}
+
+	cnsClient.SetSpanContext(spanContext)
+
+	supported, err := cnsClient.SupportsFeature(cns.FeatureDelegatedNIC)
+	if err != nil || !supported {
+		return err
+	}
+
+	podInfo := cns.KubernetesPodInfo{PodName: podName, PodNamespace: podNamespace}
+	orchestratorContext, err := json.Marshal(podInfo)
+	if err != nil {
+		log.Printf("Marshalling KubernetesPodInfo failed with %v", err)
+		return err
+	}
+
+	return cnsClient.ReleaseNetworkContainer(orchestratorContext)
+}
+
+// setAttachedNIC moves the host NIC of an attached NIC network container into the pod instead of a
+// veth pair, and reports its MAC address in the result.
+func setAttachedNIC(
+	cnsNetworkConfig *cns.GetNetworkContainerResponse,
+	epInfo *network.EndpointInfo,
+	result *cniTypesCurr.Result) error {
+	if cnsNetworkConfig == nil || cnsNetworkConfig.NetworkContainerType != cns.AttachedNIC {
+		return nil
+	}
+
+	mac, err := net.ParseMAC(cnsNetworkConfig.MACAddress)
+	if err != nil {
+		return fmt.Errorf("Invalid MAC address %q of network container: %v", cnsNetworkConfig.MACAddress, err)
+	}
+
+	log.Printf("Attaching NIC %v to the pod", mac)
+	epInfo.NICMacAddress = mac
+
+	for _, iface := range result.Interfaces {
+		if iface.Name == epInfo.IfName {
+			iface.Mac = mac.String()
+		}
+	}
+
+	return nil
+}
+
 func getContainerNetworkConfiguration(
 	nwCfg *cni.NetworkConfig,
 	address string,
 	podName string,
 	podNamespace string,
-	ifName string) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, error) {
+	ifName string,
+	spanContext trace.SpanContext) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, error) {
 	var podNameWithoutSuffix string
 
 	if !nwCfg.EnableExactMatchForPodName {
@@ -55,21 +123,30 @@ func getContainerNetworkConfiguration(
 		podNameWithoutSuffix = podName
 	}
 
+	// The runtime passes the network container of the pod when CNS injects it at scheduling time.
+	if nwCfg.RuntimeConfig.NetworkContainer != nil {
+		log.Printf("Using network container of pod %v from runtime config", podNameWithoutSuffix)
+		return getContainerNetworkConfigurationFromResponse(nwCfg.RuntimeConfig.NetworkContainer, ifName)
+	}
+
 	log.Printf("Podname without suffix %v", podNameWithoutSuffix)
-	return getContainerNetworkConfigurationInternal(address, podNamespace, podNameWithoutSuffix, ifName)
+	return getContainerNetworkConfigurationInternal(address, podNamespace, podNameWithoutSuffix, ifName, spanContext)
 }
 
 func getContainerNetworkConfigurationInternal(
 	address string,
 	namespace string,
 	podName string,
-	ifName string) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, error) {
+	ifName string,
+	spanContext trace.SpanContext) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, error) {
 	cnsClient, err := cnsclient.NewCnsClient(address)
 	if err != nil {
 		log.Printf("Initializing CNS client error %v", err)
 		return nil, nil, net.IPNet{}, err
 	}
 
+	cnsClient.SetSpanContext(spanContext)
+
 	podInfo := cns.KubernetesPodInfo{PodName: podName, PodNamespace: namespace}
 	orchestratorContext, err := json.Marshal(podInfo)
 	if err != nil {
@@ -85,6 +162,24 @@ func getContainerNetworkConfigurationInternal(
 
 	log.Printf("Network config received from cns %+v", networkConfig)
 
+	return getContainerNetworkConfigurationFromResponse(networkConfig, ifName)
+}
+
+// getContainerNetworkConfigurationFromResponse returns the CNI result of a network container, and the
+// subnet of the host interface it's reached through.
+func getContainerNetworkConfigurationFromResponse(
+	networkConfig *cns.GetNetworkContainerResponse,
+	ifName string) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, error) {
+	if networkConfig.Response.ReturnCode != 0 {
+		return nil, nil, net.IPNet{}, errors.New(networkConfig.Response.Message)
+	}
+
+	if net.ParseIP(networkConfig.IPConfiguration.IPSubnet.IPAddress) == nil {
+		errBuf := fmt.Sprintf("Invalid IP address %q of network container", networkConfig.IPConfiguration.IPSubnet.IPAddress)
+		log.Printf("%s", errBuf)
+		return nil, nil, net.IPNet{}, errors.New(errBuf)
+	}
+
 	subnetPrefix := common.GetInterfaceSubnetWithSpecificIp(networkConfig.PrimaryInterfaceIdentifier)
 	if subnetPrefix == nil {
 		errBuf := fmt.Sprintf("Interface not found for this ip %v", networkConfig.PrimaryInterfaceIdentifier)
@@ -212,10 +307,11 @@ func GetMultiTenancyCNIResult(
 	plugin *netPlugin,
 	k8sPodName string,
 	k8sNamespace string,
-	ifName string) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, *cniTypesCurr.Result, error) {
+	ifName string,
+	spanContext trace.SpanContext) (*cniTypesCurr.Result, *cns.GetNetworkContainerResponse, net.IPNet, *cniTypesCurr.Result, error) {
 
 	if nwCfg.MultiTenancy {
-		result, cnsNetworkConfig, subnetPrefix, err := getContainerNetworkConfiguration(nwCfg, nwCfg.CNSUrl, k8sPodName, k8sNamespace, ifName)
+		result, cnsNetworkConfig, subnetPrefix, err := getContainerNetworkConfiguration(nwCfg, nwCfg.CNSUrl, k8sPodName, k8sNamespace, ifName, spanContext)
 		if err != nil {
 			log.Printf("GetContainerNetworkConfiguration failed for podname %v namespace %v with error %v", k8sPodName, k8sNamespace, err)
 			return nil, nil, net.IPNet{}, nil, err
@@ -223,6 +319,11 @@ func GetMultiTenancyCNIResult(
 
 		log.Printf("PrimaryInterfaceIdentifier :%v", subnetPrefix.IP.String())
 
+		if err = validateMultiTenancyInfo(cnsNetworkConfig.MultiTenancyInfo); err != nil {
+			log.Printf("Invalid multitenancy info %+v: %v", cnsNetworkConfig.MultiTenancyInfo, err)
+			return nil, nil, net.IPNet{}, nil, err
+		}
+
 		if checkIfSubnetOverlaps(enableInfraVnet, nwCfg, cnsNetworkConfig) {
 			buf := fmt.Sprintf("InfraVnet %v overlaps with customerVnet %+v", nwCfg.InfraVnetAddressSpace, cnsNetworkConfig.CnetAddressSpace)
 			log.Printf(buf)
@@ -235,6 +336,11 @@ func GetMultiTenancyCNIResult(
 				log.Printf("Snat IP is not populated. Got empty string")
 				return nil, nil, net.IPNet{}, nil, fmt.Errorf("Snat IP is not populated. Got empty string")
 			}
+
+			if err = setSnatBridgeSubnet(nwCfg, cnsNetworkConfig); err != nil {
+				log.Printf("Failed to set SNAT bridge subnet %v: %v", nwCfg.SnatBridgeSubnet, err)
+				return nil, nil, net.IPNet{}, nil, err
+			}
 		}
 
 		if enableInfraVnet {
@@ -256,6 +362,81 @@ func GetMultiTenancyCNIResult(
 	return nil, nil, net.IPNet{}, nil, nil
 }
 
+// validateMultiTenancyInfo checks that the network container of a pod is isolated by a VLAN ID the
+// endpoint can be tagged with. Network containers without an ID are not isolated.
+func validateMultiTenancyInfo(info cns.MultiTenancyInfo) error {
+	if info.ID == 0 {
+		return nil
+	}
+
+	if info.EncapType != "" && !strings.EqualFold(info.EncapType, cns.Vlan) {
+		return fmt.Errorf("Unsupported encapsulation type %s of network container", info.EncapType)
+	}
+
+	if info.ID < minVlanID || info.ID > maxVlanID {
+		return fmt.Errorf("VLAN ID %d of network container is not between %d and %d", info.ID, minVlanID, maxVlanID)
+	}
+
+	return nil
+}
+
+// setSnatBridgeSubnet moves the SNAT address and gateway CNS returned for a pod into the SNAT bridge
+// subnet of the network configuration, keeping their host part.
+func setSnatBridgeSubnet(nwCfg *cni.NetworkConfig, cnsNetworkConfig *cns.GetNetworkContainerResponse) error {
+	if nwCfg.SnatBridgeSubnet == "" {
+		return nil
+	}
+
+	_, subnet, err := net.ParseCIDR(nwCfg.SnatBridgeSubnet)
+	if err != nil || subnet.IP.To4() == nil {
+		return fmt.Errorf("Invalid SNAT bridge subnet %v", nwCfg.SnatBridgeSubnet)
+	}
+
+	localIPConfig := &cnsNetworkConfig.LocalIPConfiguration
+	mask := net.CIDRMask(int(localIPConfig.IPSubnet.PrefixLength), 32)
+
+	remap := func(address string) (string, error) {
+		ip := net.ParseIP(address).To4()
+		if ip == nil {
+			return "", fmt.Errorf("Invalid SNAT address %v", address)
+		}
+
+		remapped := make(net.IP, net.IPv4len)
+		for i := range remapped {
+			host := ip[i] &^ mask[i]
+			if host&subnet.Mask[i] != 0 {
+				return "", fmt.Errorf("SNAT address %v/%d does not fit in subnet %v",
+					address, localIPConfig.IPSubnet.PrefixLength, subnet)
+			}
+			remapped[i] = subnet.IP[i] | host
+		}
+
+		return remapped.String(), nil
+	}
+
+	ipAddress, err := remap(localIPConfig.IPSubnet.IPAddress)
+	if err != nil {
+		return err
+	}
+
+	gateway, err := remap(localIPConfig.GatewayIPAddress)
+	if err != nil {
+		return err
+	}
+
+	prefixLength, _ := subnet.Mask.Size()
+
+	log.Printf("Moving SNAT address %v/%d gateway %v to %v/%d gateway %v",
+		localIPConfig.IPSubnet.IPAddress, localIPConfig.IPSubnet.PrefixLength, localIPConfig.GatewayIPAddress,
+		ipAddress, prefixLength, gateway)
+
+	localIPConfig.IPSubnet.IPAddress = ipAddress
+	localIPConfig.IPSubnet.PrefixLength = uint8(prefixLength)
+	localIPConfig.GatewayIPAddress = gateway
+
+	return nil
+}
+
 func CleanupMultitenancyResources(enableInfraVnet bool, nwCfg *cni.NetworkConfig, azIpamResult *cniTypesCurr.Result, plugin *netPlugin) {
 	if nwCfg.MultiTenancy && azIpamResult != nil && azIpamResult.IPs != nil {
 		cleanupInfraVnetIP(enableInfraVnet, &azIpamResult.IPs[0].Address, nwCfg, plugin)
@@ -7,6 +7,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"os"
+	"time"
 
 	"github.com/Azure/azure-container-networking/cni"
 	"github.com/Azure/azure-container-networking/cns"
@@ -16,6 +18,7 @@ import (
 	"github.com/Azure/azure-container-networking/network"
 	"github.com/Azure/azure-container-networking/platform"
 	"github.com/Azure/azure-container-networking/telemetry"
+	"github.com/Azure/azure-container-networking/trace"
 	cniSkel "github.com/containernetworking/cni/pkg/skel"
 	cniTypes "github.com/containernetworking/cni/pkg/types"
 	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
@@ -26,8 +29,15 @@ const (
 	name                = "azure-vnet"
 	dockerNetworkOption = "com.docker.network.generic"
 	opModeTransparent   = "transparent"
+	// Chaining mode where cilium-cni runs after azure-vnet.
+	chainingModeCilium = "cilium"
 	// Supported IP version. Currently support only IPv4
 	ipVersion = "4"
+	// Minimum interval between stale endpoint collections.
+	staleEndpointGCInterval = 10 * time.Minute
+	// Range of the VLAN IDs endpoints of network containers are tagged with.
+	minVlanID = 1
+	maxVlanID = 4094
 )
 
 // NetPlugin represents the CNI network plugin.
@@ -84,6 +94,13 @@ func (plugin *netPlugin) Start(config *common.PluginConfig) error {
 		return err
 	}
 
+	// Delete the endpoints of containers removed without a CNI DEL.
+	if count, err := plugin.nm.GarbageCollectEndpoints(staleEndpointGCInterval); err != nil {
+		log.Printf("[cni-net] Failed to delete stale endpoints, err:%v.", err)
+	} else if count > 0 && plugin.reportManager != nil {
+		plugin.reportManager.Report.(*telemetry.CNIReport).StaleEndpointCount = count
+	}
+
 	log.Printf("[cni-net] Plugin started.")
 
 	return nil
@@ -161,6 +178,24 @@ func (plugin *netPlugin) getPodInfo(args string) (string, string, error) {
 // https://github.com/containernetworking/cni/blob/master/SPEC.md
 //
 
+// startSpan starts the span of a CNI command, as part of the span passed by the runtime in
+// the TRACEPARENT CNI argument or environment variable, if any.
+func startSpan(command string, args *cniSkel.CmdArgs) *trace.Span {
+	parent := trace.FromEnvironment()
+	if podCfg, err := cni.ParseCniArgs(args.Args); err == nil && podCfg.TRACEPARENT != "" {
+		if sc, err := trace.ParseTraceParent(string(podCfg.TRACEPARENT)); err == nil {
+			parent = sc
+		}
+	}
+
+	span := trace.StartSpan("cni."+command, parent)
+	span.SetAttribute("cni.containerid", args.ContainerID)
+	span.SetAttribute("cni.netns", args.Netns)
+	span.SetAttribute("cni.ifname", args.IfName)
+
+	return span
+}
+
 // Add handles CNI add commands.
 func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 	var (
@@ -179,6 +214,9 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 	log.Printf("[cni-net] Processing ADD command with args {ContainerID:%v Netns:%v IfName:%v Args:%v Path:%v}.",
 		args.ContainerID, args.Netns, args.IfName, args.Args, args.Path)
 
+	span := startSpan(cni.CmdAdd, args)
+	defer func() { span.End(err) }()
+
 	// Parse network configuration from stdin.
 	nwCfg, err = cni.ParseNetworkConfig(args.StdinData)
 	if err != nil {
@@ -194,11 +232,13 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 			result = &cniTypesCurr.Result{}
 		}
 
-		iface = &cniTypesCurr.Interface{
-			Name: args.IfName,
-		}
+		if !hasInterface(result, args.IfName) {
+			iface = &cniTypesCurr.Interface{
+				Name: args.IfName,
+			}
 
-		result.Interfaces = append(result.Interfaces, iface)
+			result.Interfaces = append(result.Interfaces, iface)
+		}
 
 		addSnatInterface(nwCfg, result)
 
@@ -217,6 +257,37 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 		log.Printf("[cni-net] ADD command completed with result:%+v err:%v.", result, err)
 	}()
 
+	if err = validateChainingMode(nwCfg); err != nil {
+		err = plugin.Errorf("%v", err)
+		return err
+	}
+
+	if err = validateSnatOptions(nwCfg); err != nil {
+		err = plugin.Errorf("%v", err)
+		return err
+	}
+
+	if err = validateNetworkType(nwCfg); err != nil {
+		err = plugin.Errorf("%v", err)
+		return err
+	}
+
+	if err = validateBridgeDatapath(nwCfg); err != nil {
+		err = plugin.Errorf("%v", err)
+		return err
+	}
+
+	if err = validateHardwareOffload(nwCfg); err != nil {
+		err = plugin.Errorf("%v", err)
+		return err
+	}
+
+	// Fail before changing the host if it lacks kernel features the network needs.
+	if err = platform.CheckKernelFeatures(getRequiredKernelFeatures(nwCfg)...); err != nil {
+		err = plugin.Errorf("%v", err)
+		return err
+	}
+
 	// Parse Pod arguments.
 	k8sPodName, k8sNamespace, err := plugin.getPodInfo(args.Args)
 	if err != nil {
@@ -245,7 +316,7 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 		}
 	}
 
-	result, cnsNetworkConfig, subnetPrefix, azIpamResult, err = GetMultiTenancyCNIResult(enableInfraVnet, nwCfg, plugin, k8sPodName, k8sNamespace, args.IfName)
+	result, cnsNetworkConfig, subnetPrefix, azIpamResult, err = GetMultiTenancyCNIResult(enableInfraVnet, nwCfg, plugin, k8sPodName, k8sNamespace, args.IfName, span.Context)
 	if err != nil {
 		log.Printf("GetMultiTenancyCNIResult failed with error %v", err)
 		return err
@@ -260,12 +331,19 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 	log.Printf("Result from multitenancy %+v", result)
 
 	// Initialize values from network config.
-	networkId, err := getNetworkName(k8sPodName, k8sNamespace, args.IfName, nwCfg)
+	networkId, err := getNetworkName(k8sPodName, k8sNamespace, args.IfName, nwCfg, span.Context)
 	if err != nil {
 		log.Printf("[cni-net] Failed to extract network name from network config. error: %v", err)
 		return err
 	}
 
+	// Invocations for other networks proceed meanwhile.
+	if err = plugin.nm.LockNetwork(networkId); err != nil {
+		log.Printf("[cni-net] Failed to lock network %v, err:%v.", networkId, err)
+		return err
+	}
+	defer plugin.nm.UnlockNetwork(networkId)
+
 	endpointId := GetEndpointID(args)
 
 	policies := cni.GetPoliciesFromNwCfg(nwCfg.AdditionalArgs)
@@ -360,6 +438,9 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 		nwInfo := network.NetworkInfo{
 			Id:           networkId,
 			Mode:         nwCfg.Mode,
+			NetworkType:  nwCfg.NetworkType,
+			VxlanId:      nwCfg.VxlanId,
+			OverlayEncap: nwCfg.OverlayEncap,
 			MasterIfName: masterIfName,
 			Subnets: []network.SubnetInfo{
 				network.SubnetInfo{
@@ -372,10 +453,15 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 			EnableSnatOnHost: nwCfg.EnableSnatOnHost,
 			DNS:              nwDNSInfo,
 			Policies:         policies,
+			GeneveTenantId:   nwCfg.GeneveTenantId,
+
+			MasqueradeExclusions:  nwCfg.MasqueradeExclusions,
+			EnableHardwareOffload: nwCfg.EnableHardwareOffload,
+			BridgeDatapath:        nwCfg.BridgeDatapath,
 		}
 
 		nwInfo.Options = make(map[string]interface{})
-		setNetworkOptions(cnsNetworkConfig, &nwInfo)
+		setNetworkOptions(nwCfg, cnsNetworkConfig, &nwInfo)
 
 		err = plugin.nm.CreateNetwork(&nwInfo)
 		if err != nil {
@@ -409,6 +495,13 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 					plugin.DelegateDel(nwCfg.Ipam.Type, nwCfg)
 				}
 			}()
+
+			// The address may come from a prefix added to the subnet after the network was created.
+			err = plugin.addNetworkSubnet(nwInfo, ipconfig)
+			if err != nil {
+				err = plugin.Errorf("Failed to add subnet to network: %v", err)
+				return err
+			}
 		}
 	}
 
@@ -418,6 +511,10 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 		return err
 	}
 
+	// Surface the search suffixes and resolver options of the endpoint in the result.
+	result.DNS.Search = epDNSInfo.Search
+	result.DNS.Options = epDNSInfo.Options
+
 	epInfo = &network.EndpointInfo{
 		Id:                 endpointId,
 		ContainerID:        args.ContainerID,
@@ -428,11 +525,17 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 		Policies:           policies,
 		EnableSnatOnHost:   nwCfg.EnableSnatOnHost,
 		EnableMultiTenancy: nwCfg.MultiTenancy,
+		EnableVrfIsolation: nwCfg.MultiTenancy && nwCfg.EnableVrfIsolation,
+		EnableConntrack:    nwCfg.EnableConntrack,
+		EnableLoopbackDSR:  nwCfg.EnableLoopbackDSR,
 		EnableInfraVnet:    enableInfraVnet,
 		PODName:            k8sPodName,
 		PODNameSpace:       k8sNamespace,
 	}
 
+	// Multitenancy pods use a VF of the host NIC whose OVS flows are offloaded.
+	epInfo.EnableHardwareOffload = nwCfg.MultiTenancy && nwCfg.EnableHardwareOffload
+
 	epPolicies := getPoliciesFromRuntimeCfg(nwCfg)
 	for _, epPolicy := range epPolicies {
 		epInfo.Policies = append(epInfo.Policies, epPolicy)
@@ -464,7 +567,45 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 		// (network name, container id, name of the interface inside the container)
 		vethName = fmt.Sprintf("%s%s%s", networkId, k8sContainerID, k8sIfName)
 	}
-	setEndpointOptions(cnsNetworkConfig, epInfo, vethName)
+	setEndpointOptions(nwCfg, cnsNetworkConfig, epInfo, vethName)
+
+	if err = setAttachedNIC(cnsNetworkConfig, epInfo, result); err != nil {
+		err = plugin.Errorf("Failed to attach NIC: %v", err)
+		return err
+	}
+
+	if err = setOutboundNatExceptions(nwCfg, epInfo); err != nil {
+		err = plugin.Errorf("Failed to set outbound NAT exceptions: %v", err)
+		return err
+	}
+
+	epInfo.EgressIP, err = reserveEgressIP(nwCfg, args, span.Context)
+	if err != nil {
+		err = plugin.Errorf("Failed to reserve egress IP: %v", err)
+		return err
+	}
+
+	// On failure, hand the egress address back to CNS.
+	if epInfo.EgressIP != nil {
+		defer func() {
+			if err != nil {
+				releaseEgressIP(nwCfg, args, span.Context)
+			}
+		}()
+	}
+
+	if err = plugin.updateOverlayVteps(nwCfg, networkId, span.Context); err != nil {
+		err = plugin.Errorf("Failed to update overlay VTEPs: %v", err)
+		return err
+	}
+
+	// Some networks intentionally have gateways that don't respond, so the check is optional.
+	if len(result.IPs) > 0 {
+		if err = checkGateway(nwCfg, result.IPs[0].Gateway); err != nil {
+			err = plugin.Error(&cniTypes.Error{Code: cni.ErrGatewayUnreachable, Msg: "Failed to check gateway", Details: err.Error()})
+			return err
+		}
+	}
 
 	// Create the endpoint.
 	log.Printf("[cni-net] Creating endpoint %v.", epInfo.Id)
@@ -474,9 +615,82 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 		return err
 	}
 
+	if nwCfg.ChainingMode != "" {
+		err = plugin.setChainedInterfaces(networkId, endpointId, args, result)
+		if err != nil {
+			err = plugin.Errorf("Failed to describe interfaces for chained plugins: %v", err)
+			return err
+		}
+	}
+
 	return nil
 }
 
+// hasInterface checks if the result describes an interface.
+func hasInterface(result *cniTypesCurr.Result, name string) bool {
+	for _, iface := range result.Interfaces {
+		if iface.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setChainedInterfaces describes the host and container interfaces of an endpoint in the result,
+// with the addresses of the container interface, as plugins chained after azure-vnet expect.
+// Cilium attaches its programs to the host interface of the veth pair found this way.
+func (plugin *netPlugin) setChainedInterfaces(networkId, endpointId string, args *cniSkel.CmdArgs, result *cniTypesCurr.Result) error {
+	epInfo, err := plugin.nm.GetEndpointInfo(networkId, endpointId)
+	if err != nil {
+		return err
+	}
+
+	hostIface := &cniTypesCurr.Interface{Name: epInfo.HostIfName}
+	if hostIf, err := net.InterfaceByName(epInfo.HostIfName); err == nil {
+		hostIface.Mac = hostIf.HardwareAddr.String()
+	}
+
+	containerIface := &cniTypesCurr.Interface{
+		Name:    args.IfName,
+		Mac:     epInfo.MacAddress.String(),
+		Sandbox: args.Netns,
+	}
+
+	result.Interfaces = []*cniTypesCurr.Interface{hostIface, containerIface}
+	for _, ipconfig := range result.IPs {
+		ipconfig.Interface = cniTypesCurr.Int(1)
+	}
+
+	return nil
+}
+
+// addNetworkSubnet adds the subnet of the given address to the network if it is not already part of it.
+func (plugin *netPlugin) addNetworkSubnet(nwInfo *network.NetworkInfo, ipconfig *cniTypesCurr.IPConfig) error {
+	for _, subnet := range nwInfo.Subnets {
+		if subnet.Prefix.Contains(ipconfig.Address.IP) {
+			return nil
+		}
+	}
+
+	subnet := network.SubnetInfo{
+		Family: platform.AfINET,
+		Prefix: net.IPNet{
+			IP:   ipconfig.Address.IP.Mask(ipconfig.Address.Mask),
+			Mask: ipconfig.Address.Mask,
+		},
+		Gateway: ipconfig.Gateway,
+	}
+
+	if ipconfig.Address.IP.To4() == nil {
+		subnet.Family = platform.AfINET6
+	}
+
+	log.Printf("[cni-net] Adding subnet %v to network %v.", subnet.Prefix.String(), nwInfo.Id)
+
+	return plugin.nm.AddNetworkSubnet(nwInfo.Id, &subnet)
+}
+
 // Get handles CNI Get commands.
 func (plugin *netPlugin) Get(args *cniSkel.CmdArgs) error {
 	var (
@@ -490,6 +704,9 @@ func (plugin *netPlugin) Get(args *cniSkel.CmdArgs) error {
 	log.Printf("[cni-net] Processing GET command with args {ContainerID:%v Netns:%v IfName:%v Args:%v Path:%v}.",
 		args.ContainerID, args.Netns, args.IfName, args.Args, args.Path)
 
+	span := startSpan(cni.CmdGet, args)
+	defer func() { span.End(err) }()
+
 	defer func() {
 		// Add Interfaces to result.
 		iface = &cniTypesCurr.Interface{
@@ -526,11 +743,17 @@ func (plugin *netPlugin) Get(args *cniSkel.CmdArgs) error {
 	}
 
 	// Initialize values from network config.
-	networkId, err := getNetworkName(k8sPodName, k8sNamespace, args.IfName, nwCfg)
+	networkId, err := getNetworkName(k8sPodName, k8sNamespace, args.IfName, nwCfg, span.Context)
 	if err != nil {
 		log.Printf("[cni-net] Failed to extract network name from network config. error: %v", err)
 	}
 
+	if err = plugin.nm.LockNetwork(networkId); err != nil {
+		log.Printf("[cni-net] Failed to lock network %v, err:%v.", networkId, err)
+		return err
+	}
+	defer plugin.nm.UnlockNetwork(networkId)
+
 	endpointId := GetEndpointID(args)
 
 	// Query the network.
@@ -547,6 +770,13 @@ func (plugin *netPlugin) Get(args *cniSkel.CmdArgs) error {
 		return err
 	}
 
+	setEndpointResult(epInfo, &result)
+
+	return nil
+}
+
+// setEndpointResult describes the addresses, routes and DNS settings of an endpoint in a result.
+func setEndpointResult(epInfo *network.EndpointInfo, result *cniTypesCurr.Result) {
 	for _, ipAddresses := range epInfo.IPAddresses {
 		ipConfig := &cniTypesCurr.IPConfig{
 			Version:   ipVersion,
@@ -567,8 +797,8 @@ func (plugin *netPlugin) Get(args *cniSkel.CmdArgs) error {
 
 	result.DNS.Nameservers = epInfo.DNS.Servers
 	result.DNS.Domain = epInfo.DNS.Suffix
-
-	return nil
+	result.DNS.Search = epInfo.DNS.Search
+	result.DNS.Options = epInfo.DNS.Options
 }
 
 // Delete handles CNI delete commands.
@@ -578,6 +808,9 @@ func (plugin *netPlugin) Delete(args *cniSkel.CmdArgs) error {
 	log.Printf("[cni-net] Processing DEL command with args {ContainerID:%v Netns:%v IfName:%v Args:%v Path:%v}.",
 		args.ContainerID, args.Netns, args.IfName, args.Args, args.Path)
 
+	span := startSpan(cni.CmdDel, args)
+	defer func() { span.End(err) }()
+
 	defer func() { log.Printf("[cni-net] DEL command completed with err:%v.", err) }()
 
 	// Parse network configuration from stdin.
@@ -596,11 +829,17 @@ func (plugin *netPlugin) Delete(args *cniSkel.CmdArgs) error {
 	}
 
 	// Initialize values from network config.
-	networkId, err := getNetworkName(k8sPodName, k8sNamespace, args.IfName, nwCfg)
+	networkId, err := getNetworkName(k8sPodName, k8sNamespace, args.IfName, nwCfg, span.Context)
 	if err != nil {
 		log.Printf("[cni-net] Failed to extract network name from network config. error: %v", err)
 	}
 
+	if err = plugin.nm.LockNetwork(networkId); err != nil {
+		log.Printf("[cni-net] Failed to lock network %v, err:%v.", networkId, err)
+		return err
+	}
+	defer plugin.nm.UnlockNetwork(networkId)
+
 	endpointId := GetEndpointID(args)
 
 	// Query the network.
@@ -628,6 +867,15 @@ func (plugin *netPlugin) Delete(args *cniSkel.CmdArgs) error {
 		return err
 	}
 
+	// Hand the egress address of the pod, if any, back to CNS.
+	if epInfo.EgressIP != nil {
+		err = releaseEgressIP(nwCfg, args, span.Context)
+		if err != nil {
+			err = plugin.Errorf("Failed to release egress IP: %v", err)
+			return err
+		}
+	}
+
 	if !nwCfg.MultiTenancy {
 		// Call into IPAM plugin to release the endpoint's addresses.
 		nwCfg.Ipam.Subnet = nwInfo.Subnets[0].Prefix.String()
@@ -639,12 +887,21 @@ func (plugin *netPlugin) Delete(args *cniSkel.CmdArgs) error {
 				return err
 			}
 		}
-	} else if epInfo.EnableInfraVnet {
-		nwCfg.Ipam.Subnet = nwInfo.Subnets[0].Prefix.String()
-		nwCfg.Ipam.Address = epInfo.InfraVnetIP.IP.String()
-		err = plugin.DelegateDel(nwCfg.Ipam.Type, nwCfg)
+	} else {
+		if epInfo.EnableInfraVnet {
+			nwCfg.Ipam.Subnet = nwInfo.Subnets[0].Prefix.String()
+			nwCfg.Ipam.Address = epInfo.InfraVnetIP.IP.String()
+			err = plugin.DelegateDel(nwCfg.Ipam.Type, nwCfg)
+			if err != nil {
+				err = plugin.Errorf("Failed to release address: %v", err)
+				return err
+			}
+		}
+
+		// Hand the delegated NIC of the pod, if any, back to CNS.
+		err = releaseContainerNetworkConfiguration(nwCfg, k8sPodName, k8sNamespace, span.Context)
 		if err != nil {
-			err = plugin.Errorf("Failed to release address: %v", err)
+			err = plugin.Errorf("Failed to release network container: %v", err)
 			return err
 		}
 	}
@@ -660,13 +917,41 @@ func (plugin *netPlugin) Update(args *cniSkel.CmdArgs) error {
 		err            error
 		nwCfg          *cni.NetworkConfig
 		existingEpInfo *network.EndpointInfo
+		list           *cni.NetworkConfigList
+		index          int
 	)
 
 	log.Printf("[cni-net] Processing UPDATE command with args {Netns:%v Args:%v Path:%v}.",
 		args.Netns, args.Args, args.Path)
 
+	span := startSpan(cni.CmdUpdate, args)
+	defer func() { span.End(err) }()
+
+	// Network configuration lists are passed whole, as the plugins chained after azure-vnet
+	// are reapplied after the update.
+	stdinData := args.StdinData
+	if cni.IsNetworkConfigList(stdinData) {
+		list, err = cni.ParseNetworkConfigList(stdinData)
+		if err != nil {
+			err = plugin.Errorf("Failed to parse network configuration list: %v.", err)
+			return err
+		}
+
+		index = list.FindPlugin(name)
+		if index < 0 {
+			err = plugin.Errorf("Network configuration list %s has no %s plugin.", list.Name, name)
+			return err
+		}
+
+		stdinData, err = list.GetPluginConfig(index, nil)
+		if err != nil {
+			err = plugin.Errorf("Failed to get network configuration: %v.", err)
+			return err
+		}
+	}
+
 	// Parse network configuration from stdin.
-	nwCfg, err = cni.ParseNetworkConfig(args.StdinData)
+	nwCfg, err = cni.ParseNetworkConfig(stdinData)
 	if err != nil {
 		err = plugin.Errorf("Failed to parse network configuration: %v.", err)
 		return err
@@ -718,6 +1003,12 @@ func (plugin *netPlugin) Update(args *cniSkel.CmdArgs) error {
 	// Initialize values from network config.
 	networkID := nwCfg.Name
 
+	if err = plugin.nm.LockNetwork(networkID); err != nil {
+		log.Printf("[cni-net] Failed to lock network %v, err:%v.", networkID, err)
+		return err
+	}
+	defer plugin.nm.UnlockNetwork(networkID)
+
 	// Query the network.
 	_, err = plugin.nm.GetNetworkInfo(networkID)
 	if err != nil {
@@ -745,6 +1036,8 @@ func (plugin *netPlugin) Update(args *cniSkel.CmdArgs) error {
 		return plugin.Errorf(err.Error())
 	}
 
+	cnsClient.SetSpanContext(span.Context)
+
 	// create struct with info for target POD
 	podInfo := cns.KubernetesPodInfo{PodName: k8sPodName, PodNamespace: k8sNamespace}
 	orchestratorContext, err := json.Marshal(podInfo)
@@ -805,5 +1098,70 @@ func (plugin *netPlugin) Update(args *cniSkel.CmdArgs) error {
 		return err
 	}
 
+	if list != nil {
+		existingEpInfo.Routes = targetEpInfo.Routes
+		result, err = plugin.reapplyChainedPlugins(list, index, existingEpInfo)
+		if err != nil {
+			err = plugin.Errorf("Failed to reapply chained plugins: %v", err)
+			return err
+		}
+	}
+
 	return nil
 }
+
+// reapplyChainedPlugins reapplies the plugins chained after azure-vnet in a network configuration
+// list to an updated endpoint. Chained plugins have no UPDATE command, so they are deleted in
+// reverse order and added in order, as the runtime invokes them, and the result of the chain is
+// returned.
+func (plugin *netPlugin) reapplyChainedPlugins(list *cni.NetworkConfigList, index int, epInfo *network.EndpointInfo) (*cniTypesCurr.Result, error) {
+	result := &cniTypesCurr.Result{}
+	result.Interfaces = append(result.Interfaces, &cniTypesCurr.Interface{Name: epInfo.IfName})
+	setEndpointResult(epInfo, result)
+
+	// UPDATE is invoked per pod, chained plugins expect the sandbox they apply to.
+	if os.Getenv("CNI_CONTAINERID") == "" {
+		os.Setenv("CNI_CONTAINERID", epInfo.ContainerID)
+	}
+	if os.Getenv("CNI_IFNAME") == "" {
+		os.Setenv("CNI_IFNAME", epInfo.IfName)
+	}
+	if os.Getenv("CNI_NETNS") == "" {
+		os.Setenv("CNI_NETNS", epInfo.NetNsPath)
+	}
+
+	for i := len(list.Plugins) - 1; i > index; i-- {
+		pluginType, err := list.GetPluginType(i)
+		if err != nil {
+			return nil, err
+		}
+
+		config, err := list.GetPluginConfig(i, result)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = plugin.DelegateChainedDel(pluginType, config); err != nil {
+			return nil, err
+		}
+	}
+
+	var prevResult cniTypes.Result = result
+	for i := index + 1; i < len(list.Plugins); i++ {
+		pluginType, err := list.GetPluginType(i)
+		if err != nil {
+			return nil, err
+		}
+
+		config, err := list.GetPluginConfig(i, prevResult)
+		if err != nil {
+			return nil, err
+		}
+
+		if prevResult, err = plugin.DelegateChainedAdd(pluginType, config); err != nil {
+			return nil, err
+		}
+	}
+
+	return cniTypesCurr.NewResultFromResult(prevResult)
+}
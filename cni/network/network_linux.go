@@ -1,14 +1,19 @@
 package network
 
 import (
+	"fmt"
 	"net"
 	"strconv"
+	"strings"
 
 	"github.com/Azure/azure-container-networking/cni"
 	"github.com/Azure/azure-container-networking/cns"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/network"
+	"github.com/Azure/azure-container-networking/network/ovssnat"
 	"github.com/Azure/azure-container-networking/network/policy"
+	"github.com/Azure/azure-container-networking/platform"
+	"github.com/Azure/azure-container-networking/trace"
 	cniTypes "github.com/containernetworking/cni/pkg/types"
 	cniTypesCurr "github.com/containernetworking/cni/pkg/types/current"
 )
@@ -16,6 +21,15 @@ import (
 const (
 	snatInterface  = "eth1"
 	infraInterface = "eth2"
+
+	// Interface names are limited to IFNAMSIZ - 1 characters.
+	maxSnatBridgeNameLength = 15
+
+	opModeTunnel = "tunnel"
+
+	// Datapaths programming the MAC NAT rules of bridge networks.
+	bridgeDatapathEbtables = "ebtables"
+	bridgeDatapathNftables = "nftables"
 )
 
 // handleConsecutiveAdd is a dummy function for Linux platform.
@@ -31,6 +45,35 @@ func addDefaultRoute(gwIPString string, epInfo *network.EndpointInfo, result *cn
 	result.Routes = append(result.Routes, &cniTypes.Route{Dst: dstIP, GW: gwIP})
 }
 
+// addSnatRoutes routes the infra and host traffic SNATed through the host via the SNAT interface,
+// for pods whose internet traffic isn't SNATed.
+func addSnatRoutes(nwCfg *cni.NetworkConfig, cnsNwConfig *cns.GetNetworkContainerResponse, epInfo *network.EndpointInfo, result *cniTypesCurr.Result) {
+	var destinations []string
+
+	if network.IsSnatTrafficEnabled(nwCfg.SnatTraffic, network.SnatTrafficInfra) {
+		destinations = append(destinations, ovssnat.ImdsIP)
+		for _, server := range epInfo.DNS.Servers {
+			destinations = append(destinations, server+"/32")
+		}
+	}
+
+	if network.IsSnatTrafficEnabled(nwCfg.SnatTraffic, network.SnatTrafficHost) && cnsNwConfig.PrimaryInterfaceIdentifier != "" {
+		destinations = append(destinations, cnsNwConfig.PrimaryInterfaceIdentifier+"/32")
+	}
+
+	gwIP := net.ParseIP(cnsNwConfig.LocalIPConfiguration.GatewayIPAddress)
+	for _, destination := range destinations {
+		_, dstIP, err := net.ParseCIDR(destination)
+		if err != nil || dstIP.IP.To4() == nil {
+			log.Printf("Skipping SNAT route to %v", destination)
+			continue
+		}
+
+		epInfo.Routes = append(epInfo.Routes, network.RouteInfo{Dst: *dstIP, Gw: gwIP, DevName: snatInterface})
+		result.Routes = append(result.Routes, &cniTypes.Route{Dst: *dstIP, GW: gwIP})
+	}
+}
+
 func addInfraRoutes(azIpamResult *cniTypesCurr.Result, result *cniTypesCurr.Result, epInfo *network.EndpointInfo) {
 	for _, route := range azIpamResult.Routes {
 		epInfo.Routes = append(epInfo.Routes, network.RouteInfo{Dst: route.Dst, Gw: route.GW, DevName: infraInterface})
@@ -38,22 +81,26 @@ func addInfraRoutes(azIpamResult *cniTypesCurr.Result, result *cniTypesCurr.Resu
 	}
 }
 
-func setNetworkOptions(cnsNwConfig *cns.GetNetworkContainerResponse, nwInfo *network.NetworkInfo) {
+func setNetworkOptions(nwCfg *cni.NetworkConfig, cnsNwConfig *cns.GetNetworkContainerResponse, nwInfo *network.NetworkInfo) {
 	if cnsNwConfig != nil && cnsNwConfig.MultiTenancyInfo.ID != 0 {
 		log.Printf("Setting Network Options")
 		vlanMap := make(map[string]interface{})
 		vlanMap[network.VlanIDKey] = strconv.Itoa(cnsNwConfig.MultiTenancyInfo.ID)
 		vlanMap[network.SnatBridgeIPKey] = cnsNwConfig.LocalIPConfiguration.GatewayIPAddress + "/" + strconv.Itoa(int(cnsNwConfig.LocalIPConfiguration.IPSubnet.PrefixLength))
+		vlanMap[network.SnatBridgeNameKey] = nwCfg.SnatBridgeName
+		vlanMap[network.SnatTrafficKey] = nwCfg.SnatTraffic
 		nwInfo.Options[dockerNetworkOption] = vlanMap
 	}
 }
 
-func setEndpointOptions(cnsNwConfig *cns.GetNetworkContainerResponse, epInfo *network.EndpointInfo, vethName string) {
+func setEndpointOptions(nwCfg *cni.NetworkConfig, cnsNwConfig *cns.GetNetworkContainerResponse, epInfo *network.EndpointInfo, vethName string) {
 	if cnsNwConfig != nil && cnsNwConfig.MultiTenancyInfo.ID != 0 {
 		log.Printf("Setting Endpoint Options")
 		epInfo.Data[network.VlanIDKey] = cnsNwConfig.MultiTenancyInfo.ID
 		epInfo.Data[network.LocalIPKey] = cnsNwConfig.LocalIPConfiguration.IPSubnet.IPAddress + "/" + strconv.Itoa(int(cnsNwConfig.LocalIPConfiguration.IPSubnet.PrefixLength))
 		epInfo.Data[network.SnatBridgeIPKey] = cnsNwConfig.LocalIPConfiguration.GatewayIPAddress + "/" + strconv.Itoa(int(cnsNwConfig.LocalIPConfiguration.IPSubnet.PrefixLength))
+		epInfo.Data[network.SnatBridgeNameKey] = nwCfg.SnatBridgeName
+		epInfo.Data[network.SnatTrafficKey] = nwCfg.SnatTraffic
 	}
 
 	epInfo.Data[network.OptVethName] = vethName
@@ -96,6 +143,10 @@ func getNetworkDNSSettings(nwCfg *cni.NetworkConfig, result *cniTypesCurr.Result
 		}
 	}
 
+	// Search suffixes and resolver options of the network configuration apply with any servers.
+	nwDNS.Search = nwCfg.DNS.Search
+	nwDNS.Options = nwCfg.DNS.Options
+
 	return nwDNS, nil
 }
 
@@ -109,9 +160,140 @@ func getPoliciesFromRuntimeCfg(nwCfg *cni.NetworkConfig) []policy.Policy {
 	return nil
 }
 
+// setOutboundNatExceptions sets the CIDRs exempt from outbound NAT in network config.
+// setOutboundNatExceptions is a dummy function for Linux platform.
+func setOutboundNatExceptions(nwCfg *cni.NetworkConfig, epInfo *network.EndpointInfo) error {
+	return nil
+}
+
+// validateChainingMode checks that plugins can be chained after azure-vnet in the network configuration.
+func validateChainingMode(nwCfg *cni.NetworkConfig) error {
+	switch nwCfg.ChainingMode {
+	case "":
+		return nil
+	case chainingModeCilium:
+		// Cilium needs the host end of the veth pair of each pod, which bridges take over.
+		if nwCfg.Mode != opModeTransparent {
+			return fmt.Errorf("Chaining mode %s requires mode %s", nwCfg.ChainingMode, opModeTransparent)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unsupported chaining mode %s", nwCfg.ChainingMode)
+	}
+}
+
+// validateNetworkType checks the network type of the network configuration.
+func validateNetworkType(nwCfg *cni.NetworkConfig) error {
+	// The VXLAN interface of overlay networks is routed to, so pods can't be bridged.
+	if isOverlayNetwork(nwCfg) && nwCfg.Mode != opModeTransparent {
+		return fmt.Errorf("Network type %s requires mode %s", nwCfg.NetworkType, opModeTransparent)
+	}
+
+	switch strings.ToLower(nwCfg.OverlayEncap) {
+	case "", overlayEncapVxlan:
+		if nwCfg.GeneveTenantId != "" {
+			return fmt.Errorf("Geneve tenant ID requires overlay encapsulation %s", overlayEncapGeneve)
+		}
+	case overlayEncapGeneve:
+	default:
+		return fmt.Errorf("Unsupported overlay encapsulation %s", nwCfg.OverlayEncap)
+	}
+
+	return nil
+}
+
+// checkGateway checks that the gateway of an endpoint responds.
+// checkGateway is a dummy function for Linux platform.
+func checkGateway(nwCfg *cni.NetworkConfig, gateway net.IP) error {
+	return nil
+}
+
+// validateBridgeDatapath checks the datapath programming the MAC NAT rules of the network configuration.
+func validateBridgeDatapath(nwCfg *cni.NetworkConfig) error {
+	switch strings.ToLower(nwCfg.BridgeDatapath) {
+	case "", bridgeDatapathEbtables:
+	case bridgeDatapathNftables:
+		// nftables can't reply to ARP requests with the virtual MAC address tunnel mode hairpins traffic through.
+		if nwCfg.Mode == opModeTunnel {
+			return fmt.Errorf("Bridge datapath %s doesn't support mode %s", bridgeDatapathNftables, opModeTunnel)
+		}
+	default:
+		return fmt.Errorf("Unsupported bridge datapath %s", nwCfg.BridgeDatapath)
+	}
+
+	return nil
+}
+
+// validateHardwareOffload checks that the network configuration can offload the datapath of pods.
+// Only the OVS datapath of multitenancy pods is offloaded.
+func validateHardwareOffload(nwCfg *cni.NetworkConfig) error {
+	if nwCfg.EnableHardwareOffload && !nwCfg.MultiTenancy {
+		return fmt.Errorf("Hardware offload requires multitenancy")
+	}
+
+	return nil
+}
+
+// validateSnatOptions checks the SNAT bridge options and masquerade exclusions of the network configuration.
+func validateSnatOptions(nwCfg *cni.NetworkConfig) error {
+	if len(nwCfg.SnatBridgeName) > maxSnatBridgeNameLength {
+		return fmt.Errorf("SNAT bridge name %s is longer than %d characters", nwCfg.SnatBridgeName, maxSnatBridgeNameLength)
+	}
+
+	if nwCfg.SnatBridgeSubnet != "" {
+		if _, subnet, err := net.ParseCIDR(nwCfg.SnatBridgeSubnet); err != nil || subnet.IP.To4() == nil {
+			return fmt.Errorf("Invalid SNAT bridge subnet %s", nwCfg.SnatBridgeSubnet)
+		}
+	}
+
+	for _, class := range nwCfg.SnatTraffic {
+		switch class {
+		case network.SnatTrafficHost, network.SnatTrafficInfra, network.SnatTrafficInternet:
+		default:
+			return fmt.Errorf("Unsupported SNAT traffic class %s", class)
+		}
+	}
+
+	// Exclusions are programmed with iptables, which only handles IPv4.
+	for _, exclusion := range nwCfg.MasqueradeExclusions {
+		if _, subnet, err := net.ParseCIDR(exclusion); err != nil || subnet.IP.To4() == nil {
+			return fmt.Errorf("Invalid masquerade exclusion %s", exclusion)
+		}
+	}
+
+	return nil
+}
+
+// getRequiredKernelFeatures returns the kernel features needed by the network configuration.
+func getRequiredKernelFeatures(nwCfg *cni.NetworkConfig) []platform.KernelFeature {
+	var features []platform.KernelFeature
+
+	if nwCfg.Mode != opModeTransparent {
+		if strings.EqualFold(nwCfg.BridgeDatapath, bridgeDatapathNftables) {
+			features = append(features, platform.FeatureNftables)
+		} else {
+			features = append(features, platform.FeatureEbtables)
+		}
+	}
+
+	if nwCfg.EnableSnatOnHost || nwCfg.MultiTenancy {
+		features = append(features, platform.FeatureIptables)
+	}
+
+	if isOverlayNetwork(nwCfg) {
+		if isGeneveOverlay(nwCfg) {
+			features = append(features, platform.FeatureGeneve)
+		} else {
+			features = append(features, platform.FeatureVxlan)
+		}
+	}
+
+	return features
+}
+
 func updateSubnetPrefix(cnsNetworkConfig *cns.GetNetworkContainerResponse, subnetPrefix *net.IPNet) {
 }
 
-func getNetworkName(podName, podNs, ifName string, nwCfg *cni.NetworkConfig) (string, error) {
+func getNetworkName(podName, podNs, ifName string, nwCfg *cni.NetworkConfig, spanContext trace.SpanContext) (string, error) {
 	return nwCfg.Name, nil
 }
@@ -12,6 +12,8 @@ import (
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/network"
 	"github.com/Azure/azure-container-networking/network/policy"
+	"github.com/Azure/azure-container-networking/platform"
+	"github.com/Azure/azure-container-networking/trace"
 	"github.com/Microsoft/hcsshim"
 
 	cniTypes "github.com/containernetworking/cni/pkg/types"
@@ -67,10 +69,13 @@ func handleConsecutiveAdd(containerId, endpointId string, nwInfo *network.Networ
 func addDefaultRoute(gwIPString string, epInfo *network.EndpointInfo, result *cniTypesCurr.Result) {
 }
 
+func addSnatRoutes(nwCfg *cni.NetworkConfig, cnsNwConfig *cns.GetNetworkContainerResponse, epInfo *network.EndpointInfo, result *cniTypesCurr.Result) {
+}
+
 func addInfraRoutes(azIpamResult *cniTypesCurr.Result, result *cniTypesCurr.Result, epInfo *network.EndpointInfo) {
 }
 
-func setNetworkOptions(cnsNwConfig *cns.GetNetworkContainerResponse, nwInfo *network.NetworkInfo) {
+func setNetworkOptions(nwCfg *cni.NetworkConfig, cnsNwConfig *cns.GetNetworkContainerResponse, nwInfo *network.NetworkInfo) {
 	if cnsNwConfig != nil && cnsNwConfig.MultiTenancyInfo.ID != 0 {
 		log.Printf("Setting Network Options")
 		vlanMap := make(map[string]interface{})
@@ -79,7 +84,7 @@ func setNetworkOptions(cnsNwConfig *cns.GetNetworkContainerResponse, nwInfo *net
 	}
 }
 
-func setEndpointOptions(cnsNwConfig *cns.GetNetworkContainerResponse, epInfo *network.EndpointInfo, vethName string) {
+func setEndpointOptions(nwCfg *cni.NetworkConfig, cnsNwConfig *cns.GetNetworkContainerResponse, epInfo *network.EndpointInfo, vethName string) {
 	if cnsNwConfig != nil && cnsNwConfig.MultiTenancyInfo.ID != 0 {
 		log.Printf("Setting Endpoint Options")
 		var cnetAddressMap []string
@@ -90,6 +95,93 @@ func setEndpointOptions(cnsNwConfig *cns.GetNetworkContainerResponse, epInfo *ne
 	}
 }
 
+// setOutboundNatExceptions sets the CIDRs exempt from outbound NAT in network config.
+func setOutboundNatExceptions(nwCfg *cni.NetworkConfig, epInfo *network.EndpointInfo) error {
+	if len(nwCfg.OutboundNatExceptions) == 0 {
+		return nil
+	}
+
+	var exceptions []string
+	for _, exception := range nwCfg.OutboundNatExceptions {
+		_, ipNet, err := net.ParseCIDR(exception)
+		if err != nil {
+			return fmt.Errorf("Invalid outbound NAT exception %q: %v", exception, err)
+		}
+		exceptions = append(exceptions, ipNet.String())
+	}
+
+	log.Printf("[net] Setting outbound NAT exceptions %v", exceptions)
+	epInfo.Data[policy.OutBoundNatExceptions] = exceptions
+
+	return nil
+}
+
+// getRequiredKernelFeatures returns the kernel features needed by the network configuration.
+// getRequiredKernelFeatures is a dummy function for Windows platform.
+func getRequiredKernelFeatures(nwCfg *cni.NetworkConfig) []platform.KernelFeature {
+	return nil
+}
+
+// validateChainingMode checks that plugins can be chained after azure-vnet in the network configuration.
+// No chaining mode is supported on Windows.
+func validateChainingMode(nwCfg *cni.NetworkConfig) error {
+	if nwCfg.ChainingMode != "" {
+		return fmt.Errorf("Unsupported chaining mode %s", nwCfg.ChainingMode)
+	}
+
+	return nil
+}
+
+// validateNetworkType checks the network type of the network configuration.
+// HNS validates the network type on Windows, and its overlay networks only use VXLAN.
+func validateNetworkType(nwCfg *cni.NetworkConfig) error {
+	if nwCfg.OverlayEncap != "" || nwCfg.GeneveTenantId != "" {
+		return fmt.Errorf("Overlay encapsulation options are not supported")
+	}
+
+	return nil
+}
+
+// updateOverlayVteps programs the VTEPs of the other nodes in an overlay network.
+// HNS programs the remote VTEPs of overlay networks on Windows.
+func (plugin *netPlugin) updateOverlayVteps(nwCfg *cni.NetworkConfig, networkId string, spanContext trace.SpanContext) error {
+	return nil
+}
+
+// validateBridgeDatapath checks the datapath programming the MAC NAT rules of the network configuration.
+// HNS programs the datapath on Windows.
+func validateBridgeDatapath(nwCfg *cni.NetworkConfig) error {
+	if nwCfg.BridgeDatapath != "" {
+		return fmt.Errorf("Bridge datapath is not supported")
+	}
+
+	return nil
+}
+
+// validateHardwareOffload checks that the network configuration can offload the datapath of pods.
+// Hardware offload isn't supported on Windows.
+func validateHardwareOffload(nwCfg *cni.NetworkConfig) error {
+	if nwCfg.EnableHardwareOffload {
+		return fmt.Errorf("Hardware offload is not supported")
+	}
+
+	return nil
+}
+
+// validateSnatOptions checks the SNAT bridge options and masquerade exclusions of the network configuration.
+// SNAT through the host isn't supported on Windows.
+func validateSnatOptions(nwCfg *cni.NetworkConfig) error {
+	if nwCfg.SnatBridgeName != "" || nwCfg.SnatBridgeSubnet != "" || len(nwCfg.SnatTraffic) != 0 {
+		return fmt.Errorf("SNAT bridge options are not supported")
+	}
+
+	if len(nwCfg.MasqueradeExclusions) != 0 {
+		return fmt.Errorf("Masquerade exclusions are not supported, use outboundNatExceptions instead")
+	}
+
+	return nil
+}
+
 func addSnatInterface(nwCfg *cni.NetworkConfig, result *cniTypesCurr.Result) {
 }
 
@@ -109,9 +201,9 @@ func updateSubnetPrefix(cnsNwConfig *cns.GetNetworkContainerResponse, subnetPref
 	}
 }
 
-func getNetworkName(podName, podNs, ifName string, nwCfg *cni.NetworkConfig) (string, error) {
+func getNetworkName(podName, podNs, ifName string, nwCfg *cni.NetworkConfig, spanContext trace.SpanContext) (string, error) {
 	if nwCfg.MultiTenancy {
-		_, cnsNetworkConfig, _, err := getContainerNetworkConfiguration(nwCfg, "", podName, podNs, ifName)
+		_, cnsNetworkConfig, _, err := getContainerNetworkConfiguration(nwCfg, "", podName, podNs, ifName, spanContext)
 		if err != nil {
 			log.Printf("GetContainerNetworkConfiguration failed for podname %v namespace %v with error %v", podName, podNs, err)
 			return "", err
@@ -155,9 +247,12 @@ func getEndpointDNSSettings(nwCfg *cni.NetworkConfig, result *cniTypesCurr.Resul
 	}
 
 	if len(nwCfg.DNS.Search) > 0 {
+		// The first search suffix is qualified with the namespace of the pod.
+		search := append([]string{namespace + "." + nwCfg.DNS.Search[0]}, nwCfg.DNS.Search[1:]...)
 		epDNS = network.DNSInfo{
 			Servers: nwCfg.DNS.Nameservers,
-			Suffix:  namespace + "." + strings.Join(nwCfg.DNS.Search, ","),
+			Suffix:  strings.Join(search, ","),
+			Search:  search,
 		}
 	} else {
 		epDNS = network.DNSInfo{
@@ -166,6 +261,8 @@ func getEndpointDNSSettings(nwCfg *cni.NetworkConfig, result *cniTypesCurr.Resul
 		}
 	}
 
+	epDNS.Options = nwCfg.DNS.Options
+
 	return epDNS, nil
 }
 
@@ -0,0 +1,78 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/cni"
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/cnsclient"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/network"
+	"github.com/Azure/azure-container-networking/trace"
+)
+
+const (
+	// Network type of networks whose pods reach the pods of other nodes through a VXLAN or Geneve overlay.
+	networkTypeOverlay = "overlay"
+
+	// Encapsulations of the traffic of overlay networks.
+	overlayEncapVxlan  = "vxlan"
+	overlayEncapGeneve = "geneve"
+)
+
+// isOverlayNetwork checks if the network configuration is for an overlay network.
+func isOverlayNetwork(nwCfg *cni.NetworkConfig) bool {
+	return strings.EqualFold(nwCfg.NetworkType, networkTypeOverlay)
+}
+
+// isGeneveOverlay checks if the network configuration is for an overlay network encapsulated with Geneve.
+func isGeneveOverlay(nwCfg *cni.NetworkConfig) bool {
+	return isOverlayNetwork(nwCfg) && strings.EqualFold(nwCfg.OverlayEncap, overlayEncapGeneve)
+}
+
+// updateOverlayVteps programs the VTEPs of the other nodes CNS distributes in an overlay network, so
+// that the pods of the node reach the pods of nodes that joined the network since the last ADD.
+func (plugin *netPlugin) updateOverlayVteps(nwCfg *cni.NetworkConfig, networkId string, spanContext trace.SpanContext) error {
+	if !isOverlayNetwork(nwCfg) {
+		return nil
+	}
+
+	cnsClient, err := cnsclient.NewCnsClient(nwCfg.CNSUrl)
+	if err != nil {
+		log.Printf("Initializing CNS client error %v", err)
+		return err
+	}
+
+	cnsClient.SetSpanContext(spanContext)
+
+	supported, err := cnsClient.SupportsFeature(cns.FeatureOverlayVteps)
+	if err != nil {
+		return err
+	}
+
+	if !supported {
+		return fmt.Errorf("CNS does not support overlay VTEPs, upgrade CNS to use overlay networks")
+	}
+
+	cnsVteps, err := cnsClient.GetOverlayVteps()
+	if err != nil {
+		return err
+	}
+
+	var vteps []network.VtepInfo
+	for _, cnsVtep := range cnsVteps {
+		nodeIP := net.ParseIP(cnsVtep.NodeIP)
+		_, podPrefix, err := net.ParseCIDR(cnsVtep.PodPrefix)
+		if nodeIP == nil || err != nil {
+			return fmt.Errorf("Invalid VTEP %s serving %s", cnsVtep.NodeIP, cnsVtep.PodPrefix)
+		}
+
+		vteps = append(vteps, network.VtepInfo{NodeIP: nodeIP, PodPrefix: *podPrefix})
+	}
+
+	log.Printf("[cni-net] Updating %d VTEPs of overlay network %v.", len(vteps), networkId)
+
+	return plugin.nm.UpdateOverlayVteps(networkId, vteps)
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Azure/azure-container-networking/cni/network"
+	"github.com/Azure/azure-container-networking/common"
+	acnnetwork "github.com/Azure/azure-container-networking/network"
+)
+
+const (
+	// Subcommand printing the state of the plugin and the host.
+	dumpCommand = "dump"
+)
+
+// dumpState prints the network and endpoint state of the plugin, the state of the host, and the
+// inconsistencies between them. It returns the exit code of the subcommand, 1 if the state is
+// inconsistent.
+func dumpState() int {
+	var config common.PluginConfig
+	config.Version = version
+
+	netPlugin, err := network.NewPlugin(&config)
+	if err != nil {
+		fmt.Printf("Failed to create network plugin: %v\n", err)
+		return 2
+	}
+
+	// The store is locked so that the state isn't read while a CNI command changes it.
+	if err = netPlugin.Plugin.InitializeKeyValueStore(&config); err != nil {
+		fmt.Printf("Failed to open the state of the network plugin: %v\n", err)
+		return 2
+	}
+
+	dump, err := acnnetwork.DumpState(config.Store)
+	netPlugin.Plugin.UninitializeKeyValueStore()
+	if err != nil {
+		fmt.Printf("Failed to read the state of the network plugin: %v\n", err)
+		return 2
+	}
+
+	state, _ := json.MarshalIndent(dump.State, "", "  ")
+	fmt.Printf("=== State ===\n%s\n", state)
+
+	for _, hostState := range dump.Host {
+		fmt.Printf("\n=== %s ===\n%s\n", hostState.Name, hostState.Output)
+	}
+
+	fmt.Printf("\n=== Inconsistencies ===\n")
+	if len(dump.Inconsistencies) == 0 {
+		fmt.Printf("None\n")
+		return 0
+	}
+
+	for _, issue := range dump.Inconsistencies {
+		fmt.Printf("! %s\n", issue)
+	}
+
+	return 1
+}
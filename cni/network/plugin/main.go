@@ -5,10 +5,12 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"reflect"
+	"time"
 
 	"github.com/Azure/azure-container-networking/cni"
 	"github.com/Azure/azure-container-networking/cni/network"
@@ -16,17 +18,23 @@ import (
 	acn "github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/telemetry"
+	"github.com/Azure/azure-container-networking/trace"
 	"github.com/containernetworking/cni/pkg/skel"
 )
 
 const (
 	hostNetAgentURL = "http://169.254.169.254/machine/plugins?comp=netagent&type=cnireport"
-	ipamQueryURL    = "http://169.254.169.254/machine/plugins?comp=nmagent&type=getinterfaceinfov1"
 	pluginName      = "CNI"
+	pluginType      = "azure-vnet"
 )
 
-// Version is populated by make during build.
-var version string
+var (
+	// Version is populated by make during build.
+	version string
+
+	// Host interface configuration queried for telemetry, not available in the static environment.
+	ipamQueryURL = "http://169.254.169.254/machine/plugins?comp=nmagent&type=getinterfaceinfov1"
+)
 
 // Command line arguments for CNI plugin.
 var args = acn.ArgumentList{
@@ -69,6 +77,28 @@ func reportPluginError(reportManager *telemetry.ReportManager, err error) {
 	}
 }
 
+// reportOperation reports the result and latency of the CNI command to the telemetry daemon,
+// which aggregates them per command. Successful commands are reported to the host directly if
+// the daemon isn't running. Failures were already reported by reportPluginError.
+func reportOperation(reportManager *telemetry.ReportManager, startTime time.Time, succeeded bool) {
+	report := reportManager.Report.(*telemetry.CNIReport)
+	report.CniSucceeded = succeeded
+
+	operation := *report
+	operation.OperationType = os.Getenv("CNI_COMMAND")
+	operation.OperationDuration = int64(time.Since(startTime) / time.Millisecond)
+
+	if err := telemetry.SendToTelemetryBuffer(&operation); err == nil || !succeeded {
+		return
+	}
+
+	if err := reportManager.SendReport(); err != nil {
+		log.Printf("SendReport failed due to %v", err)
+	} else {
+		markSendReport(reportManager)
+	}
+}
+
 func validateConfig(jsonBytes []byte) error {
 	var conf struct {
 		Name string `json:"name"`
@@ -82,6 +112,53 @@ func validateConfig(jsonBytes []byte) error {
 	return nil
 }
 
+// isStaticEnvironment checks if the network configuration passed on stdin selects the static
+// environment, which has no host to query or report to. Stdin is put back for the plugin to read.
+func isStaticEnvironment() bool {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return false
+	}
+
+	stdinData, err := ioutil.ReadAll(os.Stdin)
+	os.Stdin = r
+
+	// Network configurations are small, but write them in the background so that larger ones
+	// don't fill up the pipe before the plugin reads it.
+	go func() {
+		w.Write(stdinData)
+		w.Close()
+	}()
+
+	if err != nil {
+		return false
+	}
+
+	// Find the configuration of this plugin in network configuration lists.
+	if cni.IsNetworkConfigList(stdinData) {
+		list, err := cni.ParseNetworkConfigList(stdinData)
+		if err != nil {
+			return false
+		}
+
+		index := list.FindPlugin(pluginType)
+		if index < 0 {
+			return false
+		}
+
+		if stdinData, err = list.GetPluginConfig(index, nil); err != nil {
+			return false
+		}
+	}
+
+	nwCfg, err := cni.ParseNetworkConfig(stdinData)
+	if err != nil {
+		return false
+	}
+
+	return nwCfg.Ipam.Environment == common.OptEnvironmentStatic
+}
+
 func getCmdArgsFromEnv() (string, *skel.CmdArgs, error) {
 	log.Printf("Going to read from stdin")
 	stdinData, err := ioutil.ReadAll(os.Stdin)
@@ -145,12 +222,18 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == dumpCommand {
+		os.Exit(dumpState())
+	}
+
 	var (
 		config common.PluginConfig
 		err    error
 	)
 
 	config.Version = version
+	trace.Initialize("azure-vnet", 0)
+
 	reportManager := &telemetry.ReportManager{
 		HostNetAgentURL: hostNetAgentURL,
 		ContentType:     telemetry.ContentType,
@@ -159,7 +242,14 @@ func main() {
 		},
 	}
 
-	reportManager.GetHostMetadata()
+	// Outside of Azure, such as in the static environment, there is no host agent or metadata service.
+	if isStaticEnvironment() {
+		reportManager.HostNetAgentURL = ""
+		ipamQueryURL = ""
+	} else {
+		reportManager.GetHostMetadata()
+	}
+
 	reportManager.Report.(*telemetry.CNIReport).GetReport(pluginName, config.Version, ipamQueryURL)
 
 	if !reportManager.GetReportState(telemetry.CNITelemetryFile) {
@@ -182,16 +272,15 @@ func main() {
 
 	netPlugin.SetReportManager(reportManager)
 
-	if err = netPlugin.Plugin.InitializeKeyValueStore(&config); err != nil {
+	// The network manager locks the networks it updates, so invocations for different networks don't wait for each other.
+	if err = netPlugin.Plugin.OpenKeyValueStore(&config); err != nil {
 		log.Printf("Failed to initialize key-value store of network plugin, err:%v.\n", err)
 		reportPluginError(reportManager, err)
 		os.Exit(1)
 	}
 
 	defer func() {
-		if errUninit := netPlugin.Plugin.UninitializeKeyValueStore(); errUninit != nil {
-			log.Printf("Failed to uninitialize key-value store of network plugin, err:%v.\n", err)
-		}
+		netPlugin.Plugin.CloseKeyValueStore()
 
 		if recover() != nil {
 			os.Exit(1)
@@ -204,6 +293,7 @@ func main() {
 		panic("network plugin fatal error")
 	}
 
+	startTime := time.Now()
 	handled, err := handleIfCniUpdate(netPlugin.Update)
 	if handled == true {
 		log.Printf("CNI UPDATE finished.")
@@ -214,16 +304,12 @@ func main() {
 
 	netPlugin.Stop()
 
-	if err != nil {
-		panic("network plugin fatal error")
-	}
+	// CNI exits right after the command, so spans are exported before.
+	trace.Close()
 
-	// Report CNI successfully finished execution.
-	reflect.ValueOf(reportManager.Report).Elem().FieldByName("CniSucceeded").SetBool(true)
+	reportOperation(reportManager, startTime, err == nil)
 
-	if err = reportManager.SendReport(); err != nil {
-		log.Printf("SendReport failed due to %v", err)
-	} else {
-		markSendReport(reportManager)
+	if err != nil {
+		panic("network plugin fatal error")
 	}
 }
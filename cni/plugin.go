@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/log"
@@ -20,6 +21,9 @@ import (
 	cniVers "github.com/containernetworking/cni/pkg/version"
 )
 
+// Age after which rotated log files are removed.
+const logFileMaxAge = 7 * 24 * time.Hour
+
 // Plugin is the parent class for CNI plugins.
 type Plugin struct {
 	*common.Plugin
@@ -34,9 +38,12 @@ func NewPlugin(name, version string) (*Plugin, error) {
 		return nil, err
 	}
 
+	plugin.APIVersions = supportedVersions
+
 	// Initialize logging.
 	log.SetName(plugin.Name)
 	log.SetLevel(log.LevelInfo)
+	log.SetLogFileRetention(logFileMaxAge, true)
 	err = log.SetTarget(log.TargetLogfile)
 	if err != nil {
 		log.Printf("[cni] Failed to configure logging, err:%v.\n", err)
@@ -138,6 +145,37 @@ func (plugin *Plugin) DelegateDel(pluginName string, nwCfg *NetworkConfig) error
 	return nil
 }
 
+// DelegateChainedAdd calls the ADD command of a plugin chained in a network configuration list,
+// with its configuration as the runtime passes it, and returns the result.
+func (plugin *Plugin) DelegateChainedAdd(pluginType string, config []byte) (cniTypes.Result, error) {
+	log.Printf("[cni] Calling chained plugin %v ADD config:%s.", pluginType, config)
+
+	os.Setenv(Cmd, CmdAdd)
+
+	res, err := cniInvoke.DelegateAdd(pluginType, config, nil)
+	log.Printf("[cni] Chained plugin %v returned result:%+v, err:%v.", pluginType, res, err)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to delegate to %v: %v", pluginType, err)
+	}
+
+	return res, nil
+}
+
+// DelegateChainedDel calls the DEL command of a plugin chained in a network configuration list.
+func (plugin *Plugin) DelegateChainedDel(pluginType string, config []byte) error {
+	log.Printf("[cni] Calling chained plugin %v DEL config:%s.", pluginType, config)
+
+	os.Setenv(Cmd, CmdDel)
+
+	err := cniInvoke.DelegateDel(pluginType, config, nil)
+	log.Printf("[cni] Chained plugin %v returned err:%v.", pluginType, err)
+	if err != nil {
+		return fmt.Errorf("Failed to delegate to %v: %v", pluginType, err)
+	}
+
+	return nil
+}
+
 // Error creates and logs a structured CNI error.
 func (plugin *Plugin) Error(err error) *cniTypes.Error {
 	var cniErr *cniTypes.Error
@@ -160,6 +198,22 @@ func (plugin *Plugin) Errorf(format string, args ...interface{}) *cniTypes.Error
 
 // Initialize key-value store
 func (plugin *Plugin) InitializeKeyValueStore(config *common.PluginConfig) error {
+	if err := plugin.OpenKeyValueStore(config); err != nil {
+		return err
+	}
+
+	// Acquire store lock.
+	if err := plugin.Store.Lock(true); err != nil {
+		log.Printf("[cni] Failed to lock store: %v.", err)
+		return err
+	}
+
+	return nil
+}
+
+// OpenKeyValueStore opens the key-value store without locking it, for plugins that lock the
+// parts of the state they update themselves.
+func (plugin *Plugin) OpenKeyValueStore(config *common.PluginConfig) error {
 	// Create the key value store.
 	if plugin.Store == nil {
 		var err error
@@ -185,17 +239,16 @@ func (plugin *Plugin) InitializeKeyValueStore(config *common.PluginConfig) error
 		}
 	}
 
-	// Acquire store lock.
-	if err := plugin.Store.Lock(true); err != nil {
-		log.Printf("[cni] Failed to lock store: %v.", err)
-		return err
-	}
-
 	config.Store = plugin.Store
 
 	return nil
 }
 
+// CloseKeyValueStore closes a key-value store opened without locking it.
+func (plugin *Plugin) CloseKeyValueStore() {
+	plugin.Store = nil
+}
+
 // Uninitialize key-value store
 func (plugin *Plugin) UninitializeKeyValueStore() error {
 	if plugin.Store != nil {
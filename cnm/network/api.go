@@ -17,6 +17,14 @@ const (
 	leavePath            = "/NetworkDriver.Leave"
 	endpointOperInfoPath = "/NetworkDriver.EndpointOperInfo"
 
+	// Libnetwork network plugin remote API paths not used by local scope drivers.
+	allocateNetworkPath             = "/NetworkDriver.AllocateNetwork"
+	freeNetworkPath                 = "/NetworkDriver.FreeNetwork"
+	discoverNewPath                 = "/NetworkDriver.DiscoverNew"
+	discoverDeletePath              = "/NetworkDriver.DiscoverDelete"
+	programExternalConnectivityPath = "/NetworkDriver.ProgramExternalConnectivity"
+	revokeExternalConnectivityPath  = "/NetworkDriver.RevokeExternalConnectivity"
+
 	// Libnetwork network plugin options
 	modeOption = "com.microsoft.azure.network.mode"
 )
@@ -147,3 +155,8 @@ type endpointOperInfoResponse struct {
 	Err   string
 	Value map[string]interface{}
 }
+
+// Response sent by plugin for requests that don't apply to the driver.
+type noopResponse struct {
+	Err string
+}
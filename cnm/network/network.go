@@ -6,6 +6,7 @@ package network
 import (
 	"net"
 	"net/http"
+	"time"
 
 	"github.com/Azure/azure-container-networking/cnm"
 	"github.com/Azure/azure-container-networking/common"
@@ -25,13 +26,17 @@ const (
 	containerInterfacePrefix = "eth"
 	returnCode               = 0
 	returnStr                = "Success"
+
+	// Interval between stale endpoint collections.
+	staleEndpointGCInterval = 10 * time.Minute
 )
 
 // NetPlugin represents a CNM (libnetwork) network plugin.
 type netPlugin struct {
 	*cnm.Plugin
-	scope string
-	nm    network.NetworkManager
+	scope  string
+	nm     network.NetworkManager
+	stopGC chan struct{}
 }
 
 type NetPlugin interface {
@@ -58,6 +63,7 @@ func NewPlugin(config *common.PluginConfig) (NetPlugin, error) {
 		Plugin: plugin,
 		scope:  scope,
 		nm:     nm,
+		stopGC: make(chan struct{}),
 	}, nil
 }
 
@@ -89,6 +95,18 @@ func (plugin *netPlugin) Start(config *common.PluginConfig) error {
 	listener.AddHandler(leavePath, plugin.leave)
 	listener.AddHandler(endpointOperInfoPath, plugin.endpointOperInfo)
 
+	// Docker calls these on every driver, but they have no effect for a local driver.
+	for _, path := range []string{
+		allocateNetworkPath,
+		freeNetworkPath,
+		discoverNewPath,
+		discoverDeletePath,
+		programExternalConnectivityPath,
+		revokeExternalConnectivityPath,
+	} {
+		listener.AddHandler(path, plugin.noop)
+	}
+
 	// Plugin is ready to be discovered.
 	err = plugin.EnableDiscovery()
 	if err != nil {
@@ -96,6 +114,8 @@ func (plugin *netPlugin) Start(config *common.PluginConfig) error {
 		return err
 	}
 
+	go plugin.collectStaleEndpoints()
+
 	log.Printf("[net] Plugin started.")
 
 	return nil
@@ -103,12 +123,31 @@ func (plugin *netPlugin) Start(config *common.PluginConfig) error {
 
 // Stop stops the plugin.
 func (plugin *netPlugin) Stop() {
+	close(plugin.stopGC)
 	plugin.DisableDiscovery()
 	plugin.nm.Uninitialize()
 	plugin.Uninitialize()
 	log.Printf("[net] Plugin stopped.")
 }
 
+// collectStaleEndpoints periodically deletes the endpoints whose interfaces or sandboxes no longer exist.
+func (plugin *netPlugin) collectStaleEndpoints() {
+	ticker := time.NewTicker(staleEndpointGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-plugin.stopGC:
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := plugin.nm.GarbageCollectEndpoints(0); err != nil {
+			log.Printf("[net] Failed to delete stale endpoints: %v.", err)
+		}
+	}
+}
+
 //
 // Libnetwork remote network API implementation
 // https://github.com/docker/libnetwork/blob/master/docs/remote.md
@@ -217,20 +256,23 @@ func (plugin *netPlugin) createEndpoint(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Process request.
-	var ipv4Address *net.IPNet
-	if req.Interface.Address != "" {
-		var ip net.IP
-		ip, ipv4Address, err = net.ParseCIDR(req.Interface.Address)
+	epInfo := network.EndpointInfo{
+		Id: req.EndpointID,
+	}
+
+	for _, address := range []string{req.Interface.Address, req.Interface.AddressIPv6} {
+		if address == "" {
+			continue
+		}
+
+		ip, ipAddress, err := net.ParseCIDR(address)
 		if err != nil {
 			plugin.SendErrorResponse(w, err)
 			return
 		}
-		ipv4Address.IP = ip
-	}
+		ipAddress.IP = ip
 
-	epInfo := network.EndpointInfo{
-		Id:          req.EndpointID,
-		IPAddresses: []net.IPNet{*ipv4Address},
+		epInfo.IPAddresses = append(epInfo.IPAddresses, *ipAddress)
 	}
 
 	epInfo.Data = make(map[string]interface{})
@@ -292,6 +334,12 @@ func (plugin *netPlugin) join(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	nwInfo, err := plugin.nm.GetNetworkInfo(req.NetworkID)
+	if err != nil {
+		plugin.SendErrorResponse(w, err)
+		return
+	}
+
 	// Encode response.
 	ifname := interfaceName{
 		SrcName:   ep.IfName,
@@ -300,9 +348,10 @@ func (plugin *netPlugin) join(w http.ResponseWriter, r *http.Request) {
 
 	resp := joinResponse{
 		InterfaceName: ifname,
-		Gateway:       ep.Gateways[0].String(),
 	}
 
+	resp.Gateway, resp.GatewayIPv6 = getEndpointGateways(nwInfo, ep.IPAddresses, ep.Gateways)
+
 	err = plugin.Listener.Encode(w, &resp)
 
 	log.Response(plugin.Name, &resp, returnCode, returnStr, err)
@@ -357,3 +406,45 @@ func (plugin *netPlugin) endpointOperInfo(w http.ResponseWriter, r *http.Request
 
 	log.Response(plugin.Name, &resp, returnCode, returnStr, err)
 }
+
+// Handles requests that have no effect for the driver.
+func (plugin *netPlugin) noop(w http.ResponseWriter, r *http.Request) {
+	var req map[string]interface{}
+
+	// Decode request.
+	err := plugin.Listener.Decode(w, r, &req)
+	log.Request(plugin.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	// Encode response.
+	resp := noopResponse{}
+	err = plugin.Listener.Encode(w, &resp)
+
+	log.Response(plugin.Name, &resp, returnCode, returnStr, err)
+}
+
+// getEndpointGateways returns the IPv4 and IPv6 gateways of an endpoint with the given addresses.
+// The IPv6 gateway is the one of the network subnet containing the endpoint's IPv6 address.
+func getEndpointGateways(nwInfo *network.NetworkInfo, ipAddresses []net.IPNet, gateways []net.IP) (string, string) {
+	var gateway, gatewayIPv6 string
+
+	for _, ipAddr := range ipAddresses {
+		if ipAddr.IP.To4() != nil {
+			if gateway == "" && len(gateways) > 0 && gateways[0].To4() != nil {
+				gateway = gateways[0].String()
+			}
+			continue
+		}
+
+		for _, subnet := range nwInfo.Subnets {
+			if gatewayIPv6 == "" && subnet.Family == platform.AfINET6 &&
+				subnet.Gateway != nil && subnet.Prefix.Contains(ipAddr.IP) {
+				gatewayIPv6 = subnet.Gateway.String()
+			}
+		}
+	}
+
+	return gateway, gatewayIPv6
+}
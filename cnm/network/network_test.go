@@ -16,6 +16,8 @@ import (
 	"github.com/Azure/azure-container-networking/cnm"
 	"github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/netlink"
+	"github.com/Azure/azure-container-networking/network"
+	"github.com/Azure/azure-container-networking/platform"
 	driverApi "github.com/docker/libnetwork/driverapi"
 	remoteApi "github.com/docker/libnetwork/drivers/remote/api"
 )
@@ -259,3 +261,56 @@ func TestDeleteNetwork(t *testing.T) {
 		t.Errorf("DeleteNetwork response is invalid %+v", resp)
 	}
 }
+
+// Tests the gateways returned for dual-stack endpoints.
+func TestGetEndpointGateways(t *testing.T) {
+	_, v4Prefix, _ := net.ParseCIDR("10.0.0.0/24")
+	_, v6Prefix, _ := net.ParseCIDR("fd00::/64")
+
+	nwInfo := &network.NetworkInfo{
+		Subnets: []network.SubnetInfo{
+			{Family: platform.AfINET, Prefix: *v4Prefix, Gateway: net.ParseIP("10.0.0.1")},
+			{Family: platform.AfINET6, Prefix: *v6Prefix, Gateway: net.ParseIP("fd00::1")},
+		},
+	}
+
+	v4Address := net.IPNet{IP: net.ParseIP("10.0.0.4"), Mask: v4Prefix.Mask}
+	v6Address := net.IPNet{IP: net.ParseIP("fd00::4"), Mask: v6Prefix.Mask}
+	gateways := []net.IP{net.ParseIP("10.0.0.1")}
+
+	gw, gwIPv6 := getEndpointGateways(nwInfo, []net.IPNet{v4Address, v6Address}, gateways)
+	if gw != "10.0.0.1" || gwIPv6 != "fd00::1" {
+		t.Errorf("Unexpected dual-stack gateways %q %q", gw, gwIPv6)
+	}
+
+	gw, gwIPv6 = getEndpointGateways(nwInfo, []net.IPNet{v6Address}, gateways)
+	if gw != "" || gwIPv6 != "fd00::1" {
+		t.Errorf("Unexpected IPv6-only gateways %q %q", gw, gwIPv6)
+	}
+
+	gw, gwIPv6 = getEndpointGateways(nwInfo, []net.IPNet{v4Address}, gateways)
+	if gw != "10.0.0.1" || gwIPv6 != "" {
+		t.Errorf("Unexpected IPv4-only gateways %q %q", gw, gwIPv6)
+	}
+}
+
+// Tests the NetworkDriver requests that have no effect for a local driver.
+func TestNoopRequests(t *testing.T) {
+	for _, path := range []string{discoverNewPath, programExternalConnectivityPath, revokeExternalConnectivityPath} {
+		var resp remoteApi.Response
+
+		body := bytes.NewBufferString(`{"NetworkID":"` + networkID + `","EndpointID":"` + endpointID + `"}`)
+		req, err := http.NewRequest(http.MethodPost, path, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		err = decodeResponse(w, &resp)
+		if err != nil || resp.Err != "" {
+			t.Errorf("%s response is invalid %+v", path, resp)
+		}
+	}
+}
@@ -66,6 +66,7 @@ func (plugin *Plugin) Initialize(config *common.PluginConfig) error {
 	}
 
 	plugin.Listener = config.Listener
+	plugin.Listener.AdvertiseCapabilities(plugin.Plugin)
 
 	return nil
 }
@@ -10,6 +10,20 @@ const (
 	GetNetworkContainerStatus                = "/network/getnetworkcontainerstatus"
 	GetInterfaceForContainer                 = "/network/getinterfaceforcontainer"
 	GetNetworkContainerByOrchestratorContext = "/network/getnetworkcontainerbyorchestratorcontext"
+	ReleaseNetworkContainer                  = "/network/releasenetworkcontainer"
+)
+
+// Goal state stream served by DNC, relative to its base URL.
+const (
+	GoalStateWatchPath = "/network/goalstate/watch"
+	GoalStateAckPath   = "/network/goalstate/ack"
+)
+
+// Goal state message types
+const (
+	GoalStateSnapshot  = "Snapshot"
+	GoalStateDelta     = "Delta"
+	GoalStateHeartbeat = "Heartbeat"
 )
 
 // NetworkContainer Types
@@ -17,8 +31,14 @@ const (
 	AzureContainerInstance = "AzureContainerInstance"
 	WebApps                = "WebApps"
 	ClearContainer         = "ClearContainer"
+	DelegatedNIC           = "DelegatedNIC"
+	AttachedNIC            = "AttachedNIC"
 )
 
+// DelegatedNICResourceName is the extended resource of nodes counting their delegated NICs.
+// Pods requesting it are attached to a delegated NIC network container by the CNI.
+const DelegatedNICResourceName = "networking.azure.com/delegated-nic"
+
 // Orchestrator Types
 const (
 	Kubernetes    = "Kubernetes"
@@ -44,6 +64,7 @@ type CreateNetworkContainerRequest struct {
 	MultiTenancyInfo           MultiTenancyInfo
 	CnetAddressSpace           []IPSubnet // To setup SNAT (should include service endpoint vips).
 	Routes                     []Route
+	MACAddress                 string // Host NIC moved into the pod of AttachedNIC network containers.
 }
 
 // KubernetesPodInfo is an OrchestratorContext that holds PodName and PodNamespace.
@@ -108,6 +129,11 @@ type GetNetworkContainerRequest struct {
 	OrchestratorContext json.RawMessage
 }
 
+// ReleaseNetworkContainerRequest specifies the pod whose delegated NIC network container is released.
+type ReleaseNetworkContainerRequest struct {
+	OrchestratorContext json.RawMessage
+}
+
 // GetNetworkContainerResponse describes the response to retrieve a specifc network container.
 type GetNetworkContainerResponse struct {
 	IPConfiguration            IPConfiguration
@@ -116,6 +142,22 @@ type GetNetworkContainerResponse struct {
 	MultiTenancyInfo           MultiTenancyInfo
 	PrimaryInterfaceIdentifier string
 	LocalIPConfiguration       IPConfiguration
+	NetworkContainerType       string
+	MACAddress                 string
+	Response                   Response
+}
+
+// GetNetworkContainerResponseV2 describes a network container in API version v2, where network
+// containers may hold several IP configurations, the primary one first.
+type GetNetworkContainerResponseV2 struct {
+	IPConfigurations           []IPConfiguration
+	Routes                     []Route
+	CnetAddressSpace           []IPSubnet
+	MultiTenancyInfo           MultiTenancyInfo
+	PrimaryInterfaceIdentifier string
+	LocalIPConfiguration       IPConfiguration
+	NetworkContainerType       string
+	MACAddress                 string
 	Response                   Response
 }
 
@@ -148,3 +190,21 @@ type NetworkInterface struct {
 	Name      string
 	IPAddress string
 }
+
+// GoalStateMessage is a message of the goal state stream from DNC to CNS. A snapshot holds every
+// network container of the node. A delta holds the network containers created, updated or deleted
+// since BaseVersion. Heartbeats keep idle streams alive.
+type GoalStateMessage struct {
+	Type                       string
+	Version                    int64
+	BaseVersion                int64
+	NetworkContainers          []CreateNetworkContainerRequest
+	DeletedNetworkContainerIDs []string
+}
+
+// GoalStateAck acknowledges the version of the goal state applied by CNS, with the errors of the
+// network containers that failed to apply, keyed by network container ID.
+type GoalStateAck struct {
+	Version int64
+	Errors  map[string]string
+}
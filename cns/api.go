@@ -3,6 +3,11 @@
 
 package cns
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // Container Network Service remote API Contract
 const (
 	SetEnvironmentPath          = "/network/environment"
@@ -14,10 +19,87 @@ const (
 	GetIPAddressUtilizationPath = "/network/ip/utilization"
 	GetUnhealthyIPAddressesPath = "/network/ipaddresses/unhealthy"
 	GetHealthReportPath         = "/network/health"
+	RequestIPConfigPath         = "/network/requestipconfig"
+	ReleaseIPConfigPath         = "/network/releaseipconfig"
+	GetClientStatePath          = "/network/clientstate/get"
+	SetClientStatePath          = "/network/clientstate/set"
+	GetHomeAzPath               = "/network/homeaz"
+	NegotiateAPIVersionPath     = "/network/apiversion/negotiate"
+	ReserveEgressIPPath         = "/network/egressip/reserve"
+	ReleaseEgressIPPath         = "/network/egressip/release"
+	GetOverlayVtepsPath         = "/network/overlay/vteps/get"
+	SetOverlayVtepsPath         = "/network/overlay/vteps/set"
 	V1Prefix                    = "/v0.1"
 	V2Prefix                    = "/v0.2"
+	APIV2Prefix                 = "/v2"
+)
+
+// ServiceName is the name CNS advertises its capabilities with.
+const ServiceName = "azure-cns"
+
+// Features advertised by CNS to its clients.
+const (
+	FeatureRequestIPConfig                       = "RequestIPConfig"
+	FeatureClientState                           = "ClientState"
+	FeatureNetworkContainerByOrchestratorContext = "NetworkContainerByOrchestratorContext"
+	FeatureHomeAz                                = "HomeAz"
+	FeatureDelegatedNIC                          = "DelegatedNIC"
+	FeatureEgressIP                              = "EgressIP"
+	FeatureOverlayVteps                          = "OverlayVteps"
 )
 
+// APIVersions are the versions of the remote API served by CNS.
+var APIVersions = []string{V1Prefix[1:], V2Prefix[1:], APIV2Prefix[1:]}
+
+// NegotiateAPIVersionRequest lists the API versions a client supports, preferred first.
+type NegotiateAPIVersionRequest struct {
+	APIVersions []string
+}
+
+// NegotiateAPIVersionResponse is the API version CNS chose for a client, and the prefix of its routes.
+type NegotiateAPIVersionResponse struct {
+	APIVersion string
+	Prefix     string
+	Response   Response
+}
+
+// Features are the features advertised by CNS.
+var Features = []string{
+	FeatureRequestIPConfig,
+	FeatureClientState,
+	FeatureNetworkContainerByOrchestratorContext,
+	FeatureHomeAz,
+	FeatureDelegatedNIC,
+	FeatureEgressIP,
+	FeatureOverlayVteps,
+}
+
+// HealthReportResponse describes the health of CNS, with the TLS certificate it serves, if any.
+type HealthReportResponse struct {
+	Certificate *CertificateStatus `json:",omitempty"`
+	Response    Response
+}
+
+// CertificateStatus describes the expiry of a TLS certificate.
+type CertificateStatus struct {
+	NotAfter         time.Time
+	ExpiresInSeconds int64
+}
+
+// HomeAzResponse describes the home availability zone of the node, if the host agent supports
+// reporting it, and the APIs the host agent supports.
+type HomeAzResponse struct {
+	IsSupported   bool
+	HomeAz        uint
+	SupportedAPIs []string
+}
+
+// GetHomeAzResponse describes the response to the home availability zone request.
+type GetHomeAzResponse struct {
+	HomeAzResponse HomeAzResponse
+	Response       Response
+}
+
 // SetEnvironmentRequest describes the Request to set the environment in CNS.
 type SetEnvironmentRequest struct {
 	Location    string
@@ -60,6 +142,63 @@ type ReleaseIPAddressRequest struct {
 	ReservationID string
 }
 
+// IPConfigRequest describes request to reserve or release an IP configuration for a pod interface.
+type IPConfigRequest struct {
+	PodInterfaceID      string
+	OrchestratorContext json.RawMessage
+}
+
+// IPConfigResponse describes response to reserve an IP configuration.
+type IPConfigResponse struct {
+	Response        Response
+	IPConfiguration IPConfiguration
+}
+
+// EgressIPRequest describes request to reserve a secondary address of the node as the egress
+// address of a pod interface, or to release the egress address of a pod interface.
+type EgressIPRequest struct {
+	PodInterfaceID string
+	IPAddress      string `json:",omitempty"`
+}
+
+// OverlayVtep describes the VTEP of a node in an overlay network, and the pod prefix it serves.
+type OverlayVtep struct {
+	NodeIP    string
+	PodPrefix string
+}
+
+// GetOverlayVtepsRequest describes request to get the VTEPs of the nodes in the overlay network.
+type GetOverlayVtepsRequest struct{}
+
+// GetOverlayVtepsResponse describes response containing the VTEPs of the nodes in the overlay network.
+type GetOverlayVtepsResponse struct {
+	Response Response
+	Vteps    []OverlayVtep
+}
+
+// SetOverlayVtepsRequest describes request to replace the VTEPs of the nodes in the overlay network.
+type SetOverlayVtepsRequest struct {
+	Vteps []OverlayVtep
+}
+
+// GetClientStateRequest describes request to read state persisted in CNS on behalf of a client.
+type GetClientStateRequest struct {
+	Key string
+}
+
+// GetClientStateResponse describes response containing client state persisted in CNS.
+type GetClientStateResponse struct {
+	Response  Response
+	Value     json.RawMessage
+	TimeStamp time.Time
+}
+
+// SetClientStateRequest describes request to persist client state in CNS.
+type SetClientStateRequest struct {
+	Key   string
+	Value json.RawMessage
+}
+
 // IPAddressesUtilizationResponse describes response for ip address utilization.
 type IPAddressesUtilizationResponse struct {
 	Response  Response
@@ -0,0 +1,152 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package bgp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	bgpPort    = 179
+	bgpVersion = 4
+
+	// Sizes of BGP messages, RFC 4271 section 4.
+	markerLength     = 16
+	headerLength     = 19
+	maxMessageLength = 4096
+
+	// BGP message types.
+	msgOpen         = 1
+	msgUpdate       = 2
+	msgNotification = 3
+	msgKeepalive    = 4
+
+	// Path attributes of the routes advertised, all well-known.
+	attrFlagTransitive = 0x40
+	attrOrigin         = 1
+	attrASPath         = 2
+	attrNextHop        = 3
+	attrLocalPref      = 5
+	originIGP          = 0
+	asPathSequence     = 2
+	defaultLocalPref   = 100
+
+	// Notification error codes.
+	errCodeOpenMessage = 2
+	errCodeHoldTimer   = 4
+	errCodeCease       = 6
+
+	// Maximum number of prefixes in an update, so that it stays below the maximum message length.
+	maxUpdatePrefixes = 500
+)
+
+// newMessage returns a BGP message of a type with a body.
+func newMessage(msgType byte, body []byte) []byte {
+	msg := make([]byte, headerLength, headerLength+len(body))
+	for i := 0; i < markerLength; i++ {
+		msg[i] = 0xff
+	}
+
+	binary.BigEndian.PutUint16(msg[markerLength:], uint16(headerLength+len(body)))
+	msg[headerLength-1] = msgType
+
+	return append(msg, body...)
+}
+
+// newOpenMessage returns an OPEN message without optional parameters.
+func newOpenMessage(asn uint16, holdTime uint16, routerID net.IP) []byte {
+	body := make([]byte, 10)
+	body[0] = bgpVersion
+	binary.BigEndian.PutUint16(body[1:], asn)
+	binary.BigEndian.PutUint16(body[3:], holdTime)
+	copy(body[5:9], routerID.To4())
+
+	return newMessage(msgOpen, body)
+}
+
+// newKeepaliveMessage returns a KEEPALIVE message.
+func newKeepaliveMessage() []byte {
+	return newMessage(msgKeepalive, nil)
+}
+
+// newNotificationMessage returns a NOTIFICATION message with an error code and subcode.
+func newNotificationMessage(code byte, subcode byte) []byte {
+	return newMessage(msgNotification, []byte{code, subcode})
+}
+
+// encodePrefix returns the encoding of an IPv4 prefix in the NLRI and withdrawn routes of updates.
+func encodePrefix(prefix net.IPNet) []byte {
+	ones, _ := prefix.Mask.Size()
+	return append([]byte{byte(ones)}, prefix.IP.To4()[:(ones+7)/8]...)
+}
+
+// newUpdateMessage returns an UPDATE message withdrawing and announcing IPv4 prefixes. Announced
+// prefixes originate from the local AS and are reached through the next hop.
+func newUpdateMessage(withdrawn []net.IPNet, announced []net.IPNet, asn uint16, external bool, nextHop net.IP) []byte {
+	var withdrawnRoutes, attrs, nlri []byte
+
+	for _, prefix := range withdrawn {
+		withdrawnRoutes = append(withdrawnRoutes, encodePrefix(prefix)...)
+	}
+
+	if len(announced) > 0 {
+		attrs = append(attrs, attrFlagTransitive, attrOrigin, 1, originIGP)
+
+		// The local AS is prepended to the path of routes sent to external peers only.
+		if external {
+			attrs = append(attrs, attrFlagTransitive, attrASPath, 4, asPathSequence, 1, byte(asn>>8), byte(asn))
+		} else {
+			attrs = append(attrs, attrFlagTransitive, attrASPath, 0)
+		}
+
+		attrs = append(attrs, attrFlagTransitive, attrNextHop, 4)
+		attrs = append(attrs, nextHop.To4()...)
+
+		if !external {
+			attrs = append(attrs, attrFlagTransitive, attrLocalPref, 4, 0, 0, 0, defaultLocalPref)
+		}
+
+		for _, prefix := range announced {
+			nlri = append(nlri, encodePrefix(prefix)...)
+		}
+	}
+
+	body := make([]byte, 0, 4+len(withdrawnRoutes)+len(attrs)+len(nlri))
+	body = append(body, byte(len(withdrawnRoutes)>>8), byte(len(withdrawnRoutes)))
+	body = append(body, withdrawnRoutes...)
+	body = append(body, byte(len(attrs)>>8), byte(len(attrs)))
+	body = append(body, attrs...)
+	body = append(body, nlri...)
+
+	return newMessage(msgUpdate, body)
+}
+
+// readMessage reads a BGP message and returns its type and body.
+func readMessage(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, headerLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	for i := 0; i < markerLength; i++ {
+		if header[i] != 0xff {
+			return 0, nil, fmt.Errorf("Invalid BGP message marker")
+		}
+	}
+
+	length := int(binary.BigEndian.Uint16(header[markerLength:]))
+	if length < headerLength || length > maxMessageLength {
+		return 0, nil, fmt.Errorf("Invalid BGP message length %d", length)
+	}
+
+	body := make([]byte, length-headerLength)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header[headerLength-1], body, nil
+}
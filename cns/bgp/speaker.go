@@ -0,0 +1,344 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package bgp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Hold time proposed to peers, and the interval at which keepalives are sent within it.
+	holdTime          = 90 * time.Second
+	keepalivesPerHold = 3
+
+	// Timeout of connections to peers, and the interval after which a failed session is retried.
+	connectTimeout       = 10 * time.Second
+	connectRetryInterval = 30 * time.Second
+)
+
+// Peer is a BGP peer prefixes are advertised to, such as a top-of-rack router or Azure Route Server.
+type Peer struct {
+	Address net.IP
+	ASN     uint16
+}
+
+// ParsePeers parses a comma-separated list of peers, each an IPv4 address and an AS number
+// separated by a colon, such as "10.0.0.4:65515,10.0.0.5:65515".
+func ParsePeers(s string) ([]Peer, error) {
+	var peers []Peer
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid BGP peer %q, expected address:asn", entry)
+		}
+
+		address := net.ParseIP(parts[0])
+		if address == nil || address.To4() == nil {
+			return nil, fmt.Errorf("Invalid address of BGP peer %q", entry)
+		}
+
+		asn, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil || asn == 0 {
+			return nil, fmt.Errorf("Invalid AS number of BGP peer %q", entry)
+		}
+
+		peers = append(peers, Peer{Address: address.To4(), ASN: uint16(asn)})
+	}
+
+	return peers, nil
+}
+
+// Speaker advertises IPv4 prefixes of the node to BGP peers, with the local address of each session
+// as their next hop. Routes of peers aren't learned. Sessions are retried until the speaker stops.
+type Speaker struct {
+	asn      uint16
+	port     int
+	peers    []Peer
+	prefixes []net.IPNet
+	updated  []chan struct{}
+	stop     chan struct{}
+	sync.Mutex
+}
+
+// NewSpeaker creates a speaker in an AS advertising prefixes to peers.
+func NewSpeaker(asn uint16, peers []Peer) *Speaker {
+	return &Speaker{
+		asn:   asn,
+		port:  bgpPort,
+		peers: peers,
+	}
+}
+
+// Start starts the sessions with the peers.
+func (s *Speaker) Start() {
+	s.stop = make(chan struct{})
+
+	s.Lock()
+	s.updated = nil
+	for _, peer := range s.peers {
+		updated := make(chan struct{}, 1)
+		s.updated = append(s.updated, updated)
+		go s.run(peer, updated, s.stop)
+	}
+	s.Unlock()
+}
+
+// Stop closes the sessions with the peers, which withdraws the prefixes advertised.
+func (s *Speaker) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// SetPrefixes sets the prefixes advertised to the peers.
+func (s *Speaker) SetPrefixes(prefixes []net.IPNet) {
+	s.Lock()
+	s.prefixes = prefixes
+	updated := s.updated
+	s.Unlock()
+
+	// A pending update picks up the latest prefixes.
+	for _, ch := range updated {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// getPrefixes returns the IPv4 prefixes advertised, by their string representation.
+func (s *Speaker) getPrefixes() map[string]net.IPNet {
+	s.Lock()
+	defer s.Unlock()
+
+	prefixes := make(map[string]net.IPNet)
+	for _, prefix := range s.prefixes {
+		if prefix.IP.To4() == nil {
+			continue
+		}
+
+		prefix = net.IPNet{IP: prefix.IP.To4().Mask(prefix.Mask), Mask: prefix.Mask}
+		prefixes[prefix.String()] = prefix
+	}
+
+	return prefixes
+}
+
+// run runs sessions with a peer until the speaker stops.
+func (s *Speaker) run(peer Peer, updated chan struct{}, stop chan struct{}) {
+	for {
+		err := s.runSession(peer, updated, stop)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		log.Printf("[bgp] Session with peer %v failed, retrying in %v, err:%v.", peer.Address, connectRetryInterval, err)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(connectRetryInterval):
+		}
+	}
+}
+
+// runSession connects to a peer, and advertises the prefixes of the speaker until the session fails
+// or the speaker stops.
+func (s *Speaker) runSession(peer Peer, updated chan struct{}, stop chan struct{}) error {
+	address := net.JoinHostPort(peer.Address.String(), strconv.Itoa(s.port))
+	conn, err := net.DialTimeout("tcp", address, connectTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	localIP := conn.LocalAddr().(*net.TCPAddr).IP.To4()
+	if localIP == nil {
+		return fmt.Errorf("Session has no local IPv4 address")
+	}
+
+	hold, err := s.openSession(conn, peer, localIP)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[bgp] Established session with peer %v AS %v.", peer.Address, peer.ASN)
+
+	// Messages of the peer are read until the session fails, as routes of peers aren't learned.
+	failed := make(chan error, 1)
+	go func() {
+		for {
+			if hold > 0 {
+				conn.SetReadDeadline(time.Now().Add(hold))
+			}
+
+			msgType, body, err := readMessage(conn)
+			if err != nil {
+				failed <- err
+				return
+			}
+
+			if msgType == msgNotification && len(body) >= 2 {
+				failed <- fmt.Errorf("Peer sent notification with error code %d subcode %d", body[0], body[1])
+				return
+			}
+		}
+	}()
+
+	var keepalive <-chan time.Time
+	if hold > 0 {
+		ticker := time.NewTicker(hold / keepalivesPerHold)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+
+	external := peer.ASN != s.asn
+	advertised := make(map[string]net.IPNet)
+
+	for {
+		if err = s.sendUpdates(conn, advertised, external, localIP); err != nil {
+			return err
+		}
+
+		select {
+		case <-stop:
+			conn.Write(newNotificationMessage(errCodeCease, 0))
+			return nil
+		case err = <-failed:
+			return err
+		case <-keepalive:
+			if _, err = conn.Write(newKeepaliveMessage()); err != nil {
+				return err
+			}
+		case <-updated:
+		}
+	}
+}
+
+// openSession exchanges OPEN messages with a peer and returns the negotiated hold time.
+func (s *Speaker) openSession(conn net.Conn, peer Peer, localIP net.IP) (time.Duration, error) {
+	conn.SetDeadline(time.Now().Add(holdTime))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write(newOpenMessage(s.asn, uint16(holdTime/time.Second), localIP)); err != nil {
+		return 0, err
+	}
+
+	msgType, body, err := readMessage(conn)
+	if err != nil {
+		return 0, err
+	}
+
+	if msgType != msgOpen || len(body) < 10 {
+		return 0, fmt.Errorf("Expected OPEN message from peer, got message type %d", msgType)
+	}
+
+	if asn := binary.BigEndian.Uint16(body[1:]); asn != peer.ASN {
+		conn.Write(newNotificationMessage(errCodeOpenMessage, 2))
+		return 0, fmt.Errorf("Peer is in AS %d instead of AS %d", asn, peer.ASN)
+	}
+
+	// The smaller hold time of both sides is used, and zero disables keepalives.
+	hold := time.Duration(binary.BigEndian.Uint16(body[3:])) * time.Second
+	if hold > holdTime {
+		hold = holdTime
+	}
+
+	if hold > 0 && hold < 3*time.Second {
+		conn.Write(newNotificationMessage(errCodeOpenMessage, 6))
+		return 0, fmt.Errorf("Unacceptable hold time %v of peer", hold)
+	}
+
+	if _, err = conn.Write(newKeepaliveMessage()); err != nil {
+		return 0, err
+	}
+
+	if msgType, _, err = readMessage(conn); err != nil {
+		return 0, err
+	}
+
+	if msgType != msgKeepalive {
+		return 0, fmt.Errorf("Expected KEEPALIVE message from peer, got message type %d", msgType)
+	}
+
+	return hold, nil
+}
+
+// sendUpdates withdraws the prefixes advertised to a peer that the speaker no longer has, and
+// announces the ones it wasn't advertised yet.
+func (s *Speaker) sendUpdates(conn net.Conn, advertised map[string]net.IPNet, external bool, nextHop net.IP) error {
+	prefixes := s.getPrefixes()
+
+	var withdrawn, announced []net.IPNet
+	for key, prefix := range advertised {
+		if _, ok := prefixes[key]; !ok {
+			withdrawn = append(withdrawn, prefix)
+		}
+	}
+
+	for key, prefix := range prefixes {
+		if _, ok := advertised[key]; !ok {
+			announced = append(announced, prefix)
+		}
+	}
+
+	sortPrefixes(withdrawn)
+	sortPrefixes(announced)
+
+	for len(withdrawn) > 0 || len(announced) > 0 {
+		w := withdrawn
+		if len(w) > maxUpdatePrefixes {
+			w = w[:maxUpdatePrefixes]
+		}
+		withdrawn = withdrawn[len(w):]
+
+		a := announced
+		if len(a) > maxUpdatePrefixes {
+			a = a[:maxUpdatePrefixes]
+		}
+		announced = announced[len(a):]
+
+		if _, err := conn.Write(newUpdateMessage(w, a, s.asn, external, nextHop)); err != nil {
+			return err
+		}
+
+		for _, prefix := range w {
+			log.Printf("[bgp] Withdrew prefix %v.", prefix.String())
+			delete(advertised, prefix.String())
+		}
+
+		for _, prefix := range a {
+			log.Printf("[bgp] Announced prefix %v.", prefix.String())
+			advertised[prefix.String()] = prefix
+		}
+	}
+
+	return nil
+}
+
+// sortPrefixes sorts prefixes by their string representation, so that updates are deterministic.
+func sortPrefixes(prefixes []net.IPNet) {
+	sort.Slice(prefixes, func(i, j int) bool {
+		return prefixes[i].String() < prefixes[j].String()
+	})
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package bgp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// Tests that peers are parsed from their address and AS number.
+func TestParsePeers(t *testing.T) {
+	peers, err := ParsePeers("10.0.0.4:65515, 10.0.0.5:65001")
+	if err != nil {
+		t.Fatalf("Failed to parse peers: %v", err)
+	}
+
+	if len(peers) != 2 || !peers[0].Address.Equal(net.ParseIP("10.0.0.4")) || peers[0].ASN != 65515 || peers[1].ASN != 65001 {
+		t.Errorf("Unexpected peers %+v", peers)
+	}
+
+	for _, invalid := range []string{"10.0.0.4", "10.0.0.4:0", "10.0.0.4:70000", "fd00::1:65515", "peer:65515"} {
+		if _, err = ParsePeers(invalid); err == nil {
+			t.Errorf("Expected peer %q to be invalid", invalid)
+		}
+	}
+}
+
+// Tests the encoding of updates announcing prefixes to an external peer.
+func TestNewUpdateMessage(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("10.1.0.0/16")
+	msg := newUpdateMessage(nil, []net.IPNet{*prefix}, 65000, true, net.ParseIP("192.168.0.4"))
+
+	expected := []byte{
+		0, 0, // No withdrawn routes.
+		0, 18, // Path attributes.
+		0x40, 1, 1, 0, // ORIGIN IGP.
+		0x40, 2, 4, 2, 1, 0xfd, 0xe8, // AS_PATH sequence of AS 65000.
+		0x40, 3, 4, 192, 168, 0, 4, // NEXT_HOP.
+		16, 10, 1, // NLRI.
+	}
+
+	if msg[headerLength-1] != msgUpdate || !bytes.Equal(msg[headerLength:], expected) {
+		t.Errorf("Unexpected update %v", msg)
+	}
+
+	if int(binary.BigEndian.Uint16(msg[markerLength:])) != len(msg) {
+		t.Errorf("Unexpected length of update %v", msg)
+	}
+}
+
+// Tests that a speaker announces its prefixes once the session is established, and withdraws the
+// prefixes it no longer has.
+func TestSpeakerAdvertisesPrefixes(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	s := NewSpeaker(65000, []Peer{{Address: net.ParseIP("127.0.0.1").To4(), ASN: 65515}})
+	s.port = listener.Addr().(*net.TCPAddr).Port
+
+	_, prefix, _ := net.ParseCIDR("10.1.0.0/16")
+	s.SetPrefixes([]net.IPNet{*prefix})
+	s.Start()
+	defer s.Stop()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	read := func(expectedType byte) []byte {
+		msgType, body, err := readMessage(conn)
+		if err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+
+		if msgType != expectedType {
+			t.Fatalf("Expected message type %d, got %d", expectedType, msgType)
+		}
+
+		return body
+	}
+
+	open := read(msgOpen)
+	if asn := binary.BigEndian.Uint16(open[1:]); asn != 65000 {
+		t.Errorf("Expected AS 65000 in OPEN, got %d", asn)
+	}
+
+	conn.Write(newOpenMessage(65515, 30, net.ParseIP("127.0.0.2")))
+	read(msgKeepalive)
+	conn.Write(newKeepaliveMessage())
+
+	update := read(msgUpdate)
+	if !bytes.HasSuffix(update, []byte{16, 10, 1}) {
+		t.Errorf("Expected update announcing %v, got %v", prefix, update)
+	}
+
+	s.SetPrefixes(nil)
+
+	update = read(msgUpdate)
+	if !bytes.Equal(update, []byte{0, 3, 16, 10, 1, 0, 0}) {
+		t.Errorf("Expected update withdrawing %v, got %v", prefix, update)
+	}
+}
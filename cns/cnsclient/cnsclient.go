@@ -3,22 +3,32 @@ package cnsclient
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/Azure/azure-container-networking/cns"
+	acn "github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/trace"
 )
 
 // CNSClient specifies a client to connect to Ipam Plugin.
 type CNSClient struct {
 	connectionURL string
+	spanContext   trace.SpanContext
+	capabilities  *acn.Capabilities
+	apiPrefix     *string
 }
 
 const (
 	defaultCnsURL = "http://localhost:10090"
 )
 
+// API versions of CNS the client speaks, preferred first.
+var apiVersions = []string{cns.APIV2Prefix[1:], cns.V1Prefix[1:]}
+
 // NewCnsClient create a new cns client.
 func NewCnsClient(url string) (*CNSClient, error) {
 	if url == "" {
@@ -30,25 +40,179 @@ func NewCnsClient(url string) (*CNSClient, error) {
 	}, nil
 }
 
+// SetSpanContext sets the span requests are traced as part of.
+func (cnsClient *CNSClient) SetSpanContext(sc trace.SpanContext) {
+	cnsClient.spanContext = sc
+}
+
+// post posts a JSON request, propagating the span of the client.
+func (cnsClient *CNSClient) post(httpc *http.Client, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	trace.Inject(req, cnsClient.spanContext)
+
+	return httpc.Do(req)
+}
+
+// GetCapabilities queries the API versions and features supported by CNS.
+// CNS versions that predate capability negotiation advertise no features.
+func (cnsClient *CNSClient) GetCapabilities() (*acn.Capabilities, error) {
+	if cnsClient.capabilities != nil {
+		return cnsClient.capabilities, nil
+	}
+
+	httpc := &http.Client{}
+	url := cnsClient.connectionURL + acn.CapabilitiesPath
+	log.Printf("GetCapabilities url %v", url)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	trace.Inject(req, cnsClient.spanContext)
+
+	res, err := httpc.Do(req)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] HTTP Get returned error %v", err.Error())
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	capabilities := &acn.Capabilities{Name: cns.ServiceName}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		var resp acn.CapabilitiesResponse
+
+		err = json.NewDecoder(res.Body).Decode(&resp)
+		if err != nil {
+			log.Errorf("[Azure CNSClient] Error received while parsing GetCapabilities response resp:%v err:%v", res.Body, err.Error())
+			return nil, err
+		}
+
+		if c := resp.Get(cns.ServiceName); c != nil {
+			capabilities = c
+		}
+
+	case http.StatusNotFound:
+		log.Printf("[Azure CNSClient] CNS does not advertise its capabilities.")
+
+	default:
+		errMsg := fmt.Sprintf("[Azure CNSClient] GetCapabilities invalid http status code: %v", res.StatusCode)
+		log.Errorf("%s", errMsg)
+		return nil, errors.New(errMsg)
+	}
+
+	log.Printf("[Azure CNSClient] CNS capabilities %+v", capabilities)
+	cnsClient.capabilities = capabilities
+
+	return capabilities, nil
+}
+
+// SupportsFeature checks if CNS supports a feature.
+func (cnsClient *CNSClient) SupportsFeature(feature string) (bool, error) {
+	capabilities, err := cnsClient.GetCapabilities()
+	if err != nil {
+		return false, err
+	}
+
+	return capabilities.Supports(feature), nil
+}
+
+// NegotiateAPIVersion agrees with CNS on the API version to use, and returns the prefix of its
+// routes. CNS versions that predate negotiation are talked to without a prefix.
+func (cnsClient *CNSClient) NegotiateAPIVersion() (string, error) {
+	if cnsClient.apiPrefix != nil {
+		return *cnsClient.apiPrefix, nil
+	}
+
+	var body bytes.Buffer
+
+	httpc := &http.Client{}
+	url := cnsClient.connectionURL + cns.NegotiateAPIVersionPath
+	log.Printf("NegotiateAPIVersion url %v", url)
+
+	payload := &cns.NegotiateAPIVersionRequest{
+		APIVersions: apiVersions,
+	}
+
+	err := json.NewEncoder(&body).Encode(payload)
+	if err != nil {
+		log.Errorf("encoding json failed with %v", err)
+		return "", err
+	}
+
+	res, err := cnsClient.post(httpc, url, &body)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] HTTP Post returned error %v", err.Error())
+		return "", err
+	}
+
+	defer res.Body.Close()
+
+	prefix := ""
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		var resp cns.NegotiateAPIVersionResponse
+
+		err = json.NewDecoder(res.Body).Decode(&resp)
+		if err != nil {
+			log.Errorf("[Azure CNSClient] Error received while parsing NegotiateAPIVersion response resp:%v err:%v", res.Body, err.Error())
+			return "", err
+		}
+
+		if resp.Response.ReturnCode != 0 {
+			log.Errorf("[Azure CNSClient] NegotiateAPIVersion received error response :%v", resp.Response.Message)
+			return "", errors.New(resp.Response.Message)
+		}
+
+		prefix = resp.Prefix
+
+	case http.StatusNotFound:
+		log.Printf("[Azure CNSClient] CNS does not negotiate API versions.")
+
+	default:
+		errMsg := fmt.Sprintf("[Azure CNSClient] NegotiateAPIVersion invalid http status code: %v", res.StatusCode)
+		log.Errorf("%s", errMsg)
+		return "", errors.New(errMsg)
+	}
+
+	log.Printf("[Azure CNSClient] Using CNS API prefix %q", prefix)
+	cnsClient.apiPrefix = &prefix
+
+	return prefix, nil
+}
+
 // GetNetworkConfiguration Request to get network config.
 func (cnsClient *CNSClient) GetNetworkConfiguration(orchestratorContext []byte) (*cns.GetNetworkContainerResponse, error) {
 	var body bytes.Buffer
 
+	prefix, err := cnsClient.NegotiateAPIVersion()
+	if err != nil {
+		return nil, err
+	}
+
 	httpc := &http.Client{}
-	url := cnsClient.connectionURL + cns.GetNetworkContainerByOrchestratorContext
+	url := cnsClient.connectionURL + prefix + cns.GetNetworkContainerByOrchestratorContext
 	log.Printf("GetNetworkConfiguration url %v", url)
 
 	payload := &cns.GetNetworkContainerRequest{
 		OrchestratorContext: orchestratorContext,
 	}
 
-	err := json.NewEncoder(&body).Encode(payload)
+	err = json.NewEncoder(&body).Encode(payload)
 	if err != nil {
 		log.Errorf("encoding json failed with %v", err)
 		return nil, err
 	}
 
-	res, err := httpc.Post(url, "application/json", &body)
+	res, err := cnsClient.post(httpc, url, &body)
 	if err != nil {
 		log.Errorf("[Azure CNSClient] HTTP Post returned error %v", err.Error())
 		return nil, err
@@ -59,12 +223,36 @@ func (cnsClient *CNSClient) GetNetworkConfiguration(orchestratorContext []byte)
 	if res.StatusCode != http.StatusOK {
 		errMsg := fmt.Sprintf("[Azure CNSClient] GetNetworkConfiguration invalid http status code: %v", res.StatusCode)
 		log.Errorf(errMsg)
-		return nil, fmt.Errorf(errMsg)
+		return nil, errors.New(errMsg)
 	}
 
 	var resp cns.GetNetworkContainerResponse
 
-	err = json.NewDecoder(res.Body).Decode(&resp)
+	if prefix == cns.APIV2Prefix {
+		var respV2 cns.GetNetworkContainerResponseV2
+
+		err = json.NewDecoder(res.Body).Decode(&respV2)
+		if err == nil {
+			resp = cns.GetNetworkContainerResponse{
+				Routes:                     respV2.Routes,
+				CnetAddressSpace:           respV2.CnetAddressSpace,
+				MultiTenancyInfo:           respV2.MultiTenancyInfo,
+				PrimaryInterfaceIdentifier: respV2.PrimaryInterfaceIdentifier,
+				LocalIPConfiguration:       respV2.LocalIPConfiguration,
+				NetworkContainerType:       respV2.NetworkContainerType,
+				MACAddress:                 respV2.MACAddress,
+				Response:                   respV2.Response,
+			}
+
+			// Pods are attached to the primary IP configuration.
+			if len(respV2.IPConfigurations) > 0 {
+				resp.IPConfiguration = respV2.IPConfigurations[0]
+			}
+		}
+	} else {
+		err = json.NewDecoder(res.Body).Decode(&resp)
+	}
+
 	if err != nil {
 		log.Errorf("[Azure CNSClient] Error received while parsing GetNetworkConfiguration response resp:%v err:%v", res.Body, err.Error())
 		return nil, err
@@ -72,8 +260,261 @@ func (cnsClient *CNSClient) GetNetworkConfiguration(orchestratorContext []byte)
 
 	if resp.Response.ReturnCode != 0 {
 		log.Errorf("[Azure CNSClient] GetNetworkConfiguration received error response :%v", resp.Response.Message)
-		return nil, fmt.Errorf(resp.Response.Message)
+		return nil, errors.New(resp.Response.Message)
+	}
+
+	return &resp, nil
+}
+
+// RequestIPAddress requests an ip configuration for the given pod interface from CNS.
+func (cnsClient *CNSClient) RequestIPAddress(podInterfaceID string, orchestratorContext []byte) (*cns.IPConfigResponse, error) {
+	var body bytes.Buffer
+
+	httpc := &http.Client{}
+	url := cnsClient.connectionURL + cns.RequestIPConfigPath
+	log.Printf("RequestIPAddress url %v", url)
+
+	payload := &cns.IPConfigRequest{
+		PodInterfaceID:      podInterfaceID,
+		OrchestratorContext: orchestratorContext,
+	}
+
+	err := json.NewEncoder(&body).Encode(payload)
+	if err != nil {
+		log.Errorf("encoding json failed with %v", err)
+		return nil, err
+	}
+
+	res, err := cnsClient.post(httpc, url, &body)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] HTTP Post returned error %v", err.Error())
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		errMsg := fmt.Sprintf("[Azure CNSClient] RequestIPAddress invalid http status code: %v", res.StatusCode)
+		log.Errorf("%s", errMsg)
+		return nil, errors.New(errMsg)
+	}
+
+	var resp cns.IPConfigResponse
+
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] Error received while parsing RequestIPAddress response resp:%v err:%v", res.Body, err.Error())
+		return nil, err
+	}
+
+	if resp.Response.ReturnCode != 0 {
+		log.Errorf("[Azure CNSClient] RequestIPAddress received error response :%v", resp.Response.Message)
+		return nil, errors.New(resp.Response.Message)
 	}
 
 	return &resp, nil
 }
+
+// ReleaseIPAddress releases the ip configuration held by the given pod interface back to CNS.
+func (cnsClient *CNSClient) ReleaseIPAddress(podInterfaceID string, orchestratorContext []byte) error {
+	var body bytes.Buffer
+
+	httpc := &http.Client{}
+	url := cnsClient.connectionURL + cns.ReleaseIPConfigPath
+	log.Printf("ReleaseIPAddress url %v", url)
+
+	payload := &cns.IPConfigRequest{
+		PodInterfaceID:      podInterfaceID,
+		OrchestratorContext: orchestratorContext,
+	}
+
+	err := json.NewEncoder(&body).Encode(payload)
+	if err != nil {
+		log.Errorf("encoding json failed with %v", err)
+		return err
+	}
+
+	res, err := cnsClient.post(httpc, url, &body)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] HTTP Post returned error %v", err.Error())
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		errMsg := fmt.Sprintf("[Azure CNSClient] ReleaseIPAddress invalid http status code: %v", res.StatusCode)
+		log.Errorf("%s", errMsg)
+		return errors.New(errMsg)
+	}
+
+	var resp cns.Response
+
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] Error received while parsing ReleaseIPAddress response resp:%v err:%v", res.Body, err.Error())
+		return err
+	}
+
+	if resp.ReturnCode != 0 {
+		log.Errorf("[Azure CNSClient] ReleaseIPAddress received error response :%v", resp.Message)
+		return errors.New(resp.Message)
+	}
+
+	return nil
+}
+
+// ReleaseNetworkContainer releases the delegated NIC network container reserved for a pod, if any.
+func (cnsClient *CNSClient) ReleaseNetworkContainer(orchestratorContext []byte) error {
+	var body bytes.Buffer
+
+	httpc := &http.Client{}
+	url := cnsClient.connectionURL + cns.ReleaseNetworkContainer
+	log.Printf("ReleaseNetworkContainer url %v", url)
+
+	payload := &cns.ReleaseNetworkContainerRequest{
+		OrchestratorContext: orchestratorContext,
+	}
+
+	err := json.NewEncoder(&body).Encode(payload)
+	if err != nil {
+		log.Errorf("encoding json failed with %v", err)
+		return err
+	}
+
+	res, err := cnsClient.post(httpc, url, &body)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] HTTP Post returned error %v", err.Error())
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		errMsg := fmt.Sprintf("[Azure CNSClient] ReleaseNetworkContainer invalid http status code: %v", res.StatusCode)
+		log.Errorf("%s", errMsg)
+		return errors.New(errMsg)
+	}
+
+	var resp cns.Response
+
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] Error received while parsing ReleaseNetworkContainer response resp:%v err:%v", res.Body, err.Error())
+		return err
+	}
+
+	if resp.ReturnCode != 0 {
+		log.Errorf("[Azure CNSClient] ReleaseNetworkContainer received error response :%v", resp.Message)
+		return errors.New(resp.Message)
+	}
+
+	return nil
+}
+
+// ReserveEgressIP reserves a secondary address of the node as the egress address of a pod interface.
+func (cnsClient *CNSClient) ReserveEgressIP(podInterfaceID string, ipAddress string) error {
+	payload := &cns.EgressIPRequest{
+		PodInterfaceID: podInterfaceID,
+		IPAddress:      ipAddress,
+	}
+
+	return cnsClient.postEgressIPRequest("ReserveEgressIP", cns.ReserveEgressIPPath, payload)
+}
+
+// ReleaseEgressIP releases the egress address of a pod interface, if any.
+func (cnsClient *CNSClient) ReleaseEgressIP(podInterfaceID string) error {
+	payload := &cns.EgressIPRequest{
+		PodInterfaceID: podInterfaceID,
+	}
+
+	return cnsClient.postEgressIPRequest("ReleaseEgressIP", cns.ReleaseEgressIPPath, payload)
+}
+
+// postEgressIPRequest posts an egress address request to CNS.
+func (cnsClient *CNSClient) postEgressIPRequest(name string, path string, payload *cns.EgressIPRequest) error {
+	var body bytes.Buffer
+
+	httpc := &http.Client{}
+	url := cnsClient.connectionURL + path
+	log.Printf("%s url %v", name, url)
+
+	err := json.NewEncoder(&body).Encode(payload)
+	if err != nil {
+		log.Errorf("encoding json failed with %v", err)
+		return err
+	}
+
+	res, err := cnsClient.post(httpc, url, &body)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] HTTP Post returned error %v", err.Error())
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		errMsg := fmt.Sprintf("[Azure CNSClient] %s invalid http status code: %v", name, res.StatusCode)
+		log.Errorf("%s", errMsg)
+		return errors.New(errMsg)
+	}
+
+	var resp cns.Response
+
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] Error received while parsing %s response resp:%v err:%v", name, res.Body, err.Error())
+		return err
+	}
+
+	if resp.ReturnCode != 0 {
+		log.Errorf("[Azure CNSClient] %s received error response :%v", name, resp.Message)
+		return errors.New(resp.Message)
+	}
+
+	return nil
+}
+
+// GetOverlayVteps gets the VTEPs of the nodes in the overlay network from CNS.
+func (cnsClient *CNSClient) GetOverlayVteps() ([]cns.OverlayVtep, error) {
+	var body bytes.Buffer
+
+	httpc := &http.Client{}
+	url := cnsClient.connectionURL + cns.GetOverlayVtepsPath
+	log.Printf("GetOverlayVteps url %v", url)
+
+	err := json.NewEncoder(&body).Encode(&cns.GetOverlayVtepsRequest{})
+	if err != nil {
+		log.Errorf("encoding json failed with %v", err)
+		return nil, err
+	}
+
+	res, err := cnsClient.post(httpc, url, &body)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] HTTP Post returned error %v", err.Error())
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		errMsg := fmt.Sprintf("[Azure CNSClient] GetOverlayVteps invalid http status code: %v", res.StatusCode)
+		log.Errorf("%s", errMsg)
+		return nil, errors.New(errMsg)
+	}
+
+	var resp cns.GetOverlayVtepsResponse
+
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] Error received while parsing GetOverlayVteps response resp:%v err:%v", res.Body, err.Error())
+		return nil, err
+	}
+
+	if resp.Response.ReturnCode != 0 {
+		log.Errorf("[Azure CNSClient] GetOverlayVteps received error response :%v", resp.Response.Message)
+		return nil, errors.New(resp.Response.Message)
+	}
+
+	return resp.Vteps, nil
+}
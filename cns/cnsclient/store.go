@@ -0,0 +1,212 @@
+package cnsclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/restserver"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/store"
+)
+
+// cnsStore is an implementation of KeyValueStore that persists state in CNS.
+// Locking is local to the process, callers serializing across processes must hold a separate lock.
+type cnsStore struct {
+	cnsClient *CNSClient
+	locked    bool
+	sync.Mutex
+}
+
+// NewCnsStore creates a new cnsStore object, accessed as a KeyValueStore.
+func NewCnsStore(url string) (store.KeyValueStore, error) {
+	cnsClient, err := NewCnsClient(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cnsStore{
+		cnsClient: cnsClient,
+	}, nil
+}
+
+// Read restores the value for the given key from CNS.
+func (cs *cnsStore) Read(key string, value interface{}) error {
+	cs.Mutex.Lock()
+	defer cs.Mutex.Unlock()
+
+	resp, err := cs.cnsClient.getClientState(key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(resp.Value, value)
+}
+
+// Write saves the given key value pair to CNS.
+func (cs *cnsStore) Write(key string, value interface{}) error {
+	cs.Mutex.Lock()
+	defer cs.Mutex.Unlock()
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return cs.cnsClient.setClientState(key, raw)
+}
+
+// Flush is a no-op since every write is committed to CNS.
+func (cs *cnsStore) Flush() error {
+	return nil
+}
+
+// Lock locks the store for exclusive access within the process.
+func (cs *cnsStore) Lock(block bool) error {
+	cs.Mutex.Lock()
+	defer cs.Mutex.Unlock()
+
+	if cs.locked {
+		return store.ErrStoreLocked
+	}
+
+	cs.locked = true
+
+	return nil
+}
+
+// Unlock unlocks the store.
+func (cs *cnsStore) Unlock(forceUnlock bool) error {
+	cs.Mutex.Lock()
+	defer cs.Mutex.Unlock()
+
+	if !forceUnlock && !cs.locked {
+		return store.ErrStoreNotLocked
+	}
+
+	cs.locked = false
+
+	return nil
+}
+
+// GetModificationTime returns the time client state was last written to CNS.
+func (cs *cnsStore) GetModificationTime() (time.Time, error) {
+	cs.Mutex.Lock()
+	defer cs.Mutex.Unlock()
+
+	resp, err := cs.cnsClient.getClientState("")
+	if err != nil {
+		return time.Time{}.UTC(), err
+	}
+
+	return resp.TimeStamp.UTC(), nil
+}
+
+// GetLockFileModificationTime returns an error since the store has no lock file.
+func (cs *cnsStore) GetLockFileModificationTime() (time.Time, error) {
+	return time.Time{}.UTC(), store.ErrStoreNotLocked
+}
+
+// getClientState reads client state from CNS. An empty key returns only the last modification time.
+func (cnsClient *CNSClient) getClientState(key string) (*cns.GetClientStateResponse, error) {
+	var body bytes.Buffer
+
+	httpc := &http.Client{}
+	url := cnsClient.connectionURL + cns.GetClientStatePath
+
+	payload := &cns.GetClientStateRequest{
+		Key: key,
+	}
+
+	err := json.NewEncoder(&body).Encode(payload)
+	if err != nil {
+		log.Errorf("encoding json failed with %v", err)
+		return nil, err
+	}
+
+	res, err := httpc.Post(url, "application/json", &body)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] HTTP Post returned error %v", err.Error())
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		errMsg := fmt.Sprintf("[Azure CNSClient] GetClientState invalid http status code: %v", res.StatusCode)
+		log.Errorf("%s", errMsg)
+		return nil, errors.New(errMsg)
+	}
+
+	var resp cns.GetClientStateResponse
+
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] Error received while parsing GetClientState response resp:%v err:%v", res.Body, err.Error())
+		return nil, err
+	}
+
+	if resp.Response.ReturnCode == restserver.NotFound {
+		return nil, store.ErrKeyNotFound
+	}
+
+	if resp.Response.ReturnCode != 0 {
+		log.Errorf("[Azure CNSClient] GetClientState received error response :%v", resp.Response.Message)
+		return nil, errors.New(resp.Response.Message)
+	}
+
+	return &resp, nil
+}
+
+// setClientState persists client state in CNS.
+func (cnsClient *CNSClient) setClientState(key string, value json.RawMessage) error {
+	var body bytes.Buffer
+
+	httpc := &http.Client{}
+	url := cnsClient.connectionURL + cns.SetClientStatePath
+
+	payload := &cns.SetClientStateRequest{
+		Key:   key,
+		Value: value,
+	}
+
+	err := json.NewEncoder(&body).Encode(payload)
+	if err != nil {
+		log.Errorf("encoding json failed with %v", err)
+		return err
+	}
+
+	res, err := httpc.Post(url, "application/json", &body)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] HTTP Post returned error %v", err.Error())
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		errMsg := fmt.Sprintf("[Azure CNSClient] SetClientState invalid http status code: %v", res.StatusCode)
+		log.Errorf("%s", errMsg)
+		return errors.New(errMsg)
+	}
+
+	var resp cns.Response
+
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		log.Errorf("[Azure CNSClient] Error received while parsing SetClientState response resp:%v err:%v", res.Body, err.Error())
+		return err
+	}
+
+	if resp.ReturnCode != 0 {
+		log.Errorf("[Azure CNSClient] SetClientState received error response :%v", resp.Message)
+		return errors.New(resp.Message)
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package cnsclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/restserver"
+	"github.com/Azure/azure-container-networking/store"
+)
+
+// fakeCNS persists client state like CNS does, failing requests for keys in failures.
+type fakeCNS struct {
+	state     map[string]json.RawMessage
+	timeStamp time.Time
+	failures  map[string]bool
+}
+
+func (f *fakeCNS) serve() *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(cns.GetClientStatePath, func(w http.ResponseWriter, r *http.Request) {
+		var req cns.GetClientStateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		resp := cns.GetClientStateResponse{TimeStamp: f.timeStamp}
+		if f.failures[req.Key] {
+			resp.Response = cns.Response{ReturnCode: restserver.UnexpectedError, Message: "get failed"}
+		} else if value, ok := f.state[req.Key]; req.Key != "" && !ok {
+			resp.Response = cns.Response{ReturnCode: restserver.NotFound, Message: "not found"}
+		} else {
+			resp.Value = value
+		}
+
+		json.NewEncoder(w).Encode(&resp)
+	})
+
+	mux.HandleFunc(cns.SetClientStatePath, func(w http.ResponseWriter, r *http.Request) {
+		var req cns.SetClientStateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		var resp cns.Response
+		if f.failures[req.Key] {
+			resp = cns.Response{ReturnCode: restserver.UnexpectedError, Message: "set failed"}
+		} else {
+			f.state[req.Key] = req.Value
+			f.timeStamp = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		}
+
+		json.NewEncoder(w).Encode(&resp)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+type testState struct {
+	Networks []string
+	Version  int
+}
+
+func TestCnsStoreReadWrite(t *testing.T) {
+	fake := &fakeCNS{state: make(map[string]json.RawMessage), failures: map[string]bool{"broken": true}}
+	server := fake.serve()
+	defer server.Close()
+
+	cs, err := NewCnsStore(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create store, err:%v", err)
+	}
+
+	var value testState
+	if err = cs.Read("ipam", &value); err != store.ErrKeyNotFound {
+		t.Errorf("Read of a missing key returned err:%v, expected ErrKeyNotFound", err)
+	}
+
+	expected := testState{Networks: []string{"azure"}, Version: 3}
+	if err = cs.Write("ipam", &expected); err != nil {
+		t.Fatalf("Failed to write, err:%v", err)
+	}
+
+	if err = cs.Read("ipam", &value); err != nil || !reflect.DeepEqual(value, expected) {
+		t.Errorf("Read returned %+v err:%v, expected %+v", value, err, expected)
+	}
+
+	modTime, err := cs.GetModificationTime()
+	if err != nil || !modTime.Equal(fake.timeStamp) {
+		t.Errorf("GetModificationTime returned %v err:%v, expected %v", modTime, err, fake.timeStamp)
+	}
+
+	// Errors returned by CNS aren't mistaken for missing keys.
+	if err = cs.Read("broken", &value); err == nil || err == store.ErrKeyNotFound || err.Error() != "get failed" {
+		t.Errorf("Read of a failing key returned err:%v, expected the error of CNS", err)
+	}
+
+	if err = cs.Write("broken", &expected); err == nil || err.Error() != "set failed" {
+		t.Errorf("Write of a failing key returned err:%v, expected the error of CNS", err)
+	}
+
+	// Requests CNS doesn't serve fail.
+	cs, _ = NewCnsStore(server.URL + "/missing")
+	if err = cs.Read("ipam", &value); err == nil || err == store.ErrKeyNotFound {
+		t.Errorf("Read from a missing endpoint returned err:%v, expected an HTTP error", err)
+	}
+}
+
+func TestCnsStoreLock(t *testing.T) {
+	cs, _ := NewCnsStore("")
+
+	if err := cs.Unlock(false); err != store.ErrStoreNotLocked {
+		t.Errorf("Unlock of an unlocked store returned err:%v, expected ErrStoreNotLocked", err)
+	}
+
+	if err := cs.Lock(true); err != nil {
+		t.Fatalf("Failed to lock, err:%v", err)
+	}
+
+	if err := cs.Lock(false); err != store.ErrStoreLocked {
+		t.Errorf("Lock of a locked store returned err:%v, expected ErrStoreLocked", err)
+	}
+
+	if err := cs.Unlock(false); err != nil {
+		t.Errorf("Failed to unlock, err:%v", err)
+	}
+
+	// Forced unlocks succeed even if the store isn't locked.
+	if err := cs.Unlock(true); err != nil {
+		t.Errorf("Forced unlock returned err:%v", err)
+	}
+}
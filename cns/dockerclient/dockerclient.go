@@ -177,9 +177,7 @@ func (dockerClient *DockerClient) DeleteNetwork(networkName string) error {
 			return err
 		}
 
-		cmd := fmt.Sprintf("iptables -t nat -D POSTROUTING -m iprange ! --dst-range 168.63.129.16 -m addrtype ! --dst-type local ! -d %v -j MASQUERADE",
-			primaryNic.Subnet)
-		_, err = platform.ExecuteCommand(cmd)
+		err = platform.DeleteOutboundSNAT(primaryNic.Subnet)
 		if err != nil {
 			log.Printf("[Azure CNS] Error Removing Outbound SNAT rule %v", err)
 		}
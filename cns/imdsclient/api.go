@@ -10,6 +10,11 @@ import (
 const (
 	hostQueryURL                     = "http://169.254.169.254/machine/plugins?comp=nmagent&type=getinterfaceinfov1"
 	hostQueryURLForProgrammedVersion = "http://169.254.169.254/machine/plugins/?comp=nmagent&type=NetworkManagement/interfaces/%s/networkContainers/%s/authenticationToken/%s/api-version/%s"
+	hostQueryURLForSupportedAPIs     = "http://169.254.169.254/machine/plugins/?comp=nmagent&type=GetSupportedApis"
+	hostQueryURLForHomeAz            = "http://169.254.169.254/machine/plugins/?comp=nmagent&type=GetHomeAz/api-version/1"
+
+	// NMAgent API reporting the home availability zone of the node.
+	HomeAzAPI = "GetHomeAz"
 )
 
 // ImdsClient can be used to connect to VM Host agent in Azure.
@@ -68,3 +73,14 @@ type ContainerVersion struct {
 	NetworkContainerID string
 	ProgrammedVersion  string
 }
+
+type supportedAPIsJsonResponse struct {
+	HTTPResponseCode string   `json:"httpStatusCode"`
+	SupportedAPIs    []string `json:"supportedApis"`
+}
+
+type homeAzJsonResponse struct {
+	HTTPResponseCode string `json:"httpStatusCode"`
+	HomeAz           uint   `json:"HomeAz"`
+	APIVersion       uint   `json:"APIVersion"`
+}
@@ -4,15 +4,66 @@
 package imdsclient
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/retry"
 )
 
+// Queries to the host agent that fail to connect or with a server error are retried,
+// as the agent may be briefly unavailable while it is updated.
+var hostQueryRetryPolicy = retry.Policy{
+	MaxAttempts:  4,
+	InitialDelay: 500 * time.Millisecond,
+	MaxDelay:     4 * time.Second,
+	Jitter:       0.2,
+}
+
+// hostServerError is a server error returned by the host agent.
+type hostServerError struct {
+	statusCode int
+}
+
+func (e *hostServerError) Error() string {
+	return fmt.Sprintf("Host agent returned HTTP error %d", e.statusCode)
+}
+
+// getFromHost sends a query to the host agent, retrying failures that may be transient.
+func getFromHost(queryURL string) (*http.Response, error) {
+	var resp *http.Response
+
+	err := retry.Do(context.Background(), &hostQueryRetryPolicy, func() error {
+		var err error
+		resp, err = common.NewHTTPClient(0).Get(queryURL)
+		if err != nil {
+			log.Printf("[Azure CNS] Failed to query Azure Host: %v", err)
+			return err
+		}
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			resp.Body.Close()
+			err = &hostServerError{statusCode: resp.StatusCode}
+			log.Printf("[Azure CNS] Failed to query Azure Host: %v", err)
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
 // GetNetworkContainerInfoFromHost retrieves the programmed version of network container from Host.
 func (imdsClient *ImdsClient) GetNetworkContainerInfoFromHost(networkContainerID string, primaryAddress string, authToken string, apiVersion string) (*ContainerVersion, error) {
 	log.Printf("[Azure CNS] GetNetworkContainerInfoFromHost")
@@ -20,7 +71,7 @@ func (imdsClient *ImdsClient) GetNetworkContainerInfoFromHost(networkContainerID
 		primaryAddress, networkContainerID, authToken, apiVersion)
 
 	log.Printf("[Azure CNS] Going to query Azure Host for container version @\n %v\n", queryURL)
-	jsonResponse, err := http.Get(queryURL)
+	jsonResponse, err := getFromHost(queryURL)
 	if err != nil {
 		return nil, err
 	}
@@ -43,12 +94,54 @@ func (imdsClient *ImdsClient) GetNetworkContainerInfoFromHost(networkContainerID
 	return ret, nil
 }
 
+// GetSupportedAPIsFromHost retrieves the APIs supported by the host agent.
+func (imdsClient *ImdsClient) GetSupportedAPIsFromHost() ([]string, error) {
+	log.Printf("[Azure CNS] GetSupportedAPIsFromHost")
+
+	resp, err := getFromHost(hostQueryURLForSupportedAPIs)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var response supportedAPIsJsonResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	return response.SupportedAPIs, nil
+}
+
+// GetHomeAzFromHost retrieves the home availability zone of the node from Host.
+func (imdsClient *ImdsClient) GetHomeAzFromHost() (uint, error) {
+	log.Printf("[Azure CNS] GetHomeAzFromHost")
+
+	resp, err := getFromHost(hostQueryURLForHomeAz)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	var response homeAzJsonResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, err
+	}
+
+	if response.HTTPResponseCode != "" && response.HTTPResponseCode != "200" {
+		return 0, fmt.Errorf("Host returned status %s for home AZ", response.HTTPResponseCode)
+	}
+
+	return response.HomeAz, nil
+}
+
 // GetPrimaryInterfaceInfoFromHost retrieves subnet and gateway of primary NIC from Host.
 func (imdsClient *ImdsClient) GetPrimaryInterfaceInfoFromHost() (*InterfaceInfo, error) {
 	log.Printf("[Azure CNS] GetPrimaryInterfaceInfoFromHost")
 
 	interfaceInfo := &InterfaceInfo{}
-	resp, err := http.Get(hostQueryURL)
+	resp, err := getFromHost(hostQueryURL)
 	if err != nil {
 		return nil, err
 	}
@@ -91,6 +184,8 @@ func (imdsClient *ImdsClient) GetPrimaryInterfaceInfoFromHost() (*InterfaceInfo,
 				for _, ip := range s.IPAddress {
 					if ip.IsPrimary == true {
 						interfaceInfo.PrimaryIP = ip.Address
+					} else {
+						interfaceInfo.SecondaryIPs = append(interfaceInfo.SecondaryIPs, ip.Address)
 					}
 				}
 
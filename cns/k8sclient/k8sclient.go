@@ -0,0 +1,43 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package k8sclient
+
+import (
+	"os"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// NodeNameEnv is the environment variable holding the name of the node CNS runs on.
+	NodeNameEnv = "ACN_NODE_NAME"
+)
+
+// NewInClusterClientset creates a clientset talking to the API server of the cluster CNS runs in,
+// with a timeout for each request, and returns the name of the node it runs on. The node is named
+// by NodeNameEnv, or by the host name if it isn't set.
+func NewInClusterClientset(timeout time.Duration) (kubernetes.Interface, string, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, "", err
+	}
+
+	config.Timeout = timeout
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nodeName := os.Getenv(NodeNameEnv)
+	if nodeName == "" {
+		if nodeName, err = os.Hostname(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return clientset, nodeName, nil
+}
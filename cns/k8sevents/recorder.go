@@ -0,0 +1,140 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package k8sevents
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns/k8sclient"
+	"github.com/Azure/azure-container-networking/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Namespace of the events of nodes, which aren't namespaced.
+	nodeEventNamespace = "default"
+
+	// Timeout of each event sent to the API server.
+	eventTimeout = 10 * time.Second
+
+	// Repeats of an event for the same object within this interval are dropped, so that failures
+	// reported on every retry of a caller don't flood the API server.
+	eventRepeatInterval = 5 * time.Minute
+)
+
+// Recorder publishes Kubernetes events of a component, attached to pods or to the node it runs on.
+// Events are sent in the background so that callers are never held up by the API server.
+type Recorder struct {
+	clientset kubernetes.Interface
+	component string
+	nodeName  string
+	lastSent  map[string]time.Time
+	sync.Mutex
+}
+
+// NewRecorder creates a Recorder of a component running on a node.
+func NewRecorder(clientset kubernetes.Interface, component, nodeName string) *Recorder {
+	return &Recorder{
+		clientset: clientset,
+		component: component,
+		nodeName:  nodeName,
+		lastSent:  make(map[string]time.Time),
+	}
+}
+
+// NewInClusterRecorder creates a Recorder talking to the API server of the cluster the component
+// runs in, on the node named by k8sclient.NodeNameEnv, or the host name if it isn't set.
+func NewInClusterRecorder(component string) (*Recorder, error) {
+	clientset, nodeName, err := k8sclient.NewInClusterClientset(eventTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRecorder(clientset, component, nodeName), nil
+}
+
+// PodWarning publishes a warning event attached to a pod.
+func (r *Recorder) PodWarning(namespace, name, reason, message string) {
+	if r.isRepeat("Pod", namespace, name, reason, message) {
+		return
+	}
+
+	go func() {
+		ref := corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: namespace, Name: name}
+
+		// Events only show up for their pod if they refer to it by UID.
+		if pod, err := r.clientset.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{}); err == nil {
+			ref.UID = pod.UID
+		}
+
+		r.send(namespace, ref, reason, message)
+	}()
+}
+
+// NodeWarning publishes a warning event attached to the node.
+func (r *Recorder) NodeWarning(reason, message string) {
+	if r.isRepeat("Node", nodeEventNamespace, r.nodeName, reason, message) {
+		return
+	}
+
+	go func() {
+		// Nodes are referred to by name, as the kubelet does.
+		ref := corev1.ObjectReference{Kind: "Node", Name: r.nodeName, UID: types.UID(r.nodeName)}
+
+		r.send(nodeEventNamespace, ref, reason, message)
+	}()
+}
+
+// isRepeat checks if the same event was sent for the same object recently, and records it otherwise.
+func (r *Recorder) isRepeat(kind, namespace, name, reason, message string) bool {
+	key := kind + "/" + namespace + "/" + name + "/" + reason + "/" + message
+	now := time.Now()
+
+	r.Lock()
+	defer r.Unlock()
+
+	if last, ok := r.lastSent[key]; ok && now.Sub(last) < eventRepeatInterval {
+		return true
+	}
+
+	// Forget events that can no longer be repeats.
+	for k, last := range r.lastSent {
+		if now.Sub(last) >= eventRepeatInterval {
+			delete(r.lastSent, k)
+		}
+	}
+
+	r.lastSent[key] = now
+	return false
+}
+
+// send creates an event.
+func (r *Recorder) send(namespace string, ref corev1.ObjectReference, reason, message string) {
+	now := metav1.Now()
+
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", ref.Name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        message,
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: r.component, Host: r.nodeName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	if _, err := r.clientset.CoreV1().Events(namespace).Create(context.Background(), event, metav1.CreateOptions{}); err != nil {
+		log.Printf("[k8sevents] Failed to publish event %s for %s %s/%s: %v", reason, ref.Kind, namespace, ref.Name, err)
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package k8sevents
+
+import (
+	"testing"
+)
+
+// Tests that repeats of an event for the same object are dropped.
+func TestRecorderDropsRepeatedEvents(t *testing.T) {
+	r := NewRecorder(nil, "test", "node")
+
+	if r.isRepeat("Pod", "default", "a", "Failed", "error") {
+		t.Errorf("First event reported as a repeat")
+	}
+
+	if !r.isRepeat("Pod", "default", "a", "Failed", "error") {
+		t.Errorf("Repeated event not dropped")
+	}
+
+	if r.isRepeat("Pod", "default", "b", "Failed", "error") || r.isRepeat("Pod", "default", "a", "Failed", "other error") {
+		t.Errorf("Event for another object or with another message reported as a repeat")
+	}
+}
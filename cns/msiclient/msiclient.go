@@ -0,0 +1,176 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package msiclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/retry"
+)
+
+const (
+	// Managed identity token endpoint of the instance metadata service.
+	imdsTokenURL        = "http://169.254.169.254/metadata/identity/oauth2/token"
+	imdsTokenAPIVersion = "2018-02-01"
+
+	// Tokens are refreshed this long before they expire.
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// Token requests throttled or failed by the metadata service are retried.
+var tokenRetryPolicy = retry.Policy{
+	MaxAttempts:  4,
+	InitialDelay: time.Second,
+	MaxDelay:     8 * time.Second,
+	Jitter:       0.2,
+}
+
+// TokenProvider acquires Azure AD tokens for a resource from the managed identity of the VM,
+// and caches them until shortly before they expire.
+type TokenProvider struct {
+	resource  string
+	clientID  string
+	tokenURL  string
+	token     string
+	expiresOn time.Time
+	sync.Mutex
+}
+
+// tokenResponse is the token returned by the metadata service.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   string `json:"expires_in"`
+	ExpiresOn   string `json:"expires_on"`
+	TokenType   string `json:"token_type"`
+}
+
+// tokenError is an error returned by the metadata service.
+type tokenError struct {
+	statusCode int
+}
+
+func (e *tokenError) Error() string {
+	return fmt.Sprintf("Managed identity token request returned HTTP error %d", e.statusCode)
+}
+
+// NewTokenProvider creates a TokenProvider for a resource. The client ID selects a user-assigned
+// identity, the system-assigned identity is used if it is empty.
+func NewTokenProvider(resource, clientID string) *TokenProvider {
+	return &TokenProvider{
+		resource: resource,
+		clientID: clientID,
+		tokenURL: imdsTokenURL,
+	}
+}
+
+// GetToken returns a token for the resource, acquiring a new one if the cached token is about
+// to expire. The cached token is returned as long as it is valid if a new one can't be acquired.
+func (p *TokenProvider) GetToken() (string, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now()
+	if p.token != "" && now.Add(tokenRefreshMargin).Before(p.expiresOn) {
+		return p.token, nil
+	}
+
+	token, expiresOn, err := p.requestToken()
+	if err != nil {
+		if p.token != "" && now.Before(p.expiresOn) {
+			log.Printf("[Azure CNS] Failed to refresh managed identity token, using cached token, err:%v.", err)
+			return p.token, nil
+		}
+		return "", err
+	}
+
+	log.Printf("[Azure CNS] Acquired managed identity token for %s, expires %v.", p.resource, expiresOn)
+
+	p.token = token
+	p.expiresOn = expiresOn
+
+	return p.token, nil
+}
+
+// Authorize sets the authorization header of a request to a token for the resource.
+func (p *TokenProvider) Authorize(req *http.Request) error {
+	token, err := p.GetToken()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// requestToken requests a new token from the metadata service.
+func (p *TokenProvider) requestToken() (string, time.Time, error) {
+	query := url.Values{}
+	query.Set("api-version", imdsTokenAPIVersion)
+	query.Set("resource", p.resource)
+	if p.clientID != "" {
+		query.Set("client_id", p.clientID)
+	}
+
+	var response tokenResponse
+
+	err := retry.Do(context.Background(), &tokenRetryPolicy, func() error {
+		req, err := http.NewRequest(http.MethodGet, p.tokenURL+"?"+query.Encode(), nil)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+
+		req.Header.Set("Metadata", "true")
+
+		resp, err := common.NewHTTPClient(30 * time.Second).Do(req)
+		if err != nil {
+			return err
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			err = &tokenError{statusCode: resp.StatusCode}
+			// Only throttling and server errors go away.
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+				return retry.Permanent(err)
+			}
+			return err
+		}
+
+		return json.NewDecoder(resp.Body).Decode(&response)
+	})
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if response.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("Managed identity token response has no token")
+	}
+
+	return response.AccessToken, getExpiry(&response), nil
+}
+
+// getExpiry returns the expiry time of a token.
+func getExpiry(response *tokenResponse) time.Time {
+	if seconds, err := strconv.ParseInt(response.ExpiresOn, 10, 64); err == nil {
+		return time.Unix(seconds, 0)
+	}
+
+	if seconds, err := strconv.ParseInt(response.ExpiresIn, 10, 64); err == nil {
+		return time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+
+	// Tokens without a known expiry are not cached.
+	return time.Now()
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package msiclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Tests that tokens are cached until shortly before they expire.
+func TestGetTokenCachesUntilExpiry(t *testing.T) {
+	requests := 0
+	expiresIn := 3600
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("resource") != "https://dnc" ||
+			r.URL.Query().Get("client_id") != "client" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprintf(w, `{"access_token":"token%d","expires_in":"%d","token_type":"Bearer"}`, requests, expiresIn)
+	}))
+	defer server.Close()
+
+	p := NewTokenProvider("https://dnc", "client")
+	p.tokenURL = server.URL
+
+	for i := 0; i < 2; i++ {
+		if token, err := p.GetToken(); err != nil || token != "token1" {
+			t.Fatalf("Expected token1, got %v, err:%v", token, err)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("Expected the token to be cached, got %d requests", requests)
+	}
+
+	// Tokens about to expire are refreshed.
+	p.expiresOn = time.Now().Add(time.Minute)
+	if token, err := p.GetToken(); err != nil || token != "token2" {
+		t.Errorf("Expected token2, got %v, err:%v", token, err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://dnc", nil)
+	if err := p.Authorize(req); err != nil || req.Header.Get("Authorization") != "Bearer token2" {
+		t.Errorf("Request not authorized, header %v, err:%v", req.Header.Get("Authorization"), err)
+	}
+}
+
+// Tests that requests rejected by the metadata service fail without retrying.
+func TestGetTokenFailsOnRejectedRequest(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	p := NewTokenProvider("https://dnc", "")
+	p.tokenURL = server.URL
+
+	if _, err := p.GetToken(); err == nil {
+		t.Errorf("Rejected token request succeeded")
+	}
+
+	if requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+}
@@ -136,6 +136,8 @@ func createOrUpdateWithOperation(createNetworkContainerRequest cns.CreateNetwork
 	return err
 }
 
+// deleteInterface deletes the loopback adapter of a network container with its routes, so that
+// a network container reusing the name doesn't collide with stale state.
 func deleteInterface(networkContainerID string) error {
 
 	if _, err := os.Stat("./AzureNetworkContainer.exe"); err != nil {
@@ -148,6 +150,15 @@ func deleteInterface(networkContainerID string) error {
 		return errors.New("[Azure CNS] networkContainerID is nil")
 	}
 
+	iface, err := net.InterfaceByName(networkContainerID)
+	if err != nil {
+		log.Printf("[Azure CNS] Network loopback adapter %v not found, nothing to delete: %v", networkContainerID, err)
+		return nil
+	}
+
+	// Persistent routes outlive the adapter, and are reapplied to an adapter with the same index.
+	deleteInterfaceRoutes(iface.Index)
+
 	args := []string{"/C", "AzureNetworkContainer.exe", "/logpath", log.GetLogDirectory(),
 		"/name",
 		networkContainerID,
@@ -164,5 +175,21 @@ func deleteInterface(networkContainerID string) error {
 		log.Printf("Received error while deleting a Network Container %v %v", err.Error(), string(bytes))
 		return err
 	}
+
+	if exists, _ := interfaceExists(networkContainerID); exists {
+		return fmt.Errorf("[Azure CNS] Network loopback adapter %v still exists after delete", networkContainerID)
+	}
+
 	return nil
 }
+
+// deleteInterfaceRoutes deletes the active and persistent routes of an interface.
+func deleteInterfaceRoutes(ifIndex int) {
+	command := fmt.Sprintf("Remove-NetRoute -InterfaceIndex %d -Confirm:$false -ErrorAction SilentlyContinue", ifIndex)
+
+	log.Printf("[Azure CNS] Going to delete routes of interface %v", ifIndex)
+	c := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", command)
+	if bytes, err := c.CombinedOutput(); err != nil {
+		log.Printf("[Azure CNS] Failed to delete routes of interface %v: %v %v", ifIndex, err, string(bytes))
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package noderesources
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns/k8sclient"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/retry"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Timeout of each update of the node sent to the API server.
+	patchTimeout = 10 * time.Second
+)
+
+// Failed capacity updates are retried until they succeed or the capacity is advertised again.
+var patchRetryPolicy = retry.Policy{
+	InitialDelay: time.Second,
+	MaxDelay:     time.Minute,
+	Jitter:       0.2,
+}
+
+// Advertiser advertises the capacity of an extended resource of a node, so that pods requesting
+// the resource are only scheduled to the node while it has some available. The scheduler counts
+// the resource held by pods, so the capacity is the total the node has.
+type Advertiser struct {
+	clientset    kubernetes.Interface
+	nodeName     string
+	resourceName string
+	capacity     int64
+	updated      chan struct{}
+	stop         chan struct{}
+	sync.Mutex
+}
+
+// NewAdvertiser creates an Advertiser of an extended resource of a node.
+func NewAdvertiser(clientset kubernetes.Interface, nodeName, resourceName string) *Advertiser {
+	return &Advertiser{
+		clientset:    clientset,
+		nodeName:     nodeName,
+		resourceName: resourceName,
+		updated:      make(chan struct{}, 1),
+	}
+}
+
+// NewInClusterAdvertiser creates an Advertiser talking to the API server of the cluster it runs
+// in, for the node named by k8sclient.NodeNameEnv, or the host name if it isn't set.
+func NewInClusterAdvertiser(resourceName string) (*Advertiser, error) {
+	clientset, nodeName, err := k8sclient.NewInClusterClientset(patchTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAdvertiser(clientset, nodeName, resourceName), nil
+}
+
+// Start starts updating the node with the capacity set.
+func (a *Advertiser) Start() {
+	a.stop = make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func(stop chan struct{}) {
+		<-stop
+		cancel()
+	}(a.stop)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-a.updated:
+			}
+
+			err := retry.Do(ctx, &patchRetryPolicy, func() error {
+				a.Lock()
+				capacity := a.capacity
+				a.Unlock()
+
+				return a.patch(capacity)
+			})
+
+			if err != nil && ctx.Err() == nil {
+				log.Printf("[noderesources] Failed to advertise %s of node %s: %v", a.resourceName, a.nodeName, err)
+			}
+		}
+	}()
+}
+
+// Stop stops updating the node.
+func (a *Advertiser) Stop() {
+	if a.stop != nil {
+		close(a.stop)
+		a.stop = nil
+	}
+}
+
+// SetCapacity sets the capacity of the resource advertised for the node.
+func (a *Advertiser) SetCapacity(capacity int64) {
+	a.Lock()
+	a.capacity = capacity
+	a.Unlock()
+
+	// A pending update picks up the latest capacity.
+	select {
+	case a.updated <- struct{}{}:
+	default:
+	}
+}
+
+// patch sets the capacity of the resource in the status of the node.
+func (a *Advertiser) patch(capacity int64) error {
+	// Slashes in resource names are escaped in JSON pointers.
+	path := "/status/capacity/" + strings.Replace(strings.Replace(a.resourceName, "~", "~0", -1), "/", "~1", -1)
+	data := fmt.Sprintf(`[{"op":"add","path":"%s","value":"%d"}]`, path, capacity)
+
+	if _, err := a.clientset.CoreV1().Nodes().Patch(context.Background(), a.nodeName, types.JSONPatchType, []byte(data), metav1.PatchOptions{}, "status"); err != nil {
+		return err
+	}
+
+	log.Printf("[noderesources] Advertised %d %s on node %s.", capacity, a.resourceName, a.nodeName)
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package noderesources
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Tests that the capacity is patched into the status of the node.
+func TestSetCapacityPatchesNodeStatus(t *testing.T) {
+	patches := make(chan string, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/api/v1/nodes/node0/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		patches <- string(body)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"Node","apiVersion":"v1","metadata":{"name":"node0"}}`))
+	}))
+	defer server.Close()
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create clientset: %v", err)
+	}
+
+	a := NewAdvertiser(clientset, "node0", "networking.azure.com/delegated-nic")
+	a.Start()
+	defer a.Stop()
+
+	a.SetCapacity(3)
+
+	select {
+	case patch := <-patches:
+		expected := `[{"op":"add","path":"/status/capacity/networking.azure.com~1delegated-nic","value":"3"}]`
+		if patch != expected {
+			t.Errorf("Expected patch %s, got %s", expected, patch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Node status was not patched")
+	}
+}
@@ -20,6 +20,7 @@ const (
 	CallToHostFailed             = 17
 	UnknownContainerID           = 18
 	UnsupportedOrchestratorType  = 19
+	UnsupportedAPIVersion        = 20
 	UnexpectedError              = 99
 )
 
@@ -53,6 +54,8 @@ func ReturnCodeToString(returnCode int) (s string) {
 		s = "UnknownContainerID"
 	case UnsupportedOrchestratorType:
 		s = "UnsupportedOrchestratorType"
+	case UnsupportedAPIVersion:
+		s = "UnsupportedAPIVersion"
 	case UnexpectedError:
 		s = "UnexpectedError"
 	default:
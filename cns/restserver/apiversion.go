@@ -0,0 +1,117 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// Prefixes of the routes of each API version. Version v0.1 is also served without a prefix, for
+// clients that predate versioning.
+var apiVersionPrefixes = map[string]string{
+	cns.V1Prefix[1:]:    cns.V1Prefix,
+	cns.V2Prefix[1:]:    cns.V2Prefix,
+	cns.APIV2Prefix[1:]: cns.APIV2Prefix,
+}
+
+// addAPIV2Handlers adds the handlers of API version v2. Handlers whose schema didn't change
+// are shared with earlier versions.
+func (service *HTTPRestService) addAPIV2Handlers() {
+	listener := service.Listener
+	prefix := cns.APIV2Prefix
+
+	listener.AddHandler(prefix+cns.SetEnvironmentPath, service.setEnvironment)
+	listener.AddHandler(prefix+cns.CreateNetworkPath, service.createNetwork)
+	listener.AddHandler(prefix+cns.DeleteNetworkPath, service.deleteNetwork)
+	listener.AddHandler(prefix+cns.ReserveIPAddressPath, service.reserveIPAddress)
+	listener.AddHandler(prefix+cns.ReleaseIPAddressPath, service.releaseIPAddress)
+	listener.AddHandler(prefix+cns.GetHostLocalIPPath, service.getHostLocalIP)
+	listener.AddHandler(prefix+cns.GetIPAddressUtilizationPath, service.getIPAddressUtilization)
+	listener.AddHandler(prefix+cns.GetUnhealthyIPAddressesPath, service.getUnhealthyIPAddresses)
+	listener.AddHandler(prefix+cns.CreateOrUpdateNetworkContainer, service.createOrUpdateNetworkContainer)
+	listener.AddHandler(prefix+cns.DeleteNetworkContainer, service.deleteNetworkContainer)
+	listener.AddHandler(prefix+cns.GetNetworkContainerStatus, service.getNetworkContainerStatus)
+	listener.AddHandler(prefix+cns.GetInterfaceForContainer, service.getInterfaceForContainer)
+	listener.AddHandler(prefix+cns.SetOrchestratorType, service.setOrchestratorType)
+	listener.AddHandler(prefix+cns.GetNetworkContainerByOrchestratorContext, service.getNetworkContainerByOrchestratorContextV2)
+	listener.AddHandler(prefix+cns.ReleaseNetworkContainer, service.releaseNetworkContainer)
+	listener.AddHandler(prefix+cns.RequestIPConfigPath, service.requestIPConfig)
+	listener.AddHandler(prefix+cns.ReleaseIPConfigPath, service.releaseIPConfig)
+	listener.AddHandler(prefix+cns.GetClientStatePath, service.getClientState)
+	listener.AddHandler(prefix+cns.SetClientStatePath, service.setClientState)
+	listener.AddHandler(prefix+cns.GetHealthReportPath, service.getHealthReport)
+	listener.AddHandler(prefix+cns.GetHomeAzPath, service.getHomeAz)
+	listener.AddHandler(prefix+cns.ReserveEgressIPPath, service.reserveEgressIP)
+	listener.AddHandler(prefix+cns.ReleaseEgressIPPath, service.releaseEgressIP)
+	listener.AddHandler(prefix+cns.GetOverlayVtepsPath, service.getOverlayVteps)
+	listener.AddHandler(prefix+cns.SetOverlayVtepsPath, service.setOverlayVteps)
+}
+
+// negotiateAPIVersion picks the first API version of a client CNS serves.
+func (service *HTTPRestService) negotiateAPIVersion(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] negotiateAPIVersion")
+
+	var req cns.NegotiateAPIVersionRequest
+	resp := cns.NegotiateAPIVersionResponse{}
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	for _, version := range req.APIVersions {
+		if prefix, ok := apiVersionPrefixes[version]; ok {
+			resp.APIVersion = version
+			resp.Prefix = prefix
+			break
+		}
+	}
+
+	if resp.APIVersion == "" {
+		resp.Response.ReturnCode = UnsupportedAPIVersion
+		resp.Response.Message = fmt.Sprintf("[Azure CNS] None of API versions %v is supported, supported versions are %v",
+			req.APIVersions, cns.APIVersions)
+	}
+
+	err = service.Listener.Encode(w, &resp)
+	log.Response(service.Name, resp, resp.Response.ReturnCode, ReturnCodeToString(resp.Response.ReturnCode), err)
+}
+
+// getNetworkContainerByOrchestratorContextV2 returns the network container of a pod in the v2 schema.
+func (service *HTTPRestService) getNetworkContainerByOrchestratorContextV2(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] getNetworkContainerByOrchestratorContextV2")
+
+	var req cns.GetNetworkContainerRequest
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	v1 := service.getNetworkContainerResponse(req)
+	resp := cns.GetNetworkContainerResponseV2{
+		Routes:                     v1.Routes,
+		CnetAddressSpace:           v1.CnetAddressSpace,
+		MultiTenancyInfo:           v1.MultiTenancyInfo,
+		PrimaryInterfaceIdentifier: v1.PrimaryInterfaceIdentifier,
+		LocalIPConfiguration:       v1.LocalIPConfiguration,
+		NetworkContainerType:       v1.NetworkContainerType,
+		MACAddress:                 v1.MACAddress,
+		Response:                   v1.Response,
+	}
+
+	if v1.Response.ReturnCode == 0 {
+		resp.IPConfigurations = []cns.IPConfiguration{v1.IPConfiguration}
+	}
+
+	returnCode := resp.Response.ReturnCode
+	err = service.Listener.Encode(w, &resp)
+	log.Response(service.Name, resp, returnCode, ReturnCodeToString(returnCode), err)
+}
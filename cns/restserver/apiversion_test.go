@@ -0,0 +1,86 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/cns"
+)
+
+func TestNegotiateAPIVersion(t *testing.T) {
+	tests := []struct {
+		versions   []string
+		version    string
+		prefix     string
+		returnCode int
+	}{
+		{versions: []string{"v3", "v2", "v0.1"}, version: "v2", prefix: cns.APIV2Prefix},
+		{versions: []string{"v0.1"}, version: "v0.1", prefix: cns.V1Prefix},
+		{versions: []string{"v0.2", "v2"}, version: "v0.2", prefix: cns.V2Prefix},
+		{versions: []string{"v3"}, returnCode: UnsupportedAPIVersion},
+		{returnCode: UnsupportedAPIVersion},
+	}
+
+	for _, test := range tests {
+		var resp cns.NegotiateAPIVersionResponse
+		postRequest(t, cns.NegotiateAPIVersionPath, &cns.NegotiateAPIVersionRequest{APIVersions: test.versions}, &resp)
+
+		if resp.APIVersion != test.version || resp.Prefix != test.prefix || resp.Response.ReturnCode != test.returnCode {
+			t.Errorf("Negotiating versions %v returned %+v, expected version %q with prefix %q and return code %d",
+				test.versions, resp, test.version, test.prefix, test.returnCode)
+		}
+	}
+}
+
+func TestGetNetworkContainerByOrchestratorContextV2(t *testing.T) {
+	setEnv(t)
+	if err := setOrchestratorType(t, cns.Kubernetes); err != nil {
+		t.Fatal(err)
+	}
+
+	podInfo, _ := json.Marshal(cns.KubernetesPodInfo{PodName: "v2pod", PodNamespace: "v2namespace"})
+	ipConfig := cns.IPConfiguration{
+		IPSubnet:         cns.IPSubnet{IPAddress: "11.0.1.5", PrefixLength: 24},
+		GatewayIPAddress: "11.0.1.1",
+	}
+
+	var createResp cns.CreateNetworkContainerResponse
+	postRequest(t, cns.APIV2Prefix+cns.CreateOrUpdateNetworkContainer, &cns.CreateNetworkContainerRequest{
+		Version:              "0.1",
+		NetworkContainerType: cns.AzureContainerInstance,
+		NetworkContainerid:   "ethV2Pod",
+		OrchestratorContext:  podInfo,
+		IPConfiguration:      ipConfig,
+	}, &createResp)
+
+	if createResp.Response.ReturnCode != 0 {
+		t.Fatalf("CreateNetworkContainer failed with response %+v", createResp)
+	}
+
+	defer func() {
+		var deleteResp cns.DeleteNetworkContainerResponse
+		postRequest(t, cns.APIV2Prefix+cns.DeleteNetworkContainer, &cns.DeleteNetworkContainerRequest{NetworkContainerid: "ethV2Pod"}, &deleteResp)
+	}()
+
+	// The v2 schema holds a list of IP configurations.
+	var resp cns.GetNetworkContainerResponseV2
+	postRequest(t, cns.APIV2Prefix+cns.GetNetworkContainerByOrchestratorContext, &cns.GetNetworkContainerRequest{OrchestratorContext: podInfo}, &resp)
+
+	if resp.Response.ReturnCode != 0 || len(resp.IPConfigurations) != 1 ||
+		resp.IPConfigurations[0].IPSubnet != ipConfig.IPSubnet || resp.IPConfigurations[0].GatewayIPAddress != ipConfig.GatewayIPAddress ||
+		resp.NetworkContainerType != cns.AzureContainerInstance {
+		t.Errorf("GetNetworkContainerByOrchestratorContext v2 returned %+v, expected IP configuration %+v", resp, ipConfig)
+	}
+
+	// Unknown pods have no IP configurations.
+	unknownPod, _ := json.Marshal(cns.KubernetesPodInfo{PodName: "unknown", PodNamespace: "v2namespace"})
+	resp = cns.GetNetworkContainerResponseV2{}
+	postRequest(t, cns.APIV2Prefix+cns.GetNetworkContainerByOrchestratorContext, &cns.GetNetworkContainerRequest{OrchestratorContext: unknownPod}, &resp)
+
+	if resp.Response.ReturnCode != UnknownContainerID || len(resp.IPConfigurations) != 0 {
+		t.Errorf("GetNetworkContainerByOrchestratorContext v2 of an unknown pod returned %+v, expected UnknownContainerID", resp)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"net"
+
+	"github.com/Azure/azure-container-networking/cns/bgp"
+	acn "github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// startBGPSpeaker starts advertising the prefixes of network containers to BGP peers, if configured,
+// so that networks outside of the VNET, such as on-premises networks, can reach pods dynamically.
+func (service *HTTPRestService) startBGPSpeaker() {
+	peersOpt, _ := service.GetOption(acn.OptBGPPeers).(string)
+	if peersOpt == "" {
+		return
+	}
+
+	peers, err := bgp.ParsePeers(peersOpt)
+	if err != nil {
+		log.Errorf("[Azure CNS] Not advertising prefixes to BGP peers, err:%v.", err)
+		return
+	}
+
+	asn, _ := service.GetOption(acn.OptBGPASN).(int)
+	if asn <= 0 || asn > 65535 {
+		log.Errorf("[Azure CNS] Not advertising prefixes to BGP peers, invalid AS number %d.", asn)
+		return
+	}
+
+	log.Printf("[Azure CNS] Advertising prefixes of network containers from AS %d to BGP peers %s.", asn, peersOpt)
+
+	service.bgpSpeaker = bgp.NewSpeaker(uint16(asn), peers)
+	service.bgpSpeaker.Start()
+
+	service.lock.Lock()
+	service.advertiseNetworkContainerPrefixes()
+	service.lock.Unlock()
+}
+
+// stopBGPSpeaker stops advertising the prefixes of network containers to BGP peers.
+func (service *HTTPRestService) stopBGPSpeaker() {
+	if service.bgpSpeaker != nil {
+		service.bgpSpeaker.Stop()
+		service.bgpSpeaker = nil
+	}
+}
+
+// advertiseNetworkContainerPrefixes advertises the IPv4 prefixes of the network containers of the
+// node to BGP peers, after one of them is created or deleted. The caller must hold the service lock.
+func (service *HTTPRestService) advertiseNetworkContainerPrefixes() {
+	if service.bgpSpeaker == nil {
+		return
+	}
+
+	var prefixes []net.IPNet
+	for _, status := range service.state.ContainerStatus {
+		route, err := getNetworkContainerHostRoute(&status.CreateNetworkContainerRequest)
+		if err != nil || route.Destination.IP.To4() == nil {
+			continue
+		}
+
+		prefixes = append(prefixes, route.Destination)
+	}
+
+	service.bgpSpeaker.SetPrefixes(prefixes)
+}
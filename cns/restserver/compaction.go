@@ -0,0 +1,114 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	acn "github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Default interval between compactions of CNS state, in minutes.
+	defaultCompactionIntervalInMins = 60
+
+	// Default size of persisted CNS state above which it is compacted on save, in bytes.
+	defaultCompactionThreshold = 1024 * 1024
+)
+
+// startCompaction starts compacting CNS state periodically, and on save once it grows
+// above the threshold set in the service options.
+func (service *HTTPRestService) startCompaction() {
+	interval := defaultCompactionIntervalInMins
+	if value, ok := service.GetOption(acn.OptStoreCompactionInterval).(int); ok && value > 0 {
+		interval = value
+	}
+
+	service.compactionThreshold = defaultCompactionThreshold
+	if value, ok := service.GetOption(acn.OptStoreCompactionThreshold).(int); ok && value > 0 {
+		service.compactionThreshold = value
+	}
+
+	service.stopCompaction = make(chan struct{})
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			service.lock.Lock()
+			if service.compactState() > 0 {
+				service.saveState()
+			}
+			service.lock.Unlock()
+		}
+	}(service.stopCompaction)
+}
+
+// stopCompacting stops the periodic compaction of CNS state.
+func (service *HTTPRestService) stopCompacting() {
+	if service.stopCompaction != nil {
+		close(service.stopCompaction)
+		service.stopCompaction = nil
+	}
+}
+
+// compactState prunes entries of CNS state that no longer refer to anything, and returns
+// the number of entries pruned. Callers must hold the service lock.
+func (service *HTTPRestService) compactState() int {
+	pruned := 0
+
+	// Orchestrator contexts of network containers that were deleted.
+	for orchestratorContext, networkContainerID := range service.state.ContainerIDByOrchestratorContext {
+		if _, ok := service.state.ContainerStatus[networkContainerID]; !ok {
+			delete(service.state.ContainerIDByOrchestratorContext, orchestratorContext)
+			pruned++
+		}
+	}
+
+	// Client state that was cleared by its client.
+	for key, value := range service.state.ClientState {
+		if isClientStateTombstone(value) {
+			delete(service.state.ClientState, key)
+			pruned++
+		}
+	}
+
+	if pruned > 0 {
+		log.Printf("[Azure CNS] Compacted state, pruned %d entries.", pruned)
+	}
+
+	return pruned
+}
+
+// compactStateAboveThreshold compacts CNS state if its persisted size is above the threshold.
+// Callers must hold the service lock.
+func (service *HTTPRestService) compactStateAboveThreshold() {
+	if service.compactionThreshold <= 0 {
+		return
+	}
+
+	buf, err := json.Marshal(service.state)
+	if err != nil || len(buf) <= service.compactionThreshold {
+		return
+	}
+
+	log.Printf("[Azure CNS] State size %d is above the compaction threshold %d.", len(buf), service.compactionThreshold)
+	service.compactState()
+}
+
+// isClientStateTombstone checks if client state was cleared by its client.
+func isClientStateTombstone(value json.RawMessage) bool {
+	value = bytes.TrimSpace(value)
+	return len(value) == 0 || bytes.Equal(value, []byte("null"))
+}
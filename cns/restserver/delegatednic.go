@@ -0,0 +1,158 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/noderesources"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// Network containers of delegated NICs are created by DNC without a pod. Each is advertised as a
+// unit of the delegated NIC resource of the node, and reserved for the first pod the CNI asks a
+// network container for that has none, until the CNI releases it.
+
+// startDelegatedNICAdvertiser starts advertising the delegated NICs of the node when running in a cluster.
+func (service *HTTPRestService) startDelegatedNICAdvertiser() {
+	advertiser, err := noderesources.NewInClusterAdvertiser(cns.DelegatedNICResourceName)
+	if err != nil {
+		log.Printf("[Azure CNS] Not advertising delegated NICs, err:%v.", err)
+		return
+	}
+
+	service.delegatedNICAdvertiser = advertiser
+	advertiser.Start()
+
+	service.lock.Lock()
+	count := service.countDelegatedNICs()
+	service.lock.Unlock()
+
+	// Nodes that never had delegated NICs aren't updated.
+	if count > 0 {
+		advertiser.SetCapacity(int64(count))
+	}
+}
+
+// stopDelegatedNICAdvertiser stops advertising the delegated NICs of the node.
+func (service *HTTPRestService) stopDelegatedNICAdvertiser() {
+	if service.delegatedNICAdvertiser != nil {
+		service.delegatedNICAdvertiser.Stop()
+		service.delegatedNICAdvertiser = nil
+	}
+}
+
+// advertiseDelegatedNICs advertises the number of delegated NICs of the node, after one of their
+// network containers is created or deleted. The caller must hold the service lock.
+func (service *HTTPRestService) advertiseDelegatedNICs() {
+	if service.delegatedNICAdvertiser != nil {
+		service.delegatedNICAdvertiser.SetCapacity(int64(service.countDelegatedNICs()))
+	}
+}
+
+// countDelegatedNICs returns the number of delegated NIC network containers. The caller must hold
+// the service lock.
+func (service *HTTPRestService) countDelegatedNICs() int {
+	count := 0
+	for _, status := range service.state.ContainerStatus {
+		if status.CreateNetworkContainerRequest.NetworkContainerType == cns.DelegatedNIC {
+			count++
+		}
+	}
+
+	return count
+}
+
+// reserveDelegatedNIC reserves a free delegated NIC network container for a pod and returns its
+// ID, or an empty ID if none is free. The caller must hold the service lock.
+func (service *HTTPRestService) reserveDelegatedNIC(podKey string) string {
+	reserved := make(map[string]bool)
+	for _, id := range service.state.ContainerIDByOrchestratorContext {
+		reserved[id] = true
+	}
+
+	var free []string
+	for id, status := range service.state.ContainerStatus {
+		if status.CreateNetworkContainerRequest.NetworkContainerType == cns.DelegatedNIC && !reserved[id] {
+			free = append(free, id)
+		}
+	}
+
+	if len(free) == 0 {
+		return ""
+	}
+
+	sort.Strings(free)
+
+	if service.state.ContainerIDByOrchestratorContext == nil {
+		service.state.ContainerIDByOrchestratorContext = make(map[string]string)
+	}
+
+	service.state.ContainerIDByOrchestratorContext[podKey] = free[0]
+	service.saveState()
+
+	log.Printf("[Azure CNS] Reserved delegated NIC network container %s for pod %s.", free[0], podKey)
+	return free[0]
+}
+
+// releaseDelegatedNIC releases the delegated NIC network container reserved for a pod. Releasing
+// a pod without one succeeds, so that retried releases are harmless. Network containers of other
+// types stay attached to their pod, as set by DNC.
+func (service *HTTPRestService) releaseDelegatedNIC(req *cns.ReleaseNetworkContainerRequest) (int, string) {
+	var podInfo cns.KubernetesPodInfo
+	if err := json.Unmarshal(req.OrchestratorContext, &podInfo); err != nil {
+		return UnexpectedError, fmt.Sprintf("Unmarshalling orchestrator context failed with error %v", err)
+	}
+
+	podKey := podInfo.PodName + podInfo.PodNamespace
+
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	id, ok := service.state.ContainerIDByOrchestratorContext[podKey]
+	if !ok || service.state.ContainerStatus[id].CreateNetworkContainerRequest.NetworkContainerType != cns.DelegatedNIC {
+		return 0, ""
+	}
+
+	delete(service.state.ContainerIDByOrchestratorContext, podKey)
+	service.saveState()
+
+	log.Printf("[Azure CNS] Released delegated NIC network container %s of pod %s.", id, podKey)
+	return 0, ""
+}
+
+func (service *HTTPRestService) releaseNetworkContainer(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] releaseNetworkContainer")
+
+	var req cns.ReleaseNetworkContainerRequest
+	returnMessage := ""
+	returnCode := 0
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		returnCode, returnMessage = service.releaseDelegatedNIC(&req)
+
+	default:
+		returnMessage = "[Azure CNS] Error. ReleaseNetworkContainer did not receive a POST."
+		returnCode = InvalidParameter
+	}
+
+	resp := cns.Response{
+		ReturnCode: returnCode,
+		Message:    returnMessage,
+	}
+
+	err = service.Listener.Encode(w, &resp)
+	log.Response(service.Name, resp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+}
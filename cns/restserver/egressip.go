@@ -0,0 +1,167 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// reserveEgressIPAddress reserves a secondary address of the node as the egress address of a pod
+// interface. The address must belong to the node, and not be used by another pod interface, either
+// as its egress address or as a node subnet address. Reserving the address a pod interface already
+// holds succeeds, so that retried requests are harmless.
+func (service *HTTPRestService) reserveEgressIPAddress(podInterfaceID string, address string) (int, string) {
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() == nil {
+		return InvalidParameter, fmt.Sprintf("[Azure CNS] Error. Invalid egress address %q", address)
+	}
+	address = ip.String()
+
+	// Secondary addresses of the node are learned from the host, as they may change at any time.
+	ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromHost()
+	if err != nil {
+		return UnreachableHost, fmt.Sprintf("[Azure CNS] Failed to get secondary addresses of the node from host: %v", err)
+	}
+
+	owned := false
+	for _, secondaryIP := range ifInfo.SecondaryIPs {
+		if secondaryIP == address {
+			owned = true
+			break
+		}
+	}
+
+	if !owned {
+		return InvalidParameter, fmt.Sprintf("[Azure CNS] Error. %s is not a secondary address of the node", address)
+	}
+
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	for id, reserved := range service.state.EgressIPs {
+		if reserved == address && id != podInterfaceID {
+			return AddressUnavailable, fmt.Sprintf("[Azure CNS] Error. Egress address %s is reserved by %s", address, id)
+		}
+	}
+
+	for id, allocated := range service.state.NodeSubnetAllocations {
+		if allocated == address {
+			return AddressUnavailable, fmt.Sprintf("[Azure CNS] Error. Egress address %s is allocated to %s", address, id)
+		}
+	}
+
+	if reserved, ok := service.state.EgressIPs[podInterfaceID]; ok && reserved != address {
+		return InvalidParameter, fmt.Sprintf("[Azure CNS] Error. %s already reserved egress address %s", podInterfaceID, reserved)
+	}
+
+	if service.state.EgressIPs == nil {
+		service.state.EgressIPs = make(map[string]string)
+	}
+
+	service.state.EgressIPs[podInterfaceID] = address
+	service.saveState()
+
+	log.Printf("[Azure CNS] Reserved egress address %s for %s.", address, podInterfaceID)
+
+	return 0, ""
+}
+
+// releaseEgressIPAddress releases the egress address of a pod interface. Releasing a pod interface
+// without an egress address succeeds, so that retried releases are harmless.
+func (service *HTTPRestService) releaseEgressIPAddress(podInterfaceID string) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	address, ok := service.state.EgressIPs[podInterfaceID]
+	if !ok {
+		log.Printf("[Azure CNS] No egress address is reserved for %s.", podInterfaceID)
+		return
+	}
+
+	delete(service.state.EgressIPs, podInterfaceID)
+	service.saveState()
+
+	log.Printf("[Azure CNS] Released egress address %s of %s.", address, podInterfaceID)
+}
+
+// Handles requests to reserve the egress address of a pod interface.
+func (service *HTTPRestService) reserveEgressIP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] reserveEgressIP")
+
+	var req cns.EgressIPRequest
+	returnMessage := ""
+	returnCode := 0
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		if req.PodInterfaceID == "" {
+			returnCode = ReservationNotFound
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. PodInterfaceID is empty")
+			break
+		}
+
+		returnCode, returnMessage = service.reserveEgressIPAddress(req.PodInterfaceID, req.IPAddress)
+
+	default:
+		returnMessage = "[Azure CNS] Error. ReserveEgressIP did not receive a POST."
+		returnCode = InvalidParameter
+	}
+
+	resp := cns.Response{
+		ReturnCode: returnCode,
+		Message:    returnMessage,
+	}
+
+	err = service.Listener.Encode(w, &resp)
+	log.Response(service.Name, resp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+}
+
+// Handles requests to release the egress address of a pod interface.
+func (service *HTTPRestService) releaseEgressIP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] releaseEgressIP")
+
+	var req cns.EgressIPRequest
+	returnMessage := ""
+	returnCode := 0
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		if req.PodInterfaceID == "" {
+			returnCode = ReservationNotFound
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. PodInterfaceID is empty")
+			break
+		}
+
+		service.releaseEgressIPAddress(req.PodInterfaceID)
+
+	default:
+		returnMessage = "[Azure CNS] Error. ReleaseEgressIP did not receive a POST."
+		returnCode = InvalidParameter
+	}
+
+	resp := cns.Response{
+		ReturnCode: returnCode,
+		Message:    returnMessage,
+	}
+
+	err = service.Listener.Encode(w, &resp)
+	log.Response(service.Name, resp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+}
@@ -0,0 +1,37 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Azure/azure-container-networking/cns"
+)
+
+// Reasons of the Kubernetes events published by CNS.
+const (
+	eventReasonNetworkContainerFailed          = "FailedNetworkContainer"
+	eventReasonNetworkContainerHostQueryFailed = "FailedNetworkContainerHostQuery"
+)
+
+// publishNetworkContainerFailure publishes a Kubernetes event for a network container that failed,
+// with the return code of the failure. The event is attached to the pod of the network container,
+// or to the node if it has none.
+func (service *HTTPRestService) publishNetworkContainerFailure(
+	req *cns.CreateNetworkContainerRequest, reason string, returnCode int, message string) {
+	if service.eventRecorder == nil {
+		return
+	}
+
+	message = fmt.Sprintf("Code:%s NetworkContainer %s: %s", ReturnCodeToString(returnCode), req.NetworkContainerid, message)
+
+	var podInfo cns.KubernetesPodInfo
+	if err := json.Unmarshal(req.OrchestratorContext, &podInfo); err == nil && podInfo.PodName != "" {
+		service.eventRecorder.PodWarning(podInfo.PodNamespace, podInfo.PodName, reason, message)
+		return
+	}
+
+	service.eventRecorder.NodeWarning(reason, message)
+}
@@ -0,0 +1,284 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	acn "github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/retry"
+)
+
+const (
+	// Goal state streams that receive nothing, not even a heartbeat, for this long are reconnected.
+	goalStateIdleTimeout = 5 * time.Minute
+
+	// Timeout of each acknowledgement sent to DNC.
+	goalStateAckTimeout = 30 * time.Second
+)
+
+var (
+	// Returned when a delta doesn't apply on top of the goal state version applied by CNS.
+	errGoalStateOutOfSync = errors.New("Goal state delta does not apply to the applied version")
+
+	// Dropped goal state streams are reconnected with a backoff, reset once a stream delivers messages.
+	goalStateReconnectPolicy = retry.Policy{
+		InitialDelay: time.Second,
+		MaxDelay:     2 * time.Minute,
+		Jitter:       0.2,
+	}
+
+	goalStateAckRetryPolicy = retry.Policy{
+		MaxAttempts:  3,
+		InitialDelay: time.Second,
+		Jitter:       0.2,
+	}
+)
+
+// startGoalStateWatch starts receiving the goal state of network containers streamed by DNC.
+func (service *HTTPRestService) startGoalStateWatch(baseURL string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	service.stopGoalStateWatch = cancel
+
+	go service.watchGoalState(ctx, strings.TrimSuffix(baseURL, "/"))
+}
+
+// stopWatchingGoalState closes the goal state stream.
+func (service *HTTPRestService) stopWatchingGoalState() {
+	if service.stopGoalStateWatch != nil {
+		service.stopGoalStateWatch()
+		service.stopGoalStateWatch = nil
+	}
+}
+
+// watchGoalState keeps a goal state stream open until the context is done. A stream that drops is
+// reconnected from the last applied version, or from a snapshot if CNS fell out of sync with DNC.
+func (service *HTTPRestService) watchGoalState(ctx context.Context, baseURL string) {
+	resync := false
+	attempt := 0
+
+	for {
+		received, err := service.receiveGoalState(ctx, baseURL, resync)
+		if ctx.Err() != nil {
+			return
+		}
+
+		resync = err == errGoalStateOutOfSync
+		if received {
+			attempt = 0
+		}
+		attempt++
+
+		delay := goalStateReconnectPolicy.Delay(attempt)
+		log.Printf("[Azure CNS] Goal state stream closed, err:%v. Reconnecting in %v.", err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// receiveGoalState opens a goal state stream and applies its messages until it closes. A resync
+// asks DNC for a snapshot instead of the deltas since the applied version. It returns whether
+// any message was received.
+func (service *HTTPRestService) receiveGoalState(ctx context.Context, baseURL string, resync bool) (bool, error) {
+	var version int64
+	if !resync {
+		service.lock.Lock()
+		version = service.state.GoalStateVersion
+		service.lock.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Cancel the stream if it goes idle, as the connection may have dropped silently.
+	idle := time.AfterFunc(goalStateIdleTimeout, cancel)
+	defer idle.Stop()
+
+	url := fmt.Sprintf("%s%s?version=%d", baseURL, cns.GoalStateWatchPath, version)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if err = service.authorizeDNCRequest(req); err != nil {
+		return false, err
+	}
+
+	resp, err := acn.NewHTTPClient(0).Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("Goal state watch returned HTTP error %d", resp.StatusCode)
+	}
+
+	log.Printf("[Azure CNS] Receiving goal state from version %d.", version)
+
+	decoder := json.NewDecoder(resp.Body)
+	received := false
+
+	for {
+		var msg cns.GoalStateMessage
+		if err = decoder.Decode(&msg); err != nil {
+			return received, err
+		}
+
+		received = true
+		idle.Reset(goalStateIdleTimeout)
+
+		ack, err := service.applyGoalState(&msg)
+		if err != nil {
+			return received, err
+		}
+
+		if ack != nil {
+			if err = service.ackGoalState(ctx, baseURL, ack); err != nil {
+				log.Errorf("[Azure CNS] Failed to acknowledge goal state version %d, err:%v.", ack.Version, err)
+			}
+		}
+	}
+}
+
+// applyGoalState creates, updates and deletes network containers to match a goal state message,
+// and returns the acknowledgement of its version. Network containers missing from a snapshot are
+// deleted. Heartbeats aren't acknowledged.
+func (service *HTTPRestService) applyGoalState(msg *cns.GoalStateMessage) (*cns.GoalStateAck, error) {
+	switch msg.Type {
+	case cns.GoalStateSnapshot, cns.GoalStateDelta:
+	case cns.GoalStateHeartbeat:
+		return nil, nil
+	default:
+		log.Printf("[Azure CNS] Ignoring goal state message of unknown type %v.", msg.Type)
+		return nil, nil
+	}
+
+	deleted := msg.DeletedNetworkContainerIDs
+
+	service.lock.Lock()
+	applied := service.state.GoalStateVersion
+	if msg.Type == cns.GoalStateSnapshot {
+		wanted := make(map[string]bool)
+		for _, req := range msg.NetworkContainers {
+			wanted[req.NetworkContainerid] = true
+		}
+
+		for id := range service.state.ContainerStatus {
+			if !wanted[id] {
+				deleted = append(deleted, id)
+			}
+		}
+	}
+	service.lock.Unlock()
+
+	if msg.Type == cns.GoalStateDelta && msg.BaseVersion != applied {
+		log.Printf("[Azure CNS] Goal state delta from version %d does not apply to version %d.", msg.BaseVersion, applied)
+		return nil, errGoalStateOutOfSync
+	}
+
+	ack := &cns.GoalStateAck{Version: msg.Version}
+	fail := func(id string, message string) {
+		if ack.Errors == nil {
+			ack.Errors = make(map[string]string)
+		}
+		ack.Errors[id] = message
+	}
+
+	for _, req := range msg.NetworkContainers {
+		if req.NetworkContainerid == "" {
+			log.Printf("[Azure CNS] Ignoring network container without ID in goal state version %d.", msg.Version)
+			continue
+		}
+
+		if returnCode, message := service.createOrUpdateNetworkContainerFromRequest(req); returnCode != 0 {
+			fail(req.NetworkContainerid, message)
+		}
+	}
+
+	for _, id := range deleted {
+		if returnCode, message := service.deleteNetworkContainerByID(id); returnCode != 0 {
+			fail(id, message)
+		}
+	}
+
+	service.lock.Lock()
+	service.state.GoalStateVersion = msg.Version
+	service.saveState()
+
+	// Track the network containers in the goal state, known once a snapshot is received.
+	if msg.Type == cns.GoalStateSnapshot {
+		service.goalStateNetworkContainers = make(map[string]bool)
+	}
+	if service.goalStateNetworkContainers != nil {
+		for _, req := range msg.NetworkContainers {
+			service.goalStateNetworkContainers[req.NetworkContainerid] = true
+		}
+		for _, id := range deleted {
+			delete(service.goalStateNetworkContainers, id)
+		}
+	}
+	service.lock.Unlock()
+
+	log.Printf("[Azure CNS] Applied goal state %s version %d, %d network containers updated, %d deleted, %d failed.",
+		msg.Type, msg.Version, len(msg.NetworkContainers), len(deleted), len(ack.Errors))
+
+	return ack, nil
+}
+
+// ackGoalState acknowledges an applied goal state version to DNC.
+func (service *HTTPRestService) ackGoalState(ctx context.Context, baseURL string, ack *cns.GoalStateAck) error {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(ack); err != nil {
+		return err
+	}
+
+	return retry.Do(ctx, &goalStateAckRetryPolicy, func() error {
+		req, err := http.NewRequest(http.MethodPost, baseURL+cns.GoalStateAckPath, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return retry.Permanent(err)
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		if err = service.authorizeDNCRequest(req); err != nil {
+			return err
+		}
+
+		resp, err := acn.NewHTTPClient(goalStateAckTimeout).Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Goal state ack returned HTTP error %d", resp.StatusCode)
+		}
+
+		return nil
+	})
+}
+
+// authorizeDNCRequest authenticates a request to DNC with a managed identity token, if enabled.
+func (service *HTTPRestService) authorizeDNCRequest(req *http.Request) error {
+	if service.dncTokenProvider == nil {
+		return nil
+	}
+
+	return service.dncTokenProvider.Authorize(req)
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/cns"
+)
+
+// fakeDNC streams goal state messages to CNS and records the versions CNS watches from and acknowledges.
+type fakeDNC struct {
+	messages        []cns.GoalStateMessage
+	resyncMessages  []cns.GoalStateMessage
+	watchedVersions []string
+	acks            []cns.GoalStateAck
+	sync.Mutex
+}
+
+func (dnc *fakeDNC) serve() *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(cns.GoalStateWatchPath, func(w http.ResponseWriter, r *http.Request) {
+		version := r.URL.Query().Get("version")
+
+		dnc.Lock()
+		dnc.watchedVersions = append(dnc.watchedVersions, version)
+		dnc.Unlock()
+
+		messages := dnc.messages
+		if version == "0" {
+			messages = dnc.resyncMessages
+		}
+
+		encoder := json.NewEncoder(w)
+		for _, msg := range messages {
+			encoder.Encode(msg)
+		}
+	})
+
+	mux.HandleFunc(cns.GoalStateAckPath, func(w http.ResponseWriter, r *http.Request) {
+		var ack cns.GoalStateAck
+		json.NewDecoder(r.Body).Decode(&ack)
+
+		dnc.Lock()
+		dnc.acks = append(dnc.acks, ack)
+		dnc.Unlock()
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func getNetworkContainerIDs(svc *HTTPRestService) []string {
+	var ids []string
+	for id := range svc.state.ContainerStatus {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+func TestReceiveGoalState(t *testing.T) {
+	nc := func(id string) cns.CreateNetworkContainerRequest {
+		return cns.CreateNetworkContainerRequest{
+			NetworkContainerid:   id,
+			NetworkContainerType: cns.AzureContainerInstance,
+			Version:              "1",
+		}
+	}
+
+	dnc := &fakeDNC{
+		messages: []cns.GoalStateMessage{
+			{Type: cns.GoalStateSnapshot, Version: 8, NetworkContainers: []cns.CreateNetworkContainerRequest{nc("nc1"), nc("nc2")}},
+			{Type: cns.GoalStateHeartbeat},
+			{Type: cns.GoalStateDelta, Version: 9, BaseVersion: 8,
+				NetworkContainers: []cns.CreateNetworkContainerRequest{nc("nc3")}, DeletedNetworkContainerIDs: []string{"nc1"}},
+			{Type: cns.GoalStateDelta, Version: 12, BaseVersion: 11, NetworkContainers: []cns.CreateNetworkContainerRequest{nc("nc4")}},
+		},
+		resyncMessages: []cns.GoalStateMessage{
+			{Type: cns.GoalStateSnapshot, Version: 12, NetworkContainers: []cns.CreateNetworkContainerRequest{nc("nc2"), nc("nc4")}},
+		},
+	}
+
+	server := dnc.serve()
+	defer server.Close()
+
+	// State applied before the stream opens, with a network container DNC no longer has.
+	svc := newTestService(t)
+	svc.state.GoalStateVersion = 7
+	svc.saveNetworkContainerGoalState(nc("stale"))
+
+	received, err := svc.receiveGoalState(context.Background(), server.URL, false)
+	if !received || err != errGoalStateOutOfSync {
+		t.Fatalf("receiveGoalState returned received:%v err:%v, expected the stream to fall out of sync", received, err)
+	}
+
+	if ids := getNetworkContainerIDs(svc); !reflect.DeepEqual(ids, []string{"nc2", "nc3"}) {
+		t.Errorf("Network containers after the stream are %v, expected [nc2 nc3]", ids)
+	}
+
+	if svc.state.GoalStateVersion != 9 {
+		t.Errorf("Applied goal state version is %d, expected 9", svc.state.GoalStateVersion)
+	}
+
+	// A resync asks for a snapshot instead of the deltas since the applied version.
+	received, err = svc.receiveGoalState(context.Background(), server.URL, true)
+	if !received || err != io.EOF {
+		t.Fatalf("receiveGoalState returned received:%v err:%v on resync, expected the stream to end", received, err)
+	}
+
+	if ids := getNetworkContainerIDs(svc); !reflect.DeepEqual(ids, []string{"nc2", "nc4"}) {
+		t.Errorf("Network containers after the resync are %v, expected [nc2 nc4]", ids)
+	}
+
+	if !reflect.DeepEqual(svc.goalStateNetworkContainers, map[string]bool{"nc2": true, "nc4": true}) {
+		t.Errorf("Network containers in the goal state are %v, expected nc2 and nc4", svc.goalStateNetworkContainers)
+	}
+
+	if !reflect.DeepEqual(dnc.watchedVersions, []string{"7", "0"}) {
+		t.Errorf("Goal state was watched from versions %v, expected [7 0]", dnc.watchedVersions)
+	}
+
+	var acked []int64
+	for _, ack := range dnc.acks {
+		acked = append(acked, ack.Version)
+		if len(ack.Errors) != 0 {
+			t.Errorf("Goal state version %d was acknowledged with errors %v", ack.Version, ack.Errors)
+		}
+	}
+
+	if !reflect.DeepEqual(acked, []int64{8, 9, 12}) {
+		t.Errorf("Acknowledged goal state versions are %v, expected [8 9 12]", acked)
+	}
+}
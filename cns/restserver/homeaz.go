@@ -0,0 +1,119 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/imdsclient"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Interval after which the home AZ of the node is queried from the host again.
+	homeAzCacheInterval = 15 * time.Minute
+)
+
+// homeAzCache caches the home AZ of the node and the APIs supported by the host agent.
+type homeAzCache struct {
+	response cns.HomeAzResponse
+	queried  time.Time
+	sync.Mutex
+}
+
+// getHomeAzResponse returns the home AZ of the node, querying the host if it wasn't queried
+// recently. The last known home AZ is returned if the host can't be reached.
+func (service *HTTPRestService) getHomeAzResponse() (cns.HomeAzResponse, error) {
+	cache := &service.homeAz
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if !cache.queried.IsZero() && time.Since(cache.queried) < homeAzCacheInterval {
+		return cache.response, nil
+	}
+
+	response, err := service.queryHomeAz()
+	if err != nil {
+		if !cache.queried.IsZero() {
+			log.Printf("[Azure CNS] Failed to query home AZ, using cached value, err:%v.", err)
+			return cache.response, nil
+		}
+		return response, err
+	}
+
+	cache.response = response
+	cache.queried = time.Now()
+
+	return response, nil
+}
+
+// queryHomeAz queries the home AZ of the node from the host agent, if it supports reporting it.
+func (service *HTTPRestService) queryHomeAz() (cns.HomeAzResponse, error) {
+	var response cns.HomeAzResponse
+
+	apis, err := service.imdsClient.GetSupportedAPIsFromHost()
+	if err != nil {
+		return response, err
+	}
+
+	response.SupportedAPIs = apis
+
+	for _, api := range apis {
+		if api == imdsclient.HomeAzAPI {
+			response.IsSupported = true
+			break
+		}
+	}
+
+	if !response.IsSupported {
+		return response, nil
+	}
+
+	if response.HomeAz, err = service.imdsClient.GetHomeAzFromHost(); err != nil {
+		return response, err
+	}
+
+	log.Printf("[Azure CNS] Home AZ of the node is %d.", response.HomeAz)
+
+	return response, nil
+}
+
+// Handles requests for the home AZ of the node.
+func (service *HTTPRestService) getHomeAz(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] getHomeAz")
+	log.Request(service.Name, "getHomeAz", nil)
+
+	returnMessage := ""
+	returnCode := 0
+	var homeAzResponse cns.HomeAzResponse
+
+	switch r.Method {
+	case "GET":
+		var err error
+		if homeAzResponse, err = service.getHomeAzResponse(); err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetHomeAz failed %v", err.Error())
+			returnCode = CallToHostFailed
+		}
+
+	default:
+		returnMessage = "[Azure CNS] Error. GetHomeAz did not receive a GET."
+		returnCode = InvalidParameter
+	}
+
+	resp := cns.GetHomeAzResponse{
+		HomeAzResponse: homeAzResponse,
+		Response: cns.Response{
+			ReturnCode: returnCode,
+			Message:    returnMessage,
+		},
+	}
+
+	err := service.Listener.Encode(w, &resp)
+	log.Response(service.Name, resp, returnCode, ReturnCodeToString(returnCode), err)
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/imdsclient"
+)
+
+func getHomeAz(t *testing.T, method string) cns.GetHomeAzResponse {
+	req, err := http.NewRequest(method, cns.GetHomeAzPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	var resp cns.GetHomeAzResponse
+	if err = decodeResponse(w, &resp); err != nil {
+		t.Fatalf("GetHomeAz failed, err:%v", err)
+	}
+
+	return resp
+}
+
+func TestGetHomeAz(t *testing.T) {
+	svc := service.(*HTTPRestService)
+	expected := cns.HomeAzResponse{
+		IsSupported:   true,
+		HomeAz:        2,
+		SupportedAPIs: []string{"GetSupportedApis", imdsclient.HomeAzAPI},
+	}
+
+	// Home AZ queried from the host recently is served from the cache.
+	svc.homeAz.Lock()
+	svc.homeAz.response = expected
+	svc.homeAz.queried = time.Now()
+	svc.homeAz.Unlock()
+
+	defer func() {
+		svc.homeAz.Lock()
+		svc.homeAz.response = cns.HomeAzResponse{}
+		svc.homeAz.queried = time.Time{}
+		svc.homeAz.Unlock()
+	}()
+
+	resp := getHomeAz(t, http.MethodGet)
+	if resp.Response.ReturnCode != 0 || !reflect.DeepEqual(resp.HomeAzResponse, expected) {
+		t.Errorf("GetHomeAz returned %+v, expected %+v", resp, expected)
+	}
+
+	if resp = getHomeAz(t, http.MethodPost); resp.Response.ReturnCode != InvalidParameter {
+		t.Errorf("GetHomeAz with a POST returned %+v, expected InvalidParameter", resp)
+	}
+}
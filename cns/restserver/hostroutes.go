@@ -0,0 +1,75 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/routes"
+)
+
+// getNetworkContainerHostRoute returns the host route sending the prefix of a network container
+// to its primary interface, through its gateway.
+func getNetworkContainerHostRoute(req *cns.CreateNetworkContainerRequest) (*routes.HostRoute, error) {
+	subnet := req.IPConfiguration.IPSubnet
+
+	ip := net.ParseIP(subnet.IPAddress)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid IP address %s", subnet.IPAddress)
+	}
+
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 8 * net.IPv4len
+	}
+
+	if int(subnet.PrefixLength) > bits {
+		return nil, fmt.Errorf("Invalid prefix length %d", subnet.PrefixLength)
+	}
+
+	mask := net.CIDRMask(int(subnet.PrefixLength), bits)
+	route := &routes.HostRoute{
+		Destination:      net.IPNet{IP: ip.Mask(mask), Mask: mask},
+		InterfaceAddress: req.PrimaryInterfaceIdentifier,
+	}
+
+	if req.IPConfiguration.GatewayIPAddress != "" {
+		if route.Gateway = net.ParseIP(req.IPConfiguration.GatewayIPAddress); route.Gateway == nil {
+			return nil, fmt.Errorf("Invalid gateway IP address %s", req.IPConfiguration.GatewayIPAddress)
+		}
+	}
+
+	return route, nil
+}
+
+// addNetworkContainerHostRoute adds the host route of a network container, if enabled.
+func (service *HTTPRestService) addNetworkContainerHostRoute(req *cns.CreateNetworkContainerRequest) error {
+	if !service.programHostRoutes || req.PrimaryInterfaceIdentifier == "" {
+		return nil
+	}
+
+	route, err := getNetworkContainerHostRoute(req)
+	if err != nil {
+		return err
+	}
+
+	return routes.AddHostRoute(route)
+}
+
+// deleteNetworkContainerHostRoute deletes the host route of a network container, if enabled.
+func (service *HTTPRestService) deleteNetworkContainerHostRoute(req *cns.CreateNetworkContainerRequest) error {
+	if !service.programHostRoutes || req.PrimaryInterfaceIdentifier == "" {
+		return nil
+	}
+
+	route, err := getNetworkContainerHostRoute(req)
+	if err != nil {
+		return err
+	}
+
+	return routes.DeleteHostRoute(route)
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/cns"
+)
+
+func TestGetNetworkContainerHostRoute(t *testing.T) {
+	tests := []struct {
+		name        string
+		ipAddress   string
+		prefix      uint8
+		gateway     string
+		destination string
+		fail        bool
+	}{
+		{name: "IPv4", ipAddress: "10.1.2.3", prefix: 24, gateway: "10.1.2.1", destination: "10.1.2.0/24"},
+		{name: "IPv4 host", ipAddress: "10.1.2.3", prefix: 32, destination: "10.1.2.3/32"},
+		{name: "IPv6", ipAddress: "fd00::1:5", prefix: 64, gateway: "fd00::1", destination: "fd00::/64"},
+		{name: "invalid address", ipAddress: "10.1.2", prefix: 24, fail: true},
+		{name: "invalid IPv4 prefix length", ipAddress: "10.1.2.3", prefix: 33, fail: true},
+		{name: "invalid gateway", ipAddress: "10.1.2.3", prefix: 24, gateway: "10.1.2", fail: true},
+	}
+
+	for _, test := range tests {
+		req := &cns.CreateNetworkContainerRequest{
+			PrimaryInterfaceIdentifier: "10.0.0.4",
+			IPConfiguration: cns.IPConfiguration{
+				IPSubnet:         cns.IPSubnet{IPAddress: test.ipAddress, PrefixLength: test.prefix},
+				GatewayIPAddress: test.gateway,
+			},
+		}
+
+		route, err := getNetworkContainerHostRoute(req)
+		if test.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error, got route %+v", test.name, route)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: failed to get route, err:%v", test.name, err)
+			continue
+		}
+
+		if route.Destination.String() != test.destination {
+			t.Errorf("%s: route destination is %v, expected %s", test.name, &route.Destination, test.destination)
+		}
+
+		if !route.Gateway.Equal(net.ParseIP(test.gateway)) {
+			t.Errorf("%s: route gateway is %v, expected %q", test.name, route.Gateway, test.gateway)
+		}
+
+		if route.InterfaceAddress != "10.0.0.4" {
+			t.Errorf("%s: route interface is %s, expected the primary interface 10.0.0.4", test.name, route.InterfaceAddress)
+		}
+	}
+}
+
+func TestNetworkContainerHostRouteDisabled(t *testing.T) {
+	svc := newTestService(t)
+
+	// Routes of network containers aren't touched unless host routes are enabled, even if they are invalid.
+	req := &cns.CreateNetworkContainerRequest{
+		PrimaryInterfaceIdentifier: "10.0.0.4",
+		IPConfiguration:            cns.IPConfiguration{IPSubnet: cns.IPSubnet{IPAddress: "invalid"}},
+	}
+
+	if err := svc.addNetworkContainerHostRoute(req); err != nil {
+		t.Errorf("Adding host route with host routes disabled failed, err:%v", err)
+	}
+
+	if err := svc.deleteNetworkContainerHostRoute(req); err != nil {
+		t.Errorf("Deleting host route with host routes disabled failed, err:%v", err)
+	}
+
+	// Network containers without a primary interface have no host route.
+	svc.programHostRoutes = true
+	req.PrimaryInterfaceIdentifier = ""
+
+	if err := svc.addNetworkContainerHostRoute(req); err != nil {
+		t.Errorf("Adding host route without a primary interface failed, err:%v", err)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/k8sclient"
+	acn "github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Interval between checks for network containers whose pods are gone.
+	ncGCInterval = 5 * time.Minute
+
+	// Timeout of each request listing the pods of the node.
+	ncGCListTimeout = 30 * time.Second
+)
+
+// ncGarbageCollector reclaims network containers whose pods are no longer on the node. Network
+// containers are only reclaimed once their pod has been gone for the grace period, so that pods
+// being set up or restarted keep their network container.
+type ncGarbageCollector struct {
+	clientset      kubernetes.Interface
+	nodeName       string
+	gracePeriod    time.Duration
+	orphanedSince  map[string]time.Time
+	reportedInGoal map[string]bool
+	stop           chan struct{}
+}
+
+// startNCGarbageCollection starts reclaiming orphaned network containers, if enabled.
+func (service *HTTPRestService) startNCGarbageCollection() {
+	minutes, _ := service.GetOption(acn.OptNCGCGracePeriod).(int)
+	if minutes <= 0 {
+		return
+	}
+
+	clientset, nodeName, err := k8sclient.NewInClusterClientset(ncGCListTimeout)
+	if err != nil {
+		log.Printf("[Azure CNS] Not reclaiming orphaned network containers, err:%v.", err)
+		return
+	}
+
+	gc := &ncGarbageCollector{
+		clientset:      clientset,
+		nodeName:       nodeName,
+		gracePeriod:    time.Duration(minutes) * time.Minute,
+		orphanedSince:  make(map[string]time.Time),
+		reportedInGoal: make(map[string]bool),
+		stop:           make(chan struct{}),
+	}
+	service.ncGC = gc
+
+	go func() {
+		ticker := time.NewTicker(ncGCInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-gc.stop:
+				return
+			case <-ticker.C:
+			}
+
+			if err := service.collectNetworkContainers(gc, time.Now()); err != nil {
+				log.Printf("[Azure CNS] Failed to check for orphaned network containers, err:%v.", err)
+			}
+		}
+	}()
+}
+
+// stopNCGarbageCollection stops reclaiming orphaned network containers.
+func (service *HTTPRestService) stopNCGarbageCollection() {
+	if service.ncGC != nil {
+		close(service.ncGC.stop)
+		service.ncGC = nil
+	}
+}
+
+// getLivePods returns the keys of the pods on the node, as the CNI names them in orchestrator
+// contexts. Pods are also listed under their names without suffixes, as the CNI names them
+// unless it matches pod names exactly.
+func (gc *ncGarbageCollector) getLivePods() (map[string]bool, error) {
+	pods, err := gc.clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + gc.nodeName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(map[string]bool)
+	for _, pod := range pods.Items {
+		name := pod.Name
+		for {
+			live[name+pod.Namespace] = true
+
+			i := strings.LastIndex(name, "-")
+			if i <= 0 {
+				break
+			}
+			name = name[:i]
+		}
+	}
+
+	return live, nil
+}
+
+// collectNetworkContainers reclaims the network containers whose pods have been gone for the
+// grace period. Network containers of deleted pods that are still in the goal state of DNC are
+// reported, as DNC would recreate them, and left to DNC. Delegated NICs are released instead.
+func (service *HTTPRestService) collectNetworkContainers(gc *ncGarbageCollector, now time.Time) error {
+	live, err := gc.getLivePods()
+	if err != nil {
+		return err
+	}
+
+	var deleted []string
+
+	service.lock.Lock()
+
+	// Network containers are orphaned if none of the pods they are attached to is on the node.
+	podKeys := make(map[string][]string)
+	attached := make(map[string]bool)
+	for podKey, id := range service.state.ContainerIDByOrchestratorContext {
+		podKeys[id] = append(podKeys[id], podKey)
+		if live[podKey] {
+			attached[id] = true
+		}
+	}
+
+	orphaned := make(map[string]bool)
+	for id, keys := range podKeys {
+		status, ok := service.state.ContainerStatus[id]
+		if !ok || attached[id] {
+			continue
+		}
+
+		orphaned[id] = true
+		pods := strings.Join(keys, ", ")
+
+		since, ok := gc.orphanedSince[id]
+		if !ok {
+			gc.orphanedSince[id] = now
+			continue
+		}
+
+		if now.Sub(since) < gc.gracePeriod {
+			continue
+		}
+
+		if status.CreateNetworkContainerRequest.NetworkContainerType == cns.DelegatedNIC {
+			for _, podKey := range keys {
+				delete(service.state.ContainerIDByOrchestratorContext, podKey)
+			}
+			service.saveState()
+			service.report(fmt.Sprintf("[Azure CNS] Reclaimed delegated NIC network container %s of deleted pod %s.", id, pods))
+			delete(gc.orphanedSince, id)
+			continue
+		}
+
+		if service.goalStateNetworkContainers[id] {
+			if !gc.reportedInGoal[id] {
+				gc.reportedInGoal[id] = true
+				service.report(fmt.Sprintf("[Azure CNS] Network container %s of deleted pod %s is still in the DNC goal state.", id, pods))
+			}
+			continue
+		}
+
+		deleted = append(deleted, id)
+	}
+
+	// Forget network containers whose pods came back or that were deleted.
+	for id := range gc.orphanedSince {
+		if !orphaned[id] {
+			delete(gc.orphanedSince, id)
+			delete(gc.reportedInGoal, id)
+		}
+	}
+
+	service.lock.Unlock()
+
+	for _, id := range deleted {
+		if returnCode, message := service.deleteNetworkContainerByID(id); returnCode != 0 {
+			log.Errorf("[Azure CNS] Failed to reclaim orphaned network container %s: %s", id, message)
+			continue
+		}
+
+		delete(gc.orphanedSince, id)
+		service.report(fmt.Sprintf("[Azure CNS] Reclaimed network container %s of deleted pod.", id))
+	}
+
+	return nil
+}
+
+// report logs a message and sends it as a telemetry event, if CNS reports telemetry.
+func (service *HTTPRestService) report(message string) {
+	log.Printf("%s", message)
+
+	if service.reports != nil {
+		go func(reports chan interface{}) {
+			reports <- message
+		}(service.reports)
+	}
+}
+
+// SetReportChannel sets the channel of the telemetry events of the service.
+func (service *HTTPRestService) SetReportChannel(reports chan interface{}) {
+	service.reports = reports
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// newFakeAPIServer serves the pods of a node to the garbage collector.
+func newFakeAPIServer(t *testing.T, nodeName string, pods *corev1.PodList) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/pods" || r.URL.Query().Get("fieldSelector") != "spec.nodeName="+nodeName {
+			t.Errorf("Unexpected request %v to the API server", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pods)
+	}))
+}
+
+func newPod(name string, namespace string) corev1.Pod {
+	return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+}
+
+// Reads the reports sent by the service until none is sent for a while.
+func getReports(reports chan interface{}) []string {
+	var messages []string
+	for {
+		select {
+		case report := <-reports:
+			messages = append(messages, report.(string))
+		case <-time.After(100 * time.Millisecond):
+			sort.Strings(messages)
+			return messages
+		}
+	}
+}
+
+func TestCollectNetworkContainers(t *testing.T) {
+	pods := &corev1.PodList{
+		TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"},
+		Items:    []corev1.Pod{newPod("web-1-x2b9k", "default")},
+	}
+
+	server := newFakeAPIServer(t, "node1", pods)
+	defer server.Close()
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create clientset, err:%v", err)
+	}
+
+	svc := newTestService(t)
+	reports := make(chan interface{}, 10)
+	svc.SetReportChannel(reports)
+
+	// Network containers keyed by pods as the CNI names them. The pod of web-1 is still on the node.
+	svc.state.ContainerStatus = make(map[string]containerstatus)
+	svc.state.ContainerIDByOrchestratorContext = make(map[string]string)
+	for id, podKey := range map[string]string{"nc-live": "web-1default", "nc-gone": "olddefault", "nc-goal": "keptdefault", "nc-dnic": "dpoddefault"} {
+		ncType := cns.AzureContainerInstance
+		if id == "nc-dnic" {
+			ncType = cns.DelegatedNIC
+		}
+
+		svc.state.ContainerStatus[id] = containerstatus{
+			ID:                            id,
+			CreateNetworkContainerRequest: cns.CreateNetworkContainerRequest{NetworkContainerid: id, NetworkContainerType: ncType},
+		}
+		svc.state.ContainerIDByOrchestratorContext[podKey] = id
+	}
+
+	// DNC would recreate network containers still in its goal state.
+	svc.goalStateNetworkContainers = map[string]bool{"nc-goal": true}
+
+	gc := &ncGarbageCollector{
+		clientset:      clientset,
+		nodeName:       "node1",
+		gracePeriod:    10 * time.Minute,
+		orphanedSince:  make(map[string]time.Time),
+		reportedInGoal: make(map[string]bool),
+	}
+
+	// Nothing is reclaimed within the grace period.
+	now := time.Now()
+	for _, elapsed := range []time.Duration{0, 5 * time.Minute} {
+		if err = svc.collectNetworkContainers(gc, now.Add(elapsed)); err != nil {
+			t.Fatalf("Failed to collect network containers, err:%v", err)
+		}
+
+		if ids := getNetworkContainerIDs(svc); len(ids) != 4 {
+			t.Fatalf("Network containers after %v are %v, expected none reclaimed", elapsed, ids)
+		}
+	}
+
+	if messages := getReports(reports); len(messages) != 0 {
+		t.Errorf("Reported %v within the grace period", messages)
+	}
+
+	// Once the grace period passes, orphaned network containers are deleted and delegated NICs released.
+	for i := 0; i < 2; i++ {
+		if err = svc.collectNetworkContainers(gc, now.Add(11*time.Minute)); err != nil {
+			t.Fatalf("Failed to collect network containers, err:%v", err)
+		}
+	}
+
+	if ids := getNetworkContainerIDs(svc); !reflect.DeepEqual(ids, []string{"nc-dnic", "nc-goal", "nc-live"}) {
+		t.Errorf("Network containers after the grace period are %v, expected [nc-dnic nc-goal nc-live]", ids)
+	}
+
+	expected := map[string]string{"web-1default": "nc-live", "keptdefault": "nc-goal"}
+	if !reflect.DeepEqual(svc.state.ContainerIDByOrchestratorContext, expected) {
+		t.Errorf("Pods of network containers are %v, expected %v", svc.state.ContainerIDByOrchestratorContext, expected)
+	}
+
+	// Network containers still in the goal state are only reported once.
+	messages := getReports(reports)
+	if len(messages) != 3 ||
+		!strings.Contains(messages[0], "Network container nc-goal") ||
+		!strings.Contains(messages[1], "Reclaimed delegated NIC network container nc-dnic") ||
+		!strings.Contains(messages[2], "Reclaimed network container nc-gone") {
+		t.Errorf("Reported %q, expected the reclaimed nc-dnic and nc-gone and the nc-goal still in the goal state", messages)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Interval after which the secondary addresses of the node are learned from the host again.
+	nodeSubnetRefreshInterval = 5 * time.Minute
+)
+
+// nodeSubnetIPAM serves the secondary addresses of the primary interface of the node, as
+// programmed by the host, to the CNI directly. Allocations are persisted in CNS state.
+type nodeSubnetIPAM struct {
+	subnet    *net.IPNet
+	gateway   string
+	addresses []string
+	refreshed time.Time
+}
+
+// refreshNodeSubnet learns the subnet and secondary addresses of the node from the host if they
+// weren't learned recently or all of them are allocated.
+func (service *HTTPRestService) refreshNodeSubnet(force bool) error {
+	service.lock.Lock()
+	stale := force || service.nodeSubnet.subnet == nil ||
+		time.Since(service.nodeSubnet.refreshed) > nodeSubnetRefreshInterval
+	service.lock.Unlock()
+
+	if !stale {
+		return nil
+	}
+
+	ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromHost()
+	if err != nil {
+		return err
+	}
+
+	_, subnet, err := net.ParseCIDR(ifInfo.Subnet)
+	if err != nil {
+		return fmt.Errorf("Malformed subnet %s received from host", ifInfo.Subnet)
+	}
+
+	addresses := append([]string{}, ifInfo.SecondaryIPs...)
+	sort.Strings(addresses)
+
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	if len(addresses) != len(service.nodeSubnet.addresses) {
+		log.Printf("[Azure CNS] Node subnet %v has %d secondary addresses.", subnet, len(addresses))
+	}
+
+	service.nodeSubnet.subnet = subnet
+	service.nodeSubnet.gateway = ifInfo.Gateway
+	service.nodeSubnet.addresses = addresses
+	service.nodeSubnet.refreshed = time.Now()
+
+	return nil
+}
+
+// requestNodeSubnetIPConfig allocates a secondary address of the node to a pod interface.
+// Requests for a pod interface that already holds an address return that address.
+func (service *HTTPRestService) requestNodeSubnetIPConfig(podInterfaceID string) (cns.IPConfiguration, int, string) {
+	if err := service.refreshNodeSubnet(false); err != nil {
+		return cns.IPConfiguration{}, UnreachableHost, fmt.Sprintf("[Azure CNS] Failed to get node subnet from host: %v", err)
+	}
+
+	address, ok := service.allocateNodeSubnetAddress(podInterfaceID)
+	if !ok {
+		// Addresses may have been added to the node since they were last learned.
+		if err := service.refreshNodeSubnet(true); err != nil {
+			return cns.IPConfiguration{}, UnreachableHost, fmt.Sprintf("[Azure CNS] Failed to get node subnet from host: %v", err)
+		}
+
+		if address, ok = service.allocateNodeSubnetAddress(podInterfaceID); !ok {
+			return cns.IPConfiguration{}, AddressUnavailable, "[Azure CNS] No secondary address of the node is available"
+		}
+	}
+
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	prefixLength, _ := service.nodeSubnet.subnet.Mask.Size()
+
+	return cns.IPConfiguration{
+		IPSubnet: cns.IPSubnet{
+			IPAddress:    address,
+			PrefixLength: uint8(prefixLength),
+		},
+		GatewayIPAddress: service.nodeSubnet.gateway,
+	}, 0, ""
+}
+
+// allocateNodeSubnetAddress returns the address of a pod interface, allocating a free one if it
+// has none.
+func (service *HTTPRestService) allocateNodeSubnetAddress(podInterfaceID string) (string, bool) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	if address, ok := service.state.NodeSubnetAllocations[podInterfaceID]; ok {
+		return address, true
+	}
+
+	allocated := make(map[string]bool)
+	for _, address := range service.state.NodeSubnetAllocations {
+		allocated[address] = true
+	}
+
+	// Egress addresses of pods aren't allocated to other pods.
+	for _, address := range service.state.EgressIPs {
+		allocated[address] = true
+	}
+
+	for _, address := range service.nodeSubnet.addresses {
+		if allocated[address] {
+			continue
+		}
+
+		if service.state.NodeSubnetAllocations == nil {
+			service.state.NodeSubnetAllocations = make(map[string]string)
+		}
+
+		service.state.NodeSubnetAllocations[podInterfaceID] = address
+		service.saveState()
+
+		log.Printf("[Azure CNS] Allocated node subnet address %s to %s.", address, podInterfaceID)
+		return address, true
+	}
+
+	return "", false
+}
+
+// releaseNodeSubnetIPConfig releases the address of a pod interface. Releasing a pod interface
+// without an address succeeds, so that retried releases are harmless.
+func (service *HTTPRestService) releaseNodeSubnetIPConfig(podInterfaceID string) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	address, ok := service.state.NodeSubnetAllocations[podInterfaceID]
+	if !ok {
+		log.Printf("[Azure CNS] No node subnet address is allocated to %s.", podInterfaceID)
+		return
+	}
+
+	delete(service.state.NodeSubnetAllocations, podInterfaceID)
+	service.saveState()
+
+	log.Printf("[Azure CNS] Released node subnet address %s of %s.", address, podInterfaceID)
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+)
+
+func TestNodeSubnetIPConfig(t *testing.T) {
+	svc := service.(*HTTPRestService)
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/24")
+
+	// Serve addresses learned from the host recently, so that requests don't query the host.
+	svc.lock.Lock()
+	svc.nodeSubnet = &nodeSubnetIPAM{
+		subnet:    subnet,
+		gateway:   "10.0.0.1",
+		addresses: []string{"10.0.0.5", "10.0.0.6", "10.0.0.7"},
+		refreshed: time.Now(),
+	}
+	svc.state.EgressIPs = map[string]string{"egress-pod": "10.0.0.5"}
+	svc.lock.Unlock()
+
+	defer func() {
+		svc.lock.Lock()
+		svc.nodeSubnet = nil
+		svc.state.NodeSubnetAllocations = nil
+		svc.state.EgressIPs = nil
+		svc.lock.Unlock()
+	}()
+
+	request := func(podInterfaceID string) cns.IPConfigResponse {
+		var resp cns.IPConfigResponse
+		postRequest(t, cns.RequestIPConfigPath, &cns.IPConfigRequest{PodInterfaceID: podInterfaceID}, &resp)
+		return resp
+	}
+
+	release := func(podInterfaceID string) {
+		var resp cns.Response
+		postRequest(t, cns.ReleaseIPConfigPath, &cns.IPConfigRequest{PodInterfaceID: podInterfaceID}, &resp)
+		if resp.ReturnCode != 0 {
+			t.Errorf("ReleaseIPConfig of %s failed with response %+v", podInterfaceID, resp)
+		}
+	}
+
+	// The egress address of a pod isn't allocated to other pods.
+	expected := cns.IPConfiguration{
+		IPSubnet:         cns.IPSubnet{IPAddress: "10.0.0.6", PrefixLength: 24},
+		GatewayIPAddress: "10.0.0.1",
+	}
+
+	if resp := request("pod1"); resp.Response.ReturnCode != 0 || resp.IPConfiguration.IPSubnet != expected.IPSubnet ||
+		resp.IPConfiguration.GatewayIPAddress != expected.GatewayIPAddress {
+		t.Errorf("RequestIPConfig of pod1 returned %+v, expected %+v", resp, expected)
+	}
+
+	// Retried requests return the address already allocated.
+	if resp := request("pod1"); resp.IPConfiguration.IPSubnet.IPAddress != "10.0.0.6" {
+		t.Errorf("Retried RequestIPConfig of pod1 returned %+v, expected 10.0.0.6", resp)
+	}
+
+	if resp := request("pod2"); resp.IPConfiguration.IPSubnet.IPAddress != "10.0.0.7" {
+		t.Errorf("RequestIPConfig of pod2 returned %+v, expected 10.0.0.7", resp)
+	}
+
+	// Released addresses are allocated again, and retried releases succeed.
+	release("pod1")
+	release("pod1")
+
+	if resp := request("pod3"); resp.IPConfiguration.IPSubnet.IPAddress != "10.0.0.6" {
+		t.Errorf("RequestIPConfig of pod3 returned %+v, expected the released 10.0.0.6", resp)
+	}
+
+	if resp := request(""); resp.Response.ReturnCode != ReservationNotFound {
+		t.Errorf("RequestIPConfig without a pod interface returned %+v, expected ReservationNotFound", resp)
+	}
+}
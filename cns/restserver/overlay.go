@@ -0,0 +1,119 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// validateOverlayVteps checks that VTEPs have IPv4 node addresses and pod prefixes, and that no two
+// VTEPs serve overlapping pod prefixes.
+func validateOverlayVteps(vteps []cns.OverlayVtep) error {
+	var prefixes []*net.IPNet
+
+	for _, vtep := range vteps {
+		if ip := net.ParseIP(vtep.NodeIP); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("Invalid node address %q", vtep.NodeIP)
+		}
+
+		_, prefix, err := net.ParseCIDR(vtep.PodPrefix)
+		if err != nil || prefix.IP.To4() == nil {
+			return fmt.Errorf("Invalid pod prefix %q of node %s", vtep.PodPrefix, vtep.NodeIP)
+		}
+
+		for _, other := range prefixes {
+			if other.Contains(prefix.IP) || prefix.Contains(other.IP) {
+				return fmt.Errorf("Pod prefix %s of node %s overlaps pod prefix %s", prefix, vtep.NodeIP, other)
+			}
+		}
+
+		prefixes = append(prefixes, prefix)
+	}
+
+	return nil
+}
+
+// Handles requests to get the VTEPs of the nodes in the overlay network.
+func (service *HTTPRestService) getOverlayVteps(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] getOverlayVteps")
+
+	var req cns.GetOverlayVtepsRequest
+	var vteps []cns.OverlayVtep
+	returnMessage := ""
+	returnCode := 0
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		service.lock.Lock()
+		vteps = append(vteps, service.state.OverlayVteps...)
+		service.lock.Unlock()
+
+	default:
+		returnMessage = "[Azure CNS] Error. GetOverlayVteps did not receive a POST."
+		returnCode = InvalidParameter
+	}
+
+	resp := cns.Response{
+		ReturnCode: returnCode,
+		Message:    returnMessage,
+	}
+
+	vtepsResp := &cns.GetOverlayVtepsResponse{Response: resp, Vteps: vteps}
+	err = service.Listener.Encode(w, &vtepsResp)
+	log.Response(service.Name, vtepsResp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+}
+
+// Handles requests to replace the VTEPs of the nodes in the overlay network.
+func (service *HTTPRestService) setOverlayVteps(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] setOverlayVteps")
+
+	var req cns.SetOverlayVtepsRequest
+	returnMessage := ""
+	returnCode := 0
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		if err = validateOverlayVteps(req.Vteps); err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. %v", err)
+			returnCode = InvalidParameter
+			break
+		}
+
+		service.lock.Lock()
+		service.state.OverlayVteps = req.Vteps
+		service.saveState()
+		service.lock.Unlock()
+
+		log.Printf("[Azure CNS] Set %d overlay VTEPs.", len(req.Vteps))
+
+	default:
+		returnMessage = "[Azure CNS] Error. SetOverlayVteps did not receive a POST."
+		returnCode = InvalidParameter
+	}
+
+	resp := cns.Response{
+		ReturnCode: returnCode,
+		Message:    returnMessage,
+	}
+
+	err = service.Listener.Encode(w, &resp)
+	log.Response(service.Name, resp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+}
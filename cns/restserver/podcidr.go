@@ -0,0 +1,138 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/Azure/azure-container-networking/cns"
+	acn "github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// initPodCIDR sets up serving the pod CIDR of the node to the CNI. The platform routes the pod
+// CIDR to the node and translates pod addresses leaving the virtual network, so pods don't need
+// network containers. Allocations outside of the pod CIDR, left behind by a previous pod CIDR of
+// the node, are released.
+func (service *HTTPRestService) initPodCIDR() error {
+	s, _ := service.GetOption(acn.OptPodCIDR).(string)
+	_, podCIDR, err := net.ParseCIDR(s)
+	if err != nil || podCIDR.IP.To4() == nil {
+		return fmt.Errorf("Invalid pod CIDR %q", s)
+	}
+
+	if ones, _ := podCIDR.Mask.Size(); ones > 30 {
+		return fmt.Errorf("Pod CIDR %v has no room for pods", podCIDR)
+	}
+
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	service.podCIDR = podCIDR
+
+	stale := false
+	for id, address := range service.state.PodCIDRAllocations {
+		if !podCIDR.Contains(net.ParseIP(address)) {
+			log.Printf("[Azure CNS] Released address %s of %s outside of pod CIDR %v.", address, id, podCIDR)
+			delete(service.state.PodCIDRAllocations, id)
+			stale = true
+		}
+	}
+
+	if stale {
+		service.saveState()
+	}
+
+	log.Printf("[Azure CNS] Serving IP configurations from pod CIDR %v.", podCIDR)
+
+	return nil
+}
+
+// requestPodCIDRIPConfig allocates an address of the pod CIDR to a pod interface. Pods reach the
+// network through the node, so the gateway is the primary address of the node. Requests for a pod
+// interface that already holds an address return that address.
+func (service *HTTPRestService) requestPodCIDRIPConfig(podInterfaceID string) (cns.IPConfiguration, int, string) {
+	ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
+	if err != nil {
+		return cns.IPConfiguration{}, UnexpectedError, fmt.Sprintf("[Azure CNS] Error. GetPrimaryIfaceInfo failed %v", err.Error())
+	}
+
+	address, ok := service.allocatePodCIDRAddress(podInterfaceID)
+	if !ok {
+		return cns.IPConfiguration{}, AddressUnavailable, fmt.Sprintf("[Azure CNS] No address of pod CIDR %v is available", service.podCIDR)
+	}
+
+	prefixLength, _ := service.podCIDR.Mask.Size()
+
+	return cns.IPConfiguration{
+		IPSubnet: cns.IPSubnet{
+			IPAddress:    address,
+			PrefixLength: uint8(prefixLength),
+		},
+		GatewayIPAddress: ifInfo.PrimaryIP,
+	}, 0, ""
+}
+
+// allocatePodCIDRAddress returns the address of a pod interface, allocating the lowest free host
+// address of the pod CIDR if it has none.
+func (service *HTTPRestService) allocatePodCIDRAddress(podInterfaceID string) (string, bool) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	if address, ok := service.state.PodCIDRAllocations[podInterfaceID]; ok {
+		return address, true
+	}
+
+	allocated := make(map[string]bool)
+	for _, address := range service.state.PodCIDRAllocations {
+		allocated[address] = true
+	}
+
+	ones, bits := service.podCIDR.Mask.Size()
+	network := binary.BigEndian.Uint32(service.podCIDR.IP.To4())
+	broadcast := network | (1<<uint(bits-ones) - 1)
+
+	// The network and broadcast addresses aren't host addresses.
+	for n := network + 1; n < broadcast; n++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, n)
+		address := ip.String()
+
+		if allocated[address] {
+			continue
+		}
+
+		if service.state.PodCIDRAllocations == nil {
+			service.state.PodCIDRAllocations = make(map[string]string)
+		}
+
+		service.state.PodCIDRAllocations[podInterfaceID] = address
+		service.saveState()
+
+		log.Printf("[Azure CNS] Allocated pod CIDR address %s to %s.", address, podInterfaceID)
+		return address, true
+	}
+
+	return "", false
+}
+
+// releasePodCIDRIPConfig releases the address of a pod interface. Releasing a pod interface
+// without an address succeeds, so that retried releases are harmless.
+func (service *HTTPRestService) releasePodCIDRIPConfig(podInterfaceID string) {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	address, ok := service.state.PodCIDRAllocations[podInterfaceID]
+	if !ok {
+		log.Printf("[Azure CNS] No pod CIDR address is allocated to %s.", podInterfaceID)
+		return
+	}
+
+	delete(service.state.PodCIDRAllocations, podInterfaceID)
+	service.saveState()
+
+	log.Printf("[Azure CNS] Released pod CIDR address %s of %s.", address, podInterfaceID)
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"testing"
+
+	acn "github.com/Azure/azure-container-networking/common"
+)
+
+func TestInitPodCIDR(t *testing.T) {
+	for _, podCIDR := range []string{"", "10.244.0", "fd00::/64", "10.244.0.0/31"} {
+		svc := newTestService(t)
+		svc.SetOption(acn.OptPodCIDR, podCIDR)
+
+		if err := svc.initPodCIDR(); err == nil {
+			t.Errorf("Initializing pod CIDR %q succeeded, expected an error", podCIDR)
+		}
+	}
+
+	// Allocations outside of the pod CIDR are released.
+	svc := newTestService(t)
+	svc.SetOption(acn.OptPodCIDR, "10.244.1.0/24")
+	svc.state.PodCIDRAllocations = map[string]string{"pod1": "10.244.0.2", "pod2": "10.244.1.7"}
+
+	if err := svc.initPodCIDR(); err != nil {
+		t.Fatalf("Failed to initialize pod CIDR, err:%v", err)
+	}
+
+	if svc.podCIDR.String() != "10.244.1.0/24" {
+		t.Errorf("Pod CIDR is %v, expected 10.244.1.0/24", svc.podCIDR)
+	}
+
+	if len(svc.state.PodCIDRAllocations) != 1 || svc.state.PodCIDRAllocations["pod2"] != "10.244.1.7" {
+		t.Errorf("Pod CIDR allocations are %v, expected only pod2", svc.state.PodCIDRAllocations)
+	}
+}
+
+func TestAllocatePodCIDRAddress(t *testing.T) {
+	svc := newTestService(t)
+	svc.SetOption(acn.OptPodCIDR, "10.244.0.0/30")
+
+	if err := svc.initPodCIDR(); err != nil {
+		t.Fatalf("Failed to initialize pod CIDR, err:%v", err)
+	}
+
+	allocate := func(podInterfaceID string, expected string) {
+		address, ok := svc.allocatePodCIDRAddress(podInterfaceID)
+		if address != expected || ok != (expected != "") {
+			t.Errorf("Allocated %q, %v to %s, expected %q", address, ok, podInterfaceID, expected)
+		}
+	}
+
+	// The network and broadcast addresses aren't allocated, and retried requests return the same address.
+	allocate("pod1", "10.244.0.1")
+	allocate("pod2", "10.244.0.2")
+	allocate("pod1", "10.244.0.1")
+	allocate("pod3", "")
+
+	// The lowest free address is allocated after a release, and retried releases succeed.
+	svc.releasePodCIDRIPConfig("pod1")
+	svc.releasePodCIDRIPConfig("pod1")
+	allocate("pod3", "10.244.0.1")
+}
@@ -4,6 +4,7 @@
 package restserver
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -12,12 +13,17 @@ import (
 	"time"
 
 	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/cns/bgp"
 	"github.com/Azure/azure-container-networking/cns/common"
 	"github.com/Azure/azure-container-networking/cns/dockerclient"
 	"github.com/Azure/azure-container-networking/cns/imdsclient"
 	"github.com/Azure/azure-container-networking/cns/ipamclient"
+	"github.com/Azure/azure-container-networking/cns/k8sevents"
+	"github.com/Azure/azure-container-networking/cns/msiclient"
 	"github.com/Azure/azure-container-networking/cns/networkcontainers"
+	"github.com/Azure/azure-container-networking/cns/noderesources"
 	"github.com/Azure/azure-container-networking/cns/routes"
+	acn "github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/platform"
 	"github.com/Azure/azure-container-networking/store"
@@ -27,20 +33,46 @@ const (
 	// Key against which CNS state is persisted.
 	storeKey        = "ContainerNetworkService"
 	swiftAPIVersion = "1"
+
+	// Schema version of CNS state.
+	schemaVersion = 1
 )
 
+// Layout of persisted CNS state, with the migrations from older versions.
+var schema = &store.Schema{
+	Key:     storeKey,
+	Version: schemaVersion,
+}
+
 // HTTPRestService represents http listener for CNS - Container Networking Service.
 type HTTPRestService struct {
 	*cns.Service
-	dockerClient     *dockerclient.DockerClient
-	imdsClient       *imdsclient.ImdsClient
-	ipamClient       *ipamclient.IpamClient
-	networkContainer *networkcontainers.NetworkContainers
-	routingTable     *routes.RoutingTable
-	store            store.KeyValueStore
-	state            *httpRestServiceState
-	lock             sync.Mutex
-	dncPartitionKey  string
+	dockerClient        *dockerclient.DockerClient
+	imdsClient          *imdsclient.ImdsClient
+	ipamClient          *ipamclient.IpamClient
+	networkContainer    *networkcontainers.NetworkContainers
+	routingTable        *routes.RoutingTable
+	store               store.KeyValueStore
+	state               *httpRestServiceState
+	lock                sync.Mutex
+	dncPartitionKey     string
+	rebooted            bool
+	compactionThreshold int
+	stopCompaction      chan struct{}
+	stopGoalStateWatch  context.CancelFunc
+	dncTokenProvider    *msiclient.TokenProvider
+	nodeSubnet          *nodeSubnetIPAM
+	podCIDR             *net.IPNet
+	eventRecorder       *k8sevents.Recorder
+	homeAz              homeAzCache
+	programHostRoutes   bool
+
+	delegatedNICAdvertiser *noderesources.Advertiser
+	bgpSpeaker             *bgp.Speaker
+
+	goalStateNetworkContainers map[string]bool
+	ncGC                       *ncGarbageCollector
+	reports                    chan interface{}
 }
 
 // containerstatus is used to save status of an existing container
@@ -53,6 +85,7 @@ type containerstatus struct {
 
 // httpRestServiceState contains the state we would like to persist.
 type httpRestServiceState struct {
+	SchemaVersion                    int
 	Location                         string
 	NetworkType                      string
 	OrchestratorType                 string
@@ -60,6 +93,13 @@ type httpRestServiceState struct {
 	ContainerIDByOrchestratorContext map[string]string          // OrchestratorContext is key and value is NetworkContainerID.
 	ContainerStatus                  map[string]containerstatus // NetworkContainerID is key.
 	Networks                         map[string]*networkInfo
+	ClientState                      map[string]json.RawMessage // Opaque state persisted on behalf of node-local clients.
+	ClientStateTimeStamp             time.Time
+	GoalStateVersion                 int64             // Version of the goal state streamed by DNC last applied.
+	NodeSubnetAllocations            map[string]string // PodInterfaceID is key and value is the allocated node subnet address.
+	EgressIPs                        map[string]string // PodInterfaceID is key and value is the reserved egress address.
+	PodCIDRAllocations               map[string]string // PodInterfaceID is key and value is the allocated pod CIDR address.
+	OverlayVteps                     []cns.OverlayVtep // VTEPs of the nodes in the overlay network.
 	TimeStamp                        time.Time
 }
 
@@ -132,6 +172,58 @@ func (service *HTTPRestService) Start(config *common.ServiceConfig) error {
 		return err
 	}
 
+	// Prune state left behind by older versions before serving requests.
+	service.lock.Lock()
+	if service.compactState() > 0 {
+		service.saveState()
+	}
+	service.lock.Unlock()
+
+	service.startCompaction()
+
+	// Publish failures as Kubernetes events when running in a cluster.
+	if recorder, err := k8sevents.NewInClusterRecorder(cns.ServiceName); err == nil {
+		service.eventRecorder = recorder
+	} else {
+		log.Printf("[Azure CNS] Not publishing Kubernetes events, err:%v.", err)
+	}
+
+	// Advertise delegated NICs as a resource of the node pods can request.
+	service.startDelegatedNICAdvertiser()
+
+	// Reclaim network containers of pods that are gone.
+	service.startNCGarbageCollection()
+
+	// Advertise the prefixes of network containers to BGP peers.
+	service.startBGPSpeaker()
+
+	// Serve the secondary addresses of the node to the CNI instead of going through the IPAM plugin.
+	switch mode, _ := service.GetOption(acn.OptIPAMMode).(string); mode {
+	case acn.OptIPAMModeNodeSubnet:
+		log.Printf("[Azure CNS] Serving IP configurations from the node subnet.")
+		service.nodeSubnet = &nodeSubnetIPAM{}
+	case acn.OptIPAMModePodCIDR:
+		// Serve the pod CIDR of the node, routed to the node by the platform in overlay clusters.
+		if err = service.initPodCIDR(); err != nil {
+			log.Errorf("[Azure CNS]  Failed to initialize pod CIDR, err:%v.", err)
+			return err
+		}
+	}
+
+	// Route the prefixes of network containers to their interface on the host.
+	service.programHostRoutes, _ = service.GetOption(acn.OptProgramHostRoutes).(bool)
+
+	// Authenticate calls to DNC with tokens of the managed identity of the VM.
+	if resource, ok := service.GetOption(acn.OptDNCAuthResource).(string); ok && resource != "" {
+		clientID, _ := service.GetOption(acn.OptManagedIdentityClientID).(string)
+		service.dncTokenProvider = msiclient.NewTokenProvider(resource, clientID)
+	}
+
+	// Receive the goal state of network containers from DNC if it streams it.
+	if url, ok := service.GetOption(acn.OptGoalStateURL).(string); ok && url != "" {
+		service.startGoalStateWatch(url)
+	}
+
 	// Add handlers.
 	listener := service.Listener
 	// default handlers
@@ -149,6 +241,17 @@ func (service *HTTPRestService) Start(config *common.ServiceConfig) error {
 	listener.AddHandler(cns.GetInterfaceForContainer, service.getInterfaceForContainer)
 	listener.AddHandler(cns.SetOrchestratorType, service.setOrchestratorType)
 	listener.AddHandler(cns.GetNetworkContainerByOrchestratorContext, service.getNetworkContainerByOrchestratorContext)
+	listener.AddHandler(cns.ReleaseNetworkContainer, service.releaseNetworkContainer)
+	listener.AddHandler(cns.RequestIPConfigPath, service.requestIPConfig)
+	listener.AddHandler(cns.ReleaseIPConfigPath, service.releaseIPConfig)
+	listener.AddHandler(cns.GetClientStatePath, service.getClientState)
+	listener.AddHandler(cns.SetClientStatePath, service.setClientState)
+	listener.AddHandler(cns.GetHealthReportPath, service.getHealthReport)
+	listener.AddHandler(cns.GetHomeAzPath, service.getHomeAz)
+	listener.AddHandler(cns.ReserveEgressIPPath, service.reserveEgressIP)
+	listener.AddHandler(cns.ReleaseEgressIPPath, service.releaseEgressIP)
+	listener.AddHandler(cns.GetOverlayVtepsPath, service.getOverlayVteps)
+	listener.AddHandler(cns.SetOverlayVtepsPath, service.setOverlayVteps)
 
 	// handlers for v0.2
 	listener.AddHandler(cns.V2Prefix+cns.SetEnvironmentPath, service.setEnvironment)
@@ -165,6 +268,24 @@ func (service *HTTPRestService) Start(config *common.ServiceConfig) error {
 	listener.AddHandler(cns.V2Prefix+cns.GetInterfaceForContainer, service.getInterfaceForContainer)
 	listener.AddHandler(cns.V2Prefix+cns.SetOrchestratorType, service.setOrchestratorType)
 	listener.AddHandler(cns.V2Prefix+cns.GetNetworkContainerByOrchestratorContext, service.getNetworkContainerByOrchestratorContext)
+	listener.AddHandler(cns.V2Prefix+cns.ReleaseNetworkContainer, service.releaseNetworkContainer)
+	listener.AddHandler(cns.V2Prefix+cns.RequestIPConfigPath, service.requestIPConfig)
+	listener.AddHandler(cns.V2Prefix+cns.ReleaseIPConfigPath, service.releaseIPConfig)
+	listener.AddHandler(cns.V2Prefix+cns.GetClientStatePath, service.getClientState)
+	listener.AddHandler(cns.V2Prefix+cns.SetClientStatePath, service.setClientState)
+	listener.AddHandler(cns.V2Prefix+cns.GetHealthReportPath, service.getHealthReport)
+	listener.AddHandler(cns.V2Prefix+cns.GetHomeAzPath, service.getHomeAz)
+	listener.AddHandler(cns.V2Prefix+cns.ReserveEgressIPPath, service.reserveEgressIP)
+	listener.AddHandler(cns.V2Prefix+cns.ReleaseEgressIPPath, service.releaseEgressIP)
+	listener.AddHandler(cns.V2Prefix+cns.GetOverlayVtepsPath, service.getOverlayVteps)
+	listener.AddHandler(cns.V2Prefix+cns.SetOverlayVtepsPath, service.setOverlayVteps)
+
+	// handlers for v2, and the negotiation of the version clients use
+	service.addAPIV2Handlers()
+	listener.AddHandler(cns.NegotiateAPIVersionPath, service.negotiateAPIVersion)
+
+	// Advertise the features of this version to clients.
+	listener.AdvertiseCapabilities(service)
 
 	log.Printf("[Azure CNS]  Listening.")
 	return nil
@@ -172,10 +293,25 @@ func (service *HTTPRestService) Start(config *common.ServiceConfig) error {
 
 // Stop stops the CNS.
 func (service *HTTPRestService) Stop() {
+	service.stopWatchingGoalState()
+	service.stopDelegatedNICAdvertiser()
+	service.stopBGPSpeaker()
+	service.stopNCGarbageCollection()
+	service.stopCompacting()
 	service.Uninitialize()
 	log.Printf("[Azure CNS]  Service stopped.")
 }
 
+// GetCapabilities returns the API versions and features supported by CNS.
+func (service *HTTPRestService) GetCapabilities() *acn.Capabilities {
+	return &acn.Capabilities{
+		Name:        cns.ServiceName,
+		Version:     service.Version,
+		APIVersions: cns.APIVersions,
+		Features:    cns.Features,
+	}
+}
+
 // Get dnc/service partition key
 func (service *HTTPRestService) GetPartitionKey() (dncPartitionKey string) {
 	service.lock.Lock()
@@ -515,6 +651,270 @@ func (service *HTTPRestService) releaseIPAddress(w http.ResponseWriter, r *http.
 	log.Response(service.Name, resp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
 }
 
+// Handles ip configuration requests from the CNI IPAM plugin running in delegated mode.
+func (service *HTTPRestService) requestIPConfig(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] requestIPConfig")
+
+	var req cns.IPConfigRequest
+	var ipConfig cns.IPConfiguration
+	returnMessage := ""
+	returnCode := 0
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		if req.PodInterfaceID == "" {
+			returnCode = ReservationNotFound
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. PodInterfaceID is empty")
+			break
+		}
+
+		if service.nodeSubnet != nil {
+			ipConfig, returnCode, returnMessage = service.requestNodeSubnetIPConfig(req.PodInterfaceID)
+			break
+		}
+
+		if service.podCIDR != nil {
+			ipConfig, returnCode, returnMessage = service.requestPodCIDRIPConfig(req.PodInterfaceID)
+			break
+		}
+
+		ic := service.ipamClient
+
+		ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
+		if err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryIfaceInfo failed %v", err.Error())
+			returnCode = UnexpectedError
+			break
+		}
+
+		asID, err := ic.GetAddressSpace()
+		if err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetAddressSpace failed %v", err.Error())
+			returnCode = UnexpectedError
+			break
+		}
+
+		poolID, err := ic.GetPoolID(asID, ifInfo.Subnet)
+		if err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPoolID failed %v", err.Error())
+			returnCode = UnexpectedError
+			break
+		}
+
+		addr, err := ic.ReserveIPAddress(poolID, req.PodInterfaceID)
+		if err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] ReserveIpAddress failed with %+v", err.Error())
+			returnCode = AddressUnavailable
+			break
+		}
+
+		addressIP, subnet, err := net.ParseCIDR(addr)
+		if err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] ParseCIDR failed with %+v", err.Error())
+			returnCode = UnexpectedError
+			break
+		}
+
+		prefixLength, _ := subnet.Mask.Size()
+		ipConfig = cns.IPConfiguration{
+			IPSubnet: cns.IPSubnet{
+				IPAddress:    addressIP.String(),
+				PrefixLength: uint8(prefixLength),
+			},
+			GatewayIPAddress: ifInfo.Gateway,
+		}
+
+	default:
+		returnMessage = "[Azure CNS] Error. RequestIPConfig did not receive a POST."
+		returnCode = InvalidParameter
+	}
+
+	resp := cns.Response{
+		ReturnCode: returnCode,
+		Message:    returnMessage,
+	}
+
+	ipConfigResp := &cns.IPConfigResponse{Response: resp, IPConfiguration: ipConfig}
+	err = service.Listener.Encode(w, &ipConfigResp)
+	log.Response(service.Name, ipConfigResp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+}
+
+// Handles ip configuration release requests from the CNI IPAM plugin running in delegated mode.
+func (service *HTTPRestService) releaseIPConfig(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] releaseIPConfig")
+
+	var req cns.IPConfigRequest
+	returnMessage := ""
+	returnCode := 0
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		if req.PodInterfaceID == "" {
+			returnCode = ReservationNotFound
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. PodInterfaceID is empty")
+			break
+		}
+
+		if service.nodeSubnet != nil {
+			service.releaseNodeSubnetIPConfig(req.PodInterfaceID)
+			break
+		}
+
+		if service.podCIDR != nil {
+			service.releasePodCIDRIPConfig(req.PodInterfaceID)
+			break
+		}
+
+		ic := service.ipamClient
+
+		ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
+		if err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryIfaceInfo failed %v", err.Error())
+			returnCode = UnexpectedError
+			break
+		}
+
+		asID, err := ic.GetAddressSpace()
+		if err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetAddressSpace failed %v", err.Error())
+			returnCode = UnexpectedError
+			break
+		}
+
+		poolID, err := ic.GetPoolID(asID, ifInfo.Subnet)
+		if err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPoolID failed %v", err.Error())
+			returnCode = UnexpectedError
+			break
+		}
+
+		err = ic.ReleaseIPAddress(poolID, req.PodInterfaceID)
+		if err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] ReleaseIpAddress failed with %+v", err.Error())
+			returnCode = ReservationNotFound
+		}
+
+	default:
+		returnMessage = "[Azure CNS] Error. ReleaseIPConfig did not receive a POST."
+		returnCode = InvalidParameter
+	}
+
+	resp := cns.Response{
+		ReturnCode: returnCode,
+		Message:    returnMessage,
+	}
+
+	err = service.Listener.Encode(w, &resp)
+	log.Response(service.Name, resp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+}
+
+// Handles requests to read state persisted on behalf of a node-local client.
+func (service *HTTPRestService) getClientState(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] getClientState")
+
+	var req cns.GetClientStateRequest
+	var value json.RawMessage
+	var timeStamp time.Time
+	returnMessage := ""
+	returnCode := 0
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		service.lock.Lock()
+		timeStamp = service.state.ClientStateTimeStamp
+		if req.Key != "" {
+			var ok bool
+			value, ok = service.state.ClientState[req.Key]
+			if !ok || isClientStateTombstone(value) {
+				returnMessage = fmt.Sprintf("[Azure CNS] Client state %v not found.", req.Key)
+				returnCode = NotFound
+			}
+		}
+		service.lock.Unlock()
+
+	default:
+		returnMessage = "[Azure CNS] Error. GetClientState did not receive a POST."
+		returnCode = InvalidParameter
+	}
+
+	resp := cns.Response{
+		ReturnCode: returnCode,
+		Message:    returnMessage,
+	}
+
+	stateResp := &cns.GetClientStateResponse{Response: resp, Value: value, TimeStamp: timeStamp}
+	err = service.Listener.Encode(w, &stateResp)
+	log.Response(service.Name, stateResp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+}
+
+// Handles requests to persist state on behalf of a node-local client.
+func (service *HTTPRestService) setClientState(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] setClientState")
+
+	var req cns.SetClientStateRequest
+	returnMessage := ""
+	returnCode := 0
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		if req.Key == "" {
+			returnMessage = "[Azure CNS] Error. Client state key is empty."
+			returnCode = InvalidParameter
+			break
+		}
+
+		service.lock.Lock()
+		if service.state.ClientState == nil {
+			service.state.ClientState = make(map[string]json.RawMessage)
+		}
+		service.state.ClientState[req.Key] = req.Value
+		service.state.ClientStateTimeStamp = time.Now().UTC()
+		err = service.saveState()
+		service.lock.Unlock()
+
+		if err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. Failed to save client state %v.", err)
+			returnCode = UnexpectedError
+		}
+
+	default:
+		returnMessage = "[Azure CNS] Error. SetClientState did not receive a POST."
+		returnCode = InvalidParameter
+	}
+
+	resp := cns.Response{
+		ReturnCode: returnCode,
+		Message:    returnMessage,
+	}
+
+	err = service.Listener.Encode(w, &resp)
+	log.Response(service.Name, resp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+}
+
 // Retrieves the host local ip address. Containers can talk to host using this IP address.
 func (service *HTTPRestService) getHostLocalIP(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[Azure CNS] getHostLocalIP")
@@ -756,10 +1156,20 @@ func (service *HTTPRestService) getHealthReport(w http.ResponseWriter, r *http.R
 	default:
 	}
 
-	resp := &cns.Response{ReturnCode: 0}
+	resp := &cns.HealthReportResponse{}
+
+	// Report the expiry of the served certificate so its rollover can be monitored.
+	if service.Certificate != nil {
+		notAfter := service.Certificate.NotAfter()
+		resp.Certificate = &cns.CertificateStatus{
+			NotAfter:         notAfter,
+			ExpiresInSeconds: int64(time.Until(notAfter).Seconds()),
+		}
+	}
+
 	err := service.Listener.Encode(w, &resp)
 
-	log.Response(service.Name, resp, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
+	log.Response(service.Name, resp, resp.Response.ReturnCode, ReturnCodeToString(resp.Response.ReturnCode), err)
 }
 
 // saveState writes CNS state to persistent store.
@@ -772,7 +1182,10 @@ func (service *HTTPRestService) saveState() error {
 		return nil
 	}
 
+	service.compactStateAboveThreshold()
+
 	// Update time stamp.
+	service.state.SchemaVersion = schemaVersion
 	service.state.TimeStamp = time.Now()
 	err := service.store.Write(storeKey, &service.state)
 	if err == nil {
@@ -794,6 +1207,15 @@ func (service *HTTPRestService) restoreState() error {
 		return nil
 	}
 
+	// Check for a reboot before migrations rewrite the state.
+	service.rebooted = service.detectReboot()
+
+	// Upgrade state persisted by older versions.
+	if err := schema.Migrate(service.store); err != nil {
+		log.Errorf("[Azure CNS]  Failed to migrate state, err:%v\n", err)
+		return err
+	}
+
 	// Read any persisted state.
 	err := service.store.Read(storeKey, &service.state)
 	if err != nil {
@@ -870,7 +1292,8 @@ func (service *HTTPRestService) saveNetworkContainerGoalState(req cns.CreateNetw
 			HostVersion:                   hostVersion}
 
 	if req.NetworkContainerType == cns.AzureContainerInstance ||
-		req.NetworkContainerType == cns.ClearContainer {
+		req.NetworkContainerType == cns.ClearContainer ||
+		req.NetworkContainerType == cns.AttachedNIC {
 		switch service.state.OrchestratorType {
 		case cns.Kubernetes, cns.ServiceFabric:
 			var podInfo cns.KubernetesPodInfo
@@ -894,10 +1317,62 @@ func (service *HTTPRestService) saveNetworkContainerGoalState(req cns.CreateNetw
 		}
 	}
 
+	if req.NetworkContainerType == cns.DelegatedNIC && !ok {
+		service.advertiseDelegatedNICs()
+	}
+
+	service.advertiseNetworkContainerPrefixes()
+
 	service.saveState()
 	return 0, ""
 }
 
+// createOrUpdateNetworkContainerFromRequest creates or updates a network container and saves its goal state,
+// publishing an event if it fails.
+func (service *HTTPRestService) createOrUpdateNetworkContainerFromRequest(req cns.CreateNetworkContainerRequest) (int, string) {
+	returnCode, returnMessage := service.programNetworkContainer(req)
+	if returnCode != 0 {
+		service.publishNetworkContainerFailure(&req, eventReasonNetworkContainerFailed, returnCode, returnMessage)
+	}
+
+	return returnCode, returnMessage
+}
+
+// programNetworkContainer creates or updates a network container and saves its goal state.
+func (service *HTTPRestService) programNetworkContainer(req cns.CreateNetworkContainerRequest) (int, string) {
+	// The CNI finds the host NIC of the network container by its MAC address.
+	if req.NetworkContainerType == cns.AttachedNIC {
+		if _, err := net.ParseMAC(req.MACAddress); err != nil {
+			return InvalidParameter, fmt.Sprintf("[Azure CNS] Error. Invalid MAC address %q of network container %v", req.MACAddress, req.NetworkContainerid)
+		}
+	}
+
+	if req.NetworkContainerType == cns.WebApps {
+		// try to get the saved nc state if it exists
+		service.lock.Lock()
+		existing, ok := service.state.ContainerStatus[req.NetworkContainerid]
+		service.lock.Unlock()
+
+		// create/update nc only if it doesn't exist or it exists and the requested version is different from the saved version
+		if !ok || (ok && existing.VMVersion != req.Version) {
+			nc := service.networkContainer
+			if err := nc.Create(req); err != nil {
+				return UnexpectedError, fmt.Sprintf("[Azure CNS] Error. CreateOrUpdateNetworkContainer failed %v", err.Error())
+			}
+		}
+	}
+
+	if returnCode, returnMessage := service.saveNetworkContainerGoalState(req); returnCode != 0 {
+		return returnCode, returnMessage
+	}
+
+	if err := service.addNetworkContainerHostRoute(&req); err != nil {
+		return UnexpectedError, fmt.Sprintf("[Azure CNS] Error. Failed to add host route of network container %v", err)
+	}
+
+	return 0, ""
+}
+
 func (service *HTTPRestService) createOrUpdateNetworkContainer(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[Azure CNS] createOrUpdateNetworkContainer")
 
@@ -918,24 +1393,7 @@ func (service *HTTPRestService) createOrUpdateNetworkContainer(w http.ResponseWr
 
 	switch r.Method {
 	case "POST":
-		if req.NetworkContainerType == cns.WebApps {
-			// try to get the saved nc state if it exists
-			service.lock.Lock()
-			existing, ok := service.state.ContainerStatus[req.NetworkContainerid]
-			service.lock.Unlock()
-
-			// create/update nc only if it doesn't exist or it exists and the requested version is different from the saved version
-			if !ok || (ok && existing.VMVersion != req.Version) {
-				nc := service.networkContainer
-				if err = nc.Create(req); err != nil {
-					returnMessage = fmt.Sprintf("[Azure CNS] Error. CreateOrUpdateNetworkContainer failed %v", err.Error())
-					returnCode = UnexpectedError
-					break
-				}
-			}
-		}
-
-		returnCode, returnMessage = service.saveNetworkContainerGoalState(req)
+		returnCode, returnMessage = service.createOrUpdateNetworkContainerFromRequest(req)
 
 	default:
 		returnMessage = "[Azure CNS] Error. CreateOrUpdateNetworkContainer did not receive a POST."
@@ -994,6 +1452,9 @@ func (service *HTTPRestService) getNetworkContainerResponse(req cns.GetNetworkCo
 
 		log.Printf("pod info %+v", podInfo)
 		containerID = service.state.ContainerIDByOrchestratorContext[podInfo.PodName+podInfo.PodNamespace]
+		if containerID == "" {
+			containerID = service.reserveDelegatedNIC(podInfo.PodName + podInfo.PodNamespace)
+		}
 		log.Printf("containerid %v", containerID)
 		break
 
@@ -1019,6 +1480,8 @@ func (service *HTTPRestService) getNetworkContainerResponse(req cns.GetNetworkCo
 		MultiTenancyInfo:           savedReq.MultiTenancyInfo,
 		PrimaryInterfaceIdentifier: savedReq.PrimaryInterfaceIdentifier,
 		LocalIPConfiguration:       savedReq.LocalIPConfiguration,
+		NetworkContainerType:       savedReq.NetworkContainerType,
+		MACAddress:                 savedReq.MACAddress,
 	}
 
 	return getNetworkContainerResponse
@@ -1041,6 +1504,54 @@ func (service *HTTPRestService) getNetworkContainerByOrchestratorContext(w http.
 	log.Response(service.Name, getNetworkContainerResponse, returnCode, ReturnCodeToString(returnCode), err)
 }
 
+// deleteNetworkContainerByID deletes a network container and removes it from the goal state.
+func (service *HTTPRestService) deleteNetworkContainerByID(networkContainerID string) (int, string) {
+	service.lock.Lock()
+	containerStatus, ok := service.state.ContainerStatus[networkContainerID]
+	service.lock.Unlock()
+
+	if !ok {
+		log.Printf("Not able to retrieve network container details for this container id %v", networkContainerID)
+		return 0, ""
+	}
+
+	if containerStatus.CreateNetworkContainerRequest.NetworkContainerType == cns.WebApps {
+		nc := service.networkContainer
+		if err := nc.Delete(networkContainerID); err != nil {
+			return UnexpectedError, fmt.Sprintf("[Azure CNS] Error. DeleteNetworkContainer failed %v", err.Error())
+		}
+	}
+
+	if err := service.deleteNetworkContainerHostRoute(&containerStatus.CreateNetworkContainerRequest); err != nil {
+		return UnexpectedError, fmt.Sprintf("[Azure CNS] Error. Failed to delete host route of network container %v", err)
+	}
+
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	if service.state.ContainerStatus != nil {
+		delete(service.state.ContainerStatus, networkContainerID)
+	}
+
+	if service.state.ContainerIDByOrchestratorContext != nil {
+		for orchestratorContext, id := range service.state.ContainerIDByOrchestratorContext {
+			if id == networkContainerID {
+				delete(service.state.ContainerIDByOrchestratorContext, orchestratorContext)
+				break
+			}
+		}
+	}
+
+	if containerStatus.CreateNetworkContainerRequest.NetworkContainerType == cns.DelegatedNIC {
+		service.advertiseDelegatedNICs()
+	}
+
+	service.advertiseNetworkContainerPrefixes()
+
+	service.saveState()
+	return 0, ""
+}
+
 func (service *HTTPRestService) deleteNetworkContainer(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[Azure CNS] deleteNetworkContainer")
 
@@ -1061,45 +1572,7 @@ func (service *HTTPRestService) deleteNetworkContainer(w http.ResponseWriter, r
 
 	switch r.Method {
 	case "POST":
-		var containerStatus containerstatus
-		var ok bool
-
-		service.lock.Lock()
-		containerStatus, ok = service.state.ContainerStatus[req.NetworkContainerid]
-		service.lock.Unlock()
-
-		if !ok {
-			log.Printf("Not able to retrieve network container details for this container id %v", req.NetworkContainerid)
-			break
-		}
-
-		if containerStatus.CreateNetworkContainerRequest.NetworkContainerType == cns.WebApps {
-			nc := service.networkContainer
-			if err := nc.Delete(req.NetworkContainerid); err != nil {
-				returnMessage = fmt.Sprintf("[Azure CNS] Error. DeleteNetworkContainer failed %v", err.Error())
-				returnCode = UnexpectedError
-				break
-			}
-		}
-
-		service.lock.Lock()
-		defer service.lock.Unlock()
-
-		if service.state.ContainerStatus != nil {
-			delete(service.state.ContainerStatus, req.NetworkContainerid)
-		}
-
-		if service.state.ContainerIDByOrchestratorContext != nil {
-			for orchestratorContext, networkContainerID := range service.state.ContainerIDByOrchestratorContext {
-				if networkContainerID == req.NetworkContainerid {
-					delete(service.state.ContainerIDByOrchestratorContext, orchestratorContext)
-					break
-				}
-			}
-		}
-
-		service.saveState()
-		break
+		returnCode, returnMessage = service.deleteNetworkContainerByID(req.NetworkContainerid)
 	default:
 		returnMessage = "[Azure CNS] Error. DeleteNetworkContainer did not receive a POST."
 		returnCode = InvalidParameter
@@ -1153,6 +1626,7 @@ func (service *HTTPRestService) getNetworkContainerStatus(w http.ResponseWriter,
 		if err != nil {
 			returnCode = CallToHostFailed
 			returnMessage = err.Error()
+			service.publishNetworkContainerFailure(&savedReq, eventReasonNetworkContainerHostQueryFailed, returnCode, returnMessage)
 		} else {
 			hostVersion = containerVersion.ProgrammedVersion
 		}
@@ -1231,16 +1705,8 @@ func (service *HTTPRestService) getInterfaceForContainer(w http.ResponseWriter,
 	log.Response(service.Name, getInterfaceForContainerResponse, resp.ReturnCode, ReturnCodeToString(resp.ReturnCode), err)
 }
 
-// restoreNetworkState restores Network state that existed before reboot.
-func (service *HTTPRestService) restoreNetworkState() error {
-	log.Printf("[Azure CNS] Enter Restoring Network State")
-
-	if service.store == nil {
-		log.Printf("[Azure CNS] Store is not initialized, nothing to restore for network state.")
-		return nil
-	}
-
-	rebooted := false
+// detectReboot checks if the node rebooted since CNS state was last saved.
+func (service *HTTPRestService) detectReboot() bool {
 	modTime, err := service.store.GetModificationTime()
 
 	if err == nil {
@@ -1249,11 +1715,23 @@ func (service *HTTPRestService) restoreNetworkState() error {
 		rebootTime, err := platform.GetLastRebootTime()
 		if err == nil && rebootTime.After(modTime) {
 			log.Printf("[Azure CNS] reboot time %v mod time %v", rebootTime, modTime)
-			rebooted = true
+			return true
 		}
 	}
 
-	if rebooted {
+	return false
+}
+
+// restoreNetworkState restores Network state that existed before reboot.
+func (service *HTTPRestService) restoreNetworkState() error {
+	log.Printf("[Azure CNS] Enter Restoring Network State")
+
+	if service.store == nil {
+		log.Printf("[Azure CNS] Store is not initialized, nothing to restore for network state.")
+		return nil
+	}
+
+	if service.rebooted {
 		for _, nwInfo := range service.state.Networks {
 			enableSnat := true
 
@@ -16,7 +16,6 @@ import (
 
 	"github.com/Azure/azure-container-networking/cns"
 	"github.com/Azure/azure-container-networking/cns/common"
-	"github.com/Azure/azure-container-networking/cns/imdsclient"
 	acncommon "github.com/Azure/azure-container-networking/common"
 )
 
@@ -92,12 +91,7 @@ func TestMain(m *testing.M) {
 	}
 
 	// Configure test mode.
-	service.(*httpRestService).Name = "cns-test-server"
-	service.(*httpRestService).imdsClient.HostQueryURL = imdsclient.HostQueryURL
-	service.(*httpRestService).imdsClient.HostQueryURLForProgrammedVersion = imdsclient.HostQueryURLForProgrammedVersion
-	// Following HostQueryURL and HostQueryURLForProgrammedVersion are only for mock environment.
-	// service.(*httpRestService).imdsClient.HostQueryURL = "http://localhost:9000/getInterface"
-	// service.(*httpRestService).imdsClient.HostQueryURLForProgrammedVersion = "http://localhost:9000/machine/plugins/?comp=nmagent&type=NetworkManagement/interfaces/%s/networkContainers/%s/authenticationToken/%s/api-version/%s"
+	service.(*HTTPRestService).Name = "cns-test-server"
 
 	// Start the service.
 	err = service.Start(&config)
@@ -107,7 +101,7 @@ func TestMain(m *testing.M) {
 	}
 
 	// Get the internal http mux as test hook.
-	mux = service.(*httpRestService).Listener.GetMux()
+	mux = service.(*HTTPRestService).Listener.GetMux()
 
 	// Setup mock nmagent server
 	u, err := url.Parse("tcp://localhost:9000")
@@ -151,6 +145,34 @@ func decodeResponse(w *httptest.ResponseRecorder, response interface{}) error {
 	return json.NewDecoder(w.Body).Decode(&response)
 }
 
+// Posts a request to the service and decodes its response.
+func postRequest(t *testing.T, path string, request interface{}, response interface{}) {
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(request)
+
+	req, err := http.NewRequest(http.MethodPost, path, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if err = decodeResponse(w, response); err != nil {
+		t.Fatalf("Request to %s failed, err:%v", path, err)
+	}
+}
+
+// Creates a service that isn't started, to test its internals without sharing the state of the test server.
+func newTestService(t *testing.T) *HTTPRestService {
+	svc, err := NewHTTPRestService(&common.ServiceConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create CNS object %v", err)
+	}
+
+	return svc.(*HTTPRestService)
+}
+
 func setEnv(t *testing.T) *httptest.ResponseRecorder {
 	envRequest := cns.SetEnvironmentRequest{Location: "Azure", NetworkType: "Underlay"}
 	envRequestJSON := new(bytes.Buffer)
@@ -689,3 +711,58 @@ func TestGetInterfaceForNetworkContainer(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestClientState(t *testing.T) {
+	fmt.Println("Test: TestClientState")
+
+	svc := service.(*HTTPRestService)
+	defer func() {
+		svc.lock.Lock()
+		svc.state.ClientState = nil
+		svc.lock.Unlock()
+	}()
+
+	getClientState := func(key string) cns.GetClientStateResponse {
+		var resp cns.GetClientStateResponse
+		postRequest(t, cns.GetClientStatePath, &cns.GetClientStateRequest{Key: key}, &resp)
+		return resp
+	}
+
+	setClientState := func(key string, value string) cns.Response {
+		var resp cns.Response
+		postRequest(t, cns.SetClientStatePath, &cns.SetClientStateRequest{Key: key, Value: json.RawMessage(value)}, &resp)
+		return resp
+	}
+
+	if resp := getClientState("cni"); resp.Response.ReturnCode != NotFound {
+		t.Errorf("GetClientState of a missing key returned %+v, expected NotFound", resp)
+	}
+
+	if resp := setClientState("cni", `{"Networks":["azure"]}`); resp.ReturnCode != 0 {
+		t.Fatalf("SetClientState failed with response %+v", resp)
+	}
+
+	resp := getClientState("cni")
+	if resp.Response.ReturnCode != 0 || string(resp.Value) != `{"Networks":["azure"]}` || resp.TimeStamp.IsZero() {
+		t.Errorf("GetClientState returned %+v, expected the value set", resp)
+	}
+
+	// An empty key returns only the time client state was last set.
+	if timeResp := getClientState(""); timeResp.Response.ReturnCode != 0 || !isClientStateTombstone(timeResp.Value) ||
+		!timeResp.TimeStamp.Equal(resp.TimeStamp) {
+		t.Errorf("GetClientState without a key returned %+v, expected time stamp %v", timeResp, resp.TimeStamp)
+	}
+
+	if resp := setClientState("", `{}`); resp.ReturnCode != InvalidParameter {
+		t.Errorf("SetClientState without a key returned %+v, expected InvalidParameter", resp)
+	}
+
+	// Cleared client state is not found.
+	if resp := setClientState("cni", `null`); resp.ReturnCode != 0 {
+		t.Fatalf("SetClientState to clear the key failed with response %+v", resp)
+	}
+
+	if resp := getClientState("cni"); resp.Response.ReturnCode != NotFound {
+		t.Errorf("GetClientState of a cleared key returned %+v, expected NotFound", resp)
+	}
+}
@@ -4,6 +4,10 @@
 package routes
 
 import (
+	"fmt"
+	"net"
+	"strings"
+
 	"github.com/Azure/azure-container-networking/log"
 )
 
@@ -40,3 +44,64 @@ func (rt *RoutingTable) RestoreRoutingTable() error {
 
 	return putRoutes(rt.Routes)
 }
+
+// HostRoute routes a prefix through the host interface holding an address, via a gateway if any.
+type HostRoute struct {
+	Destination      net.IPNet
+	Gateway          net.IP
+	InterfaceAddress string
+}
+
+// AddHostRoute adds a route to the routing table of the host. Routes that exist are left as is.
+func AddHostRoute(route *HostRoute) error {
+	ifaceIndex, err := getInterfaceByAddress(route.InterfaceAddress)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[Azure CNS] Adding host route %+v through interface %d.", route, ifaceIndex)
+	return addHostRoute(route, ifaceIndex)
+}
+
+// DeleteHostRoute deletes a route from the routing table of the host. Routes that don't exist
+// are ignored.
+func DeleteHostRoute(route *HostRoute) error {
+	ifaceIndex, err := getInterfaceByAddress(route.InterfaceAddress)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[Azure CNS] Deleting host route %+v through interface %d.", route, ifaceIndex)
+	return deleteHostRoute(route, ifaceIndex)
+}
+
+func getInterfaceByAddress(address string) (int, error) {
+	log.Printf("[Azure CNS] getInterfaceByAddress")
+
+	var ifaces []net.Interface
+	log.Printf("[Azure CNS] Going to obtain interface for address %s", address)
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return -1, err
+	}
+
+	for i := 0; i < len(ifaces); i++ {
+		log.Debugf("[Azure CNS] Going to check interface %v", ifaces[i].Name)
+		addrs, _ := ifaces[i].Addrs()
+		for _, addr := range addrs {
+			log.Debugf("[Azure CNS] ipAddress being compared input=%v %v\n",
+				address, addr.String())
+			ip := strings.Split(addr.String(), "/")
+			if len(ip) != 2 {
+				return -1, fmt.Errorf("Malformed ip: %v", addr.String())
+			}
+			if ip[0] == address {
+				return ifaces[i].Index, nil
+			}
+		}
+	}
+
+	return -1, fmt.Errorf(
+		"[Azure CNS] Unable to determine interface index for address %s",
+		address)
+}
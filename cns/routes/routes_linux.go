@@ -5,7 +5,11 @@
 
 package routes
 
-const ()
+import (
+	"syscall"
+
+	"github.com/Azure/azure-container-networking/netlink"
+)
 
 func getRoutes() ([]Route, error) {
 	return nil, nil
@@ -14,3 +18,31 @@ func getRoutes() ([]Route, error) {
 func putRoutes(routes []Route) error {
 	return nil
 }
+
+// getNetlinkRoute returns the netlink route of a host route.
+func getNetlinkRoute(route *HostRoute, ifaceIndex int) *netlink.Route {
+	return &netlink.Route{
+		Family:    netlink.GetIpAddressFamily(route.Destination.IP),
+		Dst:       &route.Destination,
+		Gw:        route.Gateway,
+		LinkIndex: ifaceIndex,
+	}
+}
+
+func addHostRoute(route *HostRoute, ifaceIndex int) error {
+	err := netlink.AddIpRoute(getNetlinkRoute(route, ifaceIndex))
+	if err == syscall.EEXIST {
+		return nil
+	}
+
+	return err
+}
+
+func deleteHostRoute(route *HostRoute, ifaceIndex int) error {
+	err := netlink.DeleteIpRoute(getNetlinkRoute(route, ifaceIndex))
+	if err == syscall.ESRCH {
+		return nil
+	}
+
+	return err
+}
@@ -19,37 +19,6 @@ const (
 	activeRoutesStart     = "Active Routes:"
 )
 
-func getInterfaceByAddress(address string) (int, error) {
-	log.Printf("[Azure CNS] getInterfaceByAddress")
-
-	var ifaces []net.Interface
-	log.Printf("[Azure CNS] Going to obtain interface for address %s", address)
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		return -1, err
-	}
-
-	for i := 0; i < len(ifaces); i++ {
-		log.Debugf("[Azure CNS] Going to check interface %v", ifaces[i].Name)
-		addrs, _ := ifaces[i].Addrs()
-		for _, addr := range addrs {
-			log.Debugf("[Azure CNS] ipAddress being compared input=%v %v\n",
-				address, addr.String())
-			ip := strings.Split(addr.String(), "/")
-			if len(ip) != 2 {
-				return -1, fmt.Errorf("Malformed ip: %v", addr.String())
-			}
-			if ip[0] == address {
-				return ifaces[i].Index, nil
-			}
-		}
-	}
-
-	return -1, fmt.Errorf(
-		"[Azure CNS] Unable to determine interface index for address %s",
-		address)
-}
-
 func getRoutes() ([]Route, error) {
 	log.Printf("[Azure CNS] getRoutes")
 
@@ -177,3 +146,61 @@ func putRoutes(routes []Route) error {
 
 	return err
 }
+
+// getRoute returns the route of a host route in the format of the routing table.
+func getRoute(route *HostRoute, ifaceIndex int) Route {
+	gateway := "0.0.0.0"
+	if route.Gateway != nil {
+		gateway = route.Gateway.String()
+	}
+
+	return Route{
+		destination: route.Destination.IP.String(),
+		mask:        net.IP(route.Destination.Mask).String(),
+		gateway:     gateway,
+		ifaceIndex:  ifaceIndex,
+	}
+}
+
+func addHostRoute(route *HostRoute, ifaceIndex int) error {
+	rt := getRoute(route, ifaceIndex)
+
+	currentRoutes, err := getRoutes()
+	if err != nil {
+		return err
+	}
+
+	if exists, _ := containsRoute(currentRoutes, rt); exists {
+		return nil
+	}
+
+	args := []string{"/C", "route", "ADD", rt.destination, "MASK", rt.mask, rt.gateway,
+		"IF", fmt.Sprintf("%d", rt.ifaceIndex)}
+
+	if bytes, err := exec.Command("cmd", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to add route %v: %v %s", args, err, strings.TrimSpace(string(bytes)))
+	}
+
+	return nil
+}
+
+func deleteHostRoute(route *HostRoute, ifaceIndex int) error {
+	rt := getRoute(route, ifaceIndex)
+
+	currentRoutes, err := getRoutes()
+	if err != nil {
+		return err
+	}
+
+	if exists, _ := containsRoute(currentRoutes, rt); !exists {
+		return nil
+	}
+
+	args := []string{"/C", "route", "DELETE", rt.destination, "MASK", rt.mask, rt.gateway}
+
+	if bytes, err := exec.Command("cmd", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to delete route %v: %v %s", args, err, strings.TrimSpace(string(bytes)))
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@
 package cns
 
 import (
+	"crypto/tls"
 	"net/http"
 	"net/url"
 
@@ -24,6 +25,7 @@ type Service struct {
 	*common.Service
 	EndpointType string
 	Listener     *acn.Listener
+	Certificate  *acn.CertificateReloader
 }
 
 // NewService creates a new Service object.
@@ -70,6 +72,19 @@ func (service *Service) Initialize(config *common.ServiceConfig) error {
 			return err
 		}
 
+		// Serve TLS if a certificate is set, rotating it as its files change.
+		certFile, _ := service.GetOption(acn.OptTLSCertFile).(string)
+		keyFile, _ := service.GetOption(acn.OptTLSKeyFile).(string)
+		if certFile != "" && keyFile != "" {
+			service.Certificate, err = acn.NewCertificateReloader(certFile, keyFile)
+			if err != nil {
+				return err
+			}
+
+			service.Certificate.Start(acn.DefaultCertificateReloadInterval)
+			listener.SetTLSConfig(&tls.Config{GetCertificate: service.Certificate.GetCertificate})
+		}
+
 		// Start the listener.
 		err = listener.Start(config.ErrChan)
 		if err != nil {
@@ -87,6 +102,9 @@ func (service *Service) Initialize(config *common.ServiceConfig) error {
 
 // Uninitialize cleans up the plugin.
 func (service *Service) Uninitialize() {
+	if service.Certificate != nil {
+		service.Certificate.Stop()
+	}
 	service.Listener.Stop()
 	service.Service.Uninitialize()
 }
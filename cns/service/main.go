@@ -8,23 +8,30 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/Azure/azure-container-networking/telemetry"
 
 	"github.com/Azure/azure-container-networking/cnm/ipam"
 	"github.com/Azure/azure-container-networking/cnm/network"
+	"github.com/Azure/azure-container-networking/cns"
 	"github.com/Azure/azure-container-networking/cns/common"
 	"github.com/Azure/azure-container-networking/cns/restserver"
 	acn "github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/diagnostics"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/platform"
 	"github.com/Azure/azure-container-networking/store"
+	"github.com/Azure/azure-container-networking/trace"
 )
 
 const (
 	// Service name.
-	name       = "azure-cns"
+	name       = cns.ServiceName
 	pluginName = "azure-vnet"
+
+	// Interval between exports of trace spans.
+	traceExportInterval = 10 * time.Second
 )
 
 // Version is populated by make during build.
@@ -80,6 +87,45 @@ var args = acn.ArgumentList{
 			acn.OptLogMultiWrite:   log.TargetStdOutAndLogFile,
 		},
 	},
+	{
+		Name:         acn.OptLogFormat,
+		Shorthand:    acn.OptLogFormatAlias,
+		Description:  "Set the logging format",
+		Type:         "int",
+		DefaultValue: acn.OptLogFormatText,
+		ValueMap: map[string]interface{}{
+			acn.OptLogFormatText: log.FormatText,
+			acn.OptLogFormatJSON: log.FormatJSON,
+		},
+	},
+	{
+		Name:         acn.OptLogMaxSize,
+		Shorthand:    acn.OptLogMaxSizeAlias,
+		Description:  "Set the size in MB at which log files are rotated",
+		Type:         "int",
+		DefaultValue: "5",
+	},
+	{
+		Name:         acn.OptLogMaxFiles,
+		Shorthand:    acn.OptLogMaxFilesAlias,
+		Description:  "Set the number of log files retained, including the active one",
+		Type:         "int",
+		DefaultValue: "8",
+	},
+	{
+		Name:         acn.OptLogMaxAge,
+		Shorthand:    acn.OptLogMaxAgeAlias,
+		Description:  "Set the age in days after which rotated log files are removed, unlimited if 0",
+		Type:         "int",
+		DefaultValue: "7",
+	},
+	{
+		Name:         acn.OptLogCompress,
+		Shorthand:    acn.OptLogCompressAlias,
+		Description:  "Compress rotated log files",
+		Type:         "bool",
+		DefaultValue: true,
+	},
 	{
 		Name:         acn.OptLogLocation,
 		Shorthand:    acn.OptLogLocationAlias,
@@ -129,6 +175,162 @@ var args = acn.ArgumentList{
 		Type:         "int",
 		DefaultValue: "60000",
 	},
+	{
+		Name:         acn.OptAIKey,
+		Shorthand:    acn.OptAIKeyAlias,
+		Description:  "Set the Application Insights instrumentation key",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptAIEndpoint,
+		Shorthand:    acn.OptAIEndpointAlias,
+		Description:  "Set the Application Insights ingestion endpoint",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptAIProxy,
+		Shorthand:    acn.OptAIProxyAlias,
+		Description:  "Set the proxy used to send telemetry to Application Insights",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptAISampling,
+		Shorthand:    acn.OptAISamplingAlias,
+		Description:  "Set the sampling rates of telemetry sent to Application Insights, as type=rate pairs, e.g. CNIReport=0.01,error=1",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptTelemetryConfig,
+		Shorthand:    acn.OptTelemetryConfigAlias,
+		Description:  "Set the path of the config file of the telemetry exporters, e.g. to keep reports on the node",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptHTTPProxy,
+		Shorthand:    acn.OptHTTPProxyAlias,
+		Description:  "Set the proxy of outbound HTTP calls, overriding HTTPS_PROXY and HTTP_PROXY",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptDiagnosticsAddress,
+		Shorthand:    acn.OptDiagnosticsAddressAlias,
+		Description:  "Set the loopback address serving pprof and runtime diagnostics, disabled if empty",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptStoreType,
+		Shorthand:    acn.OptStoreTypeAlias,
+		Description:  "Set the backend of the persisted state",
+		Type:         "string",
+		DefaultValue: acn.OptStoreTypeJSON,
+		ValueMap: map[string]interface{}{
+			acn.OptStoreTypeJSON: 0,
+			acn.OptStoreTypeBolt: 0,
+		},
+	},
+	{
+		Name:         acn.OptStoreCompactionInterval,
+		Shorthand:    acn.OptStoreCompactionIntervalAlias,
+		Description:  "Set the interval in minutes between compactions of the persisted state",
+		Type:         "int",
+		DefaultValue: "60",
+	},
+	{
+		Name:         acn.OptStoreCompactionThreshold,
+		Shorthand:    acn.OptStoreCompactionThresholdAlias,
+		Description:  "Set the size in bytes above which the persisted state is compacted on save",
+		Type:         "int",
+		DefaultValue: "1048576",
+	},
+	{
+		Name:         acn.OptIPAMMode,
+		Shorthand:    acn.OptIPAMModeAlias,
+		Description:  "Set the source of the addresses served to the CNI",
+		Type:         "string",
+		DefaultValue: acn.OptIPAMModePlugin,
+		ValueMap: map[string]interface{}{
+			acn.OptIPAMModePlugin:     0,
+			acn.OptIPAMModeNodeSubnet: 0,
+			acn.OptIPAMModePodCIDR:    0,
+		},
+	},
+	{
+		Name:         acn.OptPodCIDR,
+		Shorthand:    acn.OptPodCIDRAlias,
+		Description:  "Set the pod CIDR of the node served in the pod-cidr IPAM mode",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptTLSCertFile,
+		Shorthand:    acn.OptTLSCertFileAlias,
+		Description:  "Set the TLS certificate file to serve, reloaded when it changes",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptTLSKeyFile,
+		Shorthand:    acn.OptTLSKeyFileAlias,
+		Description:  "Set the key file of the TLS certificate",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptGoalStateURL,
+		Shorthand:    acn.OptGoalStateURLAlias,
+		Description:  "Set the base URL of the DNC endpoint streaming the goal state of network containers",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptDNCAuthResource,
+		Shorthand:    acn.OptDNCAuthResourceAlias,
+		Description:  "Set the Azure AD resource of the managed identity tokens authenticating calls to DNC",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptManagedIdentityClientID,
+		Shorthand:    acn.OptManagedIdentityClientIDAlias,
+		Description:  "Set the client ID of the user-assigned managed identity to authenticate with",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptProgramHostRoutes,
+		Shorthand:    acn.OptProgramHostRoutesAlias,
+		Description:  "Program host routes to the prefixes of network containers",
+		Type:         "bool",
+		DefaultValue: false,
+	},
+	{
+		Name:         acn.OptNCGCGracePeriod,
+		Shorthand:    acn.OptNCGCGracePeriodAlias,
+		Description:  "Set the minutes network containers of deleted pods are kept before they are reclaimed, 0 to keep them",
+		Type:         "int",
+		DefaultValue: "0",
+	},
+	{
+		Name:         acn.OptBGPPeers,
+		Shorthand:    acn.OptBGPPeersAlias,
+		Description:  "Set the BGP peers to advertise the prefixes of network containers to, as address:asn pairs separated by commas",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptBGPASN,
+		Shorthand:    acn.OptBGPASNAlias,
+		Description:  "Set the AS number the node advertises prefixes from to BGP peers",
+		Type:         "int",
+		DefaultValue: "0",
+	},
 }
 
 // Prints description and version information.
@@ -137,6 +339,15 @@ func printVersion() {
 	fmt.Printf("Version %v\n", version)
 }
 
+// newStore creates the key value store of the given backend at path, without file extension.
+func newStore(storeType string, path string) (store.KeyValueStore, error) {
+	if storeType == acn.OptStoreTypeBolt {
+		return store.NewBoltStore(path + ".db")
+	}
+
+	return store.NewJsonFileStore(path + ".json")
+}
+
 // Main is the entry point for CNS.
 func main() {
 	var stopcnm = false
@@ -148,12 +359,60 @@ func main() {
 	cnsURL := acn.GetArg(acn.OptCnsURL).(string)
 	logLevel := acn.GetArg(acn.OptLogLevel).(int)
 	logTarget := acn.GetArg(acn.OptLogTarget).(int)
+	logFormat := acn.GetArg(acn.OptLogFormat).(int)
+	logMaxSize := acn.GetArg(acn.OptLogMaxSize).(int)
+	logMaxFiles := acn.GetArg(acn.OptLogMaxFiles).(int)
+	logMaxAge := acn.GetArg(acn.OptLogMaxAge).(int)
+	logCompress := acn.GetArg(acn.OptLogCompress).(bool)
 	logDirectory := acn.GetArg(acn.OptLogLocation).(string)
 	ipamQueryUrl, _ := acn.GetArg(acn.OptIpamQueryUrl).(string)
 	ipamQueryInterval, _ := acn.GetArg(acn.OptIpamQueryInterval).(int)
 	stopcnm = acn.GetArg(acn.OptStopAzureVnet).(bool)
 	vers := acn.GetArg(acn.OptVersion).(bool)
 	reportToHostInterval := acn.GetArg(acn.OptReportToHostInterval).(int)
+	diagnosticsAddress := acn.GetArg(acn.OptDiagnosticsAddress).(string)
+	httpProxy := acn.GetArg(acn.OptHTTPProxy).(string)
+	storeType := acn.GetArg(acn.OptStoreType).(string)
+	storeCompactionInterval := acn.GetArg(acn.OptStoreCompactionInterval).(int)
+	storeCompactionThreshold := acn.GetArg(acn.OptStoreCompactionThreshold).(int)
+	goalStateURL := acn.GetArg(acn.OptGoalStateURL).(string)
+	ipamMode := acn.GetArg(acn.OptIPAMMode).(string)
+	podCIDR := acn.GetArg(acn.OptPodCIDR).(string)
+	tlsCertFile := acn.GetArg(acn.OptTLSCertFile).(string)
+	tlsKeyFile := acn.GetArg(acn.OptTLSKeyFile).(string)
+	dncAuthResource := acn.GetArg(acn.OptDNCAuthResource).(string)
+	managedIdentityClientID := acn.GetArg(acn.OptManagedIdentityClientID).(string)
+	programHostRoutes := acn.GetArg(acn.OptProgramHostRoutes).(bool)
+	ncGCGracePeriod := acn.GetArg(acn.OptNCGCGracePeriod).(int)
+	bgpPeers := acn.GetArg(acn.OptBGPPeers).(string)
+	bgpASN := acn.GetArg(acn.OptBGPASN).(int)
+
+	// Settings of the telemetry config file are overridden by the ones of the command line.
+	if telemetryConfigPath := acn.GetArg(acn.OptTelemetryConfig).(string); telemetryConfigPath != "" {
+		telemetryConfig, err := telemetry.ReadTelemetryConfig(telemetryConfigPath)
+		if err != nil {
+			fmt.Printf("Failed to read telemetry config: %v\n", err)
+			return
+		}
+
+		telemetry.SetAIConfig(telemetryConfig.AppInsights)
+		if err = telemetry.SetExporters(telemetryConfig.Exporters); err != nil {
+			fmt.Printf("Invalid telemetry exporters: %v\n", err)
+			return
+		}
+	}
+
+	aiSamplingRates, samplingErr := telemetry.ParseAISamplingRates(acn.GetArg(acn.OptAISampling).(string))
+	if samplingErr != nil {
+		fmt.Printf("Invalid Application Insights sampling rates: %v\n", samplingErr)
+	}
+
+	telemetry.SetAIConfig(telemetry.AIConfig{
+		InstrumentationKey: acn.GetArg(acn.OptAIKey).(string),
+		IngestionEndpoint:  acn.GetArg(acn.OptAIEndpoint).(string),
+		ProxyURL:           acn.GetArg(acn.OptAIProxy).(string),
+		SamplingRates:      aiSamplingRates,
+	})
 
 	if vers {
 		printVersion()
@@ -172,9 +431,16 @@ func main() {
 	// Create logging provider.
 	log.SetName(name)
 	log.SetLevel(logLevel)
+	if logFormat == log.FormatJSON {
+		log.SetFormat(logFormat)
+	}
 	if logDirectory != "" {
 		log.SetLogDirectory(logDirectory)
 	}
+	if logMaxSize > 0 && logMaxFiles > 0 {
+		log.SetLogFileLimits(logMaxSize*1024*1024, logMaxFiles)
+	}
+	log.SetLogFileRetention(time.Duration(logMaxAge)*24*time.Hour, logCompress)
 
 	err = log.SetTarget(logTarget)
 	if err != nil {
@@ -182,6 +448,16 @@ func main() {
 		return
 	}
 
+	if err = acn.SetProxy(httpProxy); err != nil {
+		log.Errorf("Invalid HTTP proxy %s, err:%v.\n", httpProxy, err)
+	}
+
+	if diagnosticsAddress != "" {
+		if err = diagnostics.StartServer(diagnosticsAddress); err != nil {
+			log.Errorf("Failed to start diagnostics server, err:%v.\n", err)
+		}
+	}
+
 	if logger := log.GetStd(); logger != nil {
 		logger.SetChannel(reports)
 	}
@@ -189,6 +465,8 @@ func main() {
 	// Log platform information.
 	log.Printf("Running on %v", platform.GetOSInfo())
 
+	trace.Initialize(name, traceExportInterval)
+
 	err = acn.CreateDirectory(platform.CNMRuntimePath)
 	if err != nil {
 		log.Errorf("Failed to create File Store directory Error:%v", err.Error())
@@ -196,7 +474,7 @@ func main() {
 	}
 
 	// Create the key value store.
-	config.Store, err = store.NewJsonFileStore(platform.CNMRuntimePath + name + ".json")
+	config.Store, err = newStore(storeType, platform.CNMRuntimePath+name)
 	if err != nil {
 		log.Errorf("Failed to create store: %v\n", err)
 		return
@@ -211,9 +489,23 @@ func main() {
 
 	// Set CNS options.
 	httpRestService.SetOption(acn.OptCnsURL, cnsURL)
+	httpRestService.SetOption(acn.OptIPAMMode, ipamMode)
+	httpRestService.SetOption(acn.OptPodCIDR, podCIDR)
+	httpRestService.SetOption(acn.OptTLSCertFile, tlsCertFile)
+	httpRestService.SetOption(acn.OptTLSKeyFile, tlsKeyFile)
+	httpRestService.SetOption(acn.OptStoreCompactionInterval, storeCompactionInterval)
+	httpRestService.SetOption(acn.OptStoreCompactionThreshold, storeCompactionThreshold)
+	httpRestService.SetOption(acn.OptGoalStateURL, goalStateURL)
+	httpRestService.SetOption(acn.OptDNCAuthResource, dncAuthResource)
+	httpRestService.SetOption(acn.OptManagedIdentityClientID, managedIdentityClientID)
+	httpRestService.SetOption(acn.OptProgramHostRoutes, programHostRoutes)
+	httpRestService.SetOption(acn.OptNCGCGracePeriod, ncGCGracePeriod)
+	httpRestService.SetOption(acn.OptBGPPeers, bgpPeers)
+	httpRestService.SetOption(acn.OptBGPASN, bgpASN)
 
 	// Start CNS.
 	if httpRestService != nil {
+		httpRestService.(*restserver.HTTPRestService).SetReportChannel(reports)
 		go telemetry.SendCnsTelemetry(reportToHostInterval,
 			reports,
 			httpRestService.(*restserver.HTTPRestService),
@@ -225,6 +517,10 @@ func main() {
 		}
 	}
 
+	// Report breakage of the primary interface as it happens.
+	stopMonitor := make(chan struct{})
+	startInterfaceMonitor(stopMonitor)
+
 	var netPlugin network.NetPlugin
 	var ipamPlugin ipam.IpamPlugin
 
@@ -250,7 +546,7 @@ func main() {
 		}
 
 		// Create the key value store.
-		pluginConfig.Store, err = store.NewJsonFileStore(platform.CNMRuntimePath + pluginName + ".json")
+		pluginConfig.Store, err = newStore(storeType, platform.CNMRuntimePath+pluginName)
 		if err != nil {
 			log.Errorf("Failed to create store: %v\n", err)
 			return
@@ -291,6 +587,7 @@ func main() {
 		httpRestService.Stop()
 	}
 
+	close(stopMonitor)
 	telemetryStopProcessing <- true
 
 	if !stopcnm {
@@ -303,5 +600,6 @@ func main() {
 		}
 	}
 
+	trace.Close()
 	log.Close()
 }
@@ -0,0 +1,71 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build linux
+
+package main
+
+import (
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// getPrimaryInterfaceIndex returns the index of the interface of the host's default route.
+func getPrimaryInterfaceIndex() (int, error) {
+	routes, err := netlink.GetIpRoute(&netlink.Route{Family: unix.AF_INET})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, route := range routes {
+		if route.Dst == nil && route.Gw != nil {
+			return route.LinkIndex, nil
+		}
+	}
+
+	return 0, unix.ENOENT
+}
+
+// startInterfaceMonitor reports the primary interface going down, being renamed or deleted,
+// until stopCh is closed. The reports are logged as errors, which are sent as telemetry events.
+func startInterfaceMonitor(stopCh chan struct{}) {
+	index, err := getPrimaryInterfaceIndex()
+	if err != nil {
+		log.Printf("[Azure CNS] Failed to find the primary interface, err:%v.", err)
+		return
+	}
+
+	events := make(chan netlink.LinkEvent, 16)
+	if err := netlink.SubscribeLinkEvents(events, stopCh); err != nil {
+		log.Errorf("[Azure CNS] Failed to monitor the primary interface, err:%v.", err)
+		return
+	}
+
+	log.Printf("[Azure CNS] Monitoring primary interface %v.", index)
+
+	go func() {
+		name := ""
+		isUp := true
+
+		for event := range events {
+			if event.Index != index {
+				continue
+			}
+
+			switch {
+			case event.IsDeleted():
+				log.Errorf("[Azure CNS] Primary interface %v was deleted.", event.Name)
+			case name != "" && event.Name != name:
+				log.Errorf("[Azure CNS] Primary interface %v was renamed to %v.", name, event.Name)
+			case isUp && !event.IsUp():
+				log.Errorf("[Azure CNS] Primary interface %v went down.", event.Name)
+			case !isUp && event.IsUp():
+				log.Printf("[Azure CNS] Primary interface %v came back up.", event.Name)
+			}
+
+			name = event.Name
+			isUp = event.IsUp()
+		}
+	}()
+}
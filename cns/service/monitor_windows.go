@@ -0,0 +1,10 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build windows
+
+package main
+
+// startInterfaceMonitor does nothing on Windows.
+func startInterfaceMonitor(stopCh chan struct{}) {
+}
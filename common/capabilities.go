@@ -0,0 +1,89 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package common
+
+import (
+	"net/http"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// CapabilitiesPath is the path where plugins advertise their capabilities.
+	CapabilitiesPath = "/capabilities"
+)
+
+// Capabilities are the API versions and features a plugin supports. Plugins query the
+// capabilities of their peers before using a feature, so that plugins of different
+// versions keep working together while a node is upgraded.
+type Capabilities struct {
+	Name        string
+	Version     string
+	APIVersions []string `json:",omitempty"`
+	Features    []string `json:",omitempty"`
+}
+
+// CapabilitiesProvider is implemented by plugins and services advertising their capabilities.
+type CapabilitiesProvider interface {
+	GetCapabilities() *Capabilities
+}
+
+// CapabilitiesResponse is the response of a capabilities query, with the capabilities
+// of every plugin sharing the listener.
+type CapabilitiesResponse struct {
+	Plugins []Capabilities
+}
+
+// Supports checks if a feature is supported.
+func (c *Capabilities) Supports(feature string) bool {
+	return contains(c.Features, feature)
+}
+
+// SupportsAPIVersion checks if an API version is supported.
+func (c *Capabilities) SupportsAPIVersion(version string) bool {
+	return contains(c.APIVersions, version)
+}
+
+// Get returns the capabilities of the plugin with the given name, nil if it isn't found.
+func (resp *CapabilitiesResponse) Get(name string) *Capabilities {
+	for i := range resp.Plugins {
+		if resp.Plugins[i].Name == name {
+			return &resp.Plugins[i]
+		}
+	}
+
+	return nil
+}
+
+// AdvertiseCapabilities advertises the capabilities of a plugin on the listener.
+func (listener *Listener) AdvertiseCapabilities(provider CapabilitiesProvider) {
+	if len(listener.capabilities) == 0 {
+		listener.AddHandler(CapabilitiesPath, listener.getCapabilities)
+	}
+
+	listener.capabilities = append(listener.capabilities, provider)
+}
+
+// Handles capabilities queries.
+func (listener *Listener) getCapabilities(w http.ResponseWriter, r *http.Request) {
+	var resp CapabilitiesResponse
+
+	for _, provider := range listener.capabilities {
+		resp.Plugins = append(resp.Plugins, *provider.GetCapabilities())
+	}
+
+	err := listener.Encode(w, &resp)
+	log.Response("Listener", &resp, 0, "Success", err)
+}
+
+// contains checks if a list of strings contains a string.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,155 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// DefaultCertificateReloadInterval is the interval between checks of certificate files for changes.
+	DefaultCertificateReloadInterval = 30 * time.Second
+)
+
+// CertificateReloader serves a TLS certificate loaded from a pair of PEM files, and reloads it
+// when the files change so that it can be rotated without a restart. Files of a mounted Kubernetes
+// secret are swapped atomically when the secret is updated, and are picked up the same way.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	leaf     *x509.Certificate
+	modTime  time.Time
+	stop     chan struct{}
+	sync.RWMutex
+}
+
+// NewCertificateReloader creates a CertificateReloader and loads its certificate.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Start checks the certificate files for changes at the given interval until stopped.
+func (r *CertificateReloader) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultCertificateReloadInterval
+	}
+
+	r.stop = make(chan struct{})
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			// Keep serving the current certificate if the new one can't be loaded,
+			// e.g. while its files are being written.
+			if reloaded, err := r.reload(); err != nil {
+				log.Printf("[tls] Failed to reload certificate %s: %v", r.certFile, err)
+			} else if reloaded {
+				log.Printf("[tls] Reloaded certificate %s, expires %v.", r.certFile, r.NotAfter())
+			}
+		}
+	}(r.stop)
+}
+
+// Stop stops checking the certificate files for changes.
+func (r *CertificateReloader) Stop() {
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
+
+// GetCertificate returns the current certificate. It is meant for tls.Config.GetCertificate.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.cert, nil
+}
+
+// NotAfter returns the expiry time of the current certificate.
+func (r *CertificateReloader) NotAfter() time.Time {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.leaf.NotAfter
+}
+
+// reload loads the certificate if its files changed since it was last loaded.
+func (r *CertificateReloader) reload() (bool, error) {
+	modTime, err := r.getModTime()
+	if err != nil {
+		return false, err
+	}
+
+	r.RLock()
+	changed := r.cert == nil || !modTime.Equal(r.modTime)
+	r.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, fmt.Errorf("Failed to parse certificate: %v", err)
+	}
+
+	cert.Leaf = leaf
+
+	r.Lock()
+	r.cert = &cert
+	r.leaf = leaf
+	r.modTime = modTime
+	r.Unlock()
+
+	return true, nil
+}
+
+// getModTime returns the latest modification time of the certificate files.
+func (r *CertificateReloader) getModTime() (time.Time, error) {
+	var modTime time.Time
+
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTime, err
+		}
+
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	return modTime, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate expiring at the given time and its key.
+func writeTestCertificate(t *testing.T, certFile, keyFile string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+}
+
+// Tests that a certificate is reloaded when its files change, and kept if they are invalid.
+func TestCertificateReloaderReloadsChangedCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acn-cert")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	writeTestCertificate(t, certFile, keyFile, expiry)
+
+	r, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+
+	if !r.NotAfter().Equal(expiry) {
+		t.Errorf("Expected expiry %v, got %v", expiry, r.NotAfter())
+	}
+
+	// Unchanged files aren't reloaded.
+	if reloaded, err := r.reload(); reloaded || err != nil {
+		t.Errorf("Reloaded unchanged certificate, err:%v", err)
+	}
+
+	// Rotated files are.
+	rotated := expiry.Add(24 * time.Hour)
+	writeTestCertificate(t, certFile, keyFile, rotated)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	if reloaded, err := r.reload(); !reloaded || err != nil {
+		t.Fatalf("Failed to reload rotated certificate, err:%v", err)
+	}
+
+	cert, _ := r.GetCertificate(nil)
+	if !cert.Leaf.NotAfter.Equal(rotated) || !r.NotAfter().Equal(rotated) {
+		t.Errorf("Expected rotated expiry %v, got %v", rotated, r.NotAfter())
+	}
+
+	// Invalid files leave the current certificate in place.
+	ioutil.WriteFile(keyFile, []byte("invalid"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+
+	if _, err := r.reload(); err == nil {
+		t.Errorf("Loaded invalid certificate")
+	}
+
+	if !r.NotAfter().Equal(rotated) {
+		t.Errorf("Certificate was replaced by an invalid one")
+	}
+}
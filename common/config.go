@@ -10,6 +10,10 @@ const (
 	OptEnvironmentAlias = "e"
 	OptEnvironmentAzure = "azure"
 	OptEnvironmentMAS   = "mas"
+	OptEnvironmentCNS   = "cns"
+
+	// Static environment, configured by the network configuration instead of the host.
+	OptEnvironmentStatic = "static"
 
 	// API server URL.
 	OptAPIServerURL      = "api-url"
@@ -32,6 +36,22 @@ const (
 	OptLogStdout       = "stdout"
 	OptLogMultiWrite   = "stdoutfile"
 
+	// Logging format.
+	OptLogFormat      = "log-format"
+	OptLogFormatAlias = "lf"
+	OptLogFormatText  = "text"
+	OptLogFormatJSON  = "json"
+
+	// Log file rotation size in MB, number of retained files, maximum age in days, and compression.
+	OptLogMaxSize       = "log-max-size"
+	OptLogMaxSizeAlias  = "lms"
+	OptLogMaxFiles      = "log-max-files"
+	OptLogMaxFilesAlias = "lmf"
+	OptLogMaxAge        = "log-max-age"
+	OptLogMaxAgeAlias   = "lma"
+	OptLogCompress      = "log-compress"
+	OptLogCompressAlias = "lc"
+
 	// Logging location
 	OptLogLocation      = "log-location"
 	OptLogLocationAlias = "o"
@@ -44,6 +64,15 @@ const (
 	OptIpamQueryInterval      = "ipam-query-interval"
 	OptIpamQueryIntervalAlias = "i"
 
+	// IPAM metrics file path.
+	OptIpamMetricsPath = "ipam-metrics-path"
+
+	// IPAM subnet, gateway and address range of the static environment.
+	OptIpamSubnet     = "ipam-subnet"
+	OptIpamGateway    = "ipam-gateway"
+	OptIpamRangeStart = "ipam-range-start"
+	OptIpamRangeEnd   = "ipam-range-end"
+
 	// Don't Start CNM
 	OptStopAzureVnet      = "stop-azure-cnm"
 	OptStopAzureVnetAlias = "stopcnm"
@@ -52,6 +81,144 @@ const (
 	OptReportToHostInterval      = "report-interval"
 	OptReportToHostIntervalAlias = "hostinterval"
 
+	// Application Insights instrumentation key, ingestion endpoint, proxy and sampling rates.
+	OptAIKey           = "ai-key"
+	OptAIKeyAlias      = "aik"
+	OptAIEndpoint      = "ai-endpoint"
+	OptAIEndpointAlias = "aie"
+	OptAIProxy         = "ai-proxy"
+	OptAIProxyAlias    = "aip"
+	OptAISampling      = "ai-sampling"
+	OptAISamplingAlias = "ais"
+
+	// Config file of the exporters of the telemetry buffer.
+	OptTelemetryConfig      = "telemetry-config"
+	OptTelemetryConfigAlias = "tc"
+
+	// Network policy admission webhook mode.
+	OptWebhookMode      = "webhook-mode"
+	OptWebhookModeAlias = "wm"
+	OptWebhookModeOff   = "off"
+	OptWebhookModeWarn  = "warn"
+	OptWebhookModeDeny  = "deny"
+
+	// Network policy mode.
+	OptPolicyMode        = "policy-mode"
+	OptPolicyModeAlias   = "pm"
+	OptPolicyModeEnforce = "enforce"
+	OptPolicyModeAudit   = "audit"
+
+	// Network policy dataplane.
+	OptDataplane         = "dataplane"
+	OptDataplaneAlias    = "dpl"
+	OptDataplaneIptables = "iptables"
+	OptDataplaneEBPF     = "ebpf"
+
+	// Enforce network policies on IPv6 traffic as well.
+	OptDualStack      = "dual-stack"
+	OptDualStackAlias = "ds"
+
+	// Informer resync period of NPM, in minutes.
+	OptInformerResync      = "informer-resync"
+	OptInformerResyncAlias = "irs"
+
+	// Create the ipsets of pod labels and named ports only when a network policy refers to them.
+	OptLazyIpsets      = "lazy-ipsets"
+	OptLazyIpsetsAlias = "lis"
+
+	// Network policy admission webhook address.
+	OptWebhookAddress      = "webhook-address"
+	OptWebhookAddressAlias = "wa"
+
+	// Network policy admission webhook TLS certificate and key files.
+	OptWebhookCertFile      = "webhook-cert"
+	OptWebhookCertFileAlias = "wc"
+	OptWebhookKeyFile       = "webhook-key"
+	OptWebhookKeyFileAlias  = "wk"
+
+	// NPM debug command.
+	OptNpmDebugCommand         = "command"
+	OptNpmDebugCommandAlias    = "c"
+	OptNpmDebugCommandDump     = "dump"
+	OptNpmDebugCommandSimulate = "simulate"
+
+	// Source and destination pods of simulated NPM traffic, as namespace/name.
+	OptNpmDebugSrcPod      = "src-pod"
+	OptNpmDebugSrcPodAlias = "sp"
+	OptNpmDebugDstPod      = "dst-pod"
+	OptNpmDebugDstPodAlias = "dp"
+
+	// Protocol and destination port of simulated NPM traffic.
+	OptNpmDebugProtocol      = "protocol"
+	OptNpmDebugProtocolAlias = "pr"
+	OptNpmDebugPort          = "port"
+	OptNpmDebugPortAlias     = "pt"
+
+	// Proxy of outbound HTTP calls, overriding HTTPS_PROXY and HTTP_PROXY.
+	OptHTTPProxy      = "http-proxy"
+	OptHTTPProxyAlias = "hp"
+
+	// Loopback address of the pprof and runtime diagnostics endpoints, disabled if empty.
+	OptDiagnosticsAddress      = "diagnostics-address"
+	OptDiagnosticsAddressAlias = "da"
+
+	// Backend of the persisted state.
+	OptStoreType      = "store-type"
+	OptStoreTypeAlias = "st"
+	OptStoreTypeJSON  = "json"
+	OptStoreTypeBolt  = "bolt"
+
+	// Interval between compactions of the persisted state, in minutes, and the size in bytes
+	// above which it is compacted on save.
+	OptStoreCompactionInterval       = "store-compaction-interval"
+	OptStoreCompactionIntervalAlias  = "sci"
+	OptStoreCompactionThreshold      = "store-compaction-threshold"
+	OptStoreCompactionThresholdAlias = "sct"
+
+	// Source of the addresses CNS serves to the CNI: the IPAM plugin, the secondary addresses
+	// of the node learned from the host, or the pod CIDR of the node in overlay clusters.
+	OptIPAMMode           = "ipam-mode"
+	OptIPAMModeAlias      = "im"
+	OptIPAMModePlugin     = "plugin"
+	OptIPAMModeNodeSubnet = "node-subnet"
+	OptIPAMModePodCIDR    = "pod-cidr"
+
+	// Pod CIDR of the node, served in the pod-cidr IPAM mode.
+	OptPodCIDR      = "pod-cidr"
+	OptPodCIDRAlias = "pcidr"
+
+	// TLS certificate and key files served by CNS, reloaded when they change.
+	OptTLSCertFile      = "tls-cert-file"
+	OptTLSCertFileAlias = "tcf"
+	OptTLSKeyFile       = "tls-key-file"
+	OptTLSKeyFileAlias  = "tkf"
+
+	// Base URL of the DNC endpoint streaming the goal state of network containers, disabled if empty.
+	OptGoalStateURL      = "goal-state-url"
+	OptGoalStateURLAlias = "gsu"
+
+	// Azure AD resource of the tokens authenticating CNS calls to DNC, acquired from the managed
+	// identity of the VM, and the client ID of a user-assigned identity. Calls are unauthenticated
+	// if the resource is empty.
+	OptDNCAuthResource              = "dnc-auth-resource"
+	OptDNCAuthResourceAlias         = "dar"
+	OptManagedIdentityClientID      = "managed-identity-client-id"
+	OptManagedIdentityClientIDAlias = "mic"
+
+	// Program host routes sending the prefixes of network containers to their interface.
+	OptProgramHostRoutes      = "program-host-routes"
+	OptProgramHostRoutesAlias = "phr"
+
+	// Minutes network containers of deleted pods are kept before they are reclaimed, disabled if zero.
+	OptNCGCGracePeriod      = "nc-gc-grace-period"
+	OptNCGCGracePeriodAlias = "ngp"
+
+	// BGP peers the prefixes of network containers are advertised to, and the AS of the node.
+	OptBGPPeers      = "bgp-peers"
+	OptBGPPeersAlias = "bgpp"
+	OptBGPASN        = "bgp-asn"
+	OptBGPASNAlias   = "bgpa"
+
 	// Version.
 	OptVersion      = "version"
 	OptVersionAlias = "v"
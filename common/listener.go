@@ -4,6 +4,7 @@
 package common
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -12,6 +13,7 @@ import (
 	"os"
 
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/trace"
 )
 
 // Listener represents an HTTP listener.
@@ -20,7 +22,9 @@ type Listener struct {
 	protocol     string
 	localAddress string
 	endpoints    []string
+	capabilities []CapabilitiesProvider
 	active       bool
+	tlsConfig    *tls.Config
 	l            net.Listener
 	mux          *http.ServeMux
 }
@@ -47,12 +51,22 @@ func (listener *Listener) Start(errChan chan error) error {
 		return nil
 	}
 
+	// A unix socket left over by a previous instance that didn't stop cleanly,
+	// e.g. a managed plugin restarted by Docker, would fail the bind.
+	if listener.protocol == "unix" {
+		removeStaleSocket(listener.localAddress)
+	}
+
 	listener.l, err = net.Listen(listener.protocol, listener.localAddress)
 	if err != nil {
 		log.Printf("[Listener] Failed to listen: %+v", err)
 		return err
 	}
 
+	if listener.tlsConfig != nil {
+		listener.l = tls.NewListener(listener.l, listener.tlsConfig)
+	}
+
 	log.Printf("[Listener] Started listening on %s.", listener.localAddress)
 
 	// Launch goroutine for servicing requests.
@@ -64,6 +78,27 @@ func (listener *Listener) Start(errChan chan error) error {
 	return nil
 }
 
+// removeStaleSocket deletes a unix socket file if no process is listening on it.
+func removeStaleSocket(path string) {
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return
+	}
+
+	log.Printf("[Listener] Removing stale socket %s.", path)
+	os.Remove(path)
+}
+
+// SetTLSConfig makes the listener serve TLS with the given configuration once started.
+func (listener *Listener) SetTLSConfig(config *tls.Config) {
+	listener.tlsConfig = config
+}
+
 // Stop stops listening for requests.
 func (listener *Listener) Stop() {
 	// Ignore if not active.
@@ -99,8 +134,33 @@ func (listener *Listener) AddEndpoint(endpoint string) {
 }
 
 // AddHandler registers a protocol handler.
+// Requests are traced as part of the span of the caller, if any.
 func (listener *Listener) AddHandler(path string, handler func(http.ResponseWriter, *http.Request)) {
-	listener.mux.HandleFunc(path, handler)
+	listener.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		span := trace.StartSpan(path, trace.Extract(r))
+		span.SetAttribute("http.method", r.Method)
+
+		rw := &statusResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		handler(rw, r)
+
+		var err error
+		if rw.statusCode >= http.StatusBadRequest {
+			err = fmt.Errorf("Request failed with status code %d", rw.statusCode)
+		}
+		span.End(err)
+	})
+}
+
+// statusResponseWriter records the status code of a response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+// WriteHeader records and sends the status code of the response.
+func (rw *statusResponseWriter) WriteHeader(statusCode int) {
+	rw.statusCode = statusCode
+	rw.ResponseWriter.WriteHeader(statusCode)
 }
 
 // Decode receives and decodes JSON payload to a request.
@@ -0,0 +1,88 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenerRemovesStaleSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.sock")
+
+	// Leave a socket file behind without a listener.
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	listener, _ := NewListener(&url.URL{Scheme: "unix", Path: path})
+	if err := listener.Start(make(chan error, 1)); err != nil {
+		t.Fatalf("Failed to start listener over stale socket, err:%v", err)
+	}
+	defer listener.Stop()
+
+	// A socket with a live listener is kept.
+	other, _ := NewListener(&url.URL{Scheme: "unix", Path: path})
+	if err := other.Start(make(chan error, 1)); err == nil {
+		other.Stop()
+		t.Errorf("Listener started on a socket in use")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Socket in use was removed, err:%v", err)
+	}
+}
+
+func TestListenerAdvertisesCapabilities(t *testing.T) {
+	listener, _ := NewListener(&url.URL{Scheme: "tcp", Host: "localhost:0"})
+
+	for _, name := range []string{"plugin1", "plugin2"} {
+		plugin, _ := NewPlugin(name, "v1")
+		plugin.APIVersions = []string{"v0.1"}
+		plugin.AddFeatures(name + "Feature")
+		listener.AdvertiseCapabilities(plugin)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, CapabilitiesPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	listener.GetMux().ServeHTTP(w, req)
+
+	var resp CapabilitiesResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode capabilities, err:%v", err)
+	}
+
+	if len(resp.Plugins) != 2 {
+		t.Fatalf("Capabilities of %d plugins advertised, expected 2", len(resp.Plugins))
+	}
+
+	c := resp.Get("plugin2")
+	if c == nil || !c.Supports("plugin2Feature") || c.Supports("plugin1Feature") || !c.SupportsAPIVersion("v0.1") {
+		t.Errorf("Invalid capabilities %+v", c)
+	}
+
+	if resp.Get("plugin3") != nil {
+		t.Errorf("Capabilities returned for a plugin that isn't advertised")
+	}
+}
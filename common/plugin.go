@@ -9,11 +9,13 @@ import (
 
 // Plugin is the parent class that implements behavior common to all plugins.
 type Plugin struct {
-	Name    string
-	Version string
-	Options map[string]interface{}
-	ErrChan chan error
-	Store   store.KeyValueStore
+	Name        string
+	Version     string
+	APIVersions []string
+	Features    []string
+	Options     map[string]interface{}
+	ErrChan     chan error
+	Store       store.KeyValueStore
 }
 
 // Plugin base interface.
@@ -73,3 +75,18 @@ func (plugin *Plugin) GetOption(key string) interface{} {
 func (plugin *Plugin) SetOption(key string, value interface{}) {
 	plugin.Options[key] = value
 }
+
+// AddFeatures adds features to the capabilities advertised by the plugin.
+func (plugin *Plugin) AddFeatures(features ...string) {
+	plugin.Features = append(plugin.Features, features...)
+}
+
+// GetCapabilities returns the capabilities advertised by the plugin.
+func (plugin *Plugin) GetCapabilities() *Capabilities {
+	return &Capabilities{
+		Name:        plugin.Name,
+		Version:     plugin.Version,
+		APIVersions: plugin.APIVersions,
+		Features:    plugin.Features,
+	}
+}
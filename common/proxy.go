@@ -0,0 +1,140 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package common
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Wireserver address. Calls to wireserver, IMDS and other link-local or loopback
+	// addresses never go through a proxy.
+	wireserverIP = "168.63.129.16"
+)
+
+var (
+	proxyURL   *url.URL
+	proxyMutex sync.Mutex
+)
+
+// SetProxy sets the proxy of outbound HTTP calls, overriding the HTTPS_PROXY and HTTP_PROXY
+// environment variables. The proxy is taken from the environment again if proxy is empty.
+func SetProxy(proxy string) error {
+	var u *url.URL
+
+	if proxy != "" {
+		var err error
+		if u, err = url.Parse(proxy); err != nil {
+			return err
+		}
+	}
+
+	proxyMutex.Lock()
+	proxyURL = u
+	proxyMutex.Unlock()
+
+	return nil
+}
+
+// isDirectHost checks if a host is reached without a proxy.
+func isDirectHost(host string) bool {
+	if host == "localhost" || host == wireserverIP {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsLinkLocalUnicast())
+}
+
+// getNoProxy returns the NO_PROXY environment variable.
+func getNoProxy() string {
+	if noProxy := os.Getenv("NO_PROXY"); noProxy != "" {
+		return noProxy
+	}
+
+	return os.Getenv("no_proxy")
+}
+
+// matchesNoProxy checks if a host matches a comma separated list of IP addresses, CIDRs and
+// domain names, in NO_PROXY format.
+func matchesNoProxy(host string, noProxy string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+
+		if entry == "*" {
+			return true
+		}
+
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		entry = strings.TrimPrefix(entry, "*")
+		if host == strings.TrimPrefix(entry, ".") || strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Proxy returns the proxy of an outbound request, nil if the request goes directly.
+// The explicit proxy is used if set, otherwise the one in the environment, honoring NO_PROXY.
+func Proxy(req *http.Request) (*url.URL, error) {
+	host := req.URL.Hostname()
+	if isDirectHost(host) {
+		return nil, nil
+	}
+
+	proxyMutex.Lock()
+	u := proxyURL
+	proxyMutex.Unlock()
+
+	if u != nil {
+		if matchesNoProxy(host, getNoProxy()) {
+			return nil, nil
+		}
+		return u, nil
+	}
+
+	return http.ProxyFromEnvironment(req)
+}
+
+// NewHTTPClient returns an HTTP client for outbound calls whose transport uses Proxy.
+// Requests never time out if timeout is zero.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               Proxy,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		Timeout: timeout,
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package common
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	if err := SetProxy("http://proxy.contoso.com:3128"); err != nil {
+		t.Fatalf("SetProxy failed, err:%v", err)
+	}
+	defer SetProxy("")
+
+	for url, proxied := range map[string]bool{
+		"https://dc.services.visualstudio.com/v2/track":                             true,
+		"http://169.254.169.254/metadata/instance?api-version=2017-08-01":           false,
+		"http://168.63.129.16/machine/plugins?comp=nmagent&type=getinterfaceinfov1": false,
+		"http://localhost:10090/network/getnetworkcontainerbyorchestratorcontext":   false,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		u, err := Proxy(req)
+		if err != nil || (u != nil) != proxied {
+			t.Errorf("Unexpected proxy %v of %s, err:%v", u, url, err)
+		}
+	}
+}
+
+func TestMatchesNoProxy(t *testing.T) {
+	noProxy := "10.0.0.0/8, .svc.cluster.local,contoso.com:443,192.168.1.1"
+
+	for host, matches := range map[string]bool{
+		"10.1.2.3":                     true,
+		"kubernetes.svc.cluster.local": true,
+		"contoso.com":                  true,
+		"api.contoso.com":              true,
+		"192.168.1.1":                  true,
+		"192.168.1.2":                  false,
+		"notcontoso.com":               false,
+		"dc.services.visualstudio.com": false,
+	} {
+		if matchesNoProxy(host, noProxy) != matches {
+			t.Errorf("Unexpected NO_PROXY match of %s", host)
+		}
+	}
+}
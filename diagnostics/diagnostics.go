@@ -0,0 +1,129 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Paths of the diagnostics endpoints.
+	PprofPath      = "/debug/pprof/"
+	GoroutinesPath = "/debug/goroutines"
+	GCStatsPath    = "/debug/gcstats"
+)
+
+// GCStats are the garbage collector and memory statistics of the process.
+type GCStats struct {
+	NumGoroutine  int
+	NumGC         int64
+	LastGC        time.Time
+	PauseTotal    time.Duration
+	RecentPauses  []time.Duration
+	HeapAlloc     uint64
+	HeapInuse     uint64
+	HeapObjects   uint64
+	Sys           uint64
+	TotalAlloc    uint64
+	NextGC        uint64
+	GCCPUFraction float64
+}
+
+// Handler returns the HTTP handler serving the diagnostics endpoints.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PprofPath, pprof.Index)
+	mux.HandleFunc(PprofPath+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(PprofPath+"profile", pprof.Profile)
+	mux.HandleFunc(PprofPath+"symbol", pprof.Symbol)
+	mux.HandleFunc(PprofPath+"trace", pprof.Trace)
+	mux.HandleFunc(GoroutinesPath, handleGoroutines)
+	mux.HandleFunc(GCStatsPath, handleGCStats)
+
+	return mux
+}
+
+// handleGoroutines writes the stacks of all goroutines.
+func handleGoroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// handleGCStats writes the garbage collector and memory statistics.
+func handleGCStats(w http.ResponseWriter, r *http.Request) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	var gcStats debug.GCStats
+	debug.ReadGCStats(&gcStats)
+
+	stats := GCStats{
+		NumGoroutine:  runtime.NumGoroutine(),
+		NumGC:         gcStats.NumGC,
+		LastGC:        gcStats.LastGC,
+		PauseTotal:    gcStats.PauseTotal,
+		RecentPauses:  gcStats.Pause,
+		HeapAlloc:     memStats.HeapAlloc,
+		HeapInuse:     memStats.HeapInuse,
+		HeapObjects:   memStats.HeapObjects,
+		Sys:           memStats.Sys,
+		TotalAlloc:    memStats.TotalAlloc,
+		NextGC:        memStats.NextGC,
+		GCCPUFraction: memStats.GCCPUFraction,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&stats)
+}
+
+// validateAddress checks that an address is on the loopback interface.
+func validateAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("Diagnostics address %s is not a loopback address", address)
+	}
+
+	return nil
+}
+
+// StartServer serves the diagnostics endpoints on the given loopback address in the background.
+func StartServer(address string) error {
+	if err := validateAddress(address); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[diagnostics] Serving diagnostics endpoints on %s.", listener.Addr())
+
+	go func() {
+		if err := http.Serve(listener, Handler()); err != nil {
+			log.Printf("[diagnostics] Diagnostics server failed with error %v.", err)
+		}
+	}()
+
+	return nil
+}
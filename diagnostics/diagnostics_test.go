@@ -0,0 +1,50 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateAddress(t *testing.T) {
+	for _, address := range []string{"localhost:6060", "127.0.0.1:6060", "[::1]:6060"} {
+		if err := validateAddress(address); err != nil {
+			t.Errorf("Loopback address %s rejected, err:%v", address, err)
+		}
+	}
+
+	for _, address := range []string{":6060", "0.0.0.0:6060", "10.0.0.4:6060", "localhost"} {
+		if err := validateAddress(address); err == nil {
+			t.Errorf("Address %s accepted", address)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	handler := Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, GoroutinesPath, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "TestHandler") {
+		t.Errorf("Unexpected goroutine dump %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, GCStatsPath, nil))
+
+	var stats GCStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil || stats.NumGoroutine == 0 {
+		t.Errorf("Unexpected GC stats %+v, err:%v", stats, err)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, PprofPath, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected pprof index status %d", w.Code)
+	}
+}
@@ -4,13 +4,14 @@
 package ebtables
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
-	"os/exec"
 	"strings"
 
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/platform"
 )
 
 const (
@@ -33,68 +34,106 @@ func installEbtables() {
 	}
 }
 
+// Rule is a rule of a chain of the ebtables nat table.
+type Rule struct {
+	Chain string
+	Spec  string
+}
+
+// SnatForInterfaceRule returns the MAC SNAT rule for an interface.
+func SnatForInterfaceRule(interfaceName string, macAddress net.HardwareAddr) Rule {
+	return Rule{
+		Chain: "POSTROUTING",
+		Spec:  fmt.Sprintf("-s unicast -o %s -j snat --to-src %s --snat-arp --snat-target ACCEPT", interfaceName, macAddress.String()),
+	}
+}
+
+// ArpReplyRule returns the ARP reply rule for the given target IP address and MAC address.
+func ArpReplyRule(ipAddress net.IP, macAddress net.HardwareAddr) Rule {
+	return Rule{
+		Chain: "PREROUTING",
+		Spec:  fmt.Sprintf("-p ARP --arp-op Request --arp-ip-dst %s -j arpreply --arpreply-mac %s --arpreply-target DROP", ipAddress, macAddress.String()),
+	}
+}
+
+// DnatForArpRepliesRule returns the MAC DNAT rule for ARP replies received on an interface.
+func DnatForArpRepliesRule(interfaceName string) Rule {
+	return Rule{
+		Chain: "PREROUTING",
+		Spec:  fmt.Sprintf("-p ARP -i %s --arp-op Reply -j dnat --to-dst ff:ff:ff:ff:ff:ff --dnat-target ACCEPT", interfaceName),
+	}
+}
+
+// VepaModeRules returns the VEPA mode rules for a bridge and its ports.
+func VepaModeRules(bridgeName string, downstreamIfNamePrefix string, upstreamMacAddress string) []Rule {
+	var rules []Rule
+
+	if !strings.HasPrefix(bridgeName, downstreamIfNamePrefix) {
+		rules = append(rules, Rule{
+			Chain: "PREROUTING",
+			Spec:  fmt.Sprintf("-i %s -j dnat --to-dst %s --dnat-target ACCEPT", bridgeName, upstreamMacAddress),
+		})
+	}
+
+	return append(rules, Rule{
+		Chain: "PREROUTING",
+		Spec:  fmt.Sprintf("-i %s+ -j dnat --to-dst %s --dnat-target ACCEPT", downstreamIfNamePrefix, upstreamMacAddress),
+	})
+}
+
+// DnatForIPAddressRule returns the MAC DNAT rule for an IP address.
+func DnatForIPAddressRule(interfaceName string, ipAddress net.IP, macAddress net.HardwareAddr) Rule {
+	if ipAddress.To4() == nil {
+		return Rule{
+			Chain: "PREROUTING",
+			Spec:  fmt.Sprintf("-p IPv6 -i %s --ip6-dst %s -j dnat --to-dst %s --dnat-target ACCEPT", interfaceName, ipAddress.String(), macAddress.String()),
+		}
+	}
+
+	return Rule{
+		Chain: "PREROUTING",
+		Spec:  fmt.Sprintf("-p IPv4 -i %s --ip-dst %s -j dnat --to-dst %s --dnat-target ACCEPT", interfaceName, ipAddress.String(), macAddress.String()),
+	}
+}
+
+// setRule appends or deletes a rule.
+func setRule(rule Rule, action string) error {
+	return executeShellCommand(fmt.Sprintf("ebtables -t nat %s %s %s", action, rule.Chain, rule.Spec))
+}
+
 // SetSnatForInterface sets a MAC SNAT rule for an interface.
 func SetSnatForInterface(interfaceName string, macAddress net.HardwareAddr, action string) error {
-	command := fmt.Sprintf(
-		"ebtables -t nat %s POSTROUTING -s unicast -o %s -j snat --to-src %s --snat-arp --snat-target ACCEPT",
-		action, interfaceName, macAddress.String())
-
-	return executeShellCommand(command)
+	return setRule(SnatForInterfaceRule(interfaceName, macAddress), action)
 }
 
 // SetArpReply sets an ARP reply rule for the given target IP address and MAC address.
 func SetArpReply(ipAddress net.IP, macAddress net.HardwareAddr, action string) error {
-	command := fmt.Sprintf(
-		"ebtables -t nat %s PREROUTING -p ARP --arp-op Request --arp-ip-dst %s -j arpreply --arpreply-mac %s --arpreply-target DROP",
-		action, ipAddress, macAddress.String())
-
-	return executeShellCommand(command)
+	return setRule(ArpReplyRule(ipAddress, macAddress), action)
 }
 
 // SetDnatForArpReplies sets a MAC DNAT rule for ARP replies received on an interface.
 func SetDnatForArpReplies(interfaceName string, action string) error {
-	command := fmt.Sprintf(
-		"ebtables -t nat %s PREROUTING -p ARP -i %s --arp-op Reply -j dnat --to-dst ff:ff:ff:ff:ff:ff --dnat-target ACCEPT",
-		action, interfaceName)
-
-	return executeShellCommand(command)
+	return setRule(DnatForArpRepliesRule(interfaceName), action)
 }
 
 // SetVepaMode sets the VEPA mode for a bridge and its ports.
 func SetVepaMode(bridgeName string, downstreamIfNamePrefix string, upstreamMacAddress string, action string) error {
-	if !strings.HasPrefix(bridgeName, downstreamIfNamePrefix) {
-		command := fmt.Sprintf(
-			"ebtables -t nat %s PREROUTING -i %s -j dnat --to-dst %s --dnat-target ACCEPT",
-			action, bridgeName, upstreamMacAddress)
-
-		err := executeShellCommand(command)
-		if err != nil {
+	for _, rule := range VepaModeRules(bridgeName, downstreamIfNamePrefix, upstreamMacAddress) {
+		if err := setRule(rule, action); err != nil {
 			return err
 		}
 	}
 
-	command := fmt.Sprintf(
-		"ebtables -t nat %s PREROUTING -i %s+ -j dnat --to-dst %s --dnat-target ACCEPT",
-		action, downstreamIfNamePrefix, upstreamMacAddress)
-
-	return executeShellCommand(command)
+	return nil
 }
 
 // SetDnatForIPAddress sets a MAC DNAT rule for an IP address.
 func SetDnatForIPAddress(interfaceName string, ipAddress net.IP, macAddress net.HardwareAddr, action string) error {
-	command := fmt.Sprintf(
-		"ebtables -t nat %s PREROUTING -p IPv4 -i %s --ip-dst %s -j dnat --to-dst %s --dnat-target ACCEPT",
-		action, interfaceName, ipAddress.String(), macAddress.String())
-
-	return executeShellCommand(command)
+	return setRule(DnatForIPAddressRule(interfaceName, ipAddress, macAddress), action)
 }
 
 func executeShellCommand(command string) error {
 	log.Debugf("[ebtables] %s", command)
-	cmd := exec.Command("sh", "-c", command)
-	err := cmd.Start()
-	if err != nil {
-		return err
-	}
-	return cmd.Wait()
+	_, err := platform.ExecuteCommandContext(context.Background(), nil, "sh", "-c", command)
+	return err
 }
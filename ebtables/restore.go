@@ -0,0 +1,345 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ebtables
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/platform"
+)
+
+const (
+	natTable = "nat"
+
+	// Chains of the nat table holding the rules programmed by SyncRules.
+	// They are jumped to from the first rule of the built-in chain of the same name.
+	AzurePreroutingChain  = "AZURE-PREROUTING"
+	AzurePostroutingChain = "AZURE-POSTROUTING"
+)
+
+// azureChains maps the built-in chains to the chains holding their rules.
+var azureChains = map[string]string{
+	"PREROUTING":  AzurePreroutingChain,
+	"POSTROUTING": AzurePostroutingChain,
+}
+
+// Built-in chains of the nat table.
+var natBuiltinChains = []string{"PREROUTING", "OUTPUT", "POSTROUTING"}
+
+// table is a table of ebtables-save output.
+type table struct {
+	name     string
+	chains   []string
+	policies map[string]string
+	rules    map[string][]string
+}
+
+// parseTable parses a table of ebtables-save output. The table is empty if it isn't in the output.
+func parseTable(save string, name string) *table {
+	t := &table{
+		name:     name,
+		policies: make(map[string]string),
+		rules:    make(map[string][]string),
+	}
+
+	inTable := false
+	for _, line := range strings.Split(save, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case len(line) == 0 || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "*"):
+			inTable = line[1:] == name
+		case !inTable:
+		case strings.HasPrefix(line, ":"):
+			fields := strings.Fields(line[1:])
+			if len(fields) == 0 {
+				continue
+			}
+
+			t.chains = append(t.chains, fields[0])
+			if len(fields) > 1 {
+				t.policies[fields[0]] = fields[1]
+			}
+		case strings.HasPrefix(line, "-A "):
+			fields := strings.SplitN(line[3:], " ", 2)
+			if len(fields) == 2 {
+				t.rules[fields[0]] = append(t.rules[fields[0]], strings.TrimSpace(fields[1]))
+			}
+		}
+	}
+
+	return t
+}
+
+// String returns the table in ebtables-restore format.
+func (t *table) String() string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "*%s\n", t.name)
+	for _, chain := range t.chains {
+		fmt.Fprintf(&buf, ":%s %s\n", chain, t.policies[chain])
+	}
+
+	for _, chain := range t.chains {
+		for _, spec := range t.rules[chain] {
+			fmt.Fprintf(&buf, "-A %s %s\n", chain, spec)
+		}
+	}
+
+	return buf.String()
+}
+
+// normalizeSpec returns a form of a rule spec that doesn't depend on how ebtables lists it,
+// e.g. "-s unicast" and "00:0d:3a:..." are listed as "-s Unicast" and "0:d:3a:...".
+func normalizeSpec(spec string) string {
+	fields := strings.Fields(strings.ToLower(spec))
+
+	for i, field := range fields {
+		octets := strings.Split(field, ":")
+		if len(octets) != 6 {
+			continue
+		}
+
+		isMac := true
+		for j, octet := range octets {
+			value, err := strconv.ParseUint(octet, 16, 8)
+			if err != nil {
+				isMac = false
+				break
+			}
+			octets[j] = fmt.Sprintf("%02x", value)
+		}
+
+		if isMac {
+			fields[i] = strings.Join(octets, ":")
+		}
+	}
+
+	// Options can be listed in a different order than they were given.
+	sort.Strings(fields)
+
+	return strings.Join(fields, " ")
+}
+
+// containsSpec checks if a list of rule specs contains the given spec.
+func containsSpec(specs []string, spec string) bool {
+	for _, s := range specs {
+		if normalizeSpec(s) == normalizeSpec(spec) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasChain checks if a table has a chain.
+func (t *table) hasChain(chain string) bool {
+	for _, c := range t.chains {
+		if c == chain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sync returns the table with the Azure chains holding exactly the given rules, and the number
+// of rules added and removed. Copies of the given rules left in the built-in chains, e.g. by
+// earlier versions, are removed. Rules of other chains are kept.
+func (t *table) sync(rules []Rule) (*table, int, int) {
+	desired := make(map[string][]string)
+	for _, rule := range rules {
+		chain := azureChains[rule.Chain]
+		if chain == "" {
+			log.Printf("[ebtables] Skipping rule %+v of unsupported chain.", rule)
+			continue
+		}
+
+		if !containsSpec(desired[chain], rule.Spec) {
+			desired[chain] = append(desired[chain], rule.Spec)
+		}
+	}
+
+	synced := &table{
+		name:     t.name,
+		policies: make(map[string]string),
+		rules:    make(map[string][]string),
+	}
+
+	added, removed := 0, 0
+
+	// The built-in chains are missing if the table was never used.
+	chains := t.chains
+	for _, chain := range natBuiltinChains {
+		if !t.hasChain(chain) {
+			chains = append(chains, chain)
+		}
+	}
+
+	for _, chain := range chains {
+		// Azure chains are added back below if they still have rules.
+		if chain == AzurePreroutingChain || chain == AzurePostroutingChain {
+			continue
+		}
+
+		synced.chains = append(synced.chains, chain)
+		synced.policies[chain] = t.policies[chain]
+		if synced.policies[chain] == "" {
+			synced.policies[chain] = "ACCEPT"
+		}
+
+		azureChain := azureChains[chain]
+		jump := "-j " + azureChain
+		if len(desired[azureChain]) > 0 {
+			synced.rules[chain] = append(synced.rules[chain], jump)
+		}
+
+		for _, spec := range t.rules[chain] {
+			if azureChain != "" && normalizeSpec(spec) == normalizeSpec(jump) {
+				continue
+			}
+
+			if containsSpec(desired[azureChain], spec) {
+				removed++
+				continue
+			}
+
+			synced.rules[chain] = append(synced.rules[chain], spec)
+		}
+	}
+
+	for _, chain := range []string{AzurePreroutingChain, AzurePostroutingChain} {
+		for _, spec := range t.rules[chain] {
+			if !containsSpec(desired[chain], spec) {
+				removed++
+			}
+		}
+
+		for _, spec := range desired[chain] {
+			if !containsSpec(t.rules[chain], spec) {
+				added++
+			}
+		}
+
+		if len(desired[chain]) == 0 {
+			continue
+		}
+
+		synced.chains = append(synced.chains, chain)
+		synced.policies[chain] = "RETURN"
+		synced.rules[chain] = desired[chain]
+	}
+
+	return synced, added, removed
+}
+
+// equal checks if two tables have the same chains and rules.
+func (t *table) equal(other *table) bool {
+	if len(t.chains) != len(other.chains) {
+		return false
+	}
+
+	for _, chain := range t.chains {
+		if !other.hasChain(chain) || t.policies[chain] != other.policies[chain] {
+			return false
+		}
+
+		rules, otherRules := t.rules[chain], other.rules[chain]
+		if len(rules) != len(otherRules) {
+			return false
+		}
+
+		for i := range rules {
+			if normalizeSpec(rules[i]) != normalizeSpec(otherRules[i]) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// SyncRules makes the given PREROUTING and POSTROUTING rules the full set of rules held by the Azure chains
+// of the nat table. Missing rules are added and rules no longer needed are removed in a single
+// ebtables-restore of the table, keeping the rules of other chains. Nothing is written if the table is in sync.
+// Hosts without ebtables have no rules to remove.
+func SyncRules(rules []Rule) error {
+	if _, err := exec.LookPath("ebtables-save"); err != nil && len(rules) == 0 {
+		return nil
+	}
+
+	save, err := platform.ExecuteCommandContext(context.Background(), nil, "ebtables-save")
+	if err != nil {
+		log.Printf("[ebtables] Failed to list rules: %v.", err)
+		return err
+	}
+
+	current := parseTable(save.Stdout, natTable)
+	synced, added, removed := current.sync(rules)
+	if synced.equal(current) {
+		return nil
+	}
+
+	log.Printf("[ebtables] Syncing %s table, adding %d and removing %d rules.", natTable, added, removed)
+
+	restore := synced.String()
+	log.Debugf("[ebtables] Restoring:\n%s", restore)
+
+	if _, err := platform.ExecuteCommandContext(context.Background(), strings.NewReader(restore), "ebtables-restore"); err != nil {
+		log.Printf("[ebtables] Failed to restore rules: %v.", err)
+		return err
+	}
+
+	return nil
+}
+
+// diff returns the given rules missing from the Azure chains of the table, and the rules of the
+// Azure chains that aren't given.
+func (t *table) diff(rules []Rule) ([]Rule, []Rule) {
+	var missing, extra []Rule
+
+	desired := make(map[string][]string)
+	for _, rule := range rules {
+		chain := azureChains[rule.Chain]
+		if chain == "" {
+			continue
+		}
+
+		desired[chain] = append(desired[chain], rule.Spec)
+		if !containsSpec(t.rules[chain], rule.Spec) && !containsSpec(t.rules[rule.Chain], rule.Spec) {
+			missing = append(missing, rule)
+		}
+	}
+
+	for chain, azureChain := range azureChains {
+		for _, spec := range t.rules[azureChain] {
+			if !containsSpec(desired[azureChain], spec) {
+				extra = append(extra, Rule{Chain: chain, Spec: spec})
+			}
+		}
+	}
+
+	return missing, extra
+}
+
+// DiffRules compares the given PREROUTING and POSTROUTING rules with the rules of the nat table.
+// It returns the given rules that aren't programmed, and the rules of the Azure chains that aren't given.
+func DiffRules(rules []Rule) ([]Rule, []Rule, error) {
+	save, err := platform.ExecuteCommandContext(context.Background(), nil, "ebtables-save")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	missing, extra := parseTable(save.Stdout, natTable).diff(rules)
+
+	return missing, extra, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ebtables
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+const testSave = `# Generated by ebtables-save v1.0 on Mon Jan  7 10:00:00 UTC 2019
+*filter
+:INPUT ACCEPT
+*nat
+:PREROUTING ACCEPT
+:OUTPUT ACCEPT
+:POSTROUTING ACCEPT
+:AZURE-PREROUTING RETURN
+-A PREROUTING -j AZURE-PREROUTING
+-A PREROUTING -p 802_1Q -j DROP
+-A PREROUTING -p ARP --arp-op Request --arp-ip-dst 10.0.0.5 -j arpreply --arpreply-mac 0:d:3a:1:2:3 --arpreply-target DROP
+-A AZURE-PREROUTING -p ARP --arp-op Request --arp-ip-dst 10.0.0.4 -j arpreply --arpreply-mac 0:d:3a:1:2:3 --arpreply-target DROP
+-A AZURE-PREROUTING -p ARP --arp-op Request --arp-ip-dst 10.0.0.9 -j arpreply --arpreply-mac 0:d:3a:1:2:4 --arpreply-target DROP
+`
+
+func TestSyncTable(t *testing.T) {
+	mac, _ := net.ParseMAC("00:0d:3a:01:02:03")
+	rules := []Rule{
+		ArpReplyRule(net.ParseIP("10.0.0.4"), mac),
+		ArpReplyRule(net.ParseIP("10.0.0.5"), mac),
+		ArpReplyRule(net.ParseIP("10.0.0.5"), mac),
+		SnatForInterfaceRule("eth0", mac),
+	}
+
+	current := parseTable(testSave, natTable)
+	synced, added, removed := current.sync(rules)
+
+	// 10.0.0.5 moves from PREROUTING, 10.0.0.9 is stale.
+	if added != 2 || removed != 2 {
+		t.Errorf("Unexpected number of rules added %d and removed %d", added, removed)
+	}
+
+	prerouting := synced.rules["PREROUTING"]
+	if len(prerouting) != 2 || prerouting[0] != "-j "+AzurePreroutingChain || prerouting[1] != "-p 802_1Q -j DROP" {
+		t.Errorf("Unexpected PREROUTING rules %v", prerouting)
+	}
+
+	if len(synced.rules[AzurePreroutingChain]) != 2 || len(synced.rules[AzurePostroutingChain]) != 1 {
+		t.Errorf("Unexpected Azure chain rules %v", synced.rules)
+	}
+
+	if synced.equal(current) {
+		t.Errorf("Synced table is equal to the current table")
+	}
+
+	// Syncing the same rules again doesn't change the table.
+	resynced, added, removed := parseTable(synced.String(), natTable).sync(rules)
+	if added != 0 || removed != 0 || !resynced.equal(synced) {
+		t.Errorf("Table changed on resync, added %d removed %d", added, removed)
+	}
+
+	// The Azure chains are removed with their last rule.
+	emptied, _, removed := synced.sync(nil)
+	if removed != 3 || emptied.hasChain(AzurePreroutingChain) || len(emptied.rules["PREROUTING"]) != 1 {
+		t.Errorf("Unexpected table after removing all rules %+v", emptied)
+	}
+}
+
+func TestDiffTable(t *testing.T) {
+	mac, _ := net.ParseMAC("00:0d:3a:01:02:03")
+	rules := []Rule{
+		ArpReplyRule(net.ParseIP("10.0.0.4"), mac),
+		ArpReplyRule(net.ParseIP("10.0.0.5"), mac),
+		SnatForInterfaceRule("eth0", mac),
+	}
+
+	// 10.0.0.5 is programmed in PREROUTING by an earlier version, 10.0.0.9 is stale.
+	missing, extra := parseTable(testSave, natTable).diff(rules)
+
+	if len(missing) != 1 || missing[0].Chain != "POSTROUTING" {
+		t.Errorf("Unexpected missing rules %+v", missing)
+	}
+
+	if len(extra) != 1 || !strings.Contains(extra[0].Spec, "10.0.0.9") {
+		t.Errorf("Unexpected extra rules %+v", extra)
+	}
+}
+
+func TestDnatForIPAddressRule(t *testing.T) {
+	mac, _ := net.ParseMAC("00:0d:3a:01:02:03")
+
+	rule := DnatForIPAddressRule("eth0", net.ParseIP("10.0.0.4"), mac)
+	if !strings.Contains(rule.Spec, "-p IPv4 -i eth0 --ip-dst 10.0.0.4 ") {
+		t.Errorf("Unexpected IPv4 DNAT rule %q", rule.Spec)
+	}
+
+	rule = DnatForIPAddressRule("eth0", net.ParseIP("fd00::4"), mac)
+	if !strings.Contains(rule.Spec, "-p IPv6 -i eth0 --ip6-dst fd00::4 ") {
+		t.Errorf("Unexpected IPv6 DNAT rule %q", rule.Spec)
+	}
+}
@@ -24,10 +24,14 @@ var (
 	errAddressInUse            = fmt.Errorf("Address already in use")
 	errAddressNotInUse         = fmt.Errorf("Address not in use")
 	errNoAvailableAddresses    = fmt.Errorf("No available addresses")
+	errAddressExcluded         = fmt.Errorf("Address is excluded")
 
 	// Options used by AddressManager.
 	OptInterfaceName      = "azure.interface.name"
 	OptAddressID          = "azure.address.id"
 	OptAddressType        = "azure.address.type"
 	OptAddressTypeGateway = "gateway"
+	OptAddressSandbox     = "azure.address.sandbox"
+	OptAddressExclusions  = "azure.address.exclusions"
+	OptAddressPoolSpan    = "azure.address.poolspan"
 )
@@ -141,15 +141,33 @@ func (s *azureSource) refresh() error {
 				continue
 			}
 
+			// IPv6 prefixes listed without secondary addresses are delegated to the VM
+			// as a whole, and their addresses are allocated on demand.
+			if ap.IsIPv6 {
+				ap.IsDelegated = true
+				for _, a := range s.IPAddress {
+					if !a.IsPrimary {
+						ap.IsDelegated = false
+						break
+					}
+				}
+			}
+
 			// For each address in the subnet...
 			for _, a := range s.IPAddress {
+				address := net.ParseIP(a.Address)
+
 				// Primary addresses are reserved for the host.
 				if a.IsPrimary {
+					// Keep delegated prefixes from handing out the host's address.
+					if ap.IsDelegated {
+						if ar, err := ap.newAddressRecord(&address); err == nil {
+							ar.InUse = true
+						}
+					}
 					continue
 				}
 
-				address := net.ParseIP(a.Address)
-
 				_, err = ap.newAddressRecord(&address)
 				if err != nil {
 					log.Printf("[ipam] Failed to create address:%v err:%v.", address, err)
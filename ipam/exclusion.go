@@ -0,0 +1,83 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Represents an inclusive range of addresses that are never handed out.
+type addressRange struct {
+	first net.IP
+	last  net.IP
+}
+
+// Parses a comma separated list of addresses, CIDR prefixes and first-last address ranges.
+func parseAddressRanges(s string) ([]addressRange, error) {
+	var ranges []addressRange
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var r addressRange
+
+		if strings.Contains(entry, "/") {
+			// CIDR prefix.
+			_, subnet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid excluded prefix %v", entry)
+			}
+
+			r.first = subnet.IP.To16()
+			r.last = make(net.IP, net.IPv6len)
+			ones, bits := subnet.Mask.Size()
+			mask := net.CIDRMask(ones+8*net.IPv6len-bits, 8*net.IPv6len)
+			for i := range r.last {
+				r.last[i] = r.first[i] | ^mask[i]
+			}
+		} else if i := strings.Index(entry, "-"); i > 0 {
+			// Address range.
+			r.first = net.ParseIP(strings.TrimSpace(entry[:i]))
+			r.last = net.ParseIP(strings.TrimSpace(entry[i+1:]))
+		} else {
+			// Single address.
+			r.first = net.ParseIP(entry)
+			r.last = r.first
+		}
+
+		if r.first == nil || r.last == nil || (r.first.To4() == nil) != (r.last.To4() == nil) {
+			return nil, fmt.Errorf("Invalid excluded address range %v", entry)
+		}
+
+		r.first = r.first.To16()
+		r.last = r.last.To16()
+
+		if bytes.Compare(r.first, r.last) > 0 {
+			return nil, fmt.Errorf("Invalid excluded address range %v", entry)
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+// Returns if the address falls in any of the given ranges.
+func isAddressExcluded(ranges []addressRange, addr net.IP) bool {
+	addr = addr.To16()
+
+	for _, r := range ranges {
+		if bytes.Compare(addr, r.first) >= 0 && bytes.Compare(addr, r.last) <= 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// Maximum number of consumers reported when a pool is exhausted.
+	maxReportedConsumers = 5
+
+	// Owner reported for addresses allocated without a sandbox or ID.
+	unknownConsumer = "unknown"
+)
+
+// PoolConsumer describes an owner of addresses in a pool.
+type PoolConsumer struct {
+	Owner     string
+	Addresses int
+}
+
+// PoolExhaustedError is returned when an address pool has no addresses left to allocate.
+type PoolExhaustedError struct {
+	AddressSpace string
+	PoolId       string
+	Capacity     int
+	Allocated    int
+	TopConsumers []PoolConsumer
+}
+
+// Error returns a description of the exhausted pool.
+func (e *PoolExhaustedError) Error() string {
+	return fmt.Sprintf("%v in pool %v, capacity:%v allocated:%v top consumers:%+v",
+		errNoAvailableAddresses, e.PoolId, e.Capacity, e.Allocated, e.TopConsumers)
+}
+
+// Returns a structured description of the pool for an exhausted allocation.
+func (ap *addressPool) getExhaustedError() *PoolExhaustedError {
+	owners := make(map[string]int)
+
+	for _, ar := range ap.Addresses {
+		// Skip addresses that are neither allocated nor reserved.
+		if !ar.InUse && ar.ID == "" {
+			continue
+		}
+
+		owner := ar.Sandbox
+		if owner == "" {
+			owner = ar.ID
+		}
+		if owner == "" {
+			owner = unknownConsumer
+		}
+
+		owners[owner]++
+	}
+
+	e := &PoolExhaustedError{
+		PoolId:   ap.Id,
+		Capacity: len(ap.Addresses),
+	}
+
+	if ap.as != nil {
+		e.AddressSpace = ap.as.Id
+	}
+
+	for owner, count := range owners {
+		e.Allocated += count
+		e.TopConsumers = append(e.TopConsumers, PoolConsumer{Owner: owner, Addresses: count})
+	}
+
+	// Report the owners holding the most addresses first.
+	sort.Slice(e.TopConsumers, func(i, j int) bool {
+		if e.TopConsumers[i].Addresses != e.TopConsumers[j].Addresses {
+			return e.TopConsumers[i].Addresses > e.TopConsumers[j].Addresses
+		}
+		return e.TopConsumers[i].Owner < e.TopConsumers[j].Owner
+	})
+
+	if len(e.TopConsumers) > maxReportedConsumers {
+		e.TopConsumers = e.TopConsumers[:maxReportedConsumers]
+	}
+
+	return e
+}
@@ -4,6 +4,9 @@
 package ipam
 
 import (
+	"bytes"
+	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -16,16 +19,36 @@ import (
 const (
 	// IPAM store key.
 	storeKey = "IPAM"
+
+	// Prefix of the store keys locked for address spaces and pools.
+	lockKeyPrefix = storeKey + "|"
+
+	// Schema version of address manager state.
+	schemaVersion = 1
 )
 
+// Layout of persisted address manager state, with the migrations from older versions.
+var schema = &store.Schema{
+	Key:     storeKey,
+	Version: schemaVersion,
+}
+
 // AddressManager manages the set of address spaces and pools allocated to containers.
+// Processes sharing a store that can lock its keys lock the address pools they update, and
+// lock the store only to read and write the state, so requests for different pools proceed
+// in parallel.
 type addressManager struct {
-	Version    string
-	TimeStamp  time.Time
-	AddrSpaces map[string]*addressSpace `json:"AddressSpaces"`
-	store      store.KeyValueStore
-	source     addressConfigSource
-	netApi     common.NetApi
+	SchemaVersion int
+	Version       string
+	TimeStamp     time.Time
+	AddrSpaces    map[string]*addressSpace `json:"AddressSpaces"`
+	store         store.KeyValueStore
+	locker        store.KeyLocker
+	lockedKeys    map[string]*addressPoolId
+	sourcePools   map[string]bool
+	source        addressConfigSource
+	netApi        common.NetApi
+	metricsPath   string
 	sync.Mutex
 }
 
@@ -34,6 +57,8 @@ type AddressManager interface {
 	Initialize(config *common.PluginConfig, options map[string]interface{}) error
 	Uninitialize()
 
+	SetStore(store store.KeyValueStore) error
+
 	StartSource(options map[string]interface{}) error
 	StopSource()
 
@@ -45,6 +70,13 @@ type AddressManager interface {
 
 	RequestAddress(asId, poolId, address string, options map[string]string) (string, error)
 	ReleaseAddress(asId, poolId, address string, options map[string]string) error
+
+	ReleaseOrphanedAddresses(asId string, isSandboxAlive func(sandbox string) bool) ([]string, error)
+
+	GetAddresses() []AddressInfo
+	ForceReleaseAddress(address string) error
+
+	GetMetrics() *Metrics
 }
 
 // AddressConfigSource configures the address pools managed by AddressManager.
@@ -63,7 +95,9 @@ type addressConfigSink interface {
 // Creates a new address manager.
 func NewAddressManager() (AddressManager, error) {
 	am := &addressManager{
-		AddrSpaces: make(map[string]*addressSpace),
+		AddrSpaces:  make(map[string]*addressSpace),
+		lockedKeys:  make(map[string]*addressPoolId),
+		sourcePools: make(map[string]bool),
 	}
 
 	return am, nil
@@ -73,7 +107,9 @@ func NewAddressManager() (AddressManager, error) {
 func (am *addressManager) Initialize(config *common.PluginConfig, options map[string]interface{}) error {
 	am.Version = config.Version
 	am.store = config.Store
+	am.locker, _ = config.Store.(store.KeyLocker)
 	am.netApi = config.NetApi
+	am.metricsPath, _ = options[common.OptIpamMetricsPath].(string)
 
 	// Restore persisted state.
 	err := am.restore()
@@ -92,6 +128,18 @@ func (am *addressManager) Uninitialize() {
 	am.StopSource()
 }
 
+// SetStore replaces the persistent store of address manager and restores state from it.
+// Pools are still locked in the store the address manager was initialized with.
+func (am *addressManager) SetStore(store store.KeyValueStore) error {
+	am.Lock()
+	defer am.Unlock()
+
+	am.store = store
+	am.AddrSpaces = make(map[string]*addressSpace)
+
+	return am.restore()
+}
+
 // Restore reads address manager state from persistent store.
 func (am *addressManager) restore() error {
 	// Skip if a store is not provided.
@@ -100,6 +148,19 @@ func (am *addressManager) restore() error {
 		return nil
 	}
 
+	// Keep other processes from updating the state while it is migrated or rehydrated.
+	if am.locker != nil {
+		locked, err := am.lockStore()
+		if err != nil {
+			log.Printf("[ipam] Failed to lock store, err:%v\n", err)
+			return err
+		}
+
+		if locked {
+			defer am.unlockStore()
+		}
+	}
+
 	rebooted := false
 
 	// Check if the VM is rebooted.
@@ -114,8 +175,15 @@ func (am *addressManager) restore() error {
 		}
 	}
 
+	// Upgrade state persisted by older versions.
+	err = schema.Migrate(am.store)
+	if err != nil {
+		log.Printf("[ipam] Failed to migrate state, err:%v\n", err)
+		return err
+	}
+
 	// Read any persisted state.
-	err = am.store.Read(storeKey, am)
+	err = am.read()
 	if err != nil {
 		if err == store.ErrKeyNotFound {
 			log.Printf("[ipam] store key not found")
@@ -126,6 +194,48 @@ func (am *addressManager) restore() error {
 		}
 	}
 
+	// if rebooted mark the ip as not in use.
+	if rebooted {
+		log.Printf("[ipam] Rehydrating ipam state from persistent store")
+		for _, as := range am.AddrSpaces {
+			for _, ap := range as.Pools {
+				ap.as = as
+				ap.RefCount = 0
+
+				for _, ar := range ap.Addresses {
+					ar.InUse = false
+					ar.Sandbox = ""
+				}
+			}
+		}
+
+		// Other processes save only the pools they lock, so the rehydrated state is saved as a whole.
+		if am.locker != nil {
+			am.SchemaVersion = schemaVersion
+			am.TimeStamp = time.Now()
+
+			err = am.store.Write(storeKey, am)
+			if err != nil {
+				log.Printf("[ipam] Failed to save rehydrated state, err:%v\n", err)
+				return err
+			}
+		}
+	}
+
+	log.Printf("[ipam] Restored state, %+v\n", am)
+
+	return nil
+}
+
+// Reads address manager state from persistent store, replacing the state in memory.
+func (am *addressManager) read() error {
+	am.AddrSpaces = make(map[string]*addressSpace)
+
+	err := am.store.Read(storeKey, am)
+	if err != nil {
+		return err
+	}
+
 	// Populate pointers.
 	for _, as := range am.AddrSpaces {
 		for _, ap := range as.Pools {
@@ -140,26 +250,109 @@ func (am *addressManager) restore() error {
 		}
 	}
 
-	// if rebooted mark the ip as not in use.
-	if rebooted {
-		log.Printf("[ipam] Rehydrating ipam state from persistent store")
-		for _, as := range am.AddrSpaces {
-			for _, ap := range as.Pools {
-				ap.as = as
-				ap.RefCount = 0
+	return nil
+}
 
-				for _, ar := range ap.Addresses {
-					ar.InUse = false
-				}
-			}
+// Reloads the state saved by the processes sharing the store.
+// Callers reload after locking pools, since their state may have changed while they waited.
+func (am *addressManager) reload() error {
+	if am.locker == nil || am.store == nil {
+		return nil
+	}
+
+	locked, err := am.lockStore()
+	if err != nil {
+		log.Printf("[ipam] Failed to lock store, err:%v\n", err)
+		return err
+	}
+
+	if locked {
+		defer am.unlockStore()
+	}
+
+	err = am.read()
+	if err != nil && err != store.ErrKeyNotFound {
+		log.Printf("[ipam] Failed to reload state, err:%v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Locks the store shared with other processes to read or write the state.
+// Returns false if the store was already locked by the caller of the address manager.
+func (am *addressManager) lockStore() (bool, error) {
+	err := am.locker.Lock(true)
+	if err == store.ErrStoreLocked {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Unlocks the store shared with other processes.
+func (am *addressManager) unlockStore() {
+	if err := am.locker.Unlock(false); err != nil {
+		log.Printf("[ipam] Failed to unlock store, err:%v\n", err)
+	}
+}
+
+// Returns the key locked in the store for an address space, or a pool if poolId is set.
+func getLockKey(asId, poolId string) string {
+	return lockKeyPrefix + NewAddressPoolId(asId, poolId, "").String()
+}
+
+// Locks the given address pool, or the address space if poolId is empty, for the processes
+// sharing the store. Locks are held until unlockPools is called.
+func (am *addressManager) lockPool(asId, poolId string, block bool) error {
+	if am.locker == nil {
+		return nil
+	}
+
+	key := getLockKey(asId, poolId)
+	if am.lockedKeys[key] != nil {
+		return nil
+	}
+
+	err := am.locker.LockKey(key, block)
+	if err != nil {
+		if block {
+			log.Printf("[ipam] Failed to lock %v, err:%v.", key, err)
 		}
+		return err
 	}
 
-	log.Printf("[ipam] Restored state, %+v\n", am)
+	am.lockedKeys[key] = NewAddressPoolId(asId, poolId, "")
 
 	return nil
 }
 
+// Returns whether the given address pool can be updated by this process.
+func (am *addressManager) isPoolLocked(asId, poolId string) bool {
+	return am.locker == nil || am.lockedKeys[getLockKey(asId, poolId)] != nil
+}
+
+// Unlocks the given address pool.
+func (am *addressManager) unlockPool(asId, poolId string) {
+	key := getLockKey(asId, poolId)
+	if am.lockedKeys[key] == nil {
+		return
+	}
+
+	if err := am.locker.UnlockKey(key); err != nil {
+		log.Printf("[ipam] Failed to unlock %v, err:%v.", key, err)
+	}
+
+	delete(am.lockedKeys, key)
+}
+
+// Unlocks all address pools and spaces locked by this process.
+func (am *addressManager) unlockPools() {
+	for _, pid := range am.lockedKeys {
+		am.unlockPool(pid.AsId, pid.Subnet)
+	}
+}
+
 // Save writes address manager state to persistent store.
 func (am *addressManager) save() error {
 	// Skip if a store is not provided.
@@ -168,17 +361,93 @@ func (am *addressManager) save() error {
 	}
 
 	// Update time stamp.
+	am.SchemaVersion = schemaVersion
 	am.TimeStamp = time.Now()
 
+	if am.locker != nil {
+		return am.saveLockedPools()
+	}
+
 	err := am.store.Write(storeKey, am)
 	if err == nil {
 		log.Printf("[ipam] Save succeeded.\n")
 	} else {
 		log.Printf("[ipam] Save failed, err:%v\n", err)
 	}
+
+	// Metrics are best effort and do not fail the operation.
+	am.writeMetrics()
+
 	return err
 }
 
+// Writes the pools locked by this process over the state saved by other processes, leaving the
+// pools they hold as they saved them. Pools learned from the source that no process saved yet
+// are added too.
+func (am *addressManager) saveLockedPools() error {
+	locked, err := am.lockStore()
+	if err != nil {
+		log.Printf("[ipam] Save failed, err:%v\n", err)
+		return err
+	}
+
+	if locked {
+		defer am.unlockStore()
+	}
+
+	saved := &addressManager{AddrSpaces: make(map[string]*addressSpace)}
+	err = am.store.Read(storeKey, saved)
+	if err != nil && err != store.ErrKeyNotFound {
+		log.Printf("[ipam] Save failed, err:%v\n", err)
+		return err
+	}
+
+	for _, as := range am.AddrSpaces {
+		sas := saved.AddrSpaces[as.Id]
+		if sas == nil {
+			sas = &addressSpace{Id: as.Id, Scope: as.Scope, Pools: make(map[string]*addressPool)}
+			saved.AddrSpaces[as.Id] = sas
+		}
+
+		for poolId, ap := range as.Pools {
+			key := getLockKey(as.Id, poolId)
+			if am.lockedKeys[key] != nil || (sas.Pools[poolId] == nil && am.sourcePools[key]) {
+				sas.Pools[poolId] = ap
+			}
+		}
+	}
+
+	// Remove the locked pools deleted by this process.
+	for _, pid := range am.lockedKeys {
+		as, sas := am.AddrSpaces[pid.AsId], saved.AddrSpaces[pid.AsId]
+		if pid.Subnet == "" || sas == nil {
+			continue
+		}
+
+		if as == nil || as.Pools[pid.Subnet] == nil {
+			delete(sas.Pools, pid.Subnet)
+		}
+	}
+
+	saved.SchemaVersion = am.SchemaVersion
+	saved.Version = am.Version
+	saved.TimeStamp = am.TimeStamp
+
+	err = am.store.Write(storeKey, saved)
+	if err != nil {
+		log.Printf("[ipam] Save failed, err:%v\n", err)
+		return err
+	}
+
+	log.Printf("[ipam] Save succeeded.\n")
+
+	// Metrics cover the pools of all processes, and are written with the store locked so writes don't interleave.
+	saved.metricsPath = am.metricsPath
+	saved.writeMetrics()
+
+	return nil
+}
+
 // Starts configuration source.
 func (am *addressManager) StartSource(options map[string]interface{}) error {
 	var err error
@@ -192,6 +461,9 @@ func (am *addressManager) StartSource(options map[string]interface{}) error {
 	case common.OptEnvironmentMAS:
 		am.source, err = newMasSource(options)
 
+	case common.OptEnvironmentStatic:
+		am.source, err = newStaticSource(options)
+
 	case "null":
 		am.source, err = newNullSource()
 
@@ -202,7 +474,7 @@ func (am *addressManager) StartSource(options map[string]interface{}) error {
 		return errInvalidConfiguration
 	}
 
-	if am.source != nil {
+	if err == nil && am.source != nil {
 		log.Printf("[ipam] Starting source %v.", environment)
 		err = am.source.start(am)
 	}
@@ -263,33 +535,82 @@ func (am *addressManager) GetDefaultAddressSpaces() (string, string) {
 
 // RequestPool reserves an address pool.
 func (am *addressManager) RequestPool(asId, poolId, subPoolId string, options map[string]string, v6 bool) (string, string, error) {
+	var chosenId string
+
 	am.Lock()
 	defer am.Unlock()
+	defer am.unlockPools()
 
-	am.refreshSource()
-
-	as, err := am.getAddressSpace(asId)
+	// Pools are chosen with the address space locked, so that processes don't choose the same one.
+	err := am.lockPool(asId, poolId, true)
 	if err != nil {
 		return "", "", err
 	}
 
-	pool, err := as.requestPool(poolId, subPoolId, options, v6)
-	if err != nil {
-		return "", "", err
-	}
+	for {
+		err = am.reload()
+		if err != nil {
+			return "", "", err
+		}
 
-	err = am.save()
-	if err != nil {
-		return "", "", err
-	}
+		am.refreshSource()
+
+		as, err := am.getAddressSpace(asId)
+		if err != nil {
+			return "", "", err
+		}
+
+		// Take the chosen pool unless a network extended to it while it was being locked.
+		id := poolId
+		if chosenId != "" {
+			if ap := as.Pools[chosenId]; ap != nil && !ap.isInUse() {
+				id = chosenId
+			} else {
+				am.unlockPool(asId, chosenId)
+				chosenId = ""
+			}
+		}
+
+		pool, err := as.requestPool(id, subPoolId, options, v6)
+		if err != nil {
+			return "", "", err
+		}
+
+		// Lock the chosen pool and check it again in the state it was left in.
+		if !am.isPoolLocked(asId, pool.Id) {
+			err = am.lockPool(asId, pool.Id, true)
+			if err != nil {
+				return "", "", err
+			}
+
+			chosenId = pool.Id
+			continue
+		}
+
+		err = am.save()
+		if err != nil {
+			return "", "", err
+		}
 
-	return pool.Id, pool.Subnet.String(), nil
+		return pool.Id, pool.Subnet.String(), nil
+	}
 }
 
 // ReleasePool releases a previously reserved address pool.
 func (am *addressManager) ReleasePool(asId string, poolId string) error {
 	am.Lock()
 	defer am.Unlock()
+	defer am.unlockPools()
+
+	err := am.lockPool(asId, poolId, true)
+	if err != nil {
+		return err
+	}
+
+	err = am.reload()
+	if err != nil {
+		return err
+	}
 
 	am.refreshSource()
 
@@ -298,6 +619,35 @@ func (am *addressManager) ReleasePool(asId string, poolId string) error {
 		return err
 	}
 
+	// The pools extending this pool are released along with it.
+	if am.locker != nil {
+		var extensionIds []string
+		for _, pool := range as.Pools {
+			if pool.ExtendsPoolId == poolId {
+				extensionIds = append(extensionIds, pool.Id)
+			}
+		}
+
+		if len(extensionIds) > 0 {
+			for _, id := range extensionIds {
+				err = am.lockPool(asId, id, true)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = am.reload()
+			if err != nil {
+				return err
+			}
+
+			as, err = am.getAddressSpace(asId)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	err = as.releasePool(poolId)
 	if err != nil {
 		return err
@@ -333,6 +683,17 @@ func (am *addressManager) GetPoolInfo(asId string, poolId string) (*AddressPoolI
 func (am *addressManager) RequestAddress(asId, poolId, address string, options map[string]string) (string, error) {
 	am.Lock()
 	defer am.Unlock()
+	defer am.unlockPools()
+
+	err := am.lockPool(asId, poolId, true)
+	if err != nil {
+		return "", err
+	}
+
+	err = am.reload()
+	if err != nil {
+		return "", err
+	}
 
 	am.refreshSource()
 
@@ -347,7 +708,28 @@ func (am *addressManager) RequestAddress(asId, poolId, address string, options m
 	}
 
 	addr, err := ap.requestAddress(address, options)
+
+	// Allocate from the pools extending the address space if requested.
+	if err == errNoAvailableAddresses && address == "" && options[OptAddressPoolSpan] == "true" {
+		var pools []*addressPool
+
+		as, ap, pools, err = am.getExtensionPools(asId, poolId)
+		if err != nil {
+			return "", err
+		}
+
+		_, addr, err = as.requestExtensionAddress(ap, pools, options)
+	}
+
 	if err != nil {
+		// Persist the failure so that it shows up in pool metrics.
+		ap.AllocationFailures++
+		if err == errNoAvailableAddresses {
+			exhaustedErr := ap.getExhaustedError()
+			log.Printf("[ipam] Address pool exhausted: %+v.", exhaustedErr)
+			err = exhaustedErr
+		}
+		am.save()
 		return "", err
 	}
 
@@ -359,10 +741,66 @@ func (am *addressManager) RequestAddress(asId, poolId, address string, options m
 	return addr, nil
 }
 
+// Returns the pools that may extend the address space of the given pool and can be updated by
+// this process. Processes sharing the store lock those they can without waiting for other
+// processes, and reload the state they were left in.
+func (am *addressManager) getExtensionPools(asId, poolId string) (*addressSpace, *addressPool, []*addressPool, error) {
+	as, err := am.getAddressSpace(asId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ap, err := as.getAddressPool(poolId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if am.locker != nil {
+		for _, pool := range as.getExtensionPools(ap) {
+			am.lockPool(asId, pool.Id, false)
+		}
+
+		err = am.reload()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		as, err = am.getAddressSpace(asId)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		ap, err = as.getAddressPool(poolId)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var pools []*addressPool
+	for _, pool := range as.getExtensionPools(ap) {
+		if am.isPoolLocked(asId, pool.Id) {
+			pools = append(pools, pool)
+		}
+	}
+
+	return as, ap, pools, nil
+}
+
 // ReleaseAddress releases a previously reserved address.
 func (am *addressManager) ReleaseAddress(asId string, poolId string, address string, options map[string]string) error {
 	am.Lock()
 	defer am.Unlock()
+	defer am.unlockPools()
+
+	err := am.lockPool(asId, poolId, true)
+	if err != nil {
+		return err
+	}
+
+	err = am.reload()
+	if err != nil {
+		return err
+	}
 
 	am.refreshSource()
 
@@ -376,6 +814,43 @@ func (am *addressManager) ReleaseAddress(asId string, poolId string, address str
 		return err
 	}
 
+	// Dual-stack endpoints and networks spanning multiple pools release addresses
+	// of other pools through the pool ID of the network.
+	if addr := net.ParseIP(address); addr != nil && !ap.Subnet.Contains(addr) {
+		ap = as.getAddressPoolForAddress(addr)
+		if ap == nil {
+			log.Printf("[ipam] No pool found for address %v.", address)
+			return nil
+		}
+
+		// Lock the pool of the address instead, and release it in the state that pool was left in.
+		if !am.isPoolLocked(asId, ap.Id) {
+			id := ap.Id
+			am.unlockPool(asId, poolId)
+
+			err = am.lockPool(asId, id, true)
+			if err != nil {
+				return err
+			}
+
+			err = am.reload()
+			if err != nil {
+				return err
+			}
+
+			as, err = am.getAddressSpace(asId)
+			if err != nil {
+				return err
+			}
+
+			ap = as.getAddressPoolForAddress(addr)
+			if ap == nil {
+				log.Printf("[ipam] No pool found for address %v.", address)
+				return nil
+			}
+		}
+	}
+
 	err = ap.releaseAddress(address, options)
 	if err != nil {
 		return err
@@ -388,3 +863,161 @@ func (am *addressManager) ReleaseAddress(asId string, poolId string, address str
 
 	return nil
 }
+
+// ReleaseOrphanedAddresses releases addresses in the address space whose sandbox no longer exists.
+// Pools are locked one at a time, so processes waiting for the others can proceed meanwhile.
+func (am *addressManager) ReleaseOrphanedAddresses(asId string, isSandboxAlive func(sandbox string) bool) ([]string, error) {
+	var released []string
+	var poolIds []string
+
+	am.Lock()
+	defer am.Unlock()
+	defer am.unlockPools()
+
+	err := am.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	as, err := am.getAddressSpace(asId)
+	if err != nil {
+		return nil, err
+	}
+
+	for poolId := range as.Pools {
+		poolIds = append(poolIds, poolId)
+	}
+
+	for _, poolId := range poolIds {
+		err = am.lockPool(asId, poolId, true)
+		if err != nil {
+			return released, err
+		}
+
+		err = am.reload()
+		if err != nil {
+			return released, err
+		}
+
+		as, err = am.getAddressSpace(asId)
+		if err != nil {
+			return released, err
+		}
+
+		if ap := as.Pools[poolId]; ap != nil {
+			poolReleased := ap.releaseOrphanedAddresses(isSandboxAlive)
+
+			if len(poolReleased) > 0 {
+				err = am.save()
+				if err != nil {
+					return released, err
+				}
+
+				released = append(released, poolReleased...)
+			}
+		}
+
+		am.unlockPool(asId, poolId)
+	}
+
+	return released, nil
+}
+
+// GetMetrics returns utilization metrics of all address pools.
+func (am *addressManager) GetMetrics() *Metrics {
+	am.Lock()
+	defer am.Unlock()
+
+	return am.getMetrics()
+}
+
+// GetAddresses returns all addresses managed by address manager along with their owners.
+func (am *addressManager) GetAddresses() []AddressInfo {
+	var addrs []AddressInfo
+
+	am.Lock()
+	defer am.Unlock()
+
+	// Include the addresses allocated by other processes sharing the store.
+	am.reload()
+
+	for _, as := range am.AddrSpaces {
+		for _, ap := range as.Pools {
+			for _, ar := range ap.Addresses {
+				addrs = append(addrs, AddressInfo{
+					AddressSpace: as.Id,
+					PoolId:       ap.Id,
+					Address:      ar.Addr.String(),
+					InUse:        ar.InUse,
+					ID:           ar.ID,
+					Sandbox:      ar.Sandbox,
+					Unhealthy:    ar.unhealthy,
+				})
+			}
+		}
+	}
+
+	// Keep the output stable.
+	sort.Slice(addrs, func(i, j int) bool {
+		if addrs[i].PoolId != addrs[j].PoolId {
+			return addrs[i].PoolId < addrs[j].PoolId
+		}
+		return bytes.Compare(net.ParseIP(addrs[i].Address).To16(), net.ParseIP(addrs[j].Address).To16()) < 0
+	})
+
+	return addrs
+}
+
+// ForceReleaseAddress releases the given address regardless of its owner.
+func (am *addressManager) ForceReleaseAddress(address string) error {
+	addr := net.ParseIP(address)
+	if addr == nil {
+		return errInvalidAddress
+	}
+
+	am.Lock()
+	defer am.Unlock()
+	defer am.unlockPools()
+
+	err := am.reload()
+	if err != nil {
+		return err
+	}
+
+	for _, as := range am.AddrSpaces {
+		ap := as.getAddressPoolForAddress(addr)
+		if ap == nil {
+			continue
+		}
+
+		// Lock the pool of the address and release it in the state that pool was left in.
+		if !am.isPoolLocked(as.Id, ap.Id) {
+			asId, poolId := as.Id, ap.Id
+
+			err = am.lockPool(asId, poolId, true)
+			if err != nil {
+				return err
+			}
+
+			err = am.reload()
+			if err != nil {
+				return err
+			}
+
+			if as = am.AddrSpaces[asId]; as == nil || as.Pools[poolId] == nil {
+				return errAddressNotFound
+			}
+
+			ap = as.Pools[poolId]
+		}
+
+		err = ap.forceReleaseAddress(addr.String())
+		if err != nil {
+			return err
+		}
+
+		return am.save()
+	}
+
+	return errAddressNotFound
+}
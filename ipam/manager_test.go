@@ -5,10 +5,14 @@ package ipam
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/store"
 )
 
 var (
@@ -55,6 +59,29 @@ func createAddressManager() (AddressManager, error) {
 	return am, nil
 }
 
+// createSharedAddressManager creates an address manager persisting its state in the given file,
+// like the processes of CNI invocations do.
+func createSharedAddressManager(t *testing.T, fileName string) (AddressManager, store.KeyLocker) {
+	kvs, err := store.NewJsonFileStore(fileName)
+	if err != nil {
+		t.Fatalf("Failed to create store, err:%v.", err)
+	}
+
+	config := common.PluginConfig{Store: kvs}
+
+	am, err := NewAddressManager()
+	if err != nil {
+		t.Fatalf("NewAddressManager failed, err:%v.", err)
+	}
+
+	err = am.Initialize(&config, nil)
+	if err != nil {
+		t.Fatalf("Initialize failed, err:%v.", err)
+	}
+
+	return am, kvs.(store.KeyLocker)
+}
+
 // dumpAddressManager dumps the contents of an address manager.
 func dumpAddressManager(am AddressManager) {
 	amImpl := am.(*addressManager)
@@ -371,3 +398,444 @@ func TestAddressRequestsFromTheSamePool(t *testing.T) {
 		t.Errorf("ReleasePool failed, err:%v", err)
 	}
 }
+
+// Tests pool metrics track allocated addresses and allocation failures.
+func TestAddressPoolMetrics(t *testing.T) {
+	// Start with the test address space.
+	am, err := createAddressManager()
+	if err != nil {
+		t.Fatalf("createAddressManager failed, err:%+v.", err)
+	}
+
+	// Subnet2 has a single address, so the second request fails.
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", nil)
+	if err != nil {
+		t.Errorf("RequestAddress failed, err:%v", err)
+	}
+
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", map[string]string{OptAddressSandbox: "sandbox2"})
+	exhaustedErr, ok := err.(*PoolExhaustedError)
+	if !ok {
+		t.Fatalf("RequestAddress did not fail with PoolExhaustedError on an exhausted pool, err:%v", err)
+	}
+
+	if exhaustedErr.PoolId != subnet2.String() || exhaustedErr.Capacity != 1 || exhaustedErr.Allocated != 1 ||
+		len(exhaustedErr.TopConsumers) != 1 || exhaustedErr.TopConsumers[0].Owner != unknownConsumer {
+		t.Errorf("RequestAddress returned invalid exhaustion details %+v.", exhaustedErr)
+	}
+
+	var pm *PoolMetrics
+	metrics := am.GetMetrics()
+	for i := range metrics.Pools {
+		if metrics.Pools[i].PoolId == subnet2.String() {
+			pm = &metrics.Pools[i]
+		}
+	}
+
+	if pm == nil {
+		t.Fatalf("GetMetrics did not return subnet2.")
+	}
+
+	if pm.Total != 1 || pm.Allocated != 1 || pm.Free != 0 || pm.AllocationFailures != 1 {
+		t.Errorf("GetMetrics returned invalid metrics for subnet2 %+v.", pm)
+	}
+}
+
+// Tests addresses owned by sandboxes that no longer exist are released.
+func TestReleaseOrphanedAddresses(t *testing.T) {
+	// Start with the test address space.
+	am, err := createAddressManager()
+	if err != nil {
+		t.Fatalf("createAddressManager failed, err:%+v.", err)
+	}
+
+	options := map[string]string{OptAddressSandbox: "/var/run/netns/dead"}
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", options)
+	if err != nil {
+		t.Errorf("RequestAddress failed, err:%v", err)
+	}
+
+	// Nothing is released while the sandbox is alive.
+	released, err := am.ReleaseOrphanedAddresses(LocalDefaultAddressSpaceId, func(string) bool { return true })
+	if err != nil || len(released) != 0 {
+		t.Errorf("ReleaseOrphanedAddresses released %v, err:%v", released, err)
+	}
+
+	released, err = am.ReleaseOrphanedAddresses(LocalDefaultAddressSpaceId, func(string) bool { return false })
+	if err != nil || len(released) != 1 || released[0] != addr21.String() {
+		t.Errorf("ReleaseOrphanedAddresses released %v, err:%v", released, err)
+	}
+
+	// The released address can be allocated again.
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", nil)
+	if err != nil {
+		t.Errorf("RequestAddress failed after release, err:%v", err)
+	}
+}
+
+// Tests releasing orphaned addresses also releases the ID they are reserved for.
+func TestReleaseOrphanedAddressesWithID(t *testing.T) {
+	// Start with the test address space.
+	am, err := createAddressManager()
+	if err != nil {
+		t.Fatalf("createAddressManager failed, err:%+v.", err)
+	}
+
+	// Reserve the address for an ID, then use it from a sandbox.
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", map[string]string{OptAddressID: "endpoint1"})
+	if err != nil {
+		t.Fatalf("RequestAddress with ID failed, err:%v", err)
+	}
+
+	options := map[string]string{OptAddressSandbox: "/var/run/netns/dead"}
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), addr21.String(), options)
+	if err != nil {
+		t.Fatalf("RequestAddress from sandbox failed, err:%v", err)
+	}
+
+	released, err := am.ReleaseOrphanedAddresses(LocalDefaultAddressSpaceId, func(string) bool { return false })
+	if err != nil || len(released) != 1 || released[0] != addr21.String() {
+		t.Errorf("ReleaseOrphanedAddresses released %v, err:%v", released, err)
+	}
+
+	localAs, _ := am.(*addressManager).getAddressSpace(LocalDefaultAddressSpaceId)
+	ap, _ := localAs.getAddressPool(subnet2.String())
+
+	if ar := ap.Addresses[addr21.String()]; ar.InUse || ar.ID != "" || ar.Sandbox != "" {
+		t.Errorf("Released address record is %+v, expected it free", ar)
+	}
+
+	if ap.addrsByID["endpoint1"] != nil {
+		t.Errorf("Released address is still reserved for its ID")
+	}
+}
+
+// Tests addresses are allocated on demand from delegated IPv6 prefixes.
+func TestDelegatedIPv6AddressPool(t *testing.T) {
+	// Start with the test address space.
+	am, err := createAddressManager()
+	if err != nil {
+		t.Fatalf("createAddressManager failed, err:%+v.", err)
+	}
+
+	amImpl := am.(*addressManager)
+	localAs, _ := amImpl.getAddressSpace(LocalDefaultAddressSpaceId)
+
+	_, subnet6, _ := net.ParseCIDR("fd00:0:0:1::/64")
+	ap, err := localAs.newAddressPool(anyInterface, anyPriority, subnet6)
+	if err != nil {
+		t.Fatalf("newAddressPool failed, err:%v", err)
+	}
+	ap.IsDelegated = true
+
+	poolId, _, err := am.RequestPool(LocalDefaultAddressSpaceId, "", "", nil, true)
+	if err != nil || poolId != subnet6.String() {
+		t.Fatalf("RequestPool returned poolId:%v err:%v", poolId, err)
+	}
+
+	address, err := am.RequestAddress(LocalDefaultAddressSpaceId, poolId, "", nil)
+	if err != nil || address != "fd00:0:0:1::4/64" {
+		t.Errorf("RequestAddress returned address:%v err:%v", address, err)
+	}
+
+	// Specific addresses are accepted in any notation, but only from the pool's address family.
+	address, err = am.RequestAddress(LocalDefaultAddressSpaceId, poolId, "FD00:0:0:1:0::100", nil)
+	if err != nil || address != "fd00:0:0:1::100/64" {
+		t.Errorf("RequestAddress returned address:%v err:%v", address, err)
+	}
+
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, poolId, addr11.String(), nil)
+	if err != errInvalidAddress {
+		t.Errorf("RequestAddress accepted an IPv4 address from an IPv6 pool, err:%v", err)
+	}
+
+	// Addresses of the other family are released to their own pool.
+	v4Address, err := am.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", nil)
+	if err != nil {
+		t.Errorf("RequestAddress failed, err:%v", err)
+	}
+
+	err = am.ReleaseAddress(LocalDefaultAddressSpaceId, subnet2.String(), "fd00:0:0:1::4", nil)
+	if err != nil {
+		t.Errorf("ReleaseAddress failed, err:%v", err)
+	}
+
+	if ap.Addresses["fd00:0:0:1::4"].InUse {
+		t.Errorf("ReleaseAddress did not release the IPv6 address.")
+	}
+
+	if !ap.as.Pools[subnet2.String()].Addresses[addr21.String()].InUse {
+		t.Errorf("ReleaseAddress released %v.", v4Address)
+	}
+}
+
+// Tests excluded addresses are never handed out.
+func TestExcludedAddresses(t *testing.T) {
+	// Start with the test address space.
+	am, err := createAddressManager()
+	if err != nil {
+		t.Fatalf("createAddressManager failed, err:%+v.", err)
+	}
+
+	options := map[string]string{OptAddressExclusions: "10.0.1.0/31, 10.0.2.1-10.0.2.3"}
+
+	// Addr11 is excluded, so addr12 is the only address available in subnet1.
+	address, err := am.RequestAddress(LocalDefaultAddressSpaceId, subnet1.String(), "", options)
+	if err != nil || address != "10.0.1.2/24" {
+		t.Errorf("RequestAddress returned address:%v err:%v", address, err)
+	}
+
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", options)
+	if _, ok := err.(*PoolExhaustedError); !ok {
+		t.Errorf("RequestAddress allocated an excluded address, err:%v", err)
+	}
+
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), addr21.String(), options)
+	if err != errAddressExcluded {
+		t.Errorf("RequestAddress allocated a specifically requested excluded address, err:%v", err)
+	}
+
+	options[OptAddressExclusions] = "10.0.2.3-10.0.2.1"
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", options)
+	if err == nil {
+		t.Errorf("RequestAddress accepted an invalid exclusion range.")
+	}
+}
+
+// Tests addresses are force released regardless of their owner.
+func TestForceReleaseAddress(t *testing.T) {
+	// Start with the test address space.
+	am, err := createAddressManager()
+	if err != nil {
+		t.Fatalf("createAddressManager failed, err:%+v.", err)
+	}
+
+	options := map[string]string{OptAddressID: "endpoint1"}
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", options)
+	if err != nil {
+		t.Errorf("RequestAddress failed, err:%v", err)
+	}
+
+	addrs := am.GetAddresses()
+	if len(addrs) != 3 || addrs[2].Address != addr21.String() || addrs[2].ID != "endpoint1" {
+		t.Errorf("GetAddresses returned %+v.", addrs)
+	}
+
+	err = am.ForceReleaseAddress(addr21.String())
+	if err != nil {
+		t.Errorf("ForceReleaseAddress failed, err:%v", err)
+	}
+
+	err = am.ForceReleaseAddress(addr21.String())
+	if err != errAddressNotInUse {
+		t.Errorf("ForceReleaseAddress released a free address, err:%v", err)
+	}
+
+	// The address can be allocated to a different owner.
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", nil)
+	if err != nil {
+		t.Errorf("RequestAddress failed after force release, err:%v", err)
+	}
+}
+
+// Tests addresses are allocated from pools extending an exhausted pool.
+func TestExtensionPoolRequests(t *testing.T) {
+	// Start with the test address space.
+	am, err := createAddressManager()
+	if err != nil {
+		t.Fatalf("createAddressManager failed, err:%+v.", err)
+	}
+
+	poolId, _, err := am.RequestPool(LocalDefaultAddressSpaceId, subnet2.String(), "", nil, false)
+	if err != nil {
+		t.Fatalf("RequestPool failed, err:%v", err)
+	}
+
+	options := map[string]string{OptAddressPoolSpan: "true"}
+	_, err = am.RequestAddress(LocalDefaultAddressSpaceId, poolId, "", options)
+	if err != nil {
+		t.Errorf("RequestAddress failed, err:%v", err)
+	}
+
+	// Subnet2 is exhausted, so the address comes from subnet1 on the same interface.
+	address, err := am.RequestAddress(LocalDefaultAddressSpaceId, poolId, "", options)
+	if err != nil {
+		t.Fatalf("RequestAddress failed to allocate from an extension pool, err:%v", err)
+	}
+
+	ip, _, _ := net.ParseCIDR(address)
+	if !subnet1.Contains(ip) {
+		t.Errorf("RequestAddress returned address %v outside of the extension pool.", address)
+	}
+
+	// The extension pool is no longer available to other networks.
+	_, _, err = am.RequestPool(LocalDefaultAddressSpaceId, "", "", nil, false)
+	if err != errNoAvailableAddressPools {
+		t.Errorf("RequestPool returned an extension pool in use, err:%v", err)
+	}
+
+	// Addresses of the extension pool are released through the pool of the network.
+	err = am.ReleaseAddress(LocalDefaultAddressSpaceId, poolId, ip.String(), nil)
+	if err != nil {
+		t.Errorf("ReleaseAddress failed, err:%v", err)
+	}
+
+	err = am.ReleasePool(LocalDefaultAddressSpaceId, poolId)
+	if err != nil {
+		t.Errorf("ReleasePool failed, err:%v", err)
+	}
+
+	_, _, err = am.RequestPool(LocalDefaultAddressSpaceId, subnet1.String(), "", nil, false)
+	if err != nil {
+		t.Errorf("RequestPool failed after the extension pool was released, err:%v", err)
+	}
+
+	info, _ := am.GetPoolInfo(LocalDefaultAddressSpaceId, subnet1.String())
+	if info.Available != info.Capacity {
+		t.Errorf("Extension pool still has addresses allocated %+v.", info)
+	}
+}
+
+// Tests that the static source serves the address range of a statically configured subnet.
+func TestStaticSourceServesAddressRange(t *testing.T) {
+	var config common.PluginConfig
+
+	am, err := NewAddressManager()
+	if err != nil {
+		t.Fatalf("NewAddressManager failed, err:%v", err)
+	}
+
+	if err = am.Initialize(&config, nil); err != nil {
+		t.Fatalf("Initialize failed, err:%v", err)
+	}
+
+	// Sources are validated when started.
+	options := map[string]interface{}{
+		common.OptEnvironment: common.OptEnvironmentStatic,
+		common.OptIpamSubnet:  "10.0.9.0/24",
+		common.OptIpamGateway: "10.0.10.1",
+	}
+	if err = am.StartSource(options); err == nil {
+		t.Errorf("StartSource accepted a gateway outside of the subnet.")
+	}
+
+	options[common.OptIpamGateway] = "10.0.9.254"
+	options[common.OptIpamRangeStart] = "10.0.9.253"
+	options[common.OptIpamRangeEnd] = "10.0.9.255"
+	if err = am.StartSource(options); err != nil {
+		t.Fatalf("StartSource failed, err:%v", err)
+	}
+
+	// The pool is learned on the first request, and the gateway isn't handed out.
+	allocated := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		address, err := am.RequestAddress(LocalDefaultAddressSpaceId, "10.0.9.0/24", "", nil)
+		if err != nil {
+			t.Fatalf("RequestAddress failed, err:%v", err)
+		}
+		allocated[address] = true
+	}
+
+	if !allocated["10.0.9.253/24"] || !allocated["10.0.9.255/24"] {
+		t.Errorf("RequestAddress returned addresses %v outside of the range.", allocated)
+	}
+
+	if _, err = am.RequestAddress(LocalDefaultAddressSpaceId, "10.0.9.0/24", "", nil); err == nil {
+		t.Errorf("RequestAddress allocated an address from an exhausted range.")
+	}
+
+	info, err := am.GetPoolInfo(LocalDefaultAddressSpaceId, "10.0.9.0/24")
+	if err != nil || !info.Gateway.Equal(net.ParseIP("10.0.9.254")) {
+		t.Errorf("GetPoolInfo returned gateway %v, err:%v.", info, err)
+	}
+
+	am.StopSource()
+}
+
+// Tests that address managers sharing a store wait only for the pools they use.
+func TestAddressRequestsOfProcessesSharingStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipam")
+	if err != nil {
+		t.Fatalf("Failed to create directory, err:%v.", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv(store.LockTimeoutEnv, "300ms")
+	defer os.Unsetenv(store.LockTimeoutEnv)
+
+	fileName := filepath.Join(dir, "azure-vnet-ipam.json")
+
+	// The address space is learned by the first process and read by the second.
+	am1, _ := createSharedAddressManager(t, fileName)
+	if err = setupTestAddressSpace(am1); err != nil {
+		t.Fatalf("setupTestAddressSpace failed, err:%v.", err)
+	}
+
+	am2, kl2 := createSharedAddressManager(t, fileName)
+
+	// Requests for a pool held by another process wait for it, requests for other pools don't.
+	if err = kl2.LockKey(getLockKey(LocalDefaultAddressSpaceId, subnet2.String()), true); err != nil {
+		t.Fatalf("Failed to lock pool, err:%v.", err)
+	}
+
+	if _, err = am1.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", nil); err != store.ErrTimeoutLockingStore {
+		t.Errorf("RequestAddress from a locked pool returned err:%v.", err)
+	}
+
+	addr1, err := am1.RequestAddress(LocalDefaultAddressSpaceId, subnet1.String(), "", nil)
+	if err != nil {
+		t.Fatalf("RequestAddress failed while another pool is locked, err:%v.", err)
+	}
+
+	if err = kl2.UnlockKey(getLockKey(LocalDefaultAddressSpaceId, subnet2.String())); err != nil {
+		t.Fatalf("Failed to unlock pool, err:%v.", err)
+	}
+
+	// Each process sees the addresses allocated by the other.
+	addr2, err := am2.RequestAddress(LocalDefaultAddressSpaceId, subnet1.String(), "", nil)
+	if err != nil || addr2 == addr1 {
+		t.Fatalf("RequestAddress returned %v err:%v, expected an address other than %v.", addr2, err, addr1)
+	}
+
+	if _, err = am1.RequestAddress(LocalDefaultAddressSpaceId, subnet1.String(), "", nil); err == nil {
+		t.Errorf("RequestAddress allocated an address from a pool exhausted by another process.")
+	}
+
+	// Saves of a pool leave the pools saved by other processes as they were.
+	if _, err = am1.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", nil); err != nil {
+		t.Errorf("RequestAddress failed, err:%v.", err)
+	}
+
+	ip1, _, _ := net.ParseCIDR(addr1)
+	ip2, _, _ := net.ParseCIDR(addr2)
+	if err = am2.ReleaseAddress(LocalDefaultAddressSpaceId, subnet1.String(), ip2.String(), nil); err != nil {
+		t.Errorf("ReleaseAddress failed, err:%v.", err)
+	}
+
+	am3, _ := createSharedAddressManager(t, fileName)
+	inUse := make(map[string]bool)
+	for _, ai := range am3.GetAddresses() {
+		inUse[ai.Address] = ai.InUse
+	}
+
+	if len(inUse) != 3 || !inUse[ip1.String()] || inUse[ip2.String()] || !inUse[addr21.String()] {
+		t.Errorf("Saved addresses are %v, expected %v and %v in use.", inUse, ip1, addr21)
+	}
+
+	// Pools locked by other processes aren't chosen to extend an exhausted pool.
+	if err = kl2.LockKey(getLockKey(LocalDefaultAddressSpaceId, subnet1.String()), true); err != nil {
+		t.Fatalf("Failed to lock pool, err:%v.", err)
+	}
+
+	options := map[string]string{OptAddressPoolSpan: "true"}
+	if _, err = am3.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", options); err == nil {
+		t.Errorf("RequestAddress allocated an address from a pool locked by another process.")
+	}
+
+	kl2.UnlockKey(getLockKey(LocalDefaultAddressSpaceId, subnet1.String()))
+
+	address, err := am3.RequestAddress(LocalDefaultAddressSpaceId, subnet2.String(), "", options)
+	if ip, _, _ := net.ParseCIDR(address); err != nil || !ip.Equal(ip2) {
+		t.Errorf("RequestAddress returned %v err:%v, expected %v from the extension pool.", address, err, ip2)
+	}
+}
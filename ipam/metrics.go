@@ -0,0 +1,94 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// PoolMetrics contains utilization metrics of an address pool.
+type PoolMetrics struct {
+	AddressSpace       string
+	PoolId             string
+	IfName             string
+	IsIPv6             bool
+	Total              int
+	Allocated          int
+	Free               int
+	Unhealthy          int
+	AllocationFailures int
+}
+
+// Metrics contains utilization metrics of all address pools managed by AddressManager.
+type Metrics struct {
+	TimeStamp time.Time
+	Pools     []PoolMetrics
+}
+
+// Returns utilization metrics of all address pools.
+func (am *addressManager) getMetrics() *Metrics {
+	metrics := &Metrics{
+		TimeStamp: time.Now(),
+	}
+
+	for _, as := range am.AddrSpaces {
+		for _, ap := range as.Pools {
+			pm := PoolMetrics{
+				AddressSpace:       as.Id,
+				PoolId:             ap.Id,
+				IfName:             ap.IfName,
+				IsIPv6:             ap.IsIPv6,
+				Total:              len(ap.Addresses),
+				AllocationFailures: ap.AllocationFailures,
+			}
+
+			for _, ar := range ap.Addresses {
+				if ar.InUse {
+					pm.Allocated++
+				}
+				if ar.unhealthy {
+					pm.Unhealthy++
+				}
+			}
+
+			pm.Free = pm.Total - pm.Allocated
+			metrics.Pools = append(metrics.Pools, pm)
+		}
+	}
+
+	// Keep the output stable across writes.
+	sort.Slice(metrics.Pools, func(i, j int) bool {
+		if metrics.Pools[i].AddressSpace != metrics.Pools[j].AddressSpace {
+			return metrics.Pools[i].AddressSpace < metrics.Pools[j].AddressSpace
+		}
+		return metrics.Pools[i].PoolId < metrics.Pools[j].PoolId
+	})
+
+	return metrics
+}
+
+// Writes utilization metrics to the metrics file.
+func (am *addressManager) writeMetrics() error {
+	// Skip if a metrics file is not configured.
+	if am.metricsPath == "" {
+		return nil
+	}
+
+	buf, err := json.MarshalIndent(am.getMetrics(), "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(am.metricsPath, buf, 0644)
+	if err != nil {
+		log.Printf("[ipam] Failed to write metrics to %v, err:%v.", am.metricsPath, err)
+	}
+
+	return err
+}
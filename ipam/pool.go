@@ -4,8 +4,10 @@
 package ipam
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 
 	"github.com/Azure/azure-container-networking/log"
@@ -34,6 +36,14 @@ var (
 	dnsHostProxyAddress = net.ParseIP("168.63.129.16")
 )
 
+const (
+	// First host ID allocated from a delegated prefix, after the well-known host IDs.
+	delegatedFirstHostId = 4
+
+	// Maximum number of addresses allocated from a delegated prefix.
+	delegatedMaxAddresses = 65536
+)
+
 // Represents the key to an address pool.
 type addressPoolId struct {
 	AsId        string
@@ -51,17 +61,20 @@ type addressSpace struct {
 
 // Represents a subnet and the set of addresses in it.
 type addressPool struct {
-	as        *addressSpace
-	Id        string
-	IfName    string
-	Subnet    net.IPNet
-	Gateway   net.IP
-	Addresses map[string]*addressRecord
-	addrsByID map[string]*addressRecord
-	IsIPv6    bool
-	Priority  int
-	RefCount  int
-	epoch     int
+	as                 *addressSpace
+	Id                 string
+	IfName             string
+	Subnet             net.IPNet
+	Gateway            net.IP
+	Addresses          map[string]*addressRecord
+	addrsByID          map[string]*addressRecord
+	IsIPv6             bool
+	IsDelegated        bool
+	ExtendsPoolId      string
+	Priority           int
+	RefCount           int
+	AllocationFailures int
+	epoch              int
 }
 
 // AddressPoolInfo contains information about an address pool.
@@ -75,11 +88,23 @@ type AddressPoolInfo struct {
 	Capacity       int
 }
 
+// AddressInfo describes an address in a pool and its owner.
+type AddressInfo struct {
+	AddressSpace string
+	PoolId       string
+	Address      string
+	InUse        bool
+	ID           string
+	Sandbox      string
+	Unhealthy    bool
+}
+
 // Represents an IP address in a pool.
 type addressRecord struct {
 	ID        string
 	Addr      net.IP
 	InUse     bool
+	Sandbox   string
 	unhealthy bool
 	epoch     int
 }
@@ -155,6 +180,11 @@ func (am *addressManager) getAddressSpace(id string) (*addressSpace, error) {
 
 // Sets a new or updates an existing address space.
 func (am *addressManager) setAddressSpace(as *addressSpace) error {
+	// Remember the pools of the source, since merging consumes them.
+	for poolId := range as.Pools {
+		am.sourcePools[getLockKey(as.Id, poolId)] = true
+	}
+
 	as1, ok := am.AddrSpaces[as.Id]
 	if !ok {
 		am.AddrSpaces[as.Id] = as
@@ -191,6 +221,15 @@ func (as *addressSpace) merge(newas *addressSpace) {
 			pv.epoch = as.epoch
 		} else {
 			// This pool already exists.
+			// Addresses of a delegated prefix are generated locally,
+			// so they remain valid for as long as the prefix does.
+			if ap.IsDelegated {
+				ap.epoch = as.epoch
+				for _, ar := range ap.Addresses {
+					ar.epoch = as.epoch
+				}
+			}
+
 			// Compare address records one by one.
 			for ak, av := range pv.Addresses {
 				ar := ap.Addresses[ak]
@@ -284,6 +323,17 @@ func (as *addressSpace) getAddressPool(poolId string) (*addressPool, error) {
 	return ap, nil
 }
 
+// Returns the address pool containing the given address.
+func (as *addressSpace) getAddressPoolForAddress(addr net.IP) *addressPool {
+	for _, ap := range as.Pools {
+		if (addr.To4() == nil) == ap.IsIPv6 && ap.Subnet.Contains(addr) {
+			return ap
+		}
+	}
+
+	return nil
+}
+
 // Requests a new address pool from the address space.
 func (as *addressSpace) requestPool(poolId string, subPoolId string, options map[string]string, v6 bool) (*addressPool, error) {
 	var ap *addressPool
@@ -357,6 +407,61 @@ func (as *addressSpace) requestPool(poolId string, subPoolId string, options map
 	return ap, err
 }
 
+// Returns the pools that may extend the address space of the given pool, in order of preference.
+// Extension pools are other pools of the same address family on the same interface,
+// such as prefixes added to a subnet after the network was created.
+func (as *addressSpace) getExtensionPools(ap *addressPool) []*addressPool {
+	var candidates []*addressPool
+
+	for _, pool := range as.Pools {
+		if pool == ap || pool.IfName != ap.IfName || pool.IsIPv6 != ap.IsIPv6 {
+			continue
+		}
+
+		// Skip pools used by other networks.
+		if pool.ExtendsPoolId != ap.Id && pool.isInUse() {
+			continue
+		}
+
+		candidates = append(candidates, pool)
+	}
+
+	// Prefer pools already extending this pool, then pools with the highest priority.
+	sort.Slice(candidates, func(i, j int) bool {
+		ei, ej := candidates[i].ExtendsPoolId == ap.Id, candidates[j].ExtendsPoolId == ap.Id
+		if ei != ej {
+			return ei
+		}
+		if candidates[i].Priority != candidates[j].Priority {
+			return candidates[i].Priority > candidates[j].Priority
+		}
+		return candidates[i].Id < candidates[j].Id
+	})
+
+	return candidates
+}
+
+// Requests an address from the first of the given pools that has one available,
+// making it extend the address space of the given pool.
+func (as *addressSpace) requestExtensionAddress(ap *addressPool, pools []*addressPool, options map[string]string) (*addressPool, string, error) {
+	for _, pool := range pools {
+		addr, err := pool.requestAddress("", options)
+		if err != nil {
+			continue
+		}
+
+		if pool.ExtendsPoolId != ap.Id {
+			log.Printf("[ipam] Pool %v extends pool %v.", pool.Id, ap.Id)
+			pool.ExtendsPoolId = ap.Id
+			pool.RefCount++
+		}
+
+		return pool, addr, nil
+	}
+
+	return nil, "", errNoAvailableAddresses
+}
+
 // Releases a previously requested address pool back to its address space.
 func (as *addressSpace) releasePool(poolId string) error {
 	var err error
@@ -379,6 +484,17 @@ func (as *addressSpace) releasePool(poolId string) error {
 
 	ap.RefCount--
 
+	// Release the pools that extended this pool's address space.
+	if !ap.isInUse() {
+		for _, pool := range as.Pools {
+			if pool.ExtendsPoolId == poolId && pool.isInUse() {
+				log.Printf("[ipam] Releasing extension pool %v.", pool.Id)
+				pool.ExtendsPoolId = ""
+				pool.RefCount--
+			}
+		}
+	}
+
 	// Delete address pool if it is no longer available.
 	if ap.epoch < as.epoch && !ap.isInUse() {
 		log.Printf("[ipam] Deleting stale pool with poolId:%v.", poolId)
@@ -396,6 +512,8 @@ func (ap *addressPool) getInfo() *AddressPoolInfo {
 	var available int
 	var unhealthyAddrs []net.IP
 
+	capacity := len(ap.Addresses)
+
 	for _, ar := range ap.Addresses {
 		if !ar.InUse {
 			available++
@@ -405,6 +523,12 @@ func (ap *addressPool) getInfo() *AddressPoolInfo {
 		}
 	}
 
+	// Delegated prefixes also count the addresses not generated yet.
+	if ap.IsDelegated && capacity < delegatedMaxAddresses {
+		available += delegatedMaxAddresses - capacity
+		capacity = delegatedMaxAddresses
+	}
+
 	info := &AddressPoolInfo{
 		Subnet:         ap.Subnet,
 		Gateway:        ap.Gateway,
@@ -412,7 +536,7 @@ func (ap *addressPool) getInfo() *AddressPoolInfo {
 		UnhealthyAddrs: unhealthyAddrs,
 		IsIPv6:         ap.IsIPv6,
 		Available:      available,
-		Capacity:       len(ap.Addresses),
+		Capacity:       capacity,
 	}
 
 	return info
@@ -446,6 +570,38 @@ func (ap *addressPool) newAddressRecord(addr *net.IP) (*addressRecord, error) {
 	return ar, nil
 }
 
+// Creates a new addressRecord object for the next unused address of a delegated prefix.
+func (ap *addressPool) newDelegatedAddressRecord(excluded []addressRange) (*addressRecord, error) {
+	hostId := make(net.IP, net.IPv6len)
+
+	for i := delegatedFirstHostId; i < delegatedFirstHostId+delegatedMaxAddresses; i++ {
+		binary.BigEndian.PutUint32(hostId[net.IPv6len-4:], uint32(i))
+		addr := platform.GenerateAddress(&ap.Subnet, hostId)
+
+		if !ap.Subnet.Contains(addr) {
+			break
+		}
+
+		if _, ok := ap.Addresses[addr.String()]; ok || isAddressExcluded(excluded, addr) {
+			continue
+		}
+
+		return ap.newAddressRecord(&addr)
+	}
+
+	return nil, errNoAvailableAddresses
+}
+
+// Returns the canonical form of an address, or an error if it does not belong to the pool's address family.
+func (ap *addressPool) normalizeAddress(address string) (string, error) {
+	addr := net.ParseIP(address)
+	if addr == nil || (addr.To4() == nil) != ap.IsIPv6 {
+		return "", errInvalidAddress
+	}
+
+	return addr.String(), nil
+}
+
 // Requests a new address from the address pool.
 func (ap *addressPool) requestAddress(address string, options map[string]string) (string, error) {
 	var ar *addressRecord
@@ -456,9 +612,33 @@ func (ap *addressPool) requestAddress(address string, options map[string]string)
 	log.Printf("[ipam] Requesting address with address:%v options:%+v.", address, options)
 	defer func() { log.Printf("[ipam] Address request completed with address:%v err:%v.", addr, err) }()
 
+	// Addresses reserved by the operator are never handed out.
+	excluded, err := parseAddressRanges(options[OptAddressExclusions])
+	if err != nil {
+		return "", err
+	}
+
 	if address != "" {
 		// Return the specific address requested.
+		address, err = ap.normalizeAddress(address)
+		if err != nil {
+			return "", err
+		}
+
+		if isAddressExcluded(excluded, net.ParseIP(address)) {
+			err = errAddressExcluded
+			return "", err
+		}
+
 		ar = ap.Addresses[address]
+		if ar == nil && ap.IsDelegated && ap.Subnet.Contains(net.ParseIP(address)) {
+			// Any address in a delegated prefix can be requested.
+			addr := net.ParseIP(address)
+			ar, err = ap.newAddressRecord(&addr)
+			if err != nil {
+				return "", err
+			}
+		}
 		if ar == nil {
 			err = errAddressNotFound
 			return "", err
@@ -484,14 +664,23 @@ func (ap *addressPool) requestAddress(address string, options map[string]string)
 	// If no address was found, return any available address.
 	if ar == nil {
 		for _, ar = range ap.Addresses {
-			if !ar.InUse && ar.ID == "" {
+			if !ar.InUse && ar.ID == "" && !isAddressExcluded(excluded, ar.Addr) {
 				break
 			}
 			ar = nil
 		}
 
+		// Generate a new address if the pool is a delegated prefix.
+		if ar == nil && ap.IsDelegated {
+			ar, err = ap.newDelegatedAddressRecord(excluded)
+			if err != nil {
+				return "", err
+			}
+		}
+
 		if ar == nil {
-			return "", errNoAvailableAddresses
+			err = errNoAvailableAddresses
+			return "", err
 		}
 	}
 
@@ -500,6 +689,7 @@ func (ap *addressPool) requestAddress(address string, options map[string]string)
 		ar.ID = id
 	} else {
 		ar.InUse = true
+		ar.Sandbox = options[OptAddressSandbox]
 	}
 
 	// Return address in CIDR notation.
@@ -526,6 +716,10 @@ func (ap *addressPool) releaseAddress(address string, options map[string]string)
 
 	if address != "" {
 		// Release the specific address.
+		if addr := net.ParseIP(address); addr != nil {
+			address = addr.String()
+		}
+
 		ar = ap.Addresses[address]
 
 		// Release the pre-assigned gateway address.
@@ -552,6 +746,7 @@ func (ap *addressPool) releaseAddress(address string, options map[string]string)
 	}
 
 	ar.InUse = false
+	ar.Sandbox = ""
 
 	if id != "" && ar.ID == id {
 		delete(ap.addrsByID, ar.ID)
@@ -566,3 +761,62 @@ func (ap *addressPool) releaseAddress(address string, options map[string]string)
 
 	return nil
 }
+
+// Releases an address back to its address pool regardless of its owner.
+func (ap *addressPool) forceReleaseAddress(address string) error {
+	ar := ap.Addresses[address]
+	if ar == nil {
+		return errAddressNotFound
+	}
+
+	if !ar.InUse && ar.ID == "" {
+		return errAddressNotInUse
+	}
+
+	log.Printf("[ipam] Force releasing address %v owned by ID:%v sandbox:%v.", address, ar.ID, ar.Sandbox)
+
+	if ar.ID != "" {
+		delete(ap.addrsByID, ar.ID)
+		ar.ID = ""
+	}
+
+	ar.InUse = false
+	ar.Sandbox = ""
+
+	// Delete address record if it is no longer available.
+	if ar.epoch < ap.as.epoch {
+		delete(ap.Addresses, address)
+	}
+
+	return nil
+}
+
+// Releases in-use addresses whose sandbox no longer exists back to the address pool.
+func (ap *addressPool) releaseOrphanedAddresses(isSandboxAlive func(sandbox string) bool) []string {
+	var released []string
+
+	for ak, ar := range ap.Addresses {
+		// Addresses without a sandbox cannot be checked and are left alone.
+		if !ar.InUse || ar.Sandbox == "" || isSandboxAlive(ar.Sandbox) {
+			continue
+		}
+
+		log.Printf("[ipam] Releasing orphaned address %v of sandbox %v.", ar.Addr, ar.Sandbox)
+
+		ar.InUse = false
+		ar.Sandbox = ""
+		released = append(released, ak)
+
+		if ar.ID != "" {
+			delete(ap.addrsByID, ar.ID)
+			ar.ID = ""
+		}
+
+		// Delete address record if it is no longer available.
+		if ar.epoch < ap.as.epoch {
+			delete(ap.Addresses, ak)
+		}
+	}
+
+	return released
+}
@@ -0,0 +1,209 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Maximum number of addresses in a static address range.
+	staticMaxAddresses = 65536
+)
+
+// Static IPAM configuration source, serving a subnet supplied in the network configuration
+// instead of querying the host. Used outside of Azure, such as on-premises and in test environments.
+type staticSource struct {
+	name        string
+	sink        addressConfigSink
+	subnet      *net.IPNet
+	gateway     net.IP
+	rangeStart  net.IP
+	rangeEnd    net.IP
+	initialized bool
+}
+
+// Creates the static source.
+func newStaticSource(options map[string]interface{}) (*staticSource, error) {
+	s, _ := options[common.OptIpamSubnet].(string)
+	_, subnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid static subnet %q", s)
+	}
+
+	source := &staticSource{
+		name:   "Static",
+		subnet: subnet,
+	}
+
+	parse := func(option string) (net.IP, error) {
+		s, _ := options[option].(string)
+		if s == "" {
+			return nil, nil
+		}
+
+		address := net.ParseIP(s)
+		if address == nil || !subnet.Contains(address) {
+			return nil, fmt.Errorf("Invalid static %v %q for subnet %v", option, s, subnet)
+		}
+
+		return address, nil
+	}
+
+	if source.gateway, err = parse(common.OptIpamGateway); err != nil {
+		return nil, err
+	}
+
+	if source.rangeStart, err = parse(common.OptIpamRangeStart); err != nil {
+		return nil, err
+	}
+
+	if source.rangeEnd, err = parse(common.OptIpamRangeEnd); err != nil {
+		return nil, err
+	}
+
+	if source.rangeStart != nil && source.rangeEnd != nil &&
+		bytes.Compare(source.rangeStart.To16(), source.rangeEnd.To16()) > 0 {
+		return nil, fmt.Errorf("Static address range %v-%v is empty", source.rangeStart, source.rangeEnd)
+	}
+
+	return source, nil
+}
+
+// Starts the static source.
+func (s *staticSource) start(sink addressConfigSink) error {
+	s.sink = sink
+	return nil
+}
+
+// Stops the static source.
+func (s *staticSource) stop() {
+	s.sink = nil
+	return
+}
+
+// Refreshes configuration.
+func (s *staticSource) refresh() error {
+
+	// The configuration doesn't change, so initialize once.
+	if s.initialized {
+		return nil
+	}
+	s.initialized = true
+
+	// Configure the local default address space.
+	local, err := s.sink.newAddressSpace(LocalDefaultAddressSpaceId, LocalScope)
+	if err != nil {
+		return err
+	}
+
+	ap, err := local.newAddressPool(getStaticInterfaceName(s.subnet), 0, s.subnet)
+	if err != nil {
+		return err
+	}
+
+	if s.gateway != nil {
+		ap.Gateway = s.gateway
+	}
+
+	if ap.IsIPv6 && s.rangeStart == nil && s.rangeEnd == nil {
+		// Addresses of IPv6 subnets without a range are allocated on demand.
+		ap.IsDelegated = true
+	} else {
+		first, last := s.getRange()
+
+		count := 0
+		for address := first; bytes.Compare(address, last) <= 0; address = nextAddress(address) {
+			if address.Equal(ap.Gateway) {
+				continue
+			}
+
+			if count++; count > staticMaxAddresses {
+				return fmt.Errorf("Static address range %v-%v exceeds %d addresses", first, last, staticMaxAddresses)
+			}
+
+			addr := make(net.IP, len(address))
+			copy(addr, address)
+			if _, err = ap.newAddressRecord(&addr); err != nil {
+				log.Printf("[ipam] Failed to create address:%v err:%v.", addr, err)
+			}
+		}
+	}
+
+	// Set the local address space as active.
+	s.sink.setAddressSpace(local)
+
+	return nil
+}
+
+// getRange returns the first and last address served, defaulting to the host addresses of the subnet.
+func (s *staticSource) getRange() (net.IP, net.IP) {
+	first := s.rangeStart.To16()
+	if first == nil {
+		first = nextAddress(s.subnet.IP.To16())
+	}
+
+	last := s.rangeEnd.To16()
+	if last == nil {
+		last = make(net.IP, net.IPv6len)
+		network := s.subnet.IP.To16()
+		mask := s.subnet.Mask
+		if len(mask) == net.IPv4len {
+			mask = append(net.CIDRMask(96, 128)[:12], mask...)
+		}
+		for i := range last {
+			last[i] = network[i] | ^mask[i]
+		}
+
+		// The broadcast address of IPv4 subnets isn't a host address.
+		if s.subnet.IP.To4() != nil {
+			last[len(last)-1]--
+		}
+	}
+
+	return first, last
+}
+
+// nextAddress returns the address following an address.
+func nextAddress(address net.IP) net.IP {
+	next := make(net.IP, len(address))
+	copy(next, address)
+
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
+
+// getStaticInterfaceName returns the name of the local interface with an address in a subnet, if any.
+func getStaticInterfaceName(subnet *net.IPNet) string {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && subnet.Contains(ipNet.IP) {
+				return iface.Name
+			}
+		}
+	}
+
+	return ""
+}
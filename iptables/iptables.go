@@ -0,0 +1,247 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package iptables
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Iptables backends.
+	BackendLegacy = "legacy"
+	BackendNft    = "nft"
+
+	// Iptables tables.
+	Filter = "filter"
+	Nat    = "nat"
+	Mangle = "mangle"
+)
+
+// Client programs iptables rules through the tooling of a backend.
+// The same rules can be programmed with any backend, so callers don't depend on the host's backend.
+type Client interface {
+	// Backend returns the name of the backend of the client.
+	Backend() string
+	// Run runs an iptables command on a table. Failed commands return an *exec.ExitError.
+	Run(table string, args ...string) ([]byte, error)
+	// Exists checks if a rule exists in a chain.
+	Exists(table string, chain string, spec ...string) bool
+	// Append appends a rule to a chain.
+	Append(table string, chain string, spec ...string) error
+	// Insert inserts a rule in a chain at the given position, starting at 1.
+	Insert(table string, chain string, pos int, spec ...string) error
+	// Delete deletes a rule from a chain.
+	Delete(table string, chain string, spec ...string) error
+	// Save returns the rules of a table, or of all tables if table is empty, in iptables-save format.
+	Save(table string) ([]byte, error)
+	// SaveCounters returns the rules of a table like Save, each prefixed with its [packets:bytes] counters.
+	SaveCounters(table string) ([]byte, error)
+	// Restore replaces the tables in the iptables-save formatted input.
+	Restore(input io.Reader) error
+	// RestoreNoFlush atomically replaces the chains declared in the iptables-save formatted input,
+	// leaving the other chains of their tables as they are.
+	RestoreNoFlush(input io.Reader) error
+}
+
+// cmdClient is a client running the iptables commands of a backend.
+type cmdClient struct {
+	backend    string
+	iptables   string
+	saveCmd    string
+	restoreCmd string
+}
+
+var (
+	client     Client
+	clientOnce sync.Once
+
+	ipv6Client     Client
+	ipv6ClientOnce sync.Once
+)
+
+// GetClient returns the client of the host's iptables backend. The backend is detected on first use.
+func GetClient() Client {
+	clientOnce.Do(func() {
+		client = detectClient()
+		log.Printf("[iptables] Using %s iptables backend.", client.Backend())
+	})
+
+	return client
+}
+
+// GetIPv6Client returns the ip6tables client of the same backend as the iptables client.
+func GetIPv6Client() Client {
+	ipv6ClientOnce.Do(func() {
+		ipv6Client = newIPv6Client(GetClient())
+	})
+
+	return ipv6Client
+}
+
+// newIPv6Client returns the client of the ip6tables binary matching the binary of an iptables client,
+// e.g. ip6tables-nft for iptables-nft.
+func newIPv6Client(c Client) Client {
+	iptables := "iptables"
+	if cmd, ok := c.(*cmdClient); ok {
+		iptables = cmd.iptables
+	}
+
+	return newCmdClient(c.Backend(), "ip6"+strings.TrimPrefix(iptables, "ip"))
+}
+
+// newCmdClient creates a client running the given iptables binary and its save and restore commands.
+func newCmdClient(backend string, iptables string) *cmdClient {
+	return &cmdClient{
+		backend:    backend,
+		iptables:   iptables,
+		saveCmd:    iptables + "-save",
+		restoreCmd: iptables + "-restore",
+	}
+}
+
+// detectClient returns the client of the backend the host's rules are programmed with.
+// Distros shipping both backends may have kubelet and other components on either of them,
+// so the backend already holding the most rules is picked, as rules of both backends apply.
+func detectClient() Client {
+	_, legacyErr := exec.LookPath("iptables-legacy")
+	_, nftErr := exec.LookPath("iptables-nft")
+
+	if legacyErr == nil && nftErr == nil {
+		legacySave, _ := exec.Command("iptables-legacy-save").Output()
+		nftSave, _ := exec.Command("iptables-nft-save").Output()
+
+		if countRules(string(nftSave)) > countRules(string(legacySave)) {
+			return newCmdClient(BackendNft, "iptables-nft")
+		}
+
+		if countRules(string(legacySave)) > 0 {
+			return newCmdClient(BackendLegacy, "iptables-legacy")
+		}
+	}
+
+	// Otherwise use the backend of the default iptables binary.
+	version, _ := exec.Command("iptables", "--version").Output()
+
+	return newCmdClient(parseBackend(string(version)), "iptables")
+}
+
+// countRules returns the number of rules in iptables-save output.
+func countRules(save string) int {
+	count := 0
+	for _, line := range strings.Split(save, "\n") {
+		if strings.HasPrefix(line, "-A ") {
+			count++
+		}
+	}
+
+	return count
+}
+
+// parseBackend returns the backend in iptables --version output, e.g. "iptables v1.8.7 (nf_tables)".
+// Versions older than 1.8 only have the legacy backend and don't report it.
+func parseBackend(version string) string {
+	if strings.Contains(version, "nf_tables") {
+		return BackendNft
+	}
+
+	return BackendLegacy
+}
+
+// Backend returns the name of the backend of the client.
+func (c *cmdClient) Backend() string {
+	return c.backend
+}
+
+// Run runs an iptables command on a table.
+func (c *cmdClient) Run(table string, args ...string) ([]byte, error) {
+	// Wait for the xtables lock instead of failing if another process holds it.
+	cmdArgs := append([]string{"-w", "-t", table}, args...)
+	log.Debugf("[iptables] %s %s", c.iptables, strings.Join(cmdArgs, " "))
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(c.iptables, cmdArgs...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		log.Debugf("[iptables] %s", stderr.String())
+	}
+
+	return out, err
+}
+
+// Exists checks if a rule exists in a chain.
+func (c *cmdClient) Exists(table string, chain string, spec ...string) bool {
+	_, err := c.Run(table, append([]string{"-C", chain}, spec...)...)
+	return err == nil
+}
+
+// Append appends a rule to a chain.
+func (c *cmdClient) Append(table string, chain string, spec ...string) error {
+	return c.change(table, "-A", chain, spec)
+}
+
+// Insert inserts a rule in a chain at the given position.
+func (c *cmdClient) Insert(table string, chain string, pos int, spec ...string) error {
+	return c.change(table, "-I", chain, append([]string{strconv.Itoa(pos)}, spec...))
+}
+
+// Delete deletes a rule from a chain.
+func (c *cmdClient) Delete(table string, chain string, spec ...string) error {
+	return c.change(table, "-D", chain, spec)
+}
+
+// change runs a command changing the rules of a chain.
+func (c *cmdClient) change(table string, op string, chain string, args []string) error {
+	if _, err := c.Run(table, append([]string{op, chain}, args...)...); err != nil {
+		return fmt.Errorf("%s %s %s %s failed: %v", c.iptables, op, chain, strings.Join(args, " "), err)
+	}
+
+	return nil
+}
+
+// Save returns the rules of a table in iptables-save format.
+func (c *cmdClient) Save(table string) ([]byte, error) {
+	var args []string
+	if table != "" {
+		args = []string{"-t", table}
+	}
+
+	return exec.Command(c.saveCmd, args...).Output()
+}
+
+// SaveCounters returns the rules of a table in iptables-save format, with their packet and byte counters.
+func (c *cmdClient) SaveCounters(table string) ([]byte, error) {
+	return exec.Command(c.saveCmd, "-c", "-t", table).Output()
+}
+
+// Restore replaces the tables in the iptables-save formatted input.
+func (c *cmdClient) Restore(input io.Reader) error {
+	return c.restore(input)
+}
+
+// RestoreNoFlush replaces the chains declared in the iptables-save formatted input in a single transaction.
+func (c *cmdClient) RestoreNoFlush(input io.Reader) error {
+	return c.restore(input, "--noflush")
+}
+
+// restore runs the restore command of the backend on the input.
+func (c *cmdClient) restore(input io.Reader, args ...string) error {
+	cmd := exec.Command(c.restoreCmd, args...)
+	cmd.Stdin = input
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v, %s", c.restoreCmd, err, out)
+	}
+
+	return nil
+}
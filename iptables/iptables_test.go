@@ -0,0 +1,53 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package iptables
+
+import (
+	"testing"
+)
+
+func TestParseBackend(t *testing.T) {
+	versions := map[string]string{
+		"iptables v1.8.7 (nf_tables)\n": BackendNft,
+		"iptables v1.8.4 (legacy)\n":    BackendLegacy,
+		"iptables v1.6.1\n":             BackendLegacy,
+		"":                              BackendLegacy,
+	}
+
+	for version, backend := range versions {
+		if parseBackend(version) != backend {
+			t.Errorf("Unexpected backend %s for version %q", parseBackend(version), version)
+		}
+	}
+}
+
+func TestCountRules(t *testing.T) {
+	save := `# Generated by iptables-save v1.8.4 on Mon Jan  7 10:00:00 2019
+*nat
+:PREROUTING ACCEPT [0:0]
+:KUBE-SERVICES - [0:0]
+-A PREROUTING -m comment --comment "kubernetes service portals" -j KUBE-SERVICES
+-A POSTROUTING -s 10.240.0.0/16 -j MASQUERADE
+COMMIT
+`
+
+	if count := countRules(save); count != 2 {
+		t.Errorf("Unexpected number of rules %d", count)
+	}
+}
+
+func TestNewIPv6Client(t *testing.T) {
+	clients := map[string]string{
+		"iptables":        "ip6tables",
+		"iptables-legacy": "ip6tables-legacy",
+		"iptables-nft":    "ip6tables-nft",
+	}
+
+	for iptables, ip6tables := range clients {
+		c := newIPv6Client(newCmdClient(BackendNft, iptables)).(*cmdClient)
+		if c.iptables != ip6tables || c.saveCmd != ip6tables+"-save" || c.Backend() != BackendNft {
+			t.Errorf("Unexpected IPv6 client %+v of %s", c, iptables)
+		}
+	}
+}
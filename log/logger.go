@@ -4,12 +4,15 @@
 package log
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Log level
@@ -36,6 +39,9 @@ const (
 	logFileExtension = ".log"
 	logFilePerm      = os.FileMode(0664)
 
+	// Extension of compressed rotated log files.
+	compressedFileExtension = ".gz"
+
 	// Log file rotation default limits, in bytes.
 	maxLogFileSize   = 5 * 1024 * 1024
 	maxLogFileCount  = 8
@@ -49,8 +55,11 @@ type Logger struct {
 	name         string
 	level        int
 	target       int
+	format       int
 	maxFileSize  int
 	maxFileCount int
+	maxFileAge   time.Duration
+	compress     bool
 	callCount    int
 	directory    string
 	reports      chan interface{}
@@ -70,6 +79,10 @@ func NewLogger(name string, level int, target int) *Logger {
 	logger.directory = ""
 	logger.mutex = &sync.Mutex{}
 
+	if strings.EqualFold(os.Getenv(LogFormatEnv), "json") {
+		logger.SetFormat(FormatJSON)
+	}
+
 	return &logger
 }
 
@@ -89,6 +102,13 @@ func (logger *Logger) SetLogFileLimits(maxFileSize int, maxFileCount int) {
 	logger.maxFileCount = maxFileCount
 }
 
+// SetLogFileRetention sets the maximum age of rotated log files, unlimited if zero,
+// and whether they are compressed.
+func (logger *Logger) SetLogFileRetention(maxFileAge time.Duration, compress bool) {
+	logger.maxFileAge = maxFileAge
+	logger.compress = compress
+}
+
 // SetChannel sets the channel for error message reports.
 func (logger *Logger) SetChannel(reports chan interface{}) {
 	logger.reports = reports
@@ -129,39 +149,111 @@ func (logger *Logger) getLogFileName() string {
 }
 
 // Rotate checks the active log file size and rotates log files if necessary.
+// The caller must hold the mutex.
 func (logger *Logger) rotate() {
 	// Return if target is not a log file.
-	if logger.target != TargetLogfile || logger.out == nil {
+	if (logger.target != TargetLogfile && logger.target != TargetStdOutAndLogFile) || logger.out == nil {
 		return
 	}
 
 	fileName := logger.getLogFileName()
 	fileInfo, err := os.Stat(fileName)
 	if err != nil {
-		logger.Printf("[log] Failed to query log file info %+v.", err)
+		logger.l.Printf("[log] Failed to query log file info %+v.", err)
 		return
 	}
 
 	// Rotate if size limit is reached.
 	if fileInfo.Size() >= int64(logger.maxFileSize) {
 		logger.out.Close()
-		var fn1, fn2 string
 
 		// Rotate log files, keeping the last maxFileCount files.
-		for n := logger.maxFileCount - 1; n >= 0; n-- {
-			fn2 = fn1
-			if n == 0 {
-				fn1 = fileName
-			} else {
-				fn1 = fmt.Sprintf("%v.%v", fileName, n)
-			}
-			if fn2 != "" {
-				os.Rename(fn1, fn2)
-			}
+		// Rotated files are either plain or compressed.
+		last := fmt.Sprintf("%v.%v", fileName, logger.maxFileCount-1)
+		os.Remove(last)
+		os.Remove(last + compressedFileExtension)
+
+		for n := logger.maxFileCount - 2; n > 0; n-- {
+			fn1 := fmt.Sprintf("%v.%v", fileName, n)
+			fn2 := fmt.Sprintf("%v.%v", fileName, n+1)
+			os.Rename(fn1, fn2)
+			os.Rename(fn1+compressedFileExtension, fn2+compressedFileExtension)
+		}
+
+		rotatedFileName := fileName + ".1"
+		if logger.maxFileCount > 1 {
+			os.Rename(fileName, rotatedFileName)
+		} else {
+			os.Remove(fileName)
 		}
 
 		// Create a new log file.
-		logger.SetTarget(TargetLogfile)
+		logger.SetTarget(logger.target)
+
+		if logger.compress && logger.maxFileCount > 1 {
+			if err := compressLogFile(rotatedFileName); err != nil {
+				logger.l.Printf("[log] Failed to compress log file %s, err:%v.", rotatedFileName, err)
+			}
+		}
+
+		if logger.maxFileAge > 0 {
+			logger.removeExpiredLogFiles(fileName)
+		}
+	}
+}
+
+// compressLogFile replaces a log file by its gzip compressed copy, keeping its modification time.
+func compressLogFile(fileName string) error {
+	fileInfo, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	compressedFileName := fileName + compressedFileExtension
+	dst, err := os.OpenFile(compressedFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, logFilePerm)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(compressedFileName)
+		return err
+	}
+
+	os.Chtimes(compressedFileName, fileInfo.ModTime(), fileInfo.ModTime())
+	src.Close()
+
+	return os.Remove(fileName)
+}
+
+// removeExpiredLogFiles removes the rotated log files older than the maximum age.
+func (logger *Logger) removeExpiredLogFiles(fileName string) {
+	expiry := time.Now().Add(-logger.maxFileAge)
+
+	for n := 1; n < logger.maxFileCount; n++ {
+		for _, fn := range []string{
+			fmt.Sprintf("%v.%v", fileName, n),
+			fmt.Sprintf("%v.%v%v", fileName, n, compressedFileExtension),
+		} {
+			if fileInfo, err := os.Stat(fn); err == nil && fileInfo.ModTime().Before(expiry) {
+				os.Remove(fn)
+			}
+		}
 	}
 }
 
@@ -178,8 +270,10 @@ func (logger *Logger) Request(tag string, request interface{}, err error) {
 func (logger *Logger) Response(tag string, response interface{}, returnCode int, returnStr string, err error) {
 	if err == nil && returnCode == 0 {
 		logger.Printf("[%s] Sent %T %+v.", tag, response, response)
-	} else {
+	} else if err != nil {
 		logger.Errorf("[%s] Code:%s, %+v %s.", tag, returnStr, response, err.Error())
+	} else {
+		logger.Errorf("[%s] Code:%s, %+v.", tag, returnStr, response)
 	}
 }
 
@@ -197,7 +291,7 @@ func (logger *Logger) logf(format string, args ...interface{}) {
 func (logger *Logger) Printf(format string, args ...interface{}) {
 	if logger.level >= LevelInfo {
 		logger.mutex.Lock()
-		logger.logf(format, args...)
+		logger.logMessage(LevelInfo, format, args...)
 		logger.mutex.Unlock()
 	}
 }
@@ -206,14 +300,18 @@ func (logger *Logger) Printf(format string, args ...interface{}) {
 func (logger *Logger) Debugf(format string, args ...interface{}) {
 	if logger.level >= LevelDebug {
 		logger.mutex.Lock()
-		logger.logf(format, args...)
+		logger.logMessage(LevelDebug, format, args...)
 		logger.mutex.Unlock()
 	}
 }
 
-// Errorf logs a formatted string at info level and sends the string to TelemetryBuffer.
+// Errorf logs a formatted string as an error at info level and sends the string to TelemetryBuffer.
 func (logger *Logger) Errorf(format string, args ...interface{}) {
-	logger.Printf(format, args...)
+	if logger.level >= LevelInfo {
+		logger.mutex.Lock()
+		logger.logMessage(LevelError, format, args...)
+		logger.mutex.Unlock()
+	}
 	go func() {
 		logger.reports <- fmt.Sprintf(format, args...)
 	}()
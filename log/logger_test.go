@@ -4,8 +4,15 @@
 package log
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -48,3 +55,108 @@ func TestLogFileRotatesWhenSizeLimitIsReached(t *testing.T) {
 	}
 	os.Remove(fn)
 }
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+// Tests that printf-style and structured messages are logged as JSON entries.
+func TestJSONFormat(t *testing.T) {
+	l := NewLogger(logName, LevelInfo, TargetStderr)
+	out := &bufferCloser{}
+	l.out = out
+	l.l.SetOutput(out)
+	l.SetFormat(FormatJSON)
+
+	l.Printf("[cni-net] Processing ADD command with args %v.", "eth0")
+	l.Component("cns").With("nc", "nc1").Info("Created network container", "vlan", 2)
+	l.Component("cns").Debug("Not logged at info level")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Unexpected log entries %q.", lines)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Failed to decode log entry %q, err:%v.", lines[0], err)
+	}
+
+	if entry["level"] != "info" || entry["component"] != "cni-net" ||
+		entry["msg"] != "Processing ADD command with args eth0." {
+		t.Errorf("Unexpected log entry %+v.", entry)
+	}
+
+	entry = nil
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatalf("Failed to decode log entry %q, err:%v.", lines[1], err)
+	}
+
+	if entry["component"] != "cns" || entry["nc"] != "nc1" || entry["vlan"] != float64(2) {
+		t.Errorf("Unexpected log entry %+v.", entry)
+	}
+}
+
+// Tests that rotated log files are compressed and expired ones removed.
+func TestRotatedLogFilesAreCompressed(t *testing.T) {
+	l := NewLogger(logName, LevelInfo, TargetLogfile)
+	if l == nil {
+		t.Fatalf("Failed to create logger.\n")
+	}
+
+	l.SetLogFileLimits(512, 8)
+	l.SetLogFileRetention(time.Hour, true)
+
+	fn := l.GetLogDirectory() + logName + ".log"
+	defer os.Remove(fn)
+	for n := 1; n < 8; n++ {
+		defer os.Remove(fmt.Sprintf("%s.%d.gz", fn, n))
+	}
+
+	for i := 1; i <= 100; i++ {
+		l.Printf("LogText %v", i)
+	}
+
+	if _, err := os.Stat(fn + ".1"); err == nil {
+		t.Errorf("Found uncompressed rotated log file.")
+	}
+
+	f, err := os.Open(fn + ".1.gz")
+	if err != nil {
+		t.Fatalf("Failed to find the 1st compressed log file.")
+	}
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Failed to decompress log file, err:%v.", err)
+	}
+
+	b, _ := ioutil.ReadAll(zr)
+	f.Close()
+	if !strings.Contains(string(b), "LogText") {
+		t.Errorf("Unexpected compressed log file content %q.", b)
+	}
+
+	// Rotated files older than the maximum age are removed on the next rotation.
+	old := time.Now().Add(-2 * time.Hour)
+	for n := 1; n < 8; n++ {
+		os.Chtimes(fmt.Sprintf("%s.%d.gz", fn, n), old, old)
+	}
+
+	for i := 1; i <= 50; i++ {
+		l.Printf("LogText %v", i)
+	}
+
+	l.Close()
+
+	for n := 1; n < 8; n++ {
+		rotated := fmt.Sprintf("%s.%d.gz", fn, n)
+		if fileInfo, err := os.Stat(rotated); err == nil && !fileInfo.ModTime().After(old) {
+			t.Errorf("Found expired log file %s.", rotated)
+		}
+	}
+}
@@ -3,6 +3,8 @@
 
 package log
 
+import "time"
+
 // Standard logger is a pre-defined logger for convenience.
 var stdLog = NewLogger("azure-container-networking", LevelInfo, TargetStderr)
 
@@ -23,10 +25,18 @@ func SetLevel(level int) {
 	stdLog.SetLevel(level)
 }
 
+func SetFormat(format int) {
+	stdLog.SetFormat(format)
+}
+
 func SetLogFileLimits(maxFileSize int, maxFileCount int) {
 	stdLog.SetLogFileLimits(maxFileSize, maxFileCount)
 }
 
+func SetLogFileRetention(maxFileAge time.Duration, compress bool) {
+	stdLog.SetLogFileRetention(maxFileAge, compress)
+}
+
 func Close() {
 	stdLog.Close()
 }
@@ -58,3 +68,11 @@ func Debugf(format string, args ...interface{}) {
 func Errorf(format string, args ...interface{}) {
 	stdLog.Errorf(format, args...)
 }
+
+func Log(level int, component string, message string, fields Fields) {
+	stdLog.Log(level, component, message, fields)
+}
+
+func Component(name string) *ComponentLogger {
+	return stdLog.Component(name)
+}
@@ -0,0 +1,209 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Log format
+const (
+	FormatText = iota
+	FormatJSON
+)
+
+const (
+	// LogFormatEnv is the environment variable selecting the log format of a process, "text" or "json".
+	LogFormatEnv = "ACN_LOG_FORMAT"
+
+	// Keys of the standard fields of JSON log entries.
+	timeKey      = "time"
+	levelKey     = "level"
+	componentKey = "component"
+	messageKey   = "msg"
+)
+
+// Fields are key/value pairs attached to a structured log entry.
+type Fields map[string]interface{}
+
+var levelNames = map[int]string{
+	LevelAlert:   "alert",
+	LevelError:   "error",
+	LevelWarning: "warning",
+	LevelInfo:    "info",
+	LevelDebug:   "debug",
+}
+
+// ComponentLogger logs structured entries of a component.
+type ComponentLogger struct {
+	logger    *Logger
+	component string
+	fields    Fields
+}
+
+// SetFormat sets the log format.
+func (logger *Logger) SetFormat(format int) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	logger.format = format
+	if format == FormatJSON {
+		logger.l.SetFlags(0)
+	} else {
+		logger.l.SetFlags(log.LstdFlags)
+	}
+}
+
+// Log logs a message of a component at the given level with structured fields.
+// Entries at error level or above are sent to TelemetryBuffer.
+func (logger *Logger) Log(level int, component string, message string, fields Fields) {
+	if logger.level < level {
+		return
+	}
+
+	logger.mutex.Lock()
+	logger.logf("%s", logger.formatEntry(level, component, message, fields))
+	logger.mutex.Unlock()
+
+	if level <= LevelError && logger.reports != nil {
+		go func() {
+			logger.reports <- fmt.Sprintf("[%s] %s", component, message)
+		}()
+	}
+}
+
+// logMessage logs a printf-style message. The tag the message starts with, if any, is used
+// as the component of JSON entries. The caller must hold the mutex.
+func (logger *Logger) logMessage(level int, format string, args ...interface{}) {
+	if logger.format != FormatJSON {
+		logger.logf(format, args...)
+		return
+	}
+
+	component, message := splitComponent(fmt.Sprintf(format, args...))
+	logger.logf("%s", logger.formatEntry(level, component, message, nil))
+}
+
+// formatEntry returns the text of a log entry in the log format.
+func (logger *Logger) formatEntry(level int, component string, message string, fields Fields) string {
+	if logger.format == FormatJSON {
+		entry := make(map[string]interface{}, len(fields)+4)
+		for key, value := range fields {
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			entry[key] = value
+		}
+
+		entry[timeKey] = time.Now().UTC().Format(time.RFC3339Nano)
+		entry[levelKey] = levelNames[level]
+		entry[messageKey] = message
+		if component != "" {
+			entry[componentKey] = component
+		}
+
+		b, err := json.Marshal(entry)
+		if err == nil {
+			return string(b)
+		}
+
+		// Fall back to the message alone if a field can't be encoded.
+		b, _ = json.Marshal(map[string]interface{}{
+			timeKey:    entry[timeKey],
+			levelKey:   entry[levelKey],
+			messageKey: fmt.Sprintf("%s (fields: %v)", message, err),
+		})
+		return string(b)
+	}
+
+	var text strings.Builder
+	if component != "" {
+		fmt.Fprintf(&text, "[%s] ", component)
+	}
+	text.WriteString(message)
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(&text, " %s=%v", key, fields[key])
+	}
+
+	return text.String()
+}
+
+// splitComponent splits a message starting with a [component] tag.
+func splitComponent(message string) (string, string) {
+	if !strings.HasPrefix(message, "[") {
+		return "", message
+	}
+
+	end := strings.Index(message, "]")
+	if end < 0 {
+		return "", message
+	}
+
+	return message[1:end], strings.TrimSpace(message[end+1:])
+}
+
+// toFields converts alternating keys and values to fields.
+func toFields(fields Fields, keysAndValues []interface{}) Fields {
+	result := make(Fields, len(fields)+len(keysAndValues)/2)
+	for key, value := range fields {
+		result[key] = value
+	}
+
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		if i+1 < len(keysAndValues) {
+			result[key] = keysAndValues[i+1]
+		} else {
+			result[key] = nil
+		}
+	}
+
+	return result
+}
+
+// Component returns a logger of structured entries of a component.
+func (logger *Logger) Component(name string) *ComponentLogger {
+	return &ComponentLogger{logger: logger, component: name}
+}
+
+// With returns a logger adding the given alternating keys and values to all entries.
+func (cl *ComponentLogger) With(keysAndValues ...interface{}) *ComponentLogger {
+	return &ComponentLogger{
+		logger:    cl.logger,
+		component: cl.component,
+		fields:    toFields(cl.fields, keysAndValues),
+	}
+}
+
+// Debug logs a message with alternating keys and values at debug level.
+func (cl *ComponentLogger) Debug(message string, keysAndValues ...interface{}) {
+	cl.logger.Log(LevelDebug, cl.component, message, toFields(cl.fields, keysAndValues))
+}
+
+// Info logs a message with alternating keys and values at info level.
+func (cl *ComponentLogger) Info(message string, keysAndValues ...interface{}) {
+	cl.logger.Log(LevelInfo, cl.component, message, toFields(cl.fields, keysAndValues))
+}
+
+// Warn logs a message with alternating keys and values at warning level.
+func (cl *ComponentLogger) Warn(message string, keysAndValues ...interface{}) {
+	cl.logger.Log(LevelWarning, cl.component, message, toFields(cl.fields, keysAndValues))
+}
+
+// Error logs a message with alternating keys and values at error level.
+func (cl *ComponentLogger) Error(message string, keysAndValues ...interface{}) {
+	cl.logger.Log(LevelError, cl.component, message, toFields(cl.fields, keysAndValues))
+}
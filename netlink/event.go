@@ -0,0 +1,171 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build linux
+
+package netlink
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/Azure/azure-container-networking/log"
+	"golang.org/x/sys/unix"
+)
+
+// Interval at which a subscription checks whether it was cancelled.
+const subscriptionPollSeconds = 1
+
+// LinkEvent is a change of a network interface reported by the kernel.
+type LinkEvent struct {
+	// Type is RTM_NEWLINK if the interface was added or changed, RTM_DELLINK if it was deleted.
+	Type        int
+	Index       int
+	Name        string
+	Flags       uint32
+	OperState   uint8
+	MasterIndex int
+}
+
+// IsUp checks if the interface is administratively up and has a carrier.
+func (event *LinkEvent) IsUp() bool {
+	return event.Type == unix.RTM_NEWLINK &&
+		event.Flags&unix.IFF_UP != 0 &&
+		event.Flags&unix.IFF_LOWER_UP != 0
+}
+
+// IsDeleted checks if the interface was deleted.
+func (event *LinkEvent) IsDeleted() bool {
+	return event.Type == unix.RTM_DELLINK
+}
+
+// Creates a netlink socket receiving the messages of the given multicast groups.
+func newSubscriptionSocket(groups uint32) (*socket, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &socket{fd: fd}
+	s.sa.Family = unix.AF_NETLINK
+	s.sa.Groups = groups
+
+	if err = unix.Bind(fd, &s.sa); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	// Wake up periodically so that the subscription can be cancelled.
+	tv := unix.Timeval{Sec: subscriptionPollSeconds}
+	if err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// deserializeLinkEvent decodes a link message into a LinkEvent struct.
+func deserializeLinkEvent(nlMsg *syscall.NetlinkMessage) (*LinkEvent, error) {
+	if len(nlMsg.Data) < unix.SizeofIfInfomsg {
+		return nil, unix.EINVAL
+	}
+
+	ifInfo := (*unix.IfInfomsg)(unsafe.Pointer(&nlMsg.Data[0:unix.SizeofIfInfomsg][0]))
+
+	event := &LinkEvent{
+		Type:  int(nlMsg.Header.Type),
+		Index: int(ifInfo.Index),
+		Flags: ifInfo.Flags,
+	}
+
+	attrs, err := syscall.ParseNetlinkRouteAttr(nlMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case unix.IFLA_IFNAME:
+			// The name is zero terminated.
+			name := attr.Value
+			if len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			event.Name = string(name)
+		case unix.IFLA_OPERSTATE:
+			if len(attr.Value) > 0 {
+				event.OperState = attr.Value[0]
+			}
+		case unix.IFLA_MASTER:
+			if len(attr.Value) >= 4 {
+				event.MasterIndex = int(encoder.Uint32(attr.Value[0:4]))
+			}
+		}
+	}
+
+	return event, nil
+}
+
+// SubscribeLinkEvents delivers the changes of all network interfaces to the given channel
+// until done is closed. Events are received in the background. The channel is closed when
+// the subscription ends, whether cancelled or failed.
+func SubscribeLinkEvents(ch chan<- LinkEvent, done <-chan struct{}) error {
+	s, err := newSubscriptionSocket(RTMGRP_LINK)
+	if err != nil {
+		log.Printf("[netlink] Failed to subscribe to link events, err=%v\n", err)
+		return err
+	}
+
+	go func() {
+		defer close(ch)
+		defer s.close()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			nlMsgs, err := s.receive()
+			if err != nil {
+				if err == unix.EAGAIN || err == unix.EINTR {
+					continue
+				}
+
+				// Events were dropped because the socket buffer overflowed.
+				// Subscribers re-read the state of the interfaces they care about.
+				if err == unix.ENOBUFS {
+					log.Printf("[netlink] Link events were lost.\n")
+					continue
+				}
+
+				log.Printf("[netlink] Link event subscription failed, err=%v\n", err)
+				return
+			}
+
+			for i := range nlMsgs {
+				if nlMsgs[i].Header.Type != unix.RTM_NEWLINK && nlMsgs[i].Header.Type != unix.RTM_DELLINK {
+					continue
+				}
+
+				event, err := deserializeLinkEvent(&nlMsgs[i])
+				if err != nil {
+					log.Printf("[netlink] Ignoring invalid link event, err=%v\n", err)
+					continue
+				}
+
+				log.Debugf("[netlink] Received link event %+v\n", *event)
+
+				select {
+				case ch <- *event:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	return nil
+}
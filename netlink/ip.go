@@ -6,6 +6,7 @@
 package netlink
 
 import (
+	"encoding/binary"
 	"net"
 
 	"golang.org/x/sys/unix"
@@ -103,6 +104,57 @@ type Route struct {
 	Priority   int
 	LinkIndex  int
 	ILinkIndex int
+	Encap      *IPTunnelEncap
+}
+
+// IPTunnelEncap is the lightweight tunnel encapsulation of the traffic of a route through a tunnel
+// interface in external mode.
+type IPTunnelEncap struct {
+	Id            uint64
+	Dst           net.IP
+	Src           net.IP
+	GeneveOptions []GeneveOption
+}
+
+// GeneveOption is a TLV option of the Geneve header. Its data is a multiple of 4 bytes long.
+type GeneveOption struct {
+	Class uint16
+	Type  uint8
+	Data  []byte
+}
+
+// newAttributeIPTunnelEncap creates the encapsulation attribute of a route.
+func newAttributeIPTunnelEncap(encap *IPTunnelEncap) *attribute {
+	attrEncap := newAttribute(RTA_ENCAP|unix.NLA_F_NESTED, nil)
+
+	// The tunnel ID is in network byte order.
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, encap.Id)
+	attrEncap.addNested(newAttribute(LWTUNNEL_IP_ID, id))
+
+	if encap.Dst != nil {
+		attrEncap.addNested(newAttributeIpAddress(LWTUNNEL_IP_DST, encap.Dst))
+	}
+
+	if encap.Src != nil {
+		attrEncap.addNested(newAttributeIpAddress(LWTUNNEL_IP_SRC, encap.Src))
+	}
+
+	if len(encap.GeneveOptions) != 0 {
+		attrOpts := newAttribute(LWTUNNEL_IP_OPTS|unix.NLA_F_NESTED, nil)
+
+		for _, opt := range encap.GeneveOptions {
+			attrOpt := newAttribute(LWTUNNEL_IP_OPTS_GENEVE|unix.NLA_F_NESTED, nil)
+			attrOpt.addNested(newAttribute(LWTUNNEL_IP_OPT_GENEVE_CLASS, []byte{byte(opt.Class >> 8), byte(opt.Class)}))
+			attrOpt.addNested(newAttribute(LWTUNNEL_IP_OPT_GENEVE_TYPE, []byte{opt.Type}))
+			attrOpt.addNested(newAttribute(LWTUNNEL_IP_OPT_GENEVE_DATA, opt.Data))
+			attrOpts.addNested(attrOpt)
+		}
+
+		attrEncap.addNested(attrOpts)
+	}
+
+	return attrEncap
 }
 
 // deserializeRoute decodes a netlink message into a Route struct.
@@ -214,6 +266,11 @@ func GetIpRoute(filter *Route) ([]*Route, error) {
 			continue
 		}
 
+		// Filter by priority.
+		if filter.Priority != 0 && filter.Priority != route.Priority {
+			continue
+		}
+
 		routes = append(routes, route)
 	}
 
@@ -234,7 +291,8 @@ func setIpRoute(route *Route, add bool) error {
 		flags = unix.NLM_F_CREATE | unix.NLM_F_EXCL | unix.NLM_F_ACK
 	} else {
 		msgType = unix.RTM_DELROUTE
-		flags = unix.NLM_F_EXCL | unix.NLM_F_ACK
+		// NLM_F_EXCL is NLM_F_BULK on delete requests, which newer kernels reject for routes.
+		flags = unix.NLM_F_ACK
 	}
 
 	req := newRequest(msgType, flags)
@@ -259,6 +317,12 @@ func setIpRoute(route *Route, add bool) error {
 
 	req.addPayload(msg)
 
+	// Table IDs that don't fit in the message header are passed as an attribute.
+	if route.Table > 255 {
+		msg.Table = unix.RT_TABLE_UNSPEC
+		req.addPayload(newAttributeUint32(unix.RTA_TABLE, uint32(route.Table)))
+	}
+
 	if route.Dst != nil {
 		prefixLength, _ := route.Dst.Mask.Size()
 		msg.Dst_len = uint8(prefixLength)
@@ -285,6 +349,11 @@ func setIpRoute(route *Route, add bool) error {
 		req.addPayload(newAttributeUint32(unix.RTA_IIF, uint32(route.ILinkIndex)))
 	}
 
+	if route.Encap != nil {
+		req.addPayload(newAttributeUint16(RTA_ENCAP_TYPE, LWTUNNEL_ENCAP_IP))
+		req.addPayload(newAttributeIPTunnelEncap(route.Encap))
+	}
+
 	return s.sendAndWaitForAck(req)
 }
 
@@ -297,3 +366,101 @@ func AddIpRoute(route *Route) error {
 func DeleteIpRoute(route *Route) error {
 	return setIpRoute(route, false)
 }
+
+// Rule represents a netlink policy routing rule.
+type Rule struct {
+	Family   int
+	Src      *net.IPNet
+	Dst      *net.IPNet
+	Tos      int
+	Table    int
+	Priority int
+	Mark     int
+	Mask     int
+	IifName  string
+	OifName  string
+}
+
+// setIpRule sends an IP rule set request.
+func setIpRule(rule *Rule, add bool) error {
+	var msgType, flags int
+
+	s, err := getSocket()
+	if err != nil {
+		return err
+	}
+
+	if add {
+		msgType = unix.RTM_NEWRULE
+		flags = unix.NLM_F_CREATE | unix.NLM_F_EXCL | unix.NLM_F_ACK
+	} else {
+		msgType = unix.RTM_DELRULE
+		// NLM_F_EXCL is NLM_F_BULK on delete requests, which newer kernels reject for routes.
+		flags = unix.NLM_F_ACK
+	}
+
+	req := newRequest(msgType, flags)
+
+	// Rule messages share the layout of route messages, with the action in place of the route type.
+	msg := &rtMsg{
+		RtMsg: unix.RtMsg{
+			Family: uint8(rule.Family),
+			Tos:    uint8(rule.Tos),
+			Type:   FR_ACT_TO_TBL,
+		},
+	}
+
+	if rule.Table <= 255 {
+		msg.Table = uint8(rule.Table)
+	}
+
+	req.addPayload(msg)
+
+	if rule.Table != 0 {
+		req.addPayload(newAttributeUint32(FRA_TABLE, uint32(rule.Table)))
+	}
+
+	if rule.Src != nil {
+		prefixLength, _ := rule.Src.Mask.Size()
+		msg.Src_len = uint8(prefixLength)
+		req.addPayload(newAttributeIpAddress(FRA_SRC, rule.Src.IP))
+	}
+
+	if rule.Dst != nil {
+		prefixLength, _ := rule.Dst.Mask.Size()
+		msg.Dst_len = uint8(prefixLength)
+		req.addPayload(newAttributeIpAddress(FRA_DST, rule.Dst.IP))
+	}
+
+	if rule.Priority != 0 {
+		req.addPayload(newAttributeUint32(FRA_PRIORITY, uint32(rule.Priority)))
+	}
+
+	if rule.Mark != 0 {
+		req.addPayload(newAttributeUint32(FRA_FWMARK, uint32(rule.Mark)))
+	}
+
+	if rule.Mask != 0 {
+		req.addPayload(newAttributeUint32(FRA_FWMASK, uint32(rule.Mask)))
+	}
+
+	if rule.IifName != "" {
+		req.addPayload(newAttributeStringZ(FRA_IIFNAME, rule.IifName))
+	}
+
+	if rule.OifName != "" {
+		req.addPayload(newAttributeStringZ(FRA_OIFNAME, rule.OifName))
+	}
+
+	return s.sendAndWaitForAck(req)
+}
+
+// AddIpRule adds an IP policy routing rule.
+func AddIpRule(rule *Rule) error {
+	return setIpRule(rule, true)
+}
+
+// DeleteIpRule deletes an IP policy routing rule.
+func DeleteIpRule(rule *Rule) error {
+	return setIpRule(rule, false)
+}
@@ -8,6 +8,7 @@ package netlink
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/Azure/azure-container-networking/log"
 	"golang.org/x/sys/unix"
@@ -19,6 +20,9 @@ const (
 	LINK_TYPE_VETH   = "veth"
 	LINK_TYPE_IPVLAN = "ipvlan"
 	LINK_TYPE_DUMMY  = "dummy"
+	LINK_TYPE_VRF    = "vrf"
+	LINK_TYPE_VXLAN  = "vxlan"
+	LINK_TYPE_GENEVE = "geneve"
 )
 
 // IPVLAN link attributes.
@@ -77,6 +81,30 @@ type DummyLink struct {
 	LinkInfo
 }
 
+// VRFLink represents a virtual routing and forwarding device.
+// Interfaces enslaved to it are routed using its route table.
+type VRFLink struct {
+	LinkInfo
+	Table uint32
+}
+
+// VXLANLink represents a VXLAN tunnel endpoint. Remote endpoints aren't learned, and are
+// set with forwarding database entries instead.
+type VXLANLink struct {
+	LinkInfo
+	VxlanId  uint32
+	SrcAddr  net.IP
+	Port     uint16
+	DevIndex int
+}
+
+// GENEVELink represents a Geneve tunnel endpoint in external mode. Remote endpoints, VNIs and
+// options are set by the tunnel encapsulation of the routes through it.
+type GENEVELink struct {
+	LinkInfo
+	Port uint16
+}
+
 // AddLink adds a new network interface of a specified type.
 func AddLink(link Link) error {
 	var info *LinkInfo
@@ -143,6 +171,46 @@ func AddLink(link Link) error {
 		attrData := newAttribute(IFLA_INFO_DATA, nil)
 		attrData.addNested(newAttributeUint16(IFLA_IPVLAN_MODE, uint16(ipvlan.Mode)))
 
+		attrLinkInfo.addNested(attrData)
+
+	} else if vrf, ok := link.(*VRFLink); ok {
+		// Set VRF attributes.
+		attrData := newAttribute(IFLA_INFO_DATA, nil)
+		attrData.addNested(newAttributeUint32(IFLA_VRF_TABLE, vrf.Table))
+
+		attrLinkInfo.addNested(attrData)
+
+	} else if vxlan, ok := link.(*VXLANLink); ok {
+		// Set VXLAN attributes.
+		attrData := newAttribute(IFLA_INFO_DATA, nil)
+		attrData.addNested(newAttributeUint32(IFLA_VXLAN_ID, vxlan.VxlanId))
+		attrData.addNested(newAttribute(IFLA_VXLAN_LEARNING, []byte{0}))
+
+		if vxlan.SrcAddr != nil {
+			attrData.addNested(newAttributeIpAddress(IFLA_VXLAN_LOCAL, vxlan.SrcAddr))
+		}
+
+		if vxlan.DevIndex != 0 {
+			attrData.addNested(newAttributeUint32(IFLA_VXLAN_LINK, uint32(vxlan.DevIndex)))
+		}
+
+		// The port is in network byte order.
+		if vxlan.Port != 0 {
+			attrData.addNested(newAttribute(IFLA_VXLAN_PORT, []byte{byte(vxlan.Port >> 8), byte(vxlan.Port)}))
+		}
+
+		attrLinkInfo.addNested(attrData)
+
+	} else if geneve, ok := link.(*GENEVELink); ok {
+		// Set Geneve attributes.
+		attrData := newAttribute(IFLA_INFO_DATA, nil)
+		attrData.addNested(newAttribute(IFLA_GENEVE_COLLECT_METADATA, nil))
+
+		// The port is in network byte order.
+		if geneve.Port != 0 {
+			attrData.addNested(newAttribute(IFLA_GENEVE_PORT, []byte{byte(geneve.Port >> 8), byte(geneve.Port)}))
+		}
+
 		attrLinkInfo.addNested(attrData)
 	}
 
@@ -178,6 +246,60 @@ func DeleteLink(name string) error {
 	return s.sendAndWaitForAck(req)
 }
 
+// GetLinkKind returns the kind of a network interface, e.g. "bridge" or "bond".
+// Physical interfaces don't have a kind.
+func GetLinkKind(name string) (string, error) {
+	s, err := getSocket()
+	if err != nil {
+		return "", err
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+
+	req := newRequest(unix.RTM_GETLINK, 0)
+
+	ifInfo := newIfInfoMsg()
+	ifInfo.Index = int32(iface.Index)
+	req.addPayload(ifInfo)
+
+	msgs, err := s.sendAndWaitForResponse(req)
+	if err != nil {
+		return "", err
+	}
+
+	for _, msg := range msgs {
+		for _, attr := range msg.getAttributes(ifInfo) {
+			if attr.Type != unix.IFLA_LINKINFO {
+				continue
+			}
+
+			// Link info attributes are nested.
+			b := attr.value
+			for len(b) >= unix.SizeofNlAttr {
+				attrLen := int(encoder.Uint16(b[0:2]))
+				if attrLen < unix.SizeofNlAttr || attrLen > len(b) {
+					break
+				}
+
+				if encoder.Uint16(b[2:4]) == IFLA_INFO_KIND {
+					return strings.TrimRight(string(b[unix.SizeofNlAttr:attrLen]), "\x00"), nil
+				}
+
+				attrLen = (attrLen + unix.NLA_ALIGNTO - 1) & ^(unix.NLA_ALIGNTO - 1)
+				if attrLen > len(b) {
+					break
+				}
+				b = b[attrLen:]
+			}
+		}
+	}
+
+	return "", nil
+}
+
 // SetLinkName sets the name of a network interface.
 func SetLinkName(name string, newName string) error {
 	s, err := getSocket()
@@ -412,19 +534,108 @@ func AddOrRemoveStaticArp(mode int, name string, ipaddr net.IP, mac net.Hardware
 		return err
 	}
 
+	family := unix.AF_INET
+	ipData := ipaddr.To4()
+	if ipData == nil {
+		family = unix.AF_INET6
+		ipData = ipaddr.To16()
+	}
+
 	msg := neighMsg{
-		Family: uint8(unix.AF_INET),
+		Family: uint8(family),
 		Index:  uint32(iface.Index),
 		State:  uint16(state),
 	}
 	req.addPayload(&msg)
 
+	dstData := newRtAttr(NDA_DST, ipData)
+	req.addPayload(dstData)
+
+	hwData := newRtAttr(NDA_LLADDR, []byte(mac))
+	req.addPayload(hwData)
+
+	return s.sendAndWaitForAck(req)
+}
+
+// AddOrRemoveProxyNeighbor sets/removes the proxy neighbor entry answering the neighbor solicitations
+// and ARP requests received on an interface for an IP address with the MAC address of the interface.
+func AddOrRemoveProxyNeighbor(mode int, name string, ipaddr net.IP) error {
+	s, err := getSocket()
+	if err != nil {
+		return err
+	}
+
+	var req *message
+	if mode == ADD {
+		req = newRequest(unix.RTM_NEWNEIGH, unix.NLM_F_CREATE|unix.NLM_F_REPLACE|unix.NLM_F_ACK)
+	} else {
+		req = newRequest(unix.RTM_DELNEIGH, unix.NLM_F_ACK)
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	family := unix.AF_INET
 	ipData := ipaddr.To4()
+	if ipData == nil {
+		family = unix.AF_INET6
+		ipData = ipaddr.To16()
+	}
+
+	msg := neighMsg{
+		Family: uint8(family),
+		Index:  uint32(iface.Index),
+		State:  uint16(NUD_PERMANENT),
+		Flags:  uint8(NTF_PROXY),
+	}
+	req.addPayload(&msg)
+
 	dstData := newRtAttr(NDA_DST, ipData)
 	req.addPayload(dstData)
 
+	return s.sendAndWaitForAck(req)
+}
+
+// AddOrRemoveFdbEntry sets/removes the forwarding database entry sending the frames of a MAC
+// address through a VXLAN interface to the remote endpoint with the given IP address.
+func AddOrRemoveFdbEntry(mode int, name string, mac net.HardwareAddr, dst net.IP) error {
+	s, err := getSocket()
+	if err != nil {
+		return err
+	}
+
+	var req *message
+	if mode == ADD {
+		req = newRequest(unix.RTM_NEWNEIGH, unix.NLM_F_CREATE|unix.NLM_F_REPLACE|unix.NLM_F_ACK)
+	} else {
+		req = newRequest(unix.RTM_DELNEIGH, unix.NLM_F_ACK)
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	msg := neighMsg{
+		Family: uint8(unix.AF_BRIDGE),
+		Index:  uint32(iface.Index),
+		State:  uint16(NUD_PERMANENT),
+		Flags:  uint8(NTF_SELF),
+	}
+	req.addPayload(&msg)
+
 	hwData := newRtAttr(NDA_LLADDR, []byte(mac))
 	req.addPayload(hwData)
 
+	ipData := dst.To4()
+	if ipData == nil {
+		ipData = dst.To16()
+	}
+
+	dstData := newRtAttr(NDA_DST, ipData)
+	req.addPayload(dstData)
+
 	return s.sendAndWaitForAck(req)
 }
@@ -7,7 +7,12 @@ package netlink
 
 import (
 	"net"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/bpf"
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -259,3 +264,507 @@ func TestAddRemoveStaticArp(t *testing.T) {
 		t.Errorf("DeleteLink failed: %+v", err)
 	}
 }
+
+func TestAddRemoveProxyNeighbor(t *testing.T) {
+	_, err := addDummyInterface(ifName)
+	if err != nil {
+		t.Errorf("addDummyInterface failed: %v", err)
+	}
+
+	ip := net.ParseIP("fd00::2")
+
+	err = AddOrRemoveProxyNeighbor(ADD, ifName, ip)
+	if err != nil {
+		t.Errorf("ret val %v", err)
+	}
+
+	err = AddOrRemoveProxyNeighbor(REMOVE, ifName, ip)
+	if err != nil {
+		t.Errorf("ret val %v", err)
+	}
+
+	err = DeleteLink(ifName)
+	if err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+}
+
+// TestAddDeleteIpRouteAndRule tests adding and deleting a prioritized route in a custom table and a rule looking it up.
+func TestAddDeleteIpRouteAndRule(t *testing.T) {
+	err := AddLink(&BridgeLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_BRIDGE,
+			Name: ifName,
+		},
+	})
+	if err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+
+	defer DeleteLink(ifName)
+
+	bridge, err := net.InterfaceByName(ifName)
+	if err != nil {
+		t.Fatalf("InterfaceByName failed: %+v", err)
+	}
+
+	if err = SetLinkState(ifName, true); err != nil {
+		t.Fatalf("SetLinkState failed: %+v", err)
+	}
+
+	_, dst, _ := net.ParseCIDR("10.10.0.0/24")
+	route := &Route{
+		Family:    unix.AF_INET,
+		Dst:       dst,
+		Table:     1000,
+		Priority:  100,
+		Scope:     unix.RT_SCOPE_LINK,
+		LinkIndex: bridge.Index,
+	}
+
+	if err = AddIpRoute(route); err != nil {
+		t.Fatalf("AddIpRoute failed: %+v", err)
+	}
+
+	routes, err := GetIpRoute(&Route{Family: unix.AF_INET, Table: 1000, Priority: 100, LinkIndex: bridge.Index})
+	if err != nil || len(routes) != 1 {
+		t.Errorf("GetIpRoute returned %+v, err %v", routes, err)
+	}
+
+	rule := &Rule{
+		Family:   unix.AF_INET,
+		Src:      dst,
+		Table:    1000,
+		Priority: 32000,
+	}
+
+	if err = AddIpRule(rule); err != nil {
+		t.Errorf("AddIpRule failed: %+v", err)
+	}
+
+	if err = DeleteIpRule(rule); err != nil {
+		t.Errorf("DeleteIpRule failed: %+v", err)
+	}
+
+	if err = DeleteIpRoute(route); err != nil {
+		t.Errorf("DeleteIpRoute failed: %+v", err)
+	}
+}
+
+// TestAddDeleteVRF tests adding a VRF device, enslaving an interface to it and deleting it.
+func TestAddDeleteVRF(t *testing.T) {
+	vrf := VRFLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_VRF,
+			Name: ifName2,
+		},
+		Table: 1001,
+	}
+
+	if err := AddLink(&vrf); err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+
+	err := AddLink(&BridgeLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_BRIDGE,
+			Name: ifName,
+		},
+	})
+	if err != nil {
+		t.Errorf("AddLink failed: %+v", err)
+	}
+
+	if err = SetLinkMaster(ifName, ifName2); err != nil {
+		t.Errorf("SetLinkMaster failed: %+v", err)
+	}
+
+	if err = DeleteLink(ifName); err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+
+	if err = DeleteLink(ifName2); err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+
+	if _, err = net.InterfaceByName(ifName2); err == nil {
+		t.Errorf("Interface not deleted")
+	}
+}
+
+// TestAddDeleteVXLAN tests adding a VXLAN interface with a forwarding database entry, and deleting it.
+func TestAddDeleteVXLAN(t *testing.T) {
+	vxlan := VXLANLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_VXLAN,
+			Name: ifName,
+		},
+		VxlanId: 4096,
+		Port:    4789,
+	}
+
+	if err := AddLink(&vxlan); err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+
+	mac, _ := net.ParseMAC("0e:2a:0a:f4:01:01")
+	if err := AddOrRemoveFdbEntry(ADD, ifName, mac, net.ParseIP("10.0.0.5")); err != nil {
+		t.Errorf("AddOrRemoveFdbEntry failed: %+v", err)
+	}
+
+	if err := AddOrRemoveFdbEntry(REMOVE, ifName, mac, net.ParseIP("10.0.0.5")); err != nil {
+		t.Errorf("AddOrRemoveFdbEntry failed: %+v", err)
+	}
+
+	kind, err := GetLinkKind(ifName)
+	if err != nil || kind != LINK_TYPE_VXLAN {
+		t.Errorf("Unexpected link kind %v, err:%v", kind, err)
+	}
+
+	if err = DeleteLink(ifName); err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+}
+
+// TestAddDeleteGeneve tests adding a Geneve interface in external mode and a route through it with a
+// tunnel encapsulation, and deleting them.
+func TestAddDeleteGeneve(t *testing.T) {
+	geneve := GENEVELink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_GENEVE,
+			Name: ifName,
+		},
+		Port: 6081,
+	}
+
+	if err := AddLink(&geneve); err == unix.EOPNOTSUPP {
+		t.Skip("Kernel doesn't support Geneve")
+	} else if err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+
+	if err := SetLinkState(ifName, true); err != nil {
+		t.Errorf("SetLinkState failed: %+v", err)
+	}
+
+	iface, err := net.InterfaceByName(ifName)
+	if err != nil {
+		t.Fatalf("InterfaceByName failed: %+v", err)
+	}
+
+	_, dst, _ := net.ParseCIDR("10.244.1.0/24")
+	route := &Route{
+		Family:    unix.AF_INET,
+		Dst:       dst,
+		Gw:        net.ParseIP("10.244.1.1"),
+		LinkIndex: iface.Index,
+		Flags:     unix.RTNH_F_ONLINK,
+		Encap: &IPTunnelEncap{
+			Id:  4096,
+			Dst: net.ParseIP("10.0.0.5"),
+			GeneveOptions: []GeneveOption{
+				{Class: 0xfff0, Type: 1, Data: make([]byte, 16)},
+			},
+		},
+	}
+
+	if err = AddIpRoute(route); err != nil {
+		t.Errorf("AddIpRoute failed: %+v", err)
+	}
+
+	if err = DeleteIpRoute(route); err != nil {
+		t.Errorf("DeleteIpRoute failed: %+v", err)
+	}
+
+	kind, err := GetLinkKind(ifName)
+	if err != nil || kind != LINK_TYPE_GENEVE {
+		t.Errorf("Unexpected link kind %v, err:%v", kind, err)
+	}
+
+	if err = DeleteLink(ifName); err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+}
+
+// TestSubscribeLinkEvents tests receiving the events of a bridge being added and deleted.
+func TestSubscribeLinkEvents(t *testing.T) {
+	events := make(chan LinkEvent, 16)
+	done := make(chan struct{})
+	defer close(done)
+
+	if err := SubscribeLinkEvents(events, done); err != nil {
+		t.Fatalf("SubscribeLinkEvents failed: %+v", err)
+	}
+
+	link := BridgeLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_BRIDGE,
+			Name: ifName,
+		},
+	}
+
+	if err := AddLink(&link); err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+
+	if err := DeleteLink(ifName); err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+
+	added, deleted := false, false
+	timeout := time.After(5 * time.Second)
+	for !added || !deleted {
+		select {
+		case event := <-events:
+			if event.Name == ifName {
+				added = added || event.Type == unix.RTM_NEWLINK
+				deleted = deleted || event.IsDeleted()
+			}
+		case <-timeout:
+			t.Fatalf("Link events not received, added:%v deleted:%v", added, deleted)
+		}
+	}
+}
+
+// TestGetLinkKind tests getting the kind of a bridge.
+func TestGetLinkKind(t *testing.T) {
+	link := BridgeLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_BRIDGE,
+			Name: ifName,
+		},
+	}
+
+	if err := AddLink(&link); err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+	defer DeleteLink(ifName)
+
+	kind, err := GetLinkKind(ifName)
+	if err != nil || kind != LINK_TYPE_BRIDGE {
+		t.Errorf("GetLinkKind returned %v, err:%v", kind, err)
+	}
+
+	if kind, err = GetLinkKind("lo"); err != nil || kind != "" {
+		t.Errorf("GetLinkKind returned %v for loopback, err:%v", kind, err)
+	}
+}
+
+// TestAddDeleteQdisc tests adding and deleting queueing disciplines, classes and filters.
+func TestAddDeleteQdisc(t *testing.T) {
+	link := BridgeLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_BRIDGE,
+			Name: ifName,
+		},
+	}
+
+	if err := AddLink(&link); err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+	defer DeleteLink(ifName)
+
+	tbf := TbfQdisc{
+		QdiscInfo: QdiscInfo{
+			Type:     QDISC_TYPE_TBF,
+			LinkName: ifName,
+			Handle:   MakeHandle(1, 0),
+			Parent:   TC_H_ROOT,
+		},
+		Rate:  125000,
+		Burst: 16000,
+		Limit: 32000,
+	}
+
+	if err := AddQdisc(&tbf); err != nil {
+		t.Fatalf("AddQdisc tbf failed: %+v", err)
+	}
+
+	if err := DeleteQdisc(&tbf); err != nil {
+		t.Errorf("DeleteQdisc tbf failed: %+v", err)
+	}
+
+	htb := HtbQdisc{
+		QdiscInfo: QdiscInfo{
+			Type:     QDISC_TYPE_HTB,
+			LinkName: ifName,
+			Handle:   MakeHandle(1, 0),
+			Parent:   TC_H_ROOT,
+		},
+		DefaultClass: 20,
+	}
+
+	if err := AddQdisc(&htb); err != nil {
+		t.Fatalf("AddQdisc htb failed: %+v", err)
+	}
+	defer DeleteQdisc(&htb)
+
+	class := HtbClass{
+		ClassInfo: ClassInfo{
+			Type:     CLASS_TYPE_HTB,
+			LinkName: ifName,
+			Handle:   MakeHandle(1, 10),
+			Parent:   MakeHandle(1, 0),
+		},
+		Rate: 1250000,
+	}
+
+	if err := AddClass(&class); err != nil {
+		t.Fatalf("AddClass failed: %+v", err)
+	}
+
+	fqCodel := FqCodelQdisc{
+		QdiscInfo: QdiscInfo{
+			Type:     QDISC_TYPE_FQ_CODEL,
+			LinkName: ifName,
+			Handle:   MakeHandle(10, 0),
+			Parent:   MakeHandle(1, 10),
+		},
+		ECN: true,
+	}
+
+	// Queueing disciplines and filters built as modules may be missing from the kernel.
+	if err := AddQdisc(&fqCodel); err != nil && err != unix.ENOENT {
+		t.Errorf("AddQdisc fq_codel failed: %+v", err)
+	}
+
+	_, dst, _ := net.ParseCIDR("10.0.0.0/24")
+	u32 := U32Filter{
+		FilterInfo: FilterInfo{
+			Type:     FILTER_TYPE_U32,
+			LinkName: ifName,
+			Parent:   MakeHandle(1, 0),
+			Priority: 1,
+			ClassId:  MakeHandle(1, 10),
+		},
+		Dst: dst,
+	}
+
+	if err := AddFilter(&u32); err != nil {
+		t.Errorf("AddFilter u32 failed: %+v", err)
+	}
+
+	fw := FwFilter{
+		FilterInfo: FilterInfo{
+			Type:     FILTER_TYPE_FW,
+			LinkName: ifName,
+			Handle:   0x10,
+			Parent:   MakeHandle(1, 0),
+			Priority: 2,
+			ClassId:  MakeHandle(1, 10),
+		},
+	}
+
+	if err := AddFilter(&fw); err != nil && err != unix.ENOENT {
+		t.Errorf("AddFilter fw failed: %+v", err)
+	}
+
+	// The class can't be deleted while filters send packets to it.
+	if err := DeleteClass(&class); err == nil {
+		t.Errorf("DeleteClass succeeded for a class in use by a filter")
+	}
+
+	if err := DeleteFilter(&u32); err != nil {
+		t.Errorf("DeleteFilter failed: %+v", err)
+	}
+
+	DeleteFilter(&fw)
+
+	if err := DeleteClass(&class); err != nil {
+		t.Errorf("DeleteClass failed: %+v", err)
+	}
+}
+
+// TestAddDeleteBpfFilter tests attaching eBPF programs to the hooks of a clsact queueing discipline.
+func TestAddDeleteBpfFilter(t *testing.T) {
+	link := BridgeLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_BRIDGE,
+			Name: ifName,
+		},
+	}
+
+	if err := AddLink(&link); err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+	defer DeleteLink(ifName)
+
+	clsact := QdiscInfo{
+		Type:     QDISC_TYPE_CLSACT,
+		LinkName: ifName,
+		Handle:   MakeHandle(0xFFFF, 0),
+		Parent:   TC_H_CLSACT,
+	}
+
+	if err := AddQdisc(&clsact); err != nil {
+		t.Fatalf("AddQdisc clsact failed: %+v", err)
+	}
+
+	// A program accepting all packets.
+	a := bpf.NewAssembler()
+	a.AluImm(bpf.OpMov, bpf.R0, 0)
+	a.Exit()
+	insns, _ := a.Assemble()
+
+	bpf.RemoveMemlockLimit()
+	prog, err := bpf.LoadProgram(bpf.ProgTypeSchedCLS, insns, "MIT")
+	if err != nil {
+		t.Fatalf("LoadProgram failed: %+v", err)
+	}
+	defer prog.Close()
+
+	for _, parent := range []uint32{TC_H_CLSACT_INGRESS, TC_H_CLSACT_EGRESS} {
+		filter := BpfFilter{
+			FilterInfo: FilterInfo{
+				Type:     FILTER_TYPE_BPF,
+				LinkName: ifName,
+				Handle:   1,
+				Parent:   parent,
+				Priority: 1,
+			},
+			Fd:           prog.FD(),
+			Name:         "test",
+			DirectAction: true,
+		}
+
+		if err := AddFilter(&filter); err != nil {
+			t.Fatalf("AddFilter bpf failed: %+v", err)
+		}
+
+		if err := AddFilter(&filter); err == nil {
+			t.Errorf("AddFilter succeeded for an existing filter")
+		}
+
+		if err := ReplaceFilter(&filter); err != nil {
+			t.Errorf("ReplaceFilter bpf failed: %+v", err)
+		}
+
+		if err := DeleteFilter(&filter); err != nil {
+			t.Errorf("DeleteFilter bpf failed: %+v", err)
+		}
+	}
+
+	if err := DeleteQdisc(&clsact); err != nil {
+		t.Errorf("DeleteQdisc clsact failed: %+v", err)
+	}
+}
+
+func TestDeserializeNflogPacket(t *testing.T) {
+	data := (&nfGenMsg{family: unix.AF_INET, version: unix.NFNETLINK_V0, resID: 100}).serialize()
+	data = append(data, newAttribute(nfulaPrefix, []byte("audit\x00")).serialize()...)
+	data = append(data, newAttribute(nfulaPayload, []byte{0x45, 0, 0, 20}).serialize()...)
+
+	packet, err := deserializeNflogPacket(&syscall.NetlinkMessage{Data: data})
+	if err != nil {
+		t.Fatalf("deserializeNflogPacket failed: %+v", err)
+	}
+
+	if packet.Prefix != "audit" || len(packet.Payload) != 4 || packet.Payload[0] != 0x45 {
+		t.Errorf("Unexpected NFLOG packet %+v", packet)
+	}
+
+	// Attributes running past the end of the message are invalid.
+	if _, err := deserializeNflogPacket(&syscall.NetlinkMessage{Data: data[:len(data)-4]}); err == nil {
+		t.Errorf("deserializeNflogPacket succeeded for a truncated message")
+	}
+}
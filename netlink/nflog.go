@@ -0,0 +1,216 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build linux
+
+package netlink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"syscall"
+
+	"github.com/Azure/azure-container-networking/log"
+	"golang.org/x/sys/unix"
+)
+
+// nfnetlink_log message types, attributes and commands.
+const (
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulaPayload = 9
+	nfulaPrefix  = 10
+
+	nfulaCfgCmd  = 1
+	nfulaCfgMode = 2
+
+	nfulnlCfgCmdBind = 1
+	nfulnlCopyPacket = 2
+
+	nlaTypeMask = ^uint16(unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+)
+
+// Number of bytes of each logged packet copied to the subscriber, enough for the IP and transport headers.
+const nflogCopyRange = 128
+
+// NflogPacket is a packet logged to an NFLOG group by an iptables rule.
+type NflogPacket struct {
+	// Prefix is the --nflog-prefix of the rule.
+	Prefix string
+	// Payload is the start of the packet, from its network header.
+	Payload []byte
+}
+
+// nfGenMsg is the header of nfnetlink messages.
+type nfGenMsg struct {
+	family  uint8
+	version uint8
+	resID   uint16
+}
+
+func (msg *nfGenMsg) serialize() []byte {
+	b := []byte{msg.family, msg.version, 0, 0}
+	// The resource ID is in network byte order.
+	binary.BigEndian.PutUint16(b[2:4], msg.resID)
+	return b
+}
+
+func (msg *nfGenMsg) length() int {
+	return 4
+}
+
+// configureNflog sends an nfnetlink_log config request for an NFLOG group, and waits for its ack.
+func configureNflog(s *socket, group uint16, attr *attribute) error {
+	req := newRequest(unix.NFNL_SUBSYS_ULOG<<8|nfulnlMsgConfig, unix.NLM_F_ACK)
+	req.addPayload(&nfGenMsg{family: unix.AF_UNSPEC, version: unix.NFNETLINK_V0, resID: group})
+	req.addPayload(attr)
+
+	if err := s.send(req); err != nil {
+		return err
+	}
+
+	nlMsgs, err := s.receive()
+	if err != nil {
+		return err
+	}
+
+	for _, nlMsg := range nlMsgs {
+		if nlMsg.Header.Type == unix.NLMSG_ERROR && len(nlMsg.Data) >= 4 {
+			if errCode := int32(encoder.Uint32(nlMsg.Data[0:4])); errCode != 0 {
+				return syscall.Errno(-errCode)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No ack for NFLOG group %d config", group)
+}
+
+// deserializeNflogPacket decodes an nfnetlink_log packet message.
+func deserializeNflogPacket(nlMsg *syscall.NetlinkMessage) (*NflogPacket, error) {
+	if len(nlMsg.Data) < 4 {
+		return nil, unix.EINVAL
+	}
+
+	packet := &NflogPacket{}
+
+	b := nlMsg.Data[4:]
+	for len(b) >= unix.SizeofNlAttr {
+		attrLen := int(encoder.Uint16(b[0:2]))
+		attrType := encoder.Uint16(b[2:4]) & nlaTypeMask
+		if attrLen < unix.SizeofNlAttr || attrLen > len(b) {
+			return nil, unix.EINVAL
+		}
+
+		value := b[unix.SizeofNlAttr:attrLen]
+		switch attrType {
+		case nfulaPrefix:
+			// The prefix is zero terminated.
+			if i := bytes.IndexByte(value, 0); i >= 0 {
+				value = value[:i]
+			}
+			packet.Prefix = string(value)
+		case nfulaPayload:
+			packet.Payload = append([]byte(nil), value...)
+		}
+
+		next := rtaAlignOf(attrLen)
+		if next > len(b) {
+			break
+		}
+		b = b[next:]
+	}
+
+	return packet, nil
+}
+
+// SubscribeNflog delivers the packets iptables rules log to an NFLOG group to the given channel
+// until done is closed. Packets are received in the background. The channel is closed when
+// the subscription ends, whether cancelled or failed. Only one process can subscribe to a group.
+func SubscribeNflog(group uint16, ch chan<- NflogPacket, done <-chan struct{}) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return err
+	}
+
+	s := &socket{fd: fd}
+	s.sa.Family = unix.AF_NETLINK
+
+	if err = unix.Bind(fd, &s.sa); err != nil {
+		unix.Close(fd)
+		return err
+	}
+
+	// Wake up periodically so that the subscription can be cancelled.
+	tv := unix.Timeval{Sec: subscriptionPollSeconds}
+	if err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return err
+	}
+
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode[0:4], nflogCopyRange)
+	mode[4] = nfulnlCopyPacket
+
+	for _, attr := range []*attribute{
+		newAttribute(nfulaCfgCmd, []byte{nfulnlCfgCmdBind}),
+		newAttribute(nfulaCfgMode, mode),
+	} {
+		if err = configureNflog(s, group, attr); err != nil {
+			log.Printf("[netlink] Failed to subscribe to NFLOG group %d, err=%v\n", group, err)
+			s.close()
+			return err
+		}
+	}
+
+	go func() {
+		defer close(ch)
+		defer s.close()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			nlMsgs, err := s.receive()
+			if err != nil {
+				if err == unix.EAGAIN || err == unix.EINTR {
+					continue
+				}
+
+				// Packets were dropped because the socket buffer overflowed.
+				if err == unix.ENOBUFS {
+					log.Printf("[netlink] NFLOG packets were lost.\n")
+					continue
+				}
+
+				log.Printf("[netlink] NFLOG subscription failed, err=%v\n", err)
+				return
+			}
+
+			for i := range nlMsgs {
+				if nlMsgs[i].Header.Type != unix.NFNL_SUBSYS_ULOG<<8|nfulnlMsgPacket {
+					continue
+				}
+
+				packet, err := deserializeNflogPacket(&nlMsgs[i])
+				if err != nil {
+					log.Printf("[netlink] Ignoring invalid NFLOG packet, err=%v\n", err)
+					continue
+				}
+
+				select {
+				case ch <- *packet:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	return nil
+}
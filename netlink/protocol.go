@@ -55,10 +55,97 @@ const (
 	IFLA_NET_NS_FD   = 28
 	IFLA_IPVLAN_MODE = 1
 	IFLA_BRPORT_MODE = 4
+	IFLA_VRF_TABLE   = 1
 	VETH_INFO_PEER   = 1
 	DEFAULT_CHANGE   = 0xFFFFFFFF
 )
 
+// VXLAN link attributes.
+const (
+	IFLA_VXLAN_ID       = 1
+	IFLA_VXLAN_LINK     = 3
+	IFLA_VXLAN_LOCAL    = 4
+	IFLA_VXLAN_LEARNING = 7
+	IFLA_VXLAN_PORT     = 15
+)
+
+// Geneve link attributes.
+const (
+	IFLA_GENEVE_PORT             = 5
+	IFLA_GENEVE_COLLECT_METADATA = 6
+)
+
+// Lightweight tunnel encapsulation attributes of routes.
+const (
+	RTA_ENCAP_TYPE = 21
+	RTA_ENCAP      = 22
+
+	LWTUNNEL_ENCAP_IP = 2
+
+	LWTUNNEL_IP_ID   = 1
+	LWTUNNEL_IP_DST  = 2
+	LWTUNNEL_IP_SRC  = 3
+	LWTUNNEL_IP_OPTS = 8
+
+	LWTUNNEL_IP_OPTS_GENEVE      = 1
+	LWTUNNEL_IP_OPT_GENEVE_CLASS = 1
+	LWTUNNEL_IP_OPT_GENEVE_TYPE  = 2
+	LWTUNNEL_IP_OPT_GENEVE_DATA  = 3
+)
+
+// Route netlink multicast groups.
+const (
+	RTMGRP_LINK = 0x1
+)
+
+// Policy routing rule attributes and actions.
+const (
+	FRA_DST       = 1
+	FRA_SRC       = 2
+	FRA_IIFNAME   = 3
+	FRA_PRIORITY  = 6
+	FRA_FWMARK    = 10
+	FRA_TABLE     = 15
+	FRA_FWMASK    = 16
+	FRA_OIFNAME   = 17
+	FR_ACT_TO_TBL = 1
+)
+
+// Traffic control attributes.
+const (
+	TCA_KIND    = 1
+	TCA_OPTIONS = 2
+
+	TCA_TBF_PARMS  = 1
+	TCA_TBF_RATE64 = 4
+	TCA_TBF_BURST  = 6
+
+	TCA_HTB_PARMS  = 1
+	TCA_HTB_INIT   = 2
+	TCA_HTB_RATE64 = 6
+	TCA_HTB_CEIL64 = 7
+
+	TCA_FQ_CODEL_TARGET   = 1
+	TCA_FQ_CODEL_LIMIT    = 2
+	TCA_FQ_CODEL_INTERVAL = 3
+	TCA_FQ_CODEL_ECN      = 4
+	TCA_FQ_CODEL_FLOWS    = 5
+
+	TCA_U32_CLASSID = 1
+	TCA_U32_SEL     = 5
+	TCA_FW_CLASSID  = 1
+
+	TCA_BPF_CLASSID = 3
+	TCA_BPF_FD      = 6
+	TCA_BPF_NAME    = 7
+	TCA_BPF_FLAGS   = 8
+
+	TCA_BPF_FLAG_ACT_DIRECT = 1
+
+	TC_U32_TERMINAL       = 1
+	TC_LINKLAYER_ETHERNET = 1
+)
+
 // Serializable types are used to construct netlink messages.
 type serializable interface {
 	serialize() []byte
@@ -182,7 +269,6 @@ func (msg *message) getAttributes(body serializable) []*attribute {
 	return attrs
 }
 
-//
 // Netlink message attribute
 //
 // Creates a new attribute.
@@ -456,3 +542,42 @@ func (rta *rtAttr) length() int {
 func (rta *rtAttr) addChild(attr serializable) {
 	rta.children = append(rta.children, attr)
 }
+
+//
+// Traffic control service module
+//
+
+// Traffic control message
+type tcMsg struct {
+	Family  uint8
+	Ifindex int32
+	Handle  uint32
+	Parent  uint32
+	Info    uint32
+}
+
+// Creates a new traffic control message.
+func newTcMsg(ifIndex int, handle uint32, parent uint32) *tcMsg {
+	return &tcMsg{
+		Family:  unix.AF_UNSPEC,
+		Ifindex: int32(ifIndex),
+		Handle:  handle,
+		Parent:  parent,
+	}
+}
+
+// Serializes a traffic control message.
+func (tc *tcMsg) serialize() []byte {
+	b := make([]byte, tc.length())
+	b[0] = tc.Family
+	encoder.PutUint32(b[4:8], uint32(tc.Ifindex))
+	encoder.PutUint32(b[8:12], tc.Handle)
+	encoder.PutUint32(b[12:16], tc.Parent)
+	encoder.PutUint32(b[16:20], tc.Info)
+	return b
+}
+
+// Returns the length of a traffic control message.
+func (tc *tcMsg) length() int {
+	return 20
+}
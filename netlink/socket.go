@@ -6,15 +6,28 @@
 package netlink
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/retry"
 	"golang.org/x/sys/unix"
 )
 
+// Requests rejected by the kernel because a resource is busy, for example a link still being
+// set up by another process, are retried. The kernel didn't act on them, so they are safe to resend.
+var requestRetryPolicy = retry.Policy{
+	MaxAttempts:  5,
+	InitialDelay: 50 * time.Millisecond,
+	MaxDelay:     time.Second,
+	Jitter:       0.2,
+	IsRetryable:  retry.IsErrno(unix.EBUSY, unix.EAGAIN),
+}
+
 // Represents a netlink socket.
 type socket struct {
 	fd  int
@@ -93,6 +106,19 @@ func (s *socket) send(msg *message) error {
 
 // Sends a netlink message and blocks until its response is received.
 func (s *socket) sendAndWaitForResponse(msg *message) ([]*message, error) {
+	var messages []*message
+
+	err := retry.Do(context.Background(), &requestRetryPolicy, func() error {
+		var err error
+		messages, err = s.request(msg)
+		return err
+	})
+
+	return messages, err
+}
+
+// Sends a netlink message once and blocks until its response is received.
+func (s *socket) request(msg *message) ([]*message, error) {
 	s.Lock()
 	defer s.Unlock()
 
@@ -0,0 +1,472 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build linux
+
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Traffic control object types.
+const (
+	QDISC_TYPE_TBF      = "tbf"
+	QDISC_TYPE_HTB      = "htb"
+	QDISC_TYPE_FQ_CODEL = "fq_codel"
+	QDISC_TYPE_CLSACT   = "clsact"
+	CLASS_TYPE_HTB      = "htb"
+	FILTER_TYPE_U32     = "u32"
+	FILTER_TYPE_FW      = "fw"
+	FILTER_TYPE_BPF     = "bpf"
+)
+
+// Traffic control handles.
+const (
+	TC_H_UNSPEC  = 0
+	TC_H_ROOT    = 0xFFFFFFFF
+	TC_H_INGRESS = 0xFFFFFFF1
+
+	// A clsact queueing discipline has the ffff: handle and the TC_H_CLSACT parent.
+	// Its filters are attached to its ingress or egress hook.
+	TC_H_CLSACT         = TC_H_INGRESS
+	TC_H_CLSACT_INGRESS = 0xFFFFFFF2
+	TC_H_CLSACT_EGRESS  = 0xFFFFFFF3
+)
+
+// Length of the time unit of the kernel packet scheduler, in nanoseconds.
+const pschedTickNs = 64
+
+// MakeHandle returns the traffic control handle of the given major and minor numbers, e.g. 1:10.
+func MakeHandle(major uint16, minor uint16) uint32 {
+	return uint32(major)<<16 | uint32(minor)
+}
+
+// Qdisc represents a queueing discipline.
+type Qdisc interface {
+	Info() *QdiscInfo
+}
+
+// QdiscInfo represents the common properties of all queueing disciplines.
+type QdiscInfo struct {
+	Type     string
+	LinkName string
+	Handle   uint32
+	Parent   uint32
+}
+
+func (qdiscInfo *QdiscInfo) Info() *QdiscInfo {
+	return qdiscInfo
+}
+
+// TbfQdisc represents a token bucket filter shaping traffic to a rate.
+// Rate is in bytes per second, Burst and Limit are in bytes.
+type TbfQdisc struct {
+	QdiscInfo
+	Rate  uint64
+	Burst uint32
+	Limit uint32
+}
+
+// HtbQdisc represents a hierarchy token bucket. Traffic not classified by a filter
+// is sent to the class with the DefaultClass minor handle.
+type HtbQdisc struct {
+	QdiscInfo
+	DefaultClass uint32
+}
+
+// FqCodelQdisc represents a fair queueing controlled delay queue.
+// Target and Interval are in microseconds. Zero values keep the kernel defaults.
+type FqCodelQdisc struct {
+	QdiscInfo
+	Limit    uint32
+	Flows    uint32
+	Target   uint32
+	Interval uint32
+	ECN      bool
+}
+
+// Class represents a class of a classful queueing discipline.
+type Class interface {
+	Info() *ClassInfo
+}
+
+// ClassInfo represents the common properties of all classes.
+type ClassInfo struct {
+	Type     string
+	LinkName string
+	Handle   uint32
+	Parent   uint32
+}
+
+func (classInfo *ClassInfo) Info() *ClassInfo {
+	return classInfo
+}
+
+// HtbClass represents a class of a hierarchy token bucket guaranteed Rate and borrowing up to Ceil.
+// Rates are in bytes per second, Burst is in bytes. Zero Ceil and Burst values are derived from Rate.
+type HtbClass struct {
+	ClassInfo
+	Rate     uint64
+	Ceil     uint64
+	Burst    uint32
+	Priority uint32
+}
+
+// Filter represents a traffic control filter sending the packets it matches to a class.
+type Filter interface {
+	Info() *FilterInfo
+}
+
+// FilterInfo represents the common properties of all filters.
+// Protocol is the ethernet protocol of the packets to match, all protocols if zero.
+type FilterInfo struct {
+	Type     string
+	LinkName string
+	Handle   uint32
+	Parent   uint32
+	Priority uint16
+	Protocol uint16
+	ClassId  uint32
+}
+
+func (filterInfo *FilterInfo) Info() *FilterInfo {
+	return filterInfo
+}
+
+// U32Filter represents a filter matching IPv4 packets by source and destination subnets.
+// A filter without subnets matches all IPv4 packets.
+type U32Filter struct {
+	FilterInfo
+	Src *net.IPNet
+	Dst *net.IPNet
+}
+
+// FwFilter represents a filter matching packets by the firewall mark in its Handle.
+type FwFilter struct {
+	FilterInfo
+}
+
+// BpfFilter represents a filter running the eBPF classifier program with file descriptor Fd.
+// In direct action mode the result of the program is the action taken on the packet, e.g. whether it's dropped.
+type BpfFilter struct {
+	FilterInfo
+	Fd           int
+	Name         string
+	DirectAction bool
+}
+
+// Returns the host order value of a protocol number in network byte order.
+func htons(value uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, value)
+	return encoder.Uint16(b)
+}
+
+// Returns the time to send size bytes at the given rate, in scheduler ticks.
+func transmitTime(rate uint64, size uint32) uint32 {
+	if rate == 0 {
+		return 0
+	}
+
+	return uint32(uint64(size) * 1000000000 / rate / pschedTickNs)
+}
+
+// Serializes a rate spec at the start of the given buffer.
+func serializeRateSpec(b []byte, rate uint64) {
+	b[1] = TC_LINKLAYER_ETHERNET
+	if rate > 0xFFFFFFFF {
+		rate = 0xFFFFFFFF
+	}
+	encoder.PutUint32(b[8:12], uint32(rate))
+}
+
+// setTc sends a traffic control object set request.
+func setTc(msgType int, flags int, linkName string, kind string, handle uint32, parent uint32, info uint32, options *attribute) error {
+	iface, err := net.InterfaceByName(linkName)
+	if err != nil {
+		return err
+	}
+
+	s, err := getSocket()
+	if err != nil {
+		return err
+	}
+
+	req := newRequest(msgType, flags|unix.NLM_F_ACK)
+
+	tc := newTcMsg(iface.Index, handle, parent)
+	tc.Info = info
+	req.addPayload(tc)
+
+	if kind != "" {
+		req.addPayload(newAttributeStringZ(TCA_KIND, kind))
+	}
+
+	if options != nil {
+		req.addPayload(options)
+	}
+
+	return s.sendAndWaitForAck(req)
+}
+
+// Returns the options attribute of a queueing discipline.
+func getQdiscOptions(qdisc Qdisc) (*attribute, error) {
+	options := newAttribute(TCA_OPTIONS, nil)
+
+	switch q := qdisc.(type) {
+	case *TbfQdisc:
+		if q.Rate == 0 || q.Burst == 0 || q.Limit == 0 {
+			return nil, fmt.Errorf("Invalid tbf rate, burst or limit")
+		}
+
+		parms := make([]byte, 36)
+		serializeRateSpec(parms[0:12], q.Rate)
+		encoder.PutUint32(parms[24:28], q.Limit)
+		encoder.PutUint32(parms[28:32], transmitTime(q.Rate, q.Burst))
+		options.addNested(newAttribute(TCA_TBF_PARMS, parms))
+		options.addNested(newAttributeUint32(TCA_TBF_BURST, q.Burst))
+
+		if q.Rate > 0xFFFFFFFF {
+			rate := make([]byte, 8)
+			encoder.PutUint64(rate, q.Rate)
+			options.addNested(newAttribute(TCA_TBF_RATE64, rate))
+		}
+
+	case *HtbQdisc:
+		init := make([]byte, 20)
+		encoder.PutUint32(init[0:4], 3)  // Version.
+		encoder.PutUint32(init[4:8], 10) // Rate to quantum.
+		encoder.PutUint32(init[8:12], q.DefaultClass)
+		options.addNested(newAttribute(TCA_HTB_INIT, init))
+
+	case *FqCodelQdisc:
+		if q.Limit != 0 {
+			options.addNested(newAttributeUint32(TCA_FQ_CODEL_LIMIT, q.Limit))
+		}
+		if q.Flows != 0 {
+			options.addNested(newAttributeUint32(TCA_FQ_CODEL_FLOWS, q.Flows))
+		}
+		if q.Target != 0 {
+			options.addNested(newAttributeUint32(TCA_FQ_CODEL_TARGET, q.Target))
+		}
+		if q.Interval != 0 {
+			options.addNested(newAttributeUint32(TCA_FQ_CODEL_INTERVAL, q.Interval))
+		}
+
+		ecn := uint32(0)
+		if q.ECN {
+			ecn = 1
+		}
+		options.addNested(newAttributeUint32(TCA_FQ_CODEL_ECN, ecn))
+
+	default:
+		return nil, nil
+	}
+
+	return options, nil
+}
+
+// AddQdisc adds a queueing discipline to a network interface.
+func AddQdisc(qdisc Qdisc) error {
+	info := qdisc.Info()
+
+	if info.LinkName == "" || info.Type == "" {
+		return fmt.Errorf("Invalid qdisc link name or type")
+	}
+
+	options, err := getQdiscOptions(qdisc)
+	if err != nil {
+		return err
+	}
+
+	return setTc(unix.RTM_NEWQDISC, unix.NLM_F_CREATE|unix.NLM_F_EXCL,
+		info.LinkName, info.Type, info.Handle, info.Parent, 0, options)
+}
+
+// DeleteQdisc deletes a queueing discipline, with its classes and filters, from a network interface.
+func DeleteQdisc(qdisc Qdisc) error {
+	info := qdisc.Info()
+	return setTc(unix.RTM_DELQDISC, 0, info.LinkName, "", info.Handle, info.Parent, 0, nil)
+}
+
+// AddClass adds a class to a classful queueing discipline.
+func AddClass(class Class) error {
+	info := class.Info()
+
+	if info.LinkName == "" || info.Type == "" {
+		return fmt.Errorf("Invalid class link name or type")
+	}
+
+	options := newAttribute(TCA_OPTIONS, nil)
+
+	if htb, ok := class.(*HtbClass); ok {
+		if htb.Rate == 0 {
+			return fmt.Errorf("Invalid htb class rate")
+		}
+
+		ceil := htb.Ceil
+		if ceil == 0 {
+			ceil = htb.Rate
+		}
+
+		// By default allow bursts of the traffic sent in 10ms plus a full sized frame.
+		burst := htb.Burst
+		if burst == 0 {
+			burst = uint32(htb.Rate/100) + 1600
+		}
+
+		parms := make([]byte, 44)
+		serializeRateSpec(parms[0:12], htb.Rate)
+		serializeRateSpec(parms[12:24], ceil)
+		encoder.PutUint32(parms[24:28], transmitTime(htb.Rate, burst))
+		encoder.PutUint32(parms[28:32], transmitTime(ceil, burst))
+		encoder.PutUint32(parms[40:44], htb.Priority)
+		options.addNested(newAttribute(TCA_HTB_PARMS, parms))
+
+		if htb.Rate > 0xFFFFFFFF {
+			rate := make([]byte, 8)
+			encoder.PutUint64(rate, htb.Rate)
+			options.addNested(newAttribute(TCA_HTB_RATE64, rate))
+		}
+
+		if ceil > 0xFFFFFFFF {
+			rate := make([]byte, 8)
+			encoder.PutUint64(rate, ceil)
+			options.addNested(newAttribute(TCA_HTB_CEIL64, rate))
+		}
+	}
+
+	return setTc(unix.RTM_NEWTCLASS, unix.NLM_F_CREATE|unix.NLM_F_EXCL,
+		info.LinkName, info.Type, info.Handle, info.Parent, 0, options)
+}
+
+// DeleteClass deletes a class from a classful queueing discipline.
+func DeleteClass(class Class) error {
+	info := class.Info()
+	return setTc(unix.RTM_DELTCLASS, 0, info.LinkName, "", info.Handle, info.Parent, 0, nil)
+}
+
+// Serializes a u32 selector key matching an IPv4 subnet at the given offset of the IP header.
+func serializeU32Key(b []byte, subnet *net.IPNet, offset int32) {
+	if subnet != nil {
+		ip, mask := subnet.IP.To4(), net.IP(subnet.Mask).To4()
+		if ip != nil && mask != nil {
+			binary.BigEndian.PutUint32(b[0:4], binary.BigEndian.Uint32(mask))
+			binary.BigEndian.PutUint32(b[4:8], binary.BigEndian.Uint32(ip.Mask(subnet.Mask)))
+		}
+	}
+
+	encoder.PutUint32(b[8:12], uint32(offset))
+}
+
+// Returns the options attribute of a filter.
+func getFilterOptions(filter Filter) (*attribute, error) {
+	info := filter.Info()
+	options := newAttribute(TCA_OPTIONS, nil)
+
+	switch f := filter.(type) {
+	case *U32Filter:
+		// Each key matches a 32 bit word of the IP header. Match all packets if there are no keys.
+		var keys []*net.IPNet
+		var offsets []int32
+		if f.Src != nil {
+			keys, offsets = append(keys, f.Src), append(offsets, 12)
+		}
+		if f.Dst != nil {
+			keys, offsets = append(keys, f.Dst), append(offsets, 16)
+		}
+		if len(keys) == 0 {
+			keys, offsets = append(keys, nil), append(offsets, 0)
+		}
+
+		sel := make([]byte, 16+16*len(keys))
+		sel[0] = TC_U32_TERMINAL
+		sel[2] = uint8(len(keys))
+		for i := range keys {
+			serializeU32Key(sel[16+16*i:], keys[i], offsets[i])
+		}
+
+		options.addNested(newAttributeUint32(TCA_U32_CLASSID, info.ClassId))
+		options.addNested(newAttribute(TCA_U32_SEL, sel))
+
+	case *FwFilter:
+		if info.Handle == 0 {
+			return nil, fmt.Errorf("Invalid fw filter mark")
+		}
+
+		options.addNested(newAttributeUint32(TCA_FW_CLASSID, info.ClassId))
+
+	case *BpfFilter:
+		if f.Fd <= 0 {
+			return nil, fmt.Errorf("Invalid bpf filter program")
+		}
+
+		options.addNested(newAttributeUint32(TCA_BPF_FD, uint32(f.Fd)))
+		if f.Name != "" {
+			options.addNested(newAttributeStringZ(TCA_BPF_NAME, f.Name))
+		}
+		if f.DirectAction {
+			options.addNested(newAttributeUint32(TCA_BPF_FLAGS, TCA_BPF_FLAG_ACT_DIRECT))
+		} else if info.ClassId != 0 {
+			options.addNested(newAttributeUint32(TCA_BPF_CLASSID, info.ClassId))
+		}
+
+	default:
+		return nil, nil
+	}
+
+	return options, nil
+}
+
+// AddFilter adds a filter to a queueing discipline.
+func AddFilter(filter Filter) error {
+	return setFilter(filter, unix.NLM_F_CREATE|unix.NLM_F_EXCL)
+}
+
+// ReplaceFilter adds a filter to a queueing discipline, or replaces the filter with the same handle and priority.
+// Packets are classified by either filter while it is replaced.
+func ReplaceFilter(filter Filter) error {
+	return setFilter(filter, unix.NLM_F_CREATE|unix.NLM_F_REPLACE)
+}
+
+// setFilter sends a filter set request.
+func setFilter(filter Filter, flags int) error {
+	info := filter.Info()
+
+	if info.LinkName == "" || info.Type == "" {
+		return fmt.Errorf("Invalid filter link name or type")
+	}
+
+	options, err := getFilterOptions(filter)
+	if err != nil {
+		return err
+	}
+
+	protocol := info.Protocol
+	if protocol == 0 {
+		if _, ok := filter.(*U32Filter); ok {
+			protocol = unix.ETH_P_IP
+		} else {
+			protocol = unix.ETH_P_ALL
+		}
+	}
+
+	return setTc(unix.RTM_NEWTFILTER, flags,
+		info.LinkName, info.Type, info.Handle, info.Parent, getFilterInfo(info.Priority, protocol), options)
+}
+
+// DeleteFilter deletes the filters of a priority from a queueing discipline.
+func DeleteFilter(filter Filter) error {
+	info := filter.Info()
+	return setTc(unix.RTM_DELTFILTER, 0, info.LinkName, "", 0, info.Parent, getFilterInfo(info.Priority, info.Protocol), nil)
+}
+
+// Returns the info field of a filter message holding its priority and protocol.
+func getFilterInfo(priority uint16, protocol uint16) uint32 {
+	return uint32(priority)<<16 | uint32(htons(protocol))
+}
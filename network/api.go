@@ -9,14 +9,23 @@ import (
 
 var (
 	// Error responses returned by NetworkManager.
-	errSubnetNotFound         = fmt.Errorf("Subnet not found")
-	errNetworkModeInvalid     = fmt.Errorf("Network mode is invalid")
-	errNetworkExists          = fmt.Errorf("Network already exists")
-	errNetworkNotFound        = fmt.Errorf("Network not found")
-	errEndpointExists         = fmt.Errorf("Endpoint already exists")
-	errEndpointNotFound       = fmt.Errorf("Endpoint not found")
-	errNamespaceNotFound      = fmt.Errorf("Namespace not found")
-	errMultipleEndpointsFound = fmt.Errorf("Multiple endpoints found")
-	errEndpointInUse          = fmt.Errorf("Endpoint is already joined to a sandbox")
-	errEndpointNotInUse       = fmt.Errorf("Endpoint is not joined to a sandbox")
+	errSubnetNotFound            = fmt.Errorf("Subnet not found")
+	errNetworkModeInvalid        = fmt.Errorf("Network mode is invalid")
+	errNetworkTypeInvalid        = fmt.Errorf("Network type is invalid")
+	errNetworkExists             = fmt.Errorf("Network already exists")
+	errNetworkNotFound           = fmt.Errorf("Network not found")
+	errEndpointExists            = fmt.Errorf("Endpoint already exists")
+	errEndpointNotFound          = fmt.Errorf("Endpoint not found")
+	errNamespaceNotFound         = fmt.Errorf("Namespace not found")
+	errMultipleEndpointsFound    = fmt.Errorf("Multiple endpoints found")
+	errEndpointInUse             = fmt.Errorf("Endpoint is already joined to a sandbox")
+	errEndpointNotInUse          = fmt.Errorf("Endpoint is not joined to a sandbox")
+	errSubnetNotSupported        = fmt.Errorf("Adding subnets to an existing network is not supported")
+	errLoopbackDSRNotSupported   = fmt.Errorf("Loopback DSR requires the HCN API")
+	errIPv6NotSupported          = fmt.Errorf("IPv6 endpoints require the HCN API")
+	errNICAttachmentNotSupported = fmt.Errorf("Attaching host NICs to containers is not supported on this platform")
+	errOverlayVtepsNotSupported  = fmt.Errorf("Setting the remote VTEPs of overlay networks is not supported on this platform")
+	errVlanIDInvalid             = fmt.Errorf("VLAN ID is invalid")
+	errSwitchdevModeRequired     = fmt.Errorf("Hardware offload requires the host NIC in switchdev mode")
+	errNoFreeVF                  = fmt.Errorf("No free VF of the host NIC is available")
 )
@@ -1,12 +1,14 @@
 package network
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/Azure/azure-container-networking/ebtables"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/netlink"
 	"github.com/Azure/azure-container-networking/network/epcommon"
+	"github.com/Azure/azure-container-networking/platform"
 )
 
 type LinuxBridgeEndpointClient struct {
@@ -60,19 +62,8 @@ func (client *LinuxBridgeEndpointClient) AddEndpointRules(epInfo *EndpointInfo)
 		return err
 	}
 
+	// The ARP reply and MAC DNAT rules of the endpoint are programmed by syncL2RulesImpl.
 	for _, ipAddr := range epInfo.IPAddresses {
-		// Add ARP reply rule.
-		log.Printf("[net] Adding ARP reply rule for IP address %v", ipAddr.String())
-		if err = ebtables.SetArpReply(ipAddr.IP, client.getArpReplyAddress(client.containerMac), ebtables.Append); err != nil {
-			return err
-		}
-
-		// Add MAC address translation rule.
-		log.Printf("[net] Adding MAC DNAT rule for IP address %v", ipAddr.String())
-		if err := ebtables.SetDnatForIPAddress(client.hostPrimaryIfName, ipAddr.IP, client.containerMac, ebtables.Append); err != nil {
-			return err
-		}
-
 		if client.mode != opModeTunnel {
 			log.Printf("[net] Adding static arp for IP address %v and MAC %v in VM", ipAddr.String(), client.containerMac.String())
 			netlink.AddOrRemoveStaticArp(netlink.ADD, client.bridgeName, ipAddr.IP, client.containerMac)
@@ -80,6 +71,21 @@ func (client *LinuxBridgeEndpointClient) AddEndpointRules(epInfo *EndpointInfo)
 				log.Printf("Failed setting arp in vm: %v", err)
 			}
 		}
+
+		// IPv6 addresses are resolved by neighbor discovery, which the host answers for the endpoint
+		// like the ARP reply rules do for IPv4, so that the endpoint is reachable from the VNet.
+		if ipAddr.IP.To4() == nil {
+			if err := setNdpProxy(client.bridgeName); err != nil {
+				log.Printf("[net] Failed to enable NDP proxy on %v: %v.", client.bridgeName, err)
+				return err
+			}
+
+			log.Printf("[net] Adding NDP proxy entry for IP address %v on %v.", ipAddr.IP, client.bridgeName)
+			if err := netlink.AddOrRemoveProxyNeighbor(netlink.ADD, client.bridgeName, ipAddr.IP); err != nil {
+				log.Printf("[net] Failed to add NDP proxy entry for IP address %v: %v.", ipAddr.IP, err)
+				return err
+			}
+		}
 	}
 
 	log.Printf("[net] Setting hairpin for hostveth %v", client.hostVethName)
@@ -93,31 +99,57 @@ func (client *LinuxBridgeEndpointClient) AddEndpointRules(epInfo *EndpointInfo)
 
 func (client *LinuxBridgeEndpointClient) DeleteEndpointRules(ep *endpoint) {
 	// Delete rules for IP addresses on the container interface.
+	// The ARP reply and MAC DNAT rules of the endpoint are removed by syncL2RulesImpl.
 	for _, ipAddr := range ep.IPAddresses {
-		// Delete ARP reply rule.
-		log.Printf("[net] Deleting ARP reply rule for IP address %v on %v.", ipAddr.String(), ep.Id)
-		err := ebtables.SetArpReply(ipAddr.IP, client.getArpReplyAddress(ep.MacAddress), ebtables.Delete)
-		if err != nil {
-			log.Printf("[net] Failed to delete ARP reply rule for IP address %v: %v.", ipAddr.String(), err)
-		}
-
-		// Delete MAC address translation rule.
-		log.Printf("[net] Deleting MAC DNAT rule for IP address %v on %v.", ipAddr.String(), ep.Id)
-		err = ebtables.SetDnatForIPAddress(client.hostPrimaryIfName, ipAddr.IP, ep.MacAddress, ebtables.Delete)
-		if err != nil {
-			log.Printf("[net] Failed to delete MAC DNAT rule for IP address %v: %v.", ipAddr.String(), err)
-		}
-
 		if client.mode != opModeTunnel {
 			log.Printf("[net] Removing static arp for IP address %v and MAC %v from VM", ipAddr.String(), ep.MacAddress.String())
-			netlink.AddOrRemoveStaticArp(netlink.REMOVE, client.bridgeName, ipAddr.IP, ep.MacAddress)
-			if err != nil {
+			if err := netlink.AddOrRemoveStaticArp(netlink.REMOVE, client.bridgeName, ipAddr.IP, ep.MacAddress); err != nil {
 				log.Printf("Failed removing arp from vm: %v", err)
 			}
 		}
+
+		if ipAddr.IP.To4() == nil {
+			log.Printf("[net] Removing NDP proxy entry for IP address %v from %v.", ipAddr.IP, client.bridgeName)
+			if err := netlink.AddOrRemoveProxyNeighbor(netlink.REMOVE, client.bridgeName, ipAddr.IP); err != nil {
+				log.Printf("[net] Failed to remove NDP proxy entry for IP address %v: %v.", ipAddr.IP, err)
+			}
+		}
 	}
 }
 
+// setNdpProxy makes the host answer neighbor solicitations received on an interface for its proxy entries.
+// The kernel only proxies on forwarding interfaces, which keep accepting router advertisements.
+func setNdpProxy(ifName string) error {
+	for _, setting := range []string{"accept_ra", "forwarding", "proxy_ndp"} {
+		value := 1
+		if setting == "accept_ra" {
+			value = 2
+		}
+
+		cmd := fmt.Sprintf("echo %d > /proc/sys/net/ipv6/conf/%v/%v", value, ifName, setting)
+		if _, err := platform.ExecuteCommand(cmd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getEndpointRules returns the ebtables rules of an endpoint.
+func (client *LinuxBridgeEndpointClient) getEndpointRules(ep *endpoint) []ebtables.Rule {
+	var rules []ebtables.Rule
+
+	for _, ipAddr := range ep.IPAddresses {
+		// IPv6 addresses are resolved by neighbor discovery, which the host proxies for the endpoint.
+		if ipAddr.IP.To4() != nil {
+			rules = append(rules, ebtables.ArpReplyRule(ipAddr.IP, client.getArpReplyAddress(ep.MacAddress)))
+		}
+		rules = append(rules, ebtables.DnatForIPAddressRule(client.hostPrimaryIfName, ipAddr.IP, ep.MacAddress))
+	}
+
+	return rules
+}
+
 // getArpReplyAddress returns the MAC address to use in ARP replies.
 func (client *LinuxBridgeEndpointClient) getArpReplyAddress(epMacAddress net.HardwareAddr) net.HardwareAddr {
 	var macAddress net.HardwareAddr
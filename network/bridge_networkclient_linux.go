@@ -1,8 +1,6 @@
 package network
 
 import (
-	"net"
-
 	"github.com/Azure/azure-container-networking/ebtables"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/netlink"
@@ -53,49 +51,36 @@ func (client *LinuxBridgeClient) DeleteBridge() error {
 	return nil
 }
 
+// AddL2Rules is a no-op, the ebtables rules of the bridge are programmed by syncL2RulesImpl.
 func (client *LinuxBridgeClient) AddL2Rules(extIf *externalInterface) error {
-	hostIf, err := net.InterfaceByName(client.hostInterfaceName)
-	if err != nil {
-		return err
-	}
+	return nil
+}
 
-	// Add SNAT rule to translate container egress traffic.
-	log.Printf("[net] Adding SNAT rule for egress traffic on %v.", client.hostInterfaceName)
-	if err := ebtables.SetSnatForInterface(client.hostInterfaceName, hostIf.HardwareAddr, ebtables.Append); err != nil {
-		return err
-	}
+// DeleteL2Rules is a no-op, the ebtables rules of the bridge are removed by syncL2RulesImpl.
+func (client *LinuxBridgeClient) DeleteL2Rules(extIf *externalInterface) {
+}
+
+// getL2Rules returns the ebtables rules of the bridge.
+func (client *LinuxBridgeClient) getL2Rules(extIf *externalInterface) []ebtables.Rule {
+	// SNAT rule to translate container egress traffic.
+	rules := []ebtables.Rule{ebtables.SnatForInterfaceRule(client.hostInterfaceName, extIf.MacAddress)}
 
-	// Add ARP reply rule for host primary IP address.
+	// ARP reply rule for host primary IP address.
 	// ARP requests for all IP addresses are forwarded to the SDN fabric, but fabric
 	// doesn't respond to ARP requests from the VM for its own primary IP address.
-	primary := extIf.IPAddresses[0].IP
-	log.Printf("[net] Adding ARP reply rule for primary IP address %v.", primary)
-	if err := ebtables.SetArpReply(primary, hostIf.HardwareAddr, ebtables.Append); err != nil {
-		return err
+	if len(extIf.IPAddresses) > 0 {
+		rules = append(rules, ebtables.ArpReplyRule(extIf.IPAddresses[0].IP, extIf.MacAddress))
 	}
 
-	// Add DNAT rule to forward ARP replies to container interfaces.
-	log.Printf("[net] Adding DNAT rule for ingress ARP traffic on interface %v.", client.hostInterfaceName)
-	if err := ebtables.SetDnatForArpReplies(client.hostInterfaceName, ebtables.Append); err != nil {
-		return err
-	}
+	// DNAT rule to forward ARP replies to container interfaces.
+	rules = append(rules, ebtables.DnatForArpRepliesRule(client.hostInterfaceName))
 
-	// Enable VEPA for host policy enforcement if necessary.
+	// VEPA for host policy enforcement if necessary.
 	if client.mode == opModeTunnel {
-		log.Printf("[net] Enabling VEPA mode for %v.", client.hostInterfaceName)
-		if err := ebtables.SetVepaMode(client.bridgeName, commonInterfacePrefix, virtualMacAddress, ebtables.Append); err != nil {
-			return err
-		}
+		rules = append(rules, ebtables.VepaModeRules(client.bridgeName, commonInterfacePrefix, virtualMacAddress)...)
 	}
 
-	return nil
-}
-
-func (client *LinuxBridgeClient) DeleteL2Rules(extIf *externalInterface) {
-	ebtables.SetVepaMode(client.bridgeName, commonInterfacePrefix, virtualMacAddress, ebtables.Delete)
-	ebtables.SetDnatForArpReplies(extIf.Name, ebtables.Delete)
-	ebtables.SetArpReply(extIf.IPAddresses[0].IP, extIf.MacAddress, ebtables.Delete)
-	ebtables.SetSnatForInterface(extIf.Name, extIf.MacAddress, ebtables.Delete)
+	return rules
 }
 
 func (client *LinuxBridgeClient) SetBridgeMasterToHostInterface() error {
@@ -0,0 +1,73 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/platform"
+	"github.com/Azure/azure-container-networking/store"
+)
+
+// HostState is a part of the state of the host, such as the output of a command listing its links.
+type HostState struct {
+	Name   string
+	Output string
+}
+
+// StateDump is the persisted state of the network manager, the state of the host, and the
+// inconsistencies between them.
+type StateDump struct {
+	State           interface{}
+	Host            []HostState
+	Inconsistencies []string
+}
+
+// DumpState reads the state of the network manager from a store without changing it, and
+// compares it with the host.
+func DumpState(kvs store.KeyValueStore) (*StateDump, error) {
+	nm := &networkManager{
+		ExternalInterfaces: make(map[string]*externalInterface),
+	}
+
+	if err := kvs.Read(storeKey, nm); err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+
+	for _, extIf := range nm.ExternalInterfaces {
+		for _, nw := range extIf.Networks {
+			nw.extIf = extIf
+		}
+	}
+
+	dump := &StateDump{
+		State:           nm,
+		Host:            getHostStateImpl(),
+		Inconsistencies: nm.checkStateImpl(),
+	}
+
+	return dump, nil
+}
+
+// runHostCommands runs commands listing the state of the host. The error of a command that fails
+// is kept as its output.
+func runHostCommands(commands [][]string) []HostState {
+	var states []HostState
+
+	for _, command := range commands {
+		state := HostState{Name: strings.Join(command, " ")}
+
+		out, err := platform.ExecuteCommandContext(context.Background(), nil, command[0], command[1:]...)
+		if err != nil {
+			state.Output = err.Error()
+		} else {
+			state.Output = out.Stdout
+		}
+
+		states = append(states, state)
+	}
+
+	return states
+}
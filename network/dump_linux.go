@@ -0,0 +1,121 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/ebtables"
+	"github.com/Azure/azure-container-networking/iptables"
+	"github.com/Azure/azure-container-networking/nftables"
+)
+
+// getHostStateImpl returns the links, addresses, routes and rules of the host.
+func getHostStateImpl() []HostState {
+	states := runHostCommands([][]string{
+		{"ip", "-d", "link", "show"},
+		{"ip", "address", "show"},
+		{"ip", "route", "show", "table", "all"},
+		{"ip", "rule", "show"},
+		{"ebtables-save"},
+		{"nft", "list", "table", "bridge", nftables.TableName},
+	})
+
+	state := HostState{Name: "iptables-save -t nat"}
+	if out, err := iptables.GetClient().Save(iptables.Nat); err != nil {
+		state.Output = err.Error()
+	} else {
+		state.Output = string(out)
+	}
+
+	return append(states, state)
+}
+
+// checkStateImpl returns the inconsistencies between the state of the network manager and the host.
+func (nm *networkManager) checkStateImpl() []string {
+	var issues []string
+	hostIfNames := make(map[string]bool)
+
+	for _, extIf := range nm.ExternalInterfaces {
+		if _, err := net.InterfaceByName(extIf.Name); err != nil {
+			issues = append(issues, fmt.Sprintf("External interface %s is missing", extIf.Name))
+		}
+
+		if extIf.BridgeName != "" {
+			if _, err := net.InterfaceByName(extIf.BridgeName); err != nil {
+				issues = append(issues, fmt.Sprintf("Bridge %s of external interface %s is missing", extIf.BridgeName, extIf.Name))
+			}
+		}
+
+		for _, nw := range extIf.Networks {
+			for _, ep := range nw.Endpoints {
+				if ep.HostIfName != "" {
+					hostIfNames[ep.HostIfName] = true
+
+					if _, err := net.InterfaceByName(ep.HostIfName); err != nil {
+						issues = append(issues, fmt.Sprintf("Host interface %s of endpoint %s in network %s is missing", ep.HostIfName, ep.Id, nw.Id))
+					}
+				}
+
+				for _, nsPath := range []string{ep.NetworkNameSpace, ep.SandboxKey} {
+					if nsPath == "" {
+						continue
+					}
+
+					if _, err := os.Stat(nsPath); os.IsNotExist(err) {
+						issues = append(issues, fmt.Sprintf("Network namespace %s of endpoint %s in network %s is missing", nsPath, ep.Id, nw.Id))
+					}
+				}
+			}
+		}
+	}
+
+	// Host interfaces of endpoints missing from the state were left behind.
+	if interfaces, err := net.Interfaces(); err == nil {
+		for _, iface := range interfaces {
+			if !strings.HasPrefix(iface.Name, hostVEthInterfacePrefix) ||
+				strings.HasPrefix(iface.Name, snatVethInterfacePrefix) ||
+				strings.HasPrefix(iface.Name, infraVethInterfacePrefix) ||
+				strings.HasPrefix(iface.Name, vxlanInterfacePrefix) {
+				continue
+			}
+
+			if !hostIfNames[iface.Name] {
+				issues = append(issues, fmt.Sprintf("Interface %s doesn't belong to any endpoint", iface.Name))
+			}
+		}
+	}
+
+	issues = append(issues, checkL2Rules(bridgeDatapathEbtables, nm.getL2Rules(bridgeDatapathEbtables), ebtables.DiffRules)...)
+	issues = append(issues, checkL2Rules(bridgeDatapathNftables, nm.getL2Rules(bridgeDatapathNftables), nftables.DiffRules)...)
+
+	return issues
+}
+
+// checkL2Rules returns the rules of a datapath that are missing or don't belong to any network or endpoint.
+func checkL2Rules(datapath string, rules []ebtables.Rule, diffRules func([]ebtables.Rule) ([]ebtables.Rule, []ebtables.Rule, error)) []string {
+	var issues []string
+
+	// Hosts without Linux bridge networks using the datapath may not have its tools.
+	missing, extra, err := diffRules(rules)
+	if err != nil {
+		if len(rules) > 0 {
+			issues = append(issues, fmt.Sprintf("Failed to list %s rules: %v", datapath, err))
+		}
+		return issues
+	}
+
+	for _, rule := range missing {
+		issues = append(issues, fmt.Sprintf("%s %s rule %q is missing", datapath, rule.Chain, rule.Spec))
+	}
+
+	for _, rule := range extra {
+		issues = append(issues, fmt.Sprintf("%s %s rule %q doesn't belong to any network or endpoint", datapath, rule.Chain, rule.Spec))
+	}
+
+	return issues
+}
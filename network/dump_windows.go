@@ -0,0 +1,103 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Microsoft/hcsshim"
+)
+
+// getHostStateImpl returns the HNS networks and endpoints of the host.
+func getHostStateImpl() []HostState {
+	var states []HostState
+
+	networks, err := hcsshim.HNSListNetworkRequest("GET", "", "")
+	states = append(states, newHNSState("HNS networks", networks, err))
+
+	endpoints, err := hcsshim.HNSListEndpointRequest()
+	states = append(states, newHNSState("HNS endpoints", endpoints, err))
+
+	return states
+}
+
+// newHNSState returns the HNS objects listed by a request, or the error of the request.
+func newHNSState(name string, objects interface{}, err error) HostState {
+	state := HostState{Name: name}
+
+	if err == nil {
+		var buf []byte
+		if buf, err = json.MarshalIndent(objects, "", "  "); err == nil {
+			state.Output = string(buf)
+		}
+	}
+
+	if err != nil {
+		state.Output = err.Error()
+	}
+
+	return state
+}
+
+// checkStateImpl returns the inconsistencies between the state of the network manager and HNS.
+// HNS and HCN don't agree on the case of IDs, so they are compared in lower case.
+func (nm *networkManager) checkStateImpl() []string {
+	networks, err := hcsshim.HNSListNetworkRequest("GET", "", "")
+	if err != nil {
+		return []string{fmt.Sprintf("Failed to list HNS networks: %v", err)}
+	}
+
+	endpoints, err := hcsshim.HNSListEndpointRequest()
+	if err != nil {
+		return []string{fmt.Sprintf("Failed to list HNS endpoints: %v", err)}
+	}
+
+	hnsNetworks := make(map[string]bool)
+	for _, hnsNetwork := range networks {
+		hnsNetworks[strings.ToLower(hnsNetwork.Id)] = true
+	}
+
+	hnsEndpoints := make(map[string]bool)
+	for _, hnsEndpoint := range endpoints {
+		hnsEndpoints[strings.ToLower(hnsEndpoint.Id)] = true
+	}
+
+	var issues []string
+	hnsIds := make(map[string]bool)
+
+	for _, extIf := range nm.ExternalInterfaces {
+		for _, nw := range extIf.Networks {
+			if nw.HnsId != "" && !hnsNetworks[strings.ToLower(nw.HnsId)] {
+				issues = append(issues, fmt.Sprintf("HNS network %s of network %s is missing", nw.HnsId, nw.Id))
+			}
+
+			for _, ep := range nw.Endpoints {
+				if ep.HnsId == "" {
+					continue
+				}
+
+				hnsIds[strings.ToLower(ep.HnsId)] = true
+
+				if !hnsEndpoints[strings.ToLower(ep.HnsId)] {
+					issues = append(issues, fmt.Sprintf("HNS endpoint %s of endpoint %s in network %s is missing", ep.HnsId, ep.Id, nw.Id))
+				}
+			}
+		}
+	}
+
+	// HNS endpoints of the networks that aren't in the state were left behind.
+	for _, extIf := range nm.ExternalInterfaces {
+		for _, nw := range extIf.Networks {
+			for _, hnsEndpoint := range endpoints {
+				if nw.HnsId != "" && strings.EqualFold(hnsEndpoint.VirtualNetwork, nw.HnsId) && !hnsIds[strings.ToLower(hnsEndpoint.Id)] {
+					issues = append(issues, fmt.Sprintf("HNS endpoint %s (%s) of network %s doesn't belong to any endpoint", hnsEndpoint.Id, hnsEndpoint.Name, nw.Id))
+				}
+			}
+		}
+	}
+
+	return issues
+}
@@ -0,0 +1,98 @@
+package network
+
+import (
+	"net"
+
+	"github.com/Azure/azure-container-networking/iptables"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// getEgressIPSpec returns the rule SNATing the traffic of a container address to its egress address.
+// As with outbound SNAT, traffic to the host, wireserver and the subnet of the container isn't SNATed.
+func getEgressIPSpec(egressIP net.IP, ipAddr net.IPNet) []string {
+	subnet := net.IPNet{IP: ipAddr.IP.Mask(ipAddr.Mask), Mask: ipAddr.Mask}
+
+	return []string{
+		"-s", ipAddr.IP.String(),
+		"-m", "iprange", "!", "--dst-range", "168.63.129.16",
+		"-m", "addrtype", "!", "--dst-type", "local",
+		"!", "-d", subnet.String(),
+		"-j", "SNAT", "--to-source", egressIP.String(),
+	}
+}
+
+// getEgressIPExclusionSpec returns the rule keeping the traffic of a container address to a destination from being SNATed.
+func getEgressIPExclusionSpec(ipAddr net.IPNet, exclusion string) []string {
+	return []string{"-s", ipAddr.IP.String(), "-d", exclusion, "-j", "RETURN"}
+}
+
+// addEgressIPRules SNATs the traffic of the IPv4 addresses of a container to its egress address, if any,
+// except to the excluded destinations.
+func addEgressIPRules(egressIP net.IP, ipAddresses []net.IPNet, exclusions []string) error {
+	if egressIP == nil {
+		return nil
+	}
+
+	client := iptables.GetClient()
+
+	for _, ipAddr := range ipAddresses {
+		if ipAddr.IP.To4() == nil {
+			continue
+		}
+
+		// Insert the rule ahead of the rules masquerading the traffic of the node.
+		spec := getEgressIPSpec(egressIP, ipAddr)
+		if !client.Exists(iptables.Nat, "POSTROUTING", spec...) {
+			log.Printf("[net] Adding egress IP rule %v.", spec)
+			if err := client.Insert(iptables.Nat, "POSTROUTING", 1, spec...); err != nil {
+				return err
+			}
+		}
+
+		// Insert the exclusions ahead of the rule.
+		for _, exclusion := range exclusions {
+			spec = getEgressIPExclusionSpec(ipAddr, exclusion)
+			if client.Exists(iptables.Nat, "POSTROUTING", spec...) {
+				continue
+			}
+
+			log.Printf("[net] Adding egress IP exclusion rule %v.", spec)
+			if err := client.Insert(iptables.Nat, "POSTROUTING", 1, spec...); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteEgressIPRules deletes the rules SNATing the traffic of a container to its egress address, if any.
+func deleteEgressIPRules(egressIP net.IP, ipAddresses []net.IPNet, exclusions []string) {
+	if egressIP == nil {
+		return
+	}
+
+	client := iptables.GetClient()
+
+	for _, ipAddr := range ipAddresses {
+		if ipAddr.IP.To4() == nil {
+			continue
+		}
+
+		specs := [][]string{getEgressIPSpec(egressIP, ipAddr)}
+		for _, exclusion := range exclusions {
+			specs = append(specs, getEgressIPExclusionSpec(ipAddr, exclusion))
+		}
+
+		for _, spec := range specs {
+			if !client.Exists(iptables.Nat, "POSTROUTING", spec...) {
+				continue
+			}
+
+			log.Printf("[net] Deleting egress IP rule %v.", spec)
+			if err := client.Delete(iptables.Nat, "POSTROUTING", spec...); err != nil {
+				log.Printf("[net] Failed to delete egress IP rule %v, err:%v.", spec, err)
+			}
+		}
+	}
+}
@@ -31,11 +31,18 @@ type endpoint struct {
 	EnableSnatOnHost      bool
 	EnableInfraVnet       bool
 	EnableMultitenancy    bool
+	EnableVrfIsolation    bool
+	EnableConntrack       bool
 	NetworkNameSpace      string `json:",omitempty"`
 	ContainerID           string
-	PODName               string `json:",omitempty"`
-	PODNameSpace          string `json:",omitempty"`
-	InfraVnetAddressSpace string `json:",omitempty"`
+	PODName               string           `json:",omitempty"`
+	PODNameSpace          string           `json:",omitempty"`
+	InfraVnetAddressSpace string           `json:",omitempty"`
+	NICMacAddress         net.HardwareAddr `json:",omitempty"`
+	NICName               string           `json:",omitempty"`
+	EgressIP              net.IP           `json:",omitempty"`
+	SnatBridgeIP          string           `json:",omitempty"`
+	OffloadVF             string           `json:",omitempty"`
 }
 
 // EndpointInfo contains read-only information about an endpoint.
@@ -44,6 +51,7 @@ type EndpointInfo struct {
 	ContainerID           string
 	NetNsPath             string
 	IfName                string
+	HostIfName            string
 	SandboxKey            string
 	IfIndex               int
 	MacAddress            net.HardwareAddr
@@ -56,10 +64,16 @@ type EndpointInfo struct {
 	EnableSnatOnHost      bool
 	EnableInfraVnet       bool
 	EnableMultiTenancy    bool
+	EnableVrfIsolation    bool
+	EnableConntrack       bool
+	EnableHardwareOffload bool // Use a VF of the host NIC instead of a veth pair, and offload its OVS flows.
+	EnableLoopbackDSR     bool
 	PODName               string
 	PODNameSpace          string
 	Data                  map[string]interface{}
 	InfraVnetAddressSpace string
+	NICMacAddress         net.HardwareAddr // Host NIC attached to the container instead of a veth pair.
+	EgressIP              net.IP           // Secondary address of the node the container traffic is SNATed to.
 }
 
 // RouteInfo contains information about an IP route.
@@ -70,6 +84,8 @@ type RouteInfo struct {
 	Protocol int
 	DevName  string
 	Scope    int
+	Priority int
+	Table    int
 }
 
 // NewEndpoint creates a new endpoint in the network.
@@ -164,6 +180,20 @@ func (nw *network) getEndpointByPOD(podName string, podNameSpace string) (*endpo
 	return ep, nil
 }
 
+// GetEndpointGateway returns the gateway of the endpoint.
+// Endpoints in subnets added to the network later use the gateway of their own subnet.
+func (nw *network) getEndpointGateway(epInfo *EndpointInfo) net.IP {
+	for _, ipAddr := range epInfo.IPAddresses {
+		for i, subnet := range nw.Subnets {
+			if i > 0 && subnet.Gateway != nil && subnet.Prefix.Contains(ipAddr.IP) {
+				return subnet.Gateway
+			}
+		}
+	}
+
+	return nw.extIf.IPv4Gateway
+}
+
 //
 // Endpoint
 //
@@ -182,11 +212,16 @@ func (ep *endpoint) getInfo() *EndpointInfo {
 		EnableSnatOnHost:   ep.EnableSnatOnHost,
 		EnableInfraVnet:    ep.EnableInfraVnet,
 		EnableMultiTenancy: ep.EnableMultitenancy,
+		EnableVrfIsolation: ep.EnableVrfIsolation,
+		EnableConntrack:    ep.EnableConntrack,
 		IfName:             ep.IfName,
+		HostIfName:         ep.HostIfName,
 		ContainerID:        ep.ContainerID,
 		NetNsPath:          ep.NetworkNameSpace,
 		PODName:            ep.PODName,
 		PODNameSpace:       ep.PODNameSpace,
+		NICMacAddress:      ep.NICMacAddress,
+		EgressIP:           ep.EgressIP,
 	}
 
 	for _, route := range ep.Routes {
@@ -8,6 +8,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"os"
 	"strings"
 
 	"github.com/Azure/azure-container-networking/log"
@@ -53,6 +54,8 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 	var hostIfName string
 	var contIfName string
 	var epClient EndpointClient
+	var nicClient *NICEndpointClient
+	var ovsClient *OVSEndpointClient
 	var vlanid int = 0
 
 	if nw.Endpoints[epInfo.Id] != nil {
@@ -67,6 +70,13 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 		}
 	}
 
+	snatBridgeIP, _ := epInfo.Data[SnatBridgeIPKey].(string)
+	if vlanid != 0 {
+		if err = nw.validateEndpointVlan(epInfo, vlanid, snatBridgeIP); err != nil {
+			return nil, err
+		}
+	}
+
 	if _, ok := epInfo.Data[OptVethName]; ok {
 		key := epInfo.Data[OptVethName].(string)
 		log.Printf("Generate veth name based on the key provided %v", key)
@@ -80,14 +90,20 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 		contIfName = fmt.Sprintf("%s%s-2", hostVEthInterfacePrefix, epInfo.Id[:7])
 	}
 
-	if vlanid != 0 {
+	if epInfo.NICMacAddress != nil {
+		log.Printf("NIC client")
+		nicClient = NewNICEndpointClient(nw.extIf, epInfo.NICMacAddress)
+		epClient = nicClient
+	} else if vlanid != 0 {
 		log.Printf("OVS client")
-		epClient = NewOVSEndpointClient(
+		ovsClient = NewOVSEndpointClient(
 			nw.extIf,
 			epInfo,
 			hostIfName,
 			contIfName,
-			vlanid)
+			vlanid,
+			nw.MasqueradeExclusions)
+		epClient = ovsClient
 	} else if nw.Mode != opModeTransparent {
 		log.Printf("Bridge client")
 		epClient = NewLinuxBridgeEndpointClient(nw.extIf, hostIfName, contIfName, nw.Mode)
@@ -105,11 +121,25 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 				IfName:             contIfName,
 				HostIfName:         hostIfName,
 				IPAddresses:        epInfo.IPAddresses,
-				Gateways:           []net.IP{nw.extIf.IPv4Gateway},
+				Gateways:           []net.IP{nw.getEndpointGateway(epInfo)},
 				DNS:                epInfo.DNS,
 				VlanID:             vlanid,
 				EnableSnatOnHost:   epInfo.EnableSnatOnHost,
 				EnableMultitenancy: epInfo.EnableMultiTenancy,
+				EnableVrfIsolation: epInfo.EnableVrfIsolation,
+				EnableConntrack:    epInfo.EnableConntrack,
+				NetworkNameSpace:   epInfo.NetNsPath,
+				NICMacAddress:      epInfo.NICMacAddress,
+				EgressIP:           epInfo.EgressIP,
+				SnatBridgeIP:       snatBridgeIP,
+			}
+
+			if nicClient != nil {
+				endpt.NICName = nicClient.nicName
+			}
+
+			if ovsClient != nil && ovsClient.vf != nil {
+				endpt.OffloadVF = ovsClient.vf.name
 			}
 
 			if containerIf != nil {
@@ -117,7 +147,9 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 				epClient.DeleteEndpointRules(endpt)
 			}
 
+			deleteEgressIPRules(endpt.EgressIP, endpt.IPAddresses, nw.MasqueradeExclusions)
 			epClient.DeleteEndpoints(endpt)
+			nw.deleteVlanResources(endpt)
 		}
 	}()
 
@@ -125,6 +157,18 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 		return nil, err
 	}
 
+	// Attached NICs have no host side, and are found in the host by their name.
+	if nicClient != nil {
+		hostIfName = ""
+		contIfName = nicClient.nicName
+	}
+
+	// Offloaded endpoints use a VF of the host NIC, plugged into OVS through its representor.
+	if ovsClient != nil && ovsClient.vf != nil {
+		hostIfName = ovsClient.vf.representor
+		contIfName = ovsClient.vf.name
+	}
+
 	containerIf, err = net.InterfaceByName(contIfName)
 	if err != nil {
 		return nil, err
@@ -135,6 +179,10 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 		return nil, err
 	}
 
+	if err = addEgressIPRules(epInfo.EgressIP, epInfo.IPAddresses, nw.MasqueradeExclusions); err != nil {
+		return nil, err
+	}
+
 	// If a network namespace for the container interface is specified...
 	if epInfo.NetNsPath != "" {
 		// Open the network namespace.
@@ -183,16 +231,29 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 		MacAddress:         containerIf.HardwareAddr,
 		InfraVnetIP:        epInfo.InfraVnetIP,
 		IPAddresses:        epInfo.IPAddresses,
-		Gateways:           []net.IP{nw.extIf.IPv4Gateway},
+		Gateways:           []net.IP{nw.getEndpointGateway(epInfo)},
 		DNS:                epInfo.DNS,
 		VlanID:             vlanid,
 		EnableSnatOnHost:   epInfo.EnableSnatOnHost,
 		EnableInfraVnet:    epInfo.EnableInfraVnet,
 		EnableMultitenancy: epInfo.EnableMultiTenancy,
+		EnableVrfIsolation: epInfo.EnableVrfIsolation,
+		EnableConntrack:    epInfo.EnableConntrack,
 		NetworkNameSpace:   epInfo.NetNsPath,
 		ContainerID:        epInfo.ContainerID,
 		PODName:            epInfo.PODName,
 		PODNameSpace:       epInfo.PODNameSpace,
+		EgressIP:           epInfo.EgressIP,
+		SnatBridgeIP:       snatBridgeIP,
+	}
+
+	if nicClient != nil {
+		ep.NICMacAddress = epInfo.NICMacAddress
+		ep.NICName = nicClient.nicName
+	}
+
+	if ovsClient != nil && ovsClient.vf != nil {
+		ep.OffloadVF = ovsClient.vf.name
 	}
 
 	for _, route := range epInfo.Routes {
@@ -209,23 +270,51 @@ func (nw *network) deleteEndpointImpl(ep *endpoint) error {
 	// Delete the veth pair by deleting one of the peer interfaces.
 	// Deleting the host interface is more convenient since it does not require
 	// entering the container netns and hence works both for CNI and CNM.
-	if ep.VlanID != 0 {
+	if ep.NICMacAddress != nil {
+		epClient = NewNICEndpointClient(nw.extIf, ep.NICMacAddress)
+	} else if ep.VlanID != 0 {
 		epInfo := ep.getInfo()
-		epClient = NewOVSEndpointClient(nw.extIf, epInfo, ep.HostIfName, "", ep.VlanID)
+		epClient = NewOVSEndpointClient(nw.extIf, epInfo, ep.HostIfName, "", ep.VlanID, nw.MasqueradeExclusions)
 	} else if nw.Mode != opModeTransparent {
 		epClient = NewLinuxBridgeEndpointClient(nw.extIf, ep.HostIfName, "", nw.Mode)
 	} else {
 		epClient = NewTransparentEndpointClient(nw.extIf, ep.HostIfName, "", nw.Mode)
 	}
 
+	deleteEgressIPRules(ep.EgressIP, ep.IPAddresses, nw.MasqueradeExclusions)
 	epClient.DeleteEndpointRules(ep)
 	epClient.DeleteEndpoints(ep)
+	nw.deleteVlanResources(ep)
 
 	return nil
 }
 
+// isStaleImpl checks if the host interface or the network namespace of the endpoint no longer exists.
+func (ep *endpoint) isStaleImpl() bool {
+	if ep.HostIfName != "" {
+		if _, err := net.InterfaceByName(ep.HostIfName); err != nil {
+			return true
+		}
+	}
+
+	for _, nsPath := range []string{ep.NetworkNameSpace, ep.SandboxKey} {
+		if nsPath == "" {
+			continue
+		}
+
+		if _, err := os.Stat(nsPath); os.IsNotExist(err) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // getInfoImpl returns information about the endpoint.
 func (ep *endpoint) getInfoImpl(epInfo *EndpointInfo) {
+	if ep.SnatBridgeIP != "" {
+		epInfo.Data[SnatBridgeIPKey] = ep.SnatBridgeIP
+	}
 }
 
 func addRoutes(interfaceName string, routes []RouteInfo) error {
@@ -247,6 +336,8 @@ func addRoutes(interfaceName string, routes []RouteInfo) error {
 			Dst:       &route.Dst,
 			Gw:        route.Gw,
 			LinkIndex: ifIndex,
+			Priority:  route.Priority,
+			Table:     route.Table,
 		}
 
 		if err := netlink.AddIpRoute(nlRoute); err != nil {
@@ -290,6 +381,8 @@ func deleteRoutes(interfaceName string, routes []RouteInfo) error {
 			Dst:       &route.Dst,
 			Gw:        route.Gw,
 			LinkIndex: ifIndex,
+			Priority:  route.Priority,
+			Table:     route.Table,
 		}
 
 		if err := netlink.DeleteIpRoute(nlRoute); err != nil {
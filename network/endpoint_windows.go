@@ -5,10 +5,12 @@ package network
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 	"strings"
 
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/network/hcn"
 	"github.com/Azure/azure-container-networking/network/policy"
 	"github.com/Microsoft/hcsshim"
 )
@@ -43,12 +45,206 @@ func ConstructEndpointID(containerID string, netNsPath string, ifName string) (s
 }
 
 // newEndpointImpl creates a new endpoint in the network.
+// Endpoints are created with the HCN API on hosts that implement it, and the HNS v1 API otherwise.
 func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
-	var vlanid int
+	if epInfo.NICMacAddress != nil {
+		return nil, errNICAttachmentNotSupported
+	}
+
+	// The OutBoundNAT policy of the endpoint SNATs its traffic to its egress address.
+	if epInfo.EgressIP != nil {
+		if epInfo.Data == nil {
+			epInfo.Data = make(map[string]interface{})
+		}
+		epInfo.Data[policy.OutBoundNatVIP] = epInfo.EgressIP.String()
+	}
+
+	if hcn.IsSupported() {
+		return nw.newEndpointImplHnsV2(epInfo)
+	}
+
+	return nw.newEndpointImplHnsV1(epInfo)
+}
+
+// getEndpointVlanID returns the VLAN ID of an endpoint, 0 if not set.
+func getEndpointVlanID(epInfo *EndpointInfo) int {
+	vlanid, _ := epInfo.Data[VlanIDKey].(int)
+	return vlanid
+}
+
+// getSubnetGateway returns the gateway of the network subnet of an IP address.
+func (nw *network) getSubnetGateway(ip net.IP) net.IP {
+	for _, subnet := range nw.Subnets {
+		if subnet.Prefix.Contains(ip) {
+			return subnet.Gateway
+		}
+	}
+
+	return nil
+}
+
+// getEndpointIPv4Address returns the first IPv4 address of an endpoint, nil if it has none.
+func getEndpointIPv4Address(epInfo *EndpointInfo) net.IP {
+	for _, ipAddress := range epInfo.IPAddresses {
+		if ip := ipAddress.IP.To4(); ip != nil {
+			return ip
+		}
+	}
+
+	return nil
+}
+
+// getOverlayMacAddress returns the MAC address of an endpoint with the given IP address in an
+// overlay network, derived from the address so that it is known to remote hosts.
+func getOverlayMacAddress(ip net.IP) string {
+	mac := getOverlayMac(ip)
+	if mac == nil {
+		return ""
+	}
+
+	return strings.ToUpper(strings.Replace(mac.String(), ":", "-", -1))
+}
+
+// getProviderAddress returns the host address that encapsulates the traffic of an overlay network.
+func (nw *network) getProviderAddress() (string, error) {
+	iface, err := net.InterfaceByName(nw.extIf.Name)
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("Interface %s has no IPv4 address", nw.extIf.Name)
+}
+
+// newEndpointImplHnsV2 creates a new endpoint in the network with the HCN API.
+func (nw *network) newEndpointImplHnsV2(epInfo *EndpointInfo) (*endpoint, error) {
+	infraEpName, _ := ConstructEndpointID(epInfo.ContainerID, epInfo.NetNsPath, epInfo.IfName)
+
+	policies, err := policy.GetHcnEndpointPolicies(epInfo.Policies, epInfo.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	hcnEndpoint := &hcn.Endpoint{
+		Name:               infraEpName,
+		HostComputeNetwork: nw.HnsId,
+		Policies:           policies,
+		Dns: hcn.Dns{
+			Domain:     epInfo.DNS.Suffix,
+			Search:     epInfo.DNS.Search,
+			ServerList: epInfo.DNS.Servers,
+			Options:    epInfo.DNS.Options,
+		},
+		SchemaVersion: hcn.V2(),
+	}
+
+	// Dual-stack endpoints have an address and a default route of each family.
+	var gateways []net.IP
+	for _, ipAddress := range epInfo.IPAddresses {
+		pl, _ := ipAddress.Mask.Size()
+		hcnEndpoint.IpConfigurations = append(hcnEndpoint.IpConfigurations, hcn.IpConfig{
+			IpAddress:    ipAddress.IP.String(),
+			PrefixLength: uint8(pl),
+		})
+
+		if gateway := nw.getSubnetGateway(ipAddress.IP); gateway != nil {
+			hcnEndpoint.Routes = append(hcnEndpoint.Routes, hcn.DefaultRoute(gateway.String()))
+			gateways = append(gateways, gateway)
+		}
+	}
+
+	// Traffic of overlay endpoints is encapsulated with the host address.
+	if nw.NetworkType == hnsOverlay {
+		providerAddress, err := nw.getProviderAddress()
+		if err != nil {
+			return nil, err
+		}
+
+		paPolicy, _ := hcn.NewPolicy(hcn.PA, map[string]string{"ProviderAddress": providerAddress})
+		hcnEndpoint.Policies = append(hcnEndpoint.Policies, paPolicy)
+
+		if ip := getEndpointIPv4Address(epInfo); ip != nil {
+			hcnEndpoint.MacAddress = getOverlayMacAddress(ip)
+		}
+	}
 
-	if epInfo.Data != nil {
-		if _, ok := epInfo.Data[VlanIDKey]; ok {
-			vlanid = epInfo.Data[VlanIDKey].(int)
+	// Direct server return load balancers need the endpoint to accept traffic to its own address.
+	if epInfo.EnableLoopbackDSR {
+		for _, ipAddress := range epInfo.IPAddresses {
+			dsrPolicy, _ := hcn.NewPolicy(hcn.LoopbackDSR, map[string]string{"IPAddress": ipAddress.IP.String()})
+			hcnEndpoint.Policies = append(hcnEndpoint.Policies, dsrPolicy)
+		}
+	}
+
+	// Create the HCN endpoint.
+	log.Printf("[net] Creating HCN endpoint %+v.", hcnEndpoint)
+	hcnResponse, err := hcn.CreateEndpoint(hcnEndpoint)
+	if err != nil {
+		log.Printf("[net] Failed to create HCN endpoint, err:%v.", err)
+		return nil, err
+	}
+	log.Printf("[net] Created HCN endpoint %+v.", hcnResponse)
+
+	defer func() {
+		if err != nil {
+			log.Printf("[net] Deleting HCN endpoint %v.", hcnResponse.Id)
+			err := hcn.DeleteEndpoint(hcnResponse.Id)
+			log.Printf("[net] HCN endpoint %v deleted with err:%v.", hcnResponse.Id, err)
+		}
+	}()
+
+	// Attach the endpoint.
+	log.Printf("[net] Attaching endpoint %v to container %v.", hcnResponse.Id, epInfo.ContainerID)
+	err = hcsshim.HotAttachEndpoint(epInfo.ContainerID, hcnResponse.Id)
+	if err != nil {
+		log.Printf("[net] Failed to attach endpoint: %v.", err)
+		return nil, err
+	}
+
+	// Create the endpoint object.
+	ep := &endpoint{
+		Id:               infraEpName,
+		HnsId:            hcnResponse.Id,
+		SandboxKey:       epInfo.ContainerID,
+		IfName:           epInfo.IfName,
+		IPAddresses:      epInfo.IPAddresses,
+		Gateways:         gateways,
+		DNS:              epInfo.DNS,
+		VlanID:           getEndpointVlanID(epInfo),
+		EnableSnatOnHost: epInfo.EnableSnatOnHost,
+		EgressIP:         epInfo.EgressIP,
+	}
+
+	for _, route := range epInfo.Routes {
+		ep.Routes = append(ep.Routes, route)
+	}
+
+	ep.MacAddress, _ = net.ParseMAC(hcnResponse.MacAddress)
+
+	return ep, nil
+}
+
+// newEndpointImplHnsV1 creates a new endpoint in the network with the HNS v1 API.
+func (nw *network) newEndpointImplHnsV1(epInfo *EndpointInfo) (*endpoint, error) {
+	vlanid := getEndpointVlanID(epInfo)
+
+	if epInfo.EnableLoopbackDSR {
+		return nil, errLoopbackDSRNotSupported
+	}
+
+	for _, ipAddress := range epInfo.IPAddresses {
+		if ipAddress.IP.To4() == nil {
+			return nil, errIPv6NotSupported
 		}
 	}
 
@@ -71,6 +267,21 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 		hnsEndpoint.PrefixLength = uint8(pl)
 	}
 
+	// Traffic of overlay endpoints is encapsulated with the host address.
+	if nw.NetworkType == hnsOverlay {
+		providerAddress, err := nw.getProviderAddress()
+		if err != nil {
+			return nil, err
+		}
+
+		paPolicy, _ := json.Marshal(hcsshim.PaPolicy{Type: hcsshim.PA, PA: providerAddress})
+		hnsEndpoint.Policies = append(hnsEndpoint.Policies, paPolicy)
+
+		if epInfo.IPAddresses != nil {
+			hnsEndpoint.MacAddress = getOverlayMacAddress(epInfo.IPAddresses[0].IP)
+		}
+	}
+
 	// Marshal the request.
 	buffer, err := json.Marshal(hnsEndpoint)
 	if err != nil {
@@ -113,6 +324,7 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 		DNS:              epInfo.DNS,
 		VlanID:           vlanid,
 		EnableSnatOnHost: epInfo.EnableSnatOnHost,
+		EgressIP:         epInfo.EgressIP,
 	}
 
 	for _, route := range epInfo.Routes {
@@ -126,6 +338,14 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 
 // deleteEndpointImpl deletes an existing endpoint from the network.
 func (nw *network) deleteEndpointImpl(ep *endpoint) error {
+	if hcn.IsSupported() {
+		// Delete the HCN endpoint.
+		log.Printf("[net] Deleting HCN endpoint %v.", ep.HnsId)
+		err := hcn.DeleteEndpoint(ep.HnsId)
+		log.Printf("[net] HCN endpoint %v deleted with err:%v.", ep.HnsId, err)
+		return err
+	}
+
 	// Delete the HNS endpoint.
 	log.Printf("[net] HNSEndpointRequest DELETE id:%v", ep.HnsId)
 	hnsResponse, err := hcsshim.HNSEndpointRequest("DELETE", ep.HnsId, "")
@@ -134,6 +354,21 @@ func (nw *network) deleteEndpointImpl(ep *endpoint) error {
 	return err
 }
 
+// isStaleImpl checks if the HNS endpoint of the endpoint no longer exists.
+func (ep *endpoint) isStaleImpl() bool {
+	if ep.HnsId == "" {
+		return false
+	}
+
+	if hcn.IsSupported() {
+		_, err := hcn.GetEndpointByID(ep.HnsId)
+		return hcn.IsNotFound(err)
+	}
+
+	_, err := hcsshim.GetHNSEndpointByID(ep.HnsId)
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "not found")
+}
+
 // getInfoImpl returns information about the endpoint.
 func (ep *endpoint) getInfoImpl(epInfo *EndpointInfo) {
 	epInfo.Data["hnsid"] = ep.HnsId
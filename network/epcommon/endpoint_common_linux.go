@@ -1,12 +1,11 @@
 package epcommon
 
 import (
-	"fmt"
 	"net"
 
+	"github.com/Azure/azure-container-networking/iptables"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/netlink"
-	"github.com/Azure/azure-container-networking/platform"
 )
 
 /*RFC For Private Address Space: https://tools.ietf.org/html/rfc1918
@@ -97,30 +96,34 @@ func AssignIPToInterface(interfaceName string, ipAddresses []net.IPNet) error {
 }
 
 func addOrDeleteFilterRule(bridgeName string, action string, ipAddress string, chainName string, target string) error {
-	var cmd string
-	option := "i"
+	var err error
+	option := "-i"
 
 	if chainName == "OUTPUT" {
-		option = "o"
+		option = "-o"
 	}
 
+	client := iptables.GetClient()
+	spec := []string{option, bridgeName, "-d", ipAddress, "-j", target}
+
 	if action != "D" {
-		cmd = fmt.Sprintf("iptables -t filter -C %v -%v %v -d %v -j %v", chainName, option, bridgeName, ipAddress, target)
-		_, err := platform.ExecuteCommand(cmd)
-		if err == nil {
+		if client.Exists(iptables.Filter, chainName, spec...) {
 			log.Printf("Iptable filter for private ipaddr %v on %v chain %v target rule already exists", ipAddress, chainName, target)
 			return nil
 		}
 	}
 
 	if target != "ACCEPT" {
-		cmd = fmt.Sprintf("iptables -t filter -%v %v -%v %v -d %v -j %v", action, chainName, option, bridgeName, ipAddress, target)
+		if action == "D" {
+			err = client.Delete(iptables.Filter, chainName, spec...)
+		} else {
+			err = client.Append(iptables.Filter, chainName, spec...)
+		}
 	} else {
 		action = "I"
-		cmd = fmt.Sprintf("iptables -t filter -%v %v 1 -%v %v -d %v -j %v", action, chainName, option, bridgeName, ipAddress, target)
+		err = client.Insert(iptables.Filter, chainName, 1, spec...)
 	}
 
-	_, err := platform.ExecuteCommand(cmd)
 	if err != nil {
 		log.Printf("Iptable filter %v action for private ipaddr %v on %v chain %v target failed with %v", action, ipAddress, chainName, target, err)
 		return err
@@ -0,0 +1,241 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+// Package hcn is a client of the Host Compute Network (HNS v2) API, the schema 2 successor of
+// the HNS JSON API, available from Windows Server 2019.
+package hcn
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// HCN network types.
+const (
+	L2Bridge NetworkType = "L2Bridge"
+	L2Tunnel NetworkType = "L2Tunnel"
+	Overlay  NetworkType = "Overlay"
+)
+
+// HCN policy types.
+const (
+	NetAdapterName PolicyType = "NetAdapterName"
+	VLAN           PolicyType = "VLAN"
+	OutBoundNAT    PolicyType = "OutBoundNAT"
+	SDNRoute       PolicyType = "SDNRoute"
+	PortMapping    PolicyType = "PortMapping"
+	ACL            PolicyType = "ACL"
+	VSID           PolicyType = "VSID"
+	PA             PolicyType = "ProviderAddress"
+	LoopbackDSR    PolicyType = "LoopbackDSR"
+)
+
+// HRESULTs of HCN calls on objects that don't exist.
+const (
+	errorNotFound         = 0x80070490
+	errorNetworkNotFound  = 0x803b0001
+	errorEndpointNotFound = 0x803b0002
+)
+
+const (
+	// Schema version of the objects created by this client.
+	schemaMajor = 2
+	schemaMinor = 0
+
+	// Destinations of default routes.
+	defaultIPv4Destination = "0.0.0.0/0"
+	defaultIPv6Destination = "::/0"
+)
+
+// NetworkType is the type of an HCN network.
+type NetworkType string
+
+// PolicyType is the type of an HCN network, subnet or endpoint policy.
+type PolicyType string
+
+// SchemaVersion is the version of the HCN schema of an object.
+type SchemaVersion struct {
+	Major int
+	Minor int
+}
+
+// Policy is an HCN network, subnet or endpoint policy.
+type Policy struct {
+	Type     PolicyType
+	Settings json.RawMessage `json:",omitempty"`
+}
+
+// Route is a route of an HCN subnet or endpoint.
+type Route struct {
+	NextHop           string `json:",omitempty"`
+	DestinationPrefix string `json:",omitempty"`
+	Metric            uint16 `json:",omitempty"`
+}
+
+// Subnet is a subnet of an HCN network.
+type Subnet struct {
+	IpAddressPrefix string   `json:",omitempty"`
+	Policies        []Policy `json:",omitempty"`
+	Routes          []Route  `json:",omitempty"`
+}
+
+// Ipam is the address management of an HCN network.
+type Ipam struct {
+	Type    string   `json:",omitempty"`
+	Subnets []Subnet `json:",omitempty"`
+}
+
+// Dns is the DNS configuration of an HCN network or endpoint.
+type Dns struct {
+	Domain     string   `json:",omitempty"`
+	Search     []string `json:",omitempty"`
+	ServerList []string `json:",omitempty"`
+	Options    []string `json:",omitempty"`
+}
+
+// Network is an HCN network.
+type Network struct {
+	Id            string        `json:"ID,omitempty"`
+	Name          string        `json:",omitempty"`
+	Type          NetworkType   `json:",omitempty"`
+	Policies      []Policy      `json:",omitempty"`
+	Dns           Dns           `json:",omitempty"`
+	Ipams         []Ipam        `json:",omitempty"`
+	Flags         uint32        `json:",omitempty"`
+	SchemaVersion SchemaVersion `json:",omitempty"`
+}
+
+// IpConfig is an IP address of an HCN endpoint.
+type IpConfig struct {
+	IpAddress    string `json:",omitempty"`
+	PrefixLength uint8  `json:",omitempty"`
+}
+
+// Endpoint is an HCN endpoint.
+type Endpoint struct {
+	Id                 string        `json:"ID,omitempty"`
+	Name               string        `json:",omitempty"`
+	HostComputeNetwork string        `json:",omitempty"`
+	Policies           []Policy      `json:",omitempty"`
+	IpConfigurations   []IpConfig    `json:",omitempty"`
+	Dns                Dns           `json:",omitempty"`
+	Routes             []Route       `json:",omitempty"`
+	MacAddress         string        `json:",omitempty"`
+	Flags              uint32        `json:",omitempty"`
+	SchemaVersion      SchemaVersion `json:",omitempty"`
+}
+
+// query is an HCN query of object properties.
+type query struct {
+	SchemaVersion SchemaVersion
+	Flags         uint32
+}
+
+// V2 returns the schema version of the objects created by this client.
+func V2() SchemaVersion {
+	return SchemaVersion{Major: schemaMajor, Minor: schemaMinor}
+}
+
+// DefaultRoute returns the default route of the address family of the given gateway.
+func DefaultRoute(gateway string) Route {
+	if ip := net.ParseIP(gateway); ip != nil && ip.To4() == nil {
+		return Route{NextHop: gateway, DestinationPrefix: defaultIPv6Destination}
+	}
+
+	return Route{NextHop: gateway, DestinationPrefix: defaultIPv4Destination}
+}
+
+// NewPolicy returns a policy with the given settings.
+func NewPolicy(policyType PolicyType, settings interface{}) (Policy, error) {
+	buffer, err := json.Marshal(settings)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	return Policy{Type: policyType, Settings: buffer}, nil
+}
+
+// Error is a failed HCN call, with the error record returned by HNS.
+type Error struct {
+	Operation string
+	Code      uint32
+	Message   string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s failed with HRESULT 0x%x", e.Operation, e.Code)
+	}
+
+	return fmt.Sprintf("%s failed with HRESULT 0x%x: %s", e.Operation, e.Code, e.Message)
+}
+
+// IsNotFound checks if an error is an HCN call on an object that doesn't exist.
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+
+	switch e.Code {
+	case errorNotFound, errorNetworkNotFound, errorEndpointNotFound:
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(e.Message), "not found")
+}
+
+// newError returns the error of a failed HCN call from its HRESULT and error record.
+func newError(operation string, hr uint32, record string) error {
+	e := &Error{Operation: operation, Code: hr}
+
+	var result struct {
+		Error   string
+		Success bool
+	}
+
+	if record != "" {
+		if err := json.Unmarshal([]byte(record), &result); err == nil {
+			e.Message = result.Error
+		} else {
+			e.Message = record
+		}
+	}
+
+	return e
+}
+
+// GUID is the binary layout of a GUID.
+type GUID struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+// ParseGUID parses a GUID in the "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx" format, with or without braces.
+func ParseGUID(s string) (GUID, error) {
+	var g GUID
+
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+	parts := strings.Split(s, "-")
+	if len(parts) != 5 || len(parts[0]) != 8 || len(parts[1]) != 4 || len(parts[2]) != 4 ||
+		len(parts[3]) != 4 || len(parts[4]) != 12 {
+		return g, fmt.Errorf("Invalid GUID %q", s)
+	}
+
+	b, err := hex.DecodeString(strings.Join(parts, ""))
+	if err != nil {
+		return g, fmt.Errorf("Invalid GUID %q", s)
+	}
+
+	g.Data1 = uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	g.Data2 = uint16(b[4])<<8 | uint16(b[5])
+	g.Data3 = uint16(b[6])<<8 | uint16(b[7])
+	copy(g.Data4[:], b[8:])
+
+	return g, nil
+}
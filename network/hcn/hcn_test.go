@@ -0,0 +1,56 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+package hcn
+
+import (
+	"testing"
+)
+
+func TestParseGUID(t *testing.T) {
+	g, err := ParseGUID("{6ba7b810-9dad-11d1-80b4-00c04fd430c8}")
+	if err != nil {
+		t.Fatalf("Failed to parse GUID, err:%v", err)
+	}
+
+	expected := GUID{
+		Data1: 0x6ba7b810,
+		Data2: 0x9dad,
+		Data3: 0x11d1,
+		Data4: [8]byte{0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8},
+	}
+	if g != expected {
+		t.Errorf("Unexpected GUID %+v", g)
+	}
+
+	for _, s := range []string{"", "6ba7b810-9dad-11d1-80b4", "6ba7b810-9dad-11d1-80b4-00c04fd430cz"} {
+		if _, err := ParseGUID(s); err == nil {
+			t.Errorf("Invalid GUID %q parsed", s)
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	err := newError("HcnOpenEndpoint", errorEndpointNotFound, `{"Success":false,"Error":"Endpoint was not found"}`)
+	if err.Error() != "HcnOpenEndpoint failed with HRESULT 0x803b0002: Endpoint was not found" {
+		t.Errorf("Unexpected error message %q", err.Error())
+	}
+
+	if !IsNotFound(err) {
+		t.Errorf("Error %v is not a not found error", err)
+	}
+
+	if IsNotFound(newError("HcnCreateNetwork", 0x80070057, "")) {
+		t.Errorf("Invalid parameter error is a not found error")
+	}
+}
+
+func TestDefaultRoute(t *testing.T) {
+	if route := DefaultRoute("10.0.0.1"); route.DestinationPrefix != "0.0.0.0/0" || route.NextHop != "10.0.0.1" {
+		t.Errorf("Unexpected IPv4 default route %+v", route)
+	}
+
+	if route := DefaultRoute("fd00::1"); route.DestinationPrefix != "::/0" || route.NextHop != "fd00::1" {
+		t.Errorf("Unexpected IPv6 default route %+v", route)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+// +build windows
+
+package hcn
+
+import (
+	"encoding/json"
+	"syscall"
+	"unsafe"
+
+	"github.com/Microsoft/hcsshim"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// Oldest HNS version implementing the HCN API, in Windows Server 2019.
+	v2MinMajorVersion = 9
+	v2MinMinorVersion = 2
+)
+
+var (
+	modcomputenetwork = windows.NewLazySystemDLL("computenetwork.dll")
+	modole32          = windows.NewLazySystemDLL("ole32.dll")
+
+	procHcnCreateNetwork           = modcomputenetwork.NewProc("HcnCreateNetwork")
+	procHcnOpenNetwork             = modcomputenetwork.NewProc("HcnOpenNetwork")
+	procHcnQueryNetworkProperties  = modcomputenetwork.NewProc("HcnQueryNetworkProperties")
+	procHcnDeleteNetwork           = modcomputenetwork.NewProc("HcnDeleteNetwork")
+	procHcnCloseNetwork            = modcomputenetwork.NewProc("HcnCloseNetwork")
+	procHcnCreateEndpoint          = modcomputenetwork.NewProc("HcnCreateEndpoint")
+	procHcnOpenEndpoint            = modcomputenetwork.NewProc("HcnOpenEndpoint")
+	procHcnQueryEndpointProperties = modcomputenetwork.NewProc("HcnQueryEndpointProperties")
+	procHcnDeleteEndpoint          = modcomputenetwork.NewProc("HcnDeleteEndpoint")
+	procHcnCloseEndpoint           = modcomputenetwork.NewProc("HcnCloseEndpoint")
+	procCoTaskMemFree              = modole32.NewProc("CoTaskMemFree")
+)
+
+// IsSupported checks if the host implements the HCN API.
+func IsSupported() bool {
+	if err := load(); err != nil {
+		return false
+	}
+
+	globals, err := hcsshim.GetHNSGlobals()
+	if err != nil {
+		return false
+	}
+
+	major, minor := globals.Version.Major, globals.Version.Minor
+	return major > v2MinMajorVersion || (major == v2MinMajorVersion && minor >= v2MinMinorVersion)
+}
+
+// load checks that the HCN functions used by the client are exported by the host.
+func load() error {
+	for _, proc := range []*windows.LazyProc{
+		procHcnCreateNetwork, procHcnOpenNetwork, procHcnQueryNetworkProperties, procHcnDeleteNetwork,
+		procHcnCloseNetwork, procHcnCreateEndpoint, procHcnOpenEndpoint, procHcnQueryEndpointProperties,
+		procHcnDeleteEndpoint, procHcnCloseEndpoint, procCoTaskMemFree,
+	} {
+		if err := proc.Find(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// check returns the error of an HCN call, including the error record from HNS.
+func check(operation string, hr uintptr, record *uint16) error {
+	message := takeString(record)
+
+	if int32(hr) < 0 {
+		return newError(operation, uint32(hr), message)
+	}
+
+	return nil
+}
+
+// takeString converts a string allocated by HNS and frees it.
+func takeString(buffer *uint16) string {
+	if buffer == nil {
+		return ""
+	}
+
+	s := windows.UTF16ToString((*[1 << 29]uint16)(unsafe.Pointer(buffer))[:])
+	syscall.Syscall(procCoTaskMemFree.Addr(), 1, uintptr(unsafe.Pointer(buffer)), 0, 0)
+
+	return s
+}
+
+// encode returns the JSON representation of an object as a string for HNS.
+func encode(v interface{}) (*uint16, error) {
+	buffer, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return windows.UTF16PtrFromString(string(buffer))
+}
+
+// defaultQuery returns the query of all properties of an object.
+func defaultQuery() (*uint16, error) {
+	return encode(&query{SchemaVersion: V2()})
+}
+
+// CreateNetwork creates an HCN network and returns it as created by HNS.
+func CreateNetwork(network *Network) (*Network, error) {
+	var id GUID
+	var handle uintptr
+	var record *uint16
+
+	if err := load(); err != nil {
+		return nil, err
+	}
+
+	settings, err := encode(network)
+	if err != nil {
+		return nil, err
+	}
+
+	hr, _, _ := syscall.Syscall6(procHcnCreateNetwork.Addr(), 4,
+		uintptr(unsafe.Pointer(&id)), uintptr(unsafe.Pointer(settings)), uintptr(unsafe.Pointer(&handle)),
+		uintptr(unsafe.Pointer(&record)), 0, 0)
+	if err := check("HcnCreateNetwork", hr, record); err != nil {
+		return nil, err
+	}
+	defer syscall.Syscall(procHcnCloseNetwork.Addr(), 1, handle, 0, 0)
+
+	var result Network
+	if err := queryProperties("HcnQueryNetworkProperties", procHcnQueryNetworkProperties, handle, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetNetworkByID returns an HCN network.
+func GetNetworkByID(networkID string) (*Network, error) {
+	handle, err := openNetwork(networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Syscall(procHcnCloseNetwork.Addr(), 1, handle, 0, 0)
+
+	var result Network
+	if err := queryProperties("HcnQueryNetworkProperties", procHcnQueryNetworkProperties, handle, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// DeleteNetwork deletes an HCN network.
+func DeleteNetwork(networkID string) error {
+	return deleteObject("HcnDeleteNetwork", procHcnDeleteNetwork, networkID)
+}
+
+// CreateEndpoint creates an HCN endpoint in its network and returns it as created by HNS.
+func CreateEndpoint(endpoint *Endpoint) (*Endpoint, error) {
+	var id GUID
+	var handle uintptr
+	var record *uint16
+
+	networkHandle, err := openNetwork(endpoint.HostComputeNetwork)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Syscall(procHcnCloseNetwork.Addr(), 1, networkHandle, 0, 0)
+
+	settings, err := encode(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	hr, _, _ := syscall.Syscall6(procHcnCreateEndpoint.Addr(), 5,
+		networkHandle, uintptr(unsafe.Pointer(&id)), uintptr(unsafe.Pointer(settings)),
+		uintptr(unsafe.Pointer(&handle)), uintptr(unsafe.Pointer(&record)), 0)
+	if err := check("HcnCreateEndpoint", hr, record); err != nil {
+		return nil, err
+	}
+	defer syscall.Syscall(procHcnCloseEndpoint.Addr(), 1, handle, 0, 0)
+
+	var result Endpoint
+	if err := queryProperties("HcnQueryEndpointProperties", procHcnQueryEndpointProperties, handle, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetEndpointByID returns an HCN endpoint.
+func GetEndpointByID(endpointID string) (*Endpoint, error) {
+	var handle uintptr
+	var record *uint16
+
+	if err := load(); err != nil {
+		return nil, err
+	}
+
+	id, err := ParseGUID(endpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	hr, _, _ := syscall.Syscall(procHcnOpenEndpoint.Addr(), 3,
+		uintptr(unsafe.Pointer(&id)), uintptr(unsafe.Pointer(&handle)), uintptr(unsafe.Pointer(&record)))
+	if err := check("HcnOpenEndpoint", hr, record); err != nil {
+		return nil, err
+	}
+	defer syscall.Syscall(procHcnCloseEndpoint.Addr(), 1, handle, 0, 0)
+
+	var result Endpoint
+	if err := queryProperties("HcnQueryEndpointProperties", procHcnQueryEndpointProperties, handle, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// DeleteEndpoint deletes an HCN endpoint.
+func DeleteEndpoint(endpointID string) error {
+	return deleteObject("HcnDeleteEndpoint", procHcnDeleteEndpoint, endpointID)
+}
+
+// openNetwork opens an HCN network. The handle must be closed by the caller.
+func openNetwork(networkID string) (uintptr, error) {
+	var handle uintptr
+	var record *uint16
+
+	if err := load(); err != nil {
+		return 0, err
+	}
+
+	id, err := ParseGUID(networkID)
+	if err != nil {
+		return 0, err
+	}
+
+	hr, _, _ := syscall.Syscall(procHcnOpenNetwork.Addr(), 3,
+		uintptr(unsafe.Pointer(&id)), uintptr(unsafe.Pointer(&handle)), uintptr(unsafe.Pointer(&record)))
+	if err := check("HcnOpenNetwork", hr, record); err != nil {
+		return 0, err
+	}
+
+	return handle, nil
+}
+
+// deleteObject deletes an HCN network or endpoint.
+func deleteObject(operation string, proc *windows.LazyProc, objectID string) error {
+	var record *uint16
+
+	if err := load(); err != nil {
+		return err
+	}
+
+	id, err := ParseGUID(objectID)
+	if err != nil {
+		return err
+	}
+
+	hr, _, _ := syscall.Syscall(proc.Addr(), 2, uintptr(unsafe.Pointer(&id)), uintptr(unsafe.Pointer(&record)), 0)
+	return check(operation, hr, record)
+}
+
+// queryProperties queries all properties of an open HCN object.
+func queryProperties(operation string, proc *windows.LazyProc, handle uintptr, result interface{}) error {
+	var properties *uint16
+	var record *uint16
+
+	q, err := defaultQuery()
+	if err != nil {
+		return err
+	}
+
+	hr, _, _ := syscall.Syscall6(proc.Addr(), 4,
+		handle, uintptr(unsafe.Pointer(q)), uintptr(unsafe.Pointer(&properties)), uintptr(unsafe.Pointer(&record)), 0, 0)
+	if err := check(operation, hr, record); err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(takeString(properties)), result)
+}
@@ -15,11 +15,23 @@ import (
 
 const (
 	// Network store key.
-	storeKey    = "Network"
-	VlanIDKey   = "VlanID"
-	genericData = "com.docker.network.generic"
+	storeKey = "Network"
+
+	// Prefix of the keys locking networks in the store.
+	lockKeyPrefix = storeKey + "|"
+
+	// Schema version of network manager state.
+	schemaVersion = 1
+	VlanIDKey     = "VlanID"
+	genericData   = "com.docker.network.generic"
 )
 
+// Layout of persisted network manager state, with the migrations from older versions.
+var schema = &store.Schema{
+	Key:     storeKey,
+	Version: schemaVersion,
+}
+
 type NetworkClient interface {
 	CreateBridge() error
 	DeleteBridge() error
@@ -41,10 +53,16 @@ type EndpointClient interface {
 
 // NetworkManager manages the set of container networking resources.
 type networkManager struct {
+	SchemaVersion      int
 	Version            string
 	TimeStamp          time.Time
+	GCTimeStamp        time.Time
 	ExternalInterfaces map[string]*externalInterface
 	store              store.KeyValueStore
+	locker             store.KeyLocker
+	lockedNetworks     map[string]bool
+	stateLocked        bool
+	storeLocked        bool
 	sync.Mutex
 }
 
@@ -58,6 +76,8 @@ type NetworkManager interface {
 	CreateNetwork(nwInfo *NetworkInfo) error
 	DeleteNetwork(networkId string) error
 	GetNetworkInfo(networkId string) (*NetworkInfo, error)
+	AddNetworkSubnet(networkId string, subnet *SubnetInfo) error
+	UpdateOverlayVteps(networkId string, vteps []VtepInfo) error
 
 	CreateEndpoint(networkId string, epInfo *EndpointInfo) error
 	DeleteEndpoint(networkId string, endpointId string) error
@@ -66,12 +86,18 @@ type NetworkManager interface {
 	AttachEndpoint(networkId string, endpointId string, sandboxKey string) (*endpoint, error)
 	DetachEndpoint(networkId string, endpointId string) error
 	UpdateEndpoint(networkId string, existingEpInfo *EndpointInfo, targetEpInfo *EndpointInfo) error
+
+	GarbageCollectEndpoints(interval time.Duration) (int, error)
+
+	LockNetwork(networkId string) error
+	UnlockNetwork(networkId string)
 }
 
 // Creates a new network manager.
 func NewNetworkManager() (NetworkManager, error) {
 	nm := &networkManager{
 		ExternalInterfaces: make(map[string]*externalInterface),
+		lockedNetworks:     make(map[string]bool),
 	}
 
 	return nm, nil
@@ -82,6 +108,9 @@ func (nm *networkManager) Initialize(config *common.PluginConfig) error {
 	nm.Version = config.Version
 	nm.store = config.Store
 
+	// Stores shared by processes updating different networks lock them individually.
+	nm.locker, _ = config.Store.(store.KeyLocker)
+
 	// Restore persisted state.
 	err := nm.restore()
 	return err
@@ -99,10 +128,31 @@ func (nm *networkManager) restore() error {
 		return nil
 	}
 
+	// Processes starting after a reboot wait for the first one to clean up or rehydrate the state.
+	if nm.locker != nil {
+		locked, err := nm.lockStore()
+		if err != nil {
+			return err
+		}
+
+		if locked {
+			defer nm.unlockStore()
+		}
+	}
+
 	rebooted := false
 	// After a reboot, all address resources are implicitly released.
 	// Ignore the persisted state if it is older than the last reboot time.
 
+	// Check the modification time before migrations rewrite the state.
+	modTime, modTimeErr := nm.store.GetModificationTime()
+
+	// Upgrade state persisted by older versions.
+	if err := schema.Migrate(nm.store); err != nil {
+		log.Printf("[net] Failed to migrate state, err:%v\n", err)
+		return err
+	}
+
 	// Read any persisted state.
 	err := nm.store.Read(storeKey, nm)
 	if err != nil {
@@ -116,8 +166,7 @@ func (nm *networkManager) restore() error {
 		}
 	}
 
-	modTime, err := nm.store.GetModificationTime()
-	if err == nil {
+	if modTimeErr == nil {
 		rebootTime, err := platform.GetLastRebootTime()
 		log.Printf("[net] reboot time %v store mod time %v", rebootTime, modTime)
 		if err == nil && rebootTime.After(modTime) {
@@ -143,6 +192,10 @@ func (nm *networkManager) restore() error {
 					delete(nm.ExternalInterfaces, extIfName)
 				}
 
+				if nm.locker != nil {
+					return nm.save()
+				}
+
 				return nil
 			}
 		}
@@ -175,6 +228,12 @@ func (nm *networkManager) restore() error {
 				}
 			}
 		}
+
+		if nm.locker != nil {
+			if err := nm.save(); err != nil {
+				return err
+			}
+		}
 	}
 
 	log.Printf("[net] Restored state, %+v\n", nm)
@@ -198,10 +257,21 @@ func (nm *networkManager) save() error {
 		return nil
 	}
 
+	// Keep the networks saved by the processes sharing the store.
+	locked, err := nm.lockState()
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		defer nm.unlockState()
+	}
+
 	// Update time stamp.
+	nm.SchemaVersion = schemaVersion
 	nm.TimeStamp = time.Now()
 
-	err := nm.store.Write(storeKey, nm)
+	err = nm.store.Write(storeKey, nm)
 	if err == nil {
 		log.Printf("[net] Save succeeded.\n")
 	} else {
@@ -210,18 +280,218 @@ func (nm *networkManager) save() error {
 	return err
 }
 
+// Returns whether this process shares the store with processes updating other networks.
+func (nm *networkManager) isShared() bool {
+	return nm.locker != nil && len(nm.lockedNetworks) > 0
+}
+
+// Locks the store shared with other processes to read or write the state.
+// Returns false if the store was already locked by the caller of the network manager.
+func (nm *networkManager) lockStore() (bool, error) {
+	err := nm.locker.Lock(true)
+	if err == store.ErrStoreLocked {
+		return false, nil
+	}
+
+	if err != nil {
+		log.Printf("[net] Failed to lock store, err:%v\n", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Unlocks the store shared with other processes.
+func (nm *networkManager) unlockStore() {
+	if err := nm.locker.Unlock(false); err != nil {
+		log.Printf("[net] Failed to unlock store, err:%v\n", err)
+	}
+}
+
+// Reads the state saved by the processes sharing the store, keeping the networks locked by this
+// process as they are. Networks are kept on their external interface as saved by other processes.
+func (nm *networkManager) refresh() error {
+	saved := &networkManager{}
+	err := nm.store.Read(storeKey, saved)
+	if err != nil && err != store.ErrKeyNotFound {
+		log.Printf("[net] Failed to reload state, err:%v\n", err)
+		return err
+	}
+
+	if saved.ExternalInterfaces == nil {
+		saved.ExternalInterfaces = make(map[string]*externalInterface)
+	}
+
+	for _, extIf := range saved.ExternalInterfaces {
+		if extIf.Networks == nil {
+			extIf.Networks = make(map[string]*network)
+		}
+
+		for networkId := range extIf.Networks {
+			if nm.lockedNetworks[networkId] {
+				delete(extIf.Networks, networkId)
+			}
+		}
+	}
+
+	for extIfName, extIf := range nm.ExternalInterfaces {
+		for networkId, nw := range extIf.Networks {
+			if !nm.lockedNetworks[networkId] {
+				continue
+			}
+
+			savedExtIf := saved.ExternalInterfaces[extIfName]
+			if savedExtIf == nil {
+				savedExtIf = &externalInterface{}
+				*savedExtIf = *extIf
+				savedExtIf.Networks = make(map[string]*network)
+				saved.ExternalInterfaces[extIfName] = savedExtIf
+			}
+
+			savedExtIf.Networks[networkId] = nw
+		}
+	}
+
+	nm.GCTimeStamp = saved.GCTimeStamp
+	nm.ExternalInterfaces = saved.ExternalInterfaces
+
+	// Populate pointers.
+	for _, extIf := range nm.ExternalInterfaces {
+		for _, nw := range extIf.Networks {
+			nw.extIf = extIf
+		}
+	}
+
+	return nil
+}
+
+// Reloads the state saved by the processes sharing the store, except for the networks locked by this process.
+func (nm *networkManager) reload() error {
+	locked, err := nm.lockStore()
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		defer nm.unlockStore()
+	}
+
+	return nm.refresh()
+}
+
+// Locks the store and reloads the networks saved by other processes, before updating the state
+// shared with them, i.e. more than the networks locked by this process. Does nothing unless this
+// process holds network locks, or if the state is already locked. Returns whether it locked the state.
+func (nm *networkManager) lockState() (bool, error) {
+	if !nm.isShared() || nm.stateLocked {
+		return false, nil
+	}
+
+	locked, err := nm.lockStore()
+	if err != nil {
+		return false, err
+	}
+
+	nm.stateLocked = true
+	nm.storeLocked = locked
+
+	if err = nm.refresh(); err != nil {
+		nm.unlockState()
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Unlocks the state locked by lockState.
+func (nm *networkManager) unlockState() {
+	if nm.storeLocked {
+		nm.unlockStore()
+	}
+
+	nm.stateLocked = false
+	nm.storeLocked = false
+}
+
+// Locks the given network for the processes sharing the store and reloads its state, since it may
+// have changed while waiting. Locks are held until unlockNetwork is called.
+func (nm *networkManager) lockNetwork(networkId string, block bool) error {
+	if nm.locker == nil || nm.store == nil || nm.lockedNetworks[networkId] {
+		return nil
+	}
+
+	key := lockKeyPrefix + networkId
+	err := nm.locker.LockKey(key, block)
+	if err != nil {
+		if block {
+			log.Printf("[net] Failed to lock %v, err:%v.", key, err)
+		}
+		return err
+	}
+
+	if err = nm.reload(); err != nil {
+		nm.locker.UnlockKey(key)
+		return err
+	}
+
+	nm.lockedNetworks[networkId] = true
+
+	return nil
+}
+
+// Unlocks the given network.
+func (nm *networkManager) unlockNetwork(networkId string) {
+	if !nm.lockedNetworks[networkId] {
+		return
+	}
+
+	key := lockKeyPrefix + networkId
+	if err := nm.locker.UnlockKey(key); err != nil {
+		log.Printf("[net] Failed to unlock %v, err:%v.", key, err)
+	}
+
+	delete(nm.lockedNetworks, networkId)
+}
+
 //
 // NetworkManager API
 //
 // Provides atomic stateful wrappers around core networking functionality.
 //
 
+// LockNetwork locks a network for the processes sharing the store, so that they can update
+// other networks meanwhile, and reloads it. Updates of other networks are merged on save.
+func (nm *networkManager) LockNetwork(networkId string) error {
+	nm.Lock()
+	defer nm.Unlock()
+
+	return nm.lockNetwork(networkId, true)
+}
+
+// UnlockNetwork unlocks a network locked by LockNetwork.
+func (nm *networkManager) UnlockNetwork(networkId string) {
+	nm.Lock()
+	defer nm.Unlock()
+
+	nm.unlockNetwork(networkId)
+}
+
 // AddExternalInterface adds a host interface to the list of available external interfaces.
 func (nm *networkManager) AddExternalInterface(ifName string, subnet string) error {
 	nm.Lock()
 	defer nm.Unlock()
 
-	err := nm.newExternalInterface(ifName, subnet)
+	// External interfaces are shared by all networks.
+	locked, err := nm.lockState()
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		defer nm.unlockState()
+	}
+
+	err = nm.newExternalInterface(ifName, subnet)
 	if err != nil {
 		return err
 	}
@@ -239,11 +509,27 @@ func (nm *networkManager) CreateNetwork(nwInfo *NetworkInfo) error {
 	nm.Lock()
 	defer nm.Unlock()
 
-	_, err := nm.newNetwork(nwInfo)
+	// Networks are created on external interfaces shared by all networks.
+	locked, err := nm.lockState()
 	if err != nil {
 		return err
 	}
 
+	if locked {
+		defer nm.unlockState()
+	}
+
+	_, err = nm.newNetwork(nwInfo)
+	if err != nil {
+		return err
+	}
+
+	if err = nm.syncL2RulesImpl(); err != nil {
+		log.Printf("[net] Failed to program L2 rules of network %v: %v.", nwInfo.Id, err)
+		nm.deleteNetwork(nwInfo.Id)
+		return err
+	}
+
 	err = nm.save()
 	if err != nil {
 		return err
@@ -257,11 +543,25 @@ func (nm *networkManager) DeleteNetwork(networkId string) error {
 	nm.Lock()
 	defer nm.Unlock()
 
-	err := nm.deleteNetwork(networkId)
+	// Networks are deleted from external interfaces shared by all networks.
+	locked, err := nm.lockState()
 	if err != nil {
 		return err
 	}
 
+	if locked {
+		defer nm.unlockState()
+	}
+
+	err = nm.deleteNetwork(networkId)
+	if err != nil {
+		return err
+	}
+
+	if err := nm.syncL2RulesImpl(); err != nil {
+		log.Printf("[net] Failed to remove L2 rules of network %v: %v.", networkId, err)
+	}
+
 	err = nm.save()
 	if err != nil {
 		return err
@@ -296,6 +596,41 @@ func (nm *networkManager) GetNetworkInfo(networkId string) (*NetworkInfo, error)
 	return nwInfo, nil
 }
 
+// AddNetworkSubnet adds a subnet to an existing container network.
+func (nm *networkManager) AddNetworkSubnet(networkId string, subnet *SubnetInfo) error {
+	nm.Lock()
+	defer nm.Unlock()
+
+	err := nm.addNetworkSubnet(networkId, subnet)
+	if err != nil {
+		return err
+	}
+
+	err = nm.save()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateOverlayVteps sets the remote VTEPs of an overlay network.
+func (nm *networkManager) UpdateOverlayVteps(networkId string, vteps []VtepInfo) error {
+	nm.Lock()
+	defer nm.Unlock()
+
+	nw, err := nm.getNetwork(networkId)
+	if err != nil {
+		return err
+	}
+
+	if err = nm.updateOverlayVtepsImpl(nw, vteps); err != nil {
+		return err
+	}
+
+	return nm.save()
+}
+
 // CreateEndpoint creates a new container endpoint.
 func (nm *networkManager) CreateEndpoint(networkId string, epInfo *EndpointInfo) error {
 	nm.Lock()
@@ -318,6 +653,22 @@ func (nm *networkManager) CreateEndpoint(networkId string, epInfo *EndpointInfo)
 		return err
 	}
 
+	// The L2 rules of the networks saved by other processes are kept.
+	locked, err := nm.lockState()
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		defer nm.unlockState()
+	}
+
+	if err = nm.syncL2RulesImpl(); err != nil {
+		log.Printf("[net] Failed to program L2 rules of endpoint %v: %v.", epInfo.Id, err)
+		nw.deleteEndpoint(epInfo.Id)
+		return err
+	}
+
 	err = nm.save()
 	if err != nil {
 		return err
@@ -341,6 +692,20 @@ func (nm *networkManager) DeleteEndpoint(networkId string, endpointId string) er
 		return err
 	}
 
+	// The L2 rules of the networks saved by other processes are kept.
+	locked, err := nm.lockState()
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		defer nm.unlockState()
+	}
+
+	if err := nm.syncL2RulesImpl(); err != nil {
+		log.Printf("[net] Failed to remove L2 rules of endpoint %v: %v.", endpointId, err)
+	}
+
 	err = nm.save()
 	if err != nil {
 		return err
@@ -464,3 +829,87 @@ func (nm *networkManager) UpdateEndpoint(networkID string, existingEpInfo *Endpo
 
 	return nil
 }
+
+// GarbageCollectEndpoints deletes the endpoints whose interfaces or sandboxes no longer exist,
+// e.g. of containers deleted while the plugin wasn't running. It runs at most once per interval
+// and returns the number of endpoints deleted. Networks locked by other processes are skipped.
+func (nm *networkManager) GarbageCollectEndpoints(interval time.Duration) (int, error) {
+	nm.Lock()
+	defer nm.Unlock()
+
+	if time.Since(nm.GCTimeStamp) < interval {
+		return 0, nil
+	}
+
+	if nm.locker != nil && nm.store != nil {
+		var networkIds []string
+		for _, extIf := range nm.ExternalInterfaces {
+			for networkId := range extIf.Networks {
+				networkIds = append(networkIds, networkId)
+			}
+		}
+
+		for _, networkId := range networkIds {
+			if nm.lockedNetworks[networkId] {
+				continue
+			}
+
+			if err := nm.lockNetwork(networkId, false); err != nil {
+				log.Printf("[net] Skipping stale endpoints of network %v locked by another process.", networkId)
+				continue
+			}
+			defer nm.unlockNetwork(networkId)
+		}
+
+		locked, err := nm.lockState()
+		if err != nil {
+			return 0, err
+		}
+
+		if !locked {
+			return 0, nil
+		}
+		defer nm.unlockState()
+
+		// Another process may have collected meanwhile.
+		if time.Since(nm.GCTimeStamp) < interval {
+			return 0, nil
+		}
+	}
+
+	count := 0
+	for _, extIf := range nm.ExternalInterfaces {
+		for _, nw := range extIf.Networks {
+			if nm.locker != nil && !nm.lockedNetworks[nw.Id] {
+				continue
+			}
+
+			for endpointId, ep := range nw.Endpoints {
+				if !ep.isStaleImpl() {
+					continue
+				}
+
+				// Whatever is left of the endpoint is deleted on a best effort basis.
+				log.Printf("[net] Deleting stale endpoint %v of network %v.", endpointId, nw.Id)
+				if err := nw.deleteEndpointImpl(ep); err != nil {
+					log.Printf("[net] Failed to delete stale endpoint %v: %v.", endpointId, err)
+				}
+
+				delete(nw.Endpoints, endpointId)
+				count++
+			}
+		}
+	}
+
+	nm.GCTimeStamp = time.Now()
+
+	if count > 0 {
+		log.Printf("[net] Deleted %d stale endpoints.", count)
+
+		if err := nm.syncL2RulesImpl(); err != nil {
+			log.Printf("[net] Failed to remove L2 rules of stale endpoints: %v.", err)
+		}
+	}
+
+	return count, nm.save()
+}
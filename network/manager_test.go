@@ -0,0 +1,115 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/store"
+)
+
+// createSharedNetworkManager creates a network manager of a process sharing the given store.
+func createSharedNetworkManager(t *testing.T, fileName string) NetworkManager {
+	kvs, err := store.NewJsonFileStore(fileName)
+	if err != nil {
+		t.Fatalf("Failed to create store, err:%v.", err)
+	}
+
+	nm, err := NewNetworkManager()
+	if err != nil {
+		t.Fatalf("NewNetworkManager failed, err:%v.", err)
+	}
+
+	if err = nm.Initialize(&common.PluginConfig{Store: kvs}); err != nil {
+		t.Fatalf("Initialize failed, err:%v.", err)
+	}
+
+	return nm
+}
+
+func TestEndpointUpdatesOfProcessesSharingStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "net")
+	if err != nil {
+		t.Fatalf("Failed to create directory, err:%v.", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv(store.LockTimeoutEnv, "300ms")
+	defer os.Unsetenv(store.LockTimeoutEnv)
+
+	fileName := filepath.Join(dir, "azure-vnet.json")
+
+	kvs, err := store.NewJsonFileStore(fileName)
+	if err != nil {
+		t.Fatalf("Failed to create store, err:%v.", err)
+	}
+
+	// Two networks on the same external interface, each with an endpoint.
+	extIf := &externalInterface{Name: "eth0", Networks: make(map[string]*network)}
+	for _, id := range []string{"net1", "net2"} {
+		extIf.Networks[id] = &network{
+			Id:        id,
+			Endpoints: map[string]*endpoint{"ep-" + id: {Id: "ep-" + id}},
+		}
+	}
+
+	state := &networkManager{
+		SchemaVersion:      schemaVersion,
+		ExternalInterfaces: map[string]*externalInterface{"eth0": extIf},
+	}
+
+	if err = kvs.Write(storeKey, state); err != nil {
+		t.Fatalf("Failed to write state, err:%v.", err)
+	}
+
+	nm1 := createSharedNetworkManager(t, fileName)
+	nm2 := createSharedNetworkManager(t, fileName)
+	nm3 := createSharedNetworkManager(t, fileName)
+
+	if err = nm1.LockNetwork("net1"); err != nil {
+		t.Fatalf("Failed to lock net1, err:%v.", err)
+	}
+
+	// Invocations for other networks don't wait, invocations for the same network do.
+	if err = nm2.LockNetwork("net2"); err != nil {
+		t.Fatalf("Failed to lock net2 while net1 is locked, err:%v.", err)
+	}
+
+	if err = nm3.LockNetwork("net1"); err != store.ErrTimeoutLockingStore {
+		t.Errorf("Locking a network locked by another process returned err:%v.", err)
+	}
+
+	// Saves of each process keep the endpoints updated by the other.
+	if _, err = nm1.AttachEndpoint("net1", "ep-net1", "sandbox1"); err != nil {
+		t.Fatalf("AttachEndpoint failed, err:%v.", err)
+	}
+
+	if _, err = nm2.AttachEndpoint("net2", "ep-net2", "sandbox2"); err != nil {
+		t.Fatalf("AttachEndpoint failed, err:%v.", err)
+	}
+
+	nm1.UnlockNetwork("net1")
+	nm2.UnlockNetwork("net2")
+
+	// Locking a network reloads the state saved by the processes that held it.
+	if err = nm3.LockNetwork("net1"); err != nil {
+		t.Fatalf("Failed to lock net1 after it was unlocked, err:%v.", err)
+	}
+	defer nm3.UnlockNetwork("net1")
+
+	for networkId, sandboxKey := range map[string]string{"net1": "sandbox1", "net2": "sandbox2"} {
+		epInfo, err := nm3.GetEndpointInfo(networkId, "ep-"+networkId)
+		if err != nil {
+			t.Fatalf("GetEndpointInfo of %v failed, err:%v.", networkId, err)
+		}
+
+		if epInfo.SandboxKey != sandboxKey {
+			t.Errorf("Endpoint of %v is attached to %q, expected %q.", networkId, epInfo.SandboxKey, sandboxKey)
+		}
+	}
+}
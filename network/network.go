@@ -18,6 +18,14 @@ const (
 	opModeTunnel      = "tunnel"
 	opModeTransparent = "transparent"
 	opModeDefault     = opModeTunnel
+
+	// Classes of traffic SNATed through the host in multitenancy mode.
+	SnatTrafficHost     = "host"
+	SnatTrafficInfra    = "infra"
+	SnatTrafficInternet = "internet"
+
+	// Default VXLAN ID of overlay networks.
+	defaultVxlanId = 4096
 )
 
 // ExternalInterface is a host network interface that bridges containers to external networks.
@@ -38,12 +46,67 @@ type network struct {
 	Id               string
 	HnsId            string `json:",omitempty"`
 	Mode             string
+	NetworkType      string `json:",omitempty"`
 	VlanId           int
+	VxlanId          int    `json:",omitempty"`
+	OverlayEncap     string `json:",omitempty"`
+	GeneveTenantId   string `json:",omitempty"`
 	Subnets          []SubnetInfo
 	Endpoints        map[string]*endpoint
 	extIf            *externalInterface
 	DNS              DNSInfo
 	EnableSnatOnHost bool
+	SnatBridgeName   string   `json:",omitempty"`
+	SnatTraffic      []string `json:",omitempty"`
+	BridgeDatapath   string   `json:",omitempty"`
+
+	MasqueradeExclusions []string `json:",omitempty"`
+
+	// Remote VTEPs of overlay networks.
+	Vteps []VtepInfo `json:",omitempty"`
+}
+
+// VtepInfo is the VXLAN tunnel endpoint of a remote node of an overlay network. The node serves
+// the pods of a prefix, and its VTEP has the first address of the prefix.
+type VtepInfo struct {
+	NodeIP    net.IP
+	PodPrefix net.IPNet
+}
+
+// getVxlanId returns the VXLAN ID of an overlay network.
+func getVxlanId(nwInfo *NetworkInfo) int {
+	if nwInfo.VxlanId != 0 {
+		return nwInfo.VxlanId
+	}
+
+	return defaultVxlanId
+}
+
+// getOverlayMac returns the MAC address of an endpoint with the given IPv4 address in an overlay
+// network, derived from the address so that it is known to remote hosts.
+func getOverlayMac(ip net.IP) net.HardwareAddr {
+	ip = ip.To4()
+	if ip == nil {
+		return nil
+	}
+
+	return net.HardwareAddr{0x0e, 0x2a, ip[0], ip[1], ip[2], ip[3]}
+}
+
+// IsSnatTrafficEnabled returns whether a class of traffic is SNATed through the host.
+// Infra and internet traffic are SNATed unless the classes are set.
+func IsSnatTrafficEnabled(snatTraffic []string, class string) bool {
+	if len(snatTraffic) == 0 {
+		return class != SnatTrafficHost
+	}
+
+	for _, c := range snatTraffic {
+		if c == class {
+			return true
+		}
+	}
+
+	return false
 }
 
 // NetworkInfo contains read-only information about a container network.
@@ -51,12 +114,25 @@ type NetworkInfo struct {
 	MasterIfName     string
 	Id               string
 	Mode             string
+	NetworkType      string
+	VxlanId          int
+	OverlayEncap     string
+	GeneveTenantId   string
 	Subnets          []SubnetInfo
 	DNS              DNSInfo
 	Policies         []policy.Policy
 	BridgeName       string
 	EnableSnatOnHost bool
 	Options          map[string]interface{}
+
+	// Destinations the traffic of the network is never SNATed to.
+	MasqueradeExclusions []string
+
+	// Offload the OVS datapath of multitenant networks to the host NIC.
+	EnableHardwareOffload bool
+
+	// Program the MAC NAT rules of Linux bridge networks with ebtables or nftables.
+	BridgeDatapath string
 }
 
 // SubnetInfo contains subnet information for a container network.
@@ -70,10 +146,14 @@ type SubnetInfo struct {
 type DNSInfo struct {
 	Suffix  string
 	Servers []string
+	Search  []string `json:",omitempty"`
+	Options []string `json:",omitempty"`
 }
 
 // NewExternalInterface adds a host interface to the list of available external interfaces.
 func (nm *networkManager) newExternalInterface(ifName string, subnet string) error {
+	ifName = getExternalInterfaceNameImpl(ifName)
+
 	// Check whether the external interface is already configured.
 	if nm.ExternalInterfaces[ifName] != nil {
 		return nil
@@ -126,7 +206,7 @@ func (nm *networkManager) findExternalInterfaceBySubnet(subnet string) *external
 
 // FindExternalInterfaceByName finds an external interface by name.
 func (nm *networkManager) findExternalInterfaceByName(ifName string) *externalInterface {
-	extIf, exists := nm.ExternalInterfaces[ifName]
+	extIf, exists := nm.ExternalInterfaces[getExternalInterfaceNameImpl(ifName)]
 	if exists && extIf != nil {
 		return extIf
 	}
@@ -214,6 +294,47 @@ func (nm *networkManager) deleteNetwork(networkId string) error {
 	return nil
 }
 
+// AddNetworkSubnet adds a subnet to an existing container network.
+func (nm *networkManager) addNetworkSubnet(networkId string, subnet *SubnetInfo) error {
+	log.Printf("[net] Adding subnet %+v to network %v.", subnet, networkId)
+
+	nw, err := nm.getNetwork(networkId)
+	if err != nil {
+		return err
+	}
+
+	prefix := subnet.Prefix.String()
+	for _, s := range nw.Subnets {
+		if s.Prefix.String() == prefix {
+			return nil
+		}
+	}
+
+	// Call the OS-specific implementation.
+	err = nm.addNetworkSubnetImpl(nw, subnet)
+	if err != nil {
+		log.Printf("[net] Failed to add subnet to network %v, err:%v.", networkId, err)
+		return err
+	}
+
+	nw.Subnets = append(nw.Subnets, *subnet)
+
+	// Keep the external interface discoverable by the new subnet.
+	found := false
+	for _, s := range nw.extIf.Subnets {
+		if s == prefix {
+			found = true
+			break
+		}
+	}
+	if !found {
+		nw.extIf.Subnets = append(nw.extIf.Subnets, prefix)
+	}
+
+	log.Printf("[net] Added subnet %v to network %v.", prefix, networkId)
+	return nil
+}
+
 // GetNetwork returns the network with the given ID.
 func (nm *networkManager) getNetwork(networkId string) (*network, error) {
 	for _, extIf := range nm.ExternalInterfaces {
@@ -6,11 +6,15 @@ package network
 import (
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/Azure/azure-container-networking/ebtables"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/netlink"
+	"github.com/Azure/azure-container-networking/nftables"
 	"golang.org/x/sys/unix"
 )
 
@@ -23,11 +27,23 @@ const (
 
 	SnatBridgeIPKey = "snatBridgeIP"
 
+	SnatBridgeNameKey = "snatBridgeName"
+
+	SnatTrafficKey = "snatTraffic"
+
 	LocalIPKey = "localIP"
 
 	InfraVnetIPKey = "infraVnetIP"
 
 	OptVethName = "vethname"
+
+	// Kinds of the interfaces aggregating the links of their slaves.
+	linkKindBond = "bond"
+	linkKindTeam = "team"
+
+	// Datapaths programming the MAC NAT rules of Linux bridge networks.
+	bridgeDatapathEbtables = "ebtables"
+	bridgeDatapathNftables = "nftables"
 )
 
 // Linux implementation of route.
@@ -53,11 +69,22 @@ func (nm *networkManager) newNetworkImpl(nwInfo *NetworkInfo, extIf *externalInt
 			vlanid, _ = strconv.Atoi(opt[VlanIDKey].(string))
 		}
 	case opModeTransparent:
-		break
+		if isOverlayNetwork(nwInfo.NetworkType) {
+			if err := createOverlayInterface(nwInfo, extIf); err != nil {
+				return nil, err
+			}
+		}
 	default:
 		return nil, errNetworkModeInvalid
 	}
 
+	var snatBridgeName string
+	var snatTraffic []string
+	if opt != nil {
+		snatBridgeName, _ = opt[SnatBridgeNameKey].(string)
+		snatTraffic, _ = opt[SnatTrafficKey].([]string)
+	}
+
 	// Create the network object.
 	nw := &network{
 		Id:               nwInfo.Id,
@@ -67,6 +94,24 @@ func (nm *networkManager) newNetworkImpl(nwInfo *NetworkInfo, extIf *externalInt
 		VlanId:           vlanid,
 		DNS:              nwInfo.DNS,
 		EnableSnatOnHost: nwInfo.EnableSnatOnHost,
+		SnatBridgeName:   snatBridgeName,
+		SnatTraffic:      snatTraffic,
+
+		MasqueradeExclusions: nwInfo.MasqueradeExclusions,
+	}
+
+	if strings.EqualFold(nwInfo.BridgeDatapath, bridgeDatapathNftables) {
+		nw.BridgeDatapath = bridgeDatapathNftables
+	}
+
+	if isOverlayNetwork(nwInfo.NetworkType) {
+		nw.NetworkType = overlayNetworkType
+		nw.VxlanId = getVxlanId(nwInfo)
+
+		if isGeneveOverlay(nwInfo.OverlayEncap) {
+			nw.OverlayEncap = overlayEncapGeneve
+			nw.GeneveTenantId = nwInfo.GeneveTenantId
+		}
 	}
 
 	return nw, nil
@@ -76,8 +121,12 @@ func (nm *networkManager) newNetworkImpl(nwInfo *NetworkInfo, extIf *externalInt
 func (nm *networkManager) deleteNetworkImpl(nw *network) error {
 	var networkClient NetworkClient
 
+	if isOverlayNetwork(nw.NetworkType) {
+		deleteOverlayInterface(nw)
+	}
+
 	if nw.VlanId != 0 {
-		networkClient = NewOVSClient(nw.extIf.BridgeName, nw.extIf.Name, "", nw.DNS.Servers, nw.EnableSnatOnHost)
+		networkClient = NewOVSClient(nw.extIf.BridgeName, nw.extIf.Name, "", nw.SnatBridgeName, nw.SnatTraffic, nw.MasqueradeExclusions, nw.DNS.Servers, nw.EnableSnatOnHost, false)
 	} else {
 		networkClient = NewLinuxBridgeClient(nw.extIf.BridgeName, nw.extIf.Name, nw.Mode)
 	}
@@ -90,6 +139,100 @@ func (nm *networkManager) deleteNetworkImpl(nw *network) error {
 	return nil
 }
 
+// isAggregateInterface checks if a host interface is a bond or team device.
+func isAggregateInterface(ifName string) bool {
+	kind, err := netlink.GetLinkKind(ifName)
+	return err == nil && (kind == linkKindBond || kind == linkKindTeam)
+}
+
+// getInterfaceSlaves returns the names of the slaves of a host interface.
+func getInterfaceSlaves(ifName string) []string {
+	var slaves []string
+
+	links, _ := filepath.Glob(filepath.Join("/sys/class/net", ifName, "lower_*"))
+	for _, link := range links {
+		slaves = append(slaves, strings.TrimPrefix(filepath.Base(link), "lower_"))
+	}
+
+	return slaves
+}
+
+// getExternalInterfaceNameImpl returns the bond or team device a host interface is a slave of,
+// or the interface itself. Slaves share the MAC address of their master but can't be bridged.
+func getExternalInterfaceNameImpl(ifName string) string {
+	master, err := os.Readlink(filepath.Join("/sys/class/net", ifName, "master"))
+	if err != nil {
+		return ifName
+	}
+
+	master = filepath.Base(master)
+	if !isAggregateInterface(master) {
+		return ifName
+	}
+
+	log.Printf("[net] Using %v as the external interface of its slave %v.", master, ifName)
+
+	return master
+}
+
+// syncL2RulesImpl programs the ebtables rules of all Linux bridge networks and their endpoints,
+// removing the rules of networks and endpoints that no longer exist. The rules of networks using
+// the nftables datapath are translated to the nftables bridge table instead.
+func (nm *networkManager) syncL2RulesImpl() error {
+	if err := ebtables.SyncRules(nm.getL2Rules(bridgeDatapathEbtables)); err != nil {
+		return err
+	}
+
+	return nftables.SyncRules(nm.getL2Rules(bridgeDatapathNftables))
+}
+
+// getL2Rules returns the ebtables rules of the Linux bridge networks using a datapath and their endpoints.
+func (nm *networkManager) getL2Rules(datapath string) []ebtables.Rule {
+	var rules []ebtables.Rule
+
+	for _, extIf := range nm.ExternalInterfaces {
+		if extIf.BridgeName == "" {
+			continue
+		}
+
+		networkRulesAdded := false
+		for _, nw := range extIf.Networks {
+			if nw.VlanId != 0 || nw.Mode == opModeTransparent || getBridgeDatapath(nw) != datapath {
+				continue
+			}
+
+			if !networkRulesAdded {
+				networkClient := NewLinuxBridgeClient(extIf.BridgeName, extIf.Name, nw.Mode)
+				rules = append(rules, networkClient.getL2Rules(extIf)...)
+				networkRulesAdded = true
+			}
+
+			epClient := NewLinuxBridgeEndpointClient(extIf, "", "", nw.Mode)
+			for _, ep := range nw.Endpoints {
+				rules = append(rules, epClient.getEndpointRules(ep)...)
+			}
+		}
+	}
+
+	return rules
+}
+
+// getBridgeDatapath returns the datapath programming the MAC NAT rules of a Linux bridge network.
+func getBridgeDatapath(nw *network) string {
+	if nw.BridgeDatapath == "" {
+		return bridgeDatapathEbtables
+	}
+
+	return nw.BridgeDatapath
+}
+
+// AddNetworkSubnetImpl adds a subnet to an existing container network.
+func (nm *networkManager) addNetworkSubnetImpl(nw *network, subnet *SubnetInfo) error {
+	// Endpoints in the new subnet reach its gateway through the same external interface,
+	// so only the endpoint routes need to change.
+	return nil
+}
+
 //  SaveIPConfig saves the IP configuration of an interface.
 func (nm *networkManager) saveIPConfig(hostIf *net.Interface, extIf *externalInterface) error {
 	// Save the default routes on the interface.
@@ -201,7 +344,10 @@ func (nm *networkManager) connectExternalInterface(extIf *externalInterface, nwI
 			snatBridgeIP, _ = opt[SnatBridgeIPKey].(string)
 		}
 
-		networkClient = NewOVSClient(bridgeName, extIf.Name, snatBridgeIP, nwInfo.DNS.Servers, nwInfo.EnableSnatOnHost)
+		snatBridgeName, _ := opt[SnatBridgeNameKey].(string)
+		snatTraffic, _ := opt[SnatTrafficKey].([]string)
+
+		networkClient = NewOVSClient(bridgeName, extIf.Name, snatBridgeIP, snatBridgeName, snatTraffic, nwInfo.MasqueradeExclusions, nwInfo.DNS.Servers, nwInfo.EnableSnatOnHost, nwInfo.EnableHardwareOffload)
 	} else {
 		networkClient = NewLinuxBridgeClient(bridgeName, extIf.Name, nwInfo.Mode)
 	}
@@ -231,6 +377,16 @@ func (nm *networkManager) connectExternalInterface(extIf *externalInterface, nwI
 		log.Printf("[net] Found existing bridge %v.", bridgeName)
 	}
 
+	// The bridge takes the lowest MAC address of its ports by default, so make sure it keeps
+	// the address of the bond or team device, which its slaves are known by to the fabric.
+	if isAggregateInterface(hostIf.Name) {
+		log.Printf("[net] Interface %v aggregates %v.", hostIf.Name, getInterfaceSlaves(hostIf.Name))
+		log.Printf("[net] Setting link %v address %v.", bridgeName, hostIf.HardwareAddr)
+		if err = netlink.SetLinkAddress(bridgeName, hostIf.HardwareAddr); err != nil {
+			return err
+		}
+	}
+
 	// Save host IP configuration.
 	err = nm.saveIPConfig(hostIf, extIf)
 	if err != nil {
@@ -324,6 +480,12 @@ func getNetworkInfoImpl(nwInfo *NetworkInfo, nw *network) {
 		vlanMap[VlanIDKey] = strconv.Itoa(nw.VlanId)
 		nwInfo.Options[genericData] = vlanMap
 	}
+
+	nwInfo.NetworkType = nw.NetworkType
+	nwInfo.VxlanId = nw.VxlanId
+	nwInfo.OverlayEncap = nw.OverlayEncap
+	nwInfo.GeneveTenantId = nw.GeneveTenantId
+	nwInfo.BridgeDatapath = nw.BridgeDatapath
 }
 
 func AddStaticRoute(ip string, interfaceName string) error {
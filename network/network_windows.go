@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/network/hcn"
 	"github.com/Azure/azure-container-networking/network/policy"
 	"github.com/Microsoft/hcsshim"
 )
@@ -18,20 +19,157 @@ const (
 	// HNS network types.
 	hnsL2bridge      = "l2bridge"
 	hnsL2tunnel      = "l2tunnel"
+	hnsOverlay       = "overlay"
 	CnetAddressSpace = "cnetAddressSpace"
 )
 
+// HCN network types of the HNS network types.
+var hcnNetworkTypes = map[string]hcn.NetworkType{
+	hnsL2bridge: hcn.L2Bridge,
+	hnsL2tunnel: hcn.L2Tunnel,
+	hnsOverlay:  hcn.Overlay,
+}
+
 // Windows implementation of route.
 type route interface{}
 
 // NewNetworkImpl creates a new container network.
+// Networks are created with the HCN API on hosts that implement it, and the HNS v1 API otherwise.
 func (nm *networkManager) newNetworkImpl(nwInfo *NetworkInfo, extIf *externalInterface) (*network, error) {
-	var vlanid int
-	networkAdapterName := extIf.Name
+	if hcn.IsSupported() {
+		return nm.newNetworkImplHnsV2(nwInfo, extIf)
+	}
+
+	return nm.newNetworkImplHnsV1(nwInfo, extIf)
+}
+
+// getNetworkType returns the HNS network type of a network.
+// The type defaults to the one of the network mode if not set.
+func getNetworkType(nwInfo *NetworkInfo) (string, error) {
+	networkType := strings.ToLower(nwInfo.NetworkType)
+
+	if networkType == "" {
+		switch nwInfo.Mode {
+		case opModeBridge:
+			return hnsL2bridge, nil
+		case opModeTunnel:
+			return hnsL2tunnel, nil
+		default:
+			return "", errNetworkModeInvalid
+		}
+	}
+
+	if _, ok := hcnNetworkTypes[networkType]; !ok {
+		return "", errNetworkTypeInvalid
+	}
+
+	return networkType, nil
+}
+
+// getNetworkAdapterName returns the name of the host adapter of a network.
+func getNetworkAdapterName(extIf *externalInterface) string {
 	// FixMe: Find a better way to check if a nic that is selected is not part of a vSwitch
-	if strings.HasPrefix(networkAdapterName, "vEthernet") {
-		networkAdapterName = ""
+	if strings.HasPrefix(extIf.Name, "vEthernet") {
+		return ""
+	}
+
+	return extIf.Name
+}
+
+// getNetworkVlanID returns the VLAN ID of a network, 0 if not set.
+func getNetworkVlanID(nwInfo *NetworkInfo) int {
+	opt, _ := nwInfo.Options[genericData].(map[string]interface{})
+	if opt != nil && opt[VlanIDKey] != nil {
+		vlanID, _ := strconv.ParseUint(opt[VlanIDKey].(string), 10, 32)
+		return int(vlanID)
 	}
+
+	return 0
+}
+
+// newNetworkImplHnsV2 creates a new container network with the HCN API.
+func (nm *networkManager) newNetworkImplHnsV2(nwInfo *NetworkInfo, extIf *externalInterface) (*network, error) {
+	var vxlanid int
+	vlanid := getNetworkVlanID(nwInfo)
+
+	networkType, err := getNetworkType(nwInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := policy.GetHcnNetworkPolicies(nwInfo.Policies)
+	if err != nil {
+		return nil, err
+	}
+
+	hcnNetwork := &hcn.Network{
+		Name:          nwInfo.Id,
+		Type:          hcnNetworkTypes[networkType],
+		Policies:      policies,
+		Dns:           hcn.Dns{ServerList: nwInfo.DNS.Servers},
+		SchemaVersion: hcn.V2(),
+	}
+
+	if networkAdapterName := getNetworkAdapterName(extIf); networkAdapterName != "" {
+		adapterPolicy, _ := hcn.NewPolicy(hcn.NetAdapterName, map[string]string{"NetworkAdapterName": networkAdapterName})
+		hcnNetwork.Policies = append(hcnNetwork.Policies, adapterPolicy)
+	}
+
+	// Populate subnets. The VLAN and the VXLAN ID of overlay networks are policies of each subnet.
+	if networkType == hnsOverlay {
+		vxlanid = getVxlanId(nwInfo)
+	}
+
+	ipam := hcn.Ipam{Type: "Static"}
+	for _, subnet := range nwInfo.Subnets {
+		hcnSubnet := hcn.Subnet{
+			IpAddressPrefix: subnet.Prefix.String(),
+			Routes:          []hcn.Route{hcn.DefaultRoute(subnet.Gateway.String())},
+		}
+
+		if vlanid != 0 {
+			vlanPolicy, _ := hcn.NewPolicy(hcn.VLAN, map[string]uint32{"IsolationId": uint32(vlanid)})
+			hcnSubnet.Policies = append(hcnSubnet.Policies, vlanPolicy)
+		}
+
+		if vxlanid != 0 {
+			vsidPolicy, _ := hcn.NewPolicy(hcn.VSID, map[string]uint32{"IsolationId": uint32(vxlanid)})
+			hcnSubnet.Policies = append(hcnSubnet.Policies, vsidPolicy)
+		}
+
+		ipam.Subnets = append(ipam.Subnets, hcnSubnet)
+	}
+	hcnNetwork.Ipams = []hcn.Ipam{ipam}
+
+	// Create the HCN network.
+	log.Printf("[net] Creating HCN network %+v.", hcnNetwork)
+	hcnResponse, err := hcn.CreateNetwork(hcnNetwork)
+	if err != nil {
+		log.Printf("[net] Failed to create HCN network, err:%v.", err)
+		return nil, err
+	}
+	log.Printf("[net] Created HCN network %+v.", hcnResponse)
+
+	// Create the network object.
+	nw := &network{
+		Id:               nwInfo.Id,
+		HnsId:            hcnResponse.Id,
+		Mode:             nwInfo.Mode,
+		NetworkType:      networkType,
+		Endpoints:        make(map[string]*endpoint),
+		extIf:            extIf,
+		VlanId:           vlanid,
+		VxlanId:          vxlanid,
+		EnableSnatOnHost: nwInfo.EnableSnatOnHost,
+	}
+
+	return nw, nil
+}
+
+// newNetworkImplHnsV1 creates a new container network with the HNS v1 API.
+func (nm *networkManager) newNetworkImplHnsV1(nwInfo *NetworkInfo, extIf *externalInterface) (*network, error) {
+	var vlanid int
+	networkAdapterName := getNetworkAdapterName(extIf)
 	// Initialize HNS network.
 	hnsNetwork := &hcsshim.HNSNetwork{
 		Name:               nwInfo.Id,
@@ -41,37 +179,40 @@ func (nm *networkManager) newNetworkImpl(nwInfo *NetworkInfo, extIf *externalInt
 	}
 
 	// Set the VLAN and OutboundNAT policies
-	opt, _ := nwInfo.Options[genericData].(map[string]interface{})
-	if opt != nil && opt[VlanIDKey] != nil {
+	if vlanid = getNetworkVlanID(nwInfo); vlanid != 0 {
 		vlanPolicy := hcsshim.VlanPolicy{
 			Type: "VLAN",
+			VLAN: uint(vlanid),
 		}
-		vlanID, _ := strconv.ParseUint(opt[VlanIDKey].(string), 10, 32)
-		vlanPolicy.VLAN = uint(vlanID)
 
 		serializedVlanPolicy, _ := json.Marshal(vlanPolicy)
 		hnsNetwork.Policies = append(hnsNetwork.Policies, serializedVlanPolicy)
+	}
 
-		vlanid = (int)(vlanPolicy.VLAN)
+	// Set network type.
+	networkType, err := getNetworkType(nwInfo)
+	if err != nil {
+		return nil, err
 	}
+	hnsNetwork.Type = networkType
 
-	// Set network mode.
-	switch nwInfo.Mode {
-	case opModeBridge:
-		hnsNetwork.Type = hnsL2bridge
-	case opModeTunnel:
-		hnsNetwork.Type = hnsL2tunnel
-	default:
-		return nil, errNetworkModeInvalid
+	// Populate subnets. The VXLAN ID of overlay networks is a policy of each subnet.
+	var vxlanid int
+	if networkType == hnsOverlay {
+		vxlanid = getVxlanId(nwInfo)
 	}
 
-	// Populate subnets.
 	for _, subnet := range nwInfo.Subnets {
 		hnsSubnet := hcsshim.Subnet{
 			AddressPrefix:  subnet.Prefix.String(),
 			GatewayAddress: subnet.Gateway.String(),
 		}
 
+		if vxlanid != 0 {
+			vsidPolicy, _ := json.Marshal(hcsshim.VsidPolicy{Type: hcsshim.VSID, VSID: uint(vxlanid)})
+			hnsSubnet.Policies = append(hnsSubnet.Policies, vsidPolicy)
+		}
+
 		hnsNetwork.Subnets = append(hnsNetwork.Subnets, hnsSubnet)
 	}
 
@@ -95,9 +236,11 @@ func (nm *networkManager) newNetworkImpl(nwInfo *NetworkInfo, extIf *externalInt
 		Id:               nwInfo.Id,
 		HnsId:            hnsResponse.Id,
 		Mode:             nwInfo.Mode,
+		NetworkType:      networkType,
 		Endpoints:        make(map[string]*endpoint),
 		extIf:            extIf,
 		VlanId:           vlanid,
+		VxlanId:          vxlanid,
 		EnableSnatOnHost: nwInfo.EnableSnatOnHost,
 	}
 
@@ -114,6 +257,14 @@ func (nm *networkManager) newNetworkImpl(nwInfo *NetworkInfo, extIf *externalInt
 
 // DeleteNetworkImpl deletes an existing container network.
 func (nm *networkManager) deleteNetworkImpl(nw *network) error {
+	if hcn.IsSupported() {
+		// Delete the HCN network.
+		log.Printf("[net] Deleting HCN network %v.", nw.HnsId)
+		err := hcn.DeleteNetwork(nw.HnsId)
+		log.Printf("[net] HCN network %v deleted with err:%v.", nw.HnsId, err)
+		return err
+	}
+
 	// Delete the HNS network.
 	log.Printf("[net] HNSNetworkRequest DELETE id:%v", nw.HnsId)
 	hnsResponse, err := hcsshim.HNSNetworkRequest("DELETE", nw.HnsId, "")
@@ -122,5 +273,29 @@ func (nm *networkManager) deleteNetworkImpl(nw *network) error {
 	return err
 }
 
+// getExternalInterfaceNameImpl returns the name of the external interface of a host interface.
+func getExternalInterfaceNameImpl(ifName string) string {
+	return ifName
+}
+
+// syncL2RulesImpl does nothing on Windows, where HNS programs the L2 rules.
+func (nm *networkManager) syncL2RulesImpl() error {
+	return nil
+}
+
+// updateOverlayVtepsImpl sets the remote VTEPs of an overlay network.
+// HNS programs the remote VTEPs of overlay networks on Windows.
+func (nm *networkManager) updateOverlayVtepsImpl(nw *network, vteps []VtepInfo) error {
+	return errOverlayVtepsNotSupported
+}
+
+// AddNetworkSubnetImpl adds a subnet to an existing container network.
+func (nm *networkManager) addNetworkSubnetImpl(nw *network, subnet *SubnetInfo) error {
+	// HNS networks can not be updated with new subnets.
+	return errSubnetNotSupported
+}
+
 func getNetworkInfoImpl(nwInfo *NetworkInfo, nw *network) {
+	nwInfo.NetworkType = nw.NetworkType
+	nwInfo.VxlanId = nw.VxlanId
 }
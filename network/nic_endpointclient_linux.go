@@ -0,0 +1,208 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/netlink"
+	"github.com/Azure/azure-container-networking/network/epcommon"
+)
+
+// NICEndpointClient attaches a host NIC to a container instead of a veth pair, for VM workloads
+// such as KubeVirt that expect a full NIC. The NIC keeps its MAC address, and is moved back to
+// the host when the endpoint is deleted.
+type NICEndpointClient struct {
+	hostPrimaryMac  net.HardwareAddr
+	nicMac          net.HardwareAddr
+	nicName         string
+	containerIfName string
+}
+
+func NewNICEndpointClient(extIf *externalInterface, nicMac net.HardwareAddr) *NICEndpointClient {
+	client := &NICEndpointClient{
+		hostPrimaryMac: extIf.MacAddress,
+		nicMac:         nicMac,
+	}
+
+	return client
+}
+
+// getInterfaceByMac returns the interface with a MAC address in the current network namespace.
+func getInterfaceByMac(mac net.HardwareAddr) (*net.Interface, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range interfaces {
+		if bytes.Equal(interfaces[i].HardwareAddr, mac) {
+			return &interfaces[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("Interface with MAC address %v not found", mac)
+}
+
+func (client *NICEndpointClient) AddEndpoints(epInfo *EndpointInfo) error {
+	// Never hand the interface the host reaches the network through to a container.
+	if bytes.Equal(client.nicMac, client.hostPrimaryMac) {
+		return fmt.Errorf("NIC %v is the primary interface of the network", client.nicMac)
+	}
+
+	nic, err := getInterfaceByMac(client.nicMac)
+	if err != nil {
+		return err
+	}
+
+	client.nicName = nic.Name
+
+	log.Printf("[net] Setting link %v state down.", client.nicName)
+	return netlink.SetLinkState(client.nicName, false)
+}
+
+func (client *NICEndpointClient) AddEndpointRules(epInfo *EndpointInfo) error {
+	// Traffic of the NIC doesn't go through the host.
+	return nil
+}
+
+func (client *NICEndpointClient) DeleteEndpointRules(ep *endpoint) {
+}
+
+func (client *NICEndpointClient) MoveEndpointsToContainerNS(epInfo *EndpointInfo, nsID uintptr) error {
+	log.Printf("[net] Setting link %v netns %v.", client.nicName, epInfo.NetNsPath)
+	return netlink.SetLinkNetNs(client.nicName, nsID)
+}
+
+func (client *NICEndpointClient) SetupContainerInterfaces(epInfo *EndpointInfo) error {
+	if err := epcommon.SetupContainerInterface(client.nicName, epInfo.IfName); err != nil {
+		return err
+	}
+
+	client.containerIfName = epInfo.IfName
+
+	// Workloads inside the container are reached at the MAC address of the NIC.
+	return preserveNICMacAddress(client.containerIfName, client.nicMac)
+}
+
+func (client *NICEndpointClient) ConfigureContainerInterfacesAndRoutes(epInfo *EndpointInfo) error {
+	ifName := client.containerIfName
+	if ifName == "" {
+		ifName = client.nicName
+	}
+
+	if err := epcommon.AssignIPToInterface(ifName, epInfo.IPAddresses); err != nil {
+		return err
+	}
+
+	return addRoutes(ifName, epInfo.Routes)
+}
+
+// DeleteEndpoints moves the NIC back from the container to the host, and restores its name.
+// NICs left behind by containers whose network namespace is gone are already back on the host.
+func (client *NICEndpointClient) DeleteEndpoints(ep *endpoint) error {
+	// The NIC was never taken from the host.
+	if ep.NICName == "" {
+		return nil
+	}
+
+	if ep.NetworkNameSpace != "" {
+		if _, err := os.Stat(ep.NetworkNameSpace); err == nil {
+			if err = moveNICToHost(ep.NetworkNameSpace, ep.NICMacAddress, ""); err != nil {
+				return err
+			}
+		}
+	}
+
+	nic, err := getInterfaceByMac(ep.NICMacAddress)
+	if err != nil {
+		log.Printf("[net] Failed to find NIC %v on the host: %v.", ep.NICMacAddress, err)
+		return err
+	}
+
+	if err = netlink.SetLinkState(nic.Name, false); err != nil {
+		return err
+	}
+
+	if nic.Name != ep.NICName {
+		log.Printf("[net] Setting link %v name %v.", nic.Name, ep.NICName)
+		if err = netlink.SetLinkName(nic.Name, ep.NICName); err != nil {
+			return err
+		}
+		nic.Name = ep.NICName
+	}
+
+	if err = preserveNICMacAddress(nic.Name, ep.NICMacAddress); err != nil {
+		return err
+	}
+
+	log.Printf("[net] Setting link %v state up.", nic.Name)
+	return netlink.SetLinkState(nic.Name, true)
+}
+
+// moveNICToHost moves a NIC from a container network namespace to the network namespace of the caller.
+// If a host name is given, the NIC is renamed to it first, so that it doesn't clash with host interfaces.
+func moveNICToHost(nsPath string, mac net.HardwareAddr, hostName string) error {
+	hostNs, err := GetCurrentThreadNamespace()
+	if err != nil {
+		return err
+	}
+	defer hostNs.Close()
+
+	ns, err := OpenNamespace(nsPath)
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+
+	log.Printf("[net] Entering netns %v.", nsPath)
+	if err = ns.Enter(); err != nil {
+		return err
+	}
+
+	defer func() {
+		log.Printf("[net] Exiting netns %v.", nsPath)
+		if err := ns.Exit(); err != nil {
+			log.Printf("[net] Failed to exit netns, err:%v.", err)
+		}
+	}()
+
+	nic, err := getInterfaceByMac(mac)
+	if err != nil {
+		// The NIC was never moved into the container.
+		log.Printf("[net] NIC %v is not in netns %v.", mac, nsPath)
+		return nil
+	}
+
+	if err = netlink.SetLinkState(nic.Name, false); err != nil {
+		return err
+	}
+
+	if hostName != "" && nic.Name != hostName {
+		log.Printf("[net] Setting link %v name %v.", nic.Name, hostName)
+		if err = netlink.SetLinkName(nic.Name, hostName); err != nil {
+			return err
+		}
+		nic.Name = hostName
+	}
+
+	log.Printf("[net] Setting link %v netns to the host.", nic.Name)
+	return netlink.SetLinkNetNs(nic.Name, hostNs.GetFd())
+}
+
+// preserveNICMacAddress sets the MAC address of a NIC back if it changed.
+func preserveNICMacAddress(ifName string, mac net.HardwareAddr) error {
+	nic, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(nic.HardwareAddr, mac) {
+		return nil
+	}
+
+	log.Printf("[net] Setting link %v MAC address back to %v.", ifName, mac)
+	return netlink.SetLinkAddress(ifName, mac)
+}
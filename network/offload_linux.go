@@ -0,0 +1,199 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/ovsctl"
+	"github.com/Azure/azure-container-networking/platform"
+)
+
+const (
+	sysClassNetPath = "/sys/class/net"
+)
+
+// Names representors of VFs are given by the NIC driver, such as pf0vf3.
+var vfRepresentorPortName = regexp.MustCompile(`^(pf\d+)?vf(\d+)$`)
+
+// virtualFunction is a VF of a host NIC in switchdev mode. Its netdev is given to a container,
+// and its representor is plugged into OVS, so that flows between them are offloaded to the NIC.
+type virtualFunction struct {
+	index       int
+	name        string
+	representor string
+}
+
+// getPciAddress returns the PCI address of the device of an interface.
+func getPciAddress(ifName string) (string, error) {
+	device, err := filepath.EvalSymlinks(filepath.Join(sysClassNetPath, ifName, "device"))
+	if err != nil {
+		return "", fmt.Errorf("Interface %v is not a PCI device: %v", ifName, err)
+	}
+
+	return filepath.Base(device), nil
+}
+
+// isSwitchdevMode checks if the eswitch of a host NIC is in switchdev mode, which is needed to
+// offload OVS flows to the NIC.
+func isSwitchdevMode(ifName string) (bool, error) {
+	pciAddress, err := getPciAddress(ifName)
+	if err != nil {
+		return false, err
+	}
+
+	out, err := platform.ExecuteCommand(fmt.Sprintf("devlink dev eswitch show pci/%s", pciAddress))
+	if err != nil {
+		return false, err
+	}
+
+	return strings.Contains(out, "mode switchdev"), nil
+}
+
+// isTcOffloadEnabled checks if tc flower rules of an interface are offloaded to its NIC.
+func isTcOffloadEnabled(ifName string) bool {
+	out, err := platform.ExecuteCommand(fmt.Sprintf("ethtool -k %s", ifName))
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(out, "hw-tc-offload: on")
+}
+
+// enableHardwareOffload turns on tc offload on a host NIC in switchdev mode, and tells OVS to
+// offload its datapath flows. OVS reads the setting at start, so it must be restarted if it was off.
+func enableHardwareOffload(ifName string) error {
+	switchdev, err := isSwitchdevMode(ifName)
+	if err != nil {
+		return err
+	}
+
+	if !switchdev {
+		return errSwitchdevModeRequired
+	}
+
+	if !isTcOffloadEnabled(ifName) {
+		log.Printf("[net] Enabling tc offload on %v.", ifName)
+		if _, err = platform.ExecuteCommand(fmt.Sprintf("ethtool -K %s hw-tc-offload on", ifName)); err != nil {
+			return err
+		}
+	}
+
+	if ovsctl.IsHardwareOffloadEnabled() {
+		return nil
+	}
+
+	if err = ovsctl.EnableHardwareOffload(); err != nil {
+		return err
+	}
+
+	log.Printf("[net] Enabled OVS hardware offload, it takes effect when ovs-vswitchd restarts.")
+
+	return nil
+}
+
+// verifyHardwareOffload logs whether the flows of an offloaded endpoint can be offloaded to the NIC.
+// Flows fall back to the software datapath otherwise, so an endpoint works either way.
+func verifyHardwareOffload(representor string) {
+	if !ovsctl.IsHardwareOffloadEnabled() {
+		log.Printf("[net] OVS hardware offload is disabled, flows of %v are not offloaded.", representor)
+		return
+	}
+
+	if !isTcOffloadEnabled(representor) {
+		log.Printf("[net] tc offload is disabled on %v, its flows are not offloaded.", representor)
+		return
+	}
+
+	log.Printf("[net] Flows of %v are offloaded.", representor)
+}
+
+// allocateVF returns a VF of a host NIC whose netdev is in the host network namespace, and so
+// isn't given to a container.
+func allocateVF(pfName string) (*virtualFunction, error) {
+	virtfns, err := filepath.Glob(filepath.Join(sysClassNetPath, pfName, "device", "virtfn*"))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(virtfns)
+
+	for _, virtfn := range virtfns {
+		index, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(virtfn), "virtfn"))
+		if err != nil {
+			continue
+		}
+
+		// Netdevs of VFs in container network namespaces aren't listed.
+		netdevs, err := ioutil.ReadDir(filepath.Join(virtfn, "net"))
+		if err != nil || len(netdevs) == 0 {
+			continue
+		}
+
+		representor, err := getVFRepresentor(pfName, index)
+		if err != nil {
+			log.Printf("[net] Skipping VF %v of %v: %v.", index, pfName, err)
+			continue
+		}
+
+		return &virtualFunction{
+			index:       index,
+			name:        netdevs[0].Name(),
+			representor: representor,
+		}, nil
+	}
+
+	return nil, errNoFreeVF
+}
+
+// getVFRepresentor returns the representor of a VF, the interface on the same switch as the host
+// NIC with the port name of the VF.
+func getVFRepresentor(pfName string, index int) (string, error) {
+	switchID, err := readSysfsAttribute(pfName, "phys_switch_id")
+	if err != nil {
+		return "", err
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+
+	for _, iface := range interfaces {
+		if iface.Name == pfName {
+			continue
+		}
+
+		if id, err := readSysfsAttribute(iface.Name, "phys_switch_id"); err != nil || id != switchID {
+			continue
+		}
+
+		portName, err := readSysfsAttribute(iface.Name, "phys_port_name")
+		if err != nil {
+			continue
+		}
+
+		match := vfRepresentorPortName.FindStringSubmatch(portName)
+		if match != nil && match[2] == strconv.Itoa(index) {
+			return iface.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("Representor of VF %v not found", index)
+}
+
+// readSysfsAttribute returns an attribute of an interface.
+func readSysfsAttribute(ifName string, attribute string) (string, error) {
+	value, err := ioutil.ReadFile(filepath.Join(sysClassNetPath, ifName, attribute))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(value)), nil
+}
@@ -0,0 +1,359 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package network
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/netlink"
+	"github.com/Azure/azure-container-networking/platform"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Network type of networks whose pods reach the pods of other nodes through a VXLAN or Geneve overlay.
+	overlayNetworkType = "overlay"
+
+	// Encapsulation of the traffic of Geneve overlay networks. Overlay networks use VXLAN by default.
+	overlayEncapGeneve = "geneve"
+
+	// Prefix of the names of the VXLAN and Geneve interfaces of overlay networks.
+	vxlanInterfacePrefix  = commonInterfacePrefix + "vxlan"
+	geneveInterfacePrefix = commonInterfacePrefix + "gnv"
+
+	// UDP ports of the VXLAN and Geneve traffic of overlay networks.
+	vxlanPort  = 4789
+	genevePort = 6081
+
+	// Class and type of the Geneve option carrying the tenant ID of overlay networks, from the
+	// experimental range of option classes.
+	geneveTenantOptionClass = 0xfff0
+	geneveTenantOptionType  = 0x01
+)
+
+// isOverlayNetwork checks if a network type is the one of overlay networks.
+func isOverlayNetwork(networkType string) bool {
+	return strings.ToLower(networkType) == overlayNetworkType
+}
+
+// isGeneveOverlay checks if an overlay network encapsulation is Geneve.
+func isGeneveOverlay(encap string) bool {
+	return strings.ToLower(encap) == overlayEncapGeneve
+}
+
+// getOverlayInterfaceName returns the name of the VXLAN or Geneve interface of an overlay network.
+func getOverlayInterfaceName(encap string, vxlanId int) string {
+	if isGeneveOverlay(encap) {
+		return fmt.Sprintf("%s%d", geneveInterfacePrefix, vxlanId)
+	}
+
+	return fmt.Sprintf("%s%d", vxlanInterfacePrefix, vxlanId)
+}
+
+// getGeneveOptions returns the Geneve options of the traffic of an overlay network, carrying the
+// tenant ID of the network, a GUID such as the ID of a tenant or a network container, if it has one.
+func getGeneveOptions(tenantId string) ([]netlink.GeneveOption, error) {
+	if tenantId == "" {
+		return nil, nil
+	}
+
+	data, err := hex.DecodeString(strings.Replace(tenantId, "-", "", -1))
+	if err != nil || len(data) != 16 {
+		return nil, fmt.Errorf("Geneve tenant ID %s is not a GUID", tenantId)
+	}
+
+	return []netlink.GeneveOption{
+		{
+			Class: geneveTenantOptionClass,
+			Type:  geneveTenantOptionType,
+			Data:  data,
+		},
+	}, nil
+}
+
+// getVtepAddress returns the address of the VTEP of the node serving a pod prefix.
+func getVtepAddress(podPrefix net.IPNet) net.IP {
+	vtep := make(net.IP, net.IPv4len)
+	copy(vtep, podPrefix.IP.Mask(podPrefix.Mask).To4())
+	vtep[3]++
+
+	return vtep
+}
+
+// getHostIPv4Address returns the first IPv4 address of a host interface.
+func getHostIPv4Address(ifName string) (net.IP, error) {
+	iface, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Interface %s has no IPv4 address", ifName)
+}
+
+// createOverlayInterface creates the VTEP of the node in an overlay network. It encapsulates the traffic
+// of remote pods with the address of the external interface, and has the first address of the pod
+// prefix of the node, which is the gateway of its pods.
+func createOverlayInterface(nwInfo *NetworkInfo, extIf *externalInterface) error {
+	if len(nwInfo.Subnets) == 0 || nwInfo.Subnets[0].Prefix.IP.To4() == nil {
+		return fmt.Errorf("Overlay network %s has no IPv4 subnet", nwInfo.Id)
+	}
+
+	if _, err := getGeneveOptions(nwInfo.GeneveTenantId); err != nil {
+		return err
+	}
+
+	name := getOverlayInterfaceName(nwInfo.OverlayEncap, getVxlanId(nwInfo))
+
+	// The interface is left behind if the plugin failed while creating the network.
+	if _, err := net.InterfaceByName(name); err == nil {
+		log.Printf("[net] Deleting old overlay interface %v.", name)
+		if err = netlink.DeleteLink(name); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if isGeneveOverlay(nwInfo.OverlayEncap) {
+		err = addGeneveLink(name)
+	} else {
+		err = addVxlanLink(name, getVxlanId(nwInfo), extIf)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	vtep := getVtepAddress(nwInfo.Subnets[0].Prefix)
+	if err = netlink.SetLinkAddress(name, getOverlayMac(vtep)); err != nil {
+		netlink.DeleteLink(name)
+		return err
+	}
+
+	// The address of the VTEP is the only one of the pod prefix outside of pods.
+	log.Printf("[net] Adding IP address %v to VXLAN interface %v.", vtep, name)
+	if err = netlink.AddIpAddress(name, vtep, &net.IPNet{IP: vtep, Mask: net.CIDRMask(32, 32)}); err != nil {
+		netlink.DeleteLink(name)
+		return err
+	}
+
+	if err = netlink.SetLinkState(name, true); err != nil {
+		netlink.DeleteLink(name)
+		return err
+	}
+
+	// The host routes the traffic of pods between their veth pairs and the VXLAN interface.
+	_, err = platform.ExecuteCommand("echo 1 > /proc/sys/net/ipv4/ip_forward")
+
+	return err
+}
+
+// addVxlanLink adds a VXLAN interface sending its traffic from the address of the external interface.
+func addVxlanLink(name string, vxlanId int, extIf *externalInterface) error {
+	hostIf, err := net.InterfaceByName(extIf.Name)
+	if err != nil {
+		return err
+	}
+
+	srcAddr, err := getHostIPv4Address(extIf.Name)
+	if err != nil {
+		return err
+	}
+
+	link := netlink.VXLANLink{
+		LinkInfo: netlink.LinkInfo{
+			Type: netlink.LINK_TYPE_VXLAN,
+			Name: name,
+		},
+		VxlanId:  uint32(vxlanId),
+		SrcAddr:  srcAddr,
+		Port:     vxlanPort,
+		DevIndex: hostIf.Index,
+	}
+
+	log.Printf("[net] Adding VXLAN interface %v with VXLAN ID %v.", name, link.VxlanId)
+	return netlink.AddLink(&link)
+}
+
+// addGeneveLink adds a Geneve interface in external mode. The VNI, source address and options of its
+// traffic are set by the routes of the remote VTEPs. A node has a single Geneve interface in external
+// mode, so it has at most one Geneve overlay network.
+func addGeneveLink(name string) error {
+	link := netlink.GENEVELink{
+		LinkInfo: netlink.LinkInfo{
+			Type: netlink.LINK_TYPE_GENEVE,
+			Name: name,
+		},
+		Port: genevePort,
+	}
+
+	log.Printf("[net] Adding Geneve interface %v.", name)
+	return netlink.AddLink(&link)
+}
+
+// deleteOverlayInterface deletes the VTEP of the node in an overlay network, along with the routes,
+// neighbors and forwarding database entries of the remote VTEPs.
+func deleteOverlayInterface(nw *network) {
+	name := getOverlayInterfaceName(nw.OverlayEncap, nw.VxlanId)
+
+	log.Printf("[net] Deleting overlay interface %v.", name)
+	if err := netlink.DeleteLink(name); err != nil {
+		log.Printf("[net] Failed to delete overlay interface %v: %v.", name, err)
+	}
+}
+
+// getVtepRoute returns the route of the pod prefix of a remote VTEP through the overlay interface.
+// Routes through Geneve interfaces carry the encapsulation of the traffic to the node of the VTEP.
+func getVtepRoute(nw *network, vtep VtepInfo, linkIndex int, localIP net.IP) (*netlink.Route, error) {
+	route := &netlink.Route{
+		Family:    unix.AF_INET,
+		Dst:       &vtep.PodPrefix,
+		Gw:        getVtepAddress(vtep.PodPrefix),
+		LinkIndex: linkIndex,
+		Flags:     unix.RTNH_F_ONLINK,
+	}
+
+	if isGeneveOverlay(nw.OverlayEncap) {
+		options, err := getGeneveOptions(nw.GeneveTenantId)
+		if err != nil {
+			return nil, err
+		}
+
+		route.Encap = &netlink.IPTunnelEncap{
+			Id:            uint64(nw.VxlanId),
+			Dst:           vtep.NodeIP,
+			Src:           localIP,
+			GeneveOptions: options,
+		}
+	}
+
+	return route, nil
+}
+
+// addVtep routes the pod prefix of a remote VTEP through the overlay interface, and sends the
+// encapsulated traffic to its node.
+func addVtep(nw *network, ifName string, linkIndex int, localIP net.IP, vtep VtepInfo) error {
+	address := getVtepAddress(vtep.PodPrefix)
+	mac := getOverlayMac(address)
+
+	log.Printf("[net] Adding VTEP %v of node %v serving %v.", address, vtep.NodeIP, vtep.PodPrefix.String())
+
+	// Geneve interfaces in external mode have no forwarding database.
+	if !isGeneveOverlay(nw.OverlayEncap) {
+		if err := netlink.AddOrRemoveFdbEntry(netlink.ADD, ifName, mac, vtep.NodeIP); err != nil {
+			return err
+		}
+	}
+
+	if err := netlink.AddOrRemoveStaticArp(netlink.ADD, ifName, address, mac); err != nil {
+		return err
+	}
+
+	route, err := getVtepRoute(nw, vtep, linkIndex, localIP)
+	if err != nil {
+		return err
+	}
+
+	if err = netlink.AddIpRoute(route); err != nil && err != unix.EEXIST {
+		return err
+	}
+
+	return nil
+}
+
+// deleteVtep deletes the route, neighbor and forwarding database entry of a remote VTEP.
+func deleteVtep(nw *network, ifName string, linkIndex int, localIP net.IP, vtep VtepInfo) {
+	address := getVtepAddress(vtep.PodPrefix)
+	mac := getOverlayMac(address)
+
+	log.Printf("[net] Deleting VTEP %v of node %v serving %v.", address, vtep.NodeIP, vtep.PodPrefix.String())
+
+	if route, err := getVtepRoute(nw, vtep, linkIndex, localIP); err == nil {
+		if err = netlink.DeleteIpRoute(route); err != nil {
+			log.Printf("[net] Failed to delete route of VTEP %v: %v.", address, err)
+		}
+	}
+
+	if err := netlink.AddOrRemoveStaticArp(netlink.REMOVE, ifName, address, mac); err != nil {
+		log.Printf("[net] Failed to delete neighbor of VTEP %v: %v.", address, err)
+	}
+
+	if isGeneveOverlay(nw.OverlayEncap) {
+		return
+	}
+
+	if err := netlink.AddOrRemoveFdbEntry(netlink.REMOVE, ifName, mac, vtep.NodeIP); err != nil {
+		log.Printf("[net] Failed to delete forwarding database entry of VTEP %v: %v.", address, err)
+	}
+}
+
+// containsVtep checks if a list of VTEPs contains the given VTEP.
+func containsVtep(vteps []VtepInfo, vtep VtepInfo) bool {
+	for _, v := range vteps {
+		if v.NodeIP.Equal(vtep.NodeIP) && v.PodPrefix.String() == vtep.PodPrefix.String() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateOverlayVtepsImpl makes the given VTEPs, other than the one of this node, the remote VTEPs of an overlay network.
+func (nm *networkManager) updateOverlayVtepsImpl(nw *network, vteps []VtepInfo) error {
+	if !isOverlayNetwork(nw.NetworkType) {
+		return errNetworkTypeInvalid
+	}
+
+	name := getOverlayInterfaceName(nw.OverlayEncap, nw.VxlanId)
+	overlayIf, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+
+	localIP, err := getHostIPv4Address(nw.extIf.Name)
+	if err != nil {
+		return err
+	}
+
+	var remote []VtepInfo
+	for _, vtep := range vteps {
+		if vtep.NodeIP.Equal(localIP) || vtep.PodPrefix.IP.To4() == nil {
+			continue
+		}
+
+		remote = append(remote, vtep)
+	}
+
+	// VTEPs that moved to another node are deleted before they are added back.
+	for _, vtep := range nw.Vteps {
+		if !containsVtep(remote, vtep) {
+			deleteVtep(nw, name, overlayIf.Index, localIP, vtep)
+		}
+	}
+
+	nw.Vteps = remote
+
+	// All VTEPs are added again, as their routes are gone if the interface was recreated.
+	for _, vtep := range remote {
+		if err := addVtep(nw, name, overlayIf.Index, localIP, vtep); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
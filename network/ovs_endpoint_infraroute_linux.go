@@ -26,7 +26,7 @@ func AddInfraVnetEndpoint(client *OVSEndpointClient) error {
 
 func AddInfraEndpointRules(client *OVSEndpointClient, infraIP net.IPNet, hostPort string) error {
 	if client.enableInfraVnet {
-		return client.infraVnetClient.CreateInfraVnetRules(client.bridgeName, infraIP, client.hostPrimaryMac, hostPort)
+		return client.infraVnetClient.CreateInfraVnetRules(client.bridgeName, infraIP, client.hostPrimaryMac, hostPort, client.enableConntrack)
 	}
 
 	return nil
@@ -34,7 +34,7 @@ func AddInfraEndpointRules(client *OVSEndpointClient, infraIP net.IPNet, hostPor
 
 func DeleteInfraVnetEndpointRules(client *OVSEndpointClient, ep *endpoint, hostPort string) {
 	if client.enableInfraVnet {
-		client.infraVnetClient.DeleteInfraVnetRules(client.bridgeName, ep.InfraVnetIP, hostPort)
+		client.infraVnetClient.DeleteInfraVnetRules(client.bridgeName, ep.InfraVnetIP, hostPort, client.enableConntrack)
 	}
 }
 
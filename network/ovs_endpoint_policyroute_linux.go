@@ -0,0 +1,106 @@
+package network
+
+import (
+	"net"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/netlink"
+)
+
+const (
+	// Route tables of the network containers of a multitenant container, by VLAN ID.
+	ncRouteTableBase = 20000
+
+	// Priority of the rules looking up network container route tables, ahead of the main table.
+	ncRulePriority = 1000
+)
+
+// GetNCRouteTable returns the route table holding the routes of the network container of a VLAN.
+func GetNCRouteTable(vlanID int) int {
+	return ncRouteTableBase + vlanID
+}
+
+// AddPolicyRoutes makes traffic of the network container of a multitenant endpoint exit through its
+// own interface, as containers may be attached to the VLANs of several network containers. Packets
+// from the endpoint addresses, or marked with its VLAN ID, are routed using the routes of the
+// interface only. It's called in the container network namespace. Rules are removed with the namespace,
+// and the table of a deleted interface is empty, so its rules fall through to the main table.
+func AddPolicyRoutes(client *OVSEndpointClient, epInfo *EndpointInfo) error {
+	if !client.enableMultitenancy || len(epInfo.IPAddresses) == 0 {
+		return nil
+	}
+
+	containerIf, err := net.InterfaceByName(client.containerVethName)
+	if err != nil {
+		return err
+	}
+
+	table := GetNCRouteTable(client.vlanID)
+
+	for _, ipAddr := range epInfo.IPAddresses {
+		family := netlink.GetIpAddressFamily(ipAddr.IP)
+
+		// Subnet route of the interface.
+		subnet := &net.IPNet{IP: ipAddr.IP.Mask(ipAddr.Mask), Mask: ipAddr.Mask}
+		route := &netlink.Route{
+			Family:    family,
+			Dst:       subnet,
+			Src:       ipAddr.IP,
+			Scope:     netlink.RT_SCOPE_LINK,
+			Table:     table,
+			LinkIndex: containerIf.Index,
+		}
+
+		log.Printf("[ovs] Adding IP route %+v to NC table %v.", route, table)
+		if err := netlink.AddIpRoute(route); err != nil && !isExistsError(err) {
+			return err
+		}
+
+		_, bits := ipAddr.Mask.Size()
+		rule := &netlink.Rule{
+			Family:   family,
+			Src:      &net.IPNet{IP: ipAddr.IP, Mask: net.CIDRMask(bits, bits)},
+			Table:    table,
+			Priority: ncRulePriority,
+		}
+
+		log.Printf("[ovs] Adding IP rule %+v.", rule)
+		if err := netlink.AddIpRule(rule); err != nil && !isExistsError(err) {
+			return err
+		}
+	}
+
+	// Routes through the interface, e.g. its default route.
+	var routes []RouteInfo
+	for _, route := range epInfo.Routes {
+		if route.DevName == "" || route.DevName == client.containerVethName {
+			route.Table = table
+			routes = append(routes, route)
+		}
+	}
+
+	if err := addRoutes(client.containerVethName, routes); err != nil {
+		return err
+	}
+
+	// Applications can pick the network container of their connections by marking them.
+	rule := &netlink.Rule{
+		Family:   netlink.GetIpAddressFamily(epInfo.IPAddresses[0].IP),
+		Mark:     client.vlanID,
+		Table:    table,
+		Priority: ncRulePriority,
+	}
+
+	log.Printf("[ovs] Adding IP rule %+v.", rule)
+	if err := netlink.AddIpRule(rule); err != nil && !isExistsError(err) {
+		return err
+	}
+
+	return nil
+}
+
+// isExistsError checks if a netlink request failed because the object already exists.
+func isExistsError(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "file exists")
+}
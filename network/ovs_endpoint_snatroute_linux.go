@@ -6,9 +6,30 @@ import (
 	"github.com/Azure/azure-container-networking/network/ovssnat"
 )
 
-func NewSnatClient(client *OVSEndpointClient, epInfo *EndpointInfo) {
+// getSnatSkipAddresses returns the private addresses reachable through the SNAT bridge: the DNS
+// servers for infra traffic, and the addresses of the host for host traffic.
+func getSnatSkipAddresses(snatTraffic []string, dnsServers []string, extIf *externalInterface) []string {
+	var addresses []string
+
+	if IsSnatTrafficEnabled(snatTraffic, SnatTrafficInfra) {
+		addresses = append(addresses, dnsServers...)
+	}
+
+	if IsSnatTrafficEnabled(snatTraffic, SnatTrafficHost) {
+		for _, ipAddr := range extIf.IPAddresses {
+			if ipAddr.IP.To4() != nil {
+				addresses = append(addresses, ipAddr.IP.String())
+			}
+		}
+	}
+
+	return addresses
+}
+
+func NewSnatClient(client *OVSEndpointClient, extIf *externalInterface, epInfo *EndpointInfo) {
 	if client.enableSnatOnHost {
-		var localIP, snatBridgeIP string
+		var localIP, snatBridgeIP, snatBridgeName string
+		var snatTraffic []string
 
 		hostIfName := fmt.Sprintf("%s%s", snatVethInterfacePrefix, epInfo.Id[:7])
 		contIfName := fmt.Sprintf("%s%s-2", snatVethInterfacePrefix, epInfo.Id[:7])
@@ -21,7 +42,17 @@ func NewSnatClient(client *OVSEndpointClient, epInfo *EndpointInfo) {
 			snatBridgeIP = epInfo.Data[SnatBridgeIPKey].(string)
 		}
 
-		client.snatClient = ovssnat.NewSnatClient(hostIfName, contIfName, localIP, snatBridgeIP, epInfo.DNS.Servers)
+		snatBridgeName, _ = epInfo.Data[SnatBridgeNameKey].(string)
+		snatTraffic, _ = epInfo.Data[SnatTrafficKey].([]string)
+
+		// Multitenant endpoints can be isolated in the VRF of their tenant, identified by its VLAN.
+		var tenantVrfID int
+		if epInfo.EnableVrfIsolation {
+			tenantVrfID = client.vlanID
+		}
+
+		skipAddresses := getSnatSkipAddresses(snatTraffic, epInfo.DNS.Servers, extIf)
+		client.snatClient = ovssnat.NewSnatClient(hostIfName, contIfName, localIP, snatBridgeIP, snatBridgeName, skipAddresses, client.snatExclusions, tenantVrfID)
 	}
 }
 
@@ -2,6 +2,7 @@ package network
 
 import (
 	"net"
+	"os"
 
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/netlink"
@@ -12,17 +13,22 @@ import (
 )
 
 type OVSEndpointClient struct {
-	bridgeName        string
-	hostPrimaryIfName string
-	hostVethName      string
-	hostPrimaryMac    string
-	containerVethName string
-	containerMac      string
-	snatClient        ovssnat.OVSSnatClient
-	infraVnetClient   ovsinfravnet.OVSInfraVnetClient
-	vlanID            int
-	enableSnatOnHost  bool
-	enableInfraVnet   bool
+	bridgeName         string
+	hostPrimaryIfName  string
+	hostVethName       string
+	hostPrimaryMac     string
+	containerVethName  string
+	containerMac       string
+	snatClient         ovssnat.OVSSnatClient
+	snatExclusions     []string
+	infraVnetClient    ovsinfravnet.OVSInfraVnetClient
+	vlanID             int
+	enableSnatOnHost   bool
+	enableInfraVnet    bool
+	enableConntrack    bool
+	enableMultitenancy bool
+	enableHwOffload    bool
+	vf                 *virtualFunction
 }
 
 const (
@@ -36,27 +42,36 @@ func NewOVSEndpointClient(
 	hostVethName string,
 	containerVethName string,
 	vlanid int,
+	snatExclusions []string,
 ) *OVSEndpointClient {
 
 	client := &OVSEndpointClient{
-		bridgeName:        extIf.BridgeName,
-		hostPrimaryIfName: extIf.Name,
-		hostVethName:      hostVethName,
-		hostPrimaryMac:    extIf.MacAddress.String(),
-		containerVethName: containerVethName,
-		vlanID:            vlanid,
-		enableSnatOnHost:  epInfo.EnableSnatOnHost,
-		enableInfraVnet:   epInfo.EnableInfraVnet,
+		bridgeName:         extIf.BridgeName,
+		hostPrimaryIfName:  extIf.Name,
+		hostVethName:       hostVethName,
+		hostPrimaryMac:     extIf.MacAddress.String(),
+		containerVethName:  containerVethName,
+		vlanID:             vlanid,
+		enableSnatOnHost:   epInfo.EnableSnatOnHost,
+		enableInfraVnet:    epInfo.EnableInfraVnet,
+		enableConntrack:    epInfo.EnableConntrack,
+		enableMultitenancy: epInfo.EnableMultiTenancy,
+		enableHwOffload:    epInfo.EnableHardwareOffload,
+		snatExclusions:     snatExclusions,
 	}
 
 	NewInfraVnetClient(client, epInfo.Id[:7])
-	NewSnatClient(client, epInfo)
+	NewSnatClient(client, extIf, epInfo)
 
 	return client
 }
 
 func (client *OVSEndpointClient) AddEndpoints(epInfo *EndpointInfo) error {
-	if err := epcommon.CreateEndpoint(client.hostVethName, client.containerVethName); err != nil {
+	if client.enableHwOffload {
+		if err := client.addOffloadedEndpoint(); err != nil {
+			return err
+		}
+	} else if err := epcommon.CreateEndpoint(client.hostVethName, client.containerVethName); err != nil {
 		return err
 	}
 
@@ -85,6 +100,10 @@ func (client *OVSEndpointClient) AddEndpointRules(epInfo *EndpointInfo) error {
 		return err
 	}
 
+	if client.vf != nil {
+		verifyHardwareOffload(client.hostVethName)
+	}
+
 	log.Printf("[ovs] Get ovs port for interface %v.", client.hostVethName)
 	containerPort, err := ovsctl.GetOVSPortNumber(client.hostVethName)
 	if err != nil {
@@ -101,7 +120,12 @@ func (client *OVSEndpointClient) AddEndpointRules(epInfo *EndpointInfo) error {
 
 	// IP SNAT Rule
 	log.Printf("[ovs] Adding IP SNAT rule for egress traffic on %v.", containerPort)
-	if err := ovsctl.AddIpSnatRule(client.bridgeName, containerPort, client.hostPrimaryMac, ""); err != nil {
+	if client.enableConntrack {
+		// Connections are tracked in the zone of the tenant, as tenants may use the same addresses.
+		if err := ovsctl.AddConntrackIpSnatRule(client.bridgeName, containerPort, client.hostPrimaryMac, "", client.vlanID); err != nil {
+			return err
+		}
+	} else if err := ovsctl.AddIpSnatRule(client.bridgeName, containerPort, client.hostPrimaryMac, ""); err != nil {
 		return err
 	}
 
@@ -114,7 +138,11 @@ func (client *OVSEndpointClient) AddEndpointRules(epInfo *EndpointInfo) error {
 
 		// Add IP DNAT rule based on dst ip and vlanid
 		log.Printf("[ovs] Adding MAC DNAT rule for IP address %v on %v.", ipAddr.IP.String(), hostPort)
-		if err := ovsctl.AddMacDnatRule(client.bridgeName, hostPort, ipAddr.IP, client.containerMac, client.vlanID); err != nil {
+		if client.enableConntrack {
+			if err := ovsctl.AddConntrackMacDnatRule(client.bridgeName, hostPort, ipAddr.IP, client.containerMac, client.vlanID, client.vlanID, true); err != nil {
+				return err
+			}
+		} else if err := ovsctl.AddMacDnatRule(client.bridgeName, hostPort, ipAddr.IP, client.containerMac, client.vlanID); err != nil {
 			return err
 		}
 	}
@@ -149,7 +177,11 @@ func (client *OVSEndpointClient) DeleteEndpointRules(ep *endpoint) {
 
 	// Delete MAC address translation rule.
 	log.Printf("[ovs] Deleting MAC DNAT rule for IP address %v and vlan %v.", ep.IPAddresses[0].IP.String(), ep.VlanID)
-	ovsctl.DeleteMacDnatRule(client.bridgeName, hostPort, ep.IPAddresses[0].IP, ep.VlanID)
+	if client.enableConntrack {
+		ovsctl.DeleteConntrackMacDnatRule(client.bridgeName, hostPort, ep.IPAddresses[0].IP, ep.VlanID)
+	} else {
+		ovsctl.DeleteMacDnatRule(client.bridgeName, hostPort, ep.IPAddresses[0].IP, ep.VlanID)
+	}
 
 	// Delete port from ovs bridge
 	log.Printf("[ovs] Deleting interface %v from bridge %v", client.hostVethName, client.bridgeName)
@@ -201,10 +233,22 @@ func (client *OVSEndpointClient) ConfigureContainerInterfacesAndRoutes(epInfo *E
 		return err
 	}
 
-	return addRoutes(client.containerVethName, epInfo.Routes)
+	if err := addRoutes(client.containerVethName, epInfo.Routes); err != nil {
+		return err
+	}
+
+	return AddPolicyRoutes(client, epInfo)
 }
 
 func (client *OVSEndpointClient) DeleteEndpoints(ep *endpoint) error {
+	if ep.OffloadVF != "" {
+		if err := deleteOffloadedEndpoint(ep); err != nil {
+			return err
+		}
+
+		return DeleteInfraVnetEndpoint(client, ep.Id[:7])
+	}
+
 	log.Printf("[ovs] Deleting veth pair %v %v.", ep.HostIfName, ep.IfName)
 	err := netlink.DeleteLink(ep.HostIfName)
 	if err != nil {
@@ -214,3 +258,40 @@ func (client *OVSEndpointClient) DeleteEndpoints(ep *endpoint) error {
 
 	return DeleteInfraVnetEndpoint(client, ep.Id[:7])
 }
+
+// addOffloadedEndpoint takes a free VF of the host NIC for the container instead of creating a veth
+// pair. The representor of the VF stands for the container in OVS.
+func (client *OVSEndpointClient) addOffloadedEndpoint() error {
+	vf, err := allocateVF(client.hostPrimaryIfName)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[ovs] Using VF %v of %v with representor %v.", vf.name, client.hostPrimaryIfName, vf.representor)
+
+	log.Printf("[ovs] Setting link %v state up.", vf.representor)
+	if err = netlink.SetLinkState(vf.representor, true); err != nil {
+		return err
+	}
+
+	client.vf = vf
+	client.hostVethName = vf.representor
+	client.containerVethName = vf.name
+
+	return nil
+}
+
+// deleteOffloadedEndpoint gives the VF of an offloaded endpoint back to the host. VFs left behind by
+// containers whose network namespace is gone are already back on the host.
+func deleteOffloadedEndpoint(ep *endpoint) error {
+	if ep.NetworkNameSpace == "" || ep.MacAddress == nil {
+		return nil
+	}
+
+	if _, err := os.Stat(ep.NetworkNameSpace); err != nil {
+		return nil
+	}
+
+	log.Printf("[ovs] Moving VF %v back to the host.", ep.OffloadVF)
+	return moveNICToHost(ep.NetworkNameSpace, ep.MacAddress, ep.OffloadVF)
+}
@@ -12,11 +12,15 @@ import (
 )
 
 type OVSNetworkClient struct {
-	bridgeName             string
-	hostInterfaceName      string
-	snatBridgeIP           string
-	skipAddressesFromBlock []string
-	enableSnatOnHost       bool
+	bridgeName        string
+	hostInterfaceName string
+	snatBridgeIP      string
+	snatBridgeName    string
+	snatTraffic       []string
+	snatExclusions    []string
+	dnsServers        []string
+	enableSnatOnHost  bool
+	enableHwOffload   bool
 }
 
 const (
@@ -55,19 +59,36 @@ func updateOVSConfig(option string) error {
 	return nil
 }
 
-func NewOVSClient(bridgeName, hostInterfaceName, snatBridgeIP string, skipAddressesFromBlock []string, enableSnatOnHost bool) *OVSNetworkClient {
+func NewOVSClient(
+	bridgeName, hostInterfaceName, snatBridgeIP, snatBridgeName string,
+	snatTraffic []string,
+	snatExclusions []string,
+	dnsServers []string,
+	enableSnatOnHost bool,
+	enableHwOffload bool) *OVSNetworkClient {
 	ovsClient := &OVSNetworkClient{
-		bridgeName:             bridgeName,
-		hostInterfaceName:      hostInterfaceName,
-		snatBridgeIP:           snatBridgeIP,
-		skipAddressesFromBlock: skipAddressesFromBlock,
-		enableSnatOnHost:       enableSnatOnHost,
+		bridgeName:        bridgeName,
+		hostInterfaceName: hostInterfaceName,
+		snatBridgeIP:      snatBridgeIP,
+		snatBridgeName:    ovssnat.GetSnatBridgeName(snatBridgeName),
+		snatTraffic:       snatTraffic,
+		snatExclusions:    snatExclusions,
+		dnsServers:        dnsServers,
+		enableSnatOnHost:  enableSnatOnHost,
+		enableHwOffload:   enableHwOffload,
 	}
 
 	return ovsClient
 }
 
 func (client *OVSNetworkClient) CreateBridge() error {
+	if client.enableHwOffload {
+		if err := enableHardwareOffload(client.hostInterfaceName); err != nil {
+			log.Printf("[net] Enabling hardware offload on %v failed with error %v", client.hostInterfaceName, err)
+			return err
+		}
+	}
+
 	if err := ovsctl.CreateOVSBridge(client.bridgeName); err != nil {
 		return err
 	}
@@ -77,12 +98,12 @@ func (client *OVSNetworkClient) CreateBridge() error {
 	}
 
 	if client.enableSnatOnHost {
-		if err := ovssnat.CreateSnatBridge(client.snatBridgeIP, client.bridgeName); err != nil {
+		if err := ovssnat.CreateSnatBridge(client.snatBridgeName, client.snatBridgeIP, client.bridgeName); err != nil {
 			log.Printf("[net] Creating snat bridge failed with erro %v", err)
 			return err
 		}
 
-		if err := ovssnat.AddMasqueradeRule(client.snatBridgeIP); err != nil {
+		if err := ovssnat.AddMasqueradeRule(client.snatBridgeIP, client.snatExclusions); err != nil {
 			return err
 		}
 
@@ -99,9 +120,9 @@ func (client *OVSNetworkClient) DeleteBridge() error {
 	}
 
 	if client.enableSnatOnHost {
-		ovssnat.DeleteMasqueradeRule()
+		ovssnat.DeleteMasqueradeRule(client.snatBridgeName, client.snatExclusions)
 
-		if err := ovssnat.DeleteSnatBridge(client.bridgeName); err != nil {
+		if err := ovssnat.DeleteSnatBridge(client.snatBridgeName, client.bridgeName); err != nil {
 			log.Printf("Deleting snat bridge failed with error %v", err)
 			return err
 		}
@@ -131,7 +152,7 @@ func (client *OVSNetworkClient) AddL2Rules(extIf *externalInterface) error {
 	}
 
 	if client.enableSnatOnHost {
-		if err := epcommon.AddOrDeletePrivateIPBlockRule(ovssnat.SnatBridgeName, client.skipAddressesFromBlock, "A"); err != nil {
+		if err := epcommon.AddOrDeletePrivateIPBlockRule(client.snatBridgeName, getSnatSkipAddresses(client.snatTraffic, client.dnsServers, extIf), "A"); err != nil {
 			return err
 		}
 
@@ -145,7 +166,7 @@ func (client *OVSNetworkClient) DeleteL2Rules(extIf *externalInterface) {
 	ovsctl.DeletePortFromOVS(client.bridgeName, client.hostInterfaceName)
 
 	if client.enableSnatOnHost {
-		if err := epcommon.AddOrDeletePrivateIPBlockRule(ovssnat.SnatBridgeName, client.skipAddressesFromBlock, "D"); err != nil {
+		if err := epcommon.AddOrDeletePrivateIPBlockRule(client.snatBridgeName, getSnatSkipAddresses(client.snatTraffic, client.dnsServers, extIf), "D"); err != nil {
 			log.Printf("Deleting PrivateIPBlock rules failed with error %v", err)
 		}
 	}
@@ -12,6 +12,9 @@ import (
 
 const (
 	azureInfraIfName = "eth2"
+
+	// Conntrack zone of infra VNET connections, outside of the zones of tenant VLANs.
+	conntrackZone = 4096
 )
 
 type OVSInfraVnetClient struct {
@@ -57,7 +60,8 @@ func (client *OVSInfraVnetClient) CreateInfraVnetRules(
 	bridgeName string,
 	infraIP net.IPNet,
 	hostPrimaryMac string,
-	hostPort string) error {
+	hostPort string,
+	enableConntrack bool) error {
 
 	infraContainerPort, err := ovsctl.GetOVSPortNumber(client.hostInfraVethName)
 	if err != nil {
@@ -65,6 +69,21 @@ func (client *OVSInfraVnetClient) CreateInfraVnetRules(
 		return err
 	}
 
+	// With conntrack, only return traffic of the connections the container opened is let in.
+	if enableConntrack {
+		if err := ovsctl.AddConntrackIpSnatRule(bridgeName, infraContainerPort, hostPrimaryMac, hostPort, conntrackZone); err != nil {
+			log.Printf("[ovs] AddConntrackIpSnatRule failed with error %v", err)
+			return err
+		}
+
+		if err := ovsctl.AddConntrackMacDnatRule(bridgeName, hostPort, infraIP.IP, client.containerInfraMac, 0, conntrackZone, false); err != nil {
+			log.Printf("[ovs] AddConntrackMacDnatRule failed with error %v", err)
+			return err
+		}
+
+		return nil
+	}
+
 	if err := ovsctl.AddIpSnatRule(bridgeName, infraContainerPort, hostPrimaryMac, hostPort); err != nil {
 		log.Printf("[ovs] AddIpSnatRule failed with error %v", err)
 		return err
@@ -101,10 +120,15 @@ func (client *OVSInfraVnetClient) ConfigureInfraVnetContainerInterface(infraIP n
 func (client *OVSInfraVnetClient) DeleteInfraVnetRules(
 	bridgeName string,
 	infraIP net.IPNet,
-	hostPort string) {
+	hostPort string,
+	enableConntrack bool) {
 
 	log.Printf("[ovs] Deleting MAC DNAT rule for infravnet IP address %v", infraIP.IP.String())
-	ovsctl.DeleteMacDnatRule(bridgeName, hostPort, infraIP.IP, 0)
+	if enableConntrack {
+		ovsctl.DeleteConntrackMacDnatRule(bridgeName, hostPort, infraIP.IP, 0)
+	} else {
+		ovsctl.DeleteMacDnatRule(bridgeName, hostPort, infraIP.IP, 0)
+	}
 
 	log.Printf("[ovs] Get ovs port for infravnet interface %v.", client.hostInfraVethName)
 	infraContainerPort, err := ovsctl.GetOVSPortNumber(client.hostInfraVethName)
@@ -1,10 +1,10 @@
 package ovssnat
 
 import (
-	"fmt"
 	"net"
 	"strings"
 
+	"github.com/Azure/azure-container-networking/iptables"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/netlink"
 	"github.com/Azure/azure-container-networking/network/epcommon"
@@ -16,8 +16,10 @@ const (
 	azureSnatVeth0  = "azSnatveth0"
 	azureSnatVeth1  = "azSnatveth1"
 	azureSnatIfName = "eth1"
-	SnatBridgeName  = "azSnatbr"
 	ImdsIP          = "169.254.169.254/32"
+
+	// Name of the SNAT bridge when the network configuration doesn't set one.
+	DefaultSnatBridgeName = "azSnatbr"
 )
 
 type OVSSnatClient struct {
@@ -25,16 +27,31 @@ type OVSSnatClient struct {
 	containerSnatVethName  string
 	localIP                string
 	snatBridgeIP           string
+	snatBridgeName         string
+	snatExclusions         []string
+	tenantVrfID            int
 	SkipAddressesFromBlock []string
 }
 
-func NewSnatClient(hostIfName string, contIfName string, localIP string, snatBridgeIP string, skipAddressesFromBlock []string) OVSSnatClient {
+// NewSnatClient creates a SNAT client. If tenantVrfID isn't 0, the endpoint is isolated in the VRF of that tenant.
+func NewSnatClient(
+	hostIfName string,
+	contIfName string,
+	localIP string,
+	snatBridgeIP string,
+	snatBridgeName string,
+	skipAddressesFromBlock []string,
+	snatExclusions []string,
+	tenantVrfID int) OVSSnatClient {
 	log.Printf("Initialize new snat client")
 	snatClient := OVSSnatClient{}
 	snatClient.hostSnatVethName = hostIfName
 	snatClient.containerSnatVethName = contIfName
 	snatClient.localIP = localIP
 	snatClient.snatBridgeIP = snatBridgeIP
+	snatClient.snatBridgeName = GetSnatBridgeName(snatBridgeName)
+	snatClient.snatExclusions = snatExclusions
+	snatClient.tenantVrfID = tenantVrfID
 
 	for _, address := range skipAddressesFromBlock {
 		snatClient.SkipAddressesFromBlock = append(snatClient.SkipAddressesFromBlock, address)
@@ -46,12 +63,20 @@ func NewSnatClient(hostIfName string, contIfName string, localIP string, snatBri
 }
 
 func (client *OVSSnatClient) CreateSnatEndpoint(bridgeName string) error {
-	if err := CreateSnatBridge(client.snatBridgeIP, bridgeName); err != nil {
+	if err := CreateSnatBridge(client.snatBridgeName, client.snatBridgeIP, bridgeName); err != nil {
 		log.Printf("creating snat bridge failed with error %v", err)
 		return err
 	}
 
-	if err := AddMasqueradeRule(client.snatBridgeIP); err != nil {
+	// The bridge may have been created for the network container of another VLAN, with another gateway.
+	if client.tenantVrfID == 0 {
+		if err := AddSnatBridgeIP(client.snatBridgeName, client.snatBridgeIP); err != nil {
+			log.Printf("Adding snat bridge IP failed with error %v", err)
+			return err
+		}
+	}
+
+	if err := AddMasqueradeRule(client.snatBridgeIP, client.snatExclusions); err != nil {
 		log.Printf("Adding snat rule failed with error %v", err)
 		return err
 	}
@@ -66,11 +91,15 @@ func (client *OVSSnatClient) CreateSnatEndpoint(bridgeName string) error {
 		return err
 	}
 
-	return netlink.SetLinkMaster(client.hostSnatVethName, SnatBridgeName)
+	if client.tenantVrfID != 0 {
+		return client.attachToTenantVrf()
+	}
+
+	return netlink.SetLinkMaster(client.hostSnatVethName, client.snatBridgeName)
 }
 
 func (client *OVSSnatClient) AddPrivateIPBlockRule() error {
-	if err := epcommon.AddOrDeletePrivateIPBlockRule(SnatBridgeName, client.SkipAddressesFromBlock, "A"); err != nil {
+	if err := epcommon.AddOrDeletePrivateIPBlockRule(client.snatBridgeName, client.SkipAddressesFromBlock, "A"); err != nil {
 		log.Printf("AddPrivateIPBlockRule failed with error %v", err)
 		return err
 	}
@@ -107,22 +136,35 @@ func (client *OVSSnatClient) DeleteSnatEndpoint() error {
 		return err
 	}
 
+	if client.tenantVrfID != 0 {
+		return deleteTenantVrf(GetTenantVrf(client.tenantVrfID))
+	}
+
 	return nil
 }
 
-func CreateSnatBridge(snatBridgeIP string, mainInterface string) error {
-	_, err := net.InterfaceByName(SnatBridgeName)
+// GetSnatBridgeName returns the name of the SNAT bridge, defaulting to DefaultSnatBridgeName.
+func GetSnatBridgeName(snatBridgeName string) string {
+	if snatBridgeName == "" {
+		return DefaultSnatBridgeName
+	}
+
+	return snatBridgeName
+}
+
+func CreateSnatBridge(snatBridgeName string, snatBridgeIP string, mainInterface string) error {
+	_, err := net.InterfaceByName(snatBridgeName)
 	if err == nil {
 		log.Printf("Snat Bridge already exists")
 		return nil
 	}
 
-	log.Printf("[net] Creating Snat bridge %v.", SnatBridgeName)
+	log.Printf("[net] Creating Snat bridge %v.", snatBridgeName)
 
 	link := netlink.BridgeLink{
 		LinkInfo: netlink.LinkInfo{
 			Type: netlink.LINK_TYPE_BRIDGE,
-			Name: SnatBridgeName,
+			Name: snatBridgeName,
 		},
 	}
 
@@ -153,13 +195,13 @@ func CreateSnatBridge(snatBridgeIP string, mainInterface string) error {
 	log.Printf("Assigning %v on snat bridge", snatBridgeIP)
 
 	ip, addr, _ := net.ParseCIDR(snatBridgeIP)
-	err = netlink.AddIpAddress(SnatBridgeName, ip, addr)
+	err = netlink.AddIpAddress(snatBridgeName, ip, addr)
 	if err != nil && !strings.Contains(strings.ToLower(err.Error()), "file exists") {
 		log.Printf("[net] Failed to add IP address %v: %v.", addr, err)
 		return err
 	}
 
-	if err := netlink.SetLinkState(SnatBridgeName, true); err != nil {
+	if err := netlink.SetLinkState(snatBridgeName, true); err != nil {
 		return err
 	}
 
@@ -167,7 +209,7 @@ func CreateSnatBridge(snatBridgeIP string, mainInterface string) error {
 		return err
 	}
 
-	if err := netlink.SetLinkMaster(azureSnatVeth0, SnatBridgeName); err != nil {
+	if err := netlink.SetLinkMaster(azureSnatVeth0, snatBridgeName); err != nil {
 		return err
 	}
 
@@ -182,7 +224,7 @@ func CreateSnatBridge(snatBridgeIP string, mainInterface string) error {
 	return nil
 }
 
-func DeleteSnatBridge(bridgeName string) error {
+func DeleteSnatBridge(snatBridgeName string, bridgeName string) error {
 	cmd := "ebtables -t nat -D PREROUTING -p 802_1Q -j DROP"
 	_, err := platform.ExecuteCommand(cmd)
 	if err != nil {
@@ -198,31 +240,83 @@ func DeleteSnatBridge(bridgeName string) error {
 	}
 
 	// Delete the bridge.
-	err = netlink.DeleteLink(SnatBridgeName)
+	err = netlink.DeleteLink(snatBridgeName)
 	if err != nil {
-		log.Printf("[net] Failed to delete bridge %v, err:%v.", SnatBridgeName, err)
+		log.Printf("[net] Failed to delete bridge %v, err:%v.", snatBridgeName, err)
 	}
 
 	return err
 }
 
-func AddMasqueradeRule(snatBridgeIPWithPrefix string) error {
+// AddSnatBridgeIP assigns the SNAT gateway of a network container to the SNAT bridge if it doesn't have it yet.
+func AddSnatBridgeIP(snatBridgeName string, snatBridgeIP string) error {
+	ip, addr, err := net.ParseCIDR(snatBridgeIP)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Assigning %v on snat bridge", snatBridgeIP)
+	if err = netlink.AddIpAddress(snatBridgeName, ip, addr); err != nil && !strings.Contains(strings.ToLower(err.Error()), "file exists") {
+		log.Printf("[net] Failed to add IP address %v: %v.", addr, err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteSnatBridgeIP deletes the SNAT gateway of a network container from the SNAT bridge, along with
+// the masquerade rule of its subnet and its exclusions.
+func DeleteSnatBridgeIP(snatBridgeName string, snatBridgeIP string, exclusions []string) {
+	ip, ipNet, err := net.ParseCIDR(snatBridgeIP)
+	if err != nil {
+		log.Printf("Invalid snat bridge IP %v: %v", snatBridgeIP, err)
+		return
+	}
+
+	deleteMasqueradeRule(ipNet, exclusions)
+
+	if _, err = net.InterfaceByName(snatBridgeName); err != nil {
+		return
+	}
+
+	log.Printf("Removing %v from snat bridge", snatBridgeIP)
+	if err = netlink.DeleteIpAddress(snatBridgeName, ip, ipNet); err != nil {
+		log.Printf("[net] Failed to delete IP address %v: %v.", snatBridgeIP, err)
+	}
+}
+
+// AddMasqueradeRule masquerades the traffic of the SNAT bridge, except to the excluded destinations.
+func AddMasqueradeRule(snatBridgeIPWithPrefix string, exclusions []string) error {
 	_, ipNet, _ := net.ParseCIDR(snatBridgeIPWithPrefix)
-	cmd := fmt.Sprintf("iptables -t nat -C POSTROUTING -s %v -j MASQUERADE", ipNet.String())
-	_, err := platform.ExecuteCommand(cmd)
-	if err == nil {
+	client := iptables.GetClient()
+
+	// The exclusions are inserted ahead of the masquerade rule, and of the rules masquerading the traffic of the node.
+	for _, exclusion := range exclusions {
+		spec := []string{"-s", ipNet.String(), "-d", exclusion, "-j", "RETURN"}
+		if client.Exists(iptables.Nat, "POSTROUTING", spec...) {
+			continue
+		}
+
+		log.Printf("Adding iptable snat exclusion rule %v", spec)
+		if err := client.Insert(iptables.Nat, "POSTROUTING", 1, spec...); err != nil {
+			return err
+		}
+	}
+
+	spec := []string{"-s", ipNet.String(), "-j", "MASQUERADE"}
+
+	if client.Exists(iptables.Nat, "POSTROUTING", spec...) {
 		log.Printf("iptable snat rule already exists")
 		return nil
 	}
 
-	cmd = fmt.Sprintf("iptables -t nat -A POSTROUTING -s %v -j MASQUERADE", ipNet.String())
-	log.Printf("Adding iptable snat rule %v", cmd)
-	_, err = platform.ExecuteCommand(cmd)
-	return err
+	log.Printf("Adding iptable snat rule %v", spec)
+	return client.Append(iptables.Nat, "POSTROUTING", spec...)
 }
 
-func DeleteMasqueradeRule() error {
-	snatIf, err := net.InterfaceByName(SnatBridgeName)
+// DeleteMasqueradeRule deletes the masquerade rules of the subnets of the SNAT bridge, and their exclusions.
+func DeleteMasqueradeRule(snatBridgeName string, exclusions []string) error {
+	snatIf, err := net.InterfaceByName(snatBridgeName)
 	if err != nil {
 		return err
 	}
@@ -235,17 +329,32 @@ func DeleteMasqueradeRule() error {
 			continue
 		}
 
+		// The bridge has the gateway of the network container of each VLAN.
 		if ipAddr.To4() != nil {
-			cmd := fmt.Sprintf("iptables -t nat -D POSTROUTING -s %v -j MASQUERADE", ipNet.String())
-			log.Printf("Deleting iptable snat rule %v", cmd)
-			_, err = platform.ExecuteCommand(cmd)
-			return err
+			if err = deleteMasqueradeRule(ipNet, exclusions); err != nil {
+				log.Printf("Failed to delete iptable snat rule of %v, err:%v", ipNet, err)
+			}
 		}
 	}
 
 	return nil
 }
 
+// deleteMasqueradeRule deletes the masquerade rule of a subnet of the SNAT bridge, and its exclusions.
+func deleteMasqueradeRule(ipNet *net.IPNet, exclusions []string) error {
+	for _, exclusion := range exclusions {
+		spec := []string{"-s", ipNet.String(), "-d", exclusion, "-j", "RETURN"}
+		log.Printf("Deleting iptable snat exclusion rule %v", spec)
+		if err := iptables.GetClient().Delete(iptables.Nat, "POSTROUTING", spec...); err != nil {
+			log.Printf("Failed to delete iptable snat exclusion rule %v, err:%v", spec, err)
+		}
+	}
+
+	spec := []string{"-s", ipNet.String(), "-j", "MASQUERADE"}
+	log.Printf("Deleting iptable snat rule %v", spec)
+	return iptables.GetClient().Delete(iptables.Nat, "POSTROUTING", spec...)
+}
+
 func AddVlanDropRule() error {
 	cmd := "ebtables -t nat -L PREROUTING"
 	out, err := platform.ExecuteCommand(cmd)
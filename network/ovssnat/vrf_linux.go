@@ -0,0 +1,202 @@
+package ovssnat
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	vrfInterfacePrefix = "azvrf"
+	vrfTableBase       = 10000
+)
+
+// Private address space that isn't reachable from tenant VRFs.
+var vrfBlockedAddresses = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16"}
+
+// GetTenantVrf returns the name and route table of the VRF of a tenant.
+func GetTenantVrf(vlanID int) (string, int) {
+	return fmt.Sprintf("%s%d", vrfInterfacePrefix, vlanID), vrfTableBase + vlanID
+}
+
+// isExistsError checks if a netlink request failed because the object already exists.
+func isExistsError(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "file exists")
+}
+
+// createTenantVrf creates the VRF of a tenant and the routes of its table if it doesn't exist yet.
+// Traffic leaves the VRF through the host's default route. Private address space, including
+// the endpoints of other tenants, is unreachable except for the given addresses.
+func createTenantVrf(vrfName string, table int, allowedAddresses []string) error {
+	if _, err := net.InterfaceByName(vrfName); err == nil {
+		return nil
+	}
+
+	log.Printf("[net] Creating VRF %v with table %v.", vrfName, table)
+
+	link := netlink.VRFLink{
+		LinkInfo: netlink.LinkInfo{
+			Type: netlink.LINK_TYPE_VRF,
+			Name: vrfName,
+		},
+		Table: uint32(table),
+	}
+
+	if err := netlink.AddLink(&link); err != nil {
+		return err
+	}
+
+	if err := netlink.SetLinkState(vrfName, true); err != nil {
+		return err
+	}
+
+	hostRoutes, err := netlink.GetIpRoute(&netlink.Route{Family: unix.AF_INET})
+	if err != nil {
+		return err
+	}
+
+	var defaultRoute *netlink.Route
+	for _, route := range hostRoutes {
+		if route.Dst == nil && route.Gw != nil {
+			defaultRoute = route
+			break
+		}
+	}
+
+	if defaultRoute == nil {
+		return fmt.Errorf("Host default route not found")
+	}
+
+	// Copy the route of a destination from the main table into the VRF table.
+	addRoute := func(dst *net.IPNet, routeType int) error {
+		route := &netlink.Route{
+			Family: unix.AF_INET,
+			Dst:    dst,
+			Table:  table,
+			Type:   routeType,
+		}
+
+		if routeType == unix.RTN_UNICAST {
+			route.Gw = defaultRoute.Gw
+			route.LinkIndex = defaultRoute.LinkIndex
+		}
+
+		if err := netlink.AddIpRoute(route); err != nil && !isExistsError(err) {
+			log.Printf("[net] Failed to add route %+v to VRF %v: %v.", route, vrfName, err)
+			return err
+		}
+
+		return nil
+	}
+
+	if err = addRoute(nil, unix.RTN_UNICAST); err != nil {
+		return err
+	}
+
+	for _, address := range vrfBlockedAddresses {
+		_, dst, _ := net.ParseCIDR(address)
+		if err = addRoute(dst, unix.RTN_UNREACHABLE); err != nil {
+			return err
+		}
+	}
+
+	for _, address := range allowedAddresses {
+		if !strings.Contains(address, "/") {
+			address += "/32"
+		}
+
+		_, dst, err := net.ParseCIDR(address)
+		if err != nil {
+			log.Printf("[net] Skipping invalid address %v: %v.", address, err)
+			continue
+		}
+
+		if err = addRoute(dst, unix.RTN_UNICAST); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteTenantVrf deletes the VRF of a tenant and its routes once no interface is enslaved to it.
+func deleteTenantVrf(vrfName string, table int) error {
+	if _, err := net.InterfaceByName(vrfName); err != nil {
+		return nil
+	}
+
+	if slaves, _ := filepath.Glob(filepath.Join("/sys/class/net", vrfName, "lower_*")); len(slaves) > 0 {
+		return nil
+	}
+
+	log.Printf("[net] Deleting VRF %v.", vrfName)
+
+	routes, err := netlink.GetIpRoute(&netlink.Route{Family: unix.AF_INET, Table: table})
+	if err != nil {
+		return err
+	}
+
+	for _, route := range routes {
+		if err := netlink.DeleteIpRoute(route); err != nil {
+			log.Printf("[net] Failed to delete route %+v from VRF %v: %v.", route, vrfName, err)
+		}
+	}
+
+	return netlink.DeleteLink(vrfName)
+}
+
+// attachToTenantVrf routes the host end of the SNAT endpoint in the VRF of its tenant
+// instead of bridging it to the SNAT bridge shared by all tenants.
+func (client *OVSSnatClient) attachToTenantVrf() error {
+	vrfName, table := GetTenantVrf(client.tenantVrfID)
+
+	allowedAddresses := append([]string{ImdsIP}, client.SkipAddressesFromBlock...)
+	if err := createTenantVrf(vrfName, table, allowedAddresses); err != nil {
+		log.Printf("[net] Creating VRF %v failed with error %v", vrfName, err)
+		return err
+	}
+
+	log.Printf("[net] Setting link %v master %v.", client.hostSnatVethName, vrfName)
+	if err := netlink.SetLinkMaster(client.hostSnatVethName, vrfName); err != nil {
+		return err
+	}
+
+	// The host end is the gateway of the endpoint, as the SNAT bridge is otherwise.
+	ip, ipNet, err := net.ParseCIDR(client.snatBridgeIP)
+	if err != nil {
+		return err
+	}
+
+	if err = netlink.AddIpAddress(client.hostSnatVethName, ip, ipNet); err != nil && !isExistsError(err) {
+		return err
+	}
+
+	// Replies, once unmasqueraded by the host, are routed back into the VRF.
+	localIP, _, err := net.ParseCIDR(client.localIP)
+	if err != nil {
+		return err
+	}
+
+	hostIf, err := net.InterfaceByName(client.hostSnatVethName)
+	if err != nil {
+		return err
+	}
+
+	route := &netlink.Route{
+		Family:    unix.AF_INET,
+		Dst:       &net.IPNet{IP: localIP, Mask: net.CIDRMask(32, 32)},
+		Scope:     unix.RT_SCOPE_LINK,
+		LinkIndex: hostIf.Index,
+	}
+
+	if err = netlink.AddIpRoute(route); err != nil && !isExistsError(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/network/hcn"
+)
+
+// HNS v1 policy types whose HCN equivalent has a different name.
+var hcnPolicyTypes = map[string]hcn.PolicyType{
+	"ROUTE": hcn.SDNRoute,
+	"NAT":   hcn.PortMapping,
+}
+
+// IANA numbers of the protocols of port mappings.
+var hcnProtocols = map[string]uint32{
+	"tcp": 6,
+	"udp": 17,
+}
+
+// GetHcnNetworkPolicies converts the network policies to HCN network policies.
+func GetHcnNetworkPolicies(policies []Policy) ([]hcn.Policy, error) {
+	return getHcnPolicies(NetworkPolicy, policies, nil)
+}
+
+// GetHcnEndpointPolicies converts the endpoint policies to HCN endpoint policies.
+// The OutBoundNAT policy also excludes the CNET address space of the endpoint and the exceptions
+// in the network configuration, and SNATs to the egress address of the endpoint. Both are
+// programmed even without an OutBoundNAT policy.
+func GetHcnEndpointPolicies(policies []Policy, epInfoData map[string]interface{}) ([]hcn.Policy, error) {
+	return getHcnPolicies(EndpointPolicy, policies, epInfoData)
+}
+
+// getHcnPolicies converts the policies of a type to HCN policies.
+func getHcnPolicies(policyType CNIPolicyType, policies []Policy, epInfoData map[string]interface{}) ([]hcn.Policy, error) {
+	var hcnPolicies []hcn.Policy
+	hasOutBoundNATPolicy := false
+
+	for _, policy := range policies {
+		if policy.Type != policyType {
+			continue
+		}
+
+		hcnPolicy, err := convertToHcnPolicy(policy.Data, epInfoData)
+		if err != nil {
+			return nil, err
+		}
+
+		hasOutBoundNATPolicy = hasOutBoundNATPolicy || hcnPolicy.Type == hcn.OutBoundNAT
+		hcnPolicies = append(hcnPolicies, hcnPolicy)
+	}
+
+	if policyType == EndpointPolicy && !hasOutBoundNATPolicy &&
+		(len(getConfiguredOutBoundNatExceptions(epInfoData)) > 0 || getConfiguredOutBoundNatVIP(epInfoData) != "") {
+		hcnPolicy, err := getHcnOutBoundNATPolicy(nil, epInfoData)
+		if err != nil {
+			return nil, err
+		}
+
+		hcnPolicies = append(hcnPolicies, hcnPolicy)
+	}
+
+	return hcnPolicies, nil
+}
+
+// convertToHcnPolicy converts an HNS v1 policy to an HCN policy. Policies already in
+// the HCN format, with their settings in a Settings object, are used as is.
+func convertToHcnPolicy(data json.RawMessage, epInfoData map[string]interface{}) (hcn.Policy, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return hcn.Policy{}, fmt.Errorf("Invalid policy %s: %v", data, err)
+	}
+
+	var v1Type string
+	if err := json.Unmarshal(fields["Type"], &v1Type); err != nil || v1Type == "" {
+		return hcn.Policy{}, fmt.Errorf("Policy %s has no type", data)
+	}
+	delete(fields, "Type")
+
+	policyType := hcn.PolicyType(v1Type)
+	if t, ok := hcnPolicyTypes[v1Type]; ok {
+		policyType = t
+	}
+
+	if settings, ok := fields["Settings"]; ok && len(fields) == 1 {
+		return hcn.Policy{Type: policyType, Settings: settings}, nil
+	}
+
+	switch policyType {
+	case hcn.OutBoundNAT:
+		return getHcnOutBoundNATPolicy(fields, epInfoData)
+	case hcn.PortMapping:
+		return getHcnPortMappingPolicy(fields)
+	case hcn.ACL:
+		return getHcnACLPolicy(fields)
+	}
+
+	return hcn.NewPolicy(policyType, fields)
+}
+
+// getHcnOutBoundNATPolicy converts the fields of an OutBoundNAT policy.
+func getHcnOutBoundNATPolicy(fields map[string]json.RawMessage, epInfoData map[string]interface{}) (hcn.Policy, error) {
+	var settings struct {
+		Exceptions []string `json:",omitempty"`
+		VirtualIP  string   `json:",omitempty"`
+	}
+
+	if exceptionList, ok := fields["ExceptionList"]; ok {
+		if err := json.Unmarshal(exceptionList, &settings.Exceptions); err != nil {
+			return hcn.Policy{}, fmt.Errorf("Invalid OutBoundNAT exception list %s: %v", exceptionList, err)
+		}
+	}
+
+	if vip, ok := fields["VIP"]; ok {
+		json.Unmarshal(vip, &settings.VirtualIP)
+	}
+
+	if vip := getConfiguredOutBoundNatVIP(epInfoData); vip != "" {
+		settings.VirtualIP = vip
+	}
+
+	settings.Exceptions = append(settings.Exceptions, getConfiguredOutBoundNatExceptions(epInfoData)...)
+
+	if cnetAddressSpace, ok := epInfoData["cnetAddressSpace"].([]string); ok {
+		settings.Exceptions = append(settings.Exceptions, cnetAddressSpace...)
+	}
+
+	return hcn.NewPolicy(hcn.OutBoundNAT, &settings)
+}
+
+// getHcnPortMappingPolicy converts the fields of a NAT policy.
+func getHcnPortMappingPolicy(fields map[string]json.RawMessage) (hcn.Policy, error) {
+	var v1 struct {
+		Protocol     string
+		InternalPort uint16
+		ExternalPort uint16
+	}
+
+	buffer, _ := json.Marshal(fields)
+	if err := json.Unmarshal(buffer, &v1); err != nil {
+		return hcn.Policy{}, fmt.Errorf("Invalid NAT policy %s: %v", buffer, err)
+	}
+
+	protocol, ok := hcnProtocols[strings.ToLower(v1.Protocol)]
+	if !ok {
+		return hcn.Policy{}, fmt.Errorf("Invalid NAT policy protocol %q", v1.Protocol)
+	}
+
+	settings := struct {
+		InternalPort uint16
+		ExternalPort uint16
+		Protocol     uint32
+	}{
+		InternalPort: v1.InternalPort,
+		ExternalPort: v1.ExternalPort,
+		Protocol:     protocol,
+	}
+
+	return hcn.NewPolicy(hcn.PortMapping, &settings)
+}
+
+// getHcnACLPolicy converts the fields of an ACL policy.
+func getHcnACLPolicy(fields map[string]json.RawMessage) (hcn.Policy, error) {
+	var v1 struct {
+		Id              string
+		Protocol        uint16
+		Protocols       string
+		Action          string
+		Direction       string
+		LocalAddresses  string
+		RemoteAddresses string
+		LocalPorts      string
+		LocalPort       uint16
+		RemotePorts     string
+		RemotePort      uint16
+		RuleType        string
+		Priority        uint16
+	}
+
+	buffer, _ := json.Marshal(fields)
+	if err := json.Unmarshal(buffer, &v1); err != nil {
+		return hcn.Policy{}, fmt.Errorf("Invalid ACL policy %s: %v", buffer, err)
+	}
+
+	settings := struct {
+		Id              string `json:",omitempty"`
+		Protocols       string `json:",omitempty"`
+		Action          string
+		Direction       string
+		LocalAddresses  string `json:",omitempty"`
+		RemoteAddresses string `json:",omitempty"`
+		LocalPorts      string `json:",omitempty"`
+		RemotePorts     string `json:",omitempty"`
+		RuleType        string `json:",omitempty"`
+		Priority        uint16 `json:",omitempty"`
+	}{
+		Id:              v1.Id,
+		Protocols:       v1.Protocols,
+		Action:          v1.Action,
+		Direction:       v1.Direction,
+		LocalAddresses:  v1.LocalAddresses,
+		RemoteAddresses: v1.RemoteAddresses,
+		LocalPorts:      v1.LocalPorts,
+		RemotePorts:     v1.RemotePorts,
+		RuleType:        v1.RuleType,
+		Priority:        v1.Priority,
+	}
+
+	if settings.Protocols == "" && v1.Protocol != 0 {
+		settings.Protocols = strconv.Itoa(int(v1.Protocol))
+	}
+	if settings.LocalPorts == "" && v1.LocalPort != 0 {
+		settings.LocalPorts = strconv.Itoa(int(v1.LocalPort))
+	}
+	if settings.RemotePorts == "" && v1.RemotePort != 0 {
+		settings.RemotePorts = strconv.Itoa(int(v1.RemotePort))
+	}
+
+	return hcn.NewPolicy(hcn.ACL, &settings)
+}
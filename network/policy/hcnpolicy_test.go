@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/network/hcn"
+)
+
+func TestGetHcnEndpointPolicies(t *testing.T) {
+	policies := []Policy{
+		{Type: EndpointPolicy, Data: json.RawMessage(`{"Type":"OutBoundNAT","ExceptionList":["10.0.0.0/8"]}`)},
+		{Type: EndpointPolicy, Data: json.RawMessage(`{"Type":"ROUTE","DestinationPrefix":"10.0.0.0/8","NeedEncap":true}`)},
+		{Type: EndpointPolicy, Data: json.RawMessage(`{"Type":"NAT","Protocol":"TCP","InternalPort":80,"ExternalPort":8080}`)},
+		{Type: EndpointPolicy, Data: json.RawMessage(`{"Type":"ACL","Protocol":6,"Action":"Allow","Direction":"In","LocalPort":80}`)},
+		{Type: EndpointPolicy, Data: json.RawMessage(`{"Type":"L4Proxy","Settings":{"Port":"15001"}}`)},
+		{Type: NetworkPolicy, Data: json.RawMessage(`{"Type":"VLAN","VLAN":2}`)},
+	}
+	data := map[string]interface{}{"cnetAddressSpace": []string{"192.168.0.0/16"}}
+
+	hcnPolicies, err := GetHcnEndpointPolicies(policies, data)
+	if err != nil {
+		t.Fatalf("Failed to convert policies, err:%v", err)
+	}
+
+	expected := []hcn.Policy{
+		{Type: hcn.OutBoundNAT, Settings: json.RawMessage(`{"Exceptions":["10.0.0.0/8","192.168.0.0/16"]}`)},
+		{Type: hcn.SDNRoute, Settings: json.RawMessage(`{"DestinationPrefix":"10.0.0.0/8","NeedEncap":true}`)},
+		{Type: hcn.PortMapping, Settings: json.RawMessage(`{"InternalPort":80,"ExternalPort":8080,"Protocol":6}`)},
+		{Type: hcn.ACL, Settings: json.RawMessage(`{"Protocols":"6","Action":"Allow","Direction":"In","LocalPorts":"80"}`)},
+		{Type: "L4Proxy", Settings: json.RawMessage(`{"Port":"15001"}`)},
+	}
+
+	if len(hcnPolicies) != len(expected) {
+		t.Fatalf("Unexpected policies %+v", hcnPolicies)
+	}
+
+	for i := range expected {
+		if hcnPolicies[i].Type != expected[i].Type || string(hcnPolicies[i].Settings) != string(expected[i].Settings) {
+			t.Errorf("Unexpected policy %s %s, expected %s %s",
+				hcnPolicies[i].Type, hcnPolicies[i].Settings, expected[i].Type, expected[i].Settings)
+		}
+	}
+
+	if _, err := GetHcnEndpointPolicies([]Policy{{Type: EndpointPolicy, Data: json.RawMessage(`{"ExceptionList":[]}`)}}, nil); err == nil {
+		t.Errorf("Policy without type converted")
+	}
+}
+
+func TestGetHcnEndpointPoliciesWithConfiguredOutBoundNatExceptions(t *testing.T) {
+	data := map[string]interface{}{OutBoundNatExceptions: []string{"10.0.0.0/16"}}
+
+	policies := []Policy{
+		{Type: EndpointPolicy, Data: json.RawMessage(`{"Type":"OutBoundNAT","ExceptionList":["10.240.0.0/16"]}`)},
+	}
+	hcnPolicies, err := GetHcnEndpointPolicies(policies, data)
+	if err != nil {
+		t.Fatalf("Failed to convert policies, err:%v", err)
+	}
+	if len(hcnPolicies) != 1 || string(hcnPolicies[0].Settings) != `{"Exceptions":["10.240.0.0/16","10.0.0.0/16"]}` {
+		t.Errorf("Unexpected policies %+v", hcnPolicies)
+	}
+
+	// The OutBoundNAT policy is added when the network configuration doesn't define it.
+	policies = []Policy{
+		{Type: EndpointPolicy, Data: json.RawMessage(`{"Type":"ROUTE","DestinationPrefix":"10.0.0.0/8","NeedEncap":true}`)},
+	}
+	hcnPolicies, err = GetHcnEndpointPolicies(policies, data)
+	if err != nil {
+		t.Fatalf("Failed to convert policies, err:%v", err)
+	}
+	if len(hcnPolicies) != 2 || hcnPolicies[1].Type != hcn.OutBoundNAT ||
+		string(hcnPolicies[1].Settings) != `{"Exceptions":["10.0.0.0/16"]}` {
+		t.Errorf("Unexpected policies %+v", hcnPolicies)
+	}
+
+	if hcnPolicies, _ = GetHcnNetworkPolicies(nil); len(hcnPolicies) != 0 {
+		t.Errorf("Unexpected network policies %+v", hcnPolicies)
+	}
+}
+
+func TestGetHcnEndpointPoliciesWithEgressIP(t *testing.T) {
+	data := map[string]interface{}{OutBoundNatVIP: "10.240.0.100"}
+
+	// The egress address overrides the VIP of the OutBoundNAT policy.
+	policies := []Policy{
+		{Type: EndpointPolicy, Data: json.RawMessage(`{"Type":"OutBoundNAT","VIP":"10.240.0.4","ExceptionList":["10.240.0.0/16"]}`)},
+	}
+	hcnPolicies, err := GetHcnEndpointPolicies(policies, data)
+	if err != nil {
+		t.Fatalf("Failed to convert policies, err:%v", err)
+	}
+	if len(hcnPolicies) != 1 ||
+		string(hcnPolicies[0].Settings) != `{"Exceptions":["10.240.0.0/16"],"VirtualIP":"10.240.0.100"}` {
+		t.Errorf("Unexpected policies %+v", hcnPolicies)
+	}
+
+	// The OutBoundNAT policy is added when the network configuration doesn't define it.
+	hcnPolicies, err = GetHcnEndpointPolicies(nil, data)
+	if err != nil {
+		t.Fatalf("Failed to convert policies, err:%v", err)
+	}
+	if len(hcnPolicies) != 1 || hcnPolicies[0].Type != hcn.OutBoundNAT ||
+		string(hcnPolicies[0].Settings) != `{"VirtualIP":"10.240.0.100"}` {
+		t.Errorf("Unexpected policies %+v", hcnPolicies)
+	}
+}
@@ -16,3 +16,23 @@ type Policy struct {
 	Type CNIPolicyType
 	Data json.RawMessage
 }
+
+// Key of the endpoint data holding the CIDRs exempt from outbound NAT in the network configuration.
+const OutBoundNatExceptions = "outboundNatExceptions"
+
+// Key of the endpoint data holding the address outbound traffic is SNATed to instead of the host address.
+const OutBoundNatVIP = "outboundNatVIP"
+
+// getConfiguredOutBoundNatExceptions returns the CIDRs exempt from outbound NAT in the network
+// configuration, in addition to the ones of the OutBoundNAT policy.
+func getConfiguredOutBoundNatExceptions(epInfoData map[string]interface{}) []string {
+	exceptions, _ := epInfoData[OutBoundNatExceptions].([]string)
+	return exceptions
+}
+
+// getConfiguredOutBoundNatVIP returns the address outbound traffic of the endpoint is SNATed to,
+// overriding the one of the OutBoundNAT policy, if any.
+func getConfiguredOutBoundNatVIP(epInfoData map[string]interface{}) string {
+	vip, _ := epInfoData[OutBoundNatVIP].(string)
+	return vip
+}
@@ -11,9 +11,11 @@ import (
 // SerializePolicies serializes policies to json.
 func SerializePolicies(policyType CNIPolicyType, policies []Policy, epInfoData map[string]interface{}) []json.RawMessage {
 	var jsonPolicies []json.RawMessage
+	hasOutBoundNATPolicy := false
 	for _, policy := range policies {
 		if policy.Type == policyType {
 			if isPolicyTypeOutBoundNAT := IsPolicyTypeOutBoundNAT(policy); isPolicyTypeOutBoundNAT {
+				hasOutBoundNATPolicy = true
 				if serializedOutboundNatPolicy, err := SerializeOutBoundNATPolicy(policies, epInfoData); err != nil {
 					log.Printf("Failed to serialize OutBoundNAT policy")
 				} else {
@@ -24,6 +26,17 @@ func SerializePolicies(policyType CNIPolicyType, policies []Policy, epInfoData m
 			}
 		}
 	}
+
+	// Exceptions in the network configuration and egress addresses are programmed even without an OutBoundNAT policy.
+	if policyType == EndpointPolicy && !hasOutBoundNATPolicy &&
+		(len(getConfiguredOutBoundNatExceptions(epInfoData)) > 0 || getConfiguredOutBoundNatVIP(epInfoData) != "") {
+		if serializedOutboundNatPolicy, err := SerializeOutBoundNATPolicy(policies, epInfoData); err != nil {
+			log.Printf("Failed to serialize OutBoundNAT policy")
+		} else {
+			jsonPolicies = append(jsonPolicies, serializedOutboundNatPolicy)
+		}
+	}
+
 	return jsonPolicies
 }
 
@@ -93,6 +106,8 @@ func SerializeOutBoundNATPolicy(policies []Policy, epInfoData map[string]interfa
 		}
 	}
 
+	outBoundNatPolicy.Exceptions = append(outBoundNatPolicy.Exceptions, getConfiguredOutBoundNatExceptions(epInfoData)...)
+
 	if epInfoData["cnetAddressSpace"] != nil {
 		if cnetAddressSpace := epInfoData["cnetAddressSpace"].([]string); cnetAddressSpace != nil {
 			for _, ipAddress := range cnetAddressSpace {
@@ -101,7 +116,9 @@ func SerializeOutBoundNATPolicy(policies []Policy, epInfoData map[string]interfa
 		}
 	}
 
-	if outBoundNatPolicy.Exceptions != nil {
+	outBoundNatPolicy.VIP = getConfiguredOutBoundNatVIP(epInfoData)
+
+	if outBoundNatPolicy.Exceptions != nil || outBoundNatPolicy.VIP != "" {
 		serializedOutboundNatPolicy, _ := json.Marshal(outBoundNatPolicy)
 		return serializedOutboundNatPolicy, nil
 	}
@@ -0,0 +1,60 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/network/ovssnat"
+)
+
+const (
+	// Largest VLAN ID endpoints of network containers are tagged with.
+	maxVlanID = 4094
+)
+
+// validateEndpointVlan checks that a new endpoint can be tagged with the VLAN of its network container.
+// The endpoints of a VLAN belong to a single network container, reached through a single SNAT gateway,
+// and network containers on the SNAT bridge shared by tenants can't have the same SNAT gateway.
+func (nw *network) validateEndpointVlan(epInfo *EndpointInfo, vlanID int, snatBridgeIP string) error {
+	if vlanID < 0 || vlanID > maxVlanID {
+		return errVlanIDInvalid
+	}
+
+	if snatBridgeIP == "" {
+		return nil
+	}
+
+	for _, ep := range nw.Endpoints {
+		if ep.SnatBridgeIP == "" {
+			continue
+		}
+
+		if ep.VlanID == vlanID && ep.SnatBridgeIP != snatBridgeIP {
+			return fmt.Errorf("VLAN %d is used by the network container with SNAT gateway %s", vlanID, ep.SnatBridgeIP)
+		}
+
+		if ep.VlanID != vlanID && ep.SnatBridgeIP == snatBridgeIP && !ep.EnableVrfIsolation && !epInfo.EnableVrfIsolation {
+			return fmt.Errorf("SNAT gateway %s is used by the network container of VLAN %d", snatBridgeIP, ep.VlanID)
+		}
+	}
+
+	return nil
+}
+
+// deleteVlanResources deletes the SNAT gateway of the network container of a VLAN from the host once
+// its last endpoint is deleted. The tenant VRF of the VLAN is deleted with its last SNAT endpoint.
+func (nw *network) deleteVlanResources(ep *endpoint) {
+	if ep.VlanID == 0 || !ep.EnableSnatOnHost || ep.SnatBridgeIP == "" {
+		return
+	}
+
+	// Tenants isolated in VRFs may share the subnet, and so the masquerade rule, of their SNAT gateway.
+	for _, other := range nw.Endpoints {
+		if other.Id != ep.Id && (other.VlanID == ep.VlanID || other.SnatBridgeIP == ep.SnatBridgeIP) {
+			return
+		}
+	}
+
+	log.Printf("[net] Deleting SNAT gateway %v of VLAN %v, its last endpoint %v is deleted.", ep.SnatBridgeIP, ep.VlanID, ep.Id)
+	ovssnat.DeleteSnatBridgeIP(ovssnat.GetSnatBridgeName(nw.SnatBridgeName), ep.SnatBridgeIP, nw.MasqueradeExclusions)
+}
@@ -0,0 +1,404 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// Package nftables programs the MAC NAT rules of Linux bridge networks in a table of the nftables
+// bridge family, for hosts whose kernel or image no longer has ebtables. The rules are built by the
+// ebtables package and translated to nftables rules.
+package nftables
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/ebtables"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/platform"
+)
+
+const (
+	// Table of the bridge family holding the rules programmed by SyncRules.
+	family    = "bridge"
+	TableName = "azure"
+
+	// Chains of the table, hooked at the NAT priorities of the bridge family.
+	PreroutingChain  = "prerouting"
+	PostroutingChain = "postrouting"
+
+	// Prefix of the comments identifying the rules of the table.
+	commentPrefix = "azure:"
+)
+
+// chainHooks maps the ebtables chains to the chains of the table, with their hook and priority.
+var chainHooks = []struct {
+	ebtablesChain string
+	chain         string
+	hook          string
+	priority      int
+}{
+	{"PREROUTING", PreroutingChain, "prerouting", -300},
+	{"POSTROUTING", PostroutingChain, "postrouting", 300},
+}
+
+// addressMatches maps the ebtables destination address options to nftables payload expressions.
+var addressMatches = map[string]string{
+	"--arp-ip-dst": "arp daddr ip",
+	"--ip-dst":     "ip daddr",
+	"--ip6-dst":    "ip6 daddr",
+}
+
+// commentRegex matches the comment identifying a rule in nft list output.
+var commentRegex = regexp.MustCompile(`comment "` + commentPrefix + `([0-9a-f]+)"`)
+
+// rule is a rule of a chain of the table.
+type rule struct {
+	id   string
+	expr string
+}
+
+// newRule returns a rule identified by a hash of its chain and expression, as nft lists
+// expressions differently than they are given.
+func newRule(chain string, expr string) rule {
+	h := fnv.New64a()
+	h.Write([]byte(chain + " " + expr))
+
+	return rule{id: fmt.Sprintf("%016x", h.Sum64()), expr: expr}
+}
+
+// String returns the rule with its identifying comment.
+func (r rule) String() string {
+	return fmt.Sprintf("%s comment \"%s%s\"", r.expr, commentPrefix, r.id)
+}
+
+// translateSpec translates the spec of an ebtables rule to nftables rule expressions.
+// The ebtables snat target rewriting the sender of ARP packets takes a separate rule for ARP.
+// nftables can't reply to ARP requests like the ebtables arpreply target does, so ARP requests
+// for the address are delivered to the interface with the reply MAC address, which replies itself.
+func translateSpec(spec string) ([]string, error) {
+	var matches []string
+	var target, mac, verdict string
+	snatArp := false
+
+	fields := strings.Fields(spec)
+	for i := 0; i < len(fields); i++ {
+		option := fields[i]
+		value := ""
+		if i+1 < len(fields) && !strings.HasPrefix(fields[i+1], "-") {
+			value = fields[i+1]
+			i++
+		}
+
+		switch option {
+		case "-p":
+			switch strings.ToLower(value) {
+			case "arp":
+				matches = append(matches, "ether type arp")
+			case "ipv4":
+				matches = append(matches, "ether type ip")
+			case "ipv6":
+				matches = append(matches, "ether type ip6")
+			default:
+				return nil, fmt.Errorf("Unsupported protocol %s", value)
+			}
+		case "-i":
+			matches = append(matches, fmt.Sprintf("iifname \"%s\"", translateInterfaceName(value)))
+		case "-o":
+			matches = append(matches, fmt.Sprintf("oifname \"%s\"", translateInterfaceName(value)))
+		case "-s":
+			if !strings.EqualFold(value, "unicast") {
+				return nil, fmt.Errorf("Unsupported source %s", value)
+			}
+			matches = append(matches, "ether saddr and 01:00:00:00:00:00 == 00:00:00:00:00:00")
+		case "--arp-op":
+			switch strings.ToLower(value) {
+			case "request", "reply":
+				matches = append(matches, "arp operation "+strings.ToLower(value))
+			default:
+				return nil, fmt.Errorf("Unsupported ARP operation %s", value)
+			}
+		case "--arp-ip-dst", "--ip-dst", "--ip6-dst":
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP address %s", value)
+			}
+			matches = append(matches, addressMatches[option]+" "+ip.String())
+		case "-j":
+			target = strings.ToLower(value)
+		case "--to-dst", "--to-src", "--arpreply-mac":
+			hwAddr, err := net.ParseMAC(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid MAC address %s", value)
+			}
+			mac = hwAddr.String()
+		case "--snat-arp":
+			snatArp = true
+		case "--dnat-target", "--snat-target", "--arpreply-target":
+			verdict = strings.ToLower(value)
+		default:
+			return nil, fmt.Errorf("Unsupported option %s", option)
+		}
+	}
+
+	if mac == "" {
+		return nil, fmt.Errorf("Missing MAC address")
+	}
+
+	if verdict == "" {
+		verdict = "accept"
+	}
+
+	match := strings.Join(matches, " ")
+	join := func(parts ...string) string {
+		return strings.TrimSpace(strings.Join(parts, " "))
+	}
+
+	switch target {
+	case "dnat":
+		return []string{join(match, "ether daddr set", mac, verdict)}, nil
+	case "snat":
+		exprs := []string{join(match, "ether saddr set", mac, verdict)}
+		if snatArp {
+			arp := join(match, "ether type arp arp saddr ether set", mac, "ether saddr set", mac, verdict)
+			exprs = append([]string{arp}, exprs...)
+		}
+		return exprs, nil
+	case "arpreply":
+		return []string{join(match, "ether daddr set", mac, "accept")}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported target %s", target)
+	}
+}
+
+// translateInterfaceName translates an ebtables interface name, such as the eth+ wildcard.
+func translateInterfaceName(name string) string {
+	if strings.HasSuffix(name, "+") {
+		return strings.TrimSuffix(name, "+") + "*"
+	}
+
+	return name
+}
+
+// translateRules translates ebtables rules to the rules of each chain of the table.
+func translateRules(rules []ebtables.Rule) (map[string][]rule, error) {
+	chains := make(map[string][]rule)
+
+	for _, r := range rules {
+		chain := ""
+		for _, hook := range chainHooks {
+			if hook.ebtablesChain == r.Chain {
+				chain = hook.chain
+			}
+		}
+
+		if chain == "" {
+			log.Printf("[nftables] Skipping rule %+v of unsupported chain.", r)
+			continue
+		}
+
+		exprs, err := translateSpec(r.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to translate rule %q: %v", r.Spec, err)
+		}
+
+		for _, expr := range exprs {
+			nr := newRule(chain, expr)
+			if !containsRule(chains[chain], nr.id) {
+				chains[chain] = append(chains[chain], nr)
+			}
+		}
+	}
+
+	return chains, nil
+}
+
+// containsRule checks if a list of rules contains the rule with the given ID.
+func containsRule(rules []rule, id string) bool {
+	for _, r := range rules {
+		if r.id == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseTable parses the output of nft list table, returning the rules of each chain.
+// Rules without an identifying comment weren't programmed by SyncRules.
+func parseTable(list string) map[string][]rule {
+	chains := make(map[string][]rule)
+
+	chain := ""
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "chain "):
+			chain = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "chain "), "{"))
+		case line == "}":
+			chain = ""
+		case chain == "" || strings.HasPrefix(line, "type "):
+		default:
+			id := ""
+			if match := commentRegex.FindStringSubmatch(line); match != nil {
+				id = match[1]
+			}
+			chains[chain] = append(chains[chain], rule{id: id, expr: line})
+		}
+	}
+
+	return chains
+}
+
+// equalRules checks if two tables have the same rules in the same order.
+func equalRules(current map[string][]rule, desired map[string][]rule) bool {
+	for _, hook := range chainHooks {
+		if len(current[hook.chain]) != len(desired[hook.chain]) {
+			return false
+		}
+
+		for i := range desired[hook.chain] {
+			if current[hook.chain][i].id != desired[hook.chain][i].id {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// script returns the nft script replacing the table with one holding the given rules, in a single transaction.
+// The table is removed if there are no rules.
+func script(desired map[string][]rule) string {
+	var buf bytes.Buffer
+
+	// Declaring the table first creates it if it doesn't exist, so that it can be deleted.
+	fmt.Fprintf(&buf, "table %s %s\n", family, TableName)
+	fmt.Fprintf(&buf, "delete table %s %s\n", family, TableName)
+
+	count := 0
+	for _, hook := range chainHooks {
+		count += len(desired[hook.chain])
+	}
+
+	if count == 0 {
+		return buf.String()
+	}
+
+	fmt.Fprintf(&buf, "table %s %s {\n", family, TableName)
+	for _, hook := range chainHooks {
+		fmt.Fprintf(&buf, "\tchain %s {\n", hook.chain)
+		fmt.Fprintf(&buf, "\t\ttype filter hook %s priority %d; policy accept;\n", hook.hook, hook.priority)
+		for _, r := range desired[hook.chain] {
+			fmt.Fprintf(&buf, "\t\t%s\n", r)
+		}
+		fmt.Fprintf(&buf, "\t}\n")
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	return buf.String()
+}
+
+// listTable returns the nft list output of the table, which is empty if the table doesn't exist.
+func listTable() (string, error) {
+	tables, err := platform.ExecuteCommandContext(context.Background(), nil, "nft", "list", "tables", family)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.Contains(tables.Stdout+"\n", fmt.Sprintf("table %s %s\n", family, TableName)) {
+		return "", nil
+	}
+
+	list, err := platform.ExecuteCommandContext(context.Background(), nil, "nft", "list", "table", family, TableName)
+	if err != nil {
+		return "", err
+	}
+
+	return list.Stdout, nil
+}
+
+// SyncRules makes the given PREROUTING and POSTROUTING ebtables rules the full set of rules of the table,
+// translated to nftables rules. The table is replaced in a single nft transaction, and nothing is written
+// if it is in sync. Hosts without nft have no rules to remove.
+func SyncRules(rules []ebtables.Rule) error {
+	desired, err := translateRules(rules)
+	if err != nil {
+		log.Printf("[nftables] %v.", err)
+		return err
+	}
+
+	if _, err := exec.LookPath("nft"); err != nil && len(rules) == 0 {
+		return nil
+	}
+
+	list, err := listTable()
+	if err != nil {
+		log.Printf("[nftables] Failed to list rules: %v.", err)
+		return err
+	}
+
+	if equalRules(parseTable(list), desired) {
+		return nil
+	}
+
+	restore := script(desired)
+	log.Printf("[nftables] Syncing %s %s table.", family, TableName)
+	log.Debugf("[nftables] Restoring:\n%s", restore)
+
+	if _, err := platform.ExecuteCommandContext(context.Background(), strings.NewReader(restore), "nft", "-f", "/dev/stdin"); err != nil {
+		log.Printf("[nftables] Failed to restore rules: %v.", err)
+		return err
+	}
+
+	return nil
+}
+
+// diff returns the given rules missing from the table, and the rules of the table that aren't given.
+// Rules of the table are returned with their ebtables chain and the expression nft lists.
+func diff(current map[string][]rule, rules []ebtables.Rule) ([]ebtables.Rule, []ebtables.Rule, error) {
+	var missing, extra []ebtables.Rule
+
+	desired := make(map[string][]rule)
+	for _, r := range rules {
+		chains, err := translateRules([]ebtables.Rule{r})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for chain, chainRules := range chains {
+			desired[chain] = append(desired[chain], chainRules...)
+			for _, nr := range chainRules {
+				if !containsRule(current[chain], nr.id) {
+					missing = append(missing, r)
+					break
+				}
+			}
+		}
+	}
+
+	for _, hook := range chainHooks {
+		for _, r := range current[hook.chain] {
+			if r.id == "" || !containsRule(desired[hook.chain], r.id) {
+				extra = append(extra, ebtables.Rule{Chain: hook.ebtablesChain, Spec: r.expr})
+			}
+		}
+	}
+
+	return missing, extra, nil
+}
+
+// DiffRules compares the given PREROUTING and POSTROUTING ebtables rules with the rules of the table.
+// It returns the given rules that aren't programmed, and the rules of the table that aren't given.
+func DiffRules(rules []ebtables.Rule) ([]ebtables.Rule, []ebtables.Rule, error) {
+	list, err := listTable()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return diff(parseTable(list), rules)
+}
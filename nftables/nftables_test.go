@@ -0,0 +1,137 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package nftables
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/ebtables"
+)
+
+func TestTranslateRules(t *testing.T) {
+	mac, _ := net.ParseMAC("00:0d:3a:01:02:03")
+	upstreamMac := "12:34:56:78:9a:bc"
+
+	tests := []struct {
+		rule  ebtables.Rule
+		exprs []string
+	}{
+		{
+			ebtables.SnatForInterfaceRule("eth0", mac),
+			[]string{
+				`ether saddr and 01:00:00:00:00:00 == 00:00:00:00:00:00 oifname "eth0" ether type arp arp saddr ether set 00:0d:3a:01:02:03 ether saddr set 00:0d:3a:01:02:03 accept`,
+				`ether saddr and 01:00:00:00:00:00 == 00:00:00:00:00:00 oifname "eth0" ether saddr set 00:0d:3a:01:02:03 accept`,
+			},
+		},
+		{
+			ebtables.ArpReplyRule(net.ParseIP("10.0.0.4"), mac),
+			[]string{`ether type arp arp operation request arp daddr ip 10.0.0.4 ether daddr set 00:0d:3a:01:02:03 accept`},
+		},
+		{
+			ebtables.DnatForArpRepliesRule("eth0"),
+			[]string{`ether type arp iifname "eth0" arp operation reply ether daddr set ff:ff:ff:ff:ff:ff accept`},
+		},
+		{
+			ebtables.DnatForIPAddressRule("eth0", net.ParseIP("10.0.0.4"), mac),
+			[]string{`ether type ip iifname "eth0" ip daddr 10.0.0.4 ether daddr set 00:0d:3a:01:02:03 accept`},
+		},
+		{
+			ebtables.DnatForIPAddressRule("eth0", net.ParseIP("fd00::4"), mac),
+			[]string{`ether type ip6 iifname "eth0" ip6 daddr fd00::4 ether daddr set 00:0d:3a:01:02:03 accept`},
+		},
+		{
+			ebtables.VepaModeRules("azure0", "azv", upstreamMac)[1],
+			[]string{`iifname "azv*" ether daddr set 12:34:56:78:9a:bc accept`},
+		},
+	}
+
+	for _, test := range tests {
+		chains, err := translateRules([]ebtables.Rule{test.rule})
+		if err != nil {
+			t.Fatalf("Failed to translate rule %q: %v", test.rule.Spec, err)
+		}
+
+		chain := PreroutingChain
+		if test.rule.Chain == "POSTROUTING" {
+			chain = PostroutingChain
+		}
+
+		rules := chains[chain]
+		if len(rules) != len(test.exprs) {
+			t.Fatalf("Rule %q translated to %v", test.rule.Spec, rules)
+		}
+
+		for i, r := range rules {
+			if r.expr != test.exprs[i] {
+				t.Errorf("Rule %q translated to %q, expected %q", test.rule.Spec, r.expr, test.exprs[i])
+			}
+		}
+	}
+
+	if _, err := translateRules([]ebtables.Rule{{Chain: "PREROUTING", Spec: "-p 802_1Q -j DROP"}}); err == nil {
+		t.Errorf("Translated rule of unsupported protocol")
+	}
+}
+
+func TestSyncTable(t *testing.T) {
+	mac, _ := net.ParseMAC("00:0d:3a:01:02:03")
+	rules := []ebtables.Rule{
+		ebtables.ArpReplyRule(net.ParseIP("10.0.0.4"), mac),
+		ebtables.ArpReplyRule(net.ParseIP("10.0.0.4"), mac),
+		ebtables.SnatForInterfaceRule("eth0", mac),
+	}
+
+	desired, err := translateRules(rules)
+	if err != nil {
+		t.Fatalf("Failed to translate rules: %v", err)
+	}
+
+	if len(desired[PreroutingChain]) != 1 || len(desired[PostroutingChain]) != 2 {
+		t.Fatalf("Unexpected rules %v", desired)
+	}
+
+	// An empty table is out of sync, and is created by the script.
+	if equalRules(parseTable(""), desired) {
+		t.Errorf("Empty table is in sync")
+	}
+
+	restore := script(desired)
+	if !strings.Contains(restore, "delete table bridge azure\n") ||
+		!strings.Contains(restore, "type filter hook prerouting priority -300; policy accept;") {
+		t.Errorf("Unexpected script:\n%s", restore)
+	}
+
+	// nft lists the table with expressions in its own form, but keeps the comments.
+	list := fmt.Sprintf(`table bridge azure {
+	chain prerouting {
+		type filter hook prerouting priority dstnat; policy accept;
+		arp operation request arp daddr ip 10.0.0.4 ether daddr set 00:0d:3a:01:02:03 accept comment "azure:%s"
+	}
+
+	chain postrouting {
+		type filter hook postrouting priority srcnat; policy accept;
+		oifname "eth0" ether type arp arp saddr ether set 00:0d:3a:01:02:03 ether saddr set 00:0d:3a:01:02:03 accept comment "azure:%s"
+		oifname "eth0" ether saddr set 00:0d:3a:01:02:03 accept comment "azure:%s"
+	}
+}
+`, desired[PreroutingChain][0].id, desired[PostroutingChain][0].id, desired[PostroutingChain][1].id)
+
+	current := parseTable(list)
+	if !equalRules(current, desired) {
+		t.Errorf("Listed table isn't in sync: %v", current)
+	}
+
+	missing, extra, err := diff(current, append(rules, ebtables.DnatForArpRepliesRule("eth0")))
+	if err != nil || len(missing) != 1 || len(extra) != 0 {
+		t.Errorf("Unexpected diff, missing %v extra %v err %v", missing, extra, err)
+	}
+
+	// Removing all rules removes the table.
+	if restore := script(nil); strings.Contains(restore, "chain") {
+		t.Errorf("Unexpected script:\n%s", restore)
+	}
+}
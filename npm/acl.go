@@ -0,0 +1,390 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/util"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ACL rule actions, directions and priorities. A lower value means a higher priority.
+const (
+	aclActionAllow = "Allow"
+	aclActionBlock = "Block"
+
+	aclDirectionIn  = "In"
+	aclDirectionOut = "Out"
+
+	aclHostPriority    uint16 = 100
+	aclAllowPriority   uint16 = 1000
+	aclDefaultPriority uint16 = 65000
+)
+
+// aclProtocols maps policy protocols to IANA protocol numbers.
+var aclProtocols = map[corev1.Protocol]string{
+	corev1.ProtocolTCP: "6",
+	corev1.ProtocolUDP: "17",
+}
+
+// aclRule is an access control rule of a pod endpoint.
+type aclRule struct {
+	Action          string
+	Direction       string
+	Protocols       string
+	LocalPorts      string
+	RemotePorts     string
+	RemoteAddresses string
+	Priority        uint16
+}
+
+// policyState is a snapshot of the cluster objects that network policies are evaluated against.
+type policyState struct {
+	pods       []*corev1.Pod
+	namespaces []*corev1.Namespace
+	policies   []*networkingv1.NetworkPolicy
+
+	// Indexes of the snapshot, built on first use so large clusters aren't scanned for every peer.
+	nsLabels    map[string]map[string]string
+	podsByNs    map[string][]*corev1.Pod
+	podsByLabel map[string][]*corev1.Pod
+}
+
+// getPolicyState returns the current cluster objects from the informer caches.
+func (npMgr *NetworkPolicyManager) getPolicyState() (*policyState, error) {
+	var err error
+
+	state := &policyState{}
+	if state.pods, err = npMgr.podInformer.Lister().List(labels.Everything()); err != nil {
+		return nil, err
+	}
+
+	if state.namespaces, err = npMgr.nsInformer.Lister().List(labels.Everything()); err != nil {
+		return nil, err
+	}
+
+	if state.policies, err = npMgr.npInformer.Lister().List(labels.Everything()); err != nil {
+		return nil, err
+	}
+
+	for _, nsObj := range state.namespaces {
+		if npObj := getDefaultDenyPolicy(nsObj); npObj != nil {
+			state.policies = append(state.policies, npObj)
+		}
+	}
+
+	return state, nil
+}
+
+// selectorMatches checks if a label selector matches the given labels.
+// A nil selector matches nothing.
+func selectorMatches(selector *metav1.LabelSelector, objLabels map[string]string) bool {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		log.Printf("Invalid label selector %+v: %v", selector, err)
+		return false
+	}
+
+	return s.Matches(labels.Set(objLabels))
+}
+
+// buildIndexes indexes the namespaces by name, and the pods by namespace and label.
+func (state *policyState) buildIndexes() {
+	if state.podsByNs != nil {
+		return
+	}
+
+	state.nsLabels = make(map[string]map[string]string)
+	for _, nsObj := range state.namespaces {
+		state.nsLabels[nsObj.ObjectMeta.Name] = nsObj.ObjectMeta.Labels
+	}
+
+	state.podsByNs = make(map[string][]*corev1.Pod)
+	state.podsByLabel = make(map[string][]*corev1.Pod)
+	for _, podObj := range state.pods {
+		podNs := podObj.ObjectMeta.Namespace
+		state.podsByNs[podNs] = append(state.podsByNs[podNs], podObj)
+
+		for key, value := range podObj.ObjectMeta.Labels {
+			state.podsByLabel[key+"="+value] = append(state.podsByLabel[key+"="+value], podObj)
+		}
+	}
+}
+
+// getNamespaceLabels returns the labels of a namespace.
+func (state *policyState) getNamespaceLabels(name string) map[string]string {
+	state.buildIndexes()
+
+	return state.nsLabels[name]
+}
+
+// getCandidatePods returns the pods a network policy peer may select: the pods of the policy namespace
+// if the peer has no namespace selector, narrowed to the fewest pods having one of the labels it matches.
+func (state *policyState) getCandidatePods(policyNs string, peer networkingv1.NetworkPolicyPeer) []*corev1.Pod {
+	state.buildIndexes()
+
+	pods := state.pods
+	if peer.NamespaceSelector == nil {
+		pods = state.podsByNs[policyNs]
+	}
+
+	if peer.PodSelector != nil {
+		for key, value := range peer.PodSelector.MatchLabels {
+			if labelPods := state.podsByLabel[key+"="+value]; len(labelPods) < len(pods) {
+				pods = labelPods
+			}
+		}
+	}
+
+	return pods
+}
+
+// getPeerAddresses returns the addresses of a network policy peer.
+// ACL policies can't except ranges, so ipBlock peers excepting ranges select no address rather than
+// the whole CIDR. The policies having them are reported as unsupported.
+func (state *policyState) getPeerAddresses(policyNs string, peer networkingv1.NetworkPolicyPeer) []string {
+	var addresses []string
+
+	if peer.IPBlock != nil {
+		if len(peer.IPBlock.Except) > 0 {
+			return nil
+		}
+
+		return []string{peer.IPBlock.CIDR}
+	}
+
+	for _, podObj := range state.getCandidatePods(policyNs, peer) {
+		if !isValidPod(podObj) {
+			continue
+		}
+
+		podNs := podObj.ObjectMeta.Namespace
+		if peer.NamespaceSelector != nil {
+			if !selectorMatches(peer.NamespaceSelector, state.getNamespaceLabels(podNs)) {
+				continue
+			}
+		} else if podNs != policyNs {
+			continue
+		}
+
+		if peer.PodSelector != nil && !selectorMatches(peer.PodSelector, podObj.ObjectMeta.Labels) {
+			continue
+		}
+
+		addresses = append(addresses, podObj.Status.PodIP)
+	}
+
+	return addresses
+}
+
+// getSystemPodAddresses returns the addresses of the kube-system pods, which are always allowed.
+func (state *policyState) getSystemPodAddresses() []string {
+	var addresses []string
+
+	state.buildIndexes()
+	for _, podObj := range state.podsByNs[util.KubeSystemFlag] {
+		if isValidPod(podObj) && isSystemPod(podObj) {
+			addresses = append(addresses, podObj.Status.PodIP)
+		}
+	}
+
+	return addresses
+}
+
+// resolvePort returns the port number of a policy port on the given pod.
+// Named ports can only be resolved against the pod's own container ports.
+func resolvePort(port *intstr.IntOrString, podObj *corev1.Pod) (string, bool) {
+	if port == nil {
+		return "", true
+	}
+
+	if port.Type == intstr.Int {
+		return fmt.Sprint(port.IntVal), true
+	}
+
+	if podObj != nil {
+		for _, container := range podObj.Spec.Containers {
+			for _, containerPort := range container.Ports {
+				if containerPort.Name == port.StrVal {
+					return fmt.Sprint(containerPort.ContainerPort), true
+				}
+			}
+		}
+	}
+
+	return "", false
+}
+
+// getRuleACLs returns the allow rules of one ingress or egress rule of a policy.
+func (state *policyState) getRuleACLs(
+	podObj *corev1.Pod,
+	policyNs string,
+	direction string,
+	ports []networkingv1.NetworkPolicyPort,
+	peers []networkingv1.NetworkPolicyPeer) []*aclRule {
+
+	var (
+		rules     []*aclRule
+		addresses []string
+	)
+
+	for _, peer := range peers {
+		addresses = append(addresses, state.getPeerAddresses(policyNs, peer)...)
+	}
+
+	// Peers that don't select anything allow nothing.
+	if len(peers) > 0 && len(addresses) == 0 {
+		return nil
+	}
+
+	sort.Strings(addresses)
+	remoteAddresses := strings.Join(addresses, ",")
+
+	if len(ports) == 0 {
+		return []*aclRule{
+			{
+				Action:          aclActionAllow,
+				Direction:       direction,
+				RemoteAddresses: remoteAddresses,
+				Priority:        aclAllowPriority,
+			},
+		}
+	}
+
+	for _, port := range ports {
+		protocol := corev1.ProtocolTCP
+		if port.Protocol != nil {
+			protocol = *port.Protocol
+		}
+
+		// Named ports of remote pods can't be resolved to a single port number.
+		portPod := podObj
+		if direction == aclDirectionOut {
+			portPod = nil
+		}
+
+		portNumber, ok := resolvePort(port.Port, portPod)
+		if !ok {
+			log.Printf("Cannot resolve named port %s of pod %s/%s, ignoring it",
+				port.Port.StrVal, podObj.ObjectMeta.Namespace, podObj.ObjectMeta.Name)
+			continue
+		}
+
+		// HNS ACL policies and the eBPF dataplane match single ports, so ranges allow nothing rather than
+		// their first port. The policies having them are reported as unsupported.
+		if port.EndPort != nil {
+			continue
+		}
+
+		rule := &aclRule{
+			Action:          aclActionAllow,
+			Direction:       direction,
+			Protocols:       aclProtocols[protocol],
+			RemoteAddresses: remoteAddresses,
+			Priority:        aclAllowPriority,
+		}
+
+		if direction == aclDirectionIn {
+			rule.LocalPorts = portNumber
+		} else {
+			rule.RemotePorts = portNumber
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// getEndpointACLs returns the ACL rules enforcing the network policies that select a pod.
+func (state *policyState) getEndpointACLs(podObj *corev1.Pod) []*aclRule {
+	var (
+		rules             []*aclRule
+		isIngressIsolated = false
+		isEgressIsolated  = false
+	)
+
+	podNs := podObj.ObjectMeta.Namespace
+
+	for _, npObj := range state.policies {
+		if npObj.ObjectMeta.Namespace != podNs || !selectorMatches(&npObj.Spec.PodSelector, podObj.ObjectMeta.Labels) {
+			continue
+		}
+
+		for _, ptype := range getPolicyTypes(npObj) {
+			if ptype == networkingv1.PolicyTypeIngress {
+				isIngressIsolated = true
+				for _, rule := range npObj.Spec.Ingress {
+					rules = append(rules, state.getRuleACLs(podObj, podNs, aclDirectionIn, rule.Ports, rule.From)...)
+				}
+			}
+
+			if ptype == networkingv1.PolicyTypeEgress {
+				isEgressIsolated = true
+				for _, rule := range npObj.Spec.Egress {
+					rules = append(rules, state.getRuleACLs(podObj, podNs, aclDirectionOut, rule.Ports, rule.To)...)
+				}
+			}
+		}
+	}
+
+	systemAddresses := strings.Join(state.getSystemPodAddresses(), ",")
+
+	for _, isolation := range []struct {
+		direction  string
+		isIsolated bool
+	}{
+		{aclDirectionIn, isIngressIsolated},
+		{aclDirectionOut, isEgressIsolated},
+	} {
+		if !isolation.isIsolated {
+			rules = append(rules, &aclRule{
+				Action:    aclActionAllow,
+				Direction: isolation.direction,
+				Priority:  aclDefaultPriority,
+			})
+			continue
+		}
+
+		// Traffic from the node, e.g. kubelet probes, and to or from kube-system is always allowed.
+		if isolation.direction == aclDirectionIn && len(podObj.Status.HostIP) > 0 {
+			rules = append(rules, &aclRule{
+				Action:          aclActionAllow,
+				Direction:       aclDirectionIn,
+				RemoteAddresses: podObj.Status.HostIP,
+				Priority:        aclHostPriority,
+			})
+		}
+
+		if len(systemAddresses) > 0 {
+			rules = append(rules, &aclRule{
+				Action:          aclActionAllow,
+				Direction:       isolation.direction,
+				RemoteAddresses: systemAddresses,
+				Priority:        aclHostPriority,
+			})
+		}
+
+		rules = append(rules, &aclRule{
+			Action:    aclActionBlock,
+			Direction: isolation.direction,
+			Priority:  aclDefaultPriority,
+		})
+	}
+
+	return rules
+}
+
+// isPolicyEnforced checks if network policies are enforced on a pod.
+func isPolicyEnforced(podObj *corev1.Pod) bool {
+	return isValidPod(podObj) && !isSystemPod(podObj) && !podObj.Spec.HostNetwork
+}
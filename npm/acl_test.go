@@ -0,0 +1,198 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func newTestPod(ns string, name string, ip string, podLabels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: ns,
+			Name:      name,
+			Labels:    podLabels,
+		},
+		Status: corev1.PodStatus{
+			Phase:  "Running",
+			PodIP:  ip,
+			HostIP: "10.0.0.1",
+		},
+	}
+}
+
+func TestGetEndpointACLs(t *testing.T) {
+	tcp := corev1.ProtocolTCP
+	port := intstr.FromInt(8080)
+
+	backend := newTestPod("test", "backend", "10.240.0.4", map[string]string{"app": "backend"})
+	frontend := newTestPod("test", "frontend", "10.240.0.5", map[string]string{"app": "frontend"})
+	other := newTestPod("other", "frontend", "10.240.0.6", map[string]string{"app": "frontend"})
+
+	state := &policyState{
+		pods: []*corev1.Pod{backend, frontend, other},
+		policies: []*networkingv1.NetworkPolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "test",
+					Name:      "allow-frontend",
+				},
+				Spec: networkingv1.NetworkPolicySpec{
+					PodSelector: metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "backend"},
+					},
+					Ingress: []networkingv1.NetworkPolicyIngressRule{
+						{
+							Ports: []networkingv1.NetworkPolicyPort{
+								{Protocol: &tcp, Port: &port},
+							},
+							From: []networkingv1.NetworkPolicyPeer{
+								{
+									PodSelector: &metav1.LabelSelector{
+										MatchLabels: map[string]string{"app": "frontend"},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	rules := state.getEndpointACLs(backend)
+
+	var allow, hostAllow, block, egressAllow bool
+	for _, rule := range rules {
+		switch {
+		case rule.Direction == aclDirectionIn && rule.Action == aclActionAllow && rule.Priority == aclAllowPriority:
+			if rule.RemoteAddresses != "10.240.0.5" || rule.LocalPorts != "8080" || rule.Protocols != "6" {
+				t.Errorf("TestGetEndpointACLs failed, unexpected allow rule %+v", rule)
+			}
+			allow = true
+		case rule.Direction == aclDirectionIn && rule.Action == aclActionAllow && rule.RemoteAddresses == "10.0.0.1":
+			hostAllow = true
+		case rule.Direction == aclDirectionIn && rule.Action == aclActionBlock:
+			block = true
+		case rule.Direction == aclDirectionOut && rule.Action == aclActionAllow && rule.RemoteAddresses == "":
+			egressAllow = true
+		}
+	}
+
+	if !allow || !hostAllow || !block || !egressAllow {
+		t.Errorf("TestGetEndpointACLs failed, missing rules in %+v", rules)
+	}
+
+	// Pods not selected by any policy allow all traffic.
+	rules = state.getEndpointACLs(frontend)
+	for _, rule := range rules {
+		if rule.Action != aclActionAllow {
+			t.Errorf("TestGetEndpointACLs failed, unexpected rule %+v on unselected pod", rule)
+		}
+	}
+}
+
+func TestGetRuleACLsPortRange(t *testing.T) {
+	tcp := corev1.ProtocolTCP
+	port, rangeStart := intstr.FromInt(8080), intstr.FromInt(9000)
+	endPort := int32(9100)
+
+	backend := newTestPod("test", "backend", "10.240.0.4", map[string]string{"app": "backend"})
+	state := &policyState{pods: []*corev1.Pod{backend}}
+
+	// Ranges are dropped rather than narrowed to their first port.
+	rules := state.getRuleACLs(backend, "test", aclDirectionIn, []networkingv1.NetworkPolicyPort{
+		{Protocol: &tcp, Port: &port},
+		{Protocol: &tcp, Port: &rangeStart, EndPort: &endPort},
+	}, nil)
+
+	if len(rules) != 1 || rules[0].LocalPorts != "8080" {
+		t.Errorf("TestGetRuleACLsPortRange failed, unexpected rules %+v", rules)
+	}
+
+	// Rules with only ranges allow nothing rather than all ports.
+	rules = state.getRuleACLs(backend, "test", aclDirectionIn, []networkingv1.NetworkPolicyPort{
+		{Protocol: &tcp, Port: &rangeStart, EndPort: &endPort},
+	}, nil)
+
+	if len(rules) != 0 {
+		t.Errorf("TestGetRuleACLsPortRange failed, unexpected rules %+v of a range", rules)
+	}
+}
+
+func TestGetPeerAddresses(t *testing.T) {
+	state := &policyState{
+		pods: []*corev1.Pod{
+			newTestPod("test", "backend", "10.240.0.4", map[string]string{"app": "backend"}),
+			newTestPod("test", "frontend", "10.240.0.5", map[string]string{"app": "frontend", "tier": "web"}),
+			newTestPod("other", "frontend", "10.240.0.6", map[string]string{"app": "frontend", "tier": "web"}),
+		},
+		namespaces: []*corev1.Namespace{
+			{ObjectMeta: metav1.ObjectMeta{Name: "test", Labels: map[string]string{"team": "a"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "other", Labels: map[string]string{"team": "b"}}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		peer      networkingv1.NetworkPolicyPeer
+		addresses []string
+	}{
+		{
+			"pods of the policy namespace",
+			networkingv1.NetworkPolicyPeer{
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend", "tier": "web"}},
+			},
+			[]string{"10.240.0.5"},
+		},
+		{
+			"pods of selected namespaces",
+			networkingv1.NetworkPolicyPeer{
+				PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}},
+			},
+			[]string{"10.240.0.6"},
+		},
+		{
+			"pods of all namespaces",
+			networkingv1.NetworkPolicyPeer{
+				NamespaceSelector: &metav1.LabelSelector{},
+			},
+			[]string{"10.240.0.4", "10.240.0.5", "10.240.0.6"},
+		},
+		{
+			"label no pod has",
+			networkingv1.NetworkPolicyPeer{
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			},
+			nil,
+		},
+		{
+			"ip block",
+			networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8"},
+			},
+			[]string{"10.0.0.0/8"},
+		},
+		{
+			"ip block excepting ranges",
+			networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.240.0.0/16"}},
+			},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		addresses := state.getPeerAddresses("test", test.peer)
+		if strings.Join(addresses, ",") != strings.Join(test.addresses, ",") {
+			t.Errorf("TestGetPeerAddresses failed for %s, got %v, expected %v", test.name, addresses, test.addresses)
+		}
+	}
+}
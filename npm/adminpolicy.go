@@ -0,0 +1,485 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Paths of the cluster scoped admin network policy resources of the policy.networking.k8s.io API.
+const (
+	adminNetworkPoliciesPath         = "/apis/policy.networking.k8s.io/v1alpha1/adminnetworkpolicies"
+	baselineAdminNetworkPoliciesPath = "/apis/policy.networking.k8s.io/v1alpha1/baselineadminnetworkpolicies"
+
+	// The baseline admin network policy is a singleton with this name.
+	baselineAdminNetworkPolicyName = "default"
+)
+
+// Admin network policy rule actions.
+const (
+	AdminNetworkPolicyActionAllow = "Allow"
+	AdminNetworkPolicyActionDeny  = "Deny"
+	AdminNetworkPolicyActionPass  = "Pass"
+)
+
+// AdminNetworkPolicy is a cluster scoped policy whose rules apply before any network policy,
+// in the order of their priority. Lower priorities apply first.
+type AdminNetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              AdminNetworkPolicySpec `json:"spec"`
+}
+
+// AdminNetworkPolicySpec is the specification of an admin network policy.
+type AdminNetworkPolicySpec struct {
+	Priority int32                           `json:"priority"`
+	Subject  AdminNetworkPolicySubject       `json:"subject"`
+	Ingress  []AdminNetworkPolicyIngressRule `json:"ingress,omitempty"`
+	Egress   []AdminNetworkPolicyEgressRule  `json:"egress,omitempty"`
+}
+
+// AdminNetworkPolicyList is a list of admin network policies.
+type AdminNetworkPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AdminNetworkPolicy `json:"items"`
+}
+
+// BaselineAdminNetworkPolicy is a cluster scoped policy whose rules apply to the traffic
+// that neither admin network policies nor network policies decided on.
+type BaselineAdminNetworkPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              BaselineAdminNetworkPolicySpec `json:"spec"`
+}
+
+// BaselineAdminNetworkPolicySpec is the specification of a baseline admin network policy.
+// Its rules can't pass.
+type BaselineAdminNetworkPolicySpec struct {
+	Subject AdminNetworkPolicySubject       `json:"subject"`
+	Ingress []AdminNetworkPolicyIngressRule `json:"ingress,omitempty"`
+	Egress  []AdminNetworkPolicyEgressRule  `json:"egress,omitempty"`
+}
+
+// BaselineAdminNetworkPolicyList is a list of baseline admin network policies.
+type BaselineAdminNetworkPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BaselineAdminNetworkPolicy `json:"items"`
+}
+
+// AdminNetworkPolicySubject selects the pods a policy applies to, either by their namespace or by both
+// their namespace and their labels.
+type AdminNetworkPolicySubject struct {
+	Namespaces *metav1.LabelSelector `json:"namespaces,omitempty"`
+	Pods       *NamespacedPod        `json:"pods,omitempty"`
+}
+
+// NamespacedPod selects the pods matching a pod selector in the namespaces matching a namespace selector.
+type NamespacedPod struct {
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+	PodSelector       metav1.LabelSelector `json:"podSelector"`
+}
+
+// AdminNetworkPolicyIngressRule applies an action to the traffic from its peers to the subject.
+type AdminNetworkPolicyIngressRule struct {
+	Name   string                   `json:"name,omitempty"`
+	Action string                   `json:"action"`
+	From   []AdminNetworkPolicyPeer `json:"from"`
+	Ports  []AdminNetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// AdminNetworkPolicyEgressRule applies an action to the traffic from the subject to its peers.
+type AdminNetworkPolicyEgressRule struct {
+	Name   string                   `json:"name,omitempty"`
+	Action string                   `json:"action"`
+	To     []AdminNetworkPolicyPeer `json:"to"`
+	Ports  []AdminNetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// AdminNetworkPolicyPeer selects pods like a subject does, or CIDRs outside of the cluster.
+type AdminNetworkPolicyPeer struct {
+	Namespaces *metav1.LabelSelector `json:"namespaces,omitempty"`
+	Pods       *NamespacedPod        `json:"pods,omitempty"`
+	Networks   []string              `json:"networks,omitempty"`
+}
+
+// AdminNetworkPolicyPort selects a port, a named container port or a range of ports of the subject or its peers.
+type AdminNetworkPolicyPort struct {
+	PortNumber *AdminNetworkPolicyPortNumber `json:"portNumber,omitempty"`
+	NamedPort  *string                       `json:"namedPort,omitempty"`
+	PortRange  *AdminNetworkPolicyPortRange  `json:"portRange,omitempty"`
+}
+
+// AdminNetworkPolicyPortNumber is a port of a protocol.
+type AdminNetworkPolicyPortNumber struct {
+	Protocol string `json:"protocol"`
+	Port     int32  `json:"port"`
+}
+
+// AdminNetworkPolicyPortRange is an inclusive range of ports of a protocol.
+type AdminNetworkPolicyPortRange struct {
+	Protocol string `json:"protocol"`
+	Start    int32  `json:"start"`
+	End      int32  `json:"end"`
+}
+
+// adminDirection describes how the rules of one direction match the subject and the peers.
+type adminDirection struct {
+	subjectFlag string
+	peerFlag    string
+	mark        string
+}
+
+var (
+	adminIngress = &adminDirection{util.IptablesDstFlag, util.IptablesSrcFlag, util.IptablesAzureIngressMarkHex}
+	adminEgress  = &adminDirection{util.IptablesSrcFlag, util.IptablesDstFlag, util.IptablesAzureEgressMarkHex}
+)
+
+// adminPolicyChains are the chains the admin network policy rules are synced to, in the order they are synced.
+var adminPolicyChains = []string{
+	util.IptablesAzureAnpIngressChain,
+	util.IptablesAzureAnpEgressChain,
+	util.IptablesAzureBanpChain,
+}
+
+// adminPolicyRules are the ipsets and the iptables rules of the admin network policies, by chain.
+type adminPolicyRules struct {
+	podSets     []string
+	nsLists     []string
+	ipBlockSets map[string][]string
+	chains      map[string][]*iptm.IptEntry
+}
+
+// listAPIObjects gets a list of objects of an API that client-go has no types for.
+// Clusters without the API have no objects.
+func listAPIObjects(clientset kubernetes.Interface, path string, list interface{}) error {
+	out, err := clientset.CoreV1().RESTClient().Get().AbsPath(path).DoRaw(context.Background())
+	if errors.IsNotFound(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(out, list)
+}
+
+// listAdminPolicies lists the admin network policies and the baseline admin network policy of the cluster.
+func listAdminPolicies(clientset kubernetes.Interface) ([]AdminNetworkPolicy, *BaselineAdminNetworkPolicy, error) {
+	var anpList AdminNetworkPolicyList
+	if err := listAPIObjects(clientset, adminNetworkPoliciesPath, &anpList); err != nil {
+		return nil, nil, err
+	}
+
+	var banpList BaselineAdminNetworkPolicyList
+	if err := listAPIObjects(clientset, baselineAdminNetworkPoliciesPath, &banpList); err != nil {
+		return nil, nil, err
+	}
+
+	for i := range banpList.Items {
+		if banpList.Items[i].ObjectMeta.Name == baselineAdminNetworkPolicyName {
+			return anpList.Items, &banpList.Items[i], nil
+		}
+	}
+
+	return anpList.Items, nil, nil
+}
+
+// getSetSpecs returns the iptables specs matching all of the given ipsets.
+func getSetSpecs(sets []string, flag string) []string {
+	var specs []string
+	for _, set := range sets {
+		specs = append(specs,
+			util.IptablesMatchFlag,
+			util.IptablesSetFlag,
+			util.IptablesMatchSetFlag,
+			util.GetHashedName(set),
+			flag,
+		)
+	}
+
+	return specs
+}
+
+// hasAdminMatchExpressions checks if any selector of a subject or peer has match expressions, which aren't supported.
+// Matching only their match labels would select more pods than the selectors do.
+func hasAdminMatchExpressions(namespaces *metav1.LabelSelector, pods *NamespacedPod) bool {
+	switch {
+	case namespaces != nil:
+		return len(namespaces.MatchExpressions) > 0
+	case pods != nil:
+		return len(pods.NamespaceSelector.MatchExpressions) > 0 || len(pods.PodSelector.MatchExpressions) > 0
+	}
+
+	return false
+}
+
+// getSelectorSpecs returns the iptables specs matching the pods a subject or peer selects, or nil if it selects none.
+// Only the match labels of the selectors are matched, selectors with match expressions are skipped by the callers.
+func (r *adminPolicyRules) getSelectorSpecs(namespaces *metav1.LabelSelector, pods *NamespacedPod, flag string) []string {
+	var nsLabels, podLabels map[string]string
+
+	switch {
+	case namespaces != nil:
+		nsLabels = namespaces.MatchLabels
+	case pods != nil:
+		nsLabels, podLabels = pods.NamespaceSelector.MatchLabels, pods.PodSelector.MatchLabels
+	default:
+		return nil
+	}
+
+	// Label sets are matched in a stable order, so that the rules can be compared with the listed ones.
+	var sets []string
+	for _, key := range getSortedKeys(nsLabels) {
+		list := getNsIpsetName(key, nsLabels[key])
+		r.nsLists = append(r.nsLists, list)
+		sets = append(sets, list)
+	}
+
+	for _, key := range getSortedKeys(podLabels) {
+		set := util.KubeAllNamespacesFlag + "-" + key + ":" + podLabels[key]
+		r.podSets = append(r.podSets, set)
+		sets = append(sets, set)
+	}
+
+	// Empty selectors select the pods of all namespaces.
+	if len(sets) == 0 {
+		sets = append(sets, util.KubeAllNamespacesFlag)
+	}
+
+	return getSetSpecs(sets, flag)
+}
+
+// getPeerSpecs returns the iptables specs matching each of the pods and networks a peer selects.
+func (r *adminPolicyRules) getPeerSpecs(peer AdminNetworkPolicyPeer, flag string) [][]string {
+	var peerSpecs [][]string
+
+	if specs := r.getSelectorSpecs(peer.Namespaces, peer.Pods, flag); len(specs) > 0 {
+		peerSpecs = append(peerSpecs, specs)
+	}
+
+	for _, cidr := range peer.Networks {
+		set, members := getIPBlockIpset(&networkingv1.IPBlock{CIDR: cidr})
+		r.ipBlockSets[set] = members
+		peerSpecs = append(peerSpecs, getSetSpecs([]string{set}, flag))
+	}
+
+	return peerSpecs
+}
+
+// getPortSpecs returns the iptables specs matching a port, or nil if it sets none.
+// The protocol defaults to TCP, as for network policies.
+func (r *adminPolicyRules) getPortSpecs(port AdminNetworkPolicyPort) []string {
+	getProtocol := func(protocol string) string {
+		if len(protocol) == 0 {
+			protocol = util.KubeProtocolTCP
+		}
+		return strings.ToLower(protocol)
+	}
+
+	switch {
+	case port.PortNumber != nil:
+		return []string{
+			util.IptablesProtFlag,
+			getProtocol(port.PortNumber.Protocol),
+			util.IptablesDstPortFlag,
+			fmt.Sprint(port.PortNumber.Port),
+		}
+	case port.PortRange != nil:
+		return []string{
+			util.IptablesProtFlag,
+			getProtocol(port.PortRange.Protocol),
+			util.IptablesDstPortFlag,
+			fmt.Sprintf("%d:%d", port.PortRange.Start, port.PortRange.End),
+		}
+	case port.NamedPort != nil:
+		set := util.NamedPortIPSetPrefix + *port.NamedPort
+		r.podSets = append(r.podSets, set)
+		return getSetSpecs([]string{set}, util.IptablesDstDstFlag)
+	}
+
+	return nil
+}
+
+// getAdminActionSpecs returns the iptables target of a rule action, or nil if the action isn't supported.
+// Allowed packets are marked like the packets network policies allow, denied ones are dropped,
+// and passed ones return to the network policy rules.
+func getAdminActionSpecs(action string, mark string, isBaseline bool) []string {
+	switch {
+	case action == AdminNetworkPolicyActionAllow:
+		return []string{util.IptablesJumpFlag, util.IptablesMarkTarget, util.IptablesSetMarkFlag, mark}
+	case action == AdminNetworkPolicyActionDeny:
+		return []string{util.IptablesJumpFlag, util.IptablesDrop}
+	case action == AdminNetworkPolicyActionPass && !isBaseline:
+		return []string{util.IptablesJumpFlag, util.IptablesReturn}
+	}
+
+	return nil
+}
+
+// addRule adds the rules of one policy rule to a chain, one for each peer and port.
+// Each rule only matches packets no earlier rule allowed, so that the first matching rule decides.
+func (r *adminPolicyRules) addRule(name string, chain string, dir *adminDirection, subjectSpecs []string,
+	action string, peers []AdminNetworkPolicyPeer, ports []AdminNetworkPolicyPort, isBaseline bool) {
+	actionSpecs := getAdminActionSpecs(action, dir.mark, isBaseline)
+	if actionSpecs == nil {
+		log.Printf("Ignoring rule of admin network policy %s with unsupported action %q", name, action)
+		return
+	}
+
+	// Rules without ports match all ports.
+	portSpecs := [][]string{nil}
+	if len(ports) > 0 {
+		portSpecs = nil
+		for _, port := range ports {
+			if specs := r.getPortSpecs(port); specs != nil {
+				portSpecs = append(portSpecs, specs)
+			}
+		}
+	}
+
+	markSpecs := []string{
+		util.IptablesMatchFlag,
+		util.IptablesMark,
+		util.IptablesNotFlag,
+		util.IptablesMarkFlag,
+		dir.mark,
+	}
+
+	for _, peer := range peers {
+		if hasAdminMatchExpressions(peer.Namespaces, peer.Pods) {
+			log.Printf("Ignoring peer of admin network policy %s with unsupported match expressions", name)
+			continue
+		}
+
+		for _, peerSpecs := range r.getPeerSpecs(peer, dir.peerFlag) {
+			for _, ports := range portSpecs {
+				var specs []string
+				for _, s := range [][]string{subjectSpecs, peerSpecs, ports, markSpecs, actionSpecs} {
+					specs = append(specs, s...)
+				}
+
+				r.chains[chain] = append(r.chains[chain], &iptm.IptEntry{
+					Name:  name,
+					Chain: chain,
+					Specs: specs,
+				})
+			}
+		}
+	}
+}
+
+// addPolicy adds the ingress and egress rules of an admin network policy to their chains, in order.
+func (r *adminPolicyRules) addPolicy(name string, subject AdminNetworkPolicySubject, ingress []AdminNetworkPolicyIngressRule,
+	egress []AdminNetworkPolicyEgressRule, ingressChain string, egressChain string, isBaseline bool) {
+	if hasAdminMatchExpressions(subject.Namespaces, subject.Pods) {
+		log.Printf("Ignoring admin network policy %s with unsupported match expressions in its subject", name)
+		return
+	}
+
+	ingressSubject := r.getSelectorSpecs(subject.Namespaces, subject.Pods, adminIngress.subjectFlag)
+	egressSubject := r.getSelectorSpecs(subject.Namespaces, subject.Pods, adminEgress.subjectFlag)
+	if ingressSubject == nil {
+		log.Printf("Ignoring admin network policy %s without subject", name)
+		return
+	}
+
+	for _, rule := range ingress {
+		r.addRule(name, ingressChain, adminIngress, ingressSubject, rule.Action, rule.From, rule.Ports, isBaseline)
+	}
+
+	for _, rule := range egress {
+		r.addRule(name, egressChain, adminEgress, egressSubject, rule.Action, rule.To, rule.Ports, isBaseline)
+	}
+}
+
+// parseAdminPolicies parses the admin network policies and the baseline admin network policy, if any.
+// Admin network policies are applied in the order of their priority, and policies with the same priority
+// in the order of their names, as the API leaves it undefined.
+func parseAdminPolicies(anps []AdminNetworkPolicy, banp *BaselineAdminNetworkPolicy) *adminPolicyRules {
+	r := &adminPolicyRules{
+		ipBlockSets: make(map[string][]string),
+		chains:      make(map[string][]*iptm.IptEntry),
+	}
+
+	sort.SliceStable(anps, func(i, j int) bool {
+		if anps[i].Spec.Priority != anps[j].Spec.Priority {
+			return anps[i].Spec.Priority < anps[j].Spec.Priority
+		}
+		return anps[i].ObjectMeta.Name < anps[j].ObjectMeta.Name
+	})
+
+	for _, anp := range anps {
+		r.addPolicy(anp.ObjectMeta.Name, anp.Spec.Subject, anp.Spec.Ingress, anp.Spec.Egress,
+			util.IptablesAzureAnpIngressChain, util.IptablesAzureAnpEgressChain, false)
+	}
+
+	// Rules of both directions share the baseline chain, as none of them returns.
+	if banp != nil {
+		r.addPolicy(banp.ObjectMeta.Name, banp.Spec.Subject, banp.Spec.Ingress, banp.Spec.Egress,
+			util.IptablesAzureBanpChain, util.IptablesAzureBanpChain, true)
+	}
+
+	r.podSets = util.UniqueStrSlice(r.podSets)
+	r.nsLists = util.UniqueStrSlice(r.nsLists)
+
+	return r
+}
+
+// hasRules checks if the admin network policies produced any rule. No admin network policies are synced yet if r is nil.
+func (r *adminPolicyRules) hasRules() bool {
+	if r == nil {
+		return false
+	}
+
+	for _, entries := range r.chains {
+		if len(entries) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isChainInSync checks if a chain in the iptables-save output has exactly the given rules, in order.
+func isChainInSync(iptablesSave string, chain string, entries []*iptm.IptEntry) bool {
+	chains, _ := parseIptablesSave(iptablesSave)
+
+	rules, exists := chains[chain]
+	if !exists || len(rules) != len(entries) {
+		return false
+	}
+
+	for i, entry := range entries {
+		if rules[i].key() != parseDebugRule(chain, entry.Specs).key() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getSortedKeys returns the keys of a label map in order.
+func getSortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
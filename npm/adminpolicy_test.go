@@ -0,0 +1,218 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+const testAdminPolicies = `{
+	"items": [
+		{
+			"metadata": {"name": "allow-monitoring"},
+			"spec": {
+				"priority": 20,
+				"subject": {"namespaces": {}},
+				"ingress": [
+					{"action": "Allow", "from": [{"namespaces": {"matchLabels": {"kubernetes.io/metadata.name": "monitoring"}}}]}
+				]
+			}
+		},
+		{
+			"metadata": {"name": "deny-sensitive"},
+			"spec": {
+				"priority": 10,
+				"subject": {"pods": {"namespaceSelector": {"matchLabels": {"tier": "sensitive"}}, "podSelector": {"matchLabels": {"app": "db"}}}},
+				"ingress": [
+					{"action": "Pass", "from": [{"pods": {"namespaceSelector": {}, "podSelector": {"matchLabels": {"app": "web"}}}}], "ports": [{"portNumber": {"protocol": "TCP", "port": 5432}}]},
+					{"action": "Deny", "from": [{"namespaces": {}}]}
+				],
+				"egress": [
+					{"action": "Deny", "to": [{"networks": ["10.0.0.0/8"]}], "ports": [{"portRange": {"protocol": "UDP", "start": 53, "end": 54}}, {"namedPort": "dns"}]}
+				]
+			}
+		}
+	]
+}`
+
+const testBaselineAdminPolicy = `{
+	"metadata": {"name": "default"},
+	"spec": {
+		"subject": {"namespaces": {}},
+		"ingress": [
+			{"action": "Pass", "from": [{"namespaces": {}}]},
+			{"action": "Deny", "from": [{"namespaces": {}}]}
+		]
+	}
+}`
+
+func TestParseAdminPolicies(t *testing.T) {
+	var anpList AdminNetworkPolicyList
+	if err := json.Unmarshal([]byte(testAdminPolicies), &anpList); err != nil {
+		t.Fatalf("Failed to decode admin network policies: %v", err)
+	}
+
+	var banp BaselineAdminNetworkPolicy
+	if err := json.Unmarshal([]byte(testBaselineAdminPolicy), &banp); err != nil {
+		t.Fatalf("Failed to decode baseline admin network policy: %v", err)
+	}
+
+	rules := parseAdminPolicies(anpList.Items, &banp)
+
+	// Rules are ordered by policy priority, then by rule.
+	ingress := rules.chains[util.IptablesAzureAnpIngressChain]
+	if len(ingress) != 3 {
+		t.Fatalf("Unexpected ingress rules %+v", ingress)
+	}
+
+	for i, expected := range []struct {
+		name   string
+		target string
+	}{
+		{"deny-sensitive", util.IptablesReturn},
+		{"deny-sensitive", util.IptablesDrop},
+		{"allow-monitoring", util.IptablesMarkTarget},
+	} {
+		rule := parseDebugRule(ingress[i].Chain, ingress[i].Specs)
+		if ingress[i].Name != expected.name || rule.target != expected.target {
+			t.Errorf("Ingress rule %d is %+v, expected %s of %s", i, ingress[i], expected.target, expected.name)
+		}
+
+		// Packets an earlier rule allowed skip the rule.
+		if rule.mark != util.IptablesAzureIngressMarkHex || !rule.negateMark {
+			t.Errorf("Ingress rule %d doesn't skip allowed packets: %+v", i, ingress[i])
+		}
+	}
+
+	// The subject matches the namespaces and the labels of the pods it selects.
+	pass := parseDebugRule(ingress[0].Chain, ingress[0].Specs)
+	if len(pass.sets) != 3 || pass.protocol != "tcp" || pass.dport != "5432" {
+		t.Errorf("Unexpected pass rule %+v", ingress[0])
+	}
+
+	expectedSets := []string{
+		util.GetHashedName("ns-tier:sensitive") + ":" + util.IptablesDstFlag,
+		util.GetHashedName(util.KubeAllNamespacesFlag+"-app:db") + ":" + util.IptablesDstFlag,
+		util.GetHashedName(util.KubeAllNamespacesFlag+"-app:web") + ":" + util.IptablesSrcFlag,
+	}
+	for i, expected := range expectedSets {
+		if set := pass.sets[i].name + ":" + pass.sets[i].flags; set != expected {
+			t.Errorf("Pass rule matches set %s, expected %s", set, expected)
+		}
+	}
+
+	// Each port of an egress rule gets its own rule.
+	egress := rules.chains[util.IptablesAzureAnpEgressChain]
+	if len(egress) != 2 {
+		t.Fatalf("Unexpected egress rules %+v", egress)
+	}
+
+	if rule := parseDebugRule(egress[0].Chain, egress[0].Specs); rule.protocol != "udp" || rule.dport != "53:54" {
+		t.Errorf("Unexpected port range rule %+v", egress[0])
+	}
+
+	if !strings.Contains(strings.Join(egress[1].Specs, " "), util.GetHashedName(util.NamedPortIPSetPrefix+"dns")+" "+util.IptablesDstDstFlag) {
+		t.Errorf("Unexpected named port rule %+v", egress[1])
+	}
+
+	if _, ok := rules.ipBlockSets[util.IPBlockIPSetPrefix+"10.0.0.0/8"]; !ok {
+		t.Errorf("Network ipset missing from %v", rules.ipBlockSets)
+	}
+
+	// The baseline admin network policy can't pass.
+	baseline := rules.chains[util.IptablesAzureBanpChain]
+	if len(baseline) != 1 || parseDebugRule(baseline[0].Chain, baseline[0].Specs).target != util.IptablesDrop {
+		t.Errorf("Unexpected baseline rules %+v", baseline)
+	}
+
+	if !rules.hasRules() || parseAdminPolicies(nil, nil).hasRules() {
+		t.Errorf("Unexpected admin network policy rules")
+	}
+}
+
+func TestParseAdminPoliciesWithMatchExpressions(t *testing.T) {
+	const policies = `{
+		"items": [
+			{
+				"metadata": {"name": "deny-all-but-system"},
+				"spec": {
+					"priority": 10,
+					"subject": {"namespaces": {"matchExpressions": [{"key": "kubernetes.io/metadata.name", "operator": "NotIn", "values": ["kube-system"]}]}},
+					"ingress": [{"action": "Deny", "from": [{"namespaces": {}}]}]
+				}
+			},
+			{
+				"metadata": {"name": "allow-web"},
+				"spec": {
+					"priority": 20,
+					"subject": {"pods": {"namespaceSelector": {}, "podSelector": {"matchLabels": {"app": "db"}}}},
+					"ingress": [
+						{"action": "Allow", "from": [
+							{"pods": {"namespaceSelector": {}, "podSelector": {"matchLabels": {"app": "web"}, "matchExpressions": [{"key": "tier", "operator": "In", "values": ["frontend"]}]}}},
+							{"namespaces": {"matchLabels": {"team": "web"}}}
+						]}
+					]
+				}
+			}
+		]
+	}`
+
+	var anpList AdminNetworkPolicyList
+	if err := json.Unmarshal([]byte(policies), &anpList); err != nil {
+		t.Fatalf("Failed to decode admin network policies: %v", err)
+	}
+
+	rules := parseAdminPolicies(anpList.Items, nil)
+
+	// Subjects and peers with match expressions are skipped rather than matched by their labels only.
+	ingress := rules.chains[util.IptablesAzureAnpIngressChain]
+	if len(ingress) != 1 || ingress[0].Name != "allow-web" {
+		t.Fatalf("Unexpected ingress rules %+v", ingress)
+	}
+
+	rule := parseDebugRule(ingress[0].Chain, ingress[0].Specs)
+	if len(rule.sets) != 2 || rule.sets[1].name != util.GetHashedName("ns-team:web") {
+		t.Errorf("Allow rule %+v doesn't only match the peer without match expressions", ingress[0])
+	}
+
+	for _, set := range rules.podSets {
+		if strings.Contains(set, "app:web") {
+			t.Errorf("Pod set %s of a skipped peer is created", set)
+		}
+	}
+}
+
+func TestIsChainInSync(t *testing.T) {
+	var banp BaselineAdminNetworkPolicy
+	if err := json.Unmarshal([]byte(testBaselineAdminPolicy), &banp); err != nil {
+		t.Fatalf("Failed to decode baseline admin network policy: %v", err)
+	}
+
+	entries := parseAdminPolicies(nil, &banp).chains[util.IptablesAzureBanpChain]
+	allNs := util.GetHashedName(util.KubeAllNamespacesFlag)
+
+	// iptables-save may list the matches in another order.
+	iptablesSave := `*filter
+:AZURE-NPM-BANP - [0:0]
+:AZURE-NPM-ANP-INGRESS - [0:0]
+-A AZURE-NPM-BANP -m mark ! --mark 0x2000/0x2000 -m set --match-set ` + allNs + ` dst -m set --match-set ` + allNs + ` src -j DROP
+COMMIT
+`
+
+	if !isChainInSync(iptablesSave, util.IptablesAzureBanpChain, entries) {
+		t.Errorf("Baseline chain isn't in sync")
+	}
+
+	if !isChainInSync(iptablesSave, util.IptablesAzureAnpIngressChain, nil) {
+		t.Errorf("Empty chain isn't in sync")
+	}
+
+	if isChainInSync(iptablesSave, util.IptablesAzureAnpIngressChain, entries) ||
+		isChainInSync(iptablesSave, util.IptablesAzureAnpEgressChain, nil) {
+		t.Errorf("Missing rules or chains are in sync")
+	}
+}
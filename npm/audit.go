@@ -0,0 +1,193 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+const (
+	// PolicyModeAnnotation overrides the policy mode NPM runs with for a network policy.
+	PolicyModeAnnotation = "netpol.azure.com/policy-mode"
+
+	// PolicyModeEnforce drops the packets a network policy denies.
+	PolicyModeEnforce = "enforce"
+
+	// PolicyModeAudit logs the packets a network policy would deny instead, and leaves them to the other rules.
+	PolicyModeAudit = "audit"
+
+	// NFLOG group the packets of network policies in audit mode are logged to.
+	auditNflogGroup = 100
+
+	// Number of audited packets waiting to be logged before packets are lost.
+	auditLogBufferSize = 256
+
+	auditPrefix           = "azure-npm-audit"
+	auditDirectionIngress = "ingress"
+	auditDirectionEgress  = "egress"
+)
+
+// Whether network policies without a policy mode annotation are in audit mode.
+var auditByDefault = false
+
+// SetPolicyMode sets the policy mode of the network policies without a policy mode annotation.
+func SetPolicyMode(mode string) {
+	auditByDefault = mode == PolicyModeAudit
+}
+
+// auditRecord is a structured record of a packet a network policy in audit mode would deny.
+type auditRecord struct {
+	Policy     string `json:"policy"`
+	Direction  string `json:"direction"`
+	Protocol   string `json:"protocol"`
+	SrcIP      string `json:"srcIP"`
+	DstIP      string `json:"dstIP"`
+	SrcPort    int    `json:"srcPort,omitempty"`
+	DstPort    int    `json:"dstPort,omitempty"`
+	policyHash string
+}
+
+// isAuditPolicy checks if a network policy is in audit mode.
+func isAuditPolicy(npObj *networkingv1.NetworkPolicy) bool {
+	switch npObj.ObjectMeta.Annotations[PolicyModeAnnotation] {
+	case PolicyModeAudit:
+		return true
+	case PolicyModeEnforce:
+		return false
+	}
+
+	return auditByDefault
+}
+
+// getAuditPrefix returns the NFLOG prefix of the packets a network policy would deny in a direction.
+// Prefixes are limited to 64 characters, so the policy is identified by the hash of its key.
+func getAuditPrefix(npObj *networkingv1.NetworkPolicy, direction string) string {
+	return strings.Join([]string{auditPrefix, direction, util.Hash(getObjectKey(npObj.ObjectMeta))}, ":")
+}
+
+// getAuditEntries rewrites the entries of a network policy in audit mode. The packets the policy allows are marked
+// with the audit marks instead of the marks accepting packets, so the policy doesn't allow packets other policies
+// deny. The default drop entries log the packets no enforced or audited policy allows with an NFLOG target instead.
+// Logged packets continue through the chains as if the policy didn't exist.
+func getAuditEntries(npObj *networkingv1.NetworkPolicy, entries []*iptm.IptEntry) []*iptm.IptEntry {
+	var audited []*iptm.IptEntry
+
+	for _, entry := range entries {
+		n := len(entry.Specs)
+		if n >= 2 && entry.Specs[n-2] == util.IptablesSetMarkFlag {
+			auditEntry := *entry
+			auditEntry.Specs = append([]string{}, entry.Specs...)
+			switch entry.Specs[n-1] {
+			case util.IptablesAzureIngressMarkHex:
+				auditEntry.Specs[n-1] = util.IptablesAzureAuditIngressMarkHex
+			case util.IptablesAzureEgressMarkHex:
+				auditEntry.Specs[n-1] = util.IptablesAzureAuditEgressMarkHex
+			}
+			audited = append(audited, &auditEntry)
+			continue
+		}
+
+		if entry.Chain != util.IptablesAzureTargetSetsChain || n < 7 || entry.Specs[n-1] != util.IptablesDrop {
+			audited = append(audited, entry)
+			continue
+		}
+
+		// Default drop entries match the packets without the mark of their direction.
+		direction, unmarked := auditDirectionIngress, util.IptablesAzureUnmarkedIngressHex
+		if entry.Specs[n-3] == util.IptablesAzureEgressMarkHex {
+			direction, unmarked = auditDirectionEgress, util.IptablesAzureUnmarkedEgressHex
+		}
+
+		auditEntry := *entry
+		auditEntry.Specs = append(append([]string{}, entry.Specs[:n-7]...),
+			util.IptablesMatchFlag,
+			util.IptablesMark,
+			util.IptablesMarkFlag,
+			unmarked,
+			util.IptablesJumpFlag,
+			util.IptablesNflogTarget,
+			util.IptablesNflogGroupFlag,
+			fmt.Sprint(auditNflogGroup),
+			util.IptablesNflogPrefixFlag,
+			getAuditPrefix(npObj, direction),
+		)
+		audited = append(audited, &auditEntry)
+	}
+
+	return audited
+}
+
+// parseAuditPacket parses the NFLOG prefix and the IP and transport headers of a logged packet.
+// IPv6 extension headers aren't parsed, so the ports of packets with extension headers aren't reported.
+func parseAuditPacket(prefix string, payload []byte) (*auditRecord, error) {
+	fields := strings.Split(prefix, ":")
+	if len(fields) != 3 || fields[0] != auditPrefix {
+		return nil, fmt.Errorf("Packet wasn't logged by an audit rule: %q", prefix)
+	}
+
+	record := &auditRecord{
+		Direction:  fields[1],
+		policyHash: fields[2],
+	}
+
+	var (
+		headerLen int
+		protocol  byte
+	)
+
+	switch {
+	case len(payload) >= 20 && payload[0]>>4 == 4:
+		record.SrcIP = net.IP(payload[12:16]).String()
+		record.DstIP = net.IP(payload[16:20]).String()
+		headerLen = int(payload[0]&0x0f) * 4
+		protocol = payload[9]
+	case len(payload) >= 40 && payload[0]>>4 == 6:
+		record.SrcIP = net.IP(payload[8:24]).String()
+		record.DstIP = net.IP(payload[24:40]).String()
+		headerLen = 40
+		protocol = payload[6]
+	default:
+		return nil, fmt.Errorf("Packet isn't an IP packet")
+	}
+
+	switch protocol {
+	case 1:
+		record.Protocol = "icmp"
+	case 58:
+		record.Protocol = "icmpv6"
+	case 6:
+		record.Protocol = "tcp"
+	case 17:
+		record.Protocol = "udp"
+	case 132:
+		record.Protocol = "sctp"
+	default:
+		record.Protocol = fmt.Sprint(protocol)
+	}
+
+	// TCP, UDP and SCTP headers all start with the source and destination ports.
+	if protocol != 1 && protocol != 58 && len(payload) >= headerLen+4 {
+		record.SrcPort = int(payload[headerLen])<<8 | int(payload[headerLen+1])
+		record.DstPort = int(payload[headerLen+2])<<8 | int(payload[headerLen+3])
+	}
+
+	return record, nil
+}
+
+// getAuditedPolicy returns the key of the applied network policy with the hash in the NFLOG prefixes of its packets.
+// This function should only be called when npMgr is locked.
+func (npMgr *NetworkPolicyManager) getAuditedPolicy(policyHash string) string {
+	for key := range npMgr.nsMap[util.KubeAllNamespacesFlag].npMap {
+		if util.Hash(key) == policyHash {
+			return key
+		}
+	}
+
+	return policyHash
+}
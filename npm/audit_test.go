@@ -0,0 +1,233 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestGetAuditEntries(t *testing.T) {
+	npObj := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "test",
+			Name:        "deny-all",
+			Annotations: map[string]string{PolicyModeAnnotation: PolicyModeAudit},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "db"},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+
+	_, _, _, entries := parsePolicy(npObj)
+
+	var audited []string
+	for _, entry := range entries {
+		rule := parseDebugRule(entry.Chain, entry.Specs)
+		if rule.target == util.IptablesDrop {
+			t.Errorf("Policy in audit mode drops packets: %+v", entry)
+		}
+
+		if rule.target == util.IptablesNflogTarget {
+			specs := strings.Join(entry.Specs, " ")
+			audited = append(audited, specs[strings.LastIndex(specs, " ")+1:])
+		}
+	}
+
+	hash := util.Hash("test/deny-all")
+	if len(audited) != 2 || audited[0] != "azure-npm-audit:ingress:"+hash || audited[1] != "azure-npm-audit:egress:"+hash {
+		t.Errorf("Unexpected audit prefixes %v", audited)
+	}
+
+	// The annotation overrides the policy mode of NPM.
+	SetPolicyMode(PolicyModeAudit)
+	defer SetPolicyMode(PolicyModeEnforce)
+
+	npObj.ObjectMeta.Annotations[PolicyModeAnnotation] = PolicyModeEnforce
+	if isAuditPolicy(npObj) {
+		t.Errorf("Policy in enforce mode is audited")
+	}
+
+	delete(npObj.ObjectMeta.Annotations, PolicyModeAnnotation)
+	if !isAuditPolicy(npObj) {
+		t.Errorf("Policy isn't audited in audit mode")
+	}
+}
+
+func TestGetAuditEntriesWithEnforcedPolicy(t *testing.T) {
+	tcp := corev1.ProtocolTCP
+	port := intstr.FromInt(8080)
+	newPolicy := func(name string, app string) *networkingv1.NetworkPolicy {
+		return &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "test",
+				Name:      name,
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "backend"},
+				},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{
+						Ports: []networkingv1.NetworkPolicyPort{
+							{Protocol: &tcp, Port: &port},
+						},
+						From: []networkingv1.NetworkPolicyPeer{
+							{
+								PodSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{"app": app},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	enforced := newPolicy("allow-frontend", "frontend")
+	audited := newPolicy("allow-monitoring", "monitoring")
+	audited.ObjectMeta.Annotations = map[string]string{PolicyModeAnnotation: PolicyModeAudit}
+
+	_, _, _, enforcedEntries := parsePolicy(enforced)
+	_, _, _, auditedEntries := parsePolicy(audited)
+
+	for _, entry := range enforcedEntries {
+		if rule := parseDebugRule(entry.Chain, entry.Specs); len(rule.setMark) > 0 && rule.setMark != util.IptablesAzureIngressMarkHex {
+			t.Errorf("Enforced policy sets mark %s: %+v", rule.setMark, entry)
+		}
+	}
+
+	var logged int
+	for _, entry := range auditedEntries {
+		rule := parseDebugRule(entry.Chain, entry.Specs)
+		if len(rule.setMark) > 0 && rule.setMark != util.IptablesAzureAuditIngressMarkHex {
+			t.Errorf("Audited policy sets mark %s: %+v", rule.setMark, entry)
+		}
+
+		if rule.target == util.IptablesNflogTarget {
+			logged++
+			if rule.mark != util.IptablesAzureUnmarkedIngressHex || rule.negateMark {
+				t.Errorf("Audited policy logs packets matching mark %s: %+v", rule.mark, entry)
+			}
+		}
+	}
+
+	if logged != 1 {
+		t.Errorf("Expected 1 NFLOG entry of the audited policy, got %d", logged)
+	}
+
+	backendSet := util.GetHashedName("all-namespace-app:backend")
+	frontendSet := util.GetHashedName("all-namespace-app:frontend")
+	monitoringSet := util.GetHashedName("all-namespace-app:monitoring")
+
+	ipsetSave := strings.Join([]string{
+		"create " + backendSet + " hash:net family inet hashsize 1024 maxelem 65536",
+		"add " + backendSet + " 10.240.0.4",
+		"create " + frontendSet + " hash:net family inet hashsize 1024 maxelem 65536",
+		"add " + frontendSet + " 10.240.0.5",
+		"create " + monitoringSet + " hash:net family inet hashsize 1024 maxelem 65536",
+		"add " + monitoringSet + " 10.240.0.6",
+	}, "\n")
+
+	rules := []string{
+		"*filter",
+		":AZURE-NPM - [0:0]",
+		":AZURE-NPM-INGRESS-PORT - [0:0]",
+		":AZURE-NPM-INGRESS-FROM - [0:0]",
+		":AZURE-NPM-TARGET-SETS - [0:0]",
+		"-A AZURE-NPM -j AZURE-NPM-INGRESS-PORT",
+		"-A AZURE-NPM -j AZURE-NPM-TARGET-SETS",
+		"-A AZURE-NPM -m mark --mark 0x2000/0x2000 -j ACCEPT",
+	}
+	for _, entry := range append(enforcedEntries, auditedEntries...) {
+		rules = append(rules, strings.Join(append([]string{"-A", entry.Chain}, entry.Specs...), " "))
+	}
+	rules = append(rules, "COMMIT")
+
+	backend := newTestPod("test", "backend", "10.240.0.4", map[string]string{"app": "backend"})
+	frontend := newTestPod("test", "frontend", "10.240.0.5", map[string]string{"app": "frontend"})
+	monitoring := newTestPod("test", "monitoring", "10.240.0.6", map[string]string{"app": "monitoring"})
+	d := NewDebugger(
+		[]*corev1.Pod{backend, frontend, monitoring},
+		nil,
+		[]*networkingv1.NetworkPolicy{enforced, audited},
+		ipsetSave,
+		strings.Join(rules, "\n"))
+
+	verdict, err := d.Simulate(frontend, backend, "tcp", 8080)
+	if err != nil {
+		t.Fatalf("Simulate failed: %v", err)
+	}
+
+	if !verdict.Allowed {
+		t.Errorf("Expected the enforced policy to allow frontend, trace: %v", verdict.Trace)
+	}
+
+	// The audited policy doesn't allow packets the enforced policy denies.
+	verdict, err = d.Simulate(monitoring, backend, "tcp", 8080)
+	if err != nil {
+		t.Fatalf("Simulate failed: %v", err)
+	}
+
+	if verdict.Allowed {
+		t.Errorf("Expected the enforced policy to drop monitoring, trace: %v", verdict.Trace)
+	}
+}
+
+func TestParseAuditPacket(t *testing.T) {
+	// IPv4 header of a TCP packet from 10.0.0.1:40000 to 10.0.0.2:5432, followed by its ports.
+	payload := []byte{
+		0x45, 0, 0, 40, 0, 0, 0, 0, 64, 6, 0, 0,
+		10, 0, 0, 1,
+		10, 0, 0, 2,
+		0x9c, 0x40, 0x15, 0x38,
+	}
+
+	record, err := parseAuditPacket("azure-npm-audit:ingress:1234", payload)
+	if err != nil {
+		t.Fatalf("parseAuditPacket failed: %v", err)
+	}
+
+	expected := auditRecord{
+		Direction:  "ingress",
+		Protocol:   "tcp",
+		SrcIP:      "10.0.0.1",
+		DstIP:      "10.0.0.2",
+		SrcPort:    40000,
+		DstPort:    5432,
+		policyHash: "1234",
+	}
+	if *record != expected {
+		t.Errorf("Unexpected audit record %+v", record)
+	}
+
+	if _, err := parseAuditPacket("other", payload); err == nil {
+		t.Errorf("parseAuditPacket succeeded for a packet of another rule")
+	}
+
+	// IPv6 header of a UDP packet from fd00::1:40000 to fd00::2:53, followed by its ports.
+	payload = []byte{0x60, 0, 0, 0, 0, 8, 17, 64}
+	payload = append(payload, net.ParseIP("fd00::1")...)
+	payload = append(payload, net.ParseIP("fd00::2")...)
+	payload = append(payload, 0x9c, 0x40, 0, 53)
+
+	record, err = parseAuditPacket("azure-npm-audit:egress:1234", payload)
+	if err != nil {
+		t.Fatalf("parseAuditPacket failed: %v", err)
+	}
+
+	if record.Protocol != "udp" || record.SrcIP != "fd00::1" || record.DstIP != "fd00::2" || record.DstPort != 53 {
+		t.Errorf("Unexpected IPv6 audit record %+v", record)
+	}
+}
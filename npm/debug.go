@@ -0,0 +1,648 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+const (
+	// Maximum depth of chain jumps and nested ipset lists followed when simulating a packet.
+	maxDebugChainDepth = 16
+
+	// Type of ipset lists in the output of ipset save.
+	ipsetSaveListType = "list:set"
+)
+
+// debugSet is an ipset parsed from the output of ipset save.
+type debugSet struct {
+	name    string
+	kind    string
+	members []string
+}
+
+// debugSetMatch is an ipset match of an iptables rule.
+type debugSetMatch struct {
+	name   string
+	flags  string
+	negate bool
+}
+
+// debugRule is an iptables rule parsed from the output of iptables-save.
+type debugRule struct {
+	chain       string
+	text        string
+	sets        []*debugSetMatch
+	protocol    string
+	dport       string
+	mark        string
+	negateMark  bool
+	src         string
+	dst         string
+	matchesConn bool
+	unknown     []string
+	target      string
+	setMark     string
+	packets     uint64
+	bytes       uint64
+}
+
+// debugPacket is a packet simulated through the iptables rules.
+type debugPacket struct {
+	srcIP    net.IP
+	dstIP    net.IP
+	protocol string
+	port     string
+	mark     uint32
+}
+
+// DebugVerdict is the result of simulating a packet through the NPM dataplane.
+type DebugVerdict struct {
+	Allowed bool
+	// Trace lists the matched rules, annotated with the network policies that produced them.
+	Trace []string
+}
+
+// Debugger maps the NPM dataplane back to the cluster objects that produced it.
+type Debugger struct {
+	setNames      map[string]string   // hashed set name -> set name.
+	setPolicies   map[string][]string // hashed set name -> policies referring to the set.
+	rulePolicies  map[string][]string // rule key -> policies producing the rule.
+	sets          map[string]*debugSet
+	chains        map[string][]*debugRule
+	chainNames    []string
+	podNamesByIP  map[string]string
+	defaultChains map[string]bool
+}
+
+// NewDebugger creates a debugger for the given cluster objects and the output of ipset save and iptables-save.
+func NewDebugger(
+	pods []*corev1.Pod,
+	namespaces []*corev1.Namespace,
+	policies []*networkingv1.NetworkPolicy,
+	ipsetSave string,
+	iptablesSave string) *Debugger {
+
+	d := &Debugger{
+		setNames:     make(map[string]string),
+		setPolicies:  make(map[string][]string),
+		rulePolicies: make(map[string][]string),
+		podNamesByIP: make(map[string]string),
+		defaultChains: map[string]bool{
+			util.IptablesAzureChain: true,
+		},
+	}
+
+	addSetName := func(name string) {
+		d.setNames[util.GetHashedName(name)] = name
+	}
+
+	addSetName(util.KubeAllNamespacesFlag)
+	addSetName(util.KubeSystemFlag)
+
+	for _, nsObj := range namespaces {
+		addSetName(nsObj.ObjectMeta.Name)
+		for k, v := range nsObj.ObjectMeta.Labels {
+			addSetName(getNsIpsetName(k, v))
+		}
+	}
+
+	for _, podObj := range pods {
+		if isValidPod(podObj) {
+			d.podNamesByIP[podObj.Status.PodIP] = podObj.ObjectMeta.Namespace + "/" + podObj.ObjectMeta.Name
+		}
+
+		for k, v := range podObj.ObjectMeta.Labels {
+			addSetName(util.KubeAllNamespacesFlag + "-" + k + ":" + v)
+		}
+
+		for _, container := range podObj.Spec.Containers {
+			for _, port := range container.Ports {
+				if len(port.Name) > 0 {
+					addSetName(util.NamedPortIPSetPrefix + port.Name)
+				}
+			}
+		}
+	}
+
+	// Namespaces with a default deny label have an implicit policy.
+	policies = append([]*networkingv1.NetworkPolicy{}, policies...)
+	for _, nsObj := range namespaces {
+		if npObj := getDefaultDenyPolicy(nsObj); npObj != nil {
+			policies = append(policies, npObj)
+		}
+	}
+
+	for _, npObj := range policies {
+		policyName := npObj.ObjectMeta.Namespace + "/" + npObj.ObjectMeta.Name
+		podSets, nsLists, ipBlockSets, entries := parsePolicy(npObj)
+
+		addPolicy := func(name string) {
+			addSetName(name)
+			hashedName := util.GetHashedName(name)
+			d.setPolicies[hashedName] = util.UniqueStrSlice(append(d.setPolicies[hashedName], policyName))
+		}
+
+		for _, set := range append(podSets, nsLists...) {
+			addPolicy(set)
+		}
+
+		for set := range ipBlockSets {
+			addPolicy(set)
+		}
+
+		for _, entry := range entries {
+			key := parseDebugRule(entry.Chain, entry.Specs).key()
+			d.rulePolicies[key] = util.UniqueStrSlice(append(d.rulePolicies[key], policyName))
+		}
+	}
+
+	d.sets = parseIpsetSave(ipsetSave)
+	d.chains, d.chainNames = parseIptablesSave(iptablesSave)
+
+	return d
+}
+
+// parseIpsetSave parses the output of ipset save.
+func parseIpsetSave(out string) map[string]*debugSet {
+	sets := make(map[string]*debugSet)
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+
+		switch fields[0] {
+		case "create":
+			sets[fields[1]] = &debugSet{name: fields[1], kind: fields[2]}
+		case "add":
+			if set, ok := sets[fields[1]]; ok {
+				set.members = append(set.members, strings.Join(fields[2:], " "))
+			}
+		}
+	}
+
+	return sets
+}
+
+// parseIptablesSave parses the rules of the filter table in the output of iptables-save.
+// It returns the rules by chain and the chain names in the order they were listed.
+func parseIptablesSave(out string) (map[string][]*debugRule, []string) {
+	var (
+		chains     = make(map[string][]*debugRule)
+		chainNames []string
+		isFilter   = true
+	)
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+
+		// Rules saved with their counters are prefixed with [packets:bytes].
+		var packets, bytes uint64
+		if strings.HasPrefix(line, "[") {
+			if end := strings.Index(line, "]"); end > 0 {
+				fmt.Sscanf(line[:end+1], "[%d:%d]", &packets, &bytes)
+				line = strings.TrimSpace(line[end+1:])
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(line, "*"):
+			isFilter = line == "*filter"
+		case !isFilter:
+		case strings.HasPrefix(line, ":"):
+			fields := strings.Fields(line[1:])
+			if len(fields) > 0 {
+				if _, ok := chains[fields[0]]; !ok {
+					chainNames = append(chainNames, fields[0])
+					chains[fields[0]] = nil
+				}
+			}
+		case strings.HasPrefix(line, util.IptablesAppendFlag+" "):
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+
+			if _, ok := chains[fields[1]]; !ok {
+				chainNames = append(chainNames, fields[1])
+			}
+
+			rule := parseDebugRule(fields[1], fields[2:])
+			rule.text = line
+			rule.packets, rule.bytes = packets, bytes
+			chains[fields[1]] = append(chains[fields[1]], rule)
+		}
+	}
+
+	return chains, chainNames
+}
+
+// parseDebugRule parses the specs of an iptables rule.
+func parseDebugRule(chain string, specs []string) *debugRule {
+	rule := &debugRule{
+		chain: chain,
+		text:  strings.Join(append([]string{util.IptablesAppendFlag, chain}, specs...), " "),
+	}
+
+	negate := false
+	next := func(i *int) string {
+		*i++
+		if *i < len(specs) {
+			return specs[*i]
+		}
+		return ""
+	}
+
+	for i := 0; i < len(specs); i++ {
+		spec := specs[i]
+		switch spec {
+		case util.IptablesNotFlag:
+			negate = true
+			continue
+		case util.IptablesMatchFlag:
+			// Match modules are implied by the match options following them.
+			next(&i)
+		case util.IptablesMatchSetFlag:
+			name := next(&i)
+			rule.sets = append(rule.sets, &debugSetMatch{name: name, flags: next(&i), negate: negate})
+		case util.IptablesProtFlag, "--protocol":
+			rule.protocol = strings.ToLower(next(&i))
+		case util.IptablesDstPortFlag, "--destination-port":
+			rule.dport = next(&i)
+		case util.IptablesMarkFlag:
+			rule.mark, rule.negateMark = next(&i), negate
+		case util.IPtablesMatchStateFlag, "--ctstate":
+			next(&i)
+			rule.matchesConn = true
+		case util.IptablesSFlag, "--source":
+			rule.src = next(&i)
+		case util.IptablesDFlag, "--destination":
+			rule.dst = next(&i)
+		case util.IptablesJumpFlag, "--jump", "-g", "--goto":
+			rule.target = next(&i)
+		case util.IptablesSetMarkFlag, "--set-mark":
+			rule.setMark = next(&i)
+		default:
+			rule.unknown = append(rule.unknown, spec)
+		}
+		negate = false
+	}
+
+	return rule
+}
+
+// key identifies a rule independently of how iptables-save formats it.
+func (rule *debugRule) key() string {
+	var sets []string
+	for _, set := range rule.sets {
+		sets = append(sets, fmt.Sprintf("%v:%s:%s", set.negate, set.name, set.flags))
+	}
+
+	return strings.Join([]string{
+		rule.chain,
+		strings.Join(sets, ","),
+		rule.protocol,
+		rule.dport,
+		fmt.Sprintf("%v:%s", rule.negateMark, rule.mark),
+		rule.src,
+		rule.dst,
+		rule.target,
+		rule.setMark,
+	}, "|")
+}
+
+// isNpmChain checks if a chain is managed by NPM.
+func isNpmChain(chain string) bool {
+	return strings.HasPrefix(chain, util.IptablesAzureChain)
+}
+
+// describeSetName describes the cluster objects an ipset selects.
+func describeSetName(name string) string {
+	allNsPrefix := util.KubeAllNamespacesFlag + "-"
+
+	switch {
+	case name == util.KubeAllNamespacesFlag:
+		return "all namespaces"
+	case strings.HasPrefix(name, allNsPrefix):
+		return "pods labeled " + strings.Replace(strings.TrimPrefix(name, allNsPrefix), ":", "=", 1)
+	case strings.HasPrefix(name, "ns-"):
+		return "namespaces labeled " + strings.Replace(strings.TrimPrefix(name, "ns-"), ":", "=", 1)
+	case strings.HasPrefix(name, util.NamedPortIPSetPrefix):
+		return "pods exposing named port " + strings.TrimPrefix(name, util.NamedPortIPSetPrefix)
+	case strings.HasPrefix(name, util.FQDNIPSetPrefix):
+		return "addresses of " + strings.TrimPrefix(name, util.FQDNIPSetPrefix)
+	case strings.HasPrefix(name, util.IPBlockIPSetPrefix):
+		return "ipBlock " + strings.Replace(strings.TrimPrefix(name, util.IPBlockIPSetPrefix), "-except-", " except ", 1)
+	default:
+		return "pods in namespace " + name
+	}
+}
+
+// describeSet describes an ipset and the network policies referring to it.
+func (d *Debugger) describeSet(hashedName string) string {
+	name, ok := d.setNames[hashedName]
+	if !ok {
+		return hashedName + " (unknown, not produced by any current pod, namespace or network policy)"
+	}
+
+	desc := fmt.Sprintf("%s (%s: %s)", hashedName, name, describeSetName(name))
+	if policies := d.setPolicies[hashedName]; len(policies) > 0 {
+		desc += ", used by policies " + strings.Join(policies, ", ")
+	}
+
+	return desc
+}
+
+// describeRule describes the network policies that produced an iptables rule.
+func (d *Debugger) describeRule(rule *debugRule) string {
+	if policies := d.rulePolicies[rule.key()]; len(policies) > 0 {
+		return "from policies " + strings.Join(policies, ", ")
+	}
+
+	if d.defaultChains[rule.chain] {
+		return "azure-npm default rule"
+	}
+
+	return "not produced by any current network policy"
+}
+
+// Dump writes the NPM ipsets and iptables chains annotated with the cluster objects that produced them.
+func (d *Debugger) Dump(w io.Writer) {
+	var hashedNames []string
+	for hashedName := range d.sets {
+		if strings.HasPrefix(hashedName, util.AzureNpmPrefix) {
+			hashedNames = append(hashedNames, hashedName)
+		}
+	}
+	sort.Strings(hashedNames)
+
+	fmt.Fprintf(w, "IPSETS\n")
+	for _, hashedName := range hashedNames {
+		set := d.sets[hashedName]
+		fmt.Fprintf(w, "%s [%s]\n", d.describeSet(hashedName), set.kind)
+		for _, member := range set.members {
+			fmt.Fprintf(w, "    %s\n", d.describeMember(set, member))
+		}
+	}
+
+	fmt.Fprintf(w, "\nIPTABLES\n")
+	for _, chain := range d.chainNames {
+		if !isNpmChain(chain) {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\n", chain)
+		for _, rule := range d.chains[chain] {
+			fmt.Fprintf(w, "    %s\n", rule.text)
+			fmt.Fprintf(w, "        %s\n", d.describeRule(rule))
+			for _, set := range rule.sets {
+				fmt.Fprintf(w, "        set %s\n", d.describeSet(set.name))
+			}
+		}
+	}
+}
+
+// describeMember describes an ipset member.
+func (d *Debugger) describeMember(set *debugSet, member string) string {
+	if isDebugList(set) {
+		if name, ok := d.setNames[member]; ok {
+			return fmt.Sprintf("%s (%s)", member, name)
+		}
+		return member
+	}
+
+	ip := strings.SplitN(strings.Fields(member)[0], ",", 2)[0]
+	if podName, ok := d.podNamesByIP[ip]; ok {
+		return fmt.Sprintf("%s (pod %s)", member, podName)
+	}
+
+	return member
+}
+
+// Simulate evaluates the NPM iptables rules for a new connection from the source pod to the destination pod.
+func (d *Debugger) Simulate(src *corev1.Pod, dst *corev1.Pod, protocol string, port int) (*DebugVerdict, error) {
+	pkt := &debugPacket{
+		srcIP:    net.ParseIP(src.Status.PodIP),
+		dstIP:    net.ParseIP(dst.Status.PodIP),
+		protocol: strings.ToLower(protocol),
+		port:     strconv.Itoa(port),
+	}
+
+	if pkt.srcIP == nil {
+		return nil, fmt.Errorf("Pod %s/%s has no IP address", src.ObjectMeta.Namespace, src.ObjectMeta.Name)
+	}
+
+	if pkt.dstIP == nil {
+		return nil, fmt.Errorf("Pod %s/%s has no IP address", dst.ObjectMeta.Namespace, dst.ObjectMeta.Name)
+	}
+
+	verdict := &DebugVerdict{Allowed: true}
+
+	if _, ok := d.chains[util.IptablesAzureChain]; !ok {
+		verdict.Trace = append(verdict.Trace, "AZURE-NPM chain doesn't exist, NPM doesn't filter any traffic")
+		return verdict, nil
+	}
+
+	target, err := d.simulateChain(util.IptablesAzureChain, pkt, verdict, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	switch target {
+	case util.IptablesAccept:
+	case util.IptablesDrop, util.IptablesReject:
+		verdict.Allowed = false
+	default:
+		verdict.Trace = append(verdict.Trace, "no AZURE-NPM rule decided, the FORWARD chain continues")
+	}
+
+	return verdict, nil
+}
+
+// simulateChain evaluates the rules of a chain and returns the terminating target, if any.
+func (d *Debugger) simulateChain(chain string, pkt *debugPacket, verdict *DebugVerdict, depth int) (string, error) {
+	if depth > maxDebugChainDepth {
+		return "", fmt.Errorf("Chain %s is nested too deep", chain)
+	}
+
+	for _, rule := range d.chains[chain] {
+		if !d.ruleMatches(rule, pkt) {
+			continue
+		}
+
+		verdict.Trace = append(verdict.Trace, fmt.Sprintf("%s\n    %s", rule.text, d.describeRule(rule)))
+
+		switch rule.target {
+		case util.IptablesAccept, util.IptablesDrop, util.IptablesReject:
+			return rule.target, nil
+		case "RETURN":
+			return "", nil
+		case util.IptablesMarkTarget:
+			value, mask, err := parseMark(rule.setMark)
+			if err != nil {
+				return "", err
+			}
+			pkt.mark = (pkt.mark &^ mask) ^ value
+		default:
+			if _, ok := d.chains[rule.target]; !ok {
+				continue
+			}
+
+			target, err := d.simulateChain(rule.target, pkt, verdict, depth+1)
+			if err != nil || len(target) > 0 {
+				return target, err
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// ruleMatches checks if a rule matches a packet of a new connection.
+func (d *Debugger) ruleMatches(rule *debugRule, pkt *debugPacket) bool {
+	// Connection state matches only accept packets of existing connections.
+	if rule.matchesConn || len(rule.unknown) > 0 {
+		return false
+	}
+
+	if len(rule.protocol) > 0 && rule.protocol != pkt.protocol {
+		return false
+	}
+
+	if len(rule.dport) > 0 && !portRangeContains(rule.dport, pkt.port) {
+		return false
+	}
+
+	if len(rule.src) > 0 && !cidrContains(rule.src, pkt.srcIP) {
+		return false
+	}
+
+	if len(rule.dst) > 0 && !cidrContains(rule.dst, pkt.dstIP) {
+		return false
+	}
+
+	if len(rule.mark) > 0 {
+		value, mask, err := parseMark(rule.mark)
+		if err != nil || (pkt.mark&mask == value) == rule.negateMark {
+			return false
+		}
+	}
+
+	for _, set := range rule.sets {
+		if d.setMatches(set.name, set.flags, pkt, 0) == set.negate {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setMatches checks if a packet matches an ipset.
+func (d *Debugger) setMatches(name string, flags string, pkt *debugPacket, depth int) bool {
+	set, ok := d.sets[name]
+	if !ok || depth > maxDebugChainDepth {
+		return false
+	}
+
+	ip := pkt.srcIP
+	if strings.HasPrefix(flags, util.IptablesDstFlag) {
+		ip = pkt.dstIP
+	}
+
+	switch {
+	case isDebugList(set):
+		for _, member := range set.members {
+			if d.setMatches(member, flags, pkt, depth+1) {
+				return true
+			}
+		}
+		return false
+	case set.kind == util.IpsetIPPortHashFlag:
+		entry := ip.String() + "," + pkt.protocol + ":" + pkt.port
+		for _, member := range set.members {
+			if member == entry {
+				return true
+			}
+		}
+		return false
+	default:
+		// The most specific matching entry decides, nomatch entries exclude their range.
+		bestLen, matched := -1, false
+		for _, member := range set.members {
+			fields := strings.Fields(member)
+			cidr := fields[0]
+			if !strings.Contains(cidr, "/") {
+				cidr += "/32"
+			}
+
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil || !ipNet.Contains(ip) {
+				continue
+			}
+
+			if ones, _ := ipNet.Mask.Size(); ones > bestLen {
+				bestLen, matched = ones, len(fields) < 2 || fields[1] != util.IpsetNomatch
+			}
+		}
+		return matched
+	}
+}
+
+// isDebugList checks if an ipset is a list of ipsets.
+func isDebugList(set *debugSet) bool {
+	return set.kind == util.IpsetSetListFlag || set.kind == ipsetSaveListType
+}
+
+// cidrContains checks if an address or CIDR contains an IP.
+func cidrContains(cidr string, ip net.IP) bool {
+	if !strings.Contains(cidr, "/") {
+		cidr += "/32"
+	}
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	return err == nil && ipNet.Contains(ip)
+}
+
+// portRangeContains checks if a port or first:last port range contains a port.
+func portRangeContains(portRange string, port string) bool {
+	bounds := strings.SplitN(portRange, ":", 2)
+	if len(bounds) == 1 {
+		return portRange == port
+	}
+
+	first, err1 := strconv.Atoi(bounds[0])
+	last, err2 := strconv.Atoi(bounds[1])
+	n, err3 := strconv.Atoi(port)
+
+	return err1 == nil && err2 == nil && err3 == nil && first <= n && n <= last
+}
+
+// parseMark parses a value/mask mark.
+func parseMark(mark string) (uint32, uint32, error) {
+	parts := strings.SplitN(mark, "/", 2)
+	value, err := strconv.ParseUint(parts[0], 0, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid mark %s: %v", mark, err)
+	}
+
+	mask := uint64(0xffffffff)
+	if len(parts) == 2 {
+		if mask, err = strconv.ParseUint(parts[1], 0, 32); err != nil {
+			return 0, 0, fmt.Errorf("Invalid mark %s: %v", mark, err)
+		}
+	}
+
+	return uint32(value), uint32(mask), nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	acn "github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/iptables"
+	"github.com/Azure/azure-container-networking/npm"
+	"github.com/Azure/azure-container-networking/npm/util"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Version is populated by make during build.
+var version string
+
+// Command line arguments for the NPM debug tool.
+var args = acn.ArgumentList{
+	{
+		Name:         acn.OptNpmDebugCommand,
+		Shorthand:    acn.OptNpmDebugCommandAlias,
+		Description:  "Dump the annotated dataplane or simulate traffic between two pods",
+		Type:         "string",
+		DefaultValue: acn.OptNpmDebugCommandDump,
+		ValueMap: map[string]interface{}{
+			acn.OptNpmDebugCommandDump:     0,
+			acn.OptNpmDebugCommandSimulate: 0,
+		},
+	},
+	{
+		Name:         acn.OptNpmDebugSrcPod,
+		Shorthand:    acn.OptNpmDebugSrcPodAlias,
+		Description:  "Set the source pod of simulated traffic as namespace/name",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptNpmDebugDstPod,
+		Shorthand:    acn.OptNpmDebugDstPodAlias,
+		Description:  "Set the destination pod of simulated traffic as namespace/name",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptNpmDebugProtocol,
+		Shorthand:    acn.OptNpmDebugProtocolAlias,
+		Description:  "Set the protocol of simulated traffic",
+		Type:         "string",
+		DefaultValue: "tcp",
+		ValueMap: map[string]interface{}{
+			"tcp":  0,
+			"udp":  0,
+			"sctp": 0,
+		},
+	},
+	{
+		Name:         acn.OptNpmDebugPort,
+		Shorthand:    acn.OptNpmDebugPortAlias,
+		Description:  "Set the destination port of simulated traffic",
+		Type:         "int",
+		DefaultValue: "80",
+	},
+}
+
+// Prints description and version information.
+func printVersion() {
+	fmt.Printf("Azure Network Policy Manager debug tool\n")
+	fmt.Printf("Version %v\n", version)
+}
+
+// findPod returns the pod with the given namespace/name.
+func findPod(pods []*corev1.Pod, name string) (*corev1.Pod, error) {
+	for _, podObj := range pods {
+		if podObj.ObjectMeta.Namespace+"/"+podObj.ObjectMeta.Name == name {
+			return podObj, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Pod %s not found", name)
+}
+
+// newDebugger lists the cluster objects and reads the dataplane of this node.
+func newDebugger() (*npm.Debugger, []*corev1.Pod, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	podList, err := clientset.CoreV1().Pods("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nsList, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	npList, err := clientset.NetworkingV1().NetworkPolicies("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		pods       []*corev1.Pod
+		namespaces []*corev1.Namespace
+		policies   []*networkingv1.NetworkPolicy
+	)
+
+	for i := range podList.Items {
+		pods = append(pods, &podList.Items[i])
+	}
+
+	for i := range nsList.Items {
+		namespaces = append(namespaces, &nsList.Items[i])
+	}
+
+	for i := range npList.Items {
+		policies = append(policies, &npList.Items[i])
+	}
+
+	ipsetSave, err := exec.Command(util.Ipset, util.IpsetSaveFlag).Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to run ipset save: %v", err)
+	}
+
+	iptablesSave, err := iptables.GetClient().Save(iptables.Filter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to run iptables-save: %v", err)
+	}
+
+	return npm.NewDebugger(pods, namespaces, policies, string(ipsetSave), string(iptablesSave)), pods, nil
+}
+
+func main() {
+	acn.ParseArgs(&args, printVersion)
+	command := acn.GetArg(acn.OptNpmDebugCommand).(string)
+	srcPodName := acn.GetArg(acn.OptNpmDebugSrcPod).(string)
+	dstPodName := acn.GetArg(acn.OptNpmDebugDstPod).(string)
+	protocol := acn.GetArg(acn.OptNpmDebugProtocol).(string)
+	port := acn.GetArg(acn.OptNpmDebugPort).(int)
+
+	debugger, pods, err := newDebugger()
+	if err != nil {
+		fmt.Printf("Failed to read NPM state: %v\n", err)
+		os.Exit(1)
+	}
+
+	if command == acn.OptNpmDebugCommandDump {
+		debugger.Dump(os.Stdout)
+		return
+	}
+
+	srcPod, err := findPod(pods, srcPodName)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	dstPod, err := findPod(pods, dstPodName)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	verdict, err := debugger.Simulate(srcPod, dstPod, protocol, port)
+	if err != nil {
+		fmt.Printf("Failed to simulate traffic: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s/%d from %s to %s:\n", protocol, port, srcPodName, dstPodName)
+	for _, step := range verdict.Trace {
+		fmt.Printf("  %s\n", strings.Replace(step, "\n", "\n  ", -1))
+	}
+
+	if verdict.Allowed {
+		fmt.Printf("ALLOWED\n")
+	} else {
+		fmt.Printf("DROPPED\n")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestDebugger(t *testing.T) {
+	tcp := corev1.ProtocolTCP
+	port := intstr.FromInt(8080)
+
+	backend := newTestPod("test", "backend", "10.240.0.4", map[string]string{"app": "backend"})
+	frontend := newTestPod("test", "frontend", "10.240.0.5", map[string]string{"app": "frontend"})
+	npObj := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test",
+			Name:      "allow-frontend",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "backend"},
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					Ports: []networkingv1.NetworkPolicyPort{
+						{Protocol: &tcp, Port: &port},
+					},
+					From: []networkingv1.NetworkPolicyPeer{
+						{
+							PodSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"app": "frontend"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	backendSet := util.GetHashedName("all-namespace-app:backend")
+	frontendSet := util.GetHashedName("all-namespace-app:frontend")
+
+	ipsetSave := strings.Join([]string{
+		"create " + backendSet + " hash:net family inet hashsize 1024 maxelem 65536",
+		"add " + backendSet + " 10.240.0.4",
+		"create " + frontendSet + " hash:net family inet hashsize 1024 maxelem 65536",
+		"add " + frontendSet + " 10.240.0.5",
+	}, "\n")
+
+	// Rules are listed the way iptables-save formats them.
+	iptablesSave := strings.Join([]string{
+		"*filter",
+		":FORWARD ACCEPT [0:0]",
+		":AZURE-NPM - [0:0]",
+		":AZURE-NPM-INGRESS-PORT - [0:0]",
+		":AZURE-NPM-INGRESS-FROM - [0:0]",
+		":AZURE-NPM-TARGET-SETS - [0:0]",
+		"-A FORWARD -j AZURE-NPM",
+		"-A AZURE-NPM -m state --state RELATED,ESTABLISHED -j ACCEPT",
+		"-A AZURE-NPM -j AZURE-NPM-INGRESS-PORT",
+		"-A AZURE-NPM -j AZURE-NPM-TARGET-SETS",
+		"-A AZURE-NPM -m mark --mark 0x2000/0x2000 -j ACCEPT",
+		"-A AZURE-NPM-INGRESS-PORT -p tcp -m tcp --dport 8080 -m set --match-set " + backendSet + " dst -j AZURE-NPM-INGRESS-FROM",
+		"-A AZURE-NPM-INGRESS-FROM -m set --match-set " + frontendSet + " src -m set --match-set " + backendSet + " dst -j MARK --set-xmark 0x2000/0x2000",
+		"-A AZURE-NPM-TARGET-SETS -m set --match-set " + backendSet + " dst -m mark ! --mark 0x2000/0x2000 -j DROP",
+		"COMMIT",
+	}, "\n")
+
+	d := NewDebugger(
+		[]*corev1.Pod{backend, frontend},
+		nil,
+		[]*networkingv1.NetworkPolicy{npObj},
+		ipsetSave,
+		iptablesSave)
+
+	var buf bytes.Buffer
+	d.Dump(&buf)
+	dump := buf.String()
+
+	if !strings.Contains(dump, "pods labeled app=backend") || !strings.Contains(dump, "10.240.0.4 (pod test/backend)") {
+		t.Errorf("Dump doesn't describe the ipsets:\n%s", dump)
+	}
+
+	if strings.Count(dump, "from policies test/allow-frontend") != 3 {
+		t.Errorf("Dump doesn't map the iptables rules to the policy:\n%s", dump)
+	}
+
+	verdict, err := d.Simulate(frontend, backend, "tcp", 8080)
+	if err != nil {
+		t.Fatalf("Simulate failed: %v", err)
+	}
+
+	if !verdict.Allowed {
+		t.Errorf("Expected frontend to reach backend on 8080, trace: %v", verdict.Trace)
+	}
+
+	verdict, err = d.Simulate(frontend, backend, "tcp", 9090)
+	if err != nil {
+		t.Fatalf("Simulate failed: %v", err)
+	}
+
+	if verdict.Allowed {
+		t.Errorf("Expected frontend to be dropped on 9090, trace: %v", verdict.Trace)
+	}
+
+	if last := verdict.Trace[len(verdict.Trace)-1]; !strings.Contains(last, "-j DROP") {
+		t.Errorf("Expected the trace to end with the drop rule, got %s", last)
+	}
+}
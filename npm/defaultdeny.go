@@ -0,0 +1,57 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"github.com/Azure/azure-container-networking/log"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultDenyLabel isolates all pods of a namespace as if it had a network policy selecting all of them
+	// without allowing any traffic. Its value is the direction the pods are isolated in: ingress, egress or all.
+	// Network policies of the namespace allow traffic as usual.
+	DefaultDenyLabel = "netpol.azure.com/default-deny"
+
+	DefaultDenyIngress = "ingress"
+	DefaultDenyEgress  = "egress"
+	DefaultDenyAll     = "all"
+
+	// Name of the implicit default deny policies. Object names can't contain colons, so it doesn't clash with policies.
+	defaultDenyPolicyName = "azure-npm:default-deny"
+)
+
+// getDefaultDenyPolicy returns the implicit network policy the default deny label of a namespace stands for,
+// or nil if the namespace has no valid default deny label.
+func getDefaultDenyPolicy(nsObj *corev1.Namespace) *networkingv1.NetworkPolicy {
+	value, exists := nsObj.ObjectMeta.Labels[DefaultDenyLabel]
+	if !exists {
+		return nil
+	}
+
+	var policyTypes []networkingv1.PolicyType
+	switch value {
+	case DefaultDenyIngress:
+		policyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}
+	case DefaultDenyEgress:
+		policyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeEgress}
+	case DefaultDenyAll:
+		policyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}
+	default:
+		log.Printf("Ignoring invalid default deny label %s of namespace %s", value, nsObj.ObjectMeta.Name)
+		return nil
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: nsObj.ObjectMeta.Name,
+			Name:      defaultDenyPolicyName,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PolicyTypes: policyTypes,
+		},
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetDefaultDenyPolicy(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []networkingv1.PolicyType
+	}{
+		{DefaultDenyIngress, []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}},
+		{DefaultDenyEgress, []networkingv1.PolicyType{networkingv1.PolicyTypeEgress}},
+		{DefaultDenyAll, []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}},
+		{"none", nil},
+	}
+
+	for _, test := range tests {
+		nsObj := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "test",
+				Labels: map[string]string{DefaultDenyLabel: test.value},
+			},
+		}
+
+		npObj := getDefaultDenyPolicy(nsObj)
+		if test.expected == nil {
+			if npObj != nil {
+				t.Errorf("Invalid label %s has default deny policy %+v", test.value, npObj)
+			}
+			continue
+		}
+
+		if npObj == nil || npObj.ObjectMeta.Namespace != "test" || !reflect.DeepEqual(npObj.Spec.PolicyTypes, test.expected) {
+			t.Errorf("Unexpected default deny policy %+v for label %s", npObj, test.value)
+			continue
+		}
+
+		// The policy drops all traffic of the namespace in its directions, and allows nothing.
+		_, _, _, entries := parsePolicy(npObj)
+		if len(entries) != len(test.expected) {
+			t.Errorf("Unexpected rules %+v for label %s", entries, test.value)
+		}
+	}
+
+	if getDefaultDenyPolicy(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}}) != nil {
+		t.Errorf("Namespace without label has a default deny policy")
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/bpf"
+	"github.com/Azure/azure-container-networking/log"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DataplaneIptables enforces network policies with iptables rules and ipsets.
+	DataplaneIptables = "iptables"
+
+	// DataplaneEBPF enforces network policies with eBPF programs attached to the host veths of the pods.
+	// It is experimental: admin network policies, domain names, audit mode and hit counters aren't supported,
+	// and egress rules see the virtual IPs of services before kube-proxy translates them.
+	DataplaneEBPF = "ebpf"
+
+	// Directions of the eBPF map keys, from the point of view of the local pod.
+	ebpfIngress = 0
+	ebpfEgress  = 1
+
+	// Remote identity of the policy keys allowing any remote address.
+	ebpfAnyIdentity = 0xFFFFFFFF
+
+	// Sizes of the eBPF map keys and values.
+	ebpfEndpointKeySize    = 4
+	ebpfEndpointValueSize  = 8
+	ebpfIsolationKeySize   = 8
+	ebpfPolicyKeySize      = 12
+	ebpfCIDRKeySize        = 16
+	ebpfConnKeySize        = 16
+	ebpfAllowValueSize     = 4
+	ebpfConnValueSize      = 8
+	ebpfCIDRKeyPrefixBits  = 64
+	ebpfCIDRKeyAddressBits = 32
+)
+
+// The dataplane network policies are enforced with. Windows always programs HNS ACL policies.
+var dataplane = DataplaneIptables
+
+// SetDataplane sets the dataplane network policies are enforced with.
+func SetDataplane(name string) {
+	dataplane = name
+}
+
+// isEbpfDataplane checks if network policies are enforced with eBPF programs.
+func isEbpfDataplane() bool {
+	return dataplane == DataplaneEBPF
+}
+
+// idAllocator assigns small numeric IDs to names, keeping the IDs of names across allocations.
+// Zero is never allocated.
+type idAllocator struct {
+	ids map[string]uint32
+}
+
+func newIDAllocator() *idAllocator {
+	return &idAllocator{ids: make(map[string]uint32)}
+}
+
+// allocate returns the IDs of the given names. IDs of names no longer given are released.
+func (a *idAllocator) allocate(names map[string]bool) map[string]uint32 {
+	used := make(map[uint32]bool)
+	for name, id := range a.ids {
+		if names[name] {
+			used[id] = true
+		} else {
+			delete(a.ids, name)
+		}
+	}
+
+	// Assign the lowest free IDs in a deterministic order.
+	var added []string
+	for name := range names {
+		if _, exists := a.ids[name]; !exists {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+
+	next := uint32(1)
+	for _, name := range added {
+		for used[next] {
+			next++
+		}
+		a.ids[name] = next
+		used[next] = true
+	}
+
+	ids := make(map[string]uint32, len(a.ids))
+	for name, id := range a.ids {
+		ids[name] = id
+	}
+
+	return ids
+}
+
+// ebpfMaps is the content of the maps the eBPF programs enforce network policies with.
+// Keys are the binary keys of the maps converted to strings.
+type ebpfMaps struct {
+	// endpoints maps pod IPs to their identity, and to their endpoint ID if they are local and enforced.
+	endpoints map[string][]byte
+	// isolation holds the endpoints and directions network policies isolate.
+	isolation map[string][]byte
+	// policies holds the allowed remote identities, ports and protocols of isolated endpoints.
+	policies map[string][]byte
+	// cidrs is a longest prefix match trie of the allowed remote addresses that aren't pods.
+	cidrs map[string][]byte
+}
+
+// getPodIdentity returns the name of the identity of a pod. Network policies select pods by namespace
+// and labels only, so pods with the same identity are allowed the same traffic.
+func getPodIdentity(podObj *corev1.Pod) string {
+	var labels []string
+	for key, value := range podObj.ObjectMeta.Labels {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+
+	return podObj.ObjectMeta.Namespace + "/" + strings.Join(labels, ",")
+}
+
+// getEbpfPort returns the port of an ACL rule in network byte order, zero for all ports.
+// Port ranges aren't supported.
+func getEbpfPort(ports string) (uint16, bool) {
+	if ports == "" {
+		return 0, true
+	}
+
+	port, err := strconv.ParseUint(ports, 10, 16)
+	if err != nil {
+		return 0, false
+	}
+
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(port))
+	return bpf.NativeEndian().Uint16(b), true
+}
+
+// compileEbpfMaps evaluates the network policies of the enforced pods on the given host IPs,
+// and returns the content of the eBPF maps enforcing them.
+func compileEbpfMaps(state *policyState, hostIPs map[string]bool, identities *idAllocator, endpoints *idAllocator) *ebpfMaps {
+	maps := &ebpfMaps{
+		endpoints: make(map[string][]byte),
+		isolation: make(map[string][]byte),
+		policies:  make(map[string][]byte),
+		cidrs:     make(map[string][]byte),
+	}
+
+	order := bpf.NativeEndian()
+	allow := make([]byte, ebpfAllowValueSize)
+	order.PutUint32(allow, 1)
+
+	// Host network pods share the address of their node, which can't be told apart from other host traffic.
+	var pods []*corev1.Pod
+	identityNames := make(map[string]bool)
+	endpointNames := make(map[string]bool)
+	for _, podObj := range state.pods {
+		if !isValidPod(podObj) || podObj.Spec.HostNetwork || net.ParseIP(podObj.Status.PodIP).To4() == nil {
+			continue
+		}
+
+		pods = append(pods, podObj)
+		identityNames[getPodIdentity(podObj)] = true
+		if isPolicyEnforced(podObj) && hostIPs[podObj.Status.HostIP] {
+			endpointNames[getObjectKey(podObj.ObjectMeta)] = true
+		}
+	}
+
+	identityIDs := identities.allocate(identityNames)
+	endpointIDs := endpoints.allocate(endpointNames)
+
+	podIdentities := make(map[string]uint32)
+	for _, podObj := range pods {
+		identity := identityIDs[getPodIdentity(podObj)]
+		podIdentities[podObj.Status.PodIP] = identity
+
+		value := make([]byte, ebpfEndpointValueSize)
+		order.PutUint32(value[0:4], identity)
+		order.PutUint32(value[4:8], endpointIDs[getObjectKey(podObj.ObjectMeta)])
+		maps.endpoints[string(net.ParseIP(podObj.Status.PodIP).To4())] = value
+	}
+
+	for _, podObj := range pods {
+		endpointID := endpointIDs[getObjectKey(podObj.ObjectMeta)]
+		if endpointID == 0 {
+			continue
+		}
+
+		for _, rule := range state.getEndpointACLs(podObj) {
+			direction, port := uint8(ebpfIngress), rule.LocalPorts
+			if rule.Direction == aclDirectionOut {
+				direction, port = ebpfEgress, rule.RemotePorts
+			}
+
+			if rule.Action == aclActionBlock {
+				key := make([]byte, ebpfIsolationKeySize)
+				order.PutUint32(key[0:4], endpointID)
+				order.PutUint32(key[4:8], uint32(direction))
+				maps.isolation[string(key)] = allow
+				continue
+			}
+
+			// Rules of the default priority allow all traffic of endpoints that aren't isolated.
+			if rule.Priority == aclDefaultPriority {
+				continue
+			}
+
+			portNumber, ok := getEbpfPort(port)
+			protocol, err := strconv.ParseUint("0"+rule.Protocols, 10, 8)
+			if !ok || err != nil {
+				log.Printf("Ignoring ports %s and protocols %s of pod %s/%s unsupported by the eBPF dataplane",
+					port, rule.Protocols, podObj.ObjectMeta.Namespace, podObj.ObjectMeta.Name)
+				continue
+			}
+
+			policyKey := func(remote uint32) string {
+				key := make([]byte, ebpfPolicyKeySize)
+				order.PutUint32(key[0:4], endpointID)
+				order.PutUint32(key[4:8], remote)
+				order.PutUint16(key[8:10], portNumber)
+				key[10] = uint8(protocol)
+				key[11] = direction
+				return string(key)
+			}
+
+			if rule.RemoteAddresses == "" {
+				maps.policies[policyKey(ebpfAnyIdentity)] = allow
+				continue
+			}
+
+			for _, address := range strings.Split(rule.RemoteAddresses, ",") {
+				if remote, isPod := podIdentities[address]; isPod {
+					maps.policies[policyKey(remote)] = allow
+					continue
+				}
+
+				if !strings.Contains(address, "/") {
+					address += "/32"
+				}
+
+				_, ipNet, err := net.ParseCIDR(address)
+				if err != nil || ipNet.IP.To4() == nil {
+					log.Printf("Ignoring address %s unsupported by the eBPF dataplane", address)
+					continue
+				}
+
+				ones, _ := ipNet.Mask.Size()
+				key := make([]byte, ebpfCIDRKeySize)
+				order.PutUint32(key[0:4], uint32(ebpfCIDRKeyPrefixBits+ones))
+				order.PutUint32(key[4:8], endpointID)
+				key[8] = direction
+				key[9] = uint8(protocol)
+				order.PutUint16(key[10:12], portNumber)
+				copy(key[12:16], ipNet.IP.To4())
+				maps.cidrs[string(key)] = allow
+			}
+		}
+	}
+
+	return maps
+}
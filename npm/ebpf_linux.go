@@ -0,0 +1,582 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-container-networking/bpf"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/netlink"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Prefix of the host side of the veth pairs of the pods created by the Azure CNI plugin.
+	ebpfHostVethPrefix = "azv"
+
+	// Handle, priority and names of the filters running the programs on the hooks of the host veths.
+	ebpfFilterHandle      = 1
+	ebpfFilterPriority    = 1
+	ebpfIngressFilterName = "azure-npm-ingress"
+	ebpfEgressFilterName  = "azure-npm-egress"
+
+	// Maximum number of entries of the maps.
+	ebpfMaxEndpoints = 65536
+	ebpfMaxIsolation = 4096
+	ebpfMaxPolicies  = 65536
+	ebpfMaxCIDRs     = 16384
+	ebpfMaxConns     = 262144
+
+	// Idle time after which a connection is evaluated against the network policies again.
+	ebpfConnIdleTimeout = 5 * time.Minute
+
+	// Results of the programs.
+	tcActOK   = 0
+	tcActShot = 2
+
+	ethHeaderLen  = 14
+	ipv4HeaderLen = 20
+)
+
+// ebpfDataplane holds the maps and programs enforcing network policies on the host veths of the local pods.
+type ebpfDataplane struct {
+	endpoints   *bpf.Map
+	isolation   *bpf.Map
+	policies    *bpf.Map
+	cidrs       *bpf.Map
+	conns       *bpf.Map
+	programs    map[int]*bpf.Program
+	identities  *idAllocator
+	endpointIDs *idAllocator
+}
+
+// newEbpfDataplane creates the maps and loads the programs of the eBPF dataplane.
+func newEbpfDataplane() (*ebpfDataplane, error) {
+	var err error
+
+	if err = bpf.RemoveMemlockLimit(); err != nil {
+		log.Printf("Error removing the locked memory limit: %v", err)
+	}
+
+	dp := &ebpfDataplane{
+		programs:    make(map[int]*bpf.Program),
+		identities:  newIDAllocator(),
+		endpointIDs: newIDAllocator(),
+	}
+
+	for _, m := range []struct {
+		m          **bpf.Map
+		mapType    uint32
+		keySize    int
+		valueSize  int
+		maxEntries int
+	}{
+		{&dp.endpoints, bpf.MapTypeHash, ebpfEndpointKeySize, ebpfEndpointValueSize, ebpfMaxEndpoints},
+		{&dp.isolation, bpf.MapTypeHash, ebpfIsolationKeySize, ebpfAllowValueSize, ebpfMaxIsolation},
+		{&dp.policies, bpf.MapTypeHash, ebpfPolicyKeySize, ebpfAllowValueSize, ebpfMaxPolicies},
+		{&dp.cidrs, bpf.MapTypeLPMTrie, ebpfCIDRKeySize, ebpfAllowValueSize, ebpfMaxCIDRs},
+		{&dp.conns, bpf.MapTypeLRUHash, ebpfConnKeySize, ebpfConnValueSize, ebpfMaxConns},
+	} {
+		if *m.m, err = bpf.NewMap(m.mapType, m.keySize, m.valueSize, m.maxEntries); err != nil {
+			dp.close()
+			return nil, err
+		}
+	}
+
+	for _, direction := range []int{ebpfIngress, ebpfEgress} {
+		insns, err := dp.getProgram(direction)
+		if err != nil {
+			dp.close()
+			return nil, err
+		}
+
+		if dp.programs[direction], err = bpf.LoadProgram(bpf.ProgTypeSchedCLS, insns, "MIT"); err != nil {
+			dp.close()
+			return nil, err
+		}
+	}
+
+	return dp, nil
+}
+
+// close releases the maps and programs. Programs attached to host veths keep running.
+func (dp *ebpfDataplane) close() {
+	for _, m := range []*bpf.Map{dp.endpoints, dp.isolation, dp.policies, dp.cidrs, dp.conns} {
+		if m != nil {
+			m.Close()
+		}
+	}
+
+	for _, prog := range dp.programs {
+		prog.Close()
+	}
+}
+
+// nativeUint16 returns the value of the given bytes loaded as a native 16 bit integer.
+func nativeUint16(b ...byte) int32 {
+	return int32(bpf.NativeEndian().Uint16(b))
+}
+
+// getProgram returns the program enforcing the network policies of the local pods in a direction.
+// Ingress programs run on the egress hook of the host veths, and egress programs on their ingress hook.
+//
+// Packets of connections another packet of which was allowed recently are allowed. Packets of other
+// connections are allowed if the local pod isn't isolated in the direction, or if its policies allow
+// the identity or the address of the remote pod, the destination port and the protocol.
+func (dp *ebpfDataplane) getProgram(direction int) ([]byte, error) {
+	// Offsets of the packet fields and map keys on the stack.
+	const (
+		hdr         = -24
+		fragOff     = hdr + 6
+		proto       = hdr + 9
+		saddr       = hdr + 12
+		daddr       = hdr + 16
+		ports       = -28
+		dport       = ports + 2
+		connKey     = -48
+		reverseKey  = -64
+		policyKey   = -64
+		cidrKey     = -80
+		timestamp   = -88
+		stackBottom = timestamp
+	)
+
+	localAddr, remoteAddr := int16(daddr), int16(saddr)
+	if direction == ebpfEgress {
+		localAddr, remoteAddr = saddr, daddr
+	}
+
+	a := bpf.NewAssembler()
+
+	lookup := func(m *bpf.Map, keyOff int32) {
+		a.LoadMap(bpf.R1, m.FD())
+		a.AluReg(bpf.OpMov, bpf.R2, bpf.R10)
+		a.AluImm(bpf.OpAdd, bpf.R2, keyOff)
+		a.Call(bpf.FuncMapLookupElem)
+	}
+
+	copyField := func(size uint8, dstOff int16, srcOff int16) {
+		a.Load(size, bpf.R0, bpf.R10, srcOff)
+		a.Store(size, bpf.R10, dstOff, bpf.R0)
+	}
+
+	// Only IPv4 packets are filtered.
+	a.AluReg(bpf.OpMov, bpf.R6, bpf.R1)
+	a.Load(bpf.SizeW, bpf.R0, bpf.R6, 16)
+	a.JumpImm(bpf.JumpNe, bpf.R0, nativeUint16(0x08, 0x00), "pass")
+
+	for off := int16(stackBottom); off < 0; off += 8 {
+		a.StoreImm(bpf.SizeDW, bpf.R10, off, 0)
+	}
+
+	a.AluReg(bpf.OpMov, bpf.R1, bpf.R6)
+	a.AluImm(bpf.OpMov, bpf.R2, ethHeaderLen)
+	a.AluReg(bpf.OpMov, bpf.R3, bpf.R10)
+	a.AluImm(bpf.OpAdd, bpf.R3, hdr)
+	a.AluImm(bpf.OpMov, bpf.R4, ipv4HeaderLen)
+	a.Call(bpf.FuncSkbLoadBytes)
+	a.JumpImm(bpf.JumpNe, bpf.R0, 0, "pass")
+
+	// Load the ports of TCP, UDP and SCTP packets, except for fragments after the first.
+	a.Load(bpf.SizeB, bpf.R9, bpf.R10, proto)
+	a.Load(bpf.SizeH, bpf.R0, bpf.R10, fragOff)
+	a.AluImm(bpf.OpAnd, bpf.R0, nativeUint16(0x1f, 0xff))
+	a.JumpImm(bpf.JumpNe, bpf.R0, 0, "conns")
+	a.JumpImm(bpf.JumpEq, bpf.R9, 6, "ports")
+	a.JumpImm(bpf.JumpEq, bpf.R9, 17, "ports")
+	a.JumpImm(bpf.JumpEq, bpf.R9, 132, "ports")
+	a.Jump("conns")
+
+	a.Label("ports")
+	a.Load(bpf.SizeB, bpf.R2, bpf.R10, hdr)
+	a.AluImm(bpf.OpAnd, bpf.R2, 0x0f)
+	a.AluImm(bpf.OpLsh, bpf.R2, 2)
+	a.AluImm(bpf.OpAdd, bpf.R2, ethHeaderLen)
+	a.AluReg(bpf.OpMov, bpf.R1, bpf.R6)
+	a.AluReg(bpf.OpMov, bpf.R3, bpf.R10)
+	a.AluImm(bpf.OpAdd, bpf.R3, ports)
+	a.AluImm(bpf.OpMov, bpf.R4, 4)
+	a.Call(bpf.FuncSkbLoadBytes)
+	a.JumpImm(bpf.JumpEq, bpf.R0, 0, "conns")
+	a.StoreImm(bpf.SizeW, bpf.R10, ports, 0)
+
+	// Allow the packets of recently allowed connections, in both directions.
+	a.Label("conns")
+	copyField(bpf.SizeW, connKey, saddr)
+	copyField(bpf.SizeW, connKey+4, daddr)
+	copyField(bpf.SizeW, connKey+8, ports)
+	a.Store(bpf.SizeB, bpf.R10, connKey+12, bpf.R9)
+	a.StoreImm(bpf.SizeB, bpf.R10, connKey+13, int32(direction))
+
+	copyField(bpf.SizeW, reverseKey, daddr)
+	copyField(bpf.SizeW, reverseKey+4, saddr)
+	copyField(bpf.SizeH, reverseKey+8, dport)
+	copyField(bpf.SizeH, reverseKey+10, ports)
+	a.Store(bpf.SizeB, bpf.R10, reverseKey+12, bpf.R9)
+	a.StoreImm(bpf.SizeB, bpf.R10, reverseKey+13, int32(direction^1))
+
+	for _, keyOff := range []int32{connKey, reverseKey} {
+		lookup(dp.conns, keyOff)
+		a.JumpImm(bpf.JumpNe, bpf.R0, 0, "established")
+	}
+
+	// Pass the packets of pods whose policies aren't enforced here.
+	lookup(dp.endpoints, int32(localAddr))
+	a.JumpImm(bpf.JumpEq, bpf.R0, 0, "pass")
+	a.Load(bpf.SizeW, bpf.R7, bpf.R0, 4)
+	a.JumpImm(bpf.JumpEq, bpf.R7, 0, "pass")
+
+	a.Store(bpf.SizeW, bpf.R10, policyKey, bpf.R7)
+	a.StoreImm(bpf.SizeW, bpf.R10, policyKey+4, int32(direction))
+	lookup(dp.isolation, policyKey)
+	a.JumpImm(bpf.JumpEq, bpf.R0, 0, "allow")
+
+	// Remote addresses that aren't pods have no identity.
+	a.AluImm(bpf.OpMov, bpf.R8, 0)
+	lookup(dp.endpoints, int32(remoteAddr))
+	a.JumpImm(bpf.JumpEq, bpf.R0, 0, "policies")
+	a.Load(bpf.SizeW, bpf.R8, bpf.R0, 0)
+
+	a.Label("policies")
+	a.Store(bpf.SizeW, bpf.R10, policyKey, bpf.R7)
+	a.StoreImm(bpf.SizeB, bpf.R10, policyKey+11, int32(direction))
+	a.StoreImm(bpf.SizeW, bpf.R10, cidrKey, ebpfCIDRKeyPrefixBits+ebpfCIDRKeyAddressBits)
+	a.Store(bpf.SizeW, bpf.R10, cidrKey+4, bpf.R7)
+	a.StoreImm(bpf.SizeB, bpf.R10, cidrKey+8, int32(direction))
+	copyField(bpf.SizeW, cidrKey+12, remoteAddr)
+
+	// Rules may allow all ports, and all protocols.
+	for _, match := range []struct {
+		protocol bool
+		port     bool
+	}{
+		{true, true},
+		{true, false},
+		{false, true},
+		{false, false},
+	} {
+		if match.protocol {
+			a.Store(bpf.SizeB, bpf.R10, policyKey+10, bpf.R9)
+			a.Store(bpf.SizeB, bpf.R10, cidrKey+9, bpf.R9)
+		} else {
+			a.StoreImm(bpf.SizeB, bpf.R10, policyKey+10, 0)
+			a.StoreImm(bpf.SizeB, bpf.R10, cidrKey+9, 0)
+		}
+
+		if match.port {
+			copyField(bpf.SizeH, policyKey+8, dport)
+			copyField(bpf.SizeH, cidrKey+10, dport)
+		} else {
+			a.StoreImm(bpf.SizeH, bpf.R10, policyKey+8, 0)
+			a.StoreImm(bpf.SizeH, bpf.R10, cidrKey+10, 0)
+		}
+
+		a.Store(bpf.SizeW, bpf.R10, policyKey+4, bpf.R8)
+		lookup(dp.policies, policyKey)
+		a.JumpImm(bpf.JumpNe, bpf.R0, 0, "allow")
+
+		a.StoreImm(bpf.SizeW, bpf.R10, policyKey+4, -1)
+		lookup(dp.policies, policyKey)
+		a.JumpImm(bpf.JumpNe, bpf.R0, 0, "allow")
+
+		lookup(dp.cidrs, cidrKey)
+		a.JumpImm(bpf.JumpNe, bpf.R0, 0, "allow")
+	}
+
+	a.AluImm(bpf.OpMov, bpf.R0, tcActShot)
+	a.Exit()
+
+	// Refresh the timestamp of the connection.
+	a.Label("established")
+	a.AluReg(bpf.OpMov, bpf.R7, bpf.R0)
+	a.Call(bpf.FuncKtimeGetNs)
+	a.Store(bpf.SizeDW, bpf.R7, 0, bpf.R0)
+	a.Jump("pass")
+
+	a.Label("allow")
+	a.Call(bpf.FuncKtimeGetNs)
+	a.Store(bpf.SizeDW, bpf.R10, timestamp, bpf.R0)
+	a.LoadMap(bpf.R1, dp.conns.FD())
+	a.AluReg(bpf.OpMov, bpf.R2, bpf.R10)
+	a.AluImm(bpf.OpAdd, bpf.R2, connKey)
+	a.AluReg(bpf.OpMov, bpf.R3, bpf.R10)
+	a.AluImm(bpf.OpAdd, bpf.R3, timestamp)
+	a.AluImm(bpf.OpMov, bpf.R4, 0)
+	a.Call(bpf.FuncMapUpdateElem)
+
+	a.Label("pass")
+	a.AluImm(bpf.OpMov, bpf.R0, tcActOK)
+	a.Exit()
+
+	return a.Assemble()
+}
+
+// syncMap updates a map to hold the given entries only. It returns the number of entries changed.
+func syncMap(m *bpf.Map, entries map[string][]byte) (int, error) {
+	var changed int
+
+	keys, err := m.Keys()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		if _, exists := entries[string(key)]; exists {
+			continue
+		}
+
+		if err = m.Delete(key); err != nil && err != unix.ENOENT {
+			return changed, err
+		}
+		changed++
+	}
+
+	for key, value := range entries {
+		if current, err := m.Lookup([]byte(key)); err == nil && bytes.Equal(current, value) {
+			continue
+		}
+
+		if err = m.Update([]byte(key), value); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+
+	return changed, nil
+}
+
+// attach runs the programs on both hooks of the host veths of the pods, replacing the programs
+// a previous NPM instance attached.
+func (dp *ebpfDataplane) attach() error {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+
+	for _, iface := range interfaces {
+		if !strings.HasPrefix(iface.Name, ebpfHostVethPrefix) {
+			continue
+		}
+
+		if kind, err := netlink.GetLinkKind(iface.Name); err != nil || kind != netlink.LINK_TYPE_VETH {
+			continue
+		}
+
+		err = netlink.AddQdisc(&netlink.QdiscInfo{
+			Type:     netlink.QDISC_TYPE_CLSACT,
+			LinkName: iface.Name,
+			Handle:   netlink.MakeHandle(0xFFFF, 0),
+			Parent:   netlink.TC_H_CLSACT,
+		})
+		if err != nil && err != unix.EEXIST {
+			log.Printf("Error adding clsact qdisc to %s: %v", iface.Name, err)
+			continue
+		}
+
+		for _, hook := range []struct {
+			parent    uint32
+			direction int
+			name      string
+		}{
+			{netlink.TC_H_CLSACT_INGRESS, ebpfEgress, ebpfEgressFilterName},
+			{netlink.TC_H_CLSACT_EGRESS, ebpfIngress, ebpfIngressFilterName},
+		} {
+			err = netlink.ReplaceFilter(&netlink.BpfFilter{
+				FilterInfo: netlink.FilterInfo{
+					Type:     netlink.FILTER_TYPE_BPF,
+					LinkName: iface.Name,
+					Handle:   ebpfFilterHandle,
+					Parent:   hook.parent,
+					Priority: ebpfFilterPriority,
+				},
+				Fd:           dp.programs[hook.direction].FD(),
+				Name:         hook.name,
+				DirectAction: true,
+			})
+			if err != nil {
+				log.Printf("Error attaching %s program to %s: %v", hook.name, iface.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// expireConns removes the connections idle for longer than the timeout.
+func (dp *ebpfDataplane) expireConns() error {
+	var now unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); err != nil {
+		return err
+	}
+
+	keys, err := dp.conns.Keys()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		value, err := dp.conns.Lookup(key)
+		if err != nil {
+			continue
+		}
+
+		if time.Duration(now.Nano()-int64(bpf.NativeEndian().Uint64(value))) > ebpfConnIdleTimeout {
+			dp.conns.Delete(key)
+		}
+	}
+
+	return nil
+}
+
+// getHostIPs returns the IP addresses of the node.
+func getHostIPs() (map[string]bool, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	hostIPs := make(map[string]bool)
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			hostIPs[ipNet.IP.String()] = true
+		}
+	}
+
+	return hostIPs, nil
+}
+
+// syncEbpf applies the network policies to the eBPF maps on any change to pods, namespaces or network policies.
+func (npMgr *NetworkPolicyManager) syncEbpf(eventMsg string) error {
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	_, err := npMgr.programEbpf()
+	if reportErr := npMgr.UpdateAndSendReport(err, eventMsg); reportErr != nil {
+		log.Printf("Error sending NPM telemetry report")
+	}
+
+	return err
+}
+
+// programEbpf updates the eBPF maps to enforce the network policies on the local pods, and attaches
+// the programs to new host veths. It returns the number of map entries changed.
+// This function should only be called when npMgr is locked.
+func (npMgr *NetworkPolicyManager) programEbpf() (int, error) {
+	var err error
+
+	if npMgr.ebpf == nil {
+		if npMgr.ebpf, err = newEbpfDataplane(); err != nil {
+			log.Printf("Error creating the eBPF dataplane: %v", err)
+			return 0, err
+		}
+	}
+
+	state, err := npMgr.getPolicyState()
+	if err != nil {
+		log.Printf("Error listing cluster objects: %v", err)
+		return 0, err
+	}
+
+	npMgr.clusterState.PodCount = len(state.pods)
+	npMgr.clusterState.NsCount = len(state.namespaces)
+	npMgr.clusterState.NwPolicyCount = len(state.policies)
+	metrics.NumPolicies.Set(len(state.policies))
+
+	var portRangeCount int
+	for _, npObj := range state.policies {
+		if hasPortRanges(npObj) {
+			portRangeCount++
+		}
+	}
+	npMgr.reportUnsupportedPolicies("network policies with port ranges", portRangeCount)
+
+	hostIPs, err := getHostIPs()
+	if err != nil {
+		log.Printf("Error listing host IP addresses: %v", err)
+		return 0, err
+	}
+
+	dp := npMgr.ebpf
+	maps := compileEbpfMaps(state, hostIPs, dp.identities, dp.endpointIDs)
+
+	// Allowed traffic is added before pods are isolated, so that they don't lose connectivity in between.
+	var changed int
+	for _, m := range []struct {
+		m       *bpf.Map
+		entries map[string][]byte
+	}{
+		{dp.policies, maps.policies},
+		{dp.cidrs, maps.cidrs},
+		{dp.endpoints, maps.endpoints},
+		{dp.isolation, maps.isolation},
+	} {
+		n, syncErr := syncMap(m.m, m.entries)
+		if syncErr != nil {
+			log.Printf("Error updating eBPF map: %v", syncErr)
+			err = syncErr
+		}
+		changed += n
+	}
+
+	if attachErr := dp.attach(); attachErr != nil {
+		log.Printf("Error attaching eBPF programs: %v", attachErr)
+		err = attachErr
+	}
+
+	return changed, err
+}
+
+// reconcileEbpfDataplane programs the eBPF maps of the initial cluster state, then removes the iptables rules
+// and ipsets an NPM instance running the iptables dataplane left.
+func (npMgr *NetworkPolicyManager) reconcileEbpfDataplane() {
+	for _, q := range []*workQueue{npMgr.podQueue, npMgr.nsQueue, npMgr.npQueue} {
+		drainQueue(q, npMgr.syncEbpf)
+	}
+
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+	exists, err := allNs.iptMgr.Exists(&iptm.IptEntry{
+		Chain: util.IptablesForwardChain,
+		Specs: []string{util.IptablesJumpFlag, util.IptablesAzureChain},
+	})
+	if err != nil {
+		log.Printf("Error checking for existing azure-npm chains: %v", err)
+	}
+
+	if exists {
+		log.Printf("Removing azure-npm chains of the iptables dataplane")
+		if err = allNs.iptMgr.UninitNpmChains(); err != nil {
+			log.Printf("Error removing azure-npm chains: %v", err)
+		}
+	}
+
+	removed, err := allNs.ipsMgr.RemoveStale()
+	if err != nil {
+		log.Printf("Error removing stale ipsets: %v", err)
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d stale ipsets", removed)
+	}
+}
+
+// verifyEbpfDataplane repairs the eBPF map entries that drifted from the network policies, attaches the programs
+// to host veths missing them, and expires idle connections. It returns the number of repaired entries.
+// This function should only be called when npMgr is locked.
+func (npMgr *NetworkPolicyManager) verifyEbpfDataplane() (int, error) {
+	drift, err := npMgr.programEbpf()
+	if err != nil {
+		return drift, err
+	}
+
+	return drift, npMgr.ebpf.expireConns()
+}
@@ -0,0 +1,188 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+// +build linux
+
+package npm
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// newEbpfTestState returns a backend pod allowing ingress from a frontend pod on TCP port 8080 only,
+// and isolated for egress.
+func newEbpfTestState() *policyState {
+	tcp := corev1.ProtocolTCP
+	port := intstr.FromInt(8080)
+
+	return &policyState{
+		pods: []*corev1.Pod{
+			newTestPod("test", "backend", "10.240.0.4", map[string]string{"app": "backend"}),
+			newTestPod("test", "frontend", "10.240.0.5", map[string]string{"app": "frontend"}),
+			newTestPod("other", "frontend", "10.240.0.6", map[string]string{"app": "frontend"}),
+		},
+		policies: []*networkingv1.NetworkPolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "test",
+					Name:      "allow-frontend",
+				},
+				Spec: networkingv1.NetworkPolicySpec{
+					PodSelector: metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "backend"},
+					},
+					Ingress: []networkingv1.NetworkPolicyIngressRule{
+						{
+							Ports: []networkingv1.NetworkPolicyPort{
+								{Protocol: &tcp, Port: &port},
+							},
+							From: []networkingv1.NetworkPolicyPeer{
+								{
+									PodSelector: &metav1.LabelSelector{
+										MatchLabels: map[string]string{"app": "frontend"},
+									},
+								},
+							},
+						},
+					},
+					PolicyTypes: []networkingv1.PolicyType{
+						networkingv1.PolicyTypeIngress,
+						networkingv1.PolicyTypeEgress,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestIDAllocator(t *testing.T) {
+	a := newIDAllocator()
+
+	ids := a.allocate(map[string]bool{"a": true, "b": true})
+	if ids["a"] != 1 || ids["b"] != 2 {
+		t.Errorf("Unexpected IDs %v", ids)
+	}
+
+	// IDs are kept, and released IDs reused.
+	ids = a.allocate(map[string]bool{"b": true, "c": true})
+	if len(ids) != 2 || ids["b"] != 2 || ids["c"] != 1 {
+		t.Errorf("Unexpected IDs %v", ids)
+	}
+}
+
+func TestCompileEbpfMaps(t *testing.T) {
+	maps := compileEbpfMaps(newEbpfTestState(), map[string]bool{"10.0.0.1": true}, newIDAllocator(), newIDAllocator())
+
+	// All pods have an identity, and all three are local enforced endpoints.
+	if len(maps.endpoints) != 3 {
+		t.Errorf("Unexpected endpoints %v", maps.endpoints)
+	}
+
+	// Pods with the same labels in different namespaces have different identities.
+	frontend := maps.endpoints[string(net.ParseIP("10.240.0.5").To4())]
+	other := maps.endpoints[string(net.ParseIP("10.240.0.6").To4())]
+	if string(frontend[0:4]) == string(other[0:4]) {
+		t.Errorf("Pods of different namespaces share identity %v", frontend[0:4])
+	}
+
+	// Only the backend is isolated, in both directions.
+	if len(maps.isolation) != 2 {
+		t.Errorf("Unexpected isolation %v", maps.isolation)
+	}
+
+	// The frontend identity is allowed on port 8080.
+	if len(maps.policies) != 1 {
+		t.Errorf("Unexpected policies %v", maps.policies)
+	}
+
+	// The host is allowed by address.
+	if len(maps.cidrs) != 1 {
+		t.Errorf("Unexpected CIDRs %v", maps.cidrs)
+	}
+
+	// Pods on other hosts aren't enforced here.
+	maps = compileEbpfMaps(newEbpfTestState(), map[string]bool{"10.0.0.2": true}, newIDAllocator(), newIDAllocator())
+	if len(maps.endpoints) != 3 || len(maps.isolation) != 0 || len(maps.policies) != 0 {
+		t.Errorf("Unexpected maps for remote pods %+v", maps)
+	}
+}
+
+// newEbpfTestPacket returns a TCP packet with an Ethernet header.
+func newEbpfTestPacket(src string, dst string, sport uint16, dport uint16) []byte {
+	packet := make([]byte, 64)
+	binary.BigEndian.PutUint16(packet[12:14], 0x0800)
+
+	ip := packet[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], 50)
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:16], net.ParseIP(src).To4())
+	copy(ip[16:20], net.ParseIP(dst).To4())
+
+	binary.BigEndian.PutUint16(ip[20:22], sport)
+	binary.BigEndian.PutUint16(ip[22:24], dport)
+
+	return packet
+}
+
+func TestEbpfProgram(t *testing.T) {
+	dp, err := newEbpfDataplane()
+	if err != nil {
+		t.Fatalf("newEbpfDataplane failed: %v", err)
+	}
+	defer dp.close()
+
+	maps := compileEbpfMaps(newEbpfTestState(), map[string]bool{"10.0.0.1": true}, dp.identities, dp.endpointIDs)
+	syncMap(dp.policies, maps.policies)
+	syncMap(dp.cidrs, maps.cidrs)
+	syncMap(dp.endpoints, maps.endpoints)
+	syncMap(dp.isolation, maps.isolation)
+
+	tests := []struct {
+		name      string
+		direction int
+		packet    []byte
+		result    uint32
+	}{
+		{"allowed ingress", ebpfIngress, newEbpfTestPacket("10.240.0.5", "10.240.0.4", 40000, 8080), tcActOK},
+		{"reply of allowed ingress", ebpfEgress, newEbpfTestPacket("10.240.0.4", "10.240.0.5", 8080, 40000), tcActOK},
+		{"ingress on another port", ebpfIngress, newEbpfTestPacket("10.240.0.5", "10.240.0.4", 40000, 9090), tcActShot},
+		{"ingress from another namespace", ebpfIngress, newEbpfTestPacket("10.240.0.6", "10.240.0.4", 40000, 8080), tcActShot},
+		{"ingress from the host", ebpfIngress, newEbpfTestPacket("10.0.0.1", "10.240.0.4", 40000, 9090), tcActOK},
+		{"isolated egress", ebpfEgress, newEbpfTestPacket("10.240.0.4", "10.240.0.6", 40000, 80), tcActShot},
+		{"ingress of a pod without policies", ebpfIngress, newEbpfTestPacket("10.240.0.6", "10.240.0.5", 40000, 80), tcActOK},
+	}
+
+	for _, test := range tests {
+		result, err := dp.programs[test.direction].TestRun(test.packet)
+		if err != nil {
+			t.Fatalf("TestRun failed: %v", err)
+		}
+
+		if result != test.result {
+			t.Errorf("Unexpected result %d for %s, expected %d", result, test.name, test.result)
+		}
+	}
+
+	// Maps already holding the entries aren't changed again.
+	if changed, err := syncMap(dp.policies, maps.policies); changed != 0 || err != nil {
+		t.Errorf("syncMap changed %d entries, err:%v", changed, err)
+	}
+
+	// The three allowed connections are recent, so they aren't expired.
+	if err := dp.expireConns(); err != nil {
+		t.Errorf("expireConns failed: %v", err)
+	}
+
+	if keys, err := dp.conns.Keys(); len(keys) != 3 || err != nil {
+		t.Errorf("Unexpected connections %v, err:%v", keys, err)
+	}
+}
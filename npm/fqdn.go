@@ -0,0 +1,146 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+const (
+	// FQDNEgressAnnotation lists the domain names, separated by commas, the pods a network policy selects may send
+	// traffic to when the policy isolates their egress. Wildcard names aren't supported.
+	//
+	// NPM doesn't see the DNS answers pods receive. It resolves the names itself on every sync, through the DNS
+	// servers of the node, and allows the addresses its answers list. Pods should resolve the names through the
+	// same servers. Traffic to an address a pod got from an answer NPM didn't see is denied until one of its own
+	// answers lists the address, e.g. for names whose servers rotate through more addresses than an answer lists,
+	// or vary their answers by client. Addresses seen once stay allowed for the retention period, so names whose
+	// answers rotate through a stable pool are covered once NPM saw all of it.
+	FQDNEgressAnnotation = "netpol.azure.com/egress-fqdns"
+
+	// Addresses stay allowed for this long after the last answer listed them, as pods may have cached them.
+	fqdnAddressRetention = 10 * time.Minute
+)
+
+// getPolicyFQDNs returns the domain names a network policy allows egress traffic to.
+func getPolicyFQDNs(npObj *networkingv1.NetworkPolicy) []string {
+	var fqdns []string
+
+	for _, fqdn := range strings.Split(npObj.ObjectMeta.Annotations[FQDNEgressAnnotation], ",") {
+		fqdn = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(fqdn)), ".")
+		if len(fqdn) == 0 {
+			continue
+		}
+
+		if strings.Contains(fqdn, "*") {
+			log.Printf("Ignoring wildcard domain name %s of network policy %s", fqdn, getObjectKey(npObj.ObjectMeta))
+			continue
+		}
+
+		fqdns = append(fqdns, fqdn)
+	}
+
+	return util.UniqueStrSlice(fqdns)
+}
+
+// parseFQDNEgress returns the ipsets of the domain names a network policy allows egress traffic to,
+// and the iptables entries allowing traffic from its target sets to them on any port.
+func parseFQDNEgress(ns string, targetSets []string, fqdns []string) ([]string, []*iptm.IptEntry) {
+	var (
+		fqdnSets []string
+		entries  []*iptm.IptEntry
+	)
+
+	if len(fqdns) == 0 {
+		return nil, nil
+	}
+
+	if len(targetSets) == 0 {
+		targetSets = append(targetSets, ns)
+	}
+
+	for _, fqdn := range fqdns {
+		fqdnSet := util.FQDNIPSetPrefix + fqdn
+		hashedFQDNSetName := util.GetHashedName(fqdnSet)
+		fqdnSets = append(fqdnSets, fqdnSet)
+
+		for _, targetSet := range targetSets {
+			entry := &iptm.IptEntry{
+				Name:       fqdnSet,
+				HashedName: hashedFQDNSetName,
+				Chain:      util.IptablesAzureEgressPortChain,
+				Specs: []string{
+					util.IptablesMatchFlag,
+					util.IptablesSetFlag,
+					util.IptablesMatchSetFlag,
+					util.GetHashedName(targetSet),
+					util.IptablesSrcFlag,
+					util.IptablesMatchFlag,
+					util.IptablesSetFlag,
+					util.IptablesMatchSetFlag,
+					hashedFQDNSetName,
+					util.IptablesDstFlag,
+					util.IptablesJumpFlag,
+					util.IptablesMarkTarget,
+					util.IptablesSetMarkFlag,
+					util.IptablesAzureEgressMarkHex,
+				},
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	return fqdnSets, entries
+}
+
+// updateFQDNAddresses records the addresses in the DNS answers of the domain names, and expires the addresses
+// no answer listed during the retention period. Names without an answer, e.g. on a failed lookup, keep their addresses
+// until they expire, and names that are no longer answered for are dropped. It returns the added and removed addresses,
+// by domain name.
+func updateFQDNAddresses(addresses map[string]map[string]time.Time, answers map[string][]string,
+	now time.Time, retention time.Duration) (map[string][]string, map[string][]string) {
+	added := make(map[string][]string)
+	removed := make(map[string][]string)
+
+	for fqdn, answer := range answers {
+		for _, address := range answer {
+			if _, exists := addresses[fqdn]; !exists {
+				addresses[fqdn] = make(map[string]time.Time)
+			}
+
+			if _, exists := addresses[fqdn][address]; !exists {
+				added[fqdn] = append(added[fqdn], address)
+			}
+			addresses[fqdn][address] = now
+		}
+	}
+
+	for fqdn, seen := range addresses {
+		_, tracked := answers[fqdn]
+		for address, lastSeen := range seen {
+			if !tracked || now.Sub(lastSeen) > retention {
+				removed[fqdn] = append(removed[fqdn], address)
+				delete(seen, address)
+			}
+		}
+
+		if len(seen) == 0 {
+			delete(addresses, fqdn)
+		}
+	}
+
+	for _, diff := range []map[string][]string{added, removed} {
+		for fqdn := range diff {
+			sort.Strings(diff[fqdn])
+		}
+	}
+
+	return added, removed
+}
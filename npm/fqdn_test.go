@@ -0,0 +1,127 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseFQDNEgress(t *testing.T) {
+	npObj := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test",
+			Name:      "allow-api",
+			Annotations: map[string]string{
+				FQDNEgressAnnotation: "API.contoso.com., *.contoso.com,,api.contoso.com",
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "frontend"},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+		},
+	}
+
+	fqdns := getPolicyFQDNs(npObj)
+	if !reflect.DeepEqual(fqdns, []string{"api.contoso.com"}) {
+		t.Fatalf("Unexpected domain names %v", fqdns)
+	}
+
+	podSets, _, _, entries := parsePolicy(npObj)
+
+	fqdnSet := util.FQDNIPSetPrefix + "api.contoso.com"
+	var created, allowed bool
+	for _, set := range podSets {
+		created = created || set == fqdnSet
+	}
+
+	if !created {
+		t.Errorf("Domain name ipset missing from %v", podSets)
+	}
+
+	for _, entry := range entries {
+		rule := parseDebugRule(entry.Chain, entry.Specs)
+		if entry.Name == fqdnSet && entry.Chain == util.IptablesAzureEgressPortChain && rule.setMark == util.IptablesAzureEgressMarkHex {
+			allowed = true
+		}
+	}
+
+	if !allowed {
+		t.Errorf("Egress to domain name isn't allowed by %+v", entries)
+	}
+}
+
+func TestUpdateFQDNAddresses(t *testing.T) {
+	addresses := make(map[string]map[string]time.Time)
+	now := time.Now()
+
+	added, removed := updateFQDNAddresses(addresses, map[string][]string{
+		"api.contoso.com": {"20.0.0.2", "20.0.0.1"},
+	}, now, time.Minute)
+	if !reflect.DeepEqual(added, map[string][]string{"api.contoso.com": {"20.0.0.1", "20.0.0.2"}}) || len(removed) != 0 {
+		t.Errorf("Unexpected update, added %v removed %v", added, removed)
+	}
+
+	// Addresses missing from later answers, or of failed lookups, are kept until they expire.
+	added, removed = updateFQDNAddresses(addresses, map[string][]string{
+		"api.contoso.com": {"20.0.0.1"},
+	}, now.Add(30*time.Second), time.Minute)
+	if len(added) != 0 || len(removed) != 0 {
+		t.Errorf("Unexpected update, added %v removed %v", added, removed)
+	}
+
+	added, removed = updateFQDNAddresses(addresses, map[string][]string{
+		"api.contoso.com": nil,
+	}, now.Add(90*time.Second), time.Minute)
+	if len(added) != 0 || !reflect.DeepEqual(removed, map[string][]string{"api.contoso.com": {"20.0.0.2"}}) {
+		t.Errorf("Unexpected update, added %v removed %v", added, removed)
+	}
+
+	// Names no policy refers to any more are dropped.
+	added, removed = updateFQDNAddresses(addresses, map[string][]string{}, now.Add(100*time.Second), time.Minute)
+	if len(added) != 0 || !reflect.DeepEqual(removed, map[string][]string{"api.contoso.com": {"20.0.0.1"}}) || len(addresses) != 0 {
+		t.Errorf("Unexpected update, added %v removed %v", added, removed)
+	}
+}
+
+func TestUpdateFQDNAddressesRotatingAnswers(t *testing.T) {
+	addresses := make(map[string]map[string]time.Time)
+	now := time.Now()
+
+	// Each answer lists two of the three addresses of the name, rotating every lookup.
+	answers := [][]string{
+		{"20.0.0.1", "20.0.0.2"},
+		{"20.0.0.2", "20.0.0.3"},
+		{"20.0.0.3", "20.0.0.1"},
+		{"20.0.0.1", "20.0.0.2"},
+	}
+
+	var allAdded []string
+	for i, answer := range answers {
+		added, removed := updateFQDNAddresses(addresses, map[string][]string{"api.contoso.com": answer},
+			now.Add(time.Duration(i)*10*time.Second), time.Minute)
+		if len(removed) != 0 {
+			t.Errorf("Lookup %d removed %v, expected rotated addresses to be kept", i, removed)
+		}
+		allAdded = append(allAdded, added["api.contoso.com"]...)
+	}
+
+	// Every address of the pool is added once, when an answer first lists it.
+	if !reflect.DeepEqual(allAdded, []string{"20.0.0.1", "20.0.0.2", "20.0.0.3"}) || len(addresses["api.contoso.com"]) != 3 {
+		t.Errorf("Rotating answers added %v, tracked %v, expected the whole pool", allAdded, addresses)
+	}
+
+	// Addresses that leave the rotation expire once the retention period passes since an answer last listed them.
+	added, removed := updateFQDNAddresses(addresses, map[string][]string{"api.contoso.com": {"20.0.0.1", "20.0.0.2"}},
+		now.Add(85*time.Second), time.Minute)
+	if len(added) != 0 || !reflect.DeepEqual(removed, map[string][]string{"api.contoso.com": {"20.0.0.3"}}) {
+		t.Errorf("Unexpected update, added %v removed %v", added, removed)
+	}
+}
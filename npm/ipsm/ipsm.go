@@ -3,15 +3,51 @@
 package ipsm
 
 import (
+	"bytes"
+	"context"
 	"os"
-	"os/exec"
 	"strings"
-	"syscall"
+	"time"
 
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
 	"github.com/Azure/azure-container-networking/npm/util"
+	"github.com/Azure/azure-container-networking/platform"
+	"github.com/Azure/azure-container-networking/retry"
 )
 
+// ipset commands that time out or find the kernel busy are retried. Commands are run with the
+// exist flag, so running them again after a partial failure is harmless.
+var runRetryPolicy = retry.Policy{
+	MaxAttempts:  3,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     time.Second,
+	Jitter:       0.2,
+	IsRetryable:  isTransientIpsetError,
+}
+
+// lazySets defers creating the label and named port ipsets in the kernel until a network policy refers to them.
+var lazySets bool
+
+// SetLazySets sets whether ipsets no network policy refers to are only tracked in memory.
+// Namespace sets are always created, since the namespace lists refer to them.
+func SetLazySets(enable bool) {
+	lazySets = enable
+}
+
+// dualStack mirrors every set and list in an IPv6 twin holding the IPv6 members, for ip6tables rules to match.
+var dualStack bool
+
+// SetDualStack sets whether ipsets have IPv6 twins, for dual-stack clusters.
+func SetDualStack(enable bool) {
+	dualStack = enable
+}
+
+// IsDualStack checks if ipsets have IPv6 twins.
+func IsDualStack() bool {
+	return dualStack
+}
+
 type ipsEntry struct {
 	operationFlag string
 	name          string
@@ -21,8 +57,11 @@ type ipsEntry struct {
 
 // IpsetManager stores ipset states.
 type IpsetManager struct {
-	listMap map[string]*Ipset //tracks all set lists.
-	setMap  map[string]*Ipset //label -> []ip
+	listMap    map[string]*Ipset //tracks all set lists.
+	setMap     map[string]*Ipset //label -> []ip
+	isBatching bool
+	batch      []*ipsEntry                // entries queued for the next ipset restore.
+	liveSets   map[string]map[string]bool // sets in the kernel while taking them over, by hashed name.
 }
 
 // Ipset represents one ipset entry.
@@ -30,6 +69,7 @@ type Ipset struct {
 	name       string
 	elements   []string
 	referCount int
+	isPending  bool // tracked in memory only, not yet created in the kernel.
 }
 
 // NewIpset creates a new instance for Ipset object.
@@ -71,6 +111,44 @@ func isNsSet(setName string) bool {
 	return !strings.Contains(setName, "-") && !strings.Contains(setName, ":")
 }
 
+func isNamedPortSet(setName string) bool {
+	return strings.HasPrefix(setName, util.NamedPortIPSetPrefix)
+}
+
+// isIPv6Member checks if a set member, i.e. an address, a CIDR or an ip,protocol:port pair, is an IPv6 one.
+func isIPv6Member(member string) bool {
+	fields := strings.Fields(member)
+	if len(fields) == 0 {
+		return false
+	}
+
+	return strings.Contains(strings.SplitN(fields[0], ",", 2)[0], ":")
+}
+
+// getMemberSet returns the hashed name of the set holding a member: the IPv6 twin for IPv6 members in dual-stack clusters.
+func getMemberSet(setName string, member string) string {
+	if dualStack && isIPv6Member(member) {
+		return util.GetHashedIPv6Name(setName)
+	}
+
+	return util.GetHashedName(setName)
+}
+
+// runIPv6 runs the operation of an entry on the IPv6 twin of a set or list, with the given spec.
+// It does nothing unless the cluster is dual-stack.
+func (ipsMgr *IpsetManager) runIPv6(entry *ipsEntry, name string, spec string) (int, error) {
+	if !dualStack {
+		return 0, nil
+	}
+
+	return ipsMgr.Run(&ipsEntry{
+		name:          entry.name,
+		operationFlag: entry.operationFlag,
+		set:           util.GetHashedIPv6Name(name),
+		spec:          spec,
+	})
+}
+
 // CreateList creates an ipset list. npm maintains one setlist per namespace label.
 func (ipsMgr *IpsetManager) CreateList(listName string) error {
 	if _, exists := ipsMgr.listMap[listName]; exists {
@@ -89,7 +167,13 @@ func (ipsMgr *IpsetManager) CreateList(listName string) error {
 		return err
 	}
 
+	if _, err := ipsMgr.runIPv6(entry, listName, entry.spec); err != nil {
+		log.Printf("Error creating IPv6 ipset list %s.\n", listName)
+		return err
+	}
+
 	ipsMgr.listMap[listName] = NewIpset(listName)
+	metrics.NumIpsets.Inc()
 
 	return nil
 }
@@ -113,7 +197,13 @@ func (ipsMgr *IpsetManager) DeleteList(listName string) error {
 		return err
 	}
 
+	if errCode, err := ipsMgr.runIPv6(entry, listName, ""); err != nil && errCode != 1 {
+		log.Printf("Error deleting IPv6 ipset list %s\n", listName)
+		return err
+	}
+
 	delete(ipsMgr.listMap, listName)
+	metrics.NumIpsets.Dec()
 
 	return nil
 }
@@ -139,6 +229,11 @@ func (ipsMgr *IpsetManager) AddToList(listName string, setName string) error {
 		return err
 	}
 
+	if _, err := ipsMgr.runIPv6(entry, listName, util.GetHashedIPv6Name(setName)); err != nil {
+		log.Printf("Error creating IPv6 ipset rules. rule: %+v", entry)
+		return err
+	}
+
 	ipsMgr.listMap[listName].elements = append(ipsMgr.listMap[listName].elements, setName)
 
 	return nil
@@ -164,12 +259,17 @@ func (ipsMgr *IpsetManager) DeleteFromList(listName string, setName string) erro
 		spec:          hashedSetName,
 	}
 	errCode, err := ipsMgr.Run(entry)
-	if errCode > 1 && err != nil {
+	if errCode != 1 && err != nil {
 		log.Printf("Error deleting ipset entry.\n")
 		log.Printf("%+v\n", entry)
 		return err
 	}
 
+	if errCode, err := ipsMgr.runIPv6(entry, listName, util.GetHashedIPv6Name(setName)); errCode != 1 && err != nil {
+		log.Printf("Error deleting IPv6 ipset entry.\n")
+		return err
+	}
+
 	if len(ipsMgr.listMap[listName].elements) == 0 {
 		if err := ipsMgr.DeleteList(listName); err != nil {
 			log.Printf("Error deleting ipset list %s.\n", listName)
@@ -180,9 +280,10 @@ func (ipsMgr *IpsetManager) DeleteFromList(listName string, setName string) erro
 	return nil
 }
 
-// CreateSet creates an ipset.
+// CreateSet creates an ipset. The members of a pending set are added once it is created.
 func (ipsMgr *IpsetManager) CreateSet(setName string) error {
-	if _, exists := ipsMgr.setMap[setName]; exists {
+	set, exists := ipsMgr.setMap[setName]
+	if exists && !set.isPending {
 		return nil
 	}
 
@@ -193,13 +294,41 @@ func (ipsMgr *IpsetManager) CreateSet(setName string) error {
 		set:  util.GetHashedName(setName),
 		spec: util.IpsetNetHashFlag,
 	}
+	// Named port sets hold ip,protocol:port pairs of the pods exposing the port.
+	if isNamedPortSet(setName) {
+		entry.spec = util.IpsetIPPortHashFlag
+	}
 	log.Printf("Creating Set: %+v\n", entry)
 	if _, err := ipsMgr.Run(entry); err != nil {
 		log.Printf("Error creating ipset.\n")
 		return err
 	}
 
-	ipsMgr.setMap[setName] = NewIpset(setName)
+	if _, err := ipsMgr.runIPv6(entry, setName, entry.spec+" "+util.IpsetFamilyFlag+" "+util.IpsetInet6Flag); err != nil {
+		log.Printf("Error creating IPv6 ipset.\n")
+		return err
+	}
+
+	if !exists {
+		set = NewIpset(setName)
+		ipsMgr.setMap[setName] = set
+	}
+
+	for _, member := range set.elements {
+		entry := &ipsEntry{
+			operationFlag: util.IpsetAppendFlag,
+			set:           getMemberSet(setName, member),
+			spec:          member,
+		}
+		if _, err := ipsMgr.Run(entry); err != nil {
+			log.Printf("Error adding pending member to ipset.\n")
+			log.Printf("rule: %+v\n", entry)
+			return err
+		}
+	}
+
+	set.isPending = false
+	metrics.NumIpsets.Inc()
 
 	return nil
 }
@@ -215,6 +344,11 @@ func (ipsMgr *IpsetManager) DeleteSet(setName string) error {
 		return nil
 	}
 
+	if ipsMgr.setMap[setName].isPending {
+		delete(ipsMgr.setMap, setName)
+		return nil
+	}
+
 	entry := &ipsEntry{
 		operationFlag: util.IpsetDestroyFlag,
 		set:           util.GetHashedName(setName),
@@ -230,7 +364,13 @@ func (ipsMgr *IpsetManager) DeleteSet(setName string) error {
 		return err
 	}
 
+	if errCode, err := ipsMgr.runIPv6(entry, setName, ""); err != nil && errCode != 1 {
+		log.Printf("Error deleting IPv6 ipset %s\n", setName)
+		return err
+	}
+
 	delete(ipsMgr.setMap, setName)
+	metrics.NumIpsets.Dec()
 
 	return nil
 }
@@ -241,13 +381,23 @@ func (ipsMgr *IpsetManager) AddToSet(setName string, ip string) error {
 		return nil
 	}
 
-	if err := ipsMgr.CreateSet(setName); err != nil {
-		return err
+	if _, exists := ipsMgr.setMap[setName]; !exists {
+		if lazySets && !isNsSet(setName) {
+			ipsMgr.setMap[setName] = &Ipset{name: setName, isPending: true}
+		} else if err := ipsMgr.CreateSet(setName); err != nil {
+			return err
+		}
+	}
+
+	set := ipsMgr.setMap[setName]
+	if set.isPending {
+		set.elements = append(set.elements, ip)
+		return nil
 	}
 
 	entry := &ipsEntry{
 		operationFlag: util.IpsetAppendFlag,
-		set:           util.GetHashedName(setName),
+		set:           getMemberSet(setName, ip),
 		spec:          ip,
 	}
 
@@ -257,7 +407,7 @@ func (ipsMgr *IpsetManager) AddToSet(setName string, ip string) error {
 		return err
 	}
 
-	ipsMgr.setMap[setName].elements = append(ipsMgr.setMap[setName].elements, ip)
+	set.elements = append(set.elements, ip)
 
 	return nil
 }
@@ -275,9 +425,17 @@ func (ipsMgr *IpsetManager) DeleteFromSet(setName string, ip string) error {
 		}
 	}
 
+	// Pending sets with no members left are forgotten, so they don't grow with pod churn.
+	if ipsMgr.setMap[setName].isPending {
+		if len(ipsMgr.setMap[setName].elements) == 0 {
+			delete(ipsMgr.setMap, setName)
+		}
+		return nil
+	}
+
 	entry := &ipsEntry{
 		operationFlag: util.IpsetDeletionFlag,
-		set:           util.GetHashedName(setName),
+		set:           getMemberSet(setName, ip),
 		spec:          ip,
 	}
 	if _, err := ipsMgr.Run(entry); err != nil {
@@ -315,6 +473,177 @@ func (ipsMgr *IpsetManager) Clean() error {
 	return nil
 }
 
+// getLiveSets returns the members of the ipsets currently in the kernel, keyed by set name.
+func getLiveSets() (map[string]map[string]bool, error) {
+	cmdOut, err := platform.ExecuteCommandContext(context.Background(), nil, util.Ipset, util.IpsetSaveFlag)
+	if err != nil {
+		log.Printf("Error running ipset save: %v.\n", err)
+		return nil, err
+	}
+
+	sets := make(map[string]map[string]bool)
+	for _, line := range strings.Split(cmdOut.Stdout, "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "create":
+			sets[fields[1]] = make(map[string]bool)
+		case "add":
+			if len(fields) == 3 && sets[fields[1]] != nil {
+				sets[fields[1]][fields[2]] = true
+			}
+		}
+	}
+
+	return sets, nil
+}
+
+// Verify compares the ipsets in the kernel with the managed ones and repairs any difference.
+// It returns the number of repaired sets and members.
+func (ipsMgr *IpsetManager) Verify() (int, error) {
+	liveSets, err := getLiveSets()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		drift    int
+		firstErr error
+	)
+
+	repair := func(entry *ipsEntry) {
+		drift++
+		log.Printf("Repairing ipset drift: %+v\n", entry)
+		if _, err := ipsMgr.Run(entry); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	verify := func(hashedName string, members []string, spec string) {
+		liveMembers, exists := liveSets[hashedName]
+		if !exists {
+			repair(&ipsEntry{operationFlag: util.IpsetCreationFlag, set: hashedName, spec: spec})
+		}
+
+		expected := make(map[string]bool)
+		for _, member := range members {
+			expected[member] = true
+
+			if !liveMembers[member] {
+				repair(&ipsEntry{operationFlag: util.IpsetAppendFlag, set: hashedName, spec: member})
+			}
+		}
+
+		for member := range liveMembers {
+			if !expected[member] {
+				repair(&ipsEntry{operationFlag: util.IpsetDeletionFlag, set: hashedName, spec: member})
+			}
+		}
+	}
+
+	// Sets first, lists refer to them.
+	for name, set := range ipsMgr.setMap {
+		if set.isPending {
+			continue
+		}
+
+		spec := util.IpsetNetHashFlag
+		if isNamedPortSet(name) {
+			spec = util.IpsetIPPortHashFlag
+		}
+
+		var members, ipv6Members []string
+		for _, member := range set.elements {
+			if dualStack && isIPv6Member(member) {
+				ipv6Members = append(ipv6Members, member)
+			} else {
+				members = append(members, member)
+			}
+		}
+
+		verify(util.GetHashedName(name), members, spec)
+		if dualStack {
+			verify(util.GetHashedIPv6Name(name), ipv6Members, spec+" "+util.IpsetFamilyFlag+" "+util.IpsetInet6Flag)
+		}
+	}
+
+	for name, list := range ipsMgr.listMap {
+		var members, ipv6Members []string
+		for _, member := range list.elements {
+			members = append(members, util.GetHashedName(member))
+			ipv6Members = append(ipv6Members, util.GetHashedIPv6Name(member))
+		}
+
+		verify(util.GetHashedName(name), members, util.IpsetSetListFlag)
+		if dualStack {
+			verify(util.GetHashedIPv6Name(name), ipv6Members, util.IpsetSetListFlag)
+		}
+	}
+
+	return drift, firstErr
+}
+
+// RemoveStale destroys the azure-npm ipsets in the kernel that aren't managed, e.g. left behind by a previous NPM instance.
+// Sets still referenced by iptables rules are kept. It returns the number of destroyed sets.
+func (ipsMgr *IpsetManager) RemoveStale() (int, error) {
+	liveSets, err := getLiveSets()
+	if err != nil {
+		return 0, err
+	}
+
+	// Pending sets left in the kernel hold the members of a previous instance, they are stale too.
+	managed := make(map[string]bool)
+	for name, set := range ipsMgr.setMap {
+		if !set.isPending {
+			managed[util.GetHashedName(name)] = true
+			managed[util.GetHashedIPv6Name(name)] = dualStack
+		}
+	}
+
+	for name := range ipsMgr.listMap {
+		managed[util.GetHashedName(name)] = true
+		managed[util.GetHashedIPv6Name(name)] = dualStack
+	}
+
+	// IPv6 twins are stale too when the cluster is no longer dual-stack.
+	var stale []string
+	for hashedName := range liveSets {
+		isNpmSet := strings.HasPrefix(hashedName, util.AzureNpmPrefix) || strings.HasPrefix(hashedName, util.AzureNpmIPv6Prefix)
+		if isNpmSet && !managed[hashedName] {
+			stale = append(stale, hashedName)
+		}
+	}
+
+	// Flush all stale sets first, so that stale lists no longer refer to the sets being destroyed.
+	for _, hashedName := range stale {
+		if _, err := ipsMgr.Run(&ipsEntry{operationFlag: util.IpsetFlushFlag, set: hashedName}); err != nil {
+			log.Printf("Error flushing stale ipset %s\n", hashedName)
+			return 0, err
+		}
+	}
+
+	var removed int
+	for _, hashedName := range stale {
+		errCode, err := ipsMgr.Run(&ipsEntry{operationFlag: util.IpsetDestroyFlag, set: hashedName})
+		if err != nil {
+			if errCode == 1 {
+				log.Printf("Cannot delete stale ipset %s as it's being referred.\n", hashedName)
+				continue
+			}
+
+			log.Printf("Error deleting stale ipset %s\n", hashedName)
+			return removed, err
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
+
 // Destroy completely cleans ipset.
 func (ipsMgr *IpsetManager) Destroy() error {
 	entry := &ipsEntry{
@@ -334,23 +663,165 @@ func (ipsMgr *IpsetManager) Destroy() error {
 	return nil
 }
 
+// BeginTakeover inventories the ipsets left in the kernel by a previous NPM instance. Until EndTakeover,
+// creating the sets and adding the members already in the kernel runs no command, so only the difference is applied.
+func (ipsMgr *IpsetManager) BeginTakeover() error {
+	liveSets, err := getLiveSets()
+	if err != nil {
+		return err
+	}
+
+	ipsMgr.liveSets = liveSets
+
+	return nil
+}
+
+// EndTakeover ends the takeover, and repairs the taken over sets that differ from the managed ones,
+// e.g. holding the addresses of pods deleted while NPM was down. It returns the number of repaired sets and members.
+func (ipsMgr *IpsetManager) EndTakeover() (int, error) {
+	ipsMgr.liveSets = nil
+
+	return ipsMgr.Verify()
+}
+
+// isTakenOver checks if an entry creates a set or adds a member that is already in the kernel while taking it over.
+// The inventory is kept up to date with the entries deleting members and sets.
+func (ipsMgr *IpsetManager) isTakenOver(entry *ipsEntry) bool {
+	if ipsMgr.liveSets == nil {
+		return false
+	}
+
+	members, exists := ipsMgr.liveSets[entry.set]
+
+	switch entry.operationFlag {
+	case util.IpsetCreationFlag:
+		return exists
+	case util.IpsetAppendFlag:
+		return exists && members[entry.spec]
+	case util.IpsetDeletionFlag:
+		delete(members, entry.spec)
+	case util.IpsetDestroyFlag:
+		delete(ipsMgr.liveSets, entry.set)
+	}
+
+	return false
+}
+
+// BeginBatch queues the following ipset create, add and delete operations until CommitBatch is called.
+func (ipsMgr *IpsetManager) BeginBatch() {
+	ipsMgr.isBatching = true
+}
+
+// CommitBatch applies the queued ipset operations and ends the batch.
+func (ipsMgr *IpsetManager) CommitBatch() error {
+	ipsMgr.isBatching = false
+
+	return ipsMgr.flushBatch()
+}
+
+// isBatchable checks if an entry can be applied through ipset restore.
+func isBatchable(entry *ipsEntry) bool {
+	if len(entry.set) == 0 {
+		return false
+	}
+
+	return entry.operationFlag == util.IpsetCreationFlag ||
+		entry.operationFlag == util.IpsetAppendFlag ||
+		entry.operationFlag == util.IpsetDeletionFlag
+}
+
+// flushBatch applies the queued ipset operations in a single ipset restore transaction.
+// If the transaction fails, the operations are retried one by one so a bad entry doesn't block the others.
+func (ipsMgr *IpsetManager) flushBatch() error {
+	if len(ipsMgr.batch) == 0 {
+		return nil
+	}
+
+	batch := ipsMgr.batch
+	ipsMgr.batch = nil
+
+	var buf bytes.Buffer
+	for _, entry := range batch {
+		buf.WriteString(entry.operationFlag + " " + entry.set)
+		if len(entry.spec) > 0 {
+			buf.WriteString(" " + entry.spec)
+		}
+		buf.WriteString("\n")
+	}
+
+	metrics.IpsetExecCount.Inc()
+	_, err := platform.ExecuteCommandContext(context.Background(), &buf, util.Ipset, util.IpsetRestoreFlag, util.IpsetExistFlag)
+	if err == nil {
+		return nil
+	}
+
+	metrics.IpsetExecFailures.Inc()
+
+	log.Printf("Error restoring %d ipset entries: %v. Applying them one by one.\n", len(batch), err)
+
+	var firstErr error
+	for _, entry := range batch {
+		if _, err := ipsMgr.run(entry); err != nil {
+			log.Printf("Error applying ipset entry %+v: %v\n", entry, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
 // Run execute an ipset command to update ipset.
+// While batching, create, add and delete operations are queued and applied by CommitBatch.
 func (ipsMgr *IpsetManager) Run(entry *ipsEntry) (int, error) {
+	if ipsMgr.isTakenOver(entry) {
+		return 0, nil
+	}
+
+	if ipsMgr.isBatching {
+		if isBatchable(entry) {
+			ipsMgr.batch = append(ipsMgr.batch, entry)
+			return 0, nil
+		}
+
+		// Other operations may depend on the queued ones.
+		if err := ipsMgr.flushBatch(); err != nil {
+			log.Printf("Error applying queued ipset entries before %+v\n", entry)
+		}
+	}
+
+	return ipsMgr.run(entry)
+}
+
+// run executes an ipset command.
+func (ipsMgr *IpsetManager) run(entry *ipsEntry) (int, error) {
 	cmdName := util.Ipset
 	cmdArgs := []string{entry.operationFlag, util.IpsetExistFlag}
 	if len(entry.set) > 0 {
 		cmdArgs = append(cmdArgs, entry.set)
 	}
 	if len(entry.spec) > 0 {
-		cmdArgs = append(cmdArgs, entry.spec)
+		// Specs may carry options after the element, e.g. nomatch.
+		cmdArgs = append(cmdArgs, strings.Fields(entry.spec)...)
 	}
 
-	cmdOut, err := exec.Command(cmdName, cmdArgs...).Output()
-	log.Printf("%s\n", string(cmdOut))
+	var cmdOut *platform.CommandOutput
+	err := retry.Do(context.Background(), &runRetryPolicy, func() error {
+		var err error
+		metrics.IpsetExecCount.Inc()
+		cmdOut, err = platform.ExecuteCommandContext(context.Background(), nil, cmdName, cmdArgs...)
+		if cmdOut != nil {
+			log.Printf("%s\n", cmdOut.Stdout)
+		}
+		return err
+	})
 
-	if msg, failed := err.(*exec.ExitError); failed {
-		errCode := msg.Sys().(syscall.WaitStatus).ExitStatus()
-		if errCode > 1 {
+	if err != nil {
+		// Exit code 1 reports sets and entries that exist or don't, other failures are errors.
+		errCode := platform.GetExitCode(err)
+		if errCode != 1 {
+			metrics.IpsetExecFailures.Inc()
 			log.Printf("There was an error running command: %s\nArguments:%+v", err, cmdArgs)
 		}
 
@@ -360,18 +831,28 @@ func (ipsMgr *IpsetManager) Run(entry *ipsEntry) (int, error) {
 	return 0, nil
 }
 
+// isTransientIpsetError checks if an ipset command failed in a way that may not happen again.
+func isTransientIpsetError(err error) bool {
+	cmdErr, ok := err.(*platform.CommandError)
+	if !ok {
+		return false
+	}
+
+	return cmdErr.TimedOut ||
+		strings.Contains(cmdErr.Stderr, "Device or resource busy") ||
+		strings.Contains(cmdErr.Stderr, "Resource temporarily unavailable")
+}
+
 // Save saves ipset to file.
 func (ipsMgr *IpsetManager) Save(configFile string) error {
 	if len(configFile) == 0 {
 		configFile = util.IpsetConfigFile
 	}
 
-	cmd := exec.Command(util.Ipset, util.IpsetSaveFlag, util.IpsetFileFlag, configFile)
-	if err := cmd.Start(); err != nil {
-		log.Printf("Error saving ipset to file.\n")
+	if _, err := platform.ExecuteCommandContext(context.Background(), nil, util.Ipset, util.IpsetSaveFlag, util.IpsetFileFlag, configFile); err != nil {
+		log.Printf("Error saving ipset to file: %v.\n", err)
 		return err
 	}
-	cmd.Wait()
 
 	return nil
 }
@@ -394,12 +875,10 @@ func (ipsMgr *IpsetManager) Restore(configFile string) error {
 		}
 	}
 
-	cmd := exec.Command(util.Ipset, util.IpsetRestoreFlag, util.IpsetFileFlag, configFile)
-	if err := cmd.Start(); err != nil {
-		log.Printf("Error restoring ipset from file.\n")
+	if _, err := platform.ExecuteCommandContext(context.Background(), nil, util.Ipset, util.IpsetRestoreFlag, util.IpsetFileFlag, configFile); err != nil {
+		log.Printf("Error restoring ipset from file: %v.\n", err)
 		return err
 	}
-	cmd.Wait()
 
 	return nil
 }
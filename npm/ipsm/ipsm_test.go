@@ -242,6 +242,206 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestBatch(t *testing.T) {
+	ipsMgr := NewIpsetManager()
+	if err := ipsMgr.Save(util.IpsetTestConfigFile); err != nil {
+		t.Errorf("TestBatch failed @ ipsMgr.Save")
+	}
+
+	defer func() {
+		if err := ipsMgr.Restore(util.IpsetTestConfigFile); err != nil {
+			t.Errorf("TestBatch failed @ ipsMgr.Restore")
+		}
+	}()
+
+	ipsMgr.BeginBatch()
+
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestBatch failed @ ipsMgr.AddToSet")
+	}
+
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.5"); err != nil {
+		t.Errorf("TestBatch failed @ ipsMgr.AddToSet")
+	}
+
+	if len(ipsMgr.batch) != 3 {
+		t.Errorf("TestBatch failed @ ipsMgr.batch, expected 3 queued entries, got %d", len(ipsMgr.batch))
+	}
+
+	if err := ipsMgr.CommitBatch(); err != nil {
+		t.Errorf("TestBatch failed @ ipsMgr.CommitBatch")
+	}
+
+	if len(ipsMgr.batch) != 0 {
+		t.Errorf("TestBatch failed @ ipsMgr.batch, expected no queued entries after commit")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ipsMgr := NewIpsetManager()
+	if err := ipsMgr.Save(util.IpsetTestConfigFile); err != nil {
+		t.Errorf("TestVerify failed @ ipsMgr.Save")
+	}
+
+	defer func() {
+		if err := ipsMgr.Restore(util.IpsetTestConfigFile); err != nil {
+			t.Errorf("TestVerify failed @ ipsMgr.Restore")
+		}
+	}()
+
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestVerify failed @ ipsMgr.AddToSet")
+	}
+
+	// Remove the member behind the manager's back.
+	entry := &ipsEntry{
+		operationFlag: util.IpsetDeletionFlag,
+		set:           util.GetHashedName("test-set"),
+		spec:          "1.2.3.4",
+	}
+	if _, err := ipsMgr.Run(entry); err != nil {
+		t.Errorf("TestVerify failed @ ipsMgr.Run")
+	}
+
+	if drift, err := ipsMgr.Verify(); err != nil || drift != 1 {
+		t.Errorf("TestVerify failed @ ipsMgr.Verify, drift %d err %v", drift, err)
+	}
+
+	if drift, err := ipsMgr.Verify(); err != nil || drift != 0 {
+		t.Errorf("TestVerify failed @ ipsMgr.Verify after repair, drift %d err %v", drift, err)
+	}
+}
+
+func TestLazySets(t *testing.T) {
+	SetLazySets(true)
+	defer SetLazySets(false)
+
+	ipsMgr := NewIpsetManager()
+	ipsMgr.BeginBatch()
+
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestLazySets failed @ ipsMgr.AddToSet")
+	}
+
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.5"); err != nil {
+		t.Errorf("TestLazySets failed @ ipsMgr.AddToSet")
+	}
+
+	if len(ipsMgr.batch) != 0 || !ipsMgr.setMap["test-set"].isPending {
+		t.Errorf("TestLazySets failed @ ipsMgr.AddToSet, expected a pending set, got %d queued entries", len(ipsMgr.batch))
+	}
+
+	// Namespace sets are created right away.
+	if err := ipsMgr.AddToSet("test", "1.2.3.4"); err != nil {
+		t.Errorf("TestLazySets failed @ ipsMgr.AddToSet")
+	}
+
+	if len(ipsMgr.batch) != 2 {
+		t.Errorf("TestLazySets failed @ ipsMgr.batch, expected 2 queued entries, got %d", len(ipsMgr.batch))
+	}
+
+	// Creating the set adds its pending members.
+	if err := ipsMgr.CreateSet("test-set"); err != nil {
+		t.Errorf("TestLazySets failed @ ipsMgr.CreateSet")
+	}
+
+	if len(ipsMgr.batch) != 5 || ipsMgr.setMap["test-set"].isPending {
+		t.Errorf("TestLazySets failed @ ipsMgr.CreateSet, expected 5 queued entries, got %d", len(ipsMgr.batch))
+	}
+
+	// Pending sets without members are forgotten.
+	if err := ipsMgr.AddToSet("test-other-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestLazySets failed @ ipsMgr.AddToSet")
+	}
+
+	if err := ipsMgr.DeleteFromSet("test-other-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestLazySets failed @ ipsMgr.DeleteFromSet")
+	}
+
+	if _, exists := ipsMgr.setMap["test-other-set"]; exists || len(ipsMgr.batch) != 5 {
+		t.Errorf("TestLazySets failed @ ipsMgr.DeleteFromSet, expected the pending set to be forgotten")
+	}
+}
+
+func TestDualStack(t *testing.T) {
+	SetDualStack(true)
+	defer SetDualStack(false)
+
+	members := map[string]bool{
+		"1.2.3.4":            false,
+		"1.2.3.0/24 nomatch": false,
+		"1.2.3.4,tcp:80":     false,
+		"fd00::1":            true,
+		"fd00::/64 nomatch":  true,
+		"fd00::1,tcp:80":     true,
+	}
+
+	for member, isIPv6 := range members {
+		if isIPv6Member(member) != isIPv6 {
+			t.Errorf("TestDualStack failed @ isIPv6Member, %s IPv6 %v", member, !isIPv6)
+		}
+	}
+
+	ipsMgr := NewIpsetManager()
+	ipsMgr.BeginBatch()
+
+	if err := ipsMgr.AddToSet("test-set", "fd00::1"); err != nil {
+		t.Errorf("TestDualStack failed @ ipsMgr.AddToSet")
+	}
+
+	// Both sets are created, the member is added to the IPv6 twin.
+	if len(ipsMgr.batch) != 3 {
+		t.Fatalf("TestDualStack failed @ ipsMgr.batch, expected 3 queued entries, got %d", len(ipsMgr.batch))
+	}
+
+	twin := util.GetHashedIPv6Name("test-set")
+	if ipsMgr.batch[1].set != twin || ipsMgr.batch[1].spec != "nethash family inet6" || ipsMgr.batch[2].set != twin {
+		t.Errorf("TestDualStack failed @ ipsMgr.AddToSet, unexpected entries %+v %+v", ipsMgr.batch[1], ipsMgr.batch[2])
+	}
+
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestDualStack failed @ ipsMgr.AddToSet")
+	}
+
+	if len(ipsMgr.batch) != 4 || ipsMgr.batch[3].set != util.GetHashedName("test-set") {
+		t.Errorf("TestDualStack failed @ ipsMgr.AddToSet, IPv4 member not added to the IPv4 set")
+	}
+}
+
+func TestTakeover(t *testing.T) {
+	ipsMgr := NewIpsetManager()
+	ipsMgr.liveSets = map[string]map[string]bool{
+		util.GetHashedName("test-set"): {"1.2.3.4": true},
+	}
+	ipsMgr.BeginBatch()
+
+	// The set and its member are already in the kernel.
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestTakeover failed @ ipsMgr.AddToSet")
+	}
+
+	if len(ipsMgr.batch) != 0 {
+		t.Errorf("TestTakeover failed @ ipsMgr.AddToSet, expected no queued entries, got %d", len(ipsMgr.batch))
+	}
+
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.5"); err != nil {
+		t.Errorf("TestTakeover failed @ ipsMgr.AddToSet")
+	}
+
+	// Members deleted while taking over are added again.
+	if err := ipsMgr.DeleteFromSet("test-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestTakeover failed @ ipsMgr.DeleteFromSet")
+	}
+
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestTakeover failed @ ipsMgr.AddToSet")
+	}
+
+	if len(ipsMgr.batch) != 3 {
+		t.Errorf("TestTakeover failed @ ipsMgr.batch, expected 3 queued entries, got %d", len(ipsMgr.batch))
+	}
+}
+
 func TestMain(m *testing.M) {
 	ipsMgr := NewIpsetManager()
 	ipsMgr.Save(util.IpsetConfigFile)
@@ -1,14 +1,43 @@
 package iptm
 
 import (
+	"bytes"
+	"context"
+	"net"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/Azure/azure-container-networking/iptables"
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
 	"github.com/Azure/azure-container-networking/npm/util"
+	"github.com/Azure/azure-container-networking/retry"
 )
 
+// iptables exits with this code on resource problems, such as failing to get the xtables lock
+// or the kernel being temporarily out of resources. These are retried.
+const iptablesResourceProblem = 4
+
+var runRetryPolicy = retry.Policy{
+	MaxAttempts:  3,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     time.Second,
+	Jitter:       0.2,
+	IsRetryable:  isTransientIptablesError,
+}
+
+// dualStack programs every rule in ip6tables as well, matching the IPv6 twins of the ipsets.
+var dualStack bool
+
+// SetDualStack sets whether rules are programmed in ip6tables as well, for dual-stack clusters.
+func SetDualStack(enable bool) {
+	dualStack = enable
+}
+
 // IptEntry represents an iptables rule.
 type IptEntry struct {
 	Name       string
@@ -21,6 +50,7 @@ type IptEntry struct {
 // IptablesManager stores iptables entries.
 type IptablesManager struct {
 	OperationFlag string
+	isIPv6        bool // programs ip6tables instead of iptables.
 }
 
 // NewIptablesManager creates a new instance for IptablesManager object.
@@ -32,8 +62,54 @@ func NewIptablesManager() *IptablesManager {
 	return iptMgr
 }
 
+// getIPv6Manager returns the manager programming the ip6tables rules of a dual-stack cluster, nil otherwise.
+func (iptMgr *IptablesManager) getIPv6Manager() *IptablesManager {
+	if !dualStack || iptMgr.isIPv6 {
+		return nil
+	}
+
+	return &IptablesManager{isIPv6: true}
+}
+
+// getClient returns the client of the IP family of the manager.
+func (iptMgr *IptablesManager) getClient() iptables.Client {
+	if iptMgr.isIPv6 {
+		return iptables.GetIPv6Client()
+	}
+
+	return iptables.GetClient()
+}
+
+// getIPv6Specs returns the specs of a rule for ip6tables, matching the IPv6 twins of the ipsets.
+// Rules matching IPv4 addresses don't apply to IPv6 traffic, so false is returned for them.
+func getIPv6Specs(specs []string) ([]string, bool) {
+	ipv6Specs := make([]string, len(specs))
+	for i, spec := range specs {
+		ip := net.ParseIP(spec)
+		if ip == nil {
+			ip, _, _ = net.ParseCIDR(spec)
+		}
+		if ip != nil && ip.To4() != nil {
+			return nil, false
+		}
+
+		if i > 0 && specs[i-1] == util.IptablesMatchSetFlag && strings.HasPrefix(spec, util.AzureNpmPrefix) {
+			spec = util.AzureNpmIPv6Prefix + strings.TrimPrefix(spec, util.AzureNpmPrefix)
+		}
+		ipv6Specs[i] = spec
+	}
+
+	return ipv6Specs, true
+}
+
 // InitNpmChains initializes Azure NPM chains in iptables.
 func (iptMgr *IptablesManager) InitNpmChains() error {
+	if ipv6Mgr := iptMgr.getIPv6Manager(); ipv6Mgr != nil {
+		if err := ipv6Mgr.InitNpmChains(); err != nil {
+			return err
+		}
+	}
+
 	log.Printf("Initializing AZURE-NPM chains")
 
 	if err := iptMgr.AddChain(util.IptablesAzureChain); err != nil {
@@ -84,6 +160,32 @@ func (iptMgr *IptablesManager) InitNpmChains() error {
 		}
 	}
 
+	// Insert the admin network policy chains right after the CONNECTED/RELATED rule, so that admin network policies
+	// take precedence over the default allow kube-system rules and network policies.
+	for i, chain := range []string{util.IptablesAzureAnpIngressChain, util.IptablesAzureAnpEgressChain} {
+		if err := iptMgr.AddChain(chain); err != nil {
+			return err
+		}
+
+		entry.Specs = []string{util.IptablesJumpFlag, chain}
+		exists, err = iptMgr.Exists(entry)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			iptMgr.OperationFlag = util.IptablesInsertionFlag
+			insert := &IptEntry{
+				Chain: util.IptablesAzureChain,
+				Specs: append([]string{strconv.Itoa(i + 2)}, entry.Specs...),
+			}
+			if _, err := iptMgr.Run(insert); err != nil {
+				log.Printf("Error adding %s chain to AZURE-NPM chain\n", chain)
+				return err
+			}
+		}
+	}
+
 	// Add default allow kube-system rules to AZURE-NPM chain.
 	entry.Specs = []string{
 		util.IptablesMatchFlag,
@@ -175,7 +277,7 @@ func (iptMgr *IptablesManager) InitNpmChains() error {
 		}
 	}
 
-	// Create AZURE-NPM-EGRESS-FROM chain.
+	// Create AZURE-NPM-EGRESS-TO chain.
 	if err := iptMgr.AddChain(util.IptablesAzureEgressToChain); err != nil {
 		return err
 	}
@@ -201,11 +303,65 @@ func (iptMgr *IptablesManager) InitNpmChains() error {
 		}
 	}
 
+	// Create AZURE-NPM-BANP chain.
+	if err := iptMgr.AddChain(util.IptablesAzureBanpChain); err != nil {
+		return err
+	}
+
+	// Append AZURE-NPM-BANP chain to AZURE-NPM-TARGET-SETS chain. Network policy rules are inserted above it,
+	// so the baseline admin network policy only applies to pods no network policy isolates.
+	entry.Chain = util.IptablesAzureTargetSetsChain
+	entry.Specs = []string{util.IptablesJumpFlag, util.IptablesAzureBanpChain}
+	exists, err = iptMgr.Exists(entry)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		iptMgr.OperationFlag = util.IptablesAppendFlag
+		if _, err := iptMgr.Run(entry); err != nil {
+			log.Printf("Error adding AZURE-NPM-BANP chain to AZURE-NPM-TARGET-SETS chain\n")
+			return err
+		}
+	}
+
+	entry.Chain = util.IptablesAzureChain
+
+	// Accept packets allowed by ingress or egress rules that made it through AZURE-NPM-TARGET-SETS chain.
+	for _, mark := range []string{util.IptablesAzureIngressMarkHex, util.IptablesAzureEgressMarkHex} {
+		entry.Specs = []string{
+			util.IptablesMatchFlag,
+			util.IptablesMark,
+			util.IptablesMarkFlag,
+			mark,
+			util.IptablesJumpFlag,
+			util.IptablesAccept,
+		}
+		exists, err = iptMgr.Exists(entry)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			iptMgr.OperationFlag = util.IptablesAppendFlag
+			if _, err := iptMgr.Run(entry); err != nil {
+				log.Printf("Error adding default allow marked packets rule to AZURE-NPM chain\n")
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
 // UninitNpmChains uninitializes Azure NPM chains in iptables.
 func (iptMgr *IptablesManager) UninitNpmChains() error {
+	if ipv6Mgr := iptMgr.getIPv6Manager(); ipv6Mgr != nil {
+		if err := ipv6Mgr.UninitNpmChains(); err != nil {
+			return err
+		}
+	}
+
 	IptablesAzureChainList := []string{
 		util.IptablesAzureChain,
 		util.IptablesAzureIngressPortChain,
@@ -213,6 +369,9 @@ func (iptMgr *IptablesManager) UninitNpmChains() error {
 		util.IptablesAzureEgressPortChain,
 		util.IptablesAzureEgressToChain,
 		util.IptablesAzureTargetSetsChain,
+		util.IptablesAzureAnpIngressChain,
+		util.IptablesAzureAnpEgressChain,
+		util.IptablesAzureBanpChain,
 	}
 
 	// Remove AZURE-NPM chain from FORWARD chain.
@@ -249,6 +408,59 @@ func (iptMgr *IptablesManager) UninitNpmChains() error {
 	return nil
 }
 
+// SyncChain replaces the rules of a chain with the given rules, in order.
+func (iptMgr *IptablesManager) SyncChain(chain string, entries []*IptEntry) error {
+	if ipv6Mgr := iptMgr.getIPv6Manager(); ipv6Mgr != nil {
+		if err := ipv6Mgr.SyncChain(chain, entries); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Syncing iptables chain %s\n", chain)
+
+	// The chain is replaced in a single transaction, so packets never see it flushed or partially filled.
+	input := iptMgr.getChainRestoreInput(chain, entries)
+
+	metrics.IptablesExecCount.Inc()
+	if err := iptMgr.getClient().RestoreNoFlush(strings.NewReader(input)); err != nil {
+		metrics.IptablesExecFailures.Inc()
+		log.Printf("Error syncing iptables chain %s: %v\n", chain, err)
+		return err
+	}
+
+	return nil
+}
+
+// getChainRestoreInput returns the iptables-restore input replacing the rules of a filter chain with the given rules.
+func (iptMgr *IptablesManager) getChainRestoreInput(chain string, entries []*IptEntry) string {
+	var buf bytes.Buffer
+	buf.WriteString("*" + iptables.Filter + "\n")
+	buf.WriteString(":" + chain + " - [0:0]\n")
+
+	for _, entry := range entries {
+		specs := entry.Specs
+		if iptMgr.isIPv6 {
+			var ok bool
+			if specs, ok = getIPv6Specs(entry.Specs); !ok {
+				continue
+			}
+		}
+
+		buf.WriteString(util.IptablesAppendFlag + " " + chain)
+		for _, spec := range specs {
+			if strings.ContainsAny(spec, " \"") {
+				spec = strconv.Quote(spec)
+			}
+			buf.WriteString(" " + spec)
+		}
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString("COMMIT\n")
+
+	return buf.String()
+}
+
 // Exists checks if a rule exists in iptables.
 func (iptMgr *IptablesManager) Exists(entry *IptEntry) (bool, error) {
 	iptMgr.OperationFlag = util.IptablesCheckFlag
@@ -307,6 +519,12 @@ func (iptMgr *IptablesManager) DeleteChain(chain string) error {
 
 // Add adds a rule in iptables.
 func (iptMgr *IptablesManager) Add(entry *IptEntry) error {
+	if ipv6Mgr := iptMgr.getIPv6Manager(); ipv6Mgr != nil {
+		if err := ipv6Mgr.Add(entry); err != nil {
+			return err
+		}
+	}
+
 	log.Printf("Add iptables entry: %+v\n", entry)
 
 	exists, err := iptMgr.Exists(entry)
@@ -329,6 +547,12 @@ func (iptMgr *IptablesManager) Add(entry *IptEntry) error {
 
 // Delete removes a rule in iptables.
 func (iptMgr *IptablesManager) Delete(entry *IptEntry) error {
+	if ipv6Mgr := iptMgr.getIPv6Manager(); ipv6Mgr != nil {
+		if err := ipv6Mgr.Delete(entry); err != nil {
+			return err
+		}
+	}
+
 	log.Printf("Deleting iptables entry: %+v\n", entry)
 
 	exists, err := iptMgr.Exists(entry)
@@ -350,16 +574,29 @@ func (iptMgr *IptablesManager) Delete(entry *IptEntry) error {
 }
 
 // Run execute an iptables command to update iptables.
+// Rules that don't apply to the IP family of the manager are skipped as if they succeeded.
 func (iptMgr *IptablesManager) Run(entry *IptEntry) (int, error) {
-	cmdName := util.Iptables
-	cmdArgs := append([]string{iptMgr.OperationFlag, entry.Chain}, entry.Specs...)
+	specs := entry.Specs
+	if iptMgr.isIPv6 {
+		var ok bool
+		if specs, ok = getIPv6Specs(entry.Specs); !ok {
+			return 0, nil
+		}
+	}
 
-	cmdOut, err := exec.Command(cmdName, cmdArgs...).Output()
-	log.Printf("%s\n", string(cmdOut))
+	cmdArgs := append([]string{iptMgr.OperationFlag, entry.Chain}, specs...)
+
+	err := retry.Do(context.Background(), &runRetryPolicy, func() error {
+		metrics.IptablesExecCount.Inc()
+		cmdOut, err := iptMgr.getClient().Run(iptables.Filter, cmdArgs...)
+		log.Printf("%s\n", string(cmdOut))
+		return err
+	})
 
 	if msg, failed := err.(*exec.ExitError); failed {
 		errCode := msg.Sys().(syscall.WaitStatus).ExitStatus()
 		if errCode > 1 {
+			metrics.IptablesExecFailures.Inc()
 			log.Printf("There was an error running command: %s\nArguments:%+v", err, cmdArgs)
 		}
 
@@ -369,6 +606,41 @@ func (iptMgr *IptablesManager) Run(entry *IptEntry) (int, error) {
 	return 0, nil
 }
 
+// isTransientIptablesError checks if an iptables command failed on a resource problem.
+func isTransientIptablesError(err error) bool {
+	if msg, failed := err.(*exec.ExitError); failed {
+		return msg.Sys().(syscall.WaitStatus).ExitStatus() == iptablesResourceProblem
+	}
+
+	return false
+}
+
+// List returns the rules of the filter table in the iptables-save format.
+func (iptMgr *IptablesManager) List() (string, error) {
+	metrics.IptablesExecCount.Inc()
+	cmdOut, err := iptables.GetClient().Save(iptables.Filter)
+	if err != nil {
+		metrics.IptablesExecFailures.Inc()
+		log.Printf("Error running iptables-save.\n")
+		return "", err
+	}
+
+	return string(cmdOut), nil
+}
+
+// ListCounters returns the rules of the filter table in the iptables-save format, with their counters.
+func (iptMgr *IptablesManager) ListCounters() (string, error) {
+	metrics.IptablesExecCount.Inc()
+	cmdOut, err := iptables.GetClient().SaveCounters(iptables.Filter)
+	if err != nil {
+		metrics.IptablesExecFailures.Inc()
+		log.Printf("Error running iptables-save.\n")
+		return "", err
+	}
+
+	return string(cmdOut), nil
+}
+
 // Save saves current iptables configuration to /var/log/iptables.conf
 func (iptMgr *IptablesManager) Save(configFile string) error {
 	if len(configFile) == 0 {
@@ -383,15 +655,15 @@ func (iptMgr *IptablesManager) Save(configFile string) error {
 	}
 	defer f.Close()
 
-	cmd := exec.Command(util.IptablesSave)
-	cmd.Stdout = f
-	if err := cmd.Start(); err != nil {
+	cmdOut, err := iptables.GetClient().Save("")
+	if err != nil {
 		log.Printf("Error running iptables-save.\n")
 		return err
 	}
-	cmd.Wait()
 
-	return nil
+	_, err = f.Write(cmdOut)
+
+	return err
 }
 
 // Restore restores iptables configuration from /var/log/iptables.conf
@@ -408,13 +680,10 @@ func (iptMgr *IptablesManager) Restore(configFile string) error {
 	}
 	defer f.Close()
 
-	cmd := exec.Command(util.IptablesRestore)
-	cmd.Stdin = f
-	if err := cmd.Start(); err != nil {
-		log.Printf("Error running iptables-restore.\n")
+	if err := iptables.GetClient().Restore(f); err != nil {
+		log.Printf("Error running iptables-restore: %v.\n", err)
 		return err
 	}
-	cmd.Wait()
 
 	return nil
 }
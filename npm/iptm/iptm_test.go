@@ -200,6 +200,61 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestGetIPv6Specs(t *testing.T) {
+	specs := []string{
+		util.IptablesMatchFlag,
+		util.IptablesSetFlag,
+		util.IptablesMatchSetFlag,
+		util.GetHashedName("test-set"),
+		util.IptablesSrcFlag,
+		util.IptablesJumpFlag,
+		util.IptablesAccept,
+	}
+
+	ipv6Specs, ok := getIPv6Specs(specs)
+	if !ok || ipv6Specs[3] != util.GetHashedIPv6Name("test-set") || specs[3] != util.GetHashedName("test-set") {
+		t.Errorf("TestGetIPv6Specs failed @ getIPv6Specs, got %v", ipv6Specs)
+	}
+
+	// Rules matching IPv4 addresses don't apply to IPv6 traffic.
+	if _, ok := getIPv6Specs([]string{util.IptablesSFlag, "10.0.0.0/8", util.IptablesJumpFlag, util.IptablesAccept}); ok {
+		t.Errorf("TestGetIPv6Specs failed @ getIPv6Specs, IPv4 rule applies to IPv6")
+	}
+
+	if _, ok := getIPv6Specs([]string{util.IptablesSFlag, "fd00::/8", util.IptablesJumpFlag, util.IptablesAccept}); !ok {
+		t.Errorf("TestGetIPv6Specs failed @ getIPv6Specs, IPv6 rule doesn't apply to IPv6")
+	}
+}
+
+func TestGetChainRestoreInput(t *testing.T) {
+	entries := []*IptEntry{
+		{
+			Chain: "TEST-CHAIN",
+			Specs: []string{util.IptablesMatchFlag, util.IptablesSetFlag, util.IptablesMatchSetFlag, util.GetHashedName("test-set"), util.IptablesSrcFlag, util.IptablesJumpFlag, util.IptablesDrop},
+		},
+		{
+			Chain: "TEST-CHAIN",
+			Specs: []string{util.IptablesSFlag, "10.0.0.0/8", util.IptablesMatchFlag, "comment", "--comment", "allow vnet", util.IptablesJumpFlag, util.IptablesAccept},
+		},
+	}
+
+	expected := "*filter\n:TEST-CHAIN - [0:0]\n" +
+		"-A TEST-CHAIN -m set --match-set " + util.GetHashedName("test-set") + " src -j DROP\n" +
+		"-A TEST-CHAIN -s 10.0.0.0/8 -m comment --comment \"allow vnet\" -j ACCEPT\n" +
+		"COMMIT\n"
+	if input := NewIptablesManager().getChainRestoreInput("TEST-CHAIN", entries); input != expected {
+		t.Errorf("TestGetChainRestoreInput failed, got\n%s", input)
+	}
+
+	// IPv6 rules match the IPv6 twins of the ipsets, and rules matching IPv4 addresses are left out.
+	expected = "*filter\n:TEST-CHAIN - [0:0]\n" +
+		"-A TEST-CHAIN -m set --match-set " + util.GetHashedIPv6Name("test-set") + " src -j DROP\n" +
+		"COMMIT\n"
+	if input := (&IptablesManager{isIPv6: true}).getChainRestoreInput("TEST-CHAIN", entries); input != expected {
+		t.Errorf("TestGetChainRestoreInput failed for IPv6, got\n%s", input)
+	}
+}
+
 func TestMain(m *testing.M) {
 	iptMgr := NewIptablesManager()
 	iptMgr.Save(util.IptablesConfigFile)
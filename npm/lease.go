@@ -0,0 +1,185 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/util"
+
+	coordinationv1beta1 "k8s.io/api/coordination/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1beta1"
+)
+
+// Node lease timings. A lease not renewed within its duration can be taken over.
+const (
+	leaseDuration      = 30 * time.Second
+	leaseRenewInterval = 10 * time.Second
+	leaseRetryInterval = 2 * time.Second
+)
+
+// nodeLease makes sure a single NPM instance programs the dataplane of a node,
+// e.g. while the old and new NPM pods of a rolling update both run on it.
+type nodeLease struct {
+	client   coordinationclient.LeaseInterface
+	name     string
+	holder   string
+	lastSeen time.Time
+}
+
+// getLeaseHolder returns the identity NPM holds its node lease under.
+// NPM pods share the host's hostname, so the pod name or the start time tells them apart.
+func getLeaseHolder(nodeName string) string {
+	if podName := os.Getenv("POD_NAME"); len(podName) > 0 {
+		return podName
+	}
+
+	return fmt.Sprintf("%s-%d", nodeName, time.Now().UnixNano())
+}
+
+// newNodeLease creates the lease of a node.
+func newNodeLease(clientset kubernetes.Interface, nodeName string) *nodeLease {
+	return &nodeLease{
+		client: clientset.CoordinationV1beta1().Leases(util.KubeSystemFlag),
+		name:   util.AzureNpmPrefix + nodeName,
+		holder: getLeaseHolder(nodeName),
+	}
+}
+
+// tryAcquireOrRenew takes the lease if it is free or expired, or renews it if it is already held.
+// It returns whether the lease is held.
+func (l *nodeLease) tryAcquireOrRenew() (bool, error) {
+	now := metav1.NewMicroTime(time.Now())
+	durationSeconds := int32(leaseDuration / time.Second)
+
+	lease, err := l.client.Get(context.Background(), l.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		lease = &coordinationv1beta1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      l.name,
+				Namespace: util.KubeSystemFlag,
+			},
+			Spec: coordinationv1beta1.LeaseSpec{
+				HolderIdentity:       &l.holder,
+				LeaseDurationSeconds: &durationSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+
+		if _, err = l.client.Create(context.Background(), lease, metav1.CreateOptions{}); err != nil {
+			return false, err
+		}
+
+		l.lastSeen = now.Time
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	spec := &lease.Spec
+	isHolder := spec.HolderIdentity != nil && *spec.HolderIdentity == l.holder
+	if !isHolder && spec.HolderIdentity != nil && len(*spec.HolderIdentity) > 0 && spec.RenewTime != nil {
+		duration := leaseDuration
+		if spec.LeaseDurationSeconds != nil {
+			duration = time.Duration(*spec.LeaseDurationSeconds) * time.Second
+		}
+
+		if spec.RenewTime.Add(duration).After(now.Time) {
+			return false, nil
+		}
+	}
+
+	if !isHolder {
+		log.Printf("[Azure-NPM] Taking over lease %s from %v.", l.name, spec.HolderIdentity)
+		transitions := int32(1)
+		if spec.LeaseTransitions != nil {
+			transitions += *spec.LeaseTransitions
+		}
+		spec.LeaseTransitions = &transitions
+		spec.AcquireTime = &now
+	}
+
+	spec.HolderIdentity = &l.holder
+	spec.LeaseDurationSeconds = &durationSeconds
+	spec.RenewTime = &now
+
+	// Updates are rejected if another instance changed the lease since it was read.
+	if _, err = l.client.Update(context.Background(), lease, metav1.UpdateOptions{}); err != nil {
+		return false, err
+	}
+
+	l.lastSeen = now.Time
+	return true, nil
+}
+
+// acquire blocks until the lease is held. It returns false if stopCh is closed first.
+func (l *nodeLease) acquire(stopCh <-chan struct{}) bool {
+	log.Printf("[Azure-NPM] Acquiring lease %s as %s.", l.name, l.holder)
+
+	for {
+		held, err := l.tryAcquireOrRenew()
+		if err != nil {
+			log.Printf("[Azure-NPM] Failed to acquire lease %s: %v.", l.name, err)
+		}
+
+		if held {
+			log.Printf("[Azure-NPM] Acquired lease %s.", l.name)
+			return true
+		}
+
+		select {
+		case <-stopCh:
+			return false
+		case <-time.After(leaseRetryInterval):
+		}
+	}
+}
+
+// renew keeps renewing the lease until stopCh is closed. It returns false if the lease was lost.
+func (l *nodeLease) renew(stopCh <-chan struct{}) bool {
+	for {
+		select {
+		case <-stopCh:
+			return true
+		case <-time.After(leaseRenewInterval):
+		}
+
+		held, err := l.tryAcquireOrRenew()
+		if err != nil {
+			log.Printf("[Azure-NPM] Failed to renew lease %s: %v.", l.name, err)
+		}
+
+		// Transient failures are retried until the lease would expire.
+		if !held && (err == nil || time.Since(l.lastSeen) > leaseDuration) {
+			return false
+		}
+	}
+}
+
+// release gives up the lease so that another instance can take it over right away.
+func (l *nodeLease) release() error {
+	lease, err := l.client.Get(context.Background(), l.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.holder {
+		return nil
+	}
+
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.RenewTime = nil
+	_, err = l.client.Update(context.Background(), lease, metav1.UpdateOptions{})
+
+	return err
+}
@@ -0,0 +1,273 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// DefaultAddress is the address the metrics server listens on.
+	DefaultAddress = ":10091"
+
+	// Path is the URL path metrics are served under.
+	Path = "/metrics"
+
+	contentType = "text/plain; version=0.0.4"
+)
+
+// defaultBuckets are the upper bounds, in seconds, of the duration histograms.
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// latencyBuckets are the upper bounds, in seconds, of the histograms of latencies including retries.
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// NPM metrics.
+var (
+	registry []*metricDesc
+
+	AddPolicyDuration    = newHistogram("npm_add_policy_duration_seconds", "Time taken to add a network policy.", defaultBuckets)
+	DeletePolicyDuration = newHistogram("npm_delete_policy_duration_seconds", "Time taken to delete a network policy.", defaultBuckets)
+	IptablesExecCount    = newCounter("npm_iptables_exec_total", "Number of iptables commands executed.")
+	IptablesExecFailures = newCounter("npm_iptables_exec_failures_total", "Number of iptables commands that failed.")
+	IpsetExecCount       = newCounter("npm_ipset_exec_total", "Number of ipset commands executed.")
+	IpsetExecFailures    = newCounter("npm_ipset_exec_failures_total", "Number of ipset commands that failed.")
+	EventQueueDepth      = newGauge("npm_event_queue_depth", "Number of informer events being processed or waiting to be processed.")
+	NumPolicies          = newGauge("npm_num_policies", "Number of network policies managed by NPM.")
+	NumIpsets            = newGauge("npm_num_ipsets", "Number of ipsets and ipset lists managed by NPM.")
+	DataplaneDrift       = newCounter("npm_dataplane_drift_total", "Number of dataplane entries repaired after drifting from the network policies.")
+	PolicyApplyLatency   = newHistogram("npm_policy_apply_latency_seconds", "Time from a network policy event to the dataplane being programmed.", latencyBuckets)
+	PolicyApplyFailures  = newCounter("npm_policy_apply_failures_total", "Number of failed attempts to apply a network policy change to the dataplane.")
+	PolicyHitPackets     = newCounterVec("npm_policy_hit_packets_total", "Number of packets matched by the iptables rules of a network policy.", "policy")
+	PolicyHitBytes       = newCounterVec("npm_policy_hit_bytes_total", "Number of bytes matched by the iptables rules of a network policy.", "policy")
+)
+
+// serveMux routes the requests of the metrics server.
+var serveMux = http.NewServeMux()
+
+// metric is a value that can be written in the Prometheus text format.
+type metric interface {
+	write(w io.Writer, name string)
+}
+
+// metricDesc describes a registered metric.
+type metricDesc struct {
+	name   string
+	help   string
+	kind   string
+	metric metric
+}
+
+// register adds a metric to the registry.
+func register(name string, help string, kind string, m metric) {
+	registry = append(registry, &metricDesc{name: name, help: help, kind: kind, metric: m})
+}
+
+// Counter is a metric whose value only increases.
+type Counter struct {
+	value uint64
+}
+
+func newCounter(name string, help string) *Counter {
+	c := &Counter{}
+	register(name, help, "counter", c)
+	return c
+}
+
+// Inc increments the counter.
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add adds the given value to the counter.
+func (c *Counter) Add(value int) {
+	atomic.AddUint64(&c.value, uint64(value))
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+func (c *Counter) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %d\n", name, c.Value())
+}
+
+// CounterVec is a family of counters partitioned by the value of a label.
+type CounterVec struct {
+	sync.Mutex
+	label  string
+	values map[string]uint64
+}
+
+func newCounterVec(name string, help string, label string) *CounterVec {
+	c := &CounterVec{label: label, values: make(map[string]uint64)}
+	register(name, help, "counter", c)
+	return c
+}
+
+// Set replaces the counters of the family, e.g. with counters maintained by the kernel.
+func (c *CounterVec) Set(values map[string]uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.values = make(map[string]uint64, len(values))
+	for labelValue, value := range values {
+		c.values[labelValue] = value
+	}
+}
+
+// Value returns the counter with the given label value.
+func (c *CounterVec) Value(labelValue string) uint64 {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.values[labelValue]
+}
+
+func (c *CounterVec) write(w io.Writer, name string) {
+	c.Lock()
+	defer c.Unlock()
+
+	var labelValues []string
+	for labelValue := range c.values {
+		labelValues = append(labelValues, labelValue)
+	}
+	sort.Strings(labelValues)
+
+	for _, labelValue := range labelValues {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, c.label, labelValue, c.values[labelValue])
+	}
+}
+
+// Gauge is a metric whose value goes up and down.
+type Gauge struct {
+	value int64
+}
+
+func newGauge(name string, help string) *Gauge {
+	g := &Gauge{}
+	register(name, help, "gauge", g)
+	return g
+}
+
+// Inc increments the gauge.
+func (g *Gauge) Inc() {
+	atomic.AddInt64(&g.value, 1)
+}
+
+// Dec decrements the gauge.
+func (g *Gauge) Dec() {
+	atomic.AddInt64(&g.value, -1)
+}
+
+// Add adds the given value, which may be negative, to the gauge.
+func (g *Gauge) Add(value int) {
+	atomic.AddInt64(&g.value, int64(value))
+}
+
+// Set sets the gauge to the given value.
+func (g *Gauge) Set(value int) {
+	atomic.StoreInt64(&g.value, int64(value))
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+func (g *Gauge) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %d\n", name, g.Value())
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name string, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+	register(name, help, "histogram", h)
+	return h
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(value float64) {
+	h.Lock()
+	defer h.Unlock()
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// ObserveSince adds the time elapsed since start, in seconds, to the histogram.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) write(w io.Writer, name string) {
+	h.Lock()
+	defer h.Unlock()
+
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// Write writes all metrics in the Prometheus text exposition format.
+func Write(w io.Writer) {
+	for _, desc := range registry {
+		fmt.Fprintf(w, "# HELP %s %s\n", desc.name, desc.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", desc.name, desc.kind)
+		desc.metric.write(w, desc.name)
+	}
+}
+
+// Handler returns the HTTP handler serving the metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		Write(w)
+	})
+}
+
+// Handle registers a handler, e.g. of a debug API, on the metrics server.
+// Handlers should be registered before the server is started.
+func Handle(pattern string, handler http.Handler) {
+	serveMux.Handle(pattern, handler)
+}
+
+// StartServer serves the metrics, and the registered handlers, on the given address in the background.
+func StartServer(address string) {
+	serveMux.Handle(Path, Handler())
+
+	go func() {
+		if err := http.ListenAndServe(address, serveMux); err != nil {
+			log.Printf("[Azure-NPM] Metrics server failed with error %v.", err)
+		}
+	}()
+}
@@ -0,0 +1,38 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	IptablesExecCount.Inc()
+	NumPolicies.Set(3)
+	AddPolicyDuration.Observe(0.02)
+	PolicyHitPackets.Set(map[string]uint64{"test/deny-all": 12, "test/allow-web": 3})
+
+	var buf bytes.Buffer
+	Write(&buf)
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE npm_iptables_exec_total counter\n",
+		"npm_iptables_exec_total 1\n",
+		"# TYPE npm_num_policies gauge\n",
+		"npm_num_policies 3\n",
+		"npm_add_policy_duration_seconds_bucket{le=\"0.01\"} 0\n",
+		"npm_add_policy_duration_seconds_bucket{le=\"0.025\"} 1\n",
+		"npm_add_policy_duration_seconds_bucket{le=\"+Inf\"} 1\n",
+		"npm_add_policy_duration_seconds_count 1\n",
+		"# TYPE npm_policy_hit_packets_total counter\n",
+		"npm_policy_hit_packets_total{policy=\"test/allow-web\"} 3\nnpm_policy_hit_packets_total{policy=\"test/deny-all\"} 12\n",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line) {
+			t.Errorf("TestWrite failed, output is missing %q:\n%s", line, out)
+		}
+	}
+}
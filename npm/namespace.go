@@ -7,30 +7,36 @@ import (
 	"github.com/Azure/azure-container-networking/npm/ipsm"
 	"github.com/Azure/azure-container-networking/npm/iptm"
 	"github.com/Azure/azure-container-networking/npm/util"
-	"k8s.io/apimachinery/pkg/types"
 
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
+// namespace tracks the dataplane state of a namespace.
+// The all-namespace namespace tracks the objects applied to the dataplane by namespace/name, and the pods by UID.
 type namespace struct {
-	name   string
-	setMap map[string]string
-	podMap map[types.UID]*corev1.Pod
-	npMap  map[string]*networkingv1.NetworkPolicy
-	ipsMgr *ipsm.IpsetManager
-	iptMgr *iptm.IptablesManager
+	name     string
+	setMap   map[string]string
+	podMap   map[types.UID]*corev1.Pod
+	podUIDs  map[string]types.UID // namespace/name -> UID of the applied pod.
+	nsObjMap map[string]*corev1.Namespace
+	npMap    map[string]*networkingv1.NetworkPolicy
+	ipsMgr   *ipsm.IpsetManager
+	iptMgr   *iptm.IptablesManager
 }
 
 // newNS constructs a new namespace object.
 func newNs(name string) (*namespace, error) {
 	ns := &namespace{
-		name:   name,
-		setMap: make(map[string]string),
-		podMap: make(map[types.UID]*corev1.Pod),
-		npMap:  make(map[string]*networkingv1.NetworkPolicy),
-		ipsMgr: ipsm.NewIpsetManager(),
-		iptMgr: iptm.NewIptablesManager(),
+		name:     name,
+		setMap:   make(map[string]string),
+		podMap:   make(map[types.UID]*corev1.Pod),
+		podUIDs:  make(map[string]types.UID),
+		nsObjMap: make(map[string]*corev1.Namespace),
+		npMap:    make(map[string]*networkingv1.NetworkPolicy),
+		ipsMgr:   ipsm.NewIpsetManager(),
+		iptMgr:   iptm.NewIptablesManager(),
 	}
 
 	return ns, nil
@@ -94,7 +100,8 @@ func (npMgr *NetworkPolicyManager) AddNamespace(nsObj *corev1.Namespace) error {
 	nsName, nsNs := nsObj.ObjectMeta.Name, nsObj.ObjectMeta.Namespace
 	log.Printf("NAMESPACE CREATING: %s/%s\n", nsName, nsNs)
 
-	ipsMgr := npMgr.nsMap[util.KubeAllNamespacesFlag].ipsMgr
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+	ipsMgr := allNs.ipsMgr
 	// Create ipset for the namespace.
 	if err = ipsMgr.CreateSet(nsName); err != nil {
 		log.Printf("Error creating ipset for namespace %s.\n", nsName)
@@ -125,36 +132,67 @@ func (npMgr *NetworkPolicyManager) AddNamespace(nsObj *corev1.Namespace) error {
 	}
 	npMgr.nsMap[nsName] = ns
 
+	allNs.nsObjMap[nsName] = nsObj
 	npMgr.clusterState.NsCount++
 
 	return nil
 }
 
 // UpdateNamespace handles updating namespace in ipset.
+// Only the label ipset lists that differ between the old and new namespace are changed.
 func (npMgr *NetworkPolicyManager) UpdateNamespace(oldNsObj *corev1.Namespace, newNsObj *corev1.Namespace) error {
+	if isBeingDeleted(newNsObj.ObjectMeta) {
+		return npMgr.DeleteNamespace(oldNsObj)
+	}
+
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
 	var err error
 
 	defer func() {
-		npMgr.Lock()
-		if err = npMgr.UpdateAndSendReport(err, util.AddNamespaceEvent); err != nil {
+		if err = npMgr.UpdateAndSendReport(err, util.UpdateNamespaceEvent); err != nil {
 			log.Printf("Error sending NPM telemetry report")
 		}
-		npMgr.Unlock()
 	}()
 
 	oldNsName, newNsName := oldNsObj.ObjectMeta.Name, newNsObj.ObjectMeta.Name
 	log.Printf("NAMESPACE UPDATING. %s/%s", oldNsName, newNsName)
 
-	if err = npMgr.DeleteNamespace(oldNsObj); err != nil {
-		return err
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+	ipsMgr := allNs.ipsMgr
+	oldLabels, newLabels := oldNsObj.ObjectMeta.Labels, newNsObj.ObjectMeta.Labels
+
+	// Delete the namespace from the ipset lists of its removed or changed labels.
+	for nsLabelKey, nsLabelVal := range oldLabels {
+		if newVal, exists := newLabels[nsLabelKey]; exists && newVal == nsLabelVal {
+			continue
+		}
+
+		labelKey := getNsIpsetName(nsLabelKey, nsLabelVal)
+		log.Printf("Deleting namespace %s from ipset list %s\n", oldNsName, labelKey)
+		if err = ipsMgr.DeleteFromList(labelKey, oldNsName); err != nil {
+			log.Printf("Error deleting namespace %s from ipset list %s\n", oldNsName, labelKey)
+			return err
+		}
 	}
 
-	if newNsObj.ObjectMeta.DeletionTimestamp == nil && newNsObj.ObjectMeta.DeletionGracePeriodSeconds == nil {
-		if err = npMgr.AddNamespace(newNsObj); err != nil {
+	// Add the namespace to the ipset lists of its added or changed labels.
+	for nsLabelKey, nsLabelVal := range newLabels {
+		if oldVal, exists := oldLabels[nsLabelKey]; exists && oldVal == nsLabelVal {
+			continue
+		}
+
+		labelKey := getNsIpsetName(nsLabelKey, nsLabelVal)
+		log.Printf("Adding namespace %s to ipset list %s\n", newNsName, labelKey)
+		if err = ipsMgr.AddToList(labelKey, newNsName); err != nil {
+			log.Printf("Error Adding namespace %s to ipset list %s\n", newNsName, labelKey)
 			return err
 		}
 	}
 
+	allNs.nsObjMap[newNsName] = newNsObj
+
 	return nil
 }
 
@@ -180,7 +218,8 @@ func (npMgr *NetworkPolicyManager) DeleteNamespace(nsObj *corev1.Namespace) erro
 	}
 
 	// Delete the namespace from its label's ipset list.
-	ipsMgr := npMgr.nsMap[util.KubeAllNamespacesFlag].ipsMgr
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+	ipsMgr := allNs.ipsMgr
 	var labelKeys []string
 	nsLabels := nsObj.ObjectMeta.Labels
 	for nsLabelKey, nsLabelVal := range nsLabels {
@@ -206,6 +245,7 @@ func (npMgr *NetworkPolicyManager) DeleteNamespace(nsObj *corev1.Namespace) erro
 	}
 
 	delete(npMgr.nsMap, nsName)
+	delete(allNs.nsObjMap, nsName)
 
 	npMgr.clusterState.NsCount--
 
@@ -10,10 +10,11 @@ import (
 	"time"
 
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
 	"github.com/Azure/azure-container-networking/npm/util"
 	"github.com/Azure/azure-container-networking/telemetry"
-	corev1 "k8s.io/api/core/v1"
-	networkingv1 "k8s.io/api/networking/v1"
+	"github.com/Azure/azure-container-networking/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/informers"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	networkinginformers "k8s.io/client-go/informers/networking/v1"
@@ -36,14 +37,38 @@ type NetworkPolicyManager struct {
 	nsInformer      coreinformers.NamespaceInformer
 	npInformer      networkinginformers.NetworkPolicyInformer
 
+	podQueue *workQueue
+	nsQueue  *workQueue
+	npQueue  *workQueue
+
 	nodeName               string
+	lease                  *nodeLease
 	nsMap                  map[string]*namespace
 	isAzureNpmChainCreated bool
+	adminPolicies          *adminPolicyRules
+	fqdnAddresses          map[string]map[string]time.Time
+	policyHits             map[string]*PolicyHits
+	ebpf                   *ebpfDataplane
+	unsupportedPolicies    map[string]int
 
 	clusterState  telemetry.ClusterState
 	reportManager *telemetry.ReportManager
 }
 
+// getObjectKey returns the namespace/name key an object is tracked and queued under.
+func getObjectKey(meta metav1.ObjectMeta) string {
+	if len(meta.Namespace) == 0 {
+		return meta.Name
+	}
+
+	return meta.Namespace + "/" + meta.Name
+}
+
+// isBeingDeleted checks if an object is being gracefully deleted.
+func isBeingDeleted(meta metav1.ObjectMeta) bool {
+	return meta.DeletionTimestamp != nil || meta.DeletionGracePeriodSeconds != nil
+}
+
 // GetClusterState returns current cluster state.
 func (npMgr *NetworkPolicyManager) GetClusterState() telemetry.ClusterState {
 	return npMgr.clusterState
@@ -69,6 +94,29 @@ func (npMgr *NetworkPolicyManager) UpdateAndSendReport(err error, eventMsg strin
 	return npMgr.reportManager.SendReport()
 }
 
+// reportUnsupportedPolicies warns that policies the dataplane doesn't support aren't enforced on this node,
+// whenever their number changes. This function should only be called when npMgr is locked.
+func (npMgr *NetworkPolicyManager) reportUnsupportedPolicies(kind string, count int) {
+	if count == npMgr.unsupportedPolicies[kind] {
+		return
+	}
+
+	if npMgr.unsupportedPolicies == nil {
+		npMgr.unsupportedPolicies = make(map[string]int)
+	}
+	npMgr.unsupportedPolicies[kind] = count
+
+	if count == 0 {
+		return
+	}
+
+	eventMsg := fmt.Sprintf("%s: %d %s are not enforced on %s", util.UnsupportedPolicyEvent, count, kind, unsupportedPolicyNodes)
+	log.Printf("Warning: %s", eventMsg)
+	if err := npMgr.UpdateAndSendReport(nil, eventMsg); err != nil {
+		log.Printf("Error sending NPM telemetry report")
+	}
+}
+
 // Run starts shared informers and waits for the shared informer cache to sync.
 func (npMgr *NetworkPolicyManager) Run(stopCh <-chan struct{}) error {
 	// Starts all informers manufactured by npMgr's informerFactory.
@@ -87,9 +135,40 @@ func (npMgr *NetworkPolicyManager) Run(stopCh <-chan struct{}) error {
 		return fmt.Errorf("Namespace informer failed to sync")
 	}
 
+	// Only one NPM instance programs the node, e.g. while the old and new pods of an upgrade both run on it.
+	if !npMgr.lease.acquire(stopCh) {
+		return fmt.Errorf("Stopped before acquiring lease %s", npMgr.lease.name)
+	}
+
+	go func() {
+		if !npMgr.lease.renew(stopCh) {
+			// Another instance may be programming the node by now.
+			log.Printf("[Azure-NPM] Lost lease %s, exiting.", npMgr.lease.name)
+			os.Exit(1)
+		}
+	}()
+
+	// Process the events queued while the caches synced, then all later ones.
+	span := trace.StartSpan("npm.reconcile", trace.SpanContext{})
+	npMgr.reconcileDataplane()
+	span.End(nil)
+
+	npMgr.startWorkers(stopCh)
+
 	return nil
 }
 
+// Stop hands the node over to another NPM instance by releasing the lease.
+// The dataplane is left in place for the next instance to adopt, so NPM must exit right after.
+func (npMgr *NetworkPolicyManager) Stop() {
+	// Wait for the change being applied, and keep any other from being applied.
+	npMgr.Lock()
+
+	if err := npMgr.lease.release(); err != nil {
+		log.Printf("[Azure-NPM] Failed to release lease %s: %v.", npMgr.lease.name, err)
+	}
+}
+
 // RunReportManager starts NPMReportManager and send telemetry periodically.
 func (npMgr *NetworkPolicyManager) RunReportManager() {
 	if err := npMgr.reportManager.GetHostMetadata(); err != nil {
@@ -113,6 +192,67 @@ func (npMgr *NetworkPolicyManager) RunReportManager() {
 	}
 }
 
+// RunDataplaneVerifier periodically repairs dataplane state that drifted from the network policies,
+// e.g. rules flushed by other tools, and reports the drift.
+func (npMgr *NetworkPolicyManager) RunDataplaneVerifier(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		drift, err := npMgr.verifyDataplane()
+		if err != nil {
+			log.Printf("Error verifying dataplane: %v", err)
+		}
+
+		if drift == 0 && err == nil {
+			continue
+		}
+
+		log.Printf("Repaired %d dataplane entries that drifted from the network policies", drift)
+		metrics.DataplaneDrift.Add(drift)
+
+		npMgr.Lock()
+		if err = npMgr.UpdateAndSendReport(err, fmt.Sprintf("%s: %d entries repaired", util.DataplaneDriftEvent, drift)); err != nil {
+			log.Printf("Error sending NPM telemetry report")
+		}
+		npMgr.Unlock()
+	}
+}
+
+// RunAdminPolicySync periodically applies the admin network policies and the baseline admin network policy.
+// client-go has no informers for them, so they are polled. The initial ones are applied by Run.
+func (npMgr *NetworkPolicyManager) RunAdminPolicySync(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		if err := npMgr.syncAdminPolicies(); err != nil {
+			log.Printf("Error syncing admin network policies: %v", err)
+		}
+	}
+}
+
+// RunFQDNSync periodically resolves the domain names network policies allow egress traffic to,
+// and updates their ipsets with the answers.
+func (npMgr *NetworkPolicyManager) RunFQDNSync(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		if err := npMgr.syncFQDNs(); err != nil {
+			log.Printf("Error syncing domain name ipsets: %v", err)
+		}
+	}
+}
+
+// RunPolicyHitSync periodically reads the counters of the iptables rules, and aggregates them by network policy.
+func (npMgr *NetworkPolicyManager) RunPolicyHitSync(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		if err := npMgr.syncPolicyHits(); err != nil {
+			log.Printf("Error syncing network policy hit counters: %v", err)
+		}
+	}
+}
+
 // NewNetworkPolicyManager creates a NetworkPolicyManager
 func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory, npmVersion string) *NetworkPolicyManager {
 
@@ -121,14 +261,19 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 	npInformer := informerFactory.Networking().V1().NetworkPolicies()
 
 	npMgr := &NetworkPolicyManager{
-		clientset:       clientset,
-		informerFactory: informerFactory,
-		podInformer:     podInformer,
-		nsInformer:      nsInformer,
-		npInformer:      npInformer,
-		nodeName:        os.Getenv("HOSTNAME"),
-		nsMap:           make(map[string]*namespace),
+		clientset:              clientset,
+		informerFactory:        informerFactory,
+		podInformer:            podInformer,
+		nsInformer:             nsInformer,
+		npInformer:             npInformer,
+		podQueue:               newWorkQueue("pod"),
+		nsQueue:                newWorkQueue("namespace"),
+		npQueue:                newWorkQueue("networkpolicy"),
+		nodeName:               os.Getenv("HOSTNAME"),
+		nsMap:                  make(map[string]*namespace),
 		isAzureNpmChainCreated: false,
+		fqdnAddresses:          make(map[string]map[string]time.Time),
+		policyHits:             make(map[string]*PolicyHits),
 		clusterState: telemetry.ClusterState{
 			PodCount:      0,
 			NsCount:       0,
@@ -141,6 +286,8 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 		},
 	}
 
+	npMgr.lease = newNodeLease(clientset, npMgr.nodeName)
+
 	serverVersion, err := clientset.ServerVersion()
 	if err != nil {
 		log.Printf("Error retrieving server version")
@@ -158,50 +305,7 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 	}
 	npMgr.nsMap[util.KubeAllNamespacesFlag] = allNs
 
-	podInformer.Informer().AddEventHandler(
-		// Pod event handlers
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				npMgr.AddPod(obj.(*corev1.Pod))
-			},
-			UpdateFunc: func(old, new interface{}) {
-				npMgr.UpdatePod(old.(*corev1.Pod), new.(*corev1.Pod))
-			},
-			DeleteFunc: func(obj interface{}) {
-				npMgr.DeletePod(obj.(*corev1.Pod))
-			},
-		},
-	)
-
-	nsInformer.Informer().AddEventHandler(
-		// Namespace event handlers
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				npMgr.AddNamespace(obj.(*corev1.Namespace))
-			},
-			UpdateFunc: func(old, new interface{}) {
-				npMgr.UpdateNamespace(old.(*corev1.Namespace), new.(*corev1.Namespace))
-			},
-			DeleteFunc: func(obj interface{}) {
-				npMgr.DeleteNamespace(obj.(*corev1.Namespace))
-			},
-		},
-	)
-
-	npInformer.Informer().AddEventHandler(
-		// Network policy event handlers
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				npMgr.AddNetworkPolicy(obj.(*networkingv1.NetworkPolicy))
-			},
-			UpdateFunc: func(old, new interface{}) {
-				npMgr.UpdateNetworkPolicy(old.(*networkingv1.NetworkPolicy), new.(*networkingv1.NetworkPolicy))
-			},
-			DeleteFunc: func(obj interface{}) {
-				npMgr.DeleteNetworkPolicy(obj.(*networkingv1.NetworkPolicy))
-			},
-		},
-	)
+	npMgr.addEventHandlers()
 
 	return npMgr
 }
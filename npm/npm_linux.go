@@ -0,0 +1,596 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/netlink"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Nodes that don't enforce the policies the eBPF dataplane doesn't support. The iptables dataplane supports them all.
+const unsupportedPolicyNodes = "nodes with the eBPF dataplane"
+
+// addEventHandlers queues the keys of changed pods, namespaces and network policies.
+// The eBPF dataplane resyncs all of them instead.
+func (npMgr *NetworkPolicyManager) addEventHandlers() {
+	if isEbpfDataplane() {
+		npMgr.addResyncHandlers()
+		return
+	}
+
+	npMgr.podInformer.Informer().AddEventHandler(getEnqueueHandler(npMgr.podQueue))
+	npMgr.nsInformer.Informer().AddEventHandler(getEnqueueHandler(npMgr.nsQueue))
+	npMgr.npInformer.Informer().AddEventHandler(getEnqueueHandler(npMgr.npQueue))
+}
+
+// startWorkers starts applying the queued changes to ipsets and iptables.
+func (npMgr *NetworkPolicyManager) startWorkers(stopCh <-chan struct{}) {
+	if isEbpfDataplane() {
+		for _, q := range []*workQueue{npMgr.podQueue, npMgr.nsQueue, npMgr.npQueue} {
+			startWorker(q, npMgr.syncEbpf, stopCh)
+		}
+		return
+	}
+
+	startWorker(npMgr.nsQueue, npMgr.syncNamespace, stopCh)
+	startWorker(npMgr.podQueue, npMgr.syncPod, stopCh)
+	startWorker(npMgr.npQueue, npMgr.syncNetworkPolicy, stopCh)
+}
+
+// reconcileDataplane adopts the AZURE-NPM chains and ipsets left by a previous NPM instance instead of flushing them,
+// applies the difference with the current cluster state on top of them and then removes the stale rules and ipsets.
+// Pods keep their connectivity while NPM restarts or is upgraded.
+func (npMgr *NetworkPolicyManager) reconcileDataplane() {
+	if isEbpfDataplane() {
+		npMgr.reconcileEbpfDataplane()
+		return
+	}
+
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+
+	npMgr.Lock()
+	exists, err := allNs.iptMgr.Exists(&iptm.IptEntry{
+		Chain: util.IptablesForwardChain,
+		Specs: []string{util.IptablesJumpFlag, util.IptablesAzureChain},
+	})
+	if err != nil {
+		log.Printf("Error checking for existing azure-npm chains: %v", err)
+	}
+
+	if exists {
+		log.Printf("Adopting existing azure-npm chains")
+		if err = allNs.iptMgr.InitNpmChains(); err != nil {
+			log.Printf("Error initializing azure-npm chains: %v", err)
+		}
+		npMgr.isAzureNpmChainCreated = true
+	}
+
+	// Only the ipset members that differ from the ones left in the kernel are applied.
+	if err = allNs.ipsMgr.BeginTakeover(); err != nil {
+		log.Printf("Error listing existing azure-npm ipsets: %v", err)
+	}
+	npMgr.Unlock()
+
+	// Apply the initial state before deciding what is stale.
+	drainQueue(npMgr.nsQueue, npMgr.syncNamespace)
+	drainQueue(npMgr.podQueue, npMgr.syncPod)
+	drainQueue(npMgr.npQueue, npMgr.syncNetworkPolicy)
+
+	if err = npMgr.syncAdminPolicies(); err != nil {
+		log.Printf("Error syncing admin network policies: %v", err)
+	}
+
+	if err = npMgr.syncFQDNs(); err != nil {
+		log.Printf("Error syncing domain name ipsets: %v", err)
+	}
+
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	if err = npMgr.removeStaleRules(); err != nil {
+		log.Printf("Error removing stale iptables rules: %v", err)
+	}
+
+	repaired, err := allNs.ipsMgr.EndTakeover()
+	if err != nil {
+		log.Printf("Error repairing the adopted ipsets: %v", err)
+	}
+
+	if repaired > 0 {
+		log.Printf("Repaired %d adopted ipsets and members", repaired)
+	}
+
+	removed, err := allNs.ipsMgr.RemoveStale()
+	if err != nil {
+		log.Printf("Error removing stale ipsets: %v", err)
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d stale ipsets", removed)
+	}
+}
+
+// removeStaleRules removes the rules of the AZURE-NPM policy chains that none of the applied network policies produce.
+// This function should only be called when npMgr is locked.
+func (npMgr *NetworkPolicyManager) removeStaleRules() error {
+	if !npMgr.isAzureNpmChainCreated {
+		return nil
+	}
+
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+	iptMgr := allNs.iptMgr
+
+	// Without policies the chains aren't needed, as when the last policy is deleted.
+	if !npMgr.hasPolicies() {
+		if err := iptMgr.UninitNpmChains(); err != nil {
+			return err
+		}
+		npMgr.isAzureNpmChainCreated = false
+
+		return nil
+	}
+
+	iptablesSave, err := iptMgr.List()
+	if err != nil {
+		return err
+	}
+
+	var entries []*iptm.IptEntry
+	for _, npObj := range allNs.npMap {
+		_, _, _, policyEntries := parsePolicy(npObj)
+		entries = append(entries, policyEntries...)
+	}
+
+	stale := getStaleRules(iptablesSave, entries)
+	for _, entry := range stale {
+		log.Printf("Removing stale iptables rule: %+v\n", entry)
+		if err = iptMgr.Delete(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncAdminPolicies applies the admin network policies and the baseline admin network policy of the cluster.
+func (npMgr *NetworkPolicyManager) syncAdminPolicies() error {
+	if isEbpfDataplane() {
+		return nil
+	}
+
+	anps, banp, err := listAdminPolicies(npMgr.clientset)
+	if err != nil {
+		return err
+	}
+
+	adminPolicies := parseAdminPolicies(anps, banp)
+
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	if adminPolicies.hasRules() {
+		if err = npMgr.initNpmChains(); err != nil {
+			return err
+		}
+
+		// The iptables rules refer to the ipsets, so they have to exist first.
+		if err = npMgr.createPolicySets(adminPolicies.podSets, adminPolicies.nsLists, adminPolicies.ipBlockSets); err != nil {
+			return newPolicyApplyError(policyFailureIpset, err)
+		}
+	}
+
+	npMgr.adminPolicies = adminPolicies
+
+	if !npMgr.isAzureNpmChainCreated {
+		return nil
+	}
+
+	// Without policies the chains aren't needed, as when the last policy is deleted.
+	if !npMgr.hasPolicies() {
+		if err = npMgr.nsMap[util.KubeAllNamespacesFlag].iptMgr.UninitNpmChains(); err != nil {
+			return newPolicyApplyError(policyFailureIptables, err)
+		}
+		npMgr.isAzureNpmChainCreated = false
+
+		return nil
+	}
+
+	synced, err := npMgr.syncAdminChains()
+	if synced > 0 {
+		log.Printf("Synced %d admin network policy chains", synced)
+	}
+
+	if err != nil {
+		return newPolicyApplyError(policyFailureIptables, err)
+	}
+
+	return nil
+}
+
+// syncAdminChains rebuilds the admin network policy chains whose rules differ from the applied admin network policies.
+// It returns the number of rebuilt chains. Chains are left as they are until the admin network policies are first synced.
+// This function should only be called when npMgr is locked.
+func (npMgr *NetworkPolicyManager) syncAdminChains() (int, error) {
+	if npMgr.adminPolicies == nil {
+		return 0, nil
+	}
+
+	iptMgr := npMgr.nsMap[util.KubeAllNamespacesFlag].iptMgr
+
+	iptablesSave, err := iptMgr.List()
+	if err != nil {
+		return 0, err
+	}
+
+	var synced int
+	for _, chain := range adminPolicyChains {
+		entries := npMgr.adminPolicies.chains[chain]
+		if isChainInSync(iptablesSave, chain, entries) {
+			continue
+		}
+
+		synced++
+		if err = iptMgr.SyncChain(chain, entries); err != nil {
+			return synced, err
+		}
+	}
+
+	return synced, nil
+}
+
+// syncFQDNs resolves the domain names of the applied network policies, and updates their ipsets
+// with the IPv4 addresses of the answers.
+func (npMgr *NetworkPolicyManager) syncFQDNs() error {
+	if isEbpfDataplane() {
+		return nil
+	}
+
+	npMgr.Lock()
+	var fqdns []string
+	for _, npObj := range npMgr.nsMap[util.KubeAllNamespacesFlag].npMap {
+		fqdns = append(fqdns, getPolicyFQDNs(npObj)...)
+	}
+	npMgr.Unlock()
+
+	// Resolve the names without holding the lock, as lookups may be slow.
+	answers := make(map[string][]string)
+	for _, fqdn := range util.UniqueStrSlice(fqdns) {
+		addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), fqdn)
+		if err != nil {
+			log.Printf("Failed to resolve %s: %v", fqdn, err)
+		}
+
+		answers[fqdn] = nil
+		for _, addr := range addrs {
+			if addr.IP.To4() != nil {
+				answers[fqdn] = append(answers[fqdn], addr.IP.String())
+			}
+		}
+	}
+
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	added, removed := updateFQDNAddresses(npMgr.fqdnAddresses, answers, time.Now(), fqdnAddressRetention)
+
+	ipsMgr := npMgr.nsMap[util.KubeAllNamespacesFlag].ipsMgr
+	ipsMgr.BeginBatch()
+	defer ipsMgr.CommitBatch()
+
+	for fqdn, addresses := range added {
+		for _, address := range addresses {
+			log.Printf("Adding address %s of %s to its ipset", address, fqdn)
+			if err := ipsMgr.AddToSet(util.FQDNIPSetPrefix+fqdn, address); err != nil {
+				return err
+			}
+		}
+	}
+
+	for fqdn, addresses := range removed {
+		for _, address := range addresses {
+			log.Printf("Removing expired address %s of %s from its ipset", address, fqdn)
+			if err := ipsMgr.DeleteFromSet(util.FQDNIPSetPrefix+fqdn, address); err != nil {
+				return err
+			}
+		}
+	}
+
+	return ipsMgr.CommitBatch()
+}
+
+// syncPolicyHits aggregates the counters of the iptables rules by the applied network policies producing them.
+func (npMgr *NetworkPolicyManager) syncPolicyHits() error {
+	if isEbpfDataplane() {
+		return nil
+	}
+
+	npMgr.Lock()
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+	var policies []*networkingv1.NetworkPolicy
+	for _, npObj := range allNs.npMap {
+		policies = append(policies, npObj)
+	}
+	npMgr.Unlock()
+
+	iptablesSave, err := allNs.iptMgr.ListCounters()
+	if err != nil {
+		return err
+	}
+
+	hits := getPolicyHits(policies, iptablesSave)
+
+	npMgr.Lock()
+	npMgr.setPolicyHits(hits)
+	npMgr.Unlock()
+
+	return nil
+}
+
+// RunAuditLog logs a record of each packet the network policies in audit mode would have dropped, until stopCh is closed.
+func (npMgr *NetworkPolicyManager) RunAuditLog(stopCh <-chan struct{}) {
+	if isEbpfDataplane() {
+		return
+	}
+
+	packets := make(chan netlink.NflogPacket, auditLogBufferSize)
+	if err := netlink.SubscribeNflog(auditNflogGroup, packets, stopCh); err != nil {
+		log.Errorf("Failed to subscribe to audited packets: %v", err)
+		return
+	}
+
+	for packet := range packets {
+		record, err := parseAuditPacket(packet.Prefix, packet.Payload)
+		if err != nil {
+			log.Printf("Ignoring audited packet: %v", err)
+			continue
+		}
+
+		npMgr.Lock()
+		record.Policy = npMgr.getAuditedPolicy(record.policyHash)
+		npMgr.Unlock()
+
+		b, _ := json.Marshal(record)
+		log.Printf("[Azure-NPM] Audit: %s", b)
+	}
+}
+
+// syncPod applies the difference between a pod in the informer cache and the pod applied to the ipsets.
+func (npMgr *NetworkPolicyManager) syncPod(key string) error {
+	ns, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		log.Printf("Invalid pod key %s: %v", key, err)
+		return nil
+	}
+
+	podObj, err := npMgr.podInformer.Lister().Pods(ns).Get(name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	npMgr.Lock()
+	appliedPodObj := npMgr.nsMap[util.KubeAllNamespacesFlag].getAppliedPod(key)
+	npMgr.Unlock()
+
+	switch {
+	case podObj == nil || isBeingDeleted(podObj.ObjectMeta):
+		if appliedPodObj == nil {
+			return nil
+		}
+		return npMgr.DeletePod(appliedPodObj)
+	case appliedPodObj == nil:
+		return npMgr.AddPod(podObj)
+	case appliedPodObj.ObjectMeta.UID != podObj.ObjectMeta.UID:
+		// The pod was recreated with the same name, its old entries may differ from the new ones.
+		if err := npMgr.DeletePod(appliedPodObj); err != nil {
+			return err
+		}
+		return npMgr.AddPod(podObj)
+	case appliedPodObj.ObjectMeta.ResourceVersion == podObj.ObjectMeta.ResourceVersion:
+		return nil
+	default:
+		return npMgr.UpdatePod(appliedPodObj, podObj)
+	}
+}
+
+// syncNamespace applies the difference between a namespace in the informer cache and the namespace applied to the ipsets.
+func (npMgr *NetworkPolicyManager) syncNamespace(key string) error {
+	nsObj, err := npMgr.nsInformer.Lister().Get(key)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	npMgr.Lock()
+	appliedNsObj := npMgr.nsMap[util.KubeAllNamespacesFlag].nsObjMap[key]
+	npMgr.Unlock()
+
+	switch {
+	case nsObj == nil || isBeingDeleted(nsObj.ObjectMeta):
+		if appliedNsObj == nil {
+			return nil
+		}
+		err = npMgr.DeleteNamespace(appliedNsObj)
+		nsObj = nil
+	case appliedNsObj == nil:
+		err = npMgr.AddNamespace(nsObj)
+	case appliedNsObj.ObjectMeta.ResourceVersion == nsObj.ObjectMeta.ResourceVersion:
+		return nil
+	default:
+		err = npMgr.UpdateNamespace(appliedNsObj, nsObj)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return npMgr.syncDefaultDeny(key, nsObj)
+}
+
+// syncDefaultDeny applies the difference between the implicit default deny policy of a namespace and the one
+// applied to iptables. nsObj is nil for deleted namespaces.
+func (npMgr *NetworkPolicyManager) syncDefaultDeny(ns string, nsObj *corev1.Namespace) error {
+	var npObj *networkingv1.NetworkPolicy
+	if nsObj != nil {
+		npObj = getDefaultDenyPolicy(nsObj)
+	}
+
+	key := ns + "/" + defaultDenyPolicyName
+	npMgr.Lock()
+	appliedNpObj := npMgr.nsMap[util.KubeAllNamespacesFlag].npMap[key]
+	npMgr.Unlock()
+
+	switch {
+	case npObj == nil:
+		if appliedNpObj == nil {
+			return nil
+		}
+		log.Printf("Removing the default deny policy of namespace %s", ns)
+		return npMgr.DeleteNetworkPolicy(appliedNpObj)
+	case appliedNpObj == nil:
+		log.Printf("Applying the default deny policy of namespace %s", ns)
+		return npMgr.AddNetworkPolicy(npObj)
+	case reflect.DeepEqual(appliedNpObj.Spec, npObj.Spec):
+		return nil
+	default:
+		return npMgr.UpdateNetworkPolicy(appliedNpObj, npObj)
+	}
+}
+
+// syncNetworkPolicy applies the difference between a network policy in the informer cache and the policy applied to iptables.
+func (npMgr *NetworkPolicyManager) syncNetworkPolicy(key string) error {
+	ns, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		log.Printf("Invalid network policy key %s: %v", key, err)
+		return nil
+	}
+
+	npObj, err := npMgr.npInformer.Lister().NetworkPolicies(ns).Get(name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	npMgr.Lock()
+	appliedNpObj := npMgr.nsMap[util.KubeAllNamespacesFlag].npMap[key]
+	npMgr.Unlock()
+
+	var operation string
+	var selectorErr error
+
+	switch {
+	case npObj == nil || isBeingDeleted(npObj.ObjectMeta):
+		if appliedNpObj == nil {
+			return nil
+		}
+		operation = util.DeleteNetworkPolicyEvent
+		err = npMgr.DeleteNetworkPolicy(appliedNpObj)
+	case appliedNpObj != nil && appliedNpObj.ObjectMeta.ResourceVersion == npObj.ObjectMeta.ResourceVersion:
+		return nil
+	default:
+		// Policies with selectors that can't be resolved are still applied with the match labels they have,
+		// but reported as failed. Retrying wouldn't resolve them.
+		if selectorErr = validatePolicySelectors(npObj); selectorErr != nil {
+			log.Printf("Network policy %s has unresolvable selectors: %v", key, selectorErr)
+		}
+
+		if appliedNpObj == nil {
+			operation = util.AddNetworkPolicyEvent
+			err = npMgr.AddNetworkPolicy(npObj)
+		} else {
+			operation = util.UpdateNetworkPolicyEvent
+			err = npMgr.UpdateNetworkPolicy(appliedNpObj, npObj)
+		}
+	}
+
+	if err != nil {
+		npMgr.reportPolicyApply(key, operation, err)
+	} else {
+		npMgr.reportPolicyApply(key, operation, selectorErr)
+	}
+
+	return err
+}
+
+// verifyDataplane recomputes the iptables rules of the network policies in the informer cache,
+// compares them and the managed ipsets with the kernel state, and repairs any difference.
+// Policy chains with missing or unexpected rules are rebuilt. It returns the number of repaired entries.
+func (npMgr *NetworkPolicyManager) verifyDataplane() (int, error) {
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	if isEbpfDataplane() {
+		return npMgr.verifyEbpfDataplane()
+	}
+
+	if !npMgr.isAzureNpmChainCreated {
+		return 0, nil
+	}
+
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+
+	drift, err := allNs.ipsMgr.Verify()
+	if err != nil {
+		log.Printf("Error verifying ipsets.\n")
+		return drift, err
+	}
+
+	// Recreate the AZURE-NPM chains and their default rules if they were removed.
+	if err = allNs.iptMgr.InitNpmChains(); err != nil {
+		log.Printf("Error verifying azure-npm chains.\n")
+		return drift, err
+	}
+
+	policies, err := npMgr.npInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return drift, err
+	}
+
+	for _, nsObj := range allNs.nsObjMap {
+		if npObj := getDefaultDenyPolicy(nsObj); npObj != nil {
+			policies = append(policies, npObj)
+		}
+	}
+
+	var entries []*iptm.IptEntry
+	for _, npObj := range policies {
+		_, _, _, policyEntries := parsePolicy(npObj)
+		entries = append(entries, policyEntries...)
+	}
+
+	iptablesSave, err := allNs.iptMgr.List()
+	if err != nil {
+		return drift, err
+	}
+
+	drifted, iptDrift := getDriftedChains(iptablesSave, entries)
+	drift += iptDrift
+
+	for _, chain := range policyChains {
+		chainEntries, ok := drifted[chain]
+		if !ok {
+			continue
+		}
+
+		log.Printf("Rebuilding iptables chain %s that drifted from the network policies\n", chain)
+		if err = allNs.iptMgr.SyncChain(chain, chainEntries); err != nil {
+			return drift, err
+		}
+	}
+
+	adminDrift, err := npMgr.syncAdminChains()
+	drift += adminDrift
+
+	return drift, err
+}
@@ -0,0 +1,229 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Microsoft/hcsshim"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ebpfDataplane is unused, as network policies are enforced with HNS ACL policies.
+type ebpfDataplane struct{}
+
+// Nodes that don't enforce the policies HNS ACL policies don't support.
+const unsupportedPolicyNodes = "Windows nodes"
+
+// addEventHandlers queues an ACL resync on any change to pods, namespaces or network policies.
+func (npMgr *NetworkPolicyManager) addEventHandlers() {
+	npMgr.addResyncHandlers()
+}
+
+// startWorkers starts programming the ACL policies on queued changes.
+func (npMgr *NetworkPolicyManager) startWorkers(stopCh <-chan struct{}) {
+	for _, q := range []*workQueue{npMgr.podQueue, npMgr.nsQueue, npMgr.npQueue} {
+		startWorker(q, npMgr.syncEndpointACLs, stopCh)
+	}
+}
+
+// syncAdminPolicies reports the admin network policies of the cluster, as the ACL policies don't support them.
+func (npMgr *NetworkPolicyManager) syncAdminPolicies() error {
+	anps, banp, err := listAdminPolicies(npMgr.clientset)
+	if err != nil {
+		return err
+	}
+
+	count := len(anps)
+	if banp != nil {
+		count++
+	}
+
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	npMgr.reportUnsupportedPolicies("admin network policies", count)
+
+	return nil
+}
+
+// syncFQDNs reports the network policies allowing egress traffic to domain names, as the ACL policies don't support them.
+func (npMgr *NetworkPolicyManager) syncFQDNs() error {
+	policies, err := npMgr.npInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	var count int
+	for _, npObj := range policies {
+		if len(getPolicyFQDNs(npObj)) > 0 {
+			count++
+		}
+	}
+
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	npMgr.reportUnsupportedPolicies("network policies with domain names", count)
+
+	return nil
+}
+
+// syncPolicyHits is a no-op, as the ACL policies have no counters.
+func (npMgr *NetworkPolicyManager) syncPolicyHits() error {
+	return nil
+}
+
+// RunAuditLog is a no-op, as the ACL policies don't support audit mode.
+func (npMgr *NetworkPolicyManager) RunAuditLog(stopCh <-chan struct{}) {
+}
+
+// reconcileDataplane programs the ACL policies of the initial cluster state. Endpoints keep the policies
+// a previous NPM instance programmed until they are replaced, and stale ones are removed along the way.
+func (npMgr *NetworkPolicyManager) reconcileDataplane() {
+	for _, q := range []*workQueue{npMgr.podQueue, npMgr.nsQueue, npMgr.npQueue} {
+		drainQueue(q, npMgr.syncEndpointACLs)
+	}
+}
+
+// syncEndpointACLs programs the ACL policies enforcing network policies on all local pod endpoints.
+func (npMgr *NetworkPolicyManager) syncEndpointACLs(eventMsg string) error {
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	_, err := npMgr.programEndpointACLs()
+	if reportErr := npMgr.UpdateAndSendReport(err, eventMsg); reportErr != nil {
+		log.Printf("Error sending NPM telemetry report")
+	}
+
+	return err
+}
+
+// verifyDataplane reprograms the local pod endpoints whose ACL policies drifted from the network policies.
+// It returns the number of repaired endpoints.
+func (npMgr *NetworkPolicyManager) verifyDataplane() (int, error) {
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	return npMgr.programEndpointACLs()
+}
+
+// programEndpointACLs applies the ACL policies of all local pod endpoints.
+// It returns the number of endpoints whose ACL policies changed.
+func (npMgr *NetworkPolicyManager) programEndpointACLs() (int, error) {
+	var updated int
+
+	state, err := npMgr.getPolicyState()
+	if err != nil {
+		log.Printf("Error listing cluster objects: %v", err)
+		return 0, err
+	}
+
+	npMgr.clusterState.PodCount = len(state.pods)
+	npMgr.clusterState.NsCount = len(state.namespaces)
+	npMgr.clusterState.NwPolicyCount = len(state.policies)
+	metrics.NumPolicies.Set(len(state.policies))
+
+	var exceptCount, portRangeCount int
+	for _, npObj := range state.policies {
+		if hasExceptRanges(npObj) {
+			exceptCount++
+		}
+
+		if hasPortRanges(npObj) {
+			portRangeCount++
+		}
+	}
+	npMgr.reportUnsupportedPolicies("network policies with ipBlock except ranges", exceptCount)
+	npMgr.reportUnsupportedPolicies("network policies with port ranges", portRangeCount)
+
+	endpoints, err := hcsshim.HNSListEndpointRequest()
+	if err != nil {
+		log.Printf("Error listing HNS endpoints: %v", err)
+		return 0, err
+	}
+
+	podsByIP := make(map[string]*corev1.Pod)
+	for _, podObj := range state.pods {
+		if isPolicyEnforced(podObj) {
+			podsByIP[podObj.Status.PodIP] = podObj
+		}
+	}
+
+	for i := range endpoints {
+		endpoint := &endpoints[i]
+		if endpoint.IsRemoteEndpoint || endpoint.IPAddress == nil {
+			continue
+		}
+
+		podObj, ok := podsByIP[endpoint.IPAddress.String()]
+		if !ok {
+			continue
+		}
+
+		changed, applyErr := applyEndpointACLs(endpoint, state.getEndpointACLs(podObj))
+		if applyErr != nil {
+			log.Printf("Error applying ACL policies to endpoint %s of pod %s/%s: %v",
+				endpoint.Id, podObj.ObjectMeta.Namespace, podObj.ObjectMeta.Name, applyErr)
+			err = applyErr
+		}
+
+		if changed {
+			updated++
+		}
+	}
+
+	return updated, err
+}
+
+// applyEndpointACLs replaces the ACL policies of an HNS endpoint, leaving its other policies in place.
+// It returns whether the ACL policies changed.
+func applyEndpointACLs(endpoint *hcsshim.HNSEndpoint, rules []*aclRule) (bool, error) {
+	var (
+		policies    []json.RawMessage
+		oldACLs     []hcsshim.ACLPolicy
+		newACLs     []hcsshim.ACLPolicy
+		aclPolicies []*hcsshim.ACLPolicy
+	)
+
+	for _, policy := range endpoint.Policies {
+		var acl hcsshim.ACLPolicy
+		if err := json.Unmarshal(policy, &acl); err == nil && acl.Type == hcsshim.ACL {
+			acl.Id = ""
+			oldACLs = append(oldACLs, acl)
+			continue
+		}
+
+		policies = append(policies, policy)
+	}
+
+	for _, rule := range rules {
+		aclPolicy := &hcsshim.ACLPolicy{
+			Type:            hcsshim.ACL,
+			Action:          hcsshim.ActionType(rule.Action),
+			Direction:       hcsshim.DirectionType(rule.Direction),
+			Protocols:       rule.Protocols,
+			LocalPorts:      rule.LocalPorts,
+			RemotePorts:     rule.RemotePorts,
+			RemoteAddresses: rule.RemoteAddresses,
+			RuleType:        hcsshim.Switch,
+			Priority:        rule.Priority,
+		}
+		aclPolicies = append(aclPolicies, aclPolicy)
+		newACLs = append(newACLs, *aclPolicy)
+	}
+
+	// Avoid reprogramming endpoints whose ACLs didn't change.
+	if reflect.DeepEqual(oldACLs, newACLs) {
+		return false, nil
+	}
+
+	endpoint.Policies = policies
+
+	return true, endpoint.ApplyACLPolicy(aclPolicies...)
+}
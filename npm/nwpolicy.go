@@ -3,111 +3,325 @@
 package npm
 
 import (
+	"strings"
+	"time"
+
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/metrics"
 	"github.com/Azure/azure-container-networking/npm/util"
 	networkingv1 "k8s.io/api/networking/v1"
 )
 
-// AddNetworkPolicy handles adding network policy to iptables.
-func (npMgr *NetworkPolicyManager) AddNetworkPolicy(npObj *networkingv1.NetworkPolicy) error {
-	npMgr.Lock()
-	defer npMgr.Unlock()
+// diffIptEntries returns the entries of a that aren't in b.
+func diffIptEntries(a []*iptm.IptEntry, b []*iptm.IptEntry) []*iptm.IptEntry {
+	var diff []*iptm.IptEntry
 
-	var err error
+	inB := make(map[string]bool)
+	for _, entry := range b {
+		inB[entry.Chain+" "+strings.Join(entry.Specs, " ")] = true
+	}
 
-	defer func() {
-		if err = npMgr.UpdateAndSendReport(err, util.AddNetworkPolicyEvent); err != nil {
-			log.Printf("Error sending NPM telemetry report")
+	for _, entry := range a {
+		if !inB[entry.Chain+" "+strings.Join(entry.Specs, " ")] {
+			diff = append(diff, entry)
 		}
-	}()
+	}
 
-	npNs, npName := npObj.ObjectMeta.Namespace, npObj.ObjectMeta.Name
-	log.Printf("NETWORK POLICY CREATING: %s/%s\n", npNs, npName)
+	return diff
+}
 
-	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+// The AZURE-NPM chains holding the rules of network policies.
+var policyChains = []string{
+	util.IptablesAzureIngressPortChain,
+	util.IptablesAzureIngressFromChain,
+	util.IptablesAzureEgressPortChain,
+	util.IptablesAzureEgressToChain,
+	util.IptablesAzureTargetSetsChain,
+}
 
-	if !npMgr.isAzureNpmChainCreated {
-		if err = allNs.ipsMgr.CreateSet(util.KubeSystemFlag); err != nil {
-			log.Printf("Error initialize kube-system ipset.\n")
-			return err
+// getBanpJumpEntry returns the jump to the baseline admin network policy, which is part of the chains
+// rather than of any policy.
+func getBanpJumpEntry() *iptm.IptEntry {
+	return &iptm.IptEntry{
+		Chain: util.IptablesAzureTargetSetsChain,
+		Specs: []string{util.IptablesJumpFlag, util.IptablesAzureBanpChain},
+	}
+}
+
+// getStaleRules returns the rules of the AZURE-NPM policy chains in the iptables-save output
+// that aren't among the given entries, e.g. rules of policies deleted while NPM wasn't running.
+func getStaleRules(iptablesSave string, entries []*iptm.IptEntry) []*iptm.IptEntry {
+	var stale []*iptm.IptEntry
+
+	expected := make(map[string]bool)
+	for _, entry := range entries {
+		expected[parseDebugRule(entry.Chain, entry.Specs).key()] = true
+	}
+
+	banpJump := getBanpJumpEntry()
+	expected[parseDebugRule(banpJump.Chain, banpJump.Specs).key()] = true
+
+	chains, _ := parseIptablesSave(iptablesSave)
+	for _, chain := range policyChains {
+		for _, rule := range chains[chain] {
+			if expected[rule.key()] {
+				continue
+			}
+
+			// Rules listed by iptables-save can be deleted with the same specs.
+			stale = append(stale, &iptm.IptEntry{
+				Chain: chain,
+				Specs: strings.Fields(rule.text)[2:],
+			})
 		}
+	}
 
-		if err = allNs.iptMgr.InitNpmChains(); err != nil {
-			log.Printf("Error initialize azure-npm chains.\n")
-			return err
+	return stale
+}
+
+// getDriftedChains returns the entries of the AZURE-NPM policy chains whose rules in the iptables-save output
+// differ from the given entries, and the number of missing and unexpected rules in them. Policy rules only
+// mark, log or drop packets, so their order doesn't matter, except for the jump to the baseline admin
+// network policy, which must stay last.
+func getDriftedChains(iptablesSave string, entries []*iptm.IptEntry) (map[string][]*iptm.IptEntry, int) {
+	expected := make(map[string][]*iptm.IptEntry)
+	seen := make(map[string]bool)
+	for _, entry := range append(entries, getBanpJumpEntry()) {
+		key := parseDebugRule(entry.Chain, entry.Specs).key()
+		if !seen[key] {
+			seen[key] = true
+			expected[entry.Chain] = append(expected[entry.Chain], entry)
 		}
+	}
+
+	var drift int
+	drifted := make(map[string][]*iptm.IptEntry)
+	chains, _ := parseIptablesSave(iptablesSave)
 
-		npMgr.isAzureNpmChainCreated = true
+	for _, chain := range policyChains {
+		counts := make(map[string]int)
+		for _, entry := range expected[chain] {
+			counts[parseDebugRule(chain, entry.Specs).key()]++
+		}
+
+		rules := chains[chain]
+		for _, rule := range rules {
+			counts[rule.key()]--
+		}
+
+		var chainDrift int
+		for _, count := range counts {
+			if count < 0 {
+				count = -count
+			}
+			chainDrift += count
+		}
+
+		if chainDrift == 0 && chain == util.IptablesAzureTargetSetsChain {
+			banpJump := getBanpJumpEntry()
+			if last := rules[len(rules)-1]; last.key() != parseDebugRule(chain, banpJump.Specs).key() {
+				chainDrift++
+			}
+		}
+
+		if chainDrift > 0 {
+			drifted[chain] = expected[chain]
+			drift += chainDrift
+		}
 	}
 
-	podSets, nsLists, iptEntries := parsePolicy(npObj)
+	return drifted, drift
+}
+
+// initNpmChains creates the kube-system ipset and the AZURE-NPM chains when the first policy is applied.
+// This function should only be called when npMgr is locked.
+func (npMgr *NetworkPolicyManager) initNpmChains() error {
+	if npMgr.isAzureNpmChainCreated {
+		return nil
+	}
+
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+
+	if err := allNs.ipsMgr.CreateSet(util.KubeSystemFlag); err != nil {
+		log.Printf("Error initialize kube-system ipset.\n")
+		return newPolicyApplyError(policyFailureIpset, err)
+	}
+
+	if err := allNs.iptMgr.InitNpmChains(); err != nil {
+		log.Printf("Error initialize azure-npm chains.\n")
+		return newPolicyApplyError(policyFailureIptables, err)
+	}
+
+	npMgr.isAzureNpmChainCreated = true
+
+	return nil
+}
+
+// hasPolicies checks if any network policy or admin network policy rule is applied, and so needs the AZURE-NPM chains.
+// This function should only be called when npMgr is locked.
+func (npMgr *NetworkPolicyManager) hasPolicies() bool {
+	return len(npMgr.nsMap[util.KubeAllNamespacesFlag].npMap) > 0 || npMgr.adminPolicies.hasRules()
+}
+
+// createPolicySets creates the ipsets and ipset lists a network policy refers to.
+// This function should only be called when npMgr is locked.
+func (npMgr *NetworkPolicyManager) createPolicySets(podSets []string, nsLists []string, ipBlockSets map[string][]string) error {
+	ipsMgr := npMgr.nsMap[util.KubeAllNamespacesFlag].ipsMgr
+	ipsMgr.BeginBatch()
+	defer ipsMgr.CommitBatch()
 
-	ipsMgr := allNs.ipsMgr
 	for _, set := range podSets {
-		if err = ipsMgr.CreateSet(set); err != nil {
-			log.Printf("Error creating ipset %s-%s\n", npNs, set)
+		if err := ipsMgr.CreateSet(set); err != nil {
+			log.Printf("Error creating ipset %s\n", set)
 			return err
 		}
 	}
 
 	for _, list := range nsLists {
-		if err = ipsMgr.CreateList(list); err != nil {
-			log.Printf("Error creating ipset list %s-%s\n", npNs, list)
+		if err := ipsMgr.CreateList(list); err != nil {
+			log.Printf("Error creating ipset list %s\n", list)
 			return err
 		}
 	}
 
-	if err = npMgr.InitAllNsList(); err != nil {
+	for set, members := range ipBlockSets {
+		if err := ipsMgr.CreateSet(set); err != nil {
+			log.Printf("Error creating ipset %s\n", set)
+			return err
+		}
+
+		for _, member := range members {
+			if err := ipsMgr.AddToSet(set, member); err != nil {
+				log.Printf("Error adding %s to ipset %s\n", member, set)
+				return err
+			}
+		}
+	}
+
+	if err := npMgr.InitAllNsList(); err != nil {
 		log.Printf("Error initializing all-namespace ipset list.\n")
 		return err
 	}
 
+	return ipsMgr.CommitBatch()
+}
+
+// AddNetworkPolicy handles adding network policy to iptables.
+func (npMgr *NetworkPolicyManager) AddNetworkPolicy(npObj *networkingv1.NetworkPolicy) error {
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	var err error
+
+	defer metrics.AddPolicyDuration.ObserveSince(time.Now())
+
+	defer func() {
+		if err = npMgr.UpdateAndSendReport(err, util.AddNetworkPolicyEvent); err != nil {
+			log.Printf("Error sending NPM telemetry report")
+		}
+	}()
+
+	npNs, npName := npObj.ObjectMeta.Namespace, npObj.ObjectMeta.Name
+	log.Printf("NETWORK POLICY CREATING: %s/%s\n", npNs, npName)
+
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+
+	if err = npMgr.initNpmChains(); err != nil {
+		return err
+	}
+
+	podSets, nsLists, ipBlockSets, iptEntries := parsePolicy(npObj)
+
+	// The iptables rules refer to the ipsets, so they have to exist first.
+	if err = npMgr.createPolicySets(podSets, nsLists, ipBlockSets); err != nil {
+		log.Printf("Error applying ipset updates of network policy %s/%s.\n", npNs, npName)
+		return newPolicyApplyError(policyFailureIpset, err)
+	}
+
 	iptMgr := allNs.iptMgr
 	for _, iptEntry := range iptEntries {
 		if err = iptMgr.Add(iptEntry); err != nil {
 			log.Printf("Error applying iptables rule\n. Rule: %+v", iptEntry)
-			return err
+			return newPolicyApplyError(policyFailureIptables, err)
 		}
 	}
 
-	allNs.npMap[npName] = npObj
+	allNs.npMap[getObjectKey(npObj.ObjectMeta)] = npObj
 
 	npMgr.clusterState.NwPolicyCount++
+	metrics.NumPolicies.Set(npMgr.clusterState.NwPolicyCount)
 
-	ns, err := newNs(npNs)
-	if err != nil {
-		log.Printf("Error creating namespace %s\n", npNs)
+	if _, exists := npMgr.nsMap[npNs]; !exists {
+		ns, err := newNs(npNs)
+		if err != nil {
+			log.Printf("Error creating namespace %s\n", npNs)
+		}
+		npMgr.nsMap[npNs] = ns
 	}
-	npMgr.nsMap[npNs] = ns
 
 	return nil
 }
 
 // UpdateNetworkPolicy handles updateing network policy in iptables.
+// Only the iptables rules that differ between the old and new policy are changed.
 func (npMgr *NetworkPolicyManager) UpdateNetworkPolicy(oldNpObj *networkingv1.NetworkPolicy, newNpObj *networkingv1.NetworkPolicy) error {
+	if isBeingDeleted(newNpObj.ObjectMeta) {
+		return npMgr.DeleteNetworkPolicy(oldNpObj)
+	}
+
+	npMgr.Lock()
+	_, isApplied := npMgr.nsMap[util.KubeAllNamespacesFlag].npMap[getObjectKey(oldNpObj.ObjectMeta)]
+	npMgr.Unlock()
+
+	if !isApplied {
+		return npMgr.AddNetworkPolicy(newNpObj)
+	}
+
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
 	var err error
 
+	defer metrics.AddPolicyDuration.ObserveSince(time.Now())
+
 	defer func() {
-		npMgr.Lock()
 		if err = npMgr.UpdateAndSendReport(err, util.UpdateNetworkPolicyEvent); err != nil {
 			log.Printf("Error sending NPM telemetry report")
 		}
-		npMgr.Unlock()
 	}()
 
 	oldNpNs, oldNpName := oldNpObj.ObjectMeta.Namespace, oldNpObj.ObjectMeta.Name
 	log.Printf("NETWORK POLICY UPDATING: %s/%s\n", oldNpNs, oldNpName)
 
-	if err = npMgr.DeleteNetworkPolicy(oldNpObj); err != nil {
-		return err
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+
+	_, _, _, oldEntries := parsePolicy(oldNpObj)
+	podSets, nsLists, ipBlockSets, newEntries := parsePolicy(newNpObj)
+
+	if err = npMgr.createPolicySets(podSets, nsLists, ipBlockSets); err != nil {
+		log.Printf("Error applying ipset updates of network policy %s/%s.\n", oldNpNs, oldNpName)
+		return newPolicyApplyError(policyFailureIpset, err)
 	}
 
-	if newNpObj.ObjectMeta.DeletionTimestamp == nil && newNpObj.ObjectMeta.DeletionGracePeriodSeconds == nil {
-		if err = npMgr.AddNetworkPolicy(newNpObj); err != nil {
-			return err
+	iptMgr := allNs.iptMgr
+	for _, iptEntry := range diffIptEntries(oldEntries, newEntries) {
+		if err = iptMgr.Delete(iptEntry); err != nil {
+			log.Printf("Error applying iptables rule.\n Rule: %+v", iptEntry)
+			return newPolicyApplyError(policyFailureIptables, err)
+		}
+	}
+
+	for _, iptEntry := range diffIptEntries(newEntries, oldEntries) {
+		if err = iptMgr.Add(iptEntry); err != nil {
+			log.Printf("Error applying iptables rule\n. Rule: %+v", iptEntry)
+			return newPolicyApplyError(policyFailureIptables, err)
 		}
 	}
 
+	delete(allNs.npMap, getObjectKey(oldNpObj.ObjectMeta))
+	allNs.npMap[getObjectKey(newNpObj.ObjectMeta)] = newNpObj
+
 	return nil
 }
 
@@ -118,6 +332,8 @@ func (npMgr *NetworkPolicyManager) DeleteNetworkPolicy(npObj *networkingv1.Netwo
 
 	var err error
 
+	defer metrics.DeletePolicyDuration.ObserveSince(time.Now())
+
 	defer func() {
 		if err = npMgr.UpdateAndSendReport(err, util.DeleteNetworkPolicyEvent); err != nil {
 			log.Printf("Error sending NPM telemetry report")
@@ -129,24 +345,25 @@ func (npMgr *NetworkPolicyManager) DeleteNetworkPolicy(npObj *networkingv1.Netwo
 
 	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
 
-	_, _, iptEntries := parsePolicy(npObj)
+	_, _, _, iptEntries := parsePolicy(npObj)
 
 	iptMgr := allNs.iptMgr
 	for _, iptEntry := range iptEntries {
 		if err = iptMgr.Delete(iptEntry); err != nil {
 			log.Printf("Error applying iptables rule.\n Rule: %+v", iptEntry)
-			return err
+			return newPolicyApplyError(policyFailureIptables, err)
 		}
 	}
 
-	delete(allNs.npMap, npName)
+	delete(allNs.npMap, getObjectKey(npObj.ObjectMeta))
 
 	npMgr.clusterState.NwPolicyCount--
+	metrics.NumPolicies.Set(npMgr.clusterState.NwPolicyCount)
 
-	if len(allNs.npMap) == 0 {
+	if !npMgr.hasPolicies() {
 		if err = iptMgr.UninitNpmChains(); err != nil {
 			log.Printf("Error uninitialize azure-npm chains.\n")
-			return err
+			return newPolicyApplyError(policyFailureIptables, err)
 		}
 		npMgr.isAzureNpmChainCreated = false
 	}
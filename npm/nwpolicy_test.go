@@ -3,6 +3,8 @@
 package npm
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/Azure/azure-container-networking/npm/ipsm"
@@ -284,3 +286,162 @@ func TestDeleteNetworkPolicy(t *testing.T) {
 		t.Errorf("TestAddNetworkPolicy failed @ DeleteNetworkPolicy")
 	}
 }
+
+func TestGetStaleRules(t *testing.T) {
+	tcp := corev1.ProtocolTCP
+	port := intstr.FromInt(8080)
+	npObj := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test",
+			Name:      "allow-frontend",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "backend"},
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					Ports: []networkingv1.NetworkPolicyPort{
+						{Protocol: &tcp, Port: &port},
+					},
+					From: []networkingv1.NetworkPolicyPeer{
+						{
+							PodSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"app": "frontend"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	_, _, _, entries := parsePolicy(npObj)
+
+	staleRule := "-A " + util.IptablesAzureTargetSetsChain + " -m set --match-set " +
+		util.GetHashedName("all-namespace-app:deleted") + " dst -m mark ! --mark 0x2000/0x2000 -j DROP"
+	lines := []string{
+		"*filter",
+		"-A " + util.IptablesAzureChain + " -j " + util.IptablesAzureTargetSetsChain,
+		staleRule,
+	}
+	for _, entry := range entries {
+		lines = append(lines, "-A "+entry.Chain+" "+strings.Join(entry.Specs, " "))
+	}
+	lines = append(lines, "COMMIT")
+
+	stale := getStaleRules(strings.Join(lines, "\n"), entries)
+	if len(stale) != 1 {
+		t.Fatalf("TestGetStaleRules failed @ getStaleRules, expected 1 stale rule, got %+v", stale)
+	}
+
+	if got := "-A " + stale[0].Chain + " " + strings.Join(stale[0].Specs, " "); got != staleRule {
+		t.Errorf("TestGetStaleRules failed @ getStaleRules, expected %s, got %s", staleRule, got)
+	}
+}
+
+func TestGetDriftedChains(t *testing.T) {
+	tcp := corev1.ProtocolTCP
+	port := intstr.FromInt(8080)
+	npObj := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test",
+			Name:      "allow-frontend",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "backend"},
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					Ports: []networkingv1.NetworkPolicyPort{
+						{Protocol: &tcp, Port: &port},
+					},
+					From: []networkingv1.NetworkPolicyPeer{
+						{
+							PodSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"app": "frontend"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	_, _, _, entries := parsePolicy(npObj)
+
+	rules := make(map[string][]string)
+	for _, entry := range entries {
+		rules[entry.Chain] = append(rules[entry.Chain], "-A "+entry.Chain+" "+strings.Join(entry.Specs, " "))
+	}
+
+	banpJump := "-A " + util.IptablesAzureTargetSetsChain + " -j " + util.IptablesAzureBanpChain
+	staleRule := "-A " + util.IptablesAzureTargetSetsChain + " -m set --match-set " +
+		util.GetHashedName("all-namespace-app:deleted") + " dst -m mark ! --mark 0x2000/0x2000 -j DROP"
+
+	save := func(chains ...[]string) string {
+		lines := []string{"*filter"}
+		for _, chain := range chains {
+			lines = append(lines, chain...)
+		}
+		return strings.Join(append(lines, "COMMIT"), "\n")
+	}
+
+	ingressPort, ingressFrom, targetSets := rules[util.IptablesAzureIngressPortChain], rules[util.IptablesAzureIngressFromChain], rules[util.IptablesAzureTargetSetsChain]
+
+	drifted, drift := getDriftedChains(save(ingressPort, ingressFrom, targetSets, []string{banpJump}), entries)
+	if len(drifted) != 0 || drift != 0 {
+		t.Errorf("TestGetDriftedChains failed @ getDriftedChains, expected no drift, got %d rules in %v", drift, drifted)
+	}
+
+	// A missing rule and a stale rule.
+	drifted, drift = getDriftedChains(save(ingressPort, targetSets, []string{staleRule, banpJump}), entries)
+	if drift != 2 || len(drifted) != 2 {
+		t.Errorf("TestGetDriftedChains failed @ getDriftedChains, expected 2 drifted rules in 2 chains, got %d rules in %v", drift, drifted)
+	}
+
+	if _, ok := drifted[util.IptablesAzureIngressFromChain]; !ok {
+		t.Errorf("TestGetDriftedChains failed @ getDriftedChains, chain with a missing rule isn't rebuilt")
+	}
+
+	// Rebuilt chains end with the jump to the baseline admin network policy.
+	if chain := drifted[util.IptablesAzureTargetSetsChain]; len(chain) != 2 || "-A "+chain[1].Chain+" "+strings.Join(chain[1].Specs, " ") != banpJump {
+		t.Errorf("TestGetDriftedChains failed @ getDriftedChains, unexpected rebuilt chain %+v", chain)
+	}
+
+	// Rules ahead of the baseline admin network policy jump.
+	drifted, drift = getDriftedChains(save(ingressPort, ingressFrom, []string{banpJump}, targetSets), entries)
+	if _, ok := drifted[util.IptablesAzureTargetSetsChain]; drift != 1 || !ok {
+		t.Errorf("TestGetDriftedChains failed @ getDriftedChains, expected the misordered chain to drift, got %d rules in %v", drift, drifted)
+	}
+}
+
+func TestPolicyFailureCategory(t *testing.T) {
+	if category := getPolicyFailureCategory(newPolicyApplyError(policyFailureIpset, fmt.Errorf("ipset failed"))); category != policyFailureIpset {
+		t.Errorf("Expected ipset failure, got %s", category)
+	}
+
+	if category := getPolicyFailureCategory(fmt.Errorf("failed")); category != policyFailureUnknown {
+		t.Errorf("Expected unknown failure, got %s", category)
+	}
+
+	npObj := &networkingv1.NetworkPolicy{
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: "Matches", Values: []string{"frontend"}},
+				},
+			},
+		},
+	}
+
+	if err := validatePolicySelectors(npObj); getPolicyFailureCategory(err) != policyFailureSelector {
+		t.Errorf("Expected selector failure, got %v", err)
+	}
+
+	npObj.Spec.PodSelector = metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}
+	if err := validatePolicySelectors(npObj); err != nil {
+		t.Errorf("Expected valid selectors, got %v", err)
+	}
+}
@@ -4,31 +4,119 @@ package npm
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/npm/iptm"
 	"github.com/Azure/azure-container-networking/npm/util"
 	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // azureNpmPrefix defines prefix for ipset.
 const azureNpmPrefix string = "azure-npm-"
 
 type portsInfo struct {
-	protocol string
-	port     string
+	protocol  string
+	port      string // A port, or a first:last port range.
+	namedPort string
 }
 
-func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPolicyIngressRule) ([]string, []string, []*iptm.IptEntry) {
+// getPortSpecs returns the iptables specs matching the destination port of a policy port rule.
+// Named ports are resolved through the ipset of the pods exposing a container port under that name.
+// Port rules without a port match all ports of the protocol.
+func getPortSpecs(portInfo *portsInfo) []string {
+	if len(portInfo.namedPort) > 0 {
+		return []string{
+			util.IptablesProtFlag,
+			portInfo.protocol,
+			util.IptablesMatchFlag,
+			util.IptablesSetFlag,
+			util.IptablesMatchSetFlag,
+			util.GetHashedName(util.NamedPortIPSetPrefix + portInfo.namedPort),
+			util.IptablesDstDstFlag,
+		}
+	}
+
+	if len(portInfo.port) == 0 {
+		return []string{
+			util.IptablesProtFlag,
+			portInfo.protocol,
+		}
+	}
+
+	return []string{
+		util.IptablesProtFlag,
+		portInfo.protocol,
+		util.IptablesDstPortFlag,
+		portInfo.port,
+	}
+}
+
+// getPortsInfo returns the protocol and port of a policy port rule.
+// The protocol defaults to TCP, and iptables and ipset take it in lower case, e.g. tcp, udp or sctp.
+// Rules with an endPort match the range of ports from port to endPort, inclusive.
+func getPortsInfo(portRule networkingv1.NetworkPolicyPort) *portsInfo {
+	protocol := util.KubeProtocolTCP
+	if portRule.Protocol != nil {
+		protocol = string(*portRule.Protocol)
+	}
+
+	portInfo := &portsInfo{
+		protocol: strings.ToLower(protocol),
+	}
+
+	switch {
+	case portRule.Port == nil:
+	case portRule.Port.Type == intstr.String:
+		portInfo.namedPort = portRule.Port.StrVal
+	case portRule.EndPort != nil && *portRule.EndPort > portRule.Port.IntVal:
+		portInfo.port = fmt.Sprintf("%d:%d", portRule.Port.IntVal, *portRule.EndPort)
+	default:
+		portInfo.port = fmt.Sprint(portRule.Port.IntVal)
+	}
+
+	return portInfo
+}
+
+// getIPBlockIpset returns the name and members of the ipset matching an ipBlock peer.
+// Excepted ranges are added as nomatch members so addresses in them don't match the set.
+func getIPBlockIpset(ipBlock *networkingv1.IPBlock) (string, []string) {
+	var members []string
+
+	setName := util.IPBlockIPSetPrefix + ipBlock.CIDR
+	if len(ipBlock.Except) > 0 {
+		setName += "-except-" + strings.Join(ipBlock.Except, ",")
+	}
+
+	// hash:net sets don't accept zero length prefixes.
+	switch ipBlock.CIDR {
+	case util.IPv4AnyCIDR:
+		members = append(members, util.IPv4LowerHalfCIDR, util.IPv4UpperHalfCIDR)
+	case util.IPv6AnyCIDR:
+		members = append(members, util.IPv6LowerHalfCIDR, util.IPv6UpperHalfCIDR)
+	default:
+		members = append(members, ipBlock.CIDR)
+	}
+
+	for _, except := range ipBlock.Except {
+		members = append(members, except+" "+util.IpsetNomatch)
+	}
+
+	return setName, members
+}
+
+func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPolicyIngressRule) ([]string, []string, map[string][]string, []*iptm.IptEntry) {
 	var (
 		portRuleExists    = false
 		fromRuleExists    = false
 		isAppliedToNs     = false
 		protPortPairSlice []*portsInfo
+		namedPortSets     []string // named port sets listed in Ingress rules.
 		PodNsRuleSets     []string // pod sets listed in Ingress rules.
 		nsRuleLists       []string // namespace sets listed in Ingress rules
 		entries           []*iptm.IptEntry
-		ipblock           *networkingv1.IPBlock
+		ipBlockSets       = make(map[string][]string) // ipBlock sets listed in Ingress rules and their members.
 	)
 
 	if len(targetSets) == 0 {
@@ -38,11 +126,11 @@ func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPo
 
 	for _, rule := range rules {
 		for _, portRule := range rule.Ports {
-			protPortPairSlice = append(protPortPairSlice,
-				&portsInfo{
-					protocol: string(*portRule.Protocol),
-					port:     fmt.Sprint(portRule.Port.IntVal),
-				})
+			portInfo := getPortsInfo(portRule)
+			if len(portInfo.namedPort) > 0 {
+				namedPortSets = append(namedPortSets, util.NamedPortIPSetPrefix+portInfo.namedPort)
+			}
+			protPortPairSlice = append(protPortPairSlice, portInfo)
 
 			portRuleExists = true
 		}
@@ -69,7 +157,8 @@ func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPo
 			}
 
 			if fromRule.IPBlock != nil {
-				ipblock = fromRule.IPBlock
+				ipBlockSet, members := getIPBlockIpset(fromRule.IPBlock)
+				ipBlockSets[ipBlockSet] = members
 			}
 
 			fromRuleExists = true
@@ -77,23 +166,7 @@ func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPo
 	}
 
 	if isAppliedToNs {
-		hashedTargetSetName := util.GetHashedName(ns)
-
-		nsDrop := &iptm.IptEntry{
-			Name:       ns,
-			HashedName: hashedTargetSetName,
-			Chain:      util.IptablesAzureTargetSetsChain,
-			Specs: []string{
-				util.IptablesMatchFlag,
-				util.IptablesSetFlag,
-				util.IptablesMatchSetFlag,
-				hashedTargetSetName,
-				util.IptablesDstFlag,
-				util.IptablesJumpFlag,
-				util.IptablesDrop,
-			},
-		}
-		entries = append(entries, nsDrop)
+		entries = append(entries, getIngressDropEntries([]string{ns})...)
 	}
 
 	// Use hashed string for ipset name to avoid string length limit of ipset.
@@ -102,22 +175,8 @@ func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPo
 
 		hashedTargetSetName := util.GetHashedName(targetSet)
 
+		// Target sets without any rule are left to the default drop entries.
 		if len(rules) == 0 {
-			drop := &iptm.IptEntry{
-				Name:       targetSet,
-				HashedName: hashedTargetSetName,
-				Chain:      util.IptablesAzureIngressPortChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesDstFlag,
-					util.IptablesJumpFlag,
-					util.IptablesDrop,
-				},
-			}
-			entries = append(entries, drop)
 			continue
 		}
 
@@ -133,7 +192,9 @@ func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPo
 					hashedTargetSetName,
 					util.IptablesDstFlag,
 					util.IptablesJumpFlag,
-					util.IptablesAccept,
+					util.IptablesMarkTarget,
+					util.IptablesSetMarkFlag,
+					util.IptablesAzureIngressMarkHex,
 				},
 			}
 			entries = append(entries, allow)
@@ -162,11 +223,8 @@ func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPo
 					Name:       targetSet,
 					HashedName: hashedTargetSetName,
 					Chain:      util.IptablesAzureIngressPortChain,
-					Specs: []string{
-						util.IptablesProtFlag,
-						protPortPair.protocol,
-						util.IptablesDstPortFlag,
-						protPortPair.port,
+					Specs: append(
+						getPortSpecs(protPortPair),
 						util.IptablesMatchFlag,
 						util.IptablesSetFlag,
 						util.IptablesMatchSetFlag,
@@ -174,7 +232,7 @@ func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPo
 						util.IptablesDstFlag,
 						util.IptablesJumpFlag,
 						util.IptablesAzureIngressFromChain,
-					},
+					),
 				}
 				entries = append(entries, entry)
 			}
@@ -192,52 +250,40 @@ func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPo
 					hashedTargetSetName,
 					util.IptablesDstFlag,
 					util.IptablesJumpFlag,
-					util.IptablesAccept,
+					util.IptablesMarkTarget,
+					util.IptablesSetMarkFlag,
+					util.IptablesAzureIngressMarkHex,
 				},
 			}
 			entries = append(entries, entry)
 			continue
 		}
 
-		if ipblock != nil {
-			// Handle ipblock field of NetworkPolicyPeer
-			if len(ipblock.Except) > 0 {
-				for _, except := range ipblock.Except {
-					entry := &iptm.IptEntry{
-						Chain: util.IptablesAzureIngressFromChain,
-						Specs: []string{
-							util.IptablesMatchFlag,
-							util.IptablesSetFlag,
-							util.IptablesMatchSetFlag,
-							hashedTargetSetName,
-							util.IptablesDstFlag,
-							util.IptablesSFlag,
-							except,
-							util.IptablesJumpFlag,
-							util.IptablesDrop,
-						},
-					}
-					entries = append(entries, entry)
-				}
-			}
-
-			if len(ipblock.CIDR) > 0 {
-				cidrEntry := &iptm.IptEntry{
-					Chain: util.IptablesAzureIngressFromChain,
-					Specs: []string{
-						util.IptablesMatchFlag,
-						util.IptablesSetFlag,
-						util.IptablesMatchSetFlag,
-						hashedTargetSetName,
-						util.IptablesDstFlag,
-						util.IptablesSFlag,
-						ipblock.CIDR,
-						util.IptablesJumpFlag,
-						util.IptablesAccept,
-					},
-				}
-				entries = append(entries, cidrEntry)
+		// Handle IPBlock field of NetworkPolicyPeer.
+		for ipBlockSet := range ipBlockSets {
+			hashedIPBlockSetName := util.GetHashedName(ipBlockSet)
+			entry := &iptm.IptEntry{
+				Name:       ipBlockSet,
+				HashedName: hashedIPBlockSetName,
+				Chain:      util.IptablesAzureIngressFromChain,
+				Specs: []string{
+					util.IptablesMatchFlag,
+					util.IptablesSetFlag,
+					util.IptablesMatchSetFlag,
+					hashedIPBlockSetName,
+					util.IptablesSrcFlag,
+					util.IptablesMatchFlag,
+					util.IptablesSetFlag,
+					util.IptablesMatchSetFlag,
+					hashedTargetSetName,
+					util.IptablesDstFlag,
+					util.IptablesJumpFlag,
+					util.IptablesMarkTarget,
+					util.IptablesSetMarkFlag,
+					util.IptablesAzureIngressMarkHex,
+				},
 			}
+			entries = append(entries, entry)
 		}
 
 		// Handle PodSelector field of NetworkPolicyPeer.
@@ -259,7 +305,9 @@ func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPo
 					hashedTargetSetName,
 					util.IptablesDstFlag,
 					util.IptablesJumpFlag,
-					util.IptablesAccept,
+					util.IptablesMarkTarget,
+					util.IptablesSetMarkFlag,
+					util.IptablesAzureIngressMarkHex,
 				},
 			}
 			entries = append(entries, entry)
@@ -284,26 +332,29 @@ func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPo
 					hashedTargetSetName,
 					util.IptablesDstFlag,
 					util.IptablesJumpFlag,
-					util.IptablesAccept,
+					util.IptablesMarkTarget,
+					util.IptablesSetMarkFlag,
+					util.IptablesAzureIngressMarkHex,
 				},
 			}
 			entries = append(entries, entry)
 		}
 	}
 
-	return PodNsRuleSets, nsRuleLists, entries
+	return append(PodNsRuleSets, namedPortSets...), nsRuleLists, ipBlockSets, entries
 }
 
-func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPolicyEgressRule) ([]string, []string, []*iptm.IptEntry) {
+func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPolicyEgressRule) ([]string, []string, map[string][]string, []*iptm.IptEntry) {
 	var (
 		portRuleExists    = false
 		toRuleExists      = false
 		isAppliedToNs     = false
 		protPortPairSlice []*portsInfo
+		namedPortSets     []string // named port sets listed in Egress rules.
 		PodNsRuleSets     []string // pod sets listed in Egress rules.
 		nsRuleLists       []string // namespace sets listed in Egress rules
 		entries           []*iptm.IptEntry
-		ipblock           *networkingv1.IPBlock
+		ipBlockSets       = make(map[string][]string) // ipBlock sets listed in Egress rules and their members.
 	)
 
 	if len(targetSets) == 0 {
@@ -313,11 +364,11 @@ func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPol
 
 	for _, rule := range rules {
 		for _, portRule := range rule.Ports {
-			protPortPairSlice = append(protPortPairSlice,
-				&portsInfo{
-					protocol: string(*portRule.Protocol),
-					port:     fmt.Sprint(portRule.Port.IntVal),
-				})
+			portInfo := getPortsInfo(portRule)
+			if len(portInfo.namedPort) > 0 {
+				namedPortSets = append(namedPortSets, util.NamedPortIPSetPrefix+portInfo.namedPort)
+			}
+			protPortPairSlice = append(protPortPairSlice, portInfo)
 
 			portRuleExists = true
 		}
@@ -344,7 +395,8 @@ func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPol
 			}
 
 			if toRule.IPBlock != nil {
-				ipblock = toRule.IPBlock
+				ipBlockSet, members := getIPBlockIpset(toRule.IPBlock)
+				ipBlockSets[ipBlockSet] = members
 			}
 
 			toRuleExists = true
@@ -352,45 +404,15 @@ func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPol
 	}
 
 	if isAppliedToNs {
-		hashedTargetSetName := util.GetHashedName(ns)
-
-		nsDrop := &iptm.IptEntry{
-			Name:       ns,
-			HashedName: hashedTargetSetName,
-			Chain:      util.IptablesAzureTargetSetsChain,
-			Specs: []string{
-				util.IptablesMatchFlag,
-				util.IptablesSetFlag,
-				util.IptablesMatchSetFlag,
-				hashedTargetSetName,
-				util.IptablesSrcFlag,
-				util.IptablesJumpFlag,
-				util.IptablesDrop,
-			},
-		}
-		entries = append(entries, nsDrop)
+		entries = append(entries, getEgressDropEntries([]string{ns})...)
 	}
 
 	// Use hashed string for ipset name to avoid string length limit of ipset.
 	for _, targetSet := range targetSets {
 		hashedTargetSetName := util.GetHashedName(targetSet)
 
+		// Target sets without any rule are left to the default drop entries.
 		if len(rules) == 0 {
-			drop := &iptm.IptEntry{
-				Name:       targetSet,
-				HashedName: hashedTargetSetName,
-				Chain:      util.IptablesAzureEgressPortChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesSrcFlag,
-					util.IptablesJumpFlag,
-					util.IptablesDrop,
-				},
-			}
-			entries = append(entries, drop)
 			continue
 		}
 
@@ -406,7 +428,9 @@ func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPol
 					hashedTargetSetName,
 					util.IptablesSrcFlag,
 					util.IptablesJumpFlag,
-					util.IptablesAccept,
+					util.IptablesMarkTarget,
+					util.IptablesSetMarkFlag,
+					util.IptablesAzureEgressMarkHex,
 				},
 			}
 			entries = append(entries, allow)
@@ -423,7 +447,7 @@ func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPol
 					util.IptablesSetFlag,
 					util.IptablesMatchSetFlag,
 					hashedTargetSetName,
-					util.IptablesDstFlag,
+					util.IptablesSrcFlag,
 					util.IptablesJumpFlag,
 					util.IptablesAzureEgressToChain,
 				},
@@ -435,11 +459,8 @@ func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPol
 					Name:       targetSet,
 					HashedName: hashedTargetSetName,
 					Chain:      util.IptablesAzureEgressPortChain,
-					Specs: []string{
-						util.IptablesProtFlag,
-						protPortPair.protocol,
-						util.IptablesDstPortFlag,
-						protPortPair.port,
+					Specs: append(
+						getPortSpecs(protPortPair),
 						util.IptablesMatchFlag,
 						util.IptablesSetFlag,
 						util.IptablesMatchSetFlag,
@@ -447,7 +468,7 @@ func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPol
 						util.IptablesSrcFlag,
 						util.IptablesJumpFlag,
 						util.IptablesAzureEgressToChain,
-					},
+					),
 				}
 				entries = append(entries, entry)
 			}
@@ -463,54 +484,42 @@ func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPol
 					util.IptablesSetFlag,
 					util.IptablesMatchSetFlag,
 					hashedTargetSetName,
-					util.IptablesDstFlag,
+					util.IptablesSrcFlag,
 					util.IptablesJumpFlag,
-					util.IptablesAccept,
+					util.IptablesMarkTarget,
+					util.IptablesSetMarkFlag,
+					util.IptablesAzureEgressMarkHex,
 				},
 			}
 			entries = append(entries, entry)
 			continue
 		}
 
-		if ipblock != nil {
-			// Handle ipblock field of NetworkPolicyPeer
-			if len(ipblock.Except) > 0 {
-				for _, except := range ipblock.Except {
-					entry := &iptm.IptEntry{
-						Chain: util.IptablesAzureEgressToChain,
-						Specs: []string{
-							util.IptablesMatchFlag,
-							util.IptablesSetFlag,
-							util.IptablesMatchSetFlag,
-							hashedTargetSetName,
-							util.IptablesSrcFlag,
-							util.IptablesDFlag,
-							except,
-							util.IptablesJumpFlag,
-							util.IptablesDrop,
-						},
-					}
-					entries = append(entries, entry)
-				}
-			}
-
-			if len(ipblock.CIDR) > 0 {
-				cidrEntry := &iptm.IptEntry{
-					Chain: util.IptablesAzureEgressToChain,
-					Specs: []string{
-						util.IptablesMatchFlag,
-						util.IptablesSetFlag,
-						util.IptablesMatchSetFlag,
-						hashedTargetSetName,
-						util.IptablesSrcFlag,
-						util.IptablesDFlag,
-						ipblock.CIDR,
-						util.IptablesJumpFlag,
-						util.IptablesAccept,
-					},
-				}
-				entries = append(entries, cidrEntry)
+		// Handle IPBlock field of NetworkPolicyPeer.
+		for ipBlockSet := range ipBlockSets {
+			hashedIPBlockSetName := util.GetHashedName(ipBlockSet)
+			entry := &iptm.IptEntry{
+				Name:       ipBlockSet,
+				HashedName: hashedIPBlockSetName,
+				Chain:      util.IptablesAzureEgressToChain,
+				Specs: []string{
+					util.IptablesMatchFlag,
+					util.IptablesSetFlag,
+					util.IptablesMatchSetFlag,
+					hashedIPBlockSetName,
+					util.IptablesDstFlag,
+					util.IptablesMatchFlag,
+					util.IptablesSetFlag,
+					util.IptablesMatchSetFlag,
+					hashedTargetSetName,
+					util.IptablesSrcFlag,
+					util.IptablesJumpFlag,
+					util.IptablesMarkTarget,
+					util.IptablesSetMarkFlag,
+					util.IptablesAzureEgressMarkHex,
+				},
 			}
+			entries = append(entries, entry)
 		}
 
 		// Handle PodSelector field of NetworkPolicyPeer.
@@ -532,7 +541,9 @@ func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPol
 					hashedRuleSetName,
 					util.IptablesDstFlag,
 					util.IptablesJumpFlag,
-					util.IptablesAccept,
+					util.IptablesMarkTarget,
+					util.IptablesSetMarkFlag,
+					util.IptablesAzureEgressMarkHex,
 				},
 			}
 			entries = append(entries, entry)
@@ -557,18 +568,30 @@ func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPol
 					hashedRuleSetName,
 					util.IptablesDstFlag,
 					util.IptablesJumpFlag,
-					util.IptablesAccept,
+					util.IptablesMarkTarget,
+					util.IptablesSetMarkFlag,
+					util.IptablesAzureEgressMarkHex,
 				},
 			}
 			entries = append(entries, entry)
 		}
 	}
 
-	return PodNsRuleSets, nsRuleLists, entries
+	return append(PodNsRuleSets, namedPortSets...), nsRuleLists, ipBlockSets, entries
+}
+
+// getIngressDropEntries drops all packets to the target sets that no ingress rule allowed.
+func getIngressDropEntries(targetSets []string) []*iptm.IptEntry {
+	return getDefaultDropEntries(targetSets, util.IptablesDstFlag, util.IptablesAzureIngressMarkHex)
+}
+
+// getEgressDropEntries drops all packets from the target sets that no egress rule allowed.
+func getEgressDropEntries(targetSets []string) []*iptm.IptEntry {
+	return getDefaultDropEntries(targetSets, util.IptablesSrcFlag, util.IptablesAzureEgressMarkHex)
 }
 
 // Drop all non-whitelisted packets.
-func getDefaultDropEntries(targetSets []string) []*iptm.IptEntry {
+func getDefaultDropEntries(targetSets []string, direction string, mark string) []*iptm.IptEntry {
 	var entries []*iptm.IptEntry
 
 	for _, targetSet := range targetSets {
@@ -582,23 +605,12 @@ func getDefaultDropEntries(targetSets []string) []*iptm.IptEntry {
 				util.IptablesSetFlag,
 				util.IptablesMatchSetFlag,
 				hashedTargetSetName,
-				util.IptablesSrcFlag,
-				util.IptablesJumpFlag,
-				util.IptablesDrop,
-			},
-		}
-		entries = append(entries, entry)
-
-		entry = &iptm.IptEntry{
-			Name:       targetSet,
-			HashedName: hashedTargetSetName,
-			Chain:      util.IptablesAzureTargetSetsChain,
-			Specs: []string{
+				direction,
 				util.IptablesMatchFlag,
-				util.IptablesSetFlag,
-				util.IptablesMatchSetFlag,
-				hashedTargetSetName,
-				util.IptablesDstFlag,
+				util.IptablesMark,
+				util.IptablesNotFlag,
+				util.IptablesMarkFlag,
+				mark,
 				util.IptablesJumpFlag,
 				util.IptablesDrop,
 			},
@@ -609,11 +621,26 @@ func getDefaultDropEntries(targetSets []string) []*iptm.IptEntry {
 	return entries
 }
 
+// getPolicyTypes returns the policy types of a network policy.
+// Policies without policy types always isolate ingress, and isolate egress only if they have egress rules.
+func getPolicyTypes(npObj *networkingv1.NetworkPolicy) []networkingv1.PolicyType {
+	policyTypes := npObj.Spec.PolicyTypes
+	if len(policyTypes) == 0 {
+		policyTypes = append(policyTypes, networkingv1.PolicyTypeIngress)
+		if len(npObj.Spec.Egress) > 0 {
+			policyTypes = append(policyTypes, networkingv1.PolicyTypeEgress)
+		}
+	}
+
+	return policyTypes
+}
+
 // ParsePolicy parses network policy.
-func parsePolicy(npObj *networkingv1.NetworkPolicy) ([]string, []string, []*iptm.IptEntry) {
+func parsePolicy(npObj *networkingv1.NetworkPolicy) ([]string, []string, map[string][]string, []*iptm.IptEntry) {
 	var (
 		resultPodSets []string
 		resultNsLists []string
+		resultIPBlock = make(map[string][]string)
 		affectedSets  []string
 		entries       []*iptm.IptEntry
 	)
@@ -625,44 +652,40 @@ func parsePolicy(npObj *networkingv1.NetworkPolicy) ([]string, []string, []*iptm
 		affectedSets = append(affectedSets, affectedSet)
 	}
 
-	if len(npObj.Spec.PolicyTypes) == 0 {
-		ingressPodSets, ingressNsSets, ingressEntries := parseIngress(npNs, affectedSets, npObj.Spec.Ingress)
-		resultPodSets = append(resultPodSets, ingressPodSets...)
-		resultNsLists = append(resultNsLists, ingressNsSets...)
-		entries = append(entries, ingressEntries...)
-
-		egressPodSets, egressNsSets, egressEntries := parseEgress(npNs, affectedSets, npObj.Spec.Egress)
-		resultPodSets = append(resultPodSets, egressPodSets...)
-		resultNsLists = append(resultNsLists, egressNsSets...)
-		entries = append(entries, egressEntries...)
-
-		entries = append(entries, getDefaultDropEntries(affectedSets)...)
-
-		resultPodSets = append(resultPodSets, affectedSets...)
-
-		return util.UniqueStrSlice(resultPodSets), util.UniqueStrSlice(resultNsLists), entries
-	}
-
-	for _, ptype := range npObj.Spec.PolicyTypes {
+	for _, ptype := range getPolicyTypes(npObj) {
 		if ptype == networkingv1.PolicyTypeIngress {
-			ingressPodSets, ingressNsSets, ingressEntries := parseIngress(npNs, affectedSets, npObj.Spec.Ingress)
+			ingressPodSets, ingressNsSets, ingressIPBlockSets, ingressEntries := parseIngress(npNs, affectedSets, npObj.Spec.Ingress)
 			resultPodSets = append(resultPodSets, ingressPodSets...)
 			resultNsLists = append(resultNsLists, ingressNsSets...)
+			for set, members := range ingressIPBlockSets {
+				resultIPBlock[set] = members
+			}
 			entries = append(entries, ingressEntries...)
+			entries = append(entries, getIngressDropEntries(affectedSets)...)
 		}
 
 		if ptype == networkingv1.PolicyTypeEgress {
-			egressPodSets, egressNsSets, egressEntries := parseEgress(npNs, affectedSets, npObj.Spec.Egress)
+			egressPodSets, egressNsSets, egressIPBlockSets, egressEntries := parseEgress(npNs, affectedSets, npObj.Spec.Egress)
 			resultPodSets = append(resultPodSets, egressPodSets...)
 			resultNsLists = append(resultNsLists, egressNsSets...)
+			for set, members := range egressIPBlockSets {
+				resultIPBlock[set] = members
+			}
 			entries = append(entries, egressEntries...)
-		}
 
-		entries = append(entries, getDefaultDropEntries(affectedSets)...)
+			fqdnSets, fqdnEntries := parseFQDNEgress(npNs, affectedSets, getPolicyFQDNs(npObj))
+			resultPodSets = append(resultPodSets, fqdnSets...)
+			entries = append(entries, fqdnEntries...)
+			entries = append(entries, getEgressDropEntries(affectedSets)...)
+		}
 	}
 
 	resultPodSets = append(resultPodSets, affectedSets...)
 	resultPodSets = append(resultPodSets, npNs)
 
-	return util.UniqueStrSlice(resultPodSets), util.UniqueStrSlice(resultNsLists), entries
+	if isAuditPolicy(npObj) {
+		entries = getAuditEntries(npObj, entries)
+	}
+
+	return util.UniqueStrSlice(resultPodSets), util.UniqueStrSlice(resultNsLists), resultIPBlock, entries
 }
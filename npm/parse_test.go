@@ -0,0 +1,295 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// getEntryRules returns the iptables rules of entries, formatted the way iptables-save lists them.
+func getEntryRules(entries []*iptm.IptEntry) []string {
+	var rules []string
+	for _, entry := range entries {
+		rules = append(rules, "-A "+entry.Chain+" "+strings.Join(entry.Specs, " "))
+	}
+
+	return rules
+}
+
+// checkEntryRules checks that entries have exactly the expected iptables rules, in order.
+func checkEntryRules(t *testing.T, entries []*iptm.IptEntry, expected []string) {
+	rules := getEntryRules(entries)
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("Unexpected rules:\n%s\nexpected:\n%s", strings.Join(rules, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestParsePolicyEgress(t *testing.T) {
+	tcp := corev1.ProtocolTCP
+	ingressPort := intstr.FromInt(8080)
+	egressPort := intstr.FromInt(5432)
+
+	npObj := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test",
+			Name:      "backend",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "backend"},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &ingressPort}},
+					From: []networkingv1.NetworkPolicyPeer{
+						{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}},
+					},
+				},
+			},
+			Egress: []networkingv1.NetworkPolicyEgressRule{
+				{
+					Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &egressPort}},
+					To: []networkingv1.NetworkPolicyPeer{
+						{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}},
+					},
+				},
+			},
+		},
+	}
+
+	backend := util.GetHashedName("all-namespace-app:backend")
+	frontend := util.GetHashedName("all-namespace-app:frontend")
+	db := util.GetHashedName("all-namespace-app:db")
+
+	_, _, _, entries := parsePolicy(npObj)
+	checkEntryRules(t, entries, []string{
+		"-A AZURE-NPM-INGRESS-PORT -p tcp --dport 8080 -m set --match-set " + backend + " dst -j AZURE-NPM-INGRESS-FROM",
+		"-A AZURE-NPM-INGRESS-FROM -m set --match-set " + frontend + " src -m set --match-set " + backend + " dst -j MARK --set-xmark 0x2000/0x2000",
+		"-A AZURE-NPM-TARGET-SETS -m set --match-set " + backend + " dst -m mark ! --mark 0x2000/0x2000 -j DROP",
+		"-A AZURE-NPM-EGRESS-PORT -p tcp --dport 5432 -m set --match-set " + backend + " src -j AZURE-NPM-EGRESS-TO",
+		"-A AZURE-NPM-EGRESS-TO -m set --match-set " + backend + " src -m set --match-set " + db + " dst -j MARK --set-xmark 0x1000/0x1000",
+		"-A AZURE-NPM-TARGET-SETS -m set --match-set " + backend + " src -m mark ! --mark 0x1000/0x1000 -j DROP",
+	})
+
+	// Egress is isolated by a deny all egress policy.
+	npObj.Spec.PolicyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeEgress}
+	npObj.Spec.Egress = nil
+
+	_, _, _, entries = parsePolicy(npObj)
+	checkEntryRules(t, entries, []string{
+		"-A AZURE-NPM-TARGET-SETS -m set --match-set " + backend + " src -m mark ! --mark 0x1000/0x1000 -j DROP",
+	})
+}
+
+func TestGetPortSpecs(t *testing.T) {
+	udp := corev1.ProtocolUDP
+	sctp := corev1.ProtocolSCTP
+	port := intstr.FromInt(8000)
+	namedPort := intstr.FromString("http")
+	endPort := int32(8080)
+
+	tests := []struct {
+		portRule networkingv1.NetworkPolicyPort
+		expected []string
+	}{
+		{
+			portRule: networkingv1.NetworkPolicyPort{Port: &port},
+			expected: []string{util.IptablesProtFlag, "tcp", util.IptablesDstPortFlag, "8000"},
+		},
+		{
+			portRule: networkingv1.NetworkPolicyPort{Protocol: &udp, Port: &port, EndPort: &endPort},
+			expected: []string{util.IptablesProtFlag, "udp", util.IptablesDstPortFlag, "8000:8080"},
+		},
+		{
+			portRule: networkingv1.NetworkPolicyPort{Protocol: &udp},
+			expected: []string{util.IptablesProtFlag, "udp"},
+		},
+		{
+			portRule: networkingv1.NetworkPolicyPort{Protocol: &sctp, Port: &port},
+			expected: []string{util.IptablesProtFlag, "sctp", util.IptablesDstPortFlag, "8000"},
+		},
+		{
+			portRule: networkingv1.NetworkPolicyPort{Protocol: &sctp, Port: &port, EndPort: &endPort},
+			expected: []string{util.IptablesProtFlag, "sctp", util.IptablesDstPortFlag, "8000:8080"},
+		},
+		{
+			portRule: networkingv1.NetworkPolicyPort{Protocol: &sctp},
+			expected: []string{util.IptablesProtFlag, "sctp"},
+		},
+		{
+			portRule: networkingv1.NetworkPolicyPort{Port: &namedPort},
+			expected: []string{
+				util.IptablesProtFlag,
+				"tcp",
+				util.IptablesMatchFlag,
+				util.IptablesSetFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName(util.NamedPortIPSetPrefix + "http"),
+				util.IptablesDstDstFlag,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if specs := getPortSpecs(getPortsInfo(test.portRule)); !reflect.DeepEqual(specs, test.expected) {
+			t.Errorf("Port rule %+v has specs %v, expected %v", test.portRule, specs, test.expected)
+		}
+	}
+
+	if !portRangeContains("8000:8080", "8080") || portRangeContains("8000:8080", "8081") || !portRangeContains("53", "53") {
+		t.Errorf("Unexpected port range matches")
+	}
+}
+
+func TestParsePolicyNamedPorts(t *testing.T) {
+	namedPort := intstr.FromString("http")
+	npObj := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test",
+			Name:      "backend",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "backend"},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					Ports: []networkingv1.NetworkPolicyPort{{Port: &namedPort}},
+					From: []networkingv1.NetworkPolicyPeer{
+						{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "frontend"}}},
+					},
+				},
+			},
+			Egress: []networkingv1.NetworkPolicyEgressRule{
+				{
+					Ports: []networkingv1.NetworkPolicyPort{{Port: &namedPort}},
+				},
+			},
+		},
+	}
+
+	backend := util.GetHashedName("all-namespace-app:backend")
+	frontend := util.GetHashedName("all-namespace-app:frontend")
+	http := util.GetHashedName(util.NamedPortIPSetPrefix + "http")
+
+	podSets, _, _, entries := parsePolicy(npObj)
+	checkEntryRules(t, entries, []string{
+		"-A AZURE-NPM-INGRESS-PORT -p tcp -m set --match-set " + http + " dst,dst -m set --match-set " + backend + " dst -j AZURE-NPM-INGRESS-FROM",
+		"-A AZURE-NPM-INGRESS-FROM -m set --match-set " + frontend + " src -m set --match-set " + backend + " dst -j MARK --set-xmark 0x2000/0x2000",
+		"-A AZURE-NPM-TARGET-SETS -m set --match-set " + backend + " dst -m mark ! --mark 0x2000/0x2000 -j DROP",
+		"-A AZURE-NPM-EGRESS-PORT -p tcp -m set --match-set " + http + " dst,dst -m set --match-set " + backend + " src -j AZURE-NPM-EGRESS-TO",
+		"-A AZURE-NPM-EGRESS-TO -m set --match-set " + backend + " src -j MARK --set-xmark 0x1000/0x1000",
+		"-A AZURE-NPM-TARGET-SETS -m set --match-set " + backend + " src -m mark ! --mark 0x1000/0x1000 -j DROP",
+	})
+
+	// The named port set is created with the pod sets of the policy.
+	found := false
+	for _, set := range podSets {
+		found = found || set == util.NamedPortIPSetPrefix+"http"
+	}
+
+	if !found {
+		t.Errorf("Named port set is missing from the sets of the policy %v", podSets)
+	}
+}
+
+func TestParsePolicyIPBlockExcept(t *testing.T) {
+	npObj := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test",
+			Name:      "backend",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "backend"},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/16", Except: []string{"10.0.1.0/24", "10.0.2.0/24"}}},
+					},
+				},
+			},
+			Egress: []networkingv1.NetworkPolicyEgressRule{
+				{
+					To: []networkingv1.NetworkPolicyPeer{
+						{IPBlock: &networkingv1.IPBlock{CIDR: "0.0.0.0/0", Except: []string{"169.254.169.254/32"}}},
+					},
+				},
+			},
+		},
+	}
+
+	ingressSet := util.IPBlockIPSetPrefix + "10.0.0.0/16-except-10.0.1.0/24,10.0.2.0/24"
+	egressSet := util.IPBlockIPSetPrefix + "0.0.0.0/0-except-169.254.169.254/32"
+	backend := util.GetHashedName("all-namespace-app:backend")
+
+	_, _, ipBlockSets, entries := parsePolicy(npObj)
+
+	// Excepted ranges are nomatch members, so they don't match the set of the ipBlock.
+	expectedSets := map[string][]string{
+		ingressSet: {"10.0.0.0/16", "10.0.1.0/24 nomatch", "10.0.2.0/24 nomatch"},
+		egressSet:  {"0.0.0.0/1", "128.0.0.0/1", "169.254.169.254/32 nomatch"},
+	}
+	if !reflect.DeepEqual(ipBlockSets, expectedSets) {
+		t.Errorf("Unexpected ipBlock sets %v, expected %v", ipBlockSets, expectedSets)
+	}
+
+	checkEntryRules(t, entries, []string{
+		"-A AZURE-NPM-INGRESS-PORT -m set --match-set " + backend + " dst -j AZURE-NPM-INGRESS-FROM",
+		"-A AZURE-NPM-INGRESS-FROM -m set --match-set " + util.GetHashedName(ingressSet) + " src -m set --match-set " + backend + " dst -j MARK --set-xmark 0x2000/0x2000",
+		"-A AZURE-NPM-TARGET-SETS -m set --match-set " + backend + " dst -m mark ! --mark 0x2000/0x2000 -j DROP",
+		"-A AZURE-NPM-EGRESS-PORT -m set --match-set " + backend + " src -j AZURE-NPM-EGRESS-TO",
+		"-A AZURE-NPM-EGRESS-TO -m set --match-set " + util.GetHashedName(egressSet) + " dst -m set --match-set " + backend + " src -j MARK --set-xmark 0x1000/0x1000",
+		"-A AZURE-NPM-TARGET-SETS -m set --match-set " + backend + " src -m mark ! --mark 0x1000/0x1000 -j DROP",
+	})
+}
+
+func TestParsePolicySCTP(t *testing.T) {
+	sctp := corev1.ProtocolSCTP
+	port := intstr.FromInt(3868)
+	startPort := intstr.FromInt(38412)
+	endPort := int32(38422)
+
+	npObj := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test",
+			Name:      "backend",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "backend"},
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					Ports: []networkingv1.NetworkPolicyPort{
+						{Protocol: &sctp, Port: &port},
+						{Protocol: &sctp, Port: &startPort, EndPort: &endPort},
+					},
+				},
+			},
+		},
+	}
+
+	backend := util.GetHashedName("all-namespace-app:backend")
+
+	_, _, _, entries := parsePolicy(npObj)
+	checkEntryRules(t, entries, []string{
+		"-A AZURE-NPM-INGRESS-PORT -p sctp --dport 3868 -m set --match-set " + backend + " dst -j AZURE-NPM-INGRESS-FROM",
+		"-A AZURE-NPM-INGRESS-PORT -p sctp --dport 38412:38422 -m set --match-set " + backend + " dst -j AZURE-NPM-INGRESS-FROM",
+		"-A AZURE-NPM-INGRESS-FROM -m set --match-set " + backend + " dst -j MARK --set-xmark 0x2000/0x2000",
+		"-A AZURE-NPM-TARGET-SETS -m set --match-set " + backend + " dst -m mark ! --mark 0x2000/0x2000 -j DROP",
+	})
+}
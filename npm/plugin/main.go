@@ -3,12 +3,23 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	acn "github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/diagnostics"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/npm"
+	"github.com/Azure/azure-container-networking/npm/ipsm"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/platform"
+	"github.com/Azure/azure-container-networking/telemetry"
+	"github.com/Azure/azure-container-networking/trace"
 
-	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -17,9 +28,168 @@ import (
 // Version is populated by make during build.
 var version string
 
-func initLogging() error {
+const (
+	// Interval between dataplane drift checks.
+	dataplaneVerifyInterval = 5 * time.Minute
+
+	// Interval between syncs of the admin network policies, which are polled.
+	adminPolicySyncInterval = 30 * time.Second
+
+	// Interval between lookups of the domain names network policies allow egress traffic to.
+	fqdnSyncInterval = 10 * time.Second
+
+	// Interval between reads of the iptables rule counters aggregated by network policy.
+	policyHitSyncInterval = 30 * time.Second
+
+	// Interval between exports of trace spans.
+	traceExportInterval = 10 * time.Second
+
+	// Age after which rotated log files are removed.
+	logFileMaxAge = 7 * 24 * time.Hour
+
+	// Shortest informer resync period, as each resync requeues every pod of the cluster.
+	minInformerResync = 10 * time.Minute
+)
+
+// Command line arguments for NPM.
+var args = acn.ArgumentList{
+	{
+		Name:         acn.OptLogFormat,
+		Shorthand:    acn.OptLogFormatAlias,
+		Description:  "Set the logging format",
+		Type:         "int",
+		DefaultValue: acn.OptLogFormatText,
+		ValueMap: map[string]interface{}{
+			acn.OptLogFormatText: log.FormatText,
+			acn.OptLogFormatJSON: log.FormatJSON,
+		},
+	},
+	{
+		Name:         acn.OptPolicyMode,
+		Shorthand:    acn.OptPolicyModeAlias,
+		Description:  "Set whether network policies drop the packets they deny, or only log them",
+		Type:         "string",
+		DefaultValue: acn.OptPolicyModeEnforce,
+		ValueMap: map[string]interface{}{
+			acn.OptPolicyModeEnforce: 0,
+			acn.OptPolicyModeAudit:   0,
+		},
+	},
+	{
+		Name:         acn.OptDataplane,
+		Shorthand:    acn.OptDataplaneAlias,
+		Description:  "Set whether network policies are enforced with iptables, or with experimental eBPF programs",
+		Type:         "string",
+		DefaultValue: acn.OptDataplaneIptables,
+		ValueMap: map[string]interface{}{
+			acn.OptDataplaneIptables: 0,
+			acn.OptDataplaneEBPF:     0,
+		},
+	},
+	{
+		Name:         acn.OptDualStack,
+		Shorthand:    acn.OptDualStackAlias,
+		Description:  "Enforce network policies on IPv6 traffic as well, with ip6tables rules and IPv6 ipsets",
+		Type:         "bool",
+		DefaultValue: false,
+	},
+	{
+		Name:         acn.OptInformerResync,
+		Shorthand:    acn.OptInformerResyncAlias,
+		Description:  "Set the period in minutes the informers resync pods, namespaces and network policies at, disabled if 0",
+		Type:         "int",
+		DefaultValue: "1440",
+	},
+	{
+		Name:         acn.OptLazyIpsets,
+		Shorthand:    acn.OptLazyIpsetsAlias,
+		Description:  "Create the ipsets of pod labels and named ports only when a network policy refers to them",
+		Type:         "bool",
+		DefaultValue: false,
+	},
+	{
+		Name:         acn.OptWebhookMode,
+		Shorthand:    acn.OptWebhookModeAlias,
+		Description:  "Set how the network policy admission webhook handles policies NPM can't enforce",
+		Type:         "string",
+		DefaultValue: acn.OptWebhookModeOff,
+		ValueMap: map[string]interface{}{
+			acn.OptWebhookModeOff:  0,
+			acn.OptWebhookModeWarn: 0,
+			acn.OptWebhookModeDeny: 0,
+		},
+	},
+	{
+		Name:         acn.OptWebhookAddress,
+		Shorthand:    acn.OptWebhookAddressAlias,
+		Description:  "Set the address the network policy admission webhook listens on",
+		Type:         "string",
+		DefaultValue: ":9443",
+	},
+	{
+		Name:         acn.OptWebhookCertFile,
+		Shorthand:    acn.OptWebhookCertFileAlias,
+		Description:  "Set the TLS certificate file of the network policy admission webhook",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptWebhookKeyFile,
+		Shorthand:    acn.OptWebhookKeyFileAlias,
+		Description:  "Set the TLS key file of the network policy admission webhook",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptAIKey,
+		Shorthand:    acn.OptAIKeyAlias,
+		Description:  "Set the Application Insights instrumentation key",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptAIEndpoint,
+		Shorthand:    acn.OptAIEndpointAlias,
+		Description:  "Set the Application Insights ingestion endpoint",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptAIProxy,
+		Shorthand:    acn.OptAIProxyAlias,
+		Description:  "Set the proxy used to send telemetry to Application Insights",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptAISampling,
+		Shorthand:    acn.OptAISamplingAlias,
+		Description:  "Set the sampling rates of telemetry sent to Application Insights, as type=rate pairs, e.g. CNIReport=0.01,error=1",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         acn.OptDiagnosticsAddress,
+		Shorthand:    acn.OptDiagnosticsAddressAlias,
+		Description:  "Set the loopback address serving pprof and runtime diagnostics, disabled if empty",
+		Type:         "string",
+		DefaultValue: "",
+	},
+}
+
+// Prints description and version information.
+func printVersion() {
+	fmt.Printf("Azure Network Policy Manager\n")
+	fmt.Printf("Version %v\n", version)
+}
+
+func initLogging(logFormat int) error {
 	log.SetName("azure-npm")
 	log.SetLevel(log.LevelInfo)
+	log.SetLogFileRetention(logFileMaxAge, true)
+	if logFormat == log.FormatJSON {
+		log.SetFormat(logFormat)
+	}
 	if err := log.SetTarget(log.TargetLogfile); err != nil {
 		log.Printf("[cni-npm] Failed to configure logging, err:%v.\n", err)
 		return err
@@ -37,7 +207,46 @@ func main() {
 		}
 	}()
 
-	if err = initLogging(); err != nil {
+	acn.ParseArgs(&args, printVersion)
+	logFormat := acn.GetArg(acn.OptLogFormat).(int)
+	policyMode := acn.GetArg(acn.OptPolicyMode).(string)
+	dataplane := acn.GetArg(acn.OptDataplane).(string)
+	informerResync := time.Duration(acn.GetArg(acn.OptInformerResync).(int)) * time.Minute
+	lazyIpsets := acn.GetArg(acn.OptLazyIpsets).(bool)
+	dualStack := acn.GetArg(acn.OptDualStack).(bool)
+	webhookMode := acn.GetArg(acn.OptWebhookMode).(string)
+	webhookAddress := acn.GetArg(acn.OptWebhookAddress).(string)
+	webhookCertFile := acn.GetArg(acn.OptWebhookCertFile).(string)
+	webhookKeyFile := acn.GetArg(acn.OptWebhookKeyFile).(string)
+	diagnosticsAddress := acn.GetArg(acn.OptDiagnosticsAddress).(string)
+
+	aiSamplingRates, samplingErr := telemetry.ParseAISamplingRates(acn.GetArg(acn.OptAISampling).(string))
+	if samplingErr != nil {
+		fmt.Printf("Invalid Application Insights sampling rates: %v\n", samplingErr)
+	}
+
+	telemetry.SetAIConfig(telemetry.AIConfig{
+		InstrumentationKey: acn.GetArg(acn.OptAIKey).(string),
+		IngestionEndpoint:  acn.GetArg(acn.OptAIEndpoint).(string),
+		ProxyURL:           acn.GetArg(acn.OptAIProxy).(string),
+		SamplingRates:      aiSamplingRates,
+	})
+
+	if err = initLogging(logFormat); err != nil {
+		panic(err.Error())
+	}
+
+	// Fail fast with the missing features rather than on the first failed call to the dataplane.
+	features := []platform.KernelFeature{platform.FeatureIptables, platform.FeatureIpset}
+	if dualStack {
+		features = append(features, platform.FeatureIp6tables)
+	}
+	if dataplane == acn.OptDataplaneEBPF {
+		features = []platform.KernelFeature{platform.FeatureBpfClassifier}
+	}
+
+	if err = platform.CheckKernelFeatures(features...); err != nil {
+		log.Printf("[cni-npm] %v", err)
 		panic(err.Error())
 	}
 
@@ -54,10 +263,37 @@ func main() {
 		panic(err.Error())
 	}
 
-	factory := informers.NewSharedInformerFactory(clientset, time.Hour*24)
+	trace.Initialize("azure-npm", traceExportInterval)
+
+	if informerResync > 0 && informerResync < minInformerResync {
+		log.Printf("[Azure-NPM] Informer resync period %v is too short, using %v.\n", informerResync, minInformerResync)
+		informerResync = minInformerResync
+	}
+
+	factory := informers.NewSharedInformerFactory(clientset, informerResync)
+
+	npm.SetPolicyMode(policyMode)
+	npm.SetDataplane(dataplane)
+	ipsm.SetLazySets(lazyIpsets)
+	ipsm.SetDualStack(dualStack)
+	iptm.SetDualStack(dualStack)
 
 	npMgr := npm.NewNetworkPolicyManager(clientset, factory, version)
-	err = npMgr.Run(wait.NeverStop)
+
+	// Hand the node over to the next NPM instance on shutdown, without flushing the dataplane.
+	stopCh := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		log.Printf("[Azure-NPM] Stopping.")
+		close(stopCh)
+		npMgr.Stop()
+		trace.Close()
+		os.Exit(0)
+	}()
+
+	err = npMgr.Run(stopCh)
 	if err != nil {
 		log.Printf("[Azure-NPM] npm failed with error %v.", err)
 		panic(err.Error)
@@ -65,5 +301,28 @@ func main() {
 
 	go npMgr.RunReportManager()
 
+	go npMgr.RunDataplaneVerifier(dataplaneVerifyInterval)
+
+	go npMgr.RunAdminPolicySync(adminPolicySyncInterval)
+
+	go npMgr.RunFQDNSync(fqdnSyncInterval)
+
+	go npMgr.RunAuditLog(stopCh)
+
+	go npMgr.RunPolicyHitSync(policyHitSyncInterval)
+
+	metrics.Handle(npm.PolicyHitsPath, npMgr.PolicyHitsHandler())
+	metrics.StartServer(metrics.DefaultAddress)
+
+	if diagnosticsAddress != "" {
+		if err = diagnostics.StartServer(diagnosticsAddress); err != nil {
+			log.Printf("[Azure-NPM] Failed to start diagnostics server, err:%v.", err)
+		}
+	}
+
+	if webhookMode != acn.OptWebhookModeOff {
+		npm.StartPolicyWebhook(webhookAddress, webhookCertFile, webhookKeyFile, webhookMode == acn.OptWebhookModeDeny)
+	}
+
 	select {}
 }
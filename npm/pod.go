@@ -3,12 +3,15 @@
 package npm
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/ipsm"
 	"github.com/Azure/azure-container-networking/npm/util"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func isValidPod(podObj *corev1.Pod) bool {
@@ -22,6 +25,149 @@ func isSystemPod(podObj *corev1.Pod) bool {
 	return podObj.ObjectMeta.Namespace == util.KubeSystemFlag
 }
 
+// trimPod returns a copy of a pod with only the fields its ipset entries are computed from,
+// so the applied pods don't keep the rest of the pod objects alive in large clusters.
+func trimPod(podObj *corev1.Pod) *corev1.Pod {
+	trimmed := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            podObj.ObjectMeta.Name,
+			Namespace:       podObj.ObjectMeta.Namespace,
+			UID:             podObj.ObjectMeta.UID,
+			ResourceVersion: podObj.ObjectMeta.ResourceVersion,
+			Labels:          podObj.ObjectMeta.Labels,
+		},
+		Spec: corev1.PodSpec{
+			NodeName:    podObj.Spec.NodeName,
+			HostNetwork: podObj.Spec.HostNetwork,
+		},
+		Status: corev1.PodStatus{
+			Phase:  podObj.Status.Phase,
+			PodIP:  podObj.Status.PodIP,
+			PodIPs: podObj.Status.PodIPs,
+		},
+	}
+
+	for _, container := range podObj.Spec.Containers {
+		if len(container.Ports) > 0 {
+			trimmed.Spec.Containers = append(trimmed.Spec.Containers, corev1.Container{Ports: container.Ports})
+		}
+	}
+
+	return trimmed
+}
+
+// getAppliedPod returns the pod applied to the ipsets with the given namespace/name.
+func (ns *namespace) getAppliedPod(key string) *corev1.Pod {
+	uid, exists := ns.podUIDs[key]
+	if !exists {
+		return nil
+	}
+
+	return ns.podMap[uid]
+}
+
+// setAppliedPod records a pod applied to the ipsets.
+func (ns *namespace) setAppliedPod(podObj *corev1.Pod) {
+	ns.podUIDs[getObjectKey(podObj.ObjectMeta)] = podObj.ObjectMeta.UID
+	ns.podMap[podObj.ObjectMeta.UID] = trimPod(podObj)
+}
+
+// deleteAppliedPod forgets a pod applied to the ipsets. A newer pod with the same name is kept.
+func (ns *namespace) deleteAppliedPod(podObj *corev1.Pod) {
+	key := getObjectKey(podObj.ObjectMeta)
+	if ns.podUIDs[key] == podObj.ObjectMeta.UID {
+		delete(ns.podUIDs, key)
+	}
+
+	delete(ns.podMap, podObj.ObjectMeta.UID)
+}
+
+// getPodIPs returns the addresses of a pod, primary first. The addresses of the other family of
+// dual-stack pods are only returned if ipsets have IPv6 twins to hold them.
+func getPodIPs(podObj *corev1.Pod) []string {
+	podIPs := []string{podObj.Status.PodIP}
+	if !ipsm.IsDualStack() {
+		return podIPs
+	}
+
+	for _, podIP := range podObj.Status.PodIPs {
+		if podIP.IP != "" && podIP.IP != podObj.Status.PodIP {
+			podIPs = append(podIPs, podIP.IP)
+		}
+	}
+
+	return podIPs
+}
+
+// getNamedPortIpsetEntry returns the ip,protocol:port entry of a container port in its named port ipset.
+func getNamedPortIpsetEntry(podIP string, port corev1.ContainerPort) string {
+	protocol := port.Protocol
+	if len(protocol) == 0 {
+		protocol = corev1.ProtocolTCP
+	}
+
+	return podIP + "," + strings.ToLower(string(protocol)) + ":" + fmt.Sprint(port.ContainerPort)
+}
+
+// podIpsetEntry is a member a pod adds to an ipset.
+type podIpsetEntry struct {
+	set    string
+	member string
+}
+
+// getPodIpsetEntries returns the ipset members of a pod: its ips in its namespace's and labels' ipsets,
+// and its named ports in their named port ipsets. The ipset manager adds IPv6 members to the IPv6 twins of the sets.
+func getPodIpsetEntries(podObj *corev1.Pod) []*podIpsetEntry {
+	var entries []*podIpsetEntry
+
+	for _, podIP := range getPodIPs(podObj) {
+		entries = append(entries, &podIpsetEntry{set: podObj.ObjectMeta.Namespace, member: podIP})
+
+		for podLabelKey, podLabelVal := range podObj.ObjectMeta.Labels {
+			//Ignore pod-template-hash label.
+			if strings.Contains(podLabelKey, util.KubePodTemplateHashFlag) {
+				continue
+			}
+
+			labelKey := util.KubeAllNamespacesFlag + "-" + podLabelKey + ":" + podLabelVal
+			entries = append(entries, &podIpsetEntry{set: labelKey, member: podIP})
+		}
+
+		for _, container := range podObj.Spec.Containers {
+			for _, port := range container.Ports {
+				if len(port.Name) == 0 {
+					continue
+				}
+
+				entries = append(entries, &podIpsetEntry{
+					set:    util.NamedPortIPSetPrefix + port.Name,
+					member: getNamedPortIpsetEntry(podIP, port),
+				})
+			}
+		}
+	}
+
+	return entries
+}
+
+// diffPodIpsetEntries returns the entries of a that aren't in b.
+func diffPodIpsetEntries(a []*podIpsetEntry, b []*podIpsetEntry) []*podIpsetEntry {
+	var diff []*podIpsetEntry
+
+	inB := make(map[podIpsetEntry]bool)
+	for _, entry := range b {
+		inB[*entry] = true
+	}
+
+	for _, entry := range a {
+		if !inB[*entry] {
+			diff = append(diff, entry)
+		}
+	}
+
+	return diff
+}
+
 // AddPod handles adding pod ip to its label's ipset.
 func (npMgr *NetworkPolicyManager) AddPod(podObj *corev1.Pod) error {
 	npMgr.Lock()
@@ -46,58 +192,60 @@ func (npMgr *NetworkPolicyManager) AddPod(podObj *corev1.Pod) error {
 	podIP := podObj.Status.PodIP
 	log.Printf("POD CREATING: %s/%s/%s%+v%s\n", podNs, podName, podNodeName, podLabels, podIP)
 
-	// Add the pod to ipset
-	ipsMgr := npMgr.nsMap[util.KubeAllNamespacesFlag].ipsMgr
-	// Add the pod to its namespace's ipset.
-	log.Printf("Adding pod %s to ipset %s\n", podIP, podNs)
-	if err = ipsMgr.AddToSet(podNs, podIP); err != nil {
-		log.Printf("Error adding pod to namespace ipset.\n")
-		return err
-	}
+	// Add the pod to its namespace's, labels' and named ports' ipsets.
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+	ipsMgr := allNs.ipsMgr
+	ipsMgr.BeginBatch()
+	defer ipsMgr.CommitBatch()
 
-	// Add the pod to its label's ipset.
-	var labelKeys []string
-	for podLabelKey, podLabelVal := range podLabels {
-		//Ignore pod-template-hash label.
-		if strings.Contains(podLabelKey, util.KubePodTemplateHashFlag) {
-			continue
-		}
-
-		labelKey := util.KubeAllNamespacesFlag + "-" + podLabelKey + ":" + podLabelVal
-		log.Printf("Adding pod %s to ipset %s\n", podIP, labelKey)
-		if err = ipsMgr.AddToSet(labelKey, podIP); err != nil {
-			log.Printf("Error adding pod to label ipset.\n")
+	for _, entry := range getPodIpsetEntries(podObj) {
+		log.Printf("Adding pod entry %s to ipset %s\n", entry.member, entry.set)
+		if err = ipsMgr.AddToSet(entry.set, entry.member); err != nil {
+			log.Printf("Error adding pod to ipset %s.\n", entry.set)
 			return err
 		}
-		labelKeys = append(labelKeys, labelKey)
 	}
 
+	if err = ipsMgr.CommitBatch(); err != nil {
+		log.Printf("Error applying ipset updates of pod %s/%s.\n", podNs, podName)
+		return err
+	}
+
+	allNs.setAppliedPod(podObj)
 	npMgr.clusterState.PodCount++
 
-	ns, err := newNs(podNs)
-	if err != nil {
-		log.Printf("Error creating namespace %s\n", podNs)
-		return err
+	if _, exists := npMgr.nsMap[podNs]; !exists {
+		ns, err := newNs(podNs)
+		if err != nil {
+			log.Printf("Error creating namespace %s\n", podNs)
+			return err
+		}
+		npMgr.nsMap[podNs] = ns
 	}
-	npMgr.nsMap[podNs] = ns
 
 	return nil
 }
 
 // UpdatePod handles updating pod ip in its label's ipset.
+// Only the ipset entries that differ between the old and new pod are changed.
 func (npMgr *NetworkPolicyManager) UpdatePod(oldPodObj, newPodObj *corev1.Pod) error {
-	if !isValidPod(newPodObj) {
-		return nil
+	if !isValidPod(newPodObj) || isBeingDeleted(newPodObj.ObjectMeta) {
+		return npMgr.DeletePod(oldPodObj)
+	}
+
+	if !isValidPod(oldPodObj) {
+		return npMgr.AddPod(newPodObj)
 	}
 
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
 	var err error
 
 	defer func() {
-		npMgr.Lock()
-		if err = npMgr.UpdateAndSendReport(err, util.UpdateNamespaceEvent); err != nil {
+		if err = npMgr.UpdateAndSendReport(err, util.UpdatePodEvent); err != nil {
 			log.Printf("Error sending NPM telemetry report")
 		}
-		npMgr.Unlock()
 	}()
 
 	oldPodObjNs := oldPodObj.ObjectMeta.Namespace
@@ -114,16 +262,37 @@ func (npMgr *NetworkPolicyManager) UpdatePod(oldPodObj, newPodObj *corev1.Pod) e
 		oldPodObjNs, oldPodObjName, oldPodObjPhase, oldPodObjIP, newPodObjNs, newPodObjName, newPodObjPhase, newPodObjIP,
 	)
 
-	if err = npMgr.DeletePod(oldPodObj); err != nil {
-		return err
+	oldEntries, newEntries := getPodIpsetEntries(oldPodObj), getPodIpsetEntries(newPodObj)
+
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+	ipsMgr := allNs.ipsMgr
+	ipsMgr.BeginBatch()
+	defer ipsMgr.CommitBatch()
+
+	for _, entry := range diffPodIpsetEntries(oldEntries, newEntries) {
+		log.Printf("Deleting pod entry %s from ipset %s\n", entry.member, entry.set)
+		if err = ipsMgr.DeleteFromSet(entry.set, entry.member); err != nil {
+			log.Printf("Error deleting pod from ipset %s.\n", entry.set)
+			return err
+		}
 	}
 
-	if newPodObj.ObjectMeta.DeletionTimestamp == nil && newPodObj.ObjectMeta.DeletionGracePeriodSeconds == nil {
-		if err = npMgr.AddPod(newPodObj); err != nil {
+	for _, entry := range diffPodIpsetEntries(newEntries, oldEntries) {
+		log.Printf("Adding pod entry %s to ipset %s\n", entry.member, entry.set)
+		if err = ipsMgr.AddToSet(entry.set, entry.member); err != nil {
+			log.Printf("Error adding pod to ipset %s.\n", entry.set)
 			return err
 		}
 	}
 
+	if err = ipsMgr.CommitBatch(); err != nil {
+		log.Printf("Error applying ipset updates of pod %s/%s.\n", newPodObjNs, newPodObjName)
+		return err
+	}
+
+	allNs.deleteAppliedPod(oldPodObj)
+	allNs.setAppliedPod(newPodObj)
+
 	return nil
 }
 
@@ -147,31 +316,27 @@ func (npMgr *NetworkPolicyManager) DeletePod(podObj *corev1.Pod) error {
 	podNs := podObj.ObjectMeta.Namespace
 	podName := podObj.ObjectMeta.Name
 	podNodeName := podObj.Spec.NodeName
-	podLabels := podObj.ObjectMeta.Labels
-	podIP := podObj.Status.PodIP
 	log.Printf("POD DELETING: %s/%s/%s\n", podNs, podName, podNodeName)
 
-	// Delete pod from ipset
-	ipsMgr := npMgr.nsMap[util.KubeAllNamespacesFlag].ipsMgr
-	// Delete the pod from its namespace's ipset.
-	if err = ipsMgr.DeleteFromSet(podNs, podIP); err != nil {
-		log.Printf("Error deleting pod from namespace ipset.\n")
-		return err
-	}
-	// Delete the pod from its label's ipset.
-	for podLabelKey, podLabelVal := range podLabels {
-		//Ignore pod-template-hash label.
-		if strings.Contains(podLabelKey, "pod-template-hash") {
-			continue
-		}
+	// Delete the pod from its namespace's, labels' and named ports' ipsets.
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+	ipsMgr := allNs.ipsMgr
+	ipsMgr.BeginBatch()
+	defer ipsMgr.CommitBatch()
 
-		labelKey := util.KubeAllNamespacesFlag + "-" + podLabelKey + ":" + podLabelVal
-		if err = ipsMgr.DeleteFromSet(labelKey, podIP); err != nil {
-			log.Printf("Error deleting pod from label ipset.\n")
+	for _, entry := range getPodIpsetEntries(podObj) {
+		if err = ipsMgr.DeleteFromSet(entry.set, entry.member); err != nil {
+			log.Printf("Error deleting pod from ipset %s.\n", entry.set)
 			return err
 		}
 	}
 
+	if err = ipsMgr.CommitBatch(); err != nil {
+		log.Printf("Error applying ipset updates of pod %s/%s.\n", podNs, podName)
+		return err
+	}
+
+	allNs.deleteAppliedPod(podObj)
 	npMgr.clusterState.PodCount--
 
 	return nil
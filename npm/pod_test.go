@@ -188,3 +188,120 @@ func TestDeletePod(t *testing.T) {
 		t.Errorf("TestDeletePod failed @ DeletePod")
 	}
 }
+
+func TestAppliedPods(t *testing.T) {
+	allNs, _ := newNs(util.KubeAllNamespacesFlag)
+
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-namespace",
+			UID:       "1",
+			Labels:    map[string]string{"app": "test-pod"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Image: "nginx",
+					Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 80}},
+				},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: "Running",
+			PodIP: "1.2.3.4",
+		},
+	}
+
+	allNs.setAppliedPod(podObj)
+
+	appliedPodObj := allNs.getAppliedPod("test-namespace/test-pod")
+	if appliedPodObj == nil || appliedPodObj.Spec.Containers[0].Image != "" {
+		t.Errorf("TestAppliedPods failed @ getAppliedPod, expected a trimmed pod, got %+v", appliedPodObj)
+	}
+
+	if len(getPodIpsetEntries(appliedPodObj)) != len(getPodIpsetEntries(podObj)) {
+		t.Errorf("TestAppliedPods failed @ getPodIpsetEntries, trimmed pod has different entries")
+	}
+
+	// Deleting a recreated pod's predecessor keeps the new pod.
+	newPodObj := podObj.DeepCopy()
+	newPodObj.ObjectMeta.UID = "2"
+	allNs.setAppliedPod(newPodObj)
+	allNs.deleteAppliedPod(podObj)
+
+	if appliedPodObj := allNs.getAppliedPod("test-namespace/test-pod"); appliedPodObj == nil || appliedPodObj.ObjectMeta.UID != "2" {
+		t.Errorf("TestAppliedPods failed @ deleteAppliedPod, expected the new pod, got %+v", appliedPodObj)
+	}
+
+	if len(allNs.podMap) != 1 {
+		t.Errorf("TestAppliedPods failed @ deleteAppliedPod, expected 1 applied pod, got %d", len(allNs.podMap))
+	}
+}
+
+func TestGetPodIpsetEntriesDualStack(t *testing.T) {
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-namespace",
+			Labels:    map[string]string{"app": "test-pod"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 80}},
+				},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase:  "Running",
+			PodIP:  "1.2.3.4",
+			PodIPs: []corev1.PodIP{{IP: "1.2.3.4"}, {IP: "fd00::4"}},
+		},
+	}
+
+	// The IPv6 address is ignored unless ipsets have IPv6 twins to hold it.
+	if entries := getPodIpsetEntries(podObj); len(entries) != 3 {
+		t.Errorf("TestGetPodIpsetEntriesDualStack failed @ getPodIpsetEntries, expected 3 IPv4 entries, got %d", len(entries))
+	}
+
+	ipsm.SetDualStack(true)
+	defer ipsm.SetDualStack(false)
+
+	members := make(map[string]bool)
+	for _, entry := range getPodIpsetEntries(trimPod(podObj)) {
+		members[entry.set+" "+entry.member] = true
+	}
+
+	expected := []string{
+		"test-namespace 1.2.3.4",
+		"test-namespace fd00::4",
+		util.KubeAllNamespacesFlag + "-app:test-pod 1.2.3.4",
+		util.KubeAllNamespacesFlag + "-app:test-pod fd00::4",
+		util.NamedPortIPSetPrefix + "http 1.2.3.4,tcp:80",
+		util.NamedPortIPSetPrefix + "http fd00::4,tcp:80",
+	}
+
+	if len(members) != len(expected) {
+		t.Errorf("TestGetPodIpsetEntriesDualStack failed @ getPodIpsetEntries, unexpected entries %v", members)
+	}
+
+	for _, member := range expected {
+		if !members[member] {
+			t.Errorf("TestGetPodIpsetEntriesDualStack failed @ getPodIpsetEntries, missing entry %s", member)
+		}
+	}
+
+	// The ipset manager adds the members of each family to the set of that family.
+	ipsMgr := ipsm.NewIpsetManager()
+	ipsMgr.BeginBatch()
+	for _, entry := range getPodIpsetEntries(podObj) {
+		if err := ipsMgr.AddToSet(entry.set, entry.member); err != nil {
+			t.Errorf("TestGetPodIpsetEntriesDualStack failed @ ipsMgr.AddToSet, err:%v", err)
+		}
+	}
+
+	if !ipsMgr.Exists("test-namespace", "fd00::4", util.IpsetNetHashFlag) || !ipsMgr.Exists("test-namespace", "1.2.3.4", util.IpsetNetHashFlag) {
+		t.Errorf("TestGetPodIpsetEntriesDualStack failed @ ipsMgr.AddToSet, pod addresses missing from its namespace set")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// PolicyHitsPath is the URL path of the debug API serving the hit counters of the network policies.
+const PolicyHitsPath = "/debug/policyhits"
+
+// PolicyHits are the counters of the iptables rules of a network policy.
+type PolicyHits struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// getPolicyHits aggregates the counters of the rules in the iptables-save -c output by the network policies
+// producing them. Rules produced by several policies count toward each of them.
+func getPolicyHits(policies []*networkingv1.NetworkPolicy, iptablesSave string) map[string]*PolicyHits {
+	rulePolicies := make(map[string][]string)
+	hits := make(map[string]*PolicyHits)
+
+	for _, npObj := range policies {
+		policyKey := getObjectKey(npObj.ObjectMeta)
+		hits[policyKey] = &PolicyHits{}
+
+		// A policy producing the same rule twice, e.g. for equal peers, matches its packets once.
+		_, _, _, entries := parsePolicy(npObj)
+		seen := make(map[string]bool)
+		for _, entry := range entries {
+			key := parseDebugRule(entry.Chain, entry.Specs).key()
+			if !seen[key] {
+				seen[key] = true
+				rulePolicies[key] = append(rulePolicies[key], policyKey)
+			}
+		}
+	}
+
+	chains, _ := parseIptablesSave(iptablesSave)
+	for _, rules := range chains {
+		for _, rule := range rules {
+			for _, policyKey := range rulePolicies[rule.key()] {
+				hits[policyKey].Packets += rule.packets
+				hits[policyKey].Bytes += rule.bytes
+			}
+		}
+	}
+
+	return hits
+}
+
+// setPolicyHits records the hit counters of the applied network policies and exports them as metrics.
+// This function should only be called when npMgr is locked.
+func (npMgr *NetworkPolicyManager) setPolicyHits(hits map[string]*PolicyHits) {
+	packets := make(map[string]uint64)
+	bytes := make(map[string]uint64)
+	for policyKey, policyHits := range hits {
+		packets[policyKey] = policyHits.Packets
+		bytes[policyKey] = policyHits.Bytes
+	}
+
+	npMgr.policyHits = hits
+	metrics.PolicyHitPackets.Set(packets)
+	metrics.PolicyHitBytes.Set(bytes)
+}
+
+// PolicyHitsHandler returns the HTTP handler serving the last hit counters of the network policies as JSON,
+// keyed by namespace/name.
+func (npMgr *NetworkPolicyManager) PolicyHitsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		npMgr.Lock()
+		b, err := json.Marshal(npMgr.policyHits)
+		npMgr.Unlock()
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Write(b)
+	})
+}
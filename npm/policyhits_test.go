@@ -0,0 +1,50 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPolicyHits(t *testing.T) {
+	newPolicy := func(name string) *networkingv1.NetworkPolicy {
+		return &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "test",
+				Name:      name,
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "db"},
+				},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			},
+		}
+	}
+
+	// Both policies produce the default drop rule of their target pods.
+	policies := []*networkingv1.NetworkPolicy{newPolicy("deny-a"), newPolicy("deny-b")}
+	db := util.GetHashedName(util.KubeAllNamespacesFlag + "-app:db")
+
+	iptablesSave := `*filter
+:AZURE-NPM-TARGET-SETS - [0:0]
+[7:420] -A AZURE-NPM-TARGET-SETS -m set --match-set ` + db + ` dst -m mark ! --mark 0x2000/0x2000 -j DROP
+[3:180] -A AZURE-NPM-TARGET-SETS -m set --match-set ` + util.GetHashedName("other") + ` dst -j DROP
+COMMIT
+`
+
+	hits := getPolicyHits(policies, iptablesSave)
+	if len(hits) != 2 {
+		t.Fatalf("Unexpected hits %+v", hits)
+	}
+
+	for _, policyKey := range []string{"test/deny-a", "test/deny-b"} {
+		if *hits[policyKey] != (PolicyHits{Packets: 7, Bytes: 420}) {
+			t.Errorf("Unexpected hits of %s: %+v", policyKey, hits[policyKey])
+		}
+	}
+}
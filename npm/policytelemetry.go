@@ -0,0 +1,120 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/telemetry"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Categories of failures to apply a network policy to the dataplane.
+const (
+	policyFailureIpset    = "ipset"
+	policyFailureIptables = "iptables"
+	policyFailureSelector = "selector"
+	policyFailureUnknown  = "unknown"
+)
+
+// policyApplyError is a failure to apply a network policy to the dataplane.
+type policyApplyError struct {
+	category string
+	err      error
+}
+
+func (e *policyApplyError) Error() string {
+	return e.err.Error()
+}
+
+// newPolicyApplyError returns err as a failure of the given category, or nil if err is nil.
+func newPolicyApplyError(category string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &policyApplyError{category: category, err: err}
+}
+
+// getPolicyFailureCategory returns the category of a failure to apply a network policy.
+func getPolicyFailureCategory(err error) string {
+	if e, ok := err.(*policyApplyError); ok {
+		return e.category
+	}
+
+	return policyFailureUnknown
+}
+
+// validatePolicySelectors checks that the label selectors of a network policy can be resolved.
+func validatePolicySelectors(npObj *networkingv1.NetworkPolicy) error {
+	selectors := []*metav1.LabelSelector{&npObj.Spec.PodSelector}
+
+	for _, rule := range npObj.Spec.Ingress {
+		for _, peer := range rule.From {
+			selectors = append(selectors, peer.PodSelector, peer.NamespaceSelector)
+		}
+	}
+
+	for _, rule := range npObj.Spec.Egress {
+		for _, peer := range rule.To {
+			selectors = append(selectors, peer.PodSelector, peer.NamespaceSelector)
+		}
+	}
+
+	for _, selector := range selectors {
+		if selector == nil {
+			continue
+		}
+
+		if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+			return newPolicyApplyError(policyFailureSelector, fmt.Errorf("Invalid label selector %+v: %v", selector, err))
+		}
+	}
+
+	return nil
+}
+
+// reportPolicyApply records the result of applying a change of a network policy, and the latency
+// from the first event of the change to the dataplane being programmed, including retries.
+func (npMgr *NetworkPolicyManager) reportPolicyApply(key string, operation string, err error) {
+	eventTime, attempts := npMgr.npQueue.GetSyncInfo(key)
+	latency := time.Since(eventTime)
+
+	report := &telemetry.NPMPolicyApplyReport{
+		NodeName:  npMgr.nodeName,
+		PolicyKey: key,
+		Operation: operation,
+		Succeeded: err == nil,
+		LatencyMs: int64(latency / time.Millisecond),
+		Attempts:  attempts,
+		Timestamp: time.Now().UTC().String(),
+	}
+
+	if npmReport, ok := npMgr.reportManager.Report.(*telemetry.NPMReport); ok {
+		report.ClusterID = npmReport.ClusterID
+	}
+
+	if err != nil {
+		report.FailureCategory = getPolicyFailureCategory(err)
+		report.ErrorMessage = err.Error()
+		metrics.PolicyApplyFailures.Inc()
+	} else {
+		metrics.PolicyApplyLatency.Observe(latency.Seconds())
+	}
+
+	reportManager := &telemetry.ReportManager{
+		HostNetAgentURL: npMgr.reportManager.HostNetAgentURL,
+		ContentType:     npMgr.reportManager.ContentType,
+		Report:          report,
+	}
+
+	go func() {
+		if err := reportManager.SendReport(); err != nil {
+			log.Printf("Error sending network policy apply telemetry report: %v", err)
+		}
+	}()
+}
@@ -8,6 +8,8 @@ const (
 	KubePodTemplateHashFlag string = "pod-template-hash"
 	KubeAllPodsFlag         string = "all-pod"
 	KubeAllNamespacesFlag   string = "all-namespace"
+	KubeProtocolTCP         string = "TCP"
+	KubeProtocolSCTP        string = "SCTP"
 )
 
 //iptables related constants.
@@ -28,8 +30,13 @@ const (
 	IptablesAccept                string = "ACCEPT"
 	IptablesReject                string = "REJECT"
 	IptablesDrop                  string = "DROP"
+	IptablesReturn                string = "RETURN"
+	IptablesNflogTarget           string = "NFLOG"
+	IptablesNflogGroupFlag        string = "--nflog-group"
+	IptablesNflogPrefixFlag       string = "--nflog-prefix"
 	IptablesSrcFlag               string = "src"
 	IptablesDstFlag               string = "dst"
+	IptablesDstDstFlag            string = "dst,dst"
 	IptablesProtFlag              string = "-p"
 	IptablesSFlag                 string = "-s"
 	IptablesDFlag                 string = "-d"
@@ -47,7 +54,23 @@ const (
 	IptablesAzureEgressPortChain  string = "AZURE-NPM-EGRESS-PORT"
 	IptablesAzureEgressToChain    string = "AZURE-NPM-EGRESS-TO"
 	IptablesAzureTargetSetsChain  string = "AZURE-NPM-TARGET-SETS"
+	IptablesAzureAnpIngressChain  string = "AZURE-NPM-ANP-INGRESS"
+	IptablesAzureAnpEgressChain   string = "AZURE-NPM-ANP-EGRESS"
+	IptablesAzureBanpChain        string = "AZURE-NPM-BANP"
 	IptablesForwardChain          string = "FORWARD"
+	IptablesMark                  string = "mark"
+	IptablesMarkTarget            string = "MARK"
+	IptablesMarkFlag              string = "--mark"
+	IptablesSetMarkFlag           string = "--set-xmark"
+	IptablesNotFlag               string = "!"
+	IptablesAzureIngressMarkHex   string = "0x2000/0x2000"
+	IptablesAzureEgressMarkHex    string = "0x1000/0x1000"
+	// Network policies in audit mode mark the packets they allow with their own marks.
+	IptablesAzureAuditIngressMarkHex string = "0x800/0x800"
+	IptablesAzureAuditEgressMarkHex  string = "0x400/0x400"
+	// Packets without the mark of an enforced or an audited policy of their direction.
+	IptablesAzureUnmarkedIngressHex string = "0x0/0x2800"
+	IptablesAzureUnmarkedEgressHex  string = "0x0/0x1400"
 )
 
 //ipset related constants.
@@ -66,9 +89,24 @@ const (
 	IpsetExistFlag string = "-exist"
 	IpsetFileFlag  string = "-file"
 
-	IpsetSetListFlag string = "setlist"
-	IpsetNetHashFlag string = "nethash"
-	AzureNpmPrefix   string = "azure-npm-"
+	IpsetSetListFlag    string = "setlist"
+	IpsetNetHashFlag    string = "nethash"
+	IpsetIPPortHashFlag string = "hash:ip,port"
+	IpsetNomatch        string = "nomatch"
+	IpsetFamilyFlag     string = "family"
+	IpsetInet6Flag      string = "inet6"
+	AzureNpmPrefix      string = "azure-npm-"
+	AzureNpmIPv6Prefix  string = "azure-npm6-"
+
+	NamedPortIPSetPrefix string = "namedport:"
+	IPBlockIPSetPrefix   string = "ipblock:"
+	FQDNIPSetPrefix      string = "fqdn:"
+	IPv4AnyCIDR          string = "0.0.0.0/0"
+	IPv4LowerHalfCIDR    string = "0.0.0.0/1"
+	IPv4UpperHalfCIDR    string = "128.0.0.0/1"
+	IPv6AnyCIDR          string = "::/0"
+	IPv6LowerHalfCIDR    string = "::/1"
+	IPv6UpperHalfCIDR    string = "8000::/1"
 )
 
 //NPM telemetry constants.
@@ -84,4 +122,7 @@ const (
 	AddNetworkPolicyEvent    string = "Add network policy"
 	UpdateNetworkPolicyEvent string = "Update network policy"
 	DeleteNetworkPolicyEvent string = "Delete network policy"
+
+	DataplaneDriftEvent    string = "Dataplane drift"
+	UnsupportedPolicyEvent string = "Unsupported policy"
 )
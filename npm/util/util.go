@@ -53,3 +53,8 @@ func AppendMap(base, new map[string]string) map[string]string {
 func GetHashedName(name string) string {
 	return AzureNpmPrefix + Hash(name)
 }
+
+// GetHashedIPv6Name returns the hashed name of the IPv6 twin of an ipset.
+func GetHashedIPv6Name(name string) string {
+	return AzureNpmIPv6Prefix + Hash(name)
+}
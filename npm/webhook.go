@@ -0,0 +1,232 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// PolicyWebhookPath is the URL path the network policy webhook is served under.
+	PolicyWebhookPath = "/validate-networkpolicy"
+)
+
+// admissionReview is the subset of an admission.k8s.io/v1beta1 AdmissionReview used by the webhook.
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Kind       string             `json:"kind,omitempty"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+// admissionRequest describes the object being admitted.
+type admissionRequest struct {
+	UID       types.UID       `json:"uid"`
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object"`
+}
+
+// admissionResponse describes the admission decision.
+type admissionResponse struct {
+	UID      types.UID      `json:"uid"`
+	Allowed  bool           `json:"allowed"`
+	Result   *metav1.Status `json:"status,omitempty"`
+	Warnings []string       `json:"warnings,omitempty"`
+}
+
+// PolicyWebhook validates network policies against the features NPM can enforce on this platform.
+type PolicyWebhook struct {
+	// Deny rejects policies using unsupported features instead of only warning about them.
+	Deny bool
+}
+
+// StartPolicyWebhook serves the network policy webhook over TLS in the background.
+func StartPolicyWebhook(address string, certFile string, keyFile string, deny bool) {
+	mux := http.NewServeMux()
+	mux.Handle(PolicyWebhookPath, &PolicyWebhook{Deny: deny})
+
+	go func() {
+		if err := http.ListenAndServeTLS(address, certFile, keyFile, mux); err != nil {
+			log.Printf("[Azure-NPM] Network policy webhook failed with error %v.", err)
+		}
+	}()
+}
+
+// ServeHTTP handles an admission review request.
+func (wh *PolicyWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var review admissionReview
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &review)
+	}
+
+	if err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("Invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review.Response = wh.review(review.Request)
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(&review); err != nil {
+		log.Printf("[Azure-NPM] Failed to encode admission review response: %v.", err)
+	}
+}
+
+// review decides whether a network policy is admitted.
+func (wh *PolicyWebhook) review(req *admissionRequest) *admissionResponse {
+	resp := &admissionResponse{
+		UID:     req.UID,
+		Allowed: true,
+	}
+
+	var npObj networkingv1.NetworkPolicy
+	if err := json.Unmarshal(req.Object, &npObj); err != nil {
+		// Leave malformed objects to the API server's own validation.
+		log.Printf("[Azure-NPM] Failed to decode network policy in admission request %s: %v.", req.UID, err)
+		return resp
+	}
+
+	unsupported := getUnsupportedFeatures(&npObj)
+	if len(unsupported) == 0 {
+		return resp
+	}
+
+	name := npObj.ObjectMeta.Namespace + "/" + npObj.ObjectMeta.Name
+	log.Printf("[Azure-NPM] Network policy %s uses unsupported features: %v.", name, unsupported)
+
+	for _, feature := range unsupported {
+		resp.Warnings = append(resp.Warnings, "azure-npm cannot enforce "+feature)
+	}
+
+	if wh.Deny {
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+			Message: fmt.Sprintf("network policy %s uses features azure-npm cannot enforce: %s", name, strings.Join(unsupported, "; ")),
+		}
+	}
+
+	return resp
+}
+
+// getPolicyPeers returns the peers of all ingress and egress rules of a network policy.
+func getPolicyPeers(npObj *networkingv1.NetworkPolicy) []networkingv1.NetworkPolicyPeer {
+	var peers []networkingv1.NetworkPolicyPeer
+
+	for _, rule := range npObj.Spec.Ingress {
+		peers = append(peers, rule.From...)
+	}
+
+	for _, rule := range npObj.Spec.Egress {
+		peers = append(peers, rule.To...)
+	}
+
+	return peers
+}
+
+// getPolicyPorts returns the ports of all ingress or egress rules of a network policy.
+func getPolicyPorts(npObj *networkingv1.NetworkPolicy, isEgress bool) []networkingv1.NetworkPolicyPort {
+	var ports []networkingv1.NetworkPolicyPort
+
+	if !isEgress {
+		for _, rule := range npObj.Spec.Ingress {
+			ports = append(ports, rule.Ports...)
+		}
+	} else {
+		for _, rule := range npObj.Spec.Egress {
+			ports = append(ports, rule.Ports...)
+		}
+	}
+
+	return ports
+}
+
+// hasMatchExpressions checks if any label selector of a network policy uses match expressions.
+func hasMatchExpressions(npObj *networkingv1.NetworkPolicy) bool {
+	if len(npObj.Spec.PodSelector.MatchExpressions) > 0 {
+		return true
+	}
+
+	for _, peer := range getPolicyPeers(npObj) {
+		if peer.PodSelector != nil && len(peer.PodSelector.MatchExpressions) > 0 {
+			return true
+		}
+
+		if peer.NamespaceSelector != nil && len(peer.NamespaceSelector.MatchExpressions) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasCombinedSelectors checks if any peer of a network policy combines pod and namespace selectors.
+func hasCombinedSelectors(npObj *networkingv1.NetworkPolicy) bool {
+	for _, peer := range getPolicyPeers(npObj) {
+		if peer.PodSelector != nil && peer.NamespaceSelector != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasExceptRanges checks if any ipBlock peer of a network policy excepts ranges.
+func hasExceptRanges(npObj *networkingv1.NetworkPolicy) bool {
+	for _, peer := range getPolicyPeers(npObj) {
+		if peer.IPBlock != nil && len(peer.IPBlock.Except) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasNamedPorts checks if any ingress or egress port of a network policy is a named port.
+func hasNamedPorts(npObj *networkingv1.NetworkPolicy, isEgress bool) bool {
+	for _, port := range getPolicyPorts(npObj, isEgress) {
+		if port.Port != nil && port.Port.Type == intstr.String {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasPortRanges checks if any port of a network policy is a range of ports.
+func hasPortRanges(npObj *networkingv1.NetworkPolicy) bool {
+	for _, port := range append(getPolicyPorts(npObj, false), getPolicyPorts(npObj, true)...) {
+		if port.EndPort != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasProtocol checks if any port of a network policy uses the given protocol.
+func hasProtocol(npObj *networkingv1.NetworkPolicy, protocol string) bool {
+	for _, port := range append(getPolicyPorts(npObj, false), getPolicyPorts(npObj, true)...) {
+		if port.Protocol != nil && string(*port.Protocol) == protocol {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,26 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// getUnsupportedFeatures returns the features of a network policy that the dataplane can't enforce.
+func getUnsupportedFeatures(npObj *networkingv1.NetworkPolicy) []string {
+	var unsupported []string
+
+	if hasMatchExpressions(npObj) {
+		unsupported = append(unsupported, "matchExpressions in label selectors")
+	}
+
+	if hasCombinedSelectors(npObj) {
+		unsupported = append(unsupported, "podSelector and namespaceSelector in the same peer")
+	}
+
+	if isEbpfDataplane() && hasPortRanges(npObj) {
+		unsupported = append(unsupported, "port ranges")
+	}
+
+	return unsupported
+}
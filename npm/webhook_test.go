@@ -0,0 +1,93 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func getTestAdmissionReview(t *testing.T, npObj *networkingv1.NetworkPolicy) []byte {
+	object, err := json.Marshal(npObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal network policy: %v", err)
+	}
+
+	review, err := json.Marshal(&admissionReview{
+		APIVersion: "admission.k8s.io/v1beta1",
+		Kind:       "AdmissionReview",
+		Request: &admissionRequest{
+			UID:       "test-uid",
+			Operation: "CREATE",
+			Object:    object,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal admission review: %v", err)
+	}
+
+	return review
+}
+
+func postTestAdmissionReview(t *testing.T, wh *PolicyWebhook, body []byte) *admissionResponse {
+	w := httptest.NewRecorder()
+	wh.ServeHTTP(w, httptest.NewRequest(http.MethodPost, PolicyWebhookPath, bytes.NewReader(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d", w.Code)
+	}
+
+	var review admissionReview
+	if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil || review.Response == nil {
+		t.Fatalf("Failed to decode admission review response: %v", err)
+	}
+
+	if review.Response.UID != "test-uid" {
+		t.Errorf("Unexpected response uid %s", review.Response.UID)
+	}
+
+	return review.Response
+}
+
+func TestPolicyWebhook(t *testing.T) {
+	supported := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "deny-all"},
+	}
+
+	supportedBody := getTestAdmissionReview(t, supported)
+	if resp := postTestAdmissionReview(t, &PolicyWebhook{Deny: true}, supportedBody); !resp.Allowed || len(resp.Warnings) > 0 {
+		t.Errorf("TestPolicyWebhook failed, supported policy got %+v", resp)
+	}
+
+	unsupported := supported.DeepCopy()
+	unsupported.ObjectMeta.Name = "unsupported"
+	unsupported.Spec.PodSelector.MatchExpressions = []metav1.LabelSelectorRequirement{
+		{Key: "app", Operator: metav1.LabelSelectorOpExists},
+	}
+	unsupported.Spec.Ingress = []networkingv1.NetworkPolicyIngressRule{
+		{
+			From: []networkingv1.NetworkPolicyPeer{
+				{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.1.0.0/16"}}},
+			},
+		},
+	}
+
+	if len(getUnsupportedFeatures(unsupported)) == 0 {
+		t.Skip("All features of the test policy are supported on this platform")
+	}
+
+	unsupportedBody := getTestAdmissionReview(t, unsupported)
+	if resp := postTestAdmissionReview(t, &PolicyWebhook{Deny: false}, unsupportedBody); !resp.Allowed || len(resp.Warnings) == 0 {
+		t.Errorf("TestPolicyWebhook failed, expected warnings for unsupported policy, got %+v", resp)
+	}
+
+	if resp := postTestAdmissionReview(t, &PolicyWebhook{Deny: true}, unsupportedBody); resp.Allowed || resp.Result == nil {
+		t.Errorf("TestPolicyWebhook failed, expected unsupported policy to be denied, got %+v", resp)
+	}
+}
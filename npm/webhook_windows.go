@@ -0,0 +1,32 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"github.com/Azure/azure-container-networking/npm/util"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// getUnsupportedFeatures returns the features of a network policy that HNS ACL policies can't enforce.
+func getUnsupportedFeatures(npObj *networkingv1.NetworkPolicy) []string {
+	var unsupported []string
+
+	if hasExceptRanges(npObj) {
+		unsupported = append(unsupported, "except ranges in ipBlock peers")
+	}
+
+	if hasNamedPorts(npObj, true) {
+		unsupported = append(unsupported, "named ports in egress rules")
+	}
+
+	if hasPortRanges(npObj) {
+		unsupported = append(unsupported, "port ranges")
+	}
+
+	if hasProtocol(npObj, util.KubeProtocolSCTP) {
+		unsupported = append(unsupported, "the SCTP protocol")
+	}
+
+	return unsupported
+}
@@ -0,0 +1,263 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/metrics"
+	"github.com/Azure/azure-container-networking/npm/util"
+	"github.com/Azure/azure-container-networking/trace"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Work queue rate limits. Failed keys are retried with an exponential backoff.
+const (
+	workQueueQPS          = 50
+	workQueueBurst        = 100
+	workQueueRetryInitial = 1 * time.Second
+	workQueueRetryMax     = 5 * time.Minute
+)
+
+// workQueue is a rate limited queue of object keys.
+// A key is queued at most once and is never handed to more than one worker at a time,
+// so a burst of events for an object is coalesced into a single sync.
+type workQueue struct {
+	sync.Mutex
+	name       string
+	cond       *sync.Cond
+	queue      []string
+	queued     map[string]bool
+	processing map[string]bool
+	eventTimes map[string]time.Time
+	attempts   map[string]int
+	limiter    flowcontrol.RateLimiter
+	backoff    *flowcontrol.Backoff
+	shutdown   bool
+}
+
+// newWorkQueue creates a new instance of workQueue. Syncs are traced under the name of the queue.
+func newWorkQueue(name string) *workQueue {
+	q := &workQueue{
+		name:       name,
+		queued:     make(map[string]bool),
+		processing: make(map[string]bool),
+		eventTimes: make(map[string]time.Time),
+		attempts:   make(map[string]int),
+		limiter:    flowcontrol.NewTokenBucketRateLimiter(workQueueQPS, workQueueBurst),
+		backoff:    flowcontrol.NewBackOff(workQueueRetryInitial, workQueueRetryMax),
+	}
+	q.cond = sync.NewCond(q)
+
+	return q
+}
+
+// Add queues a key unless it is already waiting.
+func (q *workQueue) Add(key string) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.shutdown || q.queued[key] {
+		return
+	}
+
+	// Keep the time of the first event not yet synced, to measure the latency of syncs including retries.
+	if _, ok := q.eventTimes[key]; !ok {
+		q.eventTimes[key] = time.Now()
+	}
+
+	q.queued[key] = true
+
+	// Keys being processed are queued again once they are done.
+	if q.processing[key] {
+		return
+	}
+
+	q.push(key)
+}
+
+// push appends a key to the queue. It must be called with the queue locked.
+func (q *workQueue) push(key string) {
+	q.queue = append(q.queue, key)
+	metrics.EventQueueDepth.Inc()
+	q.cond.Signal()
+}
+
+// AddRateLimited queues a key again after its backoff delay.
+func (q *workQueue) AddRateLimited(key string) {
+	q.backoff.Next(key, q.backoff.Clock.Now())
+	time.AfterFunc(q.backoff.Get(key), func() { q.Add(key) })
+}
+
+// Forget resets the backoff of a key once it is synced.
+func (q *workQueue) Forget(key string) {
+	q.backoff.Reset(key)
+
+	q.Lock()
+	delete(q.attempts, key)
+	if q.queued[key] {
+		// The key changed again while it was synced.
+		q.eventTimes[key] = time.Now()
+	} else {
+		delete(q.eventTimes, key)
+	}
+	q.Unlock()
+}
+
+// GetSyncInfo returns the time of the first event of a key not yet synced and the number of sync attempts.
+func (q *workQueue) GetSyncInfo(key string) (time.Time, int) {
+	q.Lock()
+	defer q.Unlock()
+
+	eventTime, ok := q.eventTimes[key]
+	if !ok {
+		eventTime = time.Now()
+	}
+
+	return eventTime, q.attempts[key]
+}
+
+// Get blocks until a key can be processed. It returns false once the queue is shut down.
+func (q *workQueue) Get() (string, bool) {
+	q.limiter.Accept()
+
+	q.Lock()
+	defer q.Unlock()
+
+	for len(q.queue) == 0 && !q.shutdown {
+		q.cond.Wait()
+	}
+
+	if q.shutdown {
+		return "", false
+	}
+
+	key := q.queue[0]
+	q.queue = q.queue[1:]
+	metrics.EventQueueDepth.Dec()
+
+	delete(q.queued, key)
+	q.processing[key] = true
+	q.attempts[key]++
+
+	return key, true
+}
+
+// Done marks a key as processed.
+func (q *workQueue) Done(key string) {
+	q.Lock()
+	defer q.Unlock()
+
+	delete(q.processing, key)
+	if q.queued[key] && !q.shutdown {
+		q.push(key)
+	}
+}
+
+// Len returns the number of keys waiting to be processed.
+func (q *workQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return len(q.queue)
+}
+
+// ShutDown stops handing out keys and wakes up the waiting workers.
+func (q *workQueue) ShutDown() {
+	q.Lock()
+	defer q.Unlock()
+
+	metrics.EventQueueDepth.Add(-len(q.queue))
+	q.queue = nil
+	q.shutdown = true
+	q.cond.Broadcast()
+}
+
+// processNextKey syncs the next key of a queue. It returns false once the queue is shut down.
+func processNextKey(q *workQueue, syncKey func(key string) error) bool {
+	key, ok := q.Get()
+	if !ok {
+		return false
+	}
+
+	span := trace.StartSpan("npm.sync."+q.name, trace.SpanContext{})
+	span.SetAttribute("key", key)
+
+	err := syncKey(key)
+	if err != nil {
+		log.Printf("Error syncing %s, retrying: %v", key, err)
+		q.AddRateLimited(key)
+	} else {
+		q.Forget(key)
+	}
+
+	span.End(err)
+
+	q.Done(key)
+
+	return true
+}
+
+// runWorker syncs the keys of a queue until the queue is shut down.
+func runWorker(q *workQueue, syncKey func(key string) error) {
+	for processNextKey(q, syncKey) {
+	}
+}
+
+// drainQueue syncs the keys waiting in a queue and returns once it is empty.
+// Failed keys are retried later by the queue's worker.
+func drainQueue(q *workQueue, syncKey func(key string) error) {
+	for q.Len() > 0 && processNextKey(q, syncKey) {
+	}
+}
+
+// startWorker runs a worker for a queue and shuts the queue down when stopCh is closed.
+func startWorker(q *workQueue, syncKey func(key string) error, stopCh <-chan struct{}) {
+	go runWorker(q, syncKey)
+
+	go func() {
+		<-stopCh
+		q.ShutDown()
+	}()
+}
+
+// addResyncHandlers queues a full resync on any change to pods, namespaces or network policies.
+// Each queue holds the event message as its only key, so a burst of changes triggers one resync.
+func (npMgr *NetworkPolicyManager) addResyncHandlers() {
+	addHandler := func(informer cache.SharedIndexInformer, q *workQueue, addMsg, updateMsg, deleteMsg string) {
+		informer.AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				AddFunc:    func(obj interface{}) { q.Add(addMsg) },
+				UpdateFunc: func(old, new interface{}) { q.Add(updateMsg) },
+				DeleteFunc: func(obj interface{}) { q.Add(deleteMsg) },
+			},
+		)
+	}
+
+	addHandler(npMgr.podInformer.Informer(), npMgr.podQueue, util.AddPodEvent, util.UpdatePodEvent, util.DeletePodEvent)
+	addHandler(npMgr.nsInformer.Informer(), npMgr.nsQueue, util.AddNamespaceEvent, util.UpdateNamespaceEvent, util.DeleteNamespaceEvent)
+	addHandler(npMgr.npInformer.Informer(), npMgr.npQueue, util.AddNetworkPolicyEvent, util.UpdateNetworkPolicyEvent, util.DeleteNetworkPolicyEvent)
+}
+
+// getEnqueueHandler returns the informer event handler queueing the keys of changed objects.
+func getEnqueueHandler(q *workQueue) cache.ResourceEventHandlerFuncs {
+	enqueue := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			log.Printf("Error getting the key of %+v: %v", obj, err)
+			return
+		}
+
+		q.Add(key)
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(old, new interface{}) { enqueue(new) },
+		DeleteFunc: enqueue,
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"testing"
+)
+
+func TestWorkQueue(t *testing.T) {
+	q := newWorkQueue("test")
+
+	// Keys already waiting are coalesced.
+	q.Add("test/a")
+	q.Add("test/b")
+	q.Add("test/a")
+	if q.Len() != 2 {
+		t.Fatalf("Expected 2 queued keys, got %d", q.Len())
+	}
+
+	key, ok := q.Get()
+	if !ok || key != "test/a" {
+		t.Fatalf("Expected test/a, got %s", key)
+	}
+
+	// Keys being processed are queued again only once they are done.
+	q.Add("test/a")
+	if q.Len() != 1 {
+		t.Errorf("Expected a key being processed not to be handed out again, got %d queued keys", q.Len())
+	}
+
+	q.Done("test/a")
+	if q.Len() != 2 {
+		t.Errorf("Expected the key to be queued again once done, got %d queued keys", q.Len())
+	}
+
+	q.ShutDown()
+	if _, ok := q.Get(); ok {
+		t.Errorf("Expected no key after shutdown")
+	}
+}
+
+func TestWorkQueueSyncInfo(t *testing.T) {
+	q := newWorkQueue("test")
+
+	q.Add("test/a")
+	eventTime, _ := q.GetSyncInfo("test/a")
+
+	// Retries keep the time of the first event.
+	q.Get()
+	q.Done("test/a")
+	q.Add("test/a")
+	q.Get()
+
+	retryTime, attempts := q.GetSyncInfo("test/a")
+	if !retryTime.Equal(eventTime) || attempts != 2 {
+		t.Errorf("Expected the first event time and 2 attempts, got %v and %d", retryTime, attempts)
+	}
+
+	q.Forget("test/a")
+	q.Done("test/a")
+	if _, attempts = q.GetSyncInfo("test/a"); attempts != 0 {
+		t.Errorf("Expected sync info to be reset once synced, got %d attempts", attempts)
+	}
+}
@@ -12,6 +12,9 @@ import (
 
 const (
 	defaultMacForArpResponse = "12:34:56:78:9a:bc"
+
+	// Table tracked ingress traffic is recirculated to.
+	conntrackTable = 2
 )
 
 func CreateOVSBridge(bridgeName string) error {
@@ -40,6 +43,29 @@ func DeleteOVSBridge(bridgeName string) error {
 	return nil
 }
 
+// IsHardwareOffloadEnabled checks if OVS is configured to offload datapath flows to NICs.
+func IsHardwareOffloadEnabled() bool {
+	out, err := platform.ExecuteCommand("ovs-vsctl get Open_vSwitch . other_config:hw-offload")
+	if err != nil {
+		return false
+	}
+
+	return strings.Trim(out, "\"\n") == "true"
+}
+
+// EnableHardwareOffload configures OVS to offload datapath flows to NICs.
+func EnableHardwareOffload() error {
+	log.Printf("[ovs] Enabling hardware offload")
+
+	_, err := platform.ExecuteCommand("ovs-vsctl set Open_vSwitch . other_config:hw-offload=true")
+	if err != nil {
+		log.Printf("[ovs] Error while enabling hardware offload %v", err)
+		return err
+	}
+
+	return nil
+}
+
 func AddPortOnOVSBridge(hostIfName string, bridgeName string, vlanID int) error {
 	cmd := ""
 
@@ -196,6 +222,71 @@ func AddMacDnatRule(bridgeName string, port string, ip net.IP, mac string, vlani
 	return nil
 }
 
+// AddConntrackIpSnatRule is the stateful version of AddIpSnatRule. Egress connections are committed
+// to the given conntrack zone, so that AddConntrackMacDnatRule lets their return traffic in.
+func AddConntrackIpSnatRule(bridgeName string, port string, mac string, outport string, zone int) error {
+	if outport == "" {
+		outport = "normal"
+	}
+
+	cmd := fmt.Sprintf("ovs-ofctl add-flow %v 'priority=20,ip,in_port=%s,vlan_tci=0,actions=ct(commit,zone=%d),mod_dl_src:%s,strip_vlan,%v'",
+		bridgeName, port, zone, mac, outport)
+	_, err := platform.ExecuteCommand(cmd)
+	if err != nil {
+		log.Printf("[ovs] Adding conntrack IP SNAT rule failed with error %v", err)
+		return err
+	}
+
+	cmd = fmt.Sprintf("ovs-ofctl add-flow %v priority=10,ip,in_port=%s,actions=drop",
+		bridgeName, port)
+	_, err = platform.ExecuteCommand(cmd)
+	if err != nil {
+		log.Printf("[ovs] Dropping vlantag packet rule failed with error %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// AddConntrackMacDnatRule is the stateful version of AddMacDnatRule. Ingress traffic is tracked in the
+// conntrack zone of the endpoint and forwarded only if it belongs to a known connection, or if allowNew is set,
+// opens a new one. Invalid packets are dropped.
+func AddConntrackMacDnatRule(bridgeName string, port string, ip net.IP, mac string, vlanid int, zone int, allowNew bool) error {
+	match := fmt.Sprintf("ip,nw_dst=%s", ip.String())
+	if vlanid != 0 {
+		match = fmt.Sprintf("%s,dl_vlan=%v", match, vlanid)
+	}
+
+	cmd := fmt.Sprintf("ovs-ofctl add-flow %s '%s,in_port=%s,actions=ct(zone=%d,table=%d)'",
+		bridgeName, match, port, zone, conntrackTable)
+	if _, err := platform.ExecuteCommand(cmd); err != nil {
+		log.Printf("[ovs] Adding conntrack rule failed with error %v", err)
+		return err
+	}
+
+	states := []string{"+trk+est", "+trk+rel"}
+	for _, state := range states {
+		cmd = fmt.Sprintf("ovs-ofctl add-flow %s table=%d,priority=20,ct_state=%s,%s,actions=mod_dl_dst:%s,normal",
+			bridgeName, conntrackTable, state, match, mac)
+		if _, err := platform.ExecuteCommand(cmd); err != nil {
+			log.Printf("[ovs] Adding conntrack MAC DNAT rule failed with error %v", err)
+			return err
+		}
+	}
+
+	// Packets matching no flow of the table are dropped.
+	if allowNew {
+		cmd = fmt.Sprintf("ovs-ofctl add-flow %s 'table=%d,priority=10,ct_state=+trk+new,%s,actions=ct(commit,zone=%d),mod_dl_dst:%s,normal'",
+			bridgeName, conntrackTable, match, zone, mac)
+		if _, err := platform.ExecuteCommand(cmd); err != nil {
+			log.Printf("[ovs] Adding conntrack MAC DNAT rule failed with error %v", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
 func DeleteArpReplyRule(bridgeName string, port string, ip net.IP, vlanid int) {
 	cmd := fmt.Sprintf("ovs-ofctl del-flows %s arp,arp_op=1,in_port=%s",
 		bridgeName, port)
@@ -238,6 +329,22 @@ func DeleteMacDnatRule(bridgeName string, port string, ip net.IP, vlanid int) {
 	}
 }
 
+// DeleteConntrackMacDnatRule deletes the rules added by AddConntrackMacDnatRule.
+func DeleteConntrackMacDnatRule(bridgeName string, port string, ip net.IP, vlanid int) {
+	DeleteMacDnatRule(bridgeName, port, ip, vlanid)
+
+	match := fmt.Sprintf("ip,nw_dst=%s", ip.String())
+	if vlanid != 0 {
+		match = fmt.Sprintf("%s,dl_vlan=%v", match, vlanid)
+	}
+
+	cmd := fmt.Sprintf("ovs-ofctl del-flows %s table=%d,%s", bridgeName, conntrackTable, match)
+	_, err := platform.ExecuteCommand(cmd)
+	if err != nil {
+		log.Printf("[net] Deleting conntrack MAC DNAT rule failed with error %v", err)
+	}
+}
+
 func DeletePortFromOVS(bridgeName string, interfaceName string) error {
 	// Disconnect external interface from its bridge.
 	cmd := fmt.Sprintf("ovs-vsctl del-port %s %s", bridgeName, interfaceName)
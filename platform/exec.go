@@ -0,0 +1,112 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package platform
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultCommandTimeout is the time after which commands run without a deadline are killed.
+	DefaultCommandTimeout = 60 * time.Second
+)
+
+// CommandOutput is the output of a command.
+type CommandOutput struct {
+	Stdout string
+	Stderr string
+}
+
+// CommandError is a command that could not be started, exited with an error or was killed
+// when its deadline expired.
+type CommandError struct {
+	Command  string
+	ExitCode int
+	Stderr   string
+	TimedOut bool
+	Err      error
+}
+
+// Error returns the error of the command followed by its standard error output.
+func (e *CommandError) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("%s timed out:%s", e.Command, e.Stderr)
+	}
+
+	return fmt.Sprintf("%s:%s", e.Err.Error(), e.Stderr)
+}
+
+// ExecuteCommandContext runs a command without a shell, with the given standard input if any,
+// and captures its standard output and error separately. The command is killed along with its
+// child processes when the context is done, or after DefaultCommandTimeout if the context has
+// no deadline. An error returned for a command that ran is a *CommandError, with the exit code
+// of the command or -1 if it didn't exit.
+func ExecuteCommandContext(ctx context.Context, stdin io.Reader, name string, args ...string) (*CommandOutput, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultCommandTimeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+
+	command := strings.Join(append([]string{name}, args...), " ")
+
+	if err := cmd.Start(); err != nil {
+		return nil, &CommandError{Command: command, ExitCode: -1, Err: err}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var err error
+	timedOut := false
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		err = <-done
+		timedOut = true
+	}
+
+	output := &CommandOutput{Stdout: stdout.String(), Stderr: stderr.String()}
+
+	if timedOut {
+		return output, &CommandError{Command: command, ExitCode: -1, Stderr: output.Stderr, TimedOut: true, Err: ctx.Err()}
+	}
+
+	if err != nil {
+		exitCode := -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		}
+
+		return output, &CommandError{Command: command, ExitCode: exitCode, Stderr: output.Stderr, Err: err}
+	}
+
+	return output, nil
+}
+
+// GetExitCode returns the exit code of a failed command, -1 if it didn't exit.
+func GetExitCode(err error) int {
+	if e, ok := err.(*CommandError); ok {
+		return e.ExitCode
+	}
+
+	return -1
+}
@@ -0,0 +1,19 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package platform
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup runs a command in its own process group.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills a command and the processes it started.
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
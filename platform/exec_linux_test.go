@@ -0,0 +1,52 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package platform
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that the output streams and exit code of a command are captured separately.
+func TestExecuteCommandContextCapturesOutput(t *testing.T) {
+	out, err := ExecuteCommandContext(context.Background(), strings.NewReader("in"), "sh", "-c", "cat; echo err >&2")
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+
+	if out.Stdout != "in" || out.Stderr != "err\n" {
+		t.Errorf("Unexpected output %+v", out)
+	}
+
+	_, err = ExecuteCommandContext(context.Background(), nil, "sh", "-c", "echo failed >&2; exit 3")
+	e, ok := err.(*CommandError)
+	if !ok || e.ExitCode != 3 || e.TimedOut || e.Stderr != "failed\n" {
+		t.Errorf("Unexpected error %#v", err)
+	}
+
+	if code := GetExitCode(err); code != 3 {
+		t.Errorf("Unexpected exit code %d", code)
+	}
+}
+
+// Tests that a command and its children are killed when the deadline expires.
+func TestExecuteCommandContextKillsProcessGroupOnDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	// The child keeps the output open, so the call only returns once it is killed too.
+	_, err := ExecuteCommandContext(ctx, nil, "sh", "-c", "sleep 10 & sleep 10")
+	e, ok := err.(*CommandError)
+	if !ok || !e.TimedOut || e.ExitCode != -1 {
+		t.Errorf("Unexpected error %#v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Command was killed after %v", elapsed)
+	}
+}
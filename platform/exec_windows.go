@@ -0,0 +1,17 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package platform
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is a no-op since commands are killed individually on Windows.
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// killProcessGroup kills a command.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package platform
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KernelFeature is a kernel feature required by a component, with the modules and
+// user space tools it is made of.
+type KernelFeature struct {
+	Name     string
+	Modules  []string
+	Binaries []string
+	Hint     string
+}
+
+// Kernel features required by the CNI and NPM.
+var (
+	FeatureEbtables = KernelFeature{
+		Name:     "ebtables",
+		Modules:  []string{"ebtables", "ebtable_nat"},
+		Binaries: []string{"ebtables"},
+		Hint:     "install the ebtables package and enable CONFIG_BRIDGE_NF_EBTABLES and CONFIG_BRIDGE_EBT_T_NAT",
+	}
+	FeatureIptables = KernelFeature{
+		Name:     "iptables",
+		Modules:  []string{"ip_tables", "iptable_filter"},
+		Binaries: []string{"iptables"},
+		Hint:     "install the iptables package and enable CONFIG_IP_NF_IPTABLES and CONFIG_IP_NF_FILTER",
+	}
+	FeatureIp6tables = KernelFeature{
+		Name:     "ip6tables",
+		Modules:  []string{"ip6_tables", "ip6table_filter"},
+		Binaries: []string{"ip6tables"},
+		Hint:     "install the iptables package and enable CONFIG_IP6_NF_IPTABLES and CONFIG_IP6_NF_FILTER",
+	}
+	FeatureIpset = KernelFeature{
+		Name:     "ipset",
+		Modules:  []string{"ip_set", "ip_set_hash_net", "ip_set_list_set", "xt_set"},
+		Binaries: []string{"ipset"},
+		Hint:     "install the ipset package and enable CONFIG_IP_SET, CONFIG_IP_SET_HASH_NET, CONFIG_IP_SET_LIST_SET and CONFIG_NETFILTER_XT_SET",
+	}
+	FeatureVxlan = KernelFeature{
+		Name:    "vxlan",
+		Modules: []string{"vxlan"},
+		Hint:    "enable CONFIG_VXLAN",
+	}
+	FeatureGeneve = KernelFeature{
+		Name:    "geneve",
+		Modules: []string{"geneve"},
+		Hint:    "enable CONFIG_GENEVE",
+	}
+	FeatureIpvlan = KernelFeature{
+		Name:    "ipvlan",
+		Modules: []string{"ipvlan"},
+		Hint:    "enable CONFIG_IPVLAN",
+	}
+	FeatureNftables = KernelFeature{
+		Name:     "nftables",
+		Modules:  []string{"nf_tables"},
+		Binaries: []string{"nft"},
+		Hint:     "install the nftables package and enable CONFIG_NF_TABLES",
+	}
+	FeatureBpfClassifier = KernelFeature{
+		Name:    "bpf classifier",
+		Modules: []string{"cls_bpf", "sch_ingress"},
+		Hint:    "enable CONFIG_BPF_SYSCALL, CONFIG_NET_CLS_BPF and CONFIG_NET_SCH_INGRESS",
+	}
+)
+
+// MissingKernelFeaturesError lists the kernel features missing on the host, with what is
+// missing of each and how to fix it.
+type MissingKernelFeaturesError struct {
+	Missing []string
+}
+
+func (e *MissingKernelFeaturesError) Error() string {
+	return "Missing kernel features: " + strings.Join(e.Missing, "; ")
+}
+
+// CheckKernelFeatures checks that the given kernel features are available on the host.
+func CheckKernelFeatures(features ...KernelFeature) error {
+	var missing []string
+
+	for _, feature := range features {
+		var parts []string
+
+		for _, module := range feature.Modules {
+			if !isKernelModuleAvailable(module) {
+				parts = append(parts, "module "+module)
+			}
+		}
+
+		for _, binary := range feature.Binaries {
+			if !isBinaryAvailable(binary) {
+				parts = append(parts, "binary "+binary)
+			}
+		}
+
+		if len(parts) > 0 {
+			missing = append(missing, fmt.Sprintf("%s (%s not found, %s)", feature.Name, strings.Join(parts, ", "), feature.Hint))
+		}
+	}
+
+	if len(missing) > 0 {
+		return &MissingKernelFeaturesError{Missing: missing}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package platform
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+var (
+	// Directories of the loaded and the installed kernel modules.
+	sysModulePath = "/sys/module/"
+	libModulePath = "/lib/modules/"
+
+	// File holding the release of the running kernel.
+	kernelReleaseFile = "/proc/sys/kernel/osrelease"
+)
+
+// isKernelModuleAvailable checks if a kernel module is loaded, built in the kernel,
+// or installed so that the kernel loads it on first use.
+func isKernelModuleAvailable(module string) bool {
+	module = strings.Replace(module, "-", "_", -1)
+
+	if _, err := os.Stat(sysModulePath + module); err == nil {
+		return true
+	}
+
+	release, err := ioutil.ReadFile(kernelReleaseFile)
+	if err != nil {
+		return false
+	}
+
+	dir := libModulePath + strings.TrimSpace(string(release)) + "/"
+	return listsKernelModule(dir+"modules.builtin", module) || listsKernelModule(dir+"modules.dep", module)
+}
+
+// listsKernelModule checks if a modules.builtin or modules.dep file lists a kernel module.
+func listsKernelModule(fileName string, module string) bool {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Lines start with the module path, such as kernel/net/bridge/netfilter/ebtables.ko.xz.
+		modulePath := strings.SplitN(scanner.Text(), ":", 2)[0]
+		name := path.Base(modulePath)
+		if i := strings.Index(name, ".ko"); i >= 0 {
+			name = name[:i]
+		}
+
+		if strings.Replace(name, "-", "_", -1) == module {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isBinaryAvailable checks if a user space tool is in the path.
+func isBinaryAvailable(binary string) bool {
+	_, err := exec.LookPath(binary)
+	return err == nil
+}
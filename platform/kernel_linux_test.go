@@ -0,0 +1,55 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package platform
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Tests that kernel modules are found loaded, built in or installed.
+func TestCheckKernelFeatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kernel")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedSys, savedLib, savedRelease := sysModulePath, libModulePath, kernelReleaseFile
+	defer func() { sysModulePath, libModulePath, kernelReleaseFile = savedSys, savedLib, savedRelease }()
+
+	sysModulePath = dir + "/sys/"
+	libModulePath = dir + "/lib/"
+	kernelReleaseFile = dir + "/osrelease"
+
+	os.MkdirAll(sysModulePath+"ip_set", 0755)
+	os.MkdirAll(libModulePath+"4.15.0-test", 0755)
+	ioutil.WriteFile(kernelReleaseFile, []byte("4.15.0-test\n"), 0644)
+	ioutil.WriteFile(libModulePath+"4.15.0-test/modules.builtin", []byte("kernel/net/ipv4/netfilter/ip_tables.ko\n"), 0644)
+	ioutil.WriteFile(libModulePath+"4.15.0-test/modules.dep",
+		[]byte("kernel/net/ipv4/netfilter/iptable_filter.ko.xz: kernel/net/ipv4/netfilter/ip_tables.ko\n"), 0644)
+
+	for _, module := range []string{"ip_set", "ip_tables", "iptable_filter", "iptable-filter"} {
+		if !isKernelModuleAvailable(module) {
+			t.Errorf("Module %v not found", module)
+		}
+	}
+
+	if isKernelModuleAvailable("vxlan") {
+		t.Errorf("Missing module vxlan found")
+	}
+
+	err = CheckKernelFeatures(KernelFeature{Name: "test", Modules: []string{"ip_set"}},
+		KernelFeature{Name: "overlay", Modules: []string{"vxlan"}, Binaries: []string{"missing-binary"}, Hint: "enable CONFIG_VXLAN"})
+	if _, ok := err.(*MissingKernelFeaturesError); !ok {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "overlay (module vxlan, binary missing-binary not found, enable CONFIG_VXLAN)") ||
+		strings.Contains(err.Error(), "test") {
+		t.Errorf("Unexpected error message %q", err.Error())
+	}
+}
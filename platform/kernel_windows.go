@@ -0,0 +1,14 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package platform
+
+// isKernelModuleAvailable returns true since Linux kernel features aren't required on Windows.
+func isKernelModuleAvailable(module string) bool {
+	return true
+}
+
+// isBinaryAvailable returns true since Linux user space tools aren't required on Windows.
+func isBinaryAvailable(binary string) bool {
+	return true
+}
@@ -4,12 +4,12 @@
 package platform
 
 import (
-	"bytes"
-	"fmt"
+	"context"
 	"io/ioutil"
 	"os/exec"
 	"time"
 
+	"github.com/Azure/azure-container-networking/iptables"
 	"github.com/Azure/azure-container-networking/log"
 )
 
@@ -61,27 +61,25 @@ func GetLastRebootTime() (time.Time, error) {
 	return rebootTime.UTC(), nil
 }
 
+// ExecuteCommand runs a shell command, killing it after DefaultCommandTimeout.
 func ExecuteCommand(command string) (string, error) {
 	log.Printf("[Azure-Utils] %s", command)
 
-	var stderr bytes.Buffer
-	var out bytes.Buffer
-	cmd := exec.Command("sh", "-c", command)
-	cmd.Stderr = &stderr
-	cmd.Stdout = &out
-
-	err := cmd.Run()
+	out, err := ExecuteCommandContext(context.Background(), nil, "sh", "-c", command)
 	if err != nil {
-		return "", fmt.Errorf("%s:%s", err.Error(), stderr.String())
+		return "", err
 	}
 
-	return out.String(), nil
+	return out.Stdout, nil
+}
+
+// getOutboundSNATSpec returns the rule masquerading traffic leaving a subnet, except to the host and wireserver.
+func getOutboundSNATSpec(subnet string) []string {
+	return []string{"-m", "iprange", "!", "--dst-range", "168.63.129.16", "-m", "addrtype", "!", "--dst-type", "local", "!", "-d", subnet, "-j", "MASQUERADE"}
 }
 
 func SetOutboundSNAT(subnet string) error {
-	cmd := fmt.Sprintf("iptables -t nat -A POSTROUTING -m iprange ! --dst-range 168.63.129.16 -m addrtype ! --dst-type local ! -d %v -j MASQUERADE",
-		subnet)
-	_, err := ExecuteCommand(cmd)
+	err := iptables.GetClient().Append(iptables.Nat, "POSTROUTING", getOutboundSNATSpec(subnet)...)
 	if err != nil {
 		log.Printf("SNAT Iptable rule was not set")
 		return err
@@ -89,6 +87,11 @@ func SetOutboundSNAT(subnet string) error {
 	return nil
 }
 
+// DeleteOutboundSNAT deletes the SNAT rule of a subnet.
+func DeleteOutboundSNAT(subnet string) error {
+	return iptables.GetClient().Delete(iptables.Nat, "POSTROUTING", getOutboundSNATSpec(subnet)...)
+}
+
 // ClearNetworkConfiguration clears the azure-vnet.json contents.
 // This will be called only when reboot is detected - This is windows specific
 func ClearNetworkConfiguration() (bool, error) {
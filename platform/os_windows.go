@@ -110,6 +110,10 @@ func SetOutboundSNAT(subnet string) error {
 	return nil
 }
 
+func DeleteOutboundSNAT(subnet string) error {
+	return nil
+}
+
 // ClearNetworkConfiguration clears the azure-vnet.json contents.
 // This will be called only when reboot is detected - This is windows specific
 func ClearNetworkConfiguration() (bool, error) {
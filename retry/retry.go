@@ -0,0 +1,172 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Factor by which the delay grows after each attempt if the policy doesn't set one.
+	defaultMultiplier = 2.0
+)
+
+// Policy describes how a failed operation is retried.
+type Policy struct {
+	// Maximum number of attempts, including the first one. Zero retries until the context is done.
+	MaxAttempts int
+
+	// Delay before the first retry.
+	InitialDelay time.Duration
+
+	// Upper bound of the delay between attempts, zero for no bound.
+	MaxDelay time.Duration
+
+	// Factor by which the delay grows after each retry, 2 if not set.
+	Multiplier float64
+
+	// Fraction of each delay that is randomized, between 0 and 1, to spread out retries of
+	// processes that failed at the same time.
+	Jitter float64
+
+	// IsRetryable reports whether an error is transient. All errors are retried if not set.
+	IsRetryable func(err error) bool
+}
+
+// permanentError is an error that is never retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+var (
+	// Source of the jitter of delays, seeded so that processes don't retry in lockstep.
+	random     = rand.New(rand.NewSource(time.Now().UnixNano()))
+	randomLock sync.Mutex
+)
+
+// Permanent marks an error returned by a retried operation as not retryable, whatever the policy.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// Do calls fn until it succeeds, returns an error that isn't retryable, runs out of attempts or
+// the context is done, waiting between attempts as set by the policy. It returns the last error
+// of fn as is, or the error of the context if fn was never called.
+func Do(ctx context.Context, policy *Policy, fn func() error) error {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		if ctx.Err() != nil {
+			if err == nil {
+				err = ctx.Err()
+			}
+			return err
+		}
+
+		err = fn()
+		if err == nil {
+			return nil
+		}
+
+		if p, ok := err.(*permanentError); ok {
+			return p.err
+		}
+
+		if policy.IsRetryable != nil && !policy.IsRetryable(err) {
+			return err
+		}
+
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(policy.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// Delay returns the delay before the given retry, counting from 1.
+func (policy *Policy) Delay(retry int) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultMultiplier
+	}
+
+	delay := float64(policy.InitialDelay)
+	for i := 1; i < retry; i++ {
+		delay *= multiplier
+		if policy.MaxDelay > 0 && delay >= float64(policy.MaxDelay) {
+			break
+		}
+	}
+
+	if policy.MaxDelay > 0 && delay > float64(policy.MaxDelay) {
+		delay = float64(policy.MaxDelay)
+	}
+
+	if policy.Jitter > 0 {
+		jitter := policy.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+
+		randomLock.Lock()
+		r := random.Float64()
+		randomLock.Unlock()
+
+		// Spread the delay evenly around its nominal value.
+		delay += delay * jitter * (2*r - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// IsTemporary is a retryable-error predicate for errors that report themselves as temporary or
+// as timeouts, such as network errors.
+func IsTemporary(err error) bool {
+	if e, ok := err.(interface{ Timeout() bool }); ok && e.Timeout() {
+		return true
+	}
+
+	if e, ok := err.(interface{ Temporary() bool }); ok && e.Temporary() {
+		return true
+	}
+
+	return false
+}
+
+// IsErrno returns a retryable-error predicate for the given system error numbers.
+func IsErrno(errnos ...syscall.Errno) func(err error) bool {
+	return func(err error) bool {
+		if errno, ok := err.(syscall.Errno); ok {
+			for _, e := range errnos {
+				if errno == e {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package retry
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var errTransient = fmt.Errorf("transient")
+
+// Tests that operations are retried until they succeed or run out of attempts.
+func TestDoRetriesUntilSuccessOrMaxAttempts(t *testing.T) {
+	policy := &Policy{MaxAttempts: 3, InitialDelay: time.Millisecond}
+
+	calls := 0
+	err := Do(context.Background(), policy, func() error {
+		calls++
+		if calls < 2 {
+			return errTransient
+		}
+		return nil
+	})
+
+	if err != nil || calls != 2 {
+		t.Errorf("Expected success after 2 calls, got %v after %d", err, calls)
+	}
+
+	calls = 0
+	err = Do(context.Background(), policy, func() error {
+		calls++
+		return errTransient
+	})
+
+	if err != errTransient || calls != 3 {
+		t.Errorf("Expected the last error after 3 calls, got %v after %d", err, calls)
+	}
+}
+
+// Tests that errors that aren't retryable are returned immediately.
+func TestDoStopsOnErrorsThatAreNotRetryable(t *testing.T) {
+	policy := &Policy{
+		InitialDelay: time.Millisecond,
+		IsRetryable:  IsErrno(syscall.EBUSY),
+	}
+
+	calls := 0
+	err := Do(context.Background(), policy, func() error {
+		calls++
+		if calls == 1 {
+			return syscall.EBUSY
+		}
+		return syscall.EINVAL
+	})
+
+	if err != syscall.EINVAL || calls != 2 {
+		t.Errorf("Expected EINVAL after 2 calls, got %v after %d", err, calls)
+	}
+
+	calls = 0
+	err = Do(context.Background(), policy, func() error {
+		calls++
+		return Permanent(syscall.EBUSY)
+	})
+
+	if err != syscall.EBUSY || calls != 1 {
+		t.Errorf("Expected a permanent EBUSY after 1 call, got %v after %d", err, calls)
+	}
+}
+
+// Tests that retries stop when the context is done.
+func TestDoStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	err := Do(ctx, &Policy{InitialDelay: 5 * time.Millisecond}, func() error {
+		calls++
+		return errTransient
+	})
+
+	if err != errTransient || calls < 2 {
+		t.Errorf("Expected the last error after several calls, got %v after %d", err, calls)
+	}
+
+	if err = Do(ctx, &Policy{}, func() error { return nil }); err != context.DeadlineExceeded {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+}
+
+// Tests that delays grow exponentially up to the maximum, within the jitter.
+func TestDelayBacksOffExponentiallyWithJitter(t *testing.T) {
+	policy := &Policy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		if d := policy.Delay(i + 1); d != delay {
+			t.Errorf("Expected delay %v before retry %d, got %v", delay, i+1, d)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := policy.Delay(2); d < time.Second || d > 3*time.Second {
+			t.Fatalf("Delay %v is outside of the jitter range", d)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// Default file name for backing BoltDB store.
+	defaultBoltFileName = "azure-container-networking.db"
+
+	// Bucket holding the key value pairs.
+	boltBucket = "state"
+
+	// Time to wait for the database file lock on non-blocking lock calls.
+	boltTryLockTimeout = lockRetryDelay
+)
+
+// boltStore is an implementation of KeyValueStore using a local BoltDB database.
+// Each write is committed in its own transaction, so the database is never left half-written
+// by a crash or a power loss. The file lock of the database is the lock of the store, and is
+// released by the OS when its owner exits.
+type boltStore struct {
+	fileName    string
+	db          *bolt.DB
+	locked      bool
+	lockTimeout time.Duration
+	encryptor   *encryptor
+	sync.Mutex
+}
+
+// NewBoltStore creates a new boltStore object, accessed as a KeyValueStore.
+func NewBoltStore(fileName string) (KeyValueStore, error) {
+	if fileName == "" {
+		fileName = defaultBoltFileName
+	}
+
+	encryptor, err := getEncryptor()
+	if err != nil {
+		return nil, err
+	}
+
+	kvs := &boltStore{
+		fileName:    fileName,
+		lockTimeout: getLockTimeout(),
+		encryptor:   encryptor,
+	}
+
+	return kvs, nil
+}
+
+// open opens the database, waiting for the file lock up to the given timeout.
+func (kvs *boltStore) open(timeout time.Duration) (*bolt.DB, error) {
+	db, err := bolt.Open(kvs.fileName, 0664, &bolt.Options{Timeout: timeout})
+	if err == bolt.ErrTimeout {
+		return nil, ErrTimeoutLockingStore
+	}
+
+	return db, err
+}
+
+// withDB calls fn with the database of the locked store, or opens the database for the call.
+func (kvs *boltStore) withDB(fn func(db *bolt.DB) error) error {
+	if kvs.db != nil {
+		return fn(kvs.db)
+	}
+
+	db, err := kvs.open(kvs.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return fn(db)
+}
+
+// Read restores the value for the given key from persistent store.
+func (kvs *boltStore) Read(key string, value interface{}) error {
+	kvs.Mutex.Lock()
+	defer kvs.Mutex.Unlock()
+
+	// Don't create the database to read from it.
+	if kvs.db == nil {
+		if _, err := os.Stat(kvs.fileName); os.IsNotExist(err) {
+			return ErrKeyNotFound
+		}
+	}
+
+	return kvs.withDB(func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte(boltBucket))
+			if bucket == nil {
+				return ErrKeyNotFound
+			}
+
+			raw := bucket.Get([]byte(key))
+			if raw == nil {
+				return ErrKeyNotFound
+			}
+
+			raw, err := unseal(kvs.encryptor, raw)
+			if err != nil {
+				return err
+			}
+
+			return json.Unmarshal(raw, value)
+		})
+	})
+}
+
+// Write saves the given key value pair to persistent store.
+func (kvs *boltStore) Write(key string, value interface{}) error {
+	kvs.Mutex.Lock()
+	defer kvs.Mutex.Unlock()
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	raw, err = seal(kvs.encryptor, raw)
+	if err != nil {
+		return err
+	}
+
+	return kvs.withDB(func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists([]byte(boltBucket))
+			if err != nil {
+				return err
+			}
+
+			return bucket.Put([]byte(key), raw)
+		})
+	})
+}
+
+// Flush is a no-op since every write is committed to persistent store.
+func (kvs *boltStore) Flush() error {
+	return nil
+}
+
+// Lock locks the store for exclusive access.
+func (kvs *boltStore) Lock(block bool) error {
+	kvs.Mutex.Lock()
+	defer kvs.Mutex.Unlock()
+
+	if kvs.locked {
+		return ErrStoreLocked
+	}
+
+	timeout := kvs.lockTimeout
+	if !block {
+		timeout = boltTryLockTimeout
+	}
+
+	db, err := kvs.open(timeout)
+	if err != nil {
+		if err == ErrTimeoutLockingStore && !block {
+			return ErrNonBlockingLockIsAlreadyLocked
+		}
+		return err
+	}
+
+	kvs.db = db
+	kvs.locked = true
+
+	return nil
+}
+
+// Unlock unlocks the store.
+func (kvs *boltStore) Unlock(forceUnlock bool) error {
+	kvs.Mutex.Lock()
+	defer kvs.Mutex.Unlock()
+
+	if !forceUnlock && !kvs.locked {
+		return ErrStoreNotLocked
+	}
+
+	if kvs.db != nil {
+		if err := kvs.db.Close(); err != nil {
+			return err
+		}
+	}
+
+	kvs.db = nil
+	kvs.locked = false
+
+	return nil
+}
+
+// GetModificationTime returns the modification time of the persistent store.
+func (kvs *boltStore) GetModificationTime() (time.Time, error) {
+	kvs.Mutex.Lock()
+	defer kvs.Mutex.Unlock()
+
+	info, err := os.Stat(kvs.fileName)
+	if err != nil {
+		log.Printf("os.stat() for file %v failed: %v", kvs.fileName, err)
+		return time.Time{}.UTC(), err
+	}
+
+	return info.ModTime().UTC(), nil
+}
+
+// GetLockFileModificationTime returns an error since the store has no lock file
+// that could be left behind by a crashed process.
+func (kvs *boltStore) GetLockFileModificationTime() (time.Time, error) {
+	return time.Time{}.UTC(), ErrStoreNotLocked
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+const (
+	// File name used for test BoltDB store.
+	testBoltFileName = "test.db"
+)
+
+// Tests that key value pairs written to a BoltDB store are read back by another store.
+func TestBoltStoreKeyValuePairsArePersisted(t *testing.T) {
+	var writtenValue = testType1{"test", 42}
+	var anotherValue = testType1{"any", 14}
+	var readValue testType1
+
+	defer os.Remove(testBoltFileName)
+
+	kvs, err := NewBoltStore(testBoltFileName)
+	if err != nil {
+		t.Fatalf("Failed to create KeyValueStore %v\n", err)
+	}
+
+	// Reading a store that doesn't exist yet doesn't create it.
+	if err = kvs.Read(testKey1, &readValue); err != ErrKeyNotFound {
+		t.Errorf("Read from empty store returned %v", err)
+	}
+	if _, err = os.Stat(testBoltFileName); !os.IsNotExist(err) {
+		t.Errorf("Read created the store file")
+	}
+
+	err = kvs.Write(testKey1, &writtenValue)
+	if err != nil {
+		t.Fatalf("Failed to write to store %v", err)
+	}
+
+	err = kvs.Write(testKey2, &anotherValue)
+	if err != nil {
+		t.Fatalf("Failed to write to store %v", err)
+	}
+
+	// Read the first pair back through a second store.
+	kvs2, err := NewBoltStore(testBoltFileName)
+	if err != nil {
+		t.Fatalf("Failed to create KeyValueStore %v\n", err)
+	}
+
+	err = kvs2.Read(testKey1, &readValue)
+	if err != nil {
+		t.Fatalf("Failed to read from store %v", err)
+	}
+
+	if readValue != writtenValue {
+		t.Errorf("Read pair (%v, %v) does not match the written pair (%v, %v)",
+			testKey1, readValue, testKey1, writtenValue)
+	}
+
+	if err = kvs2.Read("key3", &readValue); err != ErrKeyNotFound {
+		t.Errorf("Read of missing key returned %v", err)
+	}
+
+	if _, err = kvs2.GetModificationTime(); err != nil {
+		t.Errorf("Failed to get modification time %v", err)
+	}
+}
+
+// Tests that locking a BoltDB store gives the caller exclusive access.
+func TestBoltStoreLockingGivesExclusiveAccess(t *testing.T) {
+	var anyValue = testType1{"test", 42}
+
+	defer os.Remove(testBoltFileName)
+
+	kvs, _ := NewBoltStore(testBoltFileName)
+	kvs2, _ := NewBoltStore(testBoltFileName)
+
+	err := kvs.Lock(false)
+	if err != nil {
+		t.Fatalf("Failed to lock store: %v", err)
+	}
+
+	err = kvs.Write(testKey1, &anyValue)
+	if err != nil {
+		t.Fatalf("Failed to write to store: %v", err)
+	}
+
+	// This should fail because the first store has exclusive access.
+	err = kvs2.Lock(false)
+	if err != ErrNonBlockingLockIsAlreadyLocked {
+		t.Errorf("Locking an already-locked store returned %v", err)
+	}
+
+	err = kvs.Unlock(false)
+	if err != nil {
+		t.Errorf("Failed to unlock first store: %v", err)
+	}
+
+	err = kvs2.Lock(false)
+	if err != nil {
+		t.Errorf("Failed to re-lock an unlocked store: %v", err)
+	}
+
+	err = kvs2.Unlock(false)
+	if err != nil {
+		t.Errorf("Failed to unlock second store: %v", err)
+	}
+
+	err = kvs2.Unlock(false)
+	if err != ErrStoreNotLocked {
+		t.Errorf("Unlocking an unlocked store returned %v", err)
+	}
+}
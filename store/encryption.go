@@ -0,0 +1,170 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// KeyFileEnv is the environment variable holding the path of the key encrypting persisted
+	// state. The file holds a 256-bit key encoded in hex or base64. On Windows, a path prefixed
+	// with "dpapi:" holds a key protected with DPAPI for the local machine, created on first use.
+	// State is persisted in clear text if it isn't set.
+	KeyFileEnv = "ACN_STORE_KEY_FILE"
+
+	// Prefix of key files protected with DPAPI.
+	dpapiKeyPrefix = "dpapi:"
+
+	// Size of encryption keys.
+	keySize = 32
+)
+
+var (
+	// Header of encrypted state, followed by the nonce and the sealed contents.
+	encryptedHeader = []byte("ACNENC1:")
+
+	// Errors returned when encrypting and decrypting state.
+	errStoreEncrypted = fmt.Errorf("store is encrypted and no key is set in %s", KeyFileEnv)
+	errInvalidKeySize = fmt.Errorf("encryption key must be %d bytes", keySize)
+)
+
+// encryptor encrypts and decrypts persisted state with AES-GCM.
+type encryptor struct {
+	aead cipher.AEAD
+}
+
+// newEncryptor creates an encryptor with the given key.
+func newEncryptor(key []byte) (*encryptor, error) {
+	if len(key) != keySize {
+		return nil, errInvalidKeySize
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptor{aead: aead}, nil
+}
+
+// getEncryptor returns the encryptor of the key set in the environment, nil if none is set.
+func getEncryptor() (*encryptor, error) {
+	source := os.Getenv(KeyFileEnv)
+	if source == "" {
+		return nil, nil
+	}
+
+	var key []byte
+	var err error
+	if strings.HasPrefix(source, dpapiKeyPrefix) {
+		key, err = loadProtectedKey(strings.TrimPrefix(source, dpapiKeyPrefix))
+	} else {
+		key, err = loadKey(source)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load store encryption key from %s: %v", source, err)
+	}
+
+	return newEncryptor(key)
+}
+
+// loadKey reads an encryption key from a file.
+func loadKey(fileName string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseKey(string(bytes.TrimSpace(buf)))
+}
+
+// parseKey decodes an encryption key in hex or base64.
+func parseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key is neither hex nor base64")
+		}
+	}
+
+	if len(key) != keySize {
+		return nil, errInvalidKeySize
+	}
+
+	return key, nil
+}
+
+// isEncrypted checks if persisted state is encrypted.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedHeader)
+}
+
+// encrypt encrypts state to persist.
+func (e *encryptor) encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	data := append([]byte{}, encryptedHeader...)
+	data = append(data, nonce...)
+
+	return e.aead.Seal(data, nonce, plaintext, encryptedHeader), nil
+}
+
+// decrypt decrypts persisted state. State persisted before encryption was enabled is
+// returned as is, and is encrypted when next written.
+func (e *encryptor) decrypt(data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+
+	data = data[len(encryptedHeader):]
+	if len(data) < e.aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted state is truncated")
+	}
+
+	nonce, ciphertext := data[:e.aead.NonceSize()], data[e.aead.NonceSize():]
+
+	return e.aead.Open(nil, nonce, ciphertext, encryptedHeader)
+}
+
+// seal encrypts state to persist if the store has an encryptor.
+func seal(e *encryptor, data []byte) ([]byte, error) {
+	if e == nil {
+		return data, nil
+	}
+
+	return e.encrypt(data)
+}
+
+// unseal decrypts persisted state if it is encrypted.
+func unseal(e *encryptor, data []byte) ([]byte, error) {
+	if e == nil {
+		if isEncrypted(data) {
+			return nil, errStoreEncrypted
+		}
+		return data, nil
+	}
+
+	return e.decrypt(data)
+}
@@ -0,0 +1,15 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build linux
+
+package store
+
+import (
+	"fmt"
+)
+
+// loadProtectedKey returns an error since DPAPI is only available on Windows.
+func loadProtectedKey(fileName string) ([]byte, error) {
+	return nil, fmt.Errorf("DPAPI is only available on Windows")
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	// File name of the test encryption key.
+	testKeyFileName = "test.key"
+
+	// Test encryption key, in hex.
+	testKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+)
+
+// Tests that the JSON file store encrypts its file when a key is set, and reads files persisted in clear text.
+func TestJsonFileStoreIsEncrypted(t *testing.T) {
+	var writtenValue = testType1{"test", 42}
+	var readValue testType1
+
+	if err := ioutil.WriteFile(testKeyFileName, []byte(testKey+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write key file %v", err)
+	}
+	defer os.Remove(testKeyFileName)
+	defer os.Remove(testFileName)
+
+	// Persist a pair in clear text.
+	if err := ioutil.WriteFile(testFileName, []byte(`{"key1":{"Field1":"clear","Field2":1}}`), 0664); err != nil {
+		t.Fatalf("Failed to write store file %v", err)
+	}
+
+	os.Setenv(KeyFileEnv, testKeyFileName)
+	defer os.Unsetenv(KeyFileEnv)
+
+	kvs, err := NewJsonFileStore(testFileName)
+	if err != nil {
+		t.Fatalf("Failed to create KeyValueStore %v", err)
+	}
+
+	if err = kvs.Read(testKey1, &readValue); err != nil || readValue.Field1 != "clear" {
+		t.Fatalf("Failed to read clear text store %+v, err:%v", readValue, err)
+	}
+
+	if err = kvs.Write(testKey2, &writtenValue); err != nil {
+		t.Fatalf("Failed to write to store %v", err)
+	}
+
+	buf, _ := ioutil.ReadFile(testFileName)
+	if !isEncrypted(buf) || bytes.Contains(buf, []byte("Field1")) {
+		t.Errorf("Store file is not encrypted: %s", buf)
+	}
+
+	// Read the pair back through a second store.
+	kvs2, _ := NewJsonFileStore(testFileName)
+	if err = kvs2.Read(testKey2, &readValue); err != nil || readValue != writtenValue {
+		t.Errorf("Read pair %+v does not match the written pair %+v, err:%v", readValue, writtenValue, err)
+	}
+
+	// The store can't be read without the key.
+	os.Unsetenv(KeyFileEnv)
+	kvs3, _ := NewJsonFileStore(testFileName)
+	if err = kvs3.Read(testKey2, &readValue); err != errStoreEncrypted {
+		t.Errorf("Read of encrypted store without key returned %v", err)
+	}
+}
+
+// Tests that invalid encryption keys are rejected.
+func TestInvalidEncryptionKeyIsRejected(t *testing.T) {
+	defer os.Remove(testKeyFileName)
+	defer os.Unsetenv(KeyFileEnv)
+
+	os.Setenv(KeyFileEnv, testKeyFileName)
+	if _, err := NewJsonFileStore(testFileName); err == nil {
+		t.Errorf("Store created with missing key file")
+	}
+
+	ioutil.WriteFile(testKeyFileName, []byte(strings.Repeat("0", 30)), 0600)
+	if _, err := NewBoltStore(testBoltFileName); err == nil {
+		t.Errorf("Store created with short key")
+	}
+}
+
+// Tests that encrypted state is authenticated.
+func TestEncryptedStateIsAuthenticated(t *testing.T) {
+	key, _ := parseKey(testKey)
+	e, err := newEncryptor(key)
+	if err != nil {
+		t.Fatalf("Failed to create encryptor %v", err)
+	}
+
+	data, err := e.encrypt([]byte("state"))
+	if err != nil {
+		t.Fatalf("Failed to encrypt %v", err)
+	}
+
+	data[len(data)-1] ^= 1
+	if _, err = e.decrypt(data); err == nil {
+		t.Errorf("Tampered state decrypted")
+	}
+}
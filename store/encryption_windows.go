@@ -0,0 +1,122 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build windows
+
+package store
+
+import (
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// DPAPI flags protecting data for all users of the local machine, without prompts.
+	cryptProtectUIForbidden  = 0x1
+	cryptProtectLocalMachine = 0x4
+)
+
+var (
+	modcrypt32  = windows.NewLazySystemDLL("crypt32.dll")
+	modkernel32 = windows.NewLazySystemDLL("kernel32.dll")
+
+	procCryptProtectData   = modcrypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = modcrypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = modkernel32.NewProc("LocalFree")
+)
+
+// dataBlob is the DATA_BLOB buffer of DPAPI calls.
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+func newDataBlob(buf []byte) *dataBlob {
+	if len(buf) == 0 {
+		return &dataBlob{}
+	}
+
+	return &dataBlob{size: uint32(len(buf)), data: &buf[0]}
+}
+
+// bytes copies the contents of a blob allocated by DPAPI and frees it.
+func (blob *dataBlob) bytes() []byte {
+	buf := make([]byte, blob.size)
+	copy(buf, (*[1 << 30]byte)(unsafe.Pointer(blob.data))[:blob.size:blob.size])
+	syscall.Syscall(procLocalFree.Addr(), 1, uintptr(unsafe.Pointer(blob.data)), 0, 0)
+
+	return buf
+}
+
+// protect encrypts data with DPAPI for the local machine.
+func protect(data []byte) ([]byte, error) {
+	var out dataBlob
+
+	r, _, err := syscall.Syscall9(procCryptProtectData.Addr(), 7,
+		uintptr(unsafe.Pointer(newDataBlob(data))), 0, 0, 0, 0,
+		cryptProtectUIForbidden|cryptProtectLocalMachine, uintptr(unsafe.Pointer(&out)), 0, 0)
+	if r == 0 {
+		return nil, err
+	}
+
+	return out.bytes(), nil
+}
+
+// unprotect decrypts data encrypted with DPAPI.
+func unprotect(data []byte) ([]byte, error) {
+	var out dataBlob
+
+	r, _, err := syscall.Syscall9(procCryptUnprotectData.Addr(), 7,
+		uintptr(unsafe.Pointer(newDataBlob(data))), 0, 0, 0, 0,
+		cryptProtectUIForbidden, uintptr(unsafe.Pointer(&out)), 0, 0)
+	if r == 0 {
+		return nil, err
+	}
+
+	return out.bytes(), nil
+}
+
+// loadProtectedKey reads an encryption key protected with DPAPI from a file, creating a random
+// key if the file doesn't exist.
+func loadProtectedKey(fileName string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(fileName)
+	if err == nil {
+		return unprotect(buf)
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	buf, err = protect(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the key of another process that created the file first.
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return loadProtectedKey(fileName)
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
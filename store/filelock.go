@@ -0,0 +1,214 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/platform"
+)
+
+var (
+	// Time of the last reboot, queried once when a lock is first found held.
+	rebootTime     time.Time
+	rebootTimeOnce sync.Once
+)
+
+// getRebootTime returns the time of the last reboot, or the zero time if it can't be queried.
+func getRebootTime() time.Time {
+	rebootTimeOnce.Do(func() {
+		rebootTime, _ = platform.GetLastRebootTime()
+	})
+
+	return rebootTime
+}
+
+// fileLock is a lock shared by processes through a lock file holding the ID of its owner.
+type fileLock struct {
+	name    string
+	desc    string
+	timeout time.Duration
+}
+
+// newFileLock creates a lock backed by the given lock file. desc names what it locks in logs.
+func newFileLock(name string, desc string, timeout time.Duration) *fileLock {
+	return &fileLock{
+		name:    name,
+		desc:    desc,
+		timeout: timeout,
+	}
+}
+
+// lock acquires the lock. Blocking calls wait in a queue and are granted the lock in the order
+// they were made. They time out if the queue doesn't move for the lock timeout, so waiting
+// behind many short-lived holders doesn't fail.
+func (l *fileLock) lock(block bool) error {
+	queue := newLockQueue(l.name)
+
+	if !block {
+		// Don't jump ahead of waiting processes.
+		head, err := queue.head()
+		if err != nil {
+			return err
+		}
+
+		if head != "" {
+			return ErrNonBlockingLockIsAlreadyLocked
+		}
+
+		acquired, err := l.acquire()
+		if err != nil {
+			return err
+		}
+
+		if !acquired {
+			return ErrNonBlockingLockIsAlreadyLocked
+		}
+
+		return nil
+	}
+
+	if err := queue.enter(); err != nil {
+		return err
+	}
+	defer queue.leave()
+
+	var lastHead string
+	var deadline time.Time
+
+	for {
+		head, err := queue.head()
+		if err != nil {
+			return err
+		}
+
+		// Restart the timeout whenever the queue moves.
+		if head != lastHead {
+			lastHead = head
+			deadline = time.Now().Add(l.timeout)
+		}
+
+		// Take a new ticket if the queue was cleared by a forced unlock.
+		if !queue.isFirst(head) && !queue.holdsTicket() {
+			if err := queue.enter(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if queue.isFirst(head) {
+			acquired, err := l.acquire()
+			if err != nil {
+				return err
+			}
+
+			if acquired {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			owner, _ := readLockOwner(l.name)
+			log.Printf("Timed out after %v locking %v held by process %v", l.timeout, l.desc, owner)
+			return ErrTimeoutLockingStore
+		}
+
+		time.Sleep(lockRetryDelay)
+	}
+}
+
+// acquire creates the lock file, breaking it if its owner is gone, and returns whether it did.
+func (l *fileLock) acquire() (bool, error) {
+	lockPerm := os.FileMode(0664) + os.FileMode(os.ModeExclusive)
+
+	for {
+		lockFile, err := os.OpenFile(l.name, os.O_CREATE|os.O_EXCL|os.O_RDWR, lockPerm)
+		if err != nil {
+			if !os.IsExist(err) {
+				return false, err
+			}
+			if l.breakStale() {
+				continue
+			}
+			return false, nil
+		}
+
+		defer lockFile.Close()
+
+		// Write the process ID for easy identification.
+		if _, err = lockFile.WriteString(strconv.Itoa(os.Getpid())); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+}
+
+// readLockOwner returns the ID of the process owning a lock file.
+func readLockOwner(lockName string) (int, error) {
+	buf, err := ioutil.ReadFile(lockName)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(buf)))
+}
+
+// breakStale removes a lock file left behind by a process that exited without unlocking, and
+// returns whether it did. Lock files without an owner are stale once older than the lock timeout,
+// since the owner writes its ID right after creating them. Lock files created before the last
+// reboot are stale too, since their owner may name a process ID reused by a running process.
+func (l *fileLock) breakStale() bool {
+	info, err := os.Stat(l.name)
+	if err != nil {
+		// The lock was released, retry immediately.
+		return os.IsNotExist(err)
+	}
+
+	owner, err := readLockOwner(l.name)
+	if err == nil {
+		if owner == os.Getpid() {
+			return false
+		}
+
+		if isProcessRunning(owner) {
+			if !getRebootTime().After(info.ModTime()) {
+				return false
+			}
+			log.Printf("Breaking lock of %v held by process %v before the last reboot", l.desc, owner)
+		} else {
+			log.Printf("Breaking stale lock of %v held by exited process %v", l.desc, owner)
+		}
+	} else {
+		if time.Since(info.ModTime()) < l.timeout {
+			return false
+		}
+		log.Printf("Breaking stale lock of %v without owner created at %v", l.desc, info.ModTime())
+	}
+
+	if err := os.Remove(l.name); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to break stale lock of %v: %v", l.desc, err)
+		return false
+	}
+
+	return true
+}
+
+// unlock releases the lock. A forced unlock also clears the queue of waiters, since tickets left
+// behind before a reboot may name process IDs reused by running processes and block the queue.
+func (l *fileLock) unlock(forceUnlock bool) error {
+	if forceUnlock {
+		if err := newLockQueue(l.name).clear(); err != nil {
+			log.Printf("Failed to clear lock queue of %v: %v", l.desc, err)
+		}
+	}
+
+	return os.Remove(l.name)
+}
@@ -5,8 +5,10 @@ package store
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"os"
-	"strconv"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,19 +22,42 @@ const (
 	// Extension added to the file name for lock.
 	lockExtension = ".lock"
 
-	// Maximum number of retries before failing a lock call.
-	lockMaxRetries = 200
+	// Default maximum time to wait for a lock held by another process.
+	defaultLockTimeout = 20 * time.Second
 
 	// Delay between lock retries.
 	lockRetryDelay = 100 * time.Millisecond
+
+	// LockTimeoutEnv is the environment variable overriding the maximum time to wait for
+	// a lock held by another process, as a duration such as "30s".
+	LockTimeoutEnv = "ACN_STORE_LOCK_TIMEOUT"
 )
 
+// keyLockReplacer replaces the characters of keys that aren't safe in lock file names.
+var keyLockReplacer = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "|", "_", "*", "_", "?", "_")
+
+// getLockTimeout returns the maximum time to wait for a lock held by another process.
+func getLockTimeout() time.Duration {
+	if value := os.Getenv(LockTimeoutEnv); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err == nil && timeout > 0 {
+			return timeout
+		}
+		log.Printf("Invalid store lock timeout %v, using %v", value, defaultLockTimeout)
+	}
+
+	return defaultLockTimeout
+}
+
 // jsonFileStore is an implementation of KeyValueStore using a local JSON file.
 type jsonFileStore struct {
-	fileName string
-	data     map[string]*json.RawMessage
-	inSync   bool
-	locked   bool
+	fileName    string
+	data        map[string]*json.RawMessage
+	inSync      bool
+	locked      bool
+	keyLocks    map[string]bool
+	lockTimeout time.Duration
+	encryptor   *encryptor
 	sync.Mutex
 }
 
@@ -42,9 +67,17 @@ func NewJsonFileStore(fileName string) (KeyValueStore, error) {
 		fileName = defaultFileName
 	}
 
+	encryptor, err := getEncryptor()
+	if err != nil {
+		return nil, err
+	}
+
 	kvs := &jsonFileStore{
-		fileName: fileName,
-		data:     make(map[string]*json.RawMessage),
+		fileName:    fileName,
+		data:        make(map[string]*json.RawMessage),
+		keyLocks:    make(map[string]bool),
+		lockTimeout: getLockTimeout(),
+		encryptor:   encryptor,
 	}
 
 	return kvs, nil
@@ -57,22 +90,12 @@ func (kvs *jsonFileStore) Read(key string, value interface{}) error {
 
 	// Read contents from file if memory is not in sync.
 	if !kvs.inSync {
-		// Open and parse the file if it exists.
-		file, err := os.Open(kvs.fileName)
-		if err != nil {
+		if err := kvs.load(); err != nil {
 			if os.IsNotExist(err) {
 				return ErrKeyNotFound
 			}
 			return err
 		}
-		defer file.Close()
-
-		// Decode to raw JSON messages.
-		if err := json.NewDecoder(file).Decode(&kvs.data); err != nil {
-			return err
-		}
-
-		kvs.inSync = true
 	}
 
 	raw, ok := kvs.data[key]
@@ -83,11 +106,43 @@ func (kvs *jsonFileStore) Read(key string, value interface{}) error {
 	return json.Unmarshal(*raw, value)
 }
 
+// Lock-free load of the file contents for internal callers.
+func (kvs *jsonFileStore) load() error {
+	// Read and parse the file if it exists.
+	buf, err := ioutil.ReadFile(kvs.fileName)
+	if err != nil {
+		return err
+	}
+
+	buf, err = unseal(kvs.encryptor, buf)
+	if err != nil {
+		return err
+	}
+
+	// Decode to raw JSON messages.
+	data := make(map[string]*json.RawMessage)
+	if err := json.Unmarshal(buf, &data); err != nil {
+		return err
+	}
+
+	kvs.data = data
+	kvs.inSync = true
+
+	return nil
+}
+
 // Write saves the given key value pair to persistent store.
 func (kvs *jsonFileStore) Write(key string, value interface{}) error {
 	kvs.Mutex.Lock()
 	defer kvs.Mutex.Unlock()
 
+	// Keep the keys written by other processes since the file was last read.
+	if !kvs.inSync {
+		if err := kvs.load(); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
 	var raw json.RawMessage
 	raw, err := json.Marshal(value)
 	if err != nil {
@@ -120,13 +175,20 @@ func (kvs *jsonFileStore) flush() error {
 		return err
 	}
 
+	buf, err = seal(kvs.encryptor, buf)
+	if err != nil {
+		return err
+	}
+
 	if _, err := file.Write(buf); err != nil {
 		return err
 	}
 	return nil
 }
 
-// Lock locks the store for exclusive access.
+// Lock locks the store for exclusive access. Blocking calls wait in a queue and are granted
+// the lock in the order they were made. They time out if the queue doesn't move for the lock
+// timeout, so waiting behind many short-lived holders doesn't fail.
 func (kvs *jsonFileStore) Lock(block bool) error {
 	kvs.Mutex.Lock()
 	defer kvs.Mutex.Unlock()
@@ -135,75 +197,112 @@ func (kvs *jsonFileStore) Lock(block bool) error {
 		return ErrStoreLocked
 	}
 
-	var lockFile *os.File
-	var err error
-	lockName := kvs.fileName + lockExtension
-	lockPerm := os.FileMode(0664) + os.FileMode(os.ModeExclusive)
+	lock := newFileLock(kvs.fileName+lockExtension, "store "+kvs.fileName, kvs.lockTimeout)
+	if err := lock.lock(block); err != nil {
+		return err
+	}
 
-	// Try to acquire the lock file.
-	var lockRetryCount uint
-	var modTimeCur time.Time
-	var modTimePrev time.Time
-	for lockRetryCount < lockMaxRetries {
-		lockFile, err = os.OpenFile(lockName, os.O_CREATE|os.O_EXCL|os.O_RDWR, lockPerm)
-		if err == nil {
-			break
-		}
+	// Other processes may have written the file since it was last read.
+	kvs.inSync = false
+	kvs.locked = true
 
-		if !block {
-			return ErrNonBlockingLockIsAlreadyLocked
-		}
+	return nil
+}
 
-		// Reset the lock retry count if the timestamp for the lock file changes.
-		if fileInfo, err := os.Stat(lockName); err == nil {
-			modTimeCur = fileInfo.ModTime()
-			if !modTimeCur.Equal(modTimePrev) {
-				lockRetryCount = 0
-			}
-			modTimePrev = modTimeCur
-		}
+// Unlock unlocks the store. A forced unlock also clears the queue of waiters and the locks of
+// keys, since they may have been left behind before a reboot.
+func (kvs *jsonFileStore) Unlock(forceUnlock bool) error {
+	kvs.Mutex.Lock()
+	defer kvs.Mutex.Unlock()
 
-		time.Sleep(lockRetryDelay)
+	if !forceUnlock && !kvs.locked {
+		return ErrStoreNotLocked
+	}
 
-		lockRetryCount++
+	if forceUnlock {
+		kvs.clearKeyLocks()
 	}
 
-	if lockRetryCount == lockMaxRetries {
-		return ErrTimeoutLockingStore
+	lock := newFileLock(kvs.fileName+lockExtension, "store "+kvs.fileName, kvs.lockTimeout)
+	err := lock.unlock(forceUnlock)
+	if err != nil {
+		return err
 	}
 
-	defer lockFile.Close()
+	kvs.inSync = false
+	kvs.locked = false
 
-	// Write the process ID for easy identification.
-	if _, err = lockFile.WriteString(strconv.Itoa(os.Getpid())); err != nil {
+	return nil
+}
+
+// getKeyLock returns the lock of the given key, backed by a lock file next to the store file.
+func (kvs *jsonFileStore) getKeyLock(key string) *fileLock {
+	name := kvs.fileName + "." + keyLockReplacer.Replace(key) + lockExtension
+	return newFileLock(name, "key "+key+" of store "+kvs.fileName, kvs.lockTimeout)
+}
+
+// LockKey locks the given key for exclusive access. Keys are locked independently of each other
+// and of the store, so processes working on different keys don't wait for each other.
+func (kvs *jsonFileStore) LockKey(key string, block bool) error {
+	kvs.Mutex.Lock()
+	if kvs.keyLocks[key] {
+		kvs.Mutex.Unlock()
+		return ErrStoreLocked
+	}
+	kvs.Mutex.Unlock()
+
+	// Wait without holding the store mutex, so the store can be used meanwhile.
+	if err := kvs.getKeyLock(key).lock(block); err != nil {
 		return err
 	}
 
-	kvs.locked = true
+	kvs.Mutex.Lock()
+	defer kvs.Mutex.Unlock()
+
+	kvs.keyLocks[key] = true
 
 	return nil
 }
 
-// Unlock unlocks the store.
-func (kvs *jsonFileStore) Unlock(forceUnlock bool) error {
+// UnlockKey unlocks the given key.
+func (kvs *jsonFileStore) UnlockKey(key string) error {
 	kvs.Mutex.Lock()
 	defer kvs.Mutex.Unlock()
 
-	if !forceUnlock && !kvs.locked {
+	if !kvs.keyLocks[key] {
 		return ErrStoreNotLocked
 	}
 
-	err := os.Remove(kvs.fileName + lockExtension)
-	if err != nil {
+	if err := kvs.getKeyLock(key).unlock(false); err != nil {
 		return err
 	}
 
-	kvs.inSync = false
-	kvs.locked = false
+	delete(kvs.keyLocks, key)
 
 	return nil
 }
 
+// Lock-free removal of the lock files of all keys and their queues for internal callers.
+func (kvs *jsonFileStore) clearKeyLocks() {
+	lockNames, err := filepath.Glob(kvs.fileName + ".*" + lockExtension)
+	if err != nil {
+		log.Printf("Failed to find key locks of store %v: %v", kvs.fileName, err)
+		return
+	}
+
+	for _, lockName := range lockNames {
+		if err := newLockQueue(lockName).clear(); err != nil {
+			log.Printf("Failed to clear lock queue %v: %v", lockName, err)
+		}
+
+		if err := os.Remove(lockName); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove key lock %v: %v", lockName, err)
+		}
+	}
+
+	kvs.keyLocks = make(map[string]bool)
+}
+
 // GetModificationTime returns the modification time of the persistent store.
 func (kvs *jsonFileStore) GetModificationTime() (time.Time, error) {
 	kvs.Mutex.Lock()
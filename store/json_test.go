@@ -4,9 +4,14 @@
 package store
 
 import (
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -206,3 +211,158 @@ func TestLockingStoreGivesExclusiveAccess(t *testing.T) {
 	// Cleanup.
 	os.Remove(testFileName)
 }
+
+// Tests that a lock left behind by an exited process is broken, and one of a running process isn't.
+func TestLockingStoreBreaksStaleLock(t *testing.T) {
+	lockName := testFileName + lockExtension
+	defer os.Remove(lockName)
+	defer os.RemoveAll(lockName + lockQueueExtension)
+
+	// Get the ID of a process that has exited.
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("Failed to run process: %v", err)
+	}
+
+	if err := ioutil.WriteFile(lockName, []byte(strconv.Itoa(cmd.Process.Pid)), 0664); err != nil {
+		t.Fatalf("Failed to create lock file: %v", err)
+	}
+
+	kvs, err := NewJsonFileStore(testFileName)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	if err = kvs.Lock(false); err != nil {
+		t.Fatalf("Failed to lock store with stale lock: %v", err)
+	}
+
+	if err = kvs.Unlock(false); err != nil {
+		t.Errorf("Failed to unlock store: %v", err)
+	}
+
+	// The lock of a running process is kept until the lock timeout.
+	if err := ioutil.WriteFile(lockName, []byte("1"), 0664); err != nil {
+		t.Fatalf("Failed to create lock file: %v", err)
+	}
+
+	kvs.(*jsonFileStore).lockTimeout = 300 * time.Millisecond
+	if err = kvs.Lock(true); err != ErrTimeoutLockingStore {
+		t.Errorf("Locking store held by a running process returned %v", err)
+	}
+}
+
+// Tests that keys are locked independently of each other and of the store.
+func TestLockingKeysGivesExclusiveAccess(t *testing.T) {
+	defer os.Remove(testFileName)
+	defer func() {
+		lockNames, _ := filepath.Glob(testFileName + ".*")
+		for _, lockName := range lockNames {
+			os.RemoveAll(lockName)
+		}
+	}()
+
+	kvs, _ := NewJsonFileStore(testFileName)
+	kvs2, _ := NewJsonFileStore(testFileName)
+	kl, kl2 := kvs.(KeyLocker), kvs2.(KeyLocker)
+
+	if err := kl.LockKey("local|10.0.1.0/24", false); err != nil {
+		t.Fatalf("Failed to lock key: %v", err)
+	}
+
+	if err := kl2.LockKey("local|10.0.1.0/24", false); err != ErrNonBlockingLockIsAlreadyLocked {
+		t.Errorf("Locking an already-locked key returned %v", err)
+	}
+
+	// Other keys and the store itself can still be locked.
+	if err := kl2.LockKey("local|10.0.2.0/24", false); err != nil {
+		t.Errorf("Failed to lock other key: %v", err)
+	}
+
+	if err := kvs2.Lock(false); err != nil {
+		t.Errorf("Failed to lock store with a locked key: %v", err)
+	}
+
+	if err := kvs2.Unlock(false); err != nil {
+		t.Errorf("Failed to unlock store: %v", err)
+	}
+
+	if err := kl.UnlockKey("local|10.0.1.0/24"); err != nil {
+		t.Errorf("Failed to unlock key: %v", err)
+	}
+
+	if err := kl.UnlockKey("local|10.0.1.0/24"); err != ErrStoreNotLocked {
+		t.Errorf("Unlocking an unlocked key returned %v", err)
+	}
+
+	if err := kl2.LockKey("local|10.0.1.0/24", true); err != nil {
+		t.Errorf("Failed to re-lock an unlocked key: %v", err)
+	}
+
+	// A forced unlock of the store releases the locks of all keys.
+	if err := kvs.Unlock(true); err != nil && !os.IsNotExist(err) {
+		t.Errorf("Failed to force unlock store: %v", err)
+	}
+
+	for _, key := range []string{"local|10.0.1.0/24", "local|10.0.2.0/24"} {
+		if err := kl.LockKey(key, false); err != nil {
+			t.Errorf("Failed to lock key %v after forced unlock: %v", key, err)
+		}
+		kl.UnlockKey(key)
+	}
+}
+
+// Tests that writes keep the keys written by other stores on the same file.
+func TestWritingStoreKeepsKeysOfOtherWriters(t *testing.T) {
+	var value testType1
+	defer os.Remove(testFileName)
+
+	kvs, _ := NewJsonFileStore(testFileName)
+	kvs2, _ := NewJsonFileStore(testFileName)
+
+	if err := kvs.Write(testKey1, &testType1{"first", 1}); err != nil {
+		t.Fatalf("Failed to write to store: %v", err)
+	}
+
+	if err := kvs.Read(testKey1, &value); err != nil {
+		t.Fatalf("Failed to read from store: %v", err)
+	}
+
+	if err := kvs2.Write(testKey2, &testType1{"second", 2}); err != nil {
+		t.Fatalf("Failed to write to second store: %v", err)
+	}
+
+	// Locking the store drops what was read before, so the write of the second store is kept.
+	if err := kvs.Lock(true); err != nil {
+		t.Fatalf("Failed to lock store: %v", err)
+	}
+	defer kvs.Unlock(false)
+
+	if err := kvs.Write(testKey1, &testType1{"first", 3}); err != nil {
+		t.Fatalf("Failed to write to store: %v", err)
+	}
+
+	kvs3, _ := NewJsonFileStore(testFileName)
+	if err := kvs3.Read(testKey2, &value); err != nil || value.Field2 != 2 {
+		t.Errorf("Read %+v err:%v, expected the write of the second store", value, err)
+	}
+
+	if err := kvs3.Read(testKey1, &value); err != nil || value.Field2 != 3 {
+		t.Errorf("Read %+v err:%v, expected the last write of the first store", value, err)
+	}
+}
+
+// Tests that the lock timeout is read from the environment.
+func TestLockTimeoutIsConfigurable(t *testing.T) {
+	defer os.Unsetenv(LockTimeoutEnv)
+
+	os.Setenv(LockTimeoutEnv, "5s")
+	if timeout := getLockTimeout(); timeout != 5*time.Second {
+		t.Errorf("Unexpected lock timeout %v", timeout)
+	}
+
+	os.Setenv(LockTimeoutEnv, "invalid")
+	if timeout := getLockTimeout(); timeout != defaultLockTimeout {
+		t.Errorf("Unexpected lock timeout %v", timeout)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Extension added to the lock file name for the queue of its waiters.
+	lockQueueExtension = ".queue"
+
+	// Name of the file holding the last ticket handed out by a lock queue.
+	lockQueueCounter = "counter"
+)
+
+// lockQueue grants a lock file to the processes waiting for it in the order they asked.
+// Each waiter takes a numbered ticket, handed out under an OS file lock, and may acquire the
+// lock file once the tickets ahead of it are gone. Tickets of processes that exited while
+// waiting are removed by the waiters behind them.
+type lockQueue struct {
+	dir    string
+	ticket string
+}
+
+// newLockQueue creates the queue of the waiters of a lock file.
+func newLockQueue(lockName string) *lockQueue {
+	return &lockQueue{dir: lockName + lockQueueExtension}
+}
+
+// enter takes a ticket at the end of the queue.
+func (q *lockQueue) enter() error {
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(q.dir, lockQueueCounter), os.O_CREATE|os.O_RDWR, 0664)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = lockFile(file); err != nil {
+		return err
+	}
+	defer unlockFile(file)
+
+	buf, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	last, _ := strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+	next := strconv.FormatUint(last+1, 10)
+
+	if err = file.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err = file.WriteAt([]byte(next), 0); err != nil {
+		return err
+	}
+
+	// Tickets are named so that they sort in the order they were handed out.
+	ticket := fmt.Sprintf("%020d.%d", last+1, os.Getpid())
+	if err = ioutil.WriteFile(filepath.Join(q.dir, ticket), nil, 0664); err != nil {
+		return err
+	}
+
+	q.ticket = ticket
+
+	return nil
+}
+
+// leave removes the ticket of the waiter from the queue.
+func (q *lockQueue) leave() {
+	if q.ticket == "" {
+		return
+	}
+
+	if err := os.Remove(filepath.Join(q.dir, q.ticket)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove lock ticket %v: %v", q.ticket, err)
+	}
+
+	q.ticket = ""
+}
+
+// holdsTicket checks if the ticket of the waiter is still in the queue.
+func (q *lockQueue) holdsTicket() bool {
+	if q.ticket == "" {
+		return false
+	}
+
+	_, err := os.Stat(filepath.Join(q.dir, q.ticket))
+	return err == nil
+}
+
+// clear removes all tickets from the queue. Waiters whose ticket is gone take a new one.
+func (q *lockQueue) clear() error {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, file := range files {
+		if _, ok := getTicketOwner(file.Name()); !ok {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(q.dir, file.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// head returns the first ticket in the queue, empty if there is none.
+func (q *lockQueue) head() (string, error) {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	for _, file := range files {
+		owner, ok := getTicketOwner(file.Name())
+		if !ok {
+			continue
+		}
+
+		if owner != os.Getpid() && !isProcessRunning(owner) {
+			log.Printf("Removing lock ticket %v of exited process %v", file.Name(), owner)
+			os.Remove(filepath.Join(q.dir, file.Name()))
+			continue
+		}
+
+		return file.Name(), nil
+	}
+
+	return "", nil
+}
+
+// isFirst checks if the waiter holds the first ticket in the queue.
+func (q *lockQueue) isFirst(head string) bool {
+	return q.ticket != "" && head == q.ticket
+}
+
+// getTicketOwner returns the ID of the process owning a ticket.
+func getTicketOwner(ticket string) (int, bool) {
+	i := strings.LastIndex(ticket, ".")
+	if i < 0 {
+		return 0, false
+	}
+
+	pid, err := strconv.Atoi(ticket[i+1:])
+	if err != nil {
+		return 0, false
+	}
+
+	return pid, true
+}
@@ -0,0 +1,168 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Tests that a lock queue grants the lock in the order tickets were taken.
+func TestLockQueueGrantsTicketsInOrder(t *testing.T) {
+	lockName := testFileName + lockExtension
+	defer os.RemoveAll(lockName + lockQueueExtension)
+
+	var queues []*lockQueue
+	for i := 0; i < 3; i++ {
+		q := newLockQueue(lockName)
+		if err := q.enter(); err != nil {
+			t.Fatalf("Failed to enter lock queue: %v", err)
+		}
+		queues = append(queues, q)
+	}
+
+	for i, q := range queues {
+		head, err := q.head()
+		if err != nil {
+			t.Fatalf("Failed to get head of lock queue: %v", err)
+		}
+
+		if !q.isFirst(head) {
+			t.Errorf("Ticket %d is not first after the tickets ahead left, head is %v", i, head)
+		}
+
+		if i+1 < len(queues) && queues[i+1].isFirst(head) {
+			t.Errorf("Ticket %d is first before ticket %d left", i+1, i)
+		}
+
+		q.leave()
+	}
+
+	if head, _ := newLockQueue(lockName).head(); head != "" {
+		t.Errorf("Lock queue is not empty, head is %v", head)
+	}
+}
+
+// Tests that tickets of exited processes are removed from a lock queue.
+func TestLockQueueRemovesTicketsOfExitedProcesses(t *testing.T) {
+	lockName := testFileName + lockExtension
+	defer os.RemoveAll(lockName + lockQueueExtension)
+
+	// Get the ID of a process that has exited.
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("Failed to run process: %v", err)
+	}
+
+	q := newLockQueue(lockName)
+	if err := q.enter(); err != nil {
+		t.Fatalf("Failed to enter lock queue: %v", err)
+	}
+	defer q.leave()
+
+	// Leave a ticket ahead of the queue behind.
+	stale := filepath.Join(q.dir, "00000000000000000000."+strconv.Itoa(cmd.Process.Pid))
+	if err := ioutil.WriteFile(stale, nil, 0664); err != nil {
+		t.Fatalf("Failed to create ticket: %v", err)
+	}
+
+	head, err := q.head()
+	if err != nil || !q.isFirst(head) {
+		t.Errorf("Ticket is not first ahead of a stale ticket, head is %v, err:%v", head, err)
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Stale ticket was not removed")
+	}
+}
+
+// Tests that a forced unlock clears tickets left behind before a reboot.
+func TestForceUnlockClearsLockQueue(t *testing.T) {
+	lockName := testFileName + lockExtension
+	defer os.Remove(lockName)
+	defer os.RemoveAll(lockName + lockQueueExtension)
+
+	kvs, _ := NewJsonFileStore(testFileName)
+	if err := kvs.Lock(true); err != nil {
+		t.Fatalf("Failed to lock store: %v", err)
+	}
+
+	// Leave a ticket of a process ID reused by a running process ahead of the queue.
+	if err := os.MkdirAll(lockName+lockQueueExtension, 0755); err != nil {
+		t.Fatalf("Failed to create lock queue: %v", err)
+	}
+
+	stale := filepath.Join(lockName+lockQueueExtension, "00000000000000000000.1")
+	if err := ioutil.WriteFile(stale, nil, 0664); err != nil {
+		t.Fatalf("Failed to create ticket: %v", err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		waiter, _ := NewJsonFileStore(testFileName)
+		waiter.(*jsonFileStore).lockTimeout = 500 * time.Millisecond
+
+		err := waiter.Lock(true)
+		if err == nil {
+			err = waiter.Unlock(false)
+		}
+		errs <- err
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	if err := kvs.Unlock(true); err != nil {
+		t.Fatalf("Failed to force unlock store: %v", err)
+	}
+
+	// The waiter takes a new ticket and gets the lock.
+	if err := <-errs; err != nil {
+		t.Errorf("Waiter failed to lock store after forced unlock: %v", err)
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Stale ticket was not removed")
+	}
+}
+
+// Tests that waiting for a store doesn't time out while the lock changes hands.
+func TestLockingStoreTimesOutOnlyWithoutProgress(t *testing.T) {
+	lockName := testFileName + lockExtension
+	defer os.Remove(lockName)
+	defer os.RemoveAll(lockName + lockQueueExtension)
+
+	kvs, _ := NewJsonFileStore(testFileName)
+	kvs.(*jsonFileStore).lockTimeout = 500 * time.Millisecond
+
+	// Waiters each hold the store for less than the lock timeout, longer than it altogether.
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			waiter, _ := NewJsonFileStore(testFileName)
+			waiter.(*jsonFileStore).lockTimeout = 500 * time.Millisecond
+
+			err := waiter.Lock(true)
+			if err == nil {
+				time.Sleep(300 * time.Millisecond)
+				err = waiter.Unlock(false)
+			}
+			errs <- err
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Waiter failed to lock store: %v", err)
+		}
+	}
+
+	if err := kvs.Lock(false); err != nil {
+		t.Errorf("Failed to lock store after waiters: %v", err)
+	}
+	kvs.Unlock(false)
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build windows
+
+package store
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// LockFileEx flag requesting an exclusive lock.
+	lockfileExclusiveLock = 0x2
+)
+
+var (
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+// lockFile takes an exclusive OS lock on a file, waiting for it if needed.
+func lockFile(file *os.File) error {
+	var overlapped syscall.Overlapped
+
+	r, _, err := syscall.Syscall6(procLockFileEx.Addr(), 6, file.Fd(), lockfileExclusiveLock, 0, 1, 0,
+		uintptr(unsafe.Pointer(&overlapped)))
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// unlockFile releases the OS lock on a file.
+func unlockFile(file *os.File) error {
+	var overlapped syscall.Overlapped
+
+	r, _, err := syscall.Syscall6(procUnlockFileEx.Addr(), 5, file.Fd(), 0, 1, 0,
+		uintptr(unsafe.Pointer(&overlapped)), 0)
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
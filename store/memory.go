@@ -0,0 +1,108 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package store
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// memoryStore is an implementation of KeyValueStore that keeps its contents in process memory.
+// It is not persisted across process restarts and is mostly useful for tests.
+type memoryStore struct {
+	data    map[string]json.RawMessage
+	modTime time.Time
+	locked  bool
+	sync.Mutex
+}
+
+// NewMemoryStore creates a new memoryStore object, accessed as a KeyValueStore.
+func NewMemoryStore() KeyValueStore {
+	return &memoryStore{
+		data: make(map[string]json.RawMessage),
+	}
+}
+
+// Read restores the value for the given key from the store.
+func (ms *memoryStore) Read(key string, value interface{}) error {
+	ms.Mutex.Lock()
+	defer ms.Mutex.Unlock()
+
+	raw, ok := ms.data[key]
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	return json.Unmarshal(raw, value)
+}
+
+// Write saves the given key value pair to the store.
+func (ms *memoryStore) Write(key string, value interface{}) error {
+	ms.Mutex.Lock()
+	defer ms.Mutex.Unlock()
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	ms.data[key] = raw
+	ms.modTime = time.Now().UTC()
+
+	return nil
+}
+
+// Flush is a no-op since the store has no backing persistent storage.
+func (ms *memoryStore) Flush() error {
+	return nil
+}
+
+// Lock locks the store for exclusive access.
+func (ms *memoryStore) Lock(block bool) error {
+	ms.Mutex.Lock()
+	defer ms.Mutex.Unlock()
+
+	if ms.locked {
+		if !block {
+			return ErrNonBlockingLockIsAlreadyLocked
+		}
+		return ErrStoreLocked
+	}
+
+	ms.locked = true
+
+	return nil
+}
+
+// Unlock unlocks the store.
+func (ms *memoryStore) Unlock(forceUnlock bool) error {
+	ms.Mutex.Lock()
+	defer ms.Mutex.Unlock()
+
+	if !forceUnlock && !ms.locked {
+		return ErrStoreNotLocked
+	}
+
+	ms.locked = false
+
+	return nil
+}
+
+// GetModificationTime returns the time of the last write to the store.
+func (ms *memoryStore) GetModificationTime() (time.Time, error) {
+	ms.Mutex.Lock()
+	defer ms.Mutex.Unlock()
+
+	if ms.modTime.IsZero() {
+		return time.Time{}.UTC(), ErrKeyNotFound
+	}
+
+	return ms.modTime, nil
+}
+
+// GetLockFileModificationTime returns an error since the store has no lock file.
+func (ms *memoryStore) GetLockFileModificationTime() (time.Time, error) {
+	return time.Time{}.UTC(), ErrStoreNotLocked
+}
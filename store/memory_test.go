@@ -0,0 +1,67 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package store
+
+import (
+	"testing"
+)
+
+// Tests that key value pairs are written and read back correctly from a memory store.
+func TestMemoryStoreKeyValuePairsAreWrittenAndReadCorrectly(t *testing.T) {
+	var writtenValue = testType1{"test", 42}
+	var readValue testType1
+
+	kvs := NewMemoryStore()
+
+	// Reading a missing key should fail.
+	err := kvs.Read(testKey1, &readValue)
+	if err != ErrKeyNotFound {
+		t.Errorf("Read of a missing key returned %v", err)
+	}
+
+	err = kvs.Write(testKey1, &writtenValue)
+	if err != nil {
+		t.Fatalf("Failed to write to store %v", err)
+	}
+
+	err = kvs.Read(testKey1, &readValue)
+	if err != nil {
+		t.Fatalf("Failed to read from store %v", err)
+	}
+
+	// Fail if the read pair does not match the written pair.
+	if readValue != writtenValue {
+		t.Errorf("Read pair (%v, %v) does not match the written pair (%v, %v)",
+			testKey1, readValue, testKey1, writtenValue)
+	}
+
+	if _, err = kvs.GetModificationTime(); err != nil {
+		t.Errorf("Failed to get modification time %v", err)
+	}
+}
+
+// Tests that locking a memory store gives the caller exclusive access.
+func TestMemoryStoreLockingGivesExclusiveAccess(t *testing.T) {
+	kvs := NewMemoryStore()
+
+	err := kvs.Lock(false)
+	if err != nil {
+		t.Errorf("Failed to lock store: %v", err)
+	}
+
+	err = kvs.Lock(false)
+	if err == nil {
+		t.Errorf("Locking an already-locked store succeeded")
+	}
+
+	err = kvs.Unlock(false)
+	if err != nil {
+		t.Errorf("Failed to unlock store: %v", err)
+	}
+
+	err = kvs.Unlock(false)
+	if err == nil {
+		t.Errorf("Unlocking an unlocked store succeeded")
+	}
+}
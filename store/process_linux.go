@@ -0,0 +1,16 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build linux
+
+package store
+
+import (
+	"syscall"
+)
+
+// isProcessRunning checks if a process with the given ID is running.
+func isProcessRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,35 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+// +build windows
+
+package store
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// Access right to query the exit code of a process.
+	processQueryLimitedInformation = 0x1000
+
+	// Exit code of a process that hasn't exited.
+	stillActive = 259
+)
+
+// isProcessRunning checks if a process with the given ID is running.
+func isProcessRunning(pid int) bool {
+	handle, err := windows.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// Processes of other users can't be opened, but exist.
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(handle)
+
+	var exitCode uint32
+	if err := windows.GetExitCodeProcess(handle, &exitCode); err != nil {
+		return true
+	}
+
+	return exitCode == stillActive
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// SchemaVersionField is the field holding the schema version of persisted state.
+	SchemaVersionField = "SchemaVersion"
+
+	// Schema version of state persisted before schema versions were stamped.
+	unversionedSchemaVersion = 1
+)
+
+// Migration upgrades persisted state from the previous schema version to Version.
+// Migrate edits the top-level fields of the state in place.
+type Migration struct {
+	Version     int
+	Description string
+	Migrate     func(state map[string]json.RawMessage) error
+}
+
+// Schema is the versioned layout of the state persisted under a key.
+type Schema struct {
+	Key        string
+	Version    int
+	Migrations []Migration
+}
+
+// Migrate upgrades the state persisted under the key of the schema to the current version.
+// The state is written back only if every migration succeeds, so a failed upgrade leaves the
+// persisted state as it was. State of a newer version than the schema is not understood and
+// is rejected instead of being restored partially. Callers checking the modification time of
+// the store must do so before migrating.
+func (schema *Schema) Migrate(kvs KeyValueStore) error {
+	var state map[string]json.RawMessage
+
+	err := kvs.Read(schema.Key, &state)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return nil
+		}
+		return err
+	}
+
+	version, err := getSchemaVersion(state)
+	if err != nil {
+		return err
+	}
+
+	if version > schema.Version {
+		return fmt.Errorf("%s state has schema version %d, newer than the supported version %d",
+			schema.Key, version, schema.Version)
+	}
+
+	if version == schema.Version {
+		return nil
+	}
+
+	migrations := append([]Migration{}, schema.Migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+
+		if m.Version != version+1 {
+			break
+		}
+
+		log.Printf("[store] Migrating %s state to schema version %d: %s.", schema.Key, m.Version, m.Description)
+
+		if m.Migrate != nil {
+			if err := m.Migrate(state); err != nil {
+				return fmt.Errorf("Failed to migrate %s state to schema version %d: %v", schema.Key, m.Version, err)
+			}
+		}
+
+		version = m.Version
+	}
+
+	if version != schema.Version {
+		return fmt.Errorf("No migration of %s state from schema version %d to %d", schema.Key, version, version+1)
+	}
+
+	state[SchemaVersionField], _ = json.Marshal(version)
+
+	return kvs.Write(schema.Key, state)
+}
+
+// getSchemaVersion returns the schema version of persisted state.
+func getSchemaVersion(state map[string]json.RawMessage) (int, error) {
+	raw, ok := state[SchemaVersionField]
+	if !ok {
+		return unversionedSchemaVersion, nil
+	}
+
+	var version int
+	if err := json.Unmarshal(raw, &version); err != nil {
+		return 0, fmt.Errorf("Invalid schema version %s: %v", raw, err)
+	}
+
+	return version, nil
+}
+
+// RenameField returns a migration function renaming a top-level field of persisted state.
+func RenameField(from, to string) func(state map[string]json.RawMessage) error {
+	return func(state map[string]json.RawMessage) error {
+		if raw, ok := state[from]; ok {
+			state[to] = raw
+			delete(state, from)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// State persisted by an older version, and its current layout.
+type testStateV1 struct {
+	Name  string
+	Count int
+}
+
+type testStateV3 struct {
+	SchemaVersion int
+	Title         string
+	Total         int
+}
+
+// Schema of the test state, renaming both fields in separate versions.
+var testSchema = &Schema{
+	Key:     testKey1,
+	Version: 3,
+	Migrations: []Migration{
+		{Version: 3, Description: "Rename Count to Total", Migrate: RenameField("Count", "Total")},
+		{Version: 2, Description: "Rename Name to Title", Migrate: RenameField("Name", "Title")},
+	},
+}
+
+// Tests that unversioned state is migrated in place to the current schema version.
+func TestSchemaMigratesStateInPlace(t *testing.T) {
+	var state testStateV3
+
+	kvs := NewMemoryStore()
+
+	// Nothing to migrate in an empty store.
+	if err := testSchema.Migrate(kvs); err != nil {
+		t.Fatalf("Failed to migrate empty store: %v", err)
+	}
+
+	kvs.Write(testKey1, &testStateV1{Name: "test", Count: 42})
+
+	if err := testSchema.Migrate(kvs); err != nil {
+		t.Fatalf("Failed to migrate store: %v", err)
+	}
+
+	if err := kvs.Read(testKey1, &state); err != nil {
+		t.Fatalf("Failed to read from store: %v", err)
+	}
+
+	if state != (testStateV3{SchemaVersion: 3, Title: "test", Total: 42}) {
+		t.Errorf("Migrated state is %+v", state)
+	}
+
+	// Current state is left as is.
+	if err := testSchema.Migrate(kvs); err != nil {
+		t.Errorf("Failed to migrate current state: %v", err)
+	}
+}
+
+// Tests that state that can't be migrated is rejected and left unchanged.
+func TestSchemaRejectsStateThatCannotBeMigrated(t *testing.T) {
+	var state map[string]json.RawMessage
+
+	kvs := NewMemoryStore()
+
+	// State of a newer version.
+	kvs.Write(testKey1, &testStateV3{SchemaVersion: 4})
+	if err := testSchema.Migrate(kvs); err == nil {
+		t.Errorf("Migrated state of a newer schema version")
+	}
+
+	// State of a failed migration.
+	failing := &Schema{
+		Key:     testKey1,
+		Version: 2,
+		Migrations: []Migration{
+			{Version: 2, Migrate: func(map[string]json.RawMessage) error { return fmt.Errorf("failed") }},
+		},
+	}
+
+	kvs.Write(testKey1, &testStateV1{Name: "test"})
+	if err := failing.Migrate(kvs); err == nil {
+		t.Errorf("Migration failure was not returned")
+	}
+
+	// State of a version without migration.
+	missing := &Schema{Key: testKey1, Version: 2}
+	if err := missing.Migrate(kvs); err == nil {
+		t.Errorf("Migrated state without a migration")
+	}
+
+	kvs.Read(testKey1, &state)
+	if _, ok := state[SchemaVersionField]; ok || string(state["Name"]) != `"test"` {
+		t.Errorf("State was changed by failed migrations: %v", state)
+	}
+}
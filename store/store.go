@@ -19,6 +19,14 @@ type KeyValueStore interface {
 	GetLockFileModificationTime() (time.Time, error)
 }
 
+// KeyLocker is a KeyValueStore whose keys can also be locked one by one, so that processes
+// updating different parts of the state wait for each other only while the store is locked.
+type KeyLocker interface {
+	KeyValueStore
+	LockKey(key string, block bool) error
+	UnlockKey(key string) error
+}
+
 var (
 	// Errors returned by KeyValueStore methods.
 	ErrKeyNotFound                    = fmt.Errorf("key not found")
@@ -0,0 +1,327 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Environment variables configuring Application Insights.
+	// The connection string holds the instrumentation key and the ingestion endpoint of the cloud.
+	AIInstrumentationKeyEnv = "APPINSIGHTS_INSTRUMENTATIONKEY"
+	AIConnectionStringEnv   = "APPLICATIONINSIGHTS_CONNECTION_STRING"
+	AIProxyEnv              = "APPINSIGHTS_PROXY"
+
+	// Environment variable holding the sampling rates of reports, as comma separated
+	// type=rate pairs, e.g. "CNIReport=0.01,error=1". Rates range from 0, dropping all
+	// reports of the type, to 1, keeping them all. The "error" rate applies to reports
+	// of failures regardless of their type.
+	AISamplingEnv = "APPINSIGHTS_SAMPLING"
+
+	// Sampling type of reports of failures.
+	AIErrorSampling = "error"
+
+	// Ingestion endpoint of the public cloud.
+	DefaultAIIngestionEndpoint = "https://dc.services.visualstudio.com/"
+
+	aiTrackPath    = "v2/track"
+	aiSendTimeout  = 10 * time.Second
+	aiEventType    = "EventData"
+	aiEventVersion = 2
+)
+
+// AIConfig configures sending telemetry reports to Application Insights.
+// Reports are only sent to the host if InstrumentationKey is empty.
+// Reports are all sent unless sampled by SamplingRates, keyed by report type or AIErrorSampling.
+type AIConfig struct {
+	InstrumentationKey string
+	IngestionEndpoint  string
+	ProxyURL           string
+	SamplingRates      map[string]float64
+}
+
+var (
+	aiConfig      AIConfig
+	aiConfigMutex sync.Mutex
+)
+
+func init() {
+	SetAIConfig(GetAIConfigFromEnvironment())
+}
+
+// GetAIConfigFromEnvironment returns the Application Insights configuration in the environment.
+// Settings of the connection string take precedence over the instrumentation key variable.
+func GetAIConfigFromEnvironment() AIConfig {
+	config := AIConfig{
+		InstrumentationKey: os.Getenv(AIInstrumentationKeyEnv),
+		ProxyURL:           os.Getenv(AIProxyEnv),
+	}
+
+	if rates, err := ParseAISamplingRates(os.Getenv(AISamplingEnv)); err != nil {
+		log.Printf("[Telemetry] Ignoring invalid %s, err:%v.", AISamplingEnv, err)
+	} else {
+		config.SamplingRates = rates
+	}
+
+	for _, setting := range strings.Split(os.Getenv(AIConnectionStringEnv), ";") {
+		kv := strings.SplitN(strings.TrimSpace(setting), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch strings.ToLower(kv[0]) {
+		case "instrumentationkey":
+			config.InstrumentationKey = kv[1]
+		case "ingestionendpoint":
+			config.IngestionEndpoint = kv[1]
+		}
+	}
+
+	return config
+}
+
+// SetAIConfig sets the Application Insights configuration. Empty settings keep their current value.
+func SetAIConfig(config AIConfig) {
+	aiConfigMutex.Lock()
+	defer aiConfigMutex.Unlock()
+
+	if config.InstrumentationKey != "" {
+		aiConfig.InstrumentationKey = config.InstrumentationKey
+	}
+
+	if config.IngestionEndpoint != "" {
+		aiConfig.IngestionEndpoint = config.IngestionEndpoint
+	}
+
+	if config.ProxyURL != "" {
+		aiConfig.ProxyURL = config.ProxyURL
+	}
+
+	if config.SamplingRates != nil {
+		aiConfig.SamplingRates = config.SamplingRates
+	}
+
+	if aiConfig.IngestionEndpoint == "" {
+		aiConfig.IngestionEndpoint = DefaultAIIngestionEndpoint
+	}
+}
+
+// ParseAISamplingRates parses sampling rates in the format of AISamplingEnv. Returns nil if empty.
+func ParseAISamplingRates(s string) (map[string]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	rates := make(map[string]float64)
+	for _, setting := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(setting), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid sampling rate %q", setting)
+		}
+
+		rate, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("Invalid sampling rate %q", setting)
+		}
+
+		rates[strings.TrimSpace(kv[0])] = rate
+	}
+
+	return rates, nil
+}
+
+// GetAIConfig returns the Application Insights configuration.
+func GetAIConfig() AIConfig {
+	aiConfigMutex.Lock()
+	defer aiConfigMutex.Unlock()
+
+	return aiConfig
+}
+
+// Application Insights envelope of a custom event.
+type aiEnvelope struct {
+	Name       string            `json:"name"`
+	Time       string            `json:"time"`
+	IKey       string            `json:"iKey"`
+	SampleRate float64           `json:"sampleRate,omitempty"`
+	Data       aiEnvelopeData    `json:"data"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+type aiEnvelopeData struct {
+	BaseType string      `json:"baseType"`
+	BaseData aiEventData `json:"baseData"`
+}
+
+type aiEventData struct {
+	Ver        int               `json:"ver"`
+	Name       string            `json:"name"`
+	Properties map[string]string `json:"properties"`
+}
+
+// getReportProperties returns the fields of a report as strings. Nested fields are returned as JSON.
+func getReportProperties(report interface{}) (map[string]string, error) {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err = json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+
+	properties := make(map[string]string)
+	for key, value := range fields {
+		switch v := value.(type) {
+		case string:
+			properties[key] = v
+		case nil:
+		default:
+			b, _ := json.Marshal(v)
+			properties[key] = string(b)
+		}
+	}
+
+	return properties, nil
+}
+
+// getReportType returns the name of the type of a report.
+func getReportType(report interface{}) string {
+	return reflect.Indirect(reflect.ValueOf(report)).Type().Name()
+}
+
+// newAIEnvelope returns the custom event of a report, named after its type.
+func newAIEnvelope(instrumentationKey string, report interface{}) (*aiEnvelope, error) {
+	properties, err := getReportProperties(report)
+	if err != nil {
+		return nil, err
+	}
+
+	return &aiEnvelope{
+		Name: fmt.Sprintf("Microsoft.ApplicationInsights.%s.Event", strings.Replace(instrumentationKey, "-", "", -1)),
+		Time: time.Now().UTC().Format(time.RFC3339Nano),
+		IKey: instrumentationKey,
+		Data: aiEnvelopeData{
+			BaseType: aiEventType,
+			BaseData: aiEventData{
+				Ver:        aiEventVersion,
+				Name:       getReportType(report),
+				Properties: properties,
+			},
+		},
+	}, nil
+}
+
+// isErrorReport checks if a report is about a failure.
+func isErrorReport(report interface{}) bool {
+	v := reflect.Indirect(reflect.ValueOf(report))
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+
+	for _, name := range []string{"ErrorMessage", "Errorcode"} {
+		if f := v.FieldByName(name); f.IsValid() && f.Kind() == reflect.String && f.String() != "" {
+			return true
+		}
+	}
+
+	if f := v.FieldByName("Failures"); f.IsValid() && f.Kind() == reflect.Int && f.Int() > 0 {
+		return true
+	}
+
+	return false
+}
+
+// getSamplingRate returns the rate at which reports like the given one are sent.
+func getSamplingRate(rates map[string]float64, report interface{}) float64 {
+	name := AIErrorSampling
+	if !isErrorReport(report) {
+		name = getReportType(report)
+	}
+
+	if rate, ok := rates[name]; ok {
+		return rate
+	}
+
+	return 1
+}
+
+// sendToAI sends reports to Application Insights as custom events, if configured.
+func sendToAI(reports ...interface{}) error {
+	config := GetAIConfig()
+	if config.InstrumentationKey == "" || len(reports) == 0 {
+		return nil
+	}
+
+	var envelopes []*aiEnvelope
+	for _, report := range reports {
+		rate := getSamplingRate(config.SamplingRates, report)
+		if rate <= 0 || (rate < 1 && rand.Float64() >= rate) {
+			continue
+		}
+
+		envelope, err := newAIEnvelope(config.InstrumentationKey, report)
+		if err != nil {
+			return err
+		}
+
+		// Application Insights extrapolates counts of sampled events from their sample rate in percent.
+		if rate < 1 {
+			envelope.SampleRate = rate * 100
+		}
+
+		envelopes = append(envelopes, envelope)
+	}
+
+	if len(envelopes) == 0 {
+		return nil
+	}
+
+	// The Application Insights proxy takes precedence over the one of all outbound calls.
+	httpc := common.NewHTTPClient(aiSendTimeout)
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("[Telemetry] Invalid Application Insights proxy %s: %v", config.ProxyURL, err)
+		}
+		httpc.Transport.(*http.Transport).Proxy = http.ProxyURL(proxyURL)
+	}
+
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(envelopes); err != nil {
+		return err
+	}
+
+	trackURL := strings.TrimRight(config.IngestionEndpoint, "/") + "/" + aiTrackPath
+	resp, err := httpc.Post(trackURL, ContentType, &body)
+	if err != nil {
+		return fmt.Errorf("[Telemetry] Application Insights post returned error %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("[Telemetry] Application Insights post returned statuscode %d", resp.StatusCode)
+	}
+
+	log.Printf("[Telemetry] Sent %d reports to Application Insights.", len(envelopes))
+
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+package telemetry
+
+import (
+	"sort"
+	"time"
+)
+
+// Upper bounds in ms of the latency buckets of CNI operations. The last bucket is unbounded.
+var CNIOperationLatencyBuckets = []int64{100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
+// CNIOperationMetric aggregates the CNI operations of a verb over a window.
+type CNIOperationMetric struct {
+	Operation      string
+	WindowStart    string
+	WindowEnd      string
+	Count          int
+	Failures       int
+	SuccessRatio   float64
+	LatencySumMs   int64
+	LatencyMaxMs   int64
+	LatencyBuckets []int64
+	LatencyCounts  []int
+}
+
+// cniOperationWindow aggregates the CNI operations reported since the window started.
+type cniOperationWindow struct {
+	start      time.Time
+	operations map[string]*CNIOperationMetric
+}
+
+// add aggregates a CNI operation report.
+func (window *cniOperationWindow) add(report CNIReport) {
+	if window.operations == nil {
+		window.operations = make(map[string]*CNIOperationMetric)
+		window.start = time.Now()
+	}
+
+	metric := window.operations[report.OperationType]
+	if metric == nil {
+		metric = &CNIOperationMetric{
+			Operation:      report.OperationType,
+			LatencyBuckets: CNIOperationLatencyBuckets,
+			LatencyCounts:  make([]int, len(CNIOperationLatencyBuckets)+1),
+		}
+		window.operations[report.OperationType] = metric
+	}
+
+	metric.Count++
+	if !report.CniSucceeded {
+		metric.Failures++
+	}
+
+	latency := report.OperationDuration
+	metric.LatencySumMs += latency
+	if latency > metric.LatencyMaxMs {
+		metric.LatencyMaxMs = latency
+	}
+
+	bucket := sort.Search(len(CNIOperationLatencyBuckets), func(i int) bool {
+		return latency <= CNIOperationLatencyBuckets[i]
+	})
+	metric.LatencyCounts[bucket]++
+}
+
+// close returns the metrics of the window, sorted by operation, and starts a new window.
+func (window *cniOperationWindow) close() []CNIOperationMetric {
+	var metrics []CNIOperationMetric
+	end := time.Now().UTC().String()
+
+	for _, metric := range window.operations {
+		metric.WindowStart = window.start.UTC().String()
+		metric.WindowEnd = end
+		metric.SuccessRatio = float64(metric.Count-metric.Failures) / float64(metric.Count)
+		metrics = append(metrics, *metric)
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Operation < metrics[j].Operation
+	})
+
+	window.operations = nil
+
+	return metrics
+}
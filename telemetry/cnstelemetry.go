@@ -4,6 +4,7 @@
 package telemetry
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"regexp"
@@ -12,6 +13,7 @@ import (
 	"github.com/Azure/azure-container-networking/cns/restserver"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/platform"
+	"github.com/Azure/azure-container-networking/retry"
 	"github.com/google/uuid"
 )
 
@@ -21,12 +23,28 @@ const (
 	errorcodePrefix  = 5
 )
 
+// Connections to the telemetry buffer are retried until they succeed, backing off up to a minute.
+var connectRetryPolicy = retry.Policy{
+	InitialDelay: time.Second,
+	MaxDelay:     time.Minute,
+	Jitter:       0.2,
+}
+
 // SendCnsTelemetry - handles cns telemetry reports
 func SendCnsTelemetry(interval int, reports chan interface{}, service *restserver.HTTPRestService, telemetryStopProcessing chan bool) {
 	retrieveMetadata := true
 
 CONNECT:
-	telemetryBuffer, err := NewTelemetryBuffer()
+	var telemetryBuffer *TelemetryBuffer
+	err := retry.Do(context.Background(), &connectRetryPolicy, func() error {
+		var err error
+		telemetryBuffer, err = NewTelemetryBuffer()
+		if err != nil {
+			log.Printf("[Telemetry] Failed to establish telemetry buffer connection.")
+		}
+		return err
+	})
+
 	if err == nil {
 		go telemetryBuffer.Start(time.Duration(interval))
 
@@ -87,9 +105,5 @@ CONNECT:
 				}
 			}
 		}
-	} else {
-		log.Printf("[Telemetry] Failed to establish telemetry buffer connection.")
-		time.Sleep(time.Minute * 1)
-		goto CONNECT
 	}
 }
@@ -0,0 +1,199 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// Report types of records in the disk buffer.
+const (
+	dncReportType = "DNCReport"
+	cniReportType = "CNIReport"
+	npmReportType = "NPMReport"
+	cnsReportType = "CNSReport"
+)
+
+// diskBuffer persists reports that are not yet sent to the host, so that they survive restarts.
+// Records are appended to a file of JSON lines. The oldest records are dropped when the file
+// grows beyond its maximum size.
+type diskBuffer struct {
+	path    string
+	maxSize int64
+}
+
+// diskRecord is a report persisted in the disk buffer.
+type diskRecord struct {
+	Type   string
+	Report json.RawMessage
+}
+
+// newDiskBuffer creates a disk buffer persisting reports in the given file.
+func newDiskBuffer(path string, maxSize int64) *diskBuffer {
+	return &diskBuffer{path: path, maxSize: maxSize}
+}
+
+// append persists a report.
+func (db *diskBuffer) append(report interface{}) error {
+	record := diskRecord{}
+
+	switch report.(type) {
+	case DNCReport:
+		record.Type = dncReportType
+	case CNIReport:
+		record.Type = cniReportType
+	case NPMReport:
+		record.Type = npmReportType
+	case CNSReport:
+		record.Type = cnsReportType
+	default:
+		return nil
+	}
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	record.Report = b
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(db.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(line, Delimiter))
+	if err == nil {
+		var info os.FileInfo
+		if info, err = file.Stat(); err == nil && info.Size() > db.maxSize {
+			file.Close()
+			return db.compact()
+		}
+	}
+
+	file.Close()
+
+	return err
+}
+
+// readLines returns the records in the file.
+func (db *diskBuffer) readLines() ([][]byte, error) {
+	b, err := ioutil.ReadFile(db.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 0, 64*1024), int(db.maxSize)+1)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// compact drops the oldest records so that the file fills at most half of its maximum size.
+func (db *diskBuffer) compact() error {
+	lines, err := db.readLines()
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	first := len(lines)
+	for first > 0 && size+int64(len(lines[first-1])+1) <= db.maxSize/2 {
+		first--
+		size += int64(len(lines[first]) + 1)
+	}
+
+	log.Printf("[Telemetry] Dropping %d oldest buffered reports.", first)
+
+	var body bytes.Buffer
+	for _, line := range lines[first:] {
+		body.Write(line)
+		body.WriteByte(Delimiter)
+	}
+
+	// Replace the file atomically so that a crash doesn't lose the newest records.
+	tmpPath := db.path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, body.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, db.path)
+}
+
+// load returns the persisted reports, oldest first. Records that can't be decoded are skipped.
+func (db *diskBuffer) load() ([]interface{}, error) {
+	lines, err := db.readLines()
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []interface{}
+	for _, line := range lines {
+		var record diskRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			log.Printf("[Telemetry] Skipping invalid buffered report, err:%v.", err)
+			continue
+		}
+
+		var report interface{}
+		switch record.Type {
+		case dncReportType:
+			var r DNCReport
+			err = json.Unmarshal(record.Report, &r)
+			report = r
+		case cniReportType:
+			var r CNIReport
+			err = json.Unmarshal(record.Report, &r)
+			report = r
+		case npmReportType:
+			var r NPMReport
+			err = json.Unmarshal(record.Report, &r)
+			report = r
+		case cnsReportType:
+			var r CNSReport
+			err = json.Unmarshal(record.Report, &r)
+			report = r
+		default:
+			continue
+		}
+
+		if err != nil {
+			log.Printf("[Telemetry] Skipping invalid buffered %s, err:%v.", record.Type, err)
+			continue
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// clear drops all persisted reports.
+func (db *diskBuffer) clear() error {
+	err := os.Remove(db.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
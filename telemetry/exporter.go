@@ -0,0 +1,478 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/platform"
+)
+
+const (
+	// Types of the exporters sending the reports of the telemetry buffer.
+	// The host exporter sends them to the host net agent, and the file exporter keeps them on the node.
+	ExporterHost        = "host"
+	ExporterAppInsights = "appinsights"
+	ExporterFile        = "file"
+	ExporterOTLP        = "otlp"
+	ExporterStatsd      = "statsd"
+
+	// Defaults of the file exporter. The file is rotated once it grows beyond its maximum size.
+	DefaultReportFileName = "azure-telemetry-reports.json"
+	DefaultReportFileSize = 16 * 1024 * 1024
+
+	// Defaults of the statsd exporter.
+	DefaultStatsdAddress = "127.0.0.1:8125"
+	DefaultStatsdPrefix  = "azure_vnet"
+
+	otlpLogsPath     = "v1/logs"
+	otlpSendTimeout  = 10 * time.Second
+	otlpServiceName  = "azure-vnet-telemetry"
+	otlpScopeName    = "github.com/Azure/azure-container-networking/telemetry"
+	otlpSeverityInfo = 9
+	otlpSeverityErr  = 17
+
+	// Statsd metrics are batched in datagrams that fit in the MTU of most networks.
+	statsdMaxDatagramSize = 1432
+)
+
+// ExporterConfig configures an exporter of the reports of the telemetry buffer.
+// Settings not used by the type of the exporter are ignored.
+type ExporterConfig struct {
+	Type string `json:"type"`
+	// Failures of best effort exporters are only logged. Other exporters keep the reports
+	// buffered until they are all sent successfully.
+	BestEffort bool `json:"bestEffort,omitempty"`
+	// Path and maximum size of the file of the file exporter.
+	Path    string `json:"path,omitempty"`
+	MaxSize int64  `json:"maxSize,omitempty"`
+	// Base URL of the OTLP/HTTP collector, and headers of the requests sent to it.
+	Endpoint string            `json:"endpoint,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	// UDP address of the statsd server, and prefix of the metric names.
+	Address string `json:"address,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+}
+
+// TelemetryConfig is the config file of the telemetry buffer.
+// Reports are exported to the host, and to Application Insights if configured, unless exporters are set.
+type TelemetryConfig struct {
+	Exporters   []ExporterConfig `json:"exporters,omitempty"`
+	AppInsights AIConfig         `json:"appInsights"`
+}
+
+// exporter sends the reports of a payload to a sink.
+type exporter interface {
+	export(pl *Payload) error
+}
+
+var (
+	exporters      = newDefaultExporters()
+	exportersMutex sync.Mutex
+)
+
+// ReadTelemetryConfig reads and validates a telemetry config file.
+func ReadTelemetryConfig(path string) (*TelemetryConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config TelemetryConfig
+	if err = json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("Invalid telemetry config %s: %v", path, err)
+	}
+
+	for _, exporterConfig := range config.Exporters {
+		if _, err = newExporter(exporterConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	return &config, nil
+}
+
+// SetExporters sets the exporters of the reports of telemetry buffers started afterwards.
+// Empty configs keep the default exporters.
+func SetExporters(configs []ExporterConfig) error {
+	if len(configs) == 0 {
+		return nil
+	}
+
+	var configured []exporter
+	for _, config := range configs {
+		e, err := newExporter(config)
+		if err != nil {
+			return err
+		}
+		configured = append(configured, e)
+	}
+
+	exportersMutex.Lock()
+	defer exportersMutex.Unlock()
+
+	exporters = configured
+
+	return nil
+}
+
+// getExporters returns the exporters of the reports of the telemetry buffer.
+func getExporters() []exporter {
+	exportersMutex.Lock()
+	defer exportersMutex.Unlock()
+
+	return exporters
+}
+
+// newDefaultExporters returns the exporters used without config. Reports are kept buffered until
+// sent to the host, and failures to send them to Application Insights are only logged.
+func newDefaultExporters() []exporter {
+	return []exporter{
+		&hostExporter{url: HostNetAgentURL},
+		&bestEffortExporter{name: ExporterAppInsights, exporter: &aiExporter{}},
+	}
+}
+
+// newExporter creates the exporter of a config.
+func newExporter(config ExporterConfig) (exporter, error) {
+	var e exporter
+
+	switch strings.ToLower(config.Type) {
+	case ExporterHost:
+		e = &hostExporter{url: HostNetAgentURL}
+	case ExporterAppInsights:
+		e = &aiExporter{}
+	case ExporterFile:
+		fe := &fileExporter{path: config.Path, maxSize: config.MaxSize}
+		if fe.path == "" {
+			fe.path = platform.CNSRuntimePath + DefaultReportFileName
+		}
+		if fe.maxSize <= 0 {
+			fe.maxSize = DefaultReportFileSize
+		}
+		e = fe
+	case ExporterOTLP:
+		if config.Endpoint == "" {
+			return nil, fmt.Errorf("Missing endpoint of the %s exporter", ExporterOTLP)
+		}
+		e = &otlpExporter{endpoint: config.Endpoint, headers: config.Headers}
+	case ExporterStatsd:
+		se := &statsdExporter{address: config.Address, prefix: config.Prefix}
+		if se.address == "" {
+			se.address = DefaultStatsdAddress
+		}
+		if se.prefix == "" {
+			se.prefix = DefaultStatsdPrefix
+		}
+		e = se
+	default:
+		return nil, fmt.Errorf("Invalid telemetry exporter type %q", config.Type)
+	}
+
+	if config.BestEffort {
+		e = &bestEffortExporter{name: config.Type, exporter: e}
+	}
+
+	return e, nil
+}
+
+// bestEffortExporter logs the failures of the exporter it wraps.
+type bestEffortExporter struct {
+	name     string
+	exporter exporter
+}
+
+func (e *bestEffortExporter) export(pl *Payload) error {
+	if err := e.exporter.export(pl); err != nil {
+		log.Printf("[Telemetry] Failed to export reports to %s, err:%v.", e.name, err)
+	}
+
+	return nil
+}
+
+// hostExporter sends the payload to the host net agent.
+type hostExporter struct {
+	url string
+}
+
+func (e *hostExporter) export(pl *Payload) error {
+	httpc := common.NewHTTPClient(0)
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(pl)
+	resp, err := httpc.Post(e.url, ContentType, &body)
+	if err != nil {
+		return fmt.Errorf("[Telemetry] HTTP Post returned error %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("[Telemetry] HTTP Post returned statuscode %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// aiExporter sends the reports to Application Insights, if configured.
+type aiExporter struct{}
+
+func (e *aiExporter) export(pl *Payload) error {
+	return sendToAI(pl.reports()...)
+}
+
+// fileExporter appends the reports to a file of JSON lines, so that they can be collected
+// from the node. The file is renamed with a .1 suffix once it grows beyond its maximum size.
+type fileExporter struct {
+	path    string
+	maxSize int64
+}
+
+// fileRecord is a report exported to a file.
+type fileRecord struct {
+	Time   string
+	Type   string
+	Report interface{}
+}
+
+func (e *fileExporter) export(pl *Payload) error {
+	reports := pl.reports()
+	if len(reports) == 0 {
+		return nil
+	}
+
+	if info, err := os.Stat(e.path); err == nil && info.Size() > e.maxSize {
+		if err = os.Rename(e.path, e.path+".1"); err != nil {
+			return err
+		}
+	}
+
+	var lines bytes.Buffer
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, report := range reports {
+		line, err := json.Marshal(fileRecord{Time: now, Type: getReportType(report), Report: report})
+		if err != nil {
+			return err
+		}
+		lines.Write(append(line, Delimiter))
+	}
+
+	file, err := os.OpenFile(e.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	_, err = file.Write(lines.Bytes())
+	return err
+}
+
+// OTLP/HTTP JSON encoding of log records.
+type otlpLogs struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano   string          `json:"timeUnixNano"`
+	SeverityNumber int             `json:"severityNumber"`
+	SeverityText   string          `json:"severityText"`
+	Body           otlpValue       `json:"body"`
+	Attributes     []otlpAttribute `json:"attributes"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// otlpExporter sends the reports to an OpenTelemetry collector as log records named after their type.
+type otlpExporter struct {
+	endpoint string
+	headers  map[string]string
+}
+
+// newOTLPLogs returns the log records of reports, with their fields as attributes.
+func newOTLPLogs(reports []interface{}) (*otlpLogs, error) {
+	scopeLogs := otlpScopeLogs{Scope: otlpScope{Name: otlpScopeName}}
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	for _, report := range reports {
+		properties, err := getReportProperties(report)
+		if err != nil {
+			return nil, err
+		}
+
+		record := otlpLogRecord{
+			TimeUnixNano:   now,
+			SeverityNumber: otlpSeverityInfo,
+			SeverityText:   "INFO",
+			Body:           otlpValue{StringValue: getReportType(report)},
+		}
+
+		if isErrorReport(report) {
+			record.SeverityNumber, record.SeverityText = otlpSeverityErr, "ERROR"
+		}
+
+		for key, value := range properties {
+			record.Attributes = append(record.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+		}
+		sort.Slice(record.Attributes, func(i, j int) bool { return record.Attributes[i].Key < record.Attributes[j].Key })
+
+		scopeLogs.LogRecords = append(scopeLogs.LogRecords, record)
+	}
+
+	return &otlpLogs{
+		ResourceLogs: []otlpResourceLogs{
+			{
+				Resource: otlpResource{
+					Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: otlpServiceName}}},
+				},
+				ScopeLogs: []otlpScopeLogs{scopeLogs},
+			},
+		},
+	}, nil
+}
+
+func (e *otlpExporter) export(pl *Payload) error {
+	reports := pl.reports()
+	if len(reports) == 0 {
+		return nil
+	}
+
+	logs, err := newOTLPLogs(reports)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	if err = json.NewEncoder(&body).Encode(logs); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(e.endpoint, "/")+"/"+otlpLogsPath, &body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", ContentType)
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := common.NewHTTPClient(otlpSendTimeout).Do(req)
+	if err != nil {
+		return fmt.Errorf("[Telemetry] OTLP post returned error %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("[Telemetry] OTLP post returned statuscode %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// statsdExporter sends counters of the reports to a statsd server. CNI operation metrics are
+// sent as counters and gauges of each operation.
+type statsdExporter struct {
+	address string
+	prefix  string
+}
+
+// getStatsdMetrics returns the statsd metrics of reports, sorted by name.
+func getStatsdMetrics(prefix string, reports []interface{}) []string {
+	counters := make(map[string]int64)
+	var metrics []string
+
+	for _, report := range reports {
+		name := prefix + "." + strings.ToLower(getReportType(report))
+		counters[name+".reports"]++
+		if isErrorReport(report) {
+			counters[name+".errors"]++
+		}
+
+		if metric, ok := report.(CNIOperationMetric); ok {
+			name += "." + strings.ToLower(metric.Operation)
+			counters[name+".count"] += int64(metric.Count)
+			counters[name+".failures"] += int64(metric.Failures)
+			counters[name+".latency_sum_ms"] += metric.LatencySumMs
+			metrics = append(metrics, fmt.Sprintf("%s.latency_max_ms:%d|g", name, metric.LatencyMaxMs))
+		}
+	}
+
+	for name, value := range counters {
+		metrics = append(metrics, fmt.Sprintf("%s:%d|c", name, value))
+	}
+	sort.Strings(metrics)
+
+	return metrics
+}
+
+func (e *statsdExporter) export(pl *Payload) error {
+	metrics := getStatsdMetrics(e.prefix, pl.reports())
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	conn, err := net.Dial("udp", e.address)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	var datagram bytes.Buffer
+	for _, metric := range metrics {
+		if datagram.Len() > 0 && datagram.Len()+len(metric)+1 > statsdMaxDatagramSize {
+			if _, err = conn.Write(datagram.Bytes()); err != nil {
+				return err
+			}
+			datagram.Reset()
+		}
+
+		if datagram.Len() > 0 {
+			datagram.WriteByte(Delimiter)
+		}
+		datagram.WriteString(metric)
+	}
+
+	_, err = conn.Write(datagram.Bytes())
+	return err
+}
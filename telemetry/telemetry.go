@@ -6,6 +6,7 @@ package telemetry
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -16,10 +17,12 @@ import (
 	"os/exec"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/platform"
+	"github.com/Azure/azure-container-networking/retry"
 )
 
 const (
@@ -32,6 +35,15 @@ const (
 	ContentType = "application/json"
 )
 
+// Reports the host agent fails to receive are sent again a few times, briefly so as not to
+// hold up the plugins sending them.
+var sendRetryPolicy = retry.Policy{
+	MaxAttempts:  3,
+	InitialDelay: 200 * time.Millisecond,
+	MaxDelay:     time.Second,
+	Jitter:       0.2,
+}
+
 // OS Details structure.
 type OSInfo struct {
 	OSType         string
@@ -110,7 +122,10 @@ type CNIReport struct {
 	OSVersion           string
 	ErrorMessage        string
 	Context             string
+	StaleEndpointCount  int
 	SubContext          string
+	OperationType       string
+	OperationDuration   int64
 	VnetAddressSpace    []string
 	OrchestratorDetails *OrchestratorInfo
 	OSDetails           *OSInfo
@@ -134,6 +149,18 @@ type CNSReport struct {
 	Metadata        Metadata `json:"compute"`
 }
 
+// Azure IPAM Telemetry Report structure.
+type IPAMReport struct {
+	EventMessage string
+	AddressSpace string
+	PoolId       string
+	Capacity     int
+	Allocated    int
+	TopConsumers []string
+	Timestamp    string
+	Metadata     Metadata `json:"compute"`
+}
+
 // ClusterState contains the current kubernetes cluster state.
 type ClusterState struct {
 	PodCount      int
@@ -156,6 +183,20 @@ type NPMReport struct {
 	Metadata          Metadata `json:"compute"`
 }
 
+// NPMPolicyApplyReport is the result and latency of applying a network policy change to the dataplane.
+type NPMPolicyApplyReport struct {
+	ClusterID       string
+	NodeName        string
+	PolicyKey       string
+	Operation       string
+	Succeeded       bool
+	FailureCategory string
+	ErrorMessage    string
+	LatencyMs       int64
+	Attempts        int
+	Timestamp       string
+}
+
 // DNCReport structure.
 type DNCReport struct {
 	IsNewInstance bool
@@ -239,35 +280,58 @@ func (reportMgr *ReportManager) SendReport() error {
 		log.Printf("[Telemetry] %+v", reportMgr.Report.(*CNIReport))
 	case *NPMReport:
 		log.Printf("[Telemetry] %+v", reportMgr.Report.(*NPMReport))
+	case *NPMPolicyApplyReport:
+		log.Printf("[Telemetry] %+v", reportMgr.Report.(*NPMPolicyApplyReport))
 	case *DNCReport:
 		log.Printf("[Telemetry] %+v", reportMgr.Report.(*DNCReport))
+	case *IPAMReport:
+		log.Printf("[Telemetry] %+v", reportMgr.Report.(*IPAMReport))
 	default:
 		log.Printf("[Telemetry] Invalid report type")
 	}
 
-	httpc := &http.Client{}
-	var body bytes.Buffer
-	json.NewEncoder(&body).Encode(reportMgr.Report)
-	resp, err := httpc.Post(reportMgr.HostNetAgentURL, reportMgr.ContentType, &body)
-	if err != nil {
-		return fmt.Errorf("[Telemetry] HTTP Post returned error %v", err)
+	if err := sendToAI(reportMgr.Report); err != nil {
+		log.Printf("%v", err)
 	}
 
-	defer resp.Body.Close()
+	// Reports are only sent to the host if it runs a host agent.
+	if reportMgr.HostNetAgentURL == "" {
+		return nil
+	}
 
-	if resp.StatusCode != 200 {
-		if resp.StatusCode == 400 {
-			return fmt.Errorf(`"[Telemetry] HTTP Post returned statuscode %d. 
-				This error happens because telemetry service is not yet activated. 
-				The error can be ignored as it won't affect functionality"`, resp.StatusCode)
+	var payload bytes.Buffer
+	json.NewEncoder(&payload).Encode(reportMgr.Report)
+
+	// Retry failures to reach the host agent and its server errors. Other errors,
+	// such as the telemetry service not being activated, won't go away.
+	return retry.Do(context.Background(), &sendRetryPolicy, func() error {
+		httpc := common.NewHTTPClient(0)
+		resp, err := httpc.Post(reportMgr.HostNetAgentURL, reportMgr.ContentType, bytes.NewReader(payload.Bytes()))
+		if err != nil {
+			return fmt.Errorf("[Telemetry] HTTP Post returned error %v", err)
 		}
 
-		return fmt.Errorf("[Telemetry] HTTP Post returned statuscode %d", resp.StatusCode)
-	}
+		defer resp.Body.Close()
 
-	log.Printf("[Telemetry] Telemetry sent with status code %d\n", resp.StatusCode)
+		if resp.StatusCode != 200 {
+			if resp.StatusCode == 400 {
+				return retry.Permanent(fmt.Errorf(`"[Telemetry] HTTP Post returned statuscode %d. 
+				This error happens because telemetry service is not yet activated. 
+				The error can be ignored as it won't affect functionality"`, resp.StatusCode))
+			}
 
-	return nil
+			err = fmt.Errorf("[Telemetry] HTTP Post returned statuscode %d", resp.StatusCode)
+			if resp.StatusCode < http.StatusInternalServerError {
+				return retry.Permanent(err)
+			}
+
+			return err
+		}
+
+		log.Printf("[Telemetry] Telemetry sent with status code %d\n", resp.StatusCode)
+
+		return nil
+	})
 }
 
 // SetReportState will save the state in file if telemetry report sent successfully.
@@ -323,6 +387,7 @@ func (report *CNIReport) GetInterfaceDetails(queryUrl string) {
 	)
 
 	if queryUrl == "" {
+		report.InterfaceDetails = &InterfaceInfo{}
 		report.InterfaceDetails.ErrorMessage = "IpamQueryUrl is null"
 		return
 	}
@@ -334,7 +399,7 @@ func (report *CNIReport) GetInterfaceDetails(queryUrl string) {
 		return
 	}
 
-	resp, err := http.Get(queryUrl)
+	resp, err := common.NewHTTPClient(0).Get(queryUrl)
 	if err != nil {
 		report.InterfaceDetails = &InterfaceInfo{}
 		report.InterfaceDetails.ErrorMessage = "Http get failed in getting interface details " + err.Error()
@@ -439,7 +504,7 @@ func (reportMgr *ReportManager) GetHostMetadata() error {
 	}
 
 	req.Header.Set("Metadata", "True")
-	client := &http.Client{}
+	client := common.NewHTTPClient(0)
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -491,6 +556,7 @@ func (reportMgr *ReportManager) ReportToBytes() (report []byte, err error) {
 	case *NPMReport:
 	case *DNCReport:
 	case *CNSReport:
+	case *IPAMReport:
 	default:
 		err = fmt.Errorf("[Telemetry] Invalid report type")
 	}
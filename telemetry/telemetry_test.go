@@ -6,11 +6,16 @@ package telemetry
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-container-networking/common"
 )
@@ -138,3 +143,230 @@ func TestSetReportState(t *testing.T) {
 		t.Errorf("Error removing telemetry file due to %v", err)
 	}
 }
+
+func TestAIConfigFromEnvironment(t *testing.T) {
+	os.Setenv(AIConnectionStringEnv, "InstrumentationKey=00000000-0000-0000-0000-000000000001;IngestionEndpoint=https://usgovvirginia-0.in.applicationinsights.azure.us/")
+	defer os.Unsetenv(AIConnectionStringEnv)
+
+	config := GetAIConfigFromEnvironment()
+	if config.InstrumentationKey != "00000000-0000-0000-0000-000000000001" ||
+		config.IngestionEndpoint != "https://usgovvirginia-0.in.applicationinsights.azure.us/" {
+		t.Errorf("Unexpected Application Insights config %+v", config)
+	}
+}
+
+func TestAIEnvelope(t *testing.T) {
+	envelope, err := newAIEnvelope("00000000-0000-0000-0000-000000000001", &CNIReport{Name: "azure-vnet", ErrorMessage: "failed"})
+	if err != nil {
+		t.Fatalf("newAIEnvelope failed, err:%v", err)
+	}
+
+	if envelope.Data.BaseType != aiEventType ||
+		envelope.Data.BaseData.Name != "CNIReport" ||
+		envelope.Data.BaseData.Properties["Name"] != "azure-vnet" ||
+		envelope.Data.BaseData.Properties["ErrorMessage"] != "failed" {
+		t.Errorf("Unexpected Application Insights envelope %+v", envelope)
+	}
+}
+
+func TestDiskBuffer(t *testing.T) {
+	path := "azure-telemetry-buffer-test.json"
+	defer os.Remove(path)
+
+	buffer := newDiskBuffer(path, 4096)
+	buffer.append(CNIReport{Name: "azure-vnet", ErrorMessage: "failed"})
+	buffer.append(NPMReport{ClusterID: "cluster"})
+
+	reports, err := buffer.load()
+	if err != nil || len(reports) != 2 {
+		t.Fatalf("Failed to load buffered reports %+v, err:%v", reports, err)
+	}
+
+	if report, ok := reports[0].(CNIReport); !ok || report.ErrorMessage != "failed" {
+		t.Errorf("Unexpected buffered report %+v", reports[0])
+	}
+
+	// The oldest reports are dropped when the buffer is full.
+	for i := 0; i < 100; i++ {
+		buffer.append(CNSReport{DncPartitionKey: fmt.Sprintf("%d", i)})
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Size() > 4096 {
+		t.Errorf("Buffer exceeds its maximum size, err:%v", err)
+	}
+
+	reports, _ = buffer.load()
+	if report, ok := reports[len(reports)-1].(CNSReport); !ok || report.DncPartitionKey != "99" {
+		t.Errorf("Newest report was dropped %+v", reports[len(reports)-1])
+	}
+
+	if err = buffer.clear(); err != nil {
+		t.Errorf("Failed to clear buffer, err:%v", err)
+	}
+
+	if reports, _ = buffer.load(); len(reports) != 0 {
+		t.Errorf("Buffer not cleared %+v", reports)
+	}
+}
+
+func TestCNIOperationMetrics(t *testing.T) {
+	var payload Payload
+
+	payload.push(CNIReport{OperationType: "ADD", CniSucceeded: true, OperationDuration: 80})
+	payload.push(CNIReport{OperationType: "ADD", CniSucceeded: false, OperationDuration: 3000})
+	payload.push(CNIReport{OperationType: "DEL", CniSucceeded: true, OperationDuration: 40000})
+	payload.push(CNIReport{Name: "azure-vnet"})
+
+	if len(payload.CNIReports) != 1 {
+		t.Errorf("CNI operations not aggregated %+v", payload.CNIReports)
+	}
+
+	metrics := payload.cniOperations.close()
+	if len(metrics) != 2 {
+		t.Fatalf("Unexpected CNI operation metrics %+v", metrics)
+	}
+
+	add, del := metrics[0], metrics[1]
+	if add.Operation != "ADD" || add.Count != 2 || add.Failures != 1 || add.SuccessRatio != 0.5 ||
+		add.LatencySumMs != 3080 || add.LatencyMaxMs != 3000 || add.LatencyCounts[0] != 1 || add.LatencyCounts[5] != 1 {
+		t.Errorf("Unexpected ADD metric %+v", add)
+	}
+
+	if del.Operation != "DEL" || del.LatencyCounts[len(CNIOperationLatencyBuckets)] != 1 {
+		t.Errorf("Unexpected DEL metric %+v", del)
+	}
+
+	if metrics = payload.cniOperations.close(); len(metrics) != 0 {
+		t.Errorf("Window not reset %+v", metrics)
+	}
+}
+
+func TestAISampling(t *testing.T) {
+	rates, err := ParseAISamplingRates("CNIReport=0, NPMReport=0.5,error=1")
+	if err != nil || len(rates) != 3 {
+		t.Fatalf("Failed to parse sampling rates %+v, err:%v", rates, err)
+	}
+
+	if _, err = ParseAISamplingRates("CNIReport=2"); err == nil {
+		t.Errorf("Invalid sampling rate accepted")
+	}
+
+	if rate := getSamplingRate(rates, &CNIReport{}); rate != 0 {
+		t.Errorf("Unexpected sampling rate %v of CNI report", rate)
+	}
+
+	if rate := getSamplingRate(rates, CNIReport{ErrorMessage: "failed"}); rate != 1 {
+		t.Errorf("Unexpected sampling rate %v of CNI error report", rate)
+	}
+
+	if rate := getSamplingRate(rates, CNIOperationMetric{Failures: 1}); rate != 1 {
+		t.Errorf("Unexpected sampling rate %v of failed CNI operations", rate)
+	}
+
+	if rate := getSamplingRate(rates, CNSReport{}); rate != 1 {
+		t.Errorf("Unexpected sampling rate %v of CNS report", rate)
+	}
+}
+
+func TestTelemetryConfig(t *testing.T) {
+	path := "azure-telemetry-config-test.json"
+	defer os.Remove(path)
+
+	ioutil.WriteFile(path, []byte(`{
+		"exporters": [
+			{"type": "file", "path": "reports.json"},
+			{"type": "statsd", "bestEffort": true}
+		],
+		"appInsights": {"instrumentationKey": "00000000-0000-0000-0000-000000000001"}
+	}`), 0600)
+
+	config, err := ReadTelemetryConfig(path)
+	if err != nil {
+		t.Fatalf("ReadTelemetryConfig failed, err:%v", err)
+	}
+
+	if len(config.Exporters) != 2 || config.Exporters[1].Type != ExporterStatsd || !config.Exporters[1].BestEffort ||
+		config.AppInsights.InstrumentationKey != "00000000-0000-0000-0000-000000000001" {
+		t.Errorf("Unexpected telemetry config %+v", config)
+	}
+
+	// Exporters of unknown types, and OTLP exporters without endpoint, are rejected.
+	if err = SetExporters([]ExporterConfig{{Type: "syslog"}}); err == nil {
+		t.Errorf("Unknown exporter type accepted")
+	}
+
+	if _, err = newExporter(ExporterConfig{Type: ExporterOTLP}); err == nil {
+		t.Errorf("OTLP exporter without endpoint accepted")
+	}
+}
+
+func TestExporters(t *testing.T) {
+	var payload Payload
+	payload.push(CNIReport{Name: "azure-vnet", ErrorMessage: "failed"})
+	payload.push(NPMReport{ClusterID: "cluster"})
+	payload.CNIOperationMetrics = []CNIOperationMetric{{Operation: "ADD", Count: 3, Failures: 1, LatencyMaxMs: 90}}
+
+	// The file exporter appends a line per report.
+	path := "azure-telemetry-reports-test.json"
+	defer os.Remove(path)
+
+	file, _ := newExporter(ExporterConfig{Type: ExporterFile, Path: path})
+	if err := file.export(&payload); err != nil {
+		t.Fatalf("File export failed, err:%v", err)
+	}
+
+	lines, err := ReadFileByLines(path)
+	if err != nil || len(lines) != 3 || !strings.Contains(lines[0], `"Type":"CNIReport"`) {
+		t.Errorf("Unexpected exported reports %v, err:%v", lines, err)
+	}
+
+	// The OTLP exporter posts a log record per report.
+	var logs otlpLogs
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/logs" && r.Header.Get("Authorization") == "token" {
+			json.NewDecoder(r.Body).Decode(&logs)
+		}
+	}))
+	defer collector.Close()
+
+	otlp, _ := newExporter(ExporterConfig{Type: ExporterOTLP, Endpoint: collector.URL, Headers: map[string]string{"Authorization": "token"}})
+	if err = otlp.export(&payload); err != nil {
+		t.Fatalf("OTLP export failed, err:%v", err)
+	}
+
+	if len(logs.ResourceLogs) != 1 || len(logs.ResourceLogs[0].ScopeLogs[0].LogRecords) != 3 {
+		t.Fatalf("Unexpected OTLP logs %+v", logs)
+	}
+
+	if record := logs.ResourceLogs[0].ScopeLogs[0].LogRecords[0]; record.Body.StringValue != "CNIReport" || record.SeverityText != "ERROR" {
+		t.Errorf("Unexpected OTLP log record %+v", record)
+	}
+
+	// The statsd exporter sends counters of the reports.
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed, err:%v", err)
+	}
+	defer server.Close()
+
+	statsd, _ := newExporter(ExporterConfig{Type: ExporterStatsd, Address: server.LocalAddr().String()})
+	if err = statsd.export(&payload); err != nil {
+		t.Fatalf("Statsd export failed, err:%v", err)
+	}
+
+	datagram := make([]byte, statsdMaxDatagramSize)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(datagram)
+	metrics := string(datagram[:n])
+	if err != nil || !strings.Contains(metrics, "azure_vnet.cnireport.errors:1|c") ||
+		!strings.Contains(metrics, "azure_vnet.cnioperationmetric.add.count:3|c") ||
+		!strings.Contains(metrics, "azure_vnet.cnioperationmetric.add.latency_max_ms:90|g") {
+		t.Errorf("Unexpected statsd metrics %q, err:%v", metrics, err)
+	}
+
+	// Failures of best effort exporters aren't returned.
+	bestEffort, _ := newExporter(ExporterConfig{Type: ExporterOTLP, Endpoint: "http://127.0.0.1:1", BestEffort: true})
+	if err = bestEffort.export(&payload); err != nil {
+		t.Errorf("Best effort exporter returned err:%v", err)
+	}
+}
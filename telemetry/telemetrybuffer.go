@@ -5,13 +5,13 @@ package telemetry
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/json"
-	"fmt"
 	"net"
-	"net/http"
 	"strings"
 	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/platform"
 )
 
 // FdName - file descriptor name
@@ -21,11 +21,17 @@ import (
 // DefaultCniReportsSize - default CNI report slice size
 // DefaultNpmReportsSize - default NPM report slice size
 // DefaultInterval - default interval for sending payload to host
+// MaxPayloadReports - max number of reports of each type buffered until sent to host
+// BufferFileName - name of the file persisting reports not yet sent to host
+// MaxBufferFileSize - max size of the buffer file
 const (
-	FdName          = "azure-telemetry"
-	Delimiter       = '\n'
-	HostNetAgentURL = "http://169.254.169.254/machine/plugins?comp=netagent&type=payload"
-	DefaultInterval = 1 * time.Minute
+	FdName            = "azure-telemetry"
+	Delimiter         = '\n'
+	HostNetAgentURL   = "http://169.254.169.254/machine/plugins?comp=netagent&type=payload"
+	DefaultInterval   = 1 * time.Minute
+	MaxPayloadReports = 1000
+	BufferFileName    = "azure-telemetry-buffer.json"
+	MaxBufferFileSize = 4 * 1024 * 1024
 )
 
 // TelemetryBuffer object
@@ -34,6 +40,8 @@ type TelemetryBuffer struct {
 	listener    net.Listener
 	connections []net.Conn
 	payload     Payload
+	buffer      *diskBuffer
+	exporters   []exporter
 	fdExists    bool
 	connected   bool
 	data        chan interface{}
@@ -41,11 +49,14 @@ type TelemetryBuffer struct {
 }
 
 // Payload object holds the different types of reports
+// CNI operation reports are aggregated into metrics instead of being sent individually
 type Payload struct {
-	DNCReports []DNCReport
-	CNIReports []CNIReport
-	NPMReports []NPMReport
-	CNSReports []CNSReport
+	DNCReports          []DNCReport
+	CNIReports          []CNIReport
+	NPMReports          []NPMReport
+	CNSReports          []CNSReport
+	CNIOperationMetrics []CNIOperationMetric
+	cniOperations       cniOperationWindow
 }
 
 // NewTelemetryBuffer - create a new TelemetryBuffer
@@ -118,17 +129,30 @@ func (tb *TelemetryBuffer) Start(intervalms time.Duration) {
 			intervalms = DefaultInterval
 		}
 
+		tb.exporters = getExporters()
+
+		// Replay reports buffered on disk by a previous instance.
+		tb.buffer = newDiskBuffer(platform.CNSRuntimePath+BufferFileName, MaxBufferFileSize)
+		if reports, err := tb.buffer.load(); err != nil {
+			log.Printf("[Telemetry] Failed to load buffered reports, err:%v.", err)
+		} else if len(reports) > 0 {
+			log.Printf("[Telemetry] Replaying %d buffered reports.", len(reports))
+			for _, report := range reports {
+				tb.payload.push(report)
+			}
+			tb.flush()
+		}
+
 		interval := time.NewTicker(intervalms).C
 		for {
 			select {
 			case <-interval:
-				// Send payload to host and clear cache when sent successfully
-				// To-do : if we hit max slice size in payload, write to disk and process the logs on disk on future sends
-				if err := tb.sendToHost(); err == nil {
-					tb.payload.reset()
-				}
+				tb.flush()
 			case report := <-tb.data:
 				tb.payload.push(report)
+				if err := tb.buffer.append(report); err != nil {
+					log.Printf("[Telemetry] Failed to buffer report, err:%v.", err)
+				}
 			case <-tb.cancel:
 				goto EXIT
 			}
@@ -162,6 +186,24 @@ func (tb *TelemetryBuffer) Write(b []byte) (c int, err error) {
 	return
 }
 
+// SendToTelemetryBuffer - send a report to the telemetry buffer of another process, without starting one
+func SendToTelemetryBuffer(report interface{}) error {
+	var tb TelemetryBuffer
+	if err := tb.Dial(FdName); err != nil {
+		return err
+	}
+
+	defer tb.client.Close()
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	_, err = tb.Write(b)
+	return err
+}
+
 // Cancel - signal to tear down telemetry buffer
 func (tb *TelemetryBuffer) Cancel() {
 	tb.cancel <- true
@@ -184,39 +226,87 @@ func (tb *TelemetryBuffer) close() {
 	}
 }
 
-// sendToHost - send payload to host
-func (tb *TelemetryBuffer) sendToHost() error {
-	httpc := &http.Client{}
-	var body bytes.Buffer
-	json.NewEncoder(&body).Encode(tb.payload)
-	resp, err := httpc.Post(HostNetAgentURL, ContentType, &body)
-	if err != nil {
-		return fmt.Errorf("[Telemetry] HTTP Post returned error %v", err)
+// flush - export payload and clear buffered reports when exported successfully
+func (tb *TelemetryBuffer) flush() {
+	tb.payload.CNIOperationMetrics = append(tb.payload.CNIOperationMetrics, tb.payload.cniOperations.close()...)
+	if len(tb.payload.CNIOperationMetrics) > MaxPayloadReports {
+		tb.payload.CNIOperationMetrics = tb.payload.CNIOperationMetrics[len(tb.payload.CNIOperationMetrics)-MaxPayloadReports:]
 	}
 
-	defer resp.Body.Close()
+	if err := tb.export(); err != nil {
+		return
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("[Telemetry] HTTP Post returned statuscode %d", resp.StatusCode)
+	tb.payload.reset()
+	if err := tb.buffer.clear(); err != nil {
+		log.Printf("[Telemetry] Failed to clear buffered reports, err:%v.", err)
+	}
+}
+
+// export - export payload with all exporters, returning the first failure
+func (tb *TelemetryBuffer) export() error {
+	var failure error
+	for _, e := range tb.exporters {
+		if err := e.export(&tb.payload); err != nil && failure == nil {
+			failure = err
+		}
 	}
 
-	return nil
+	return failure
 }
 
-// push - push the report (x) to corresponding slice
+// push - push the report (x) to corresponding slice, dropping the oldest report if the slice is full
 func (pl *Payload) push(x interface{}) {
 	switch x.(type) {
 	case DNCReport:
+		if len(pl.DNCReports) >= MaxPayloadReports {
+			pl.DNCReports = pl.DNCReports[1:]
+		}
 		pl.DNCReports = append(pl.DNCReports, x.(DNCReport))
 	case CNIReport:
+		if x.(CNIReport).OperationType != "" {
+			pl.cniOperations.add(x.(CNIReport))
+			break
+		}
+		if len(pl.CNIReports) >= MaxPayloadReports {
+			pl.CNIReports = pl.CNIReports[1:]
+		}
 		pl.CNIReports = append(pl.CNIReports, x.(CNIReport))
 	case NPMReport:
+		if len(pl.NPMReports) >= MaxPayloadReports {
+			pl.NPMReports = pl.NPMReports[1:]
+		}
 		pl.NPMReports = append(pl.NPMReports, x.(NPMReport))
 	case CNSReport:
+		if len(pl.CNSReports) >= MaxPayloadReports {
+			pl.CNSReports = pl.CNSReports[1:]
+		}
 		pl.CNSReports = append(pl.CNSReports, x.(CNSReport))
 	}
 }
 
+// reports - returns the reports of all types in the payload
+func (pl *Payload) reports() []interface{} {
+	var reports []interface{}
+	for i := range pl.DNCReports {
+		reports = append(reports, pl.DNCReports[i])
+	}
+	for i := range pl.CNIReports {
+		reports = append(reports, pl.CNIReports[i])
+	}
+	for i := range pl.NPMReports {
+		reports = append(reports, pl.NPMReports[i])
+	}
+	for i := range pl.CNSReports {
+		reports = append(reports, pl.CNSReports[i])
+	}
+	for i := range pl.CNIOperationMetrics {
+		reports = append(reports, pl.CNIOperationMetrics[i])
+	}
+
+	return reports
+}
+
 // reset - reset payload slices
 func (pl *Payload) reset() {
 	pl.DNCReports = nil
@@ -227,4 +317,5 @@ func (pl *Payload) reset() {
 	pl.NPMReports = make([]NPMReport, 0)
 	pl.CNSReports = nil
 	pl.CNSReports = make([]CNSReport, 0)
+	pl.CNIOperationMetrics = nil
 }
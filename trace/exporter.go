@@ -0,0 +1,286 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+package trace
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// EndpointEnv is the environment variable holding the URL of the OTLP collector spans are exported to.
+	// Tracing is disabled if it isn't set.
+	EndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+	// Path of the OTLP/HTTP traces endpoint of a collector.
+	tracesPath = "/v1/traces"
+
+	// Spans queued while the collector is unreachable are dropped beyond this limit.
+	maxQueuedSpans = 2048
+
+	// OTLP span kinds and status codes.
+	spanKindInternal = 1
+	statusCodeOk     = 1
+	statusCodeError  = 2
+
+	exportTimeout       = 5 * time.Second
+	instrumentationName = "github.com/Azure/azure-container-networking"
+)
+
+// Exporter exports spans to a tracing backend.
+type Exporter interface {
+	Export(serviceName string, spans []*Span) error
+}
+
+// otlpExporter exports spans to an OTLP collector over HTTP in JSON encoding.
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+var tracer struct {
+	mutex       sync.Mutex
+	serviceName string
+	exporter    Exporter
+	spans       []*Span
+	stop        chan struct{}
+}
+
+// Initialize enables the export of the spans of a service to the collector in the environment, if any.
+// Spans are exported every interval, or only when flushed if interval is zero.
+func Initialize(serviceName string, interval time.Duration) {
+	endpoint := os.Getenv(EndpointEnv)
+	if endpoint == "" {
+		return
+	}
+
+	log.Printf("[trace] Exporting spans of %s to %s.", serviceName, endpoint)
+
+	SetExporter(serviceName, &otlpExporter{
+		url:    strings.TrimRight(endpoint, "/") + tracesPath,
+		client: &http.Client{Timeout: exportTimeout},
+	})
+
+	if interval > 0 {
+		tracer.mutex.Lock()
+		tracer.stop = make(chan struct{})
+		stop := tracer.stop
+		tracer.mutex.Unlock()
+
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					Flush()
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// SetExporter sets the exporter of the spans of a service. Spans aren't kept if exporter is nil.
+func SetExporter(serviceName string, exporter Exporter) {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+
+	tracer.serviceName = serviceName
+	tracer.exporter = exporter
+}
+
+// Close stops periodic exports and exports the remaining spans.
+func Close() {
+	tracer.mutex.Lock()
+	if tracer.stop != nil {
+		close(tracer.stop)
+		tracer.stop = nil
+	}
+	tracer.mutex.Unlock()
+
+	if err := Flush(); err != nil {
+		log.Printf("[trace] Failed to export spans, err:%v.", err)
+	}
+}
+
+// queue queues an ended span for export.
+func queue(span *Span) {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+
+	if tracer.exporter == nil {
+		return
+	}
+
+	if len(tracer.spans) >= maxQueuedSpans {
+		tracer.spans = tracer.spans[1:]
+	}
+
+	tracer.spans = append(tracer.spans, span)
+}
+
+// Flush exports the queued spans. Spans are kept for the next export if it fails.
+func Flush() error {
+	tracer.mutex.Lock()
+	spans, exporter, serviceName := tracer.spans, tracer.exporter, tracer.serviceName
+	tracer.spans = nil
+	tracer.mutex.Unlock()
+
+	if exporter == nil || len(spans) == 0 {
+		return nil
+	}
+
+	err := exporter.Export(serviceName, spans)
+	if err != nil {
+		tracer.mutex.Lock()
+		tracer.spans = append(spans, tracer.spans...)
+		if len(tracer.spans) > maxQueuedSpans {
+			tracer.spans = tracer.spans[len(tracer.spans)-maxQueuedSpans:]
+		}
+		tracer.mutex.Unlock()
+	}
+
+	return err
+}
+
+// OTLP JSON encoding of spans.
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// encodeSpans returns the OTLP export request of the spans of a service.
+func encodeSpans(serviceName string, spans []*Span) *otlpRequest {
+	var encoded []otlpSpan
+
+	for _, span := range spans {
+		span.mutex.Lock()
+
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.Context.SpanID[:]),
+			Name:              span.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+			Status:            otlpStatus{Code: statusCodeOk},
+		}
+
+		if span.ParentSpanID != [8]byte{} {
+			s.ParentSpanID = hex.EncodeToString(span.ParentSpanID[:])
+		}
+
+		if span.ErrorMessage != "" {
+			s.Status = otlpStatus{Code: statusCodeError, Message: span.ErrorMessage}
+		}
+
+		keys := make([]string, 0, len(span.Attributes))
+		for key := range span.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s.Attributes = append(s.Attributes, otlpAttribute{Key: key, Value: otlpValue{span.Attributes[key]}})
+		}
+
+		span.mutex.Unlock()
+
+		encoded = append(encoded, s)
+	}
+
+	return &otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource: otlpResource{
+					Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{serviceName}}},
+				},
+				ScopeSpans: []otlpScopeSpans{
+					{
+						Scope: otlpScope{Name: instrumentationName},
+						Spans: encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+// Export posts the spans to the collector.
+func (exporter *otlpExporter) Export(serviceName string, spans []*Span) error {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(encodeSpans(serviceName, spans)); err != nil {
+		return err
+	}
+
+	resp, err := exporter.client.Post(exporter.url, "application/json", &body)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Collector returned status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TraceParentHeader is the W3C trace context header propagating the span of a request.
+	TraceParentHeader = "traceparent"
+
+	// TraceParentEnv is the environment variable propagating the span of a process.
+	TraceParentEnv = "TRACEPARENT"
+
+	// Version and sampled flag of W3C trace contexts.
+	traceParentVersion = "00"
+	traceFlagSampled   = "01"
+)
+
+// SpanContext identifies a span and the trace it belongs to.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// Span is a timed operation of a trace.
+type Span struct {
+	Name         string
+	Context      SpanContext
+	ParentSpanID [8]byte
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]string
+	ErrorMessage string
+	mutex        sync.Mutex
+}
+
+// IsValid checks if the span context identifies a span.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent returns the span context in W3C traceparent format.
+func (sc SpanContext) TraceParent() string {
+	return fmt.Sprintf("%s-%s-%s-%s", traceParentVersion,
+		hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), traceFlagSampled)
+}
+
+// ParseTraceParent parses a span context in W3C traceparent format.
+func ParseTraceParent(traceParent string) (SpanContext, error) {
+	var sc SpanContext
+
+	fields := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" {
+		return sc, fmt.Errorf("Invalid traceparent %q", traceParent)
+	}
+
+	traceID, err := hex.DecodeString(fields[1])
+	if err != nil || len(traceID) != len(sc.TraceID) {
+		return sc, fmt.Errorf("Invalid trace ID in traceparent %q", traceParent)
+	}
+
+	spanID, err := hex.DecodeString(fields[2])
+	if err != nil || len(spanID) != len(sc.SpanID) {
+		return sc, fmt.Errorf("Invalid span ID in traceparent %q", traceParent)
+	}
+
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("Invalid traceparent %q", traceParent)
+	}
+
+	return sc, nil
+}
+
+// Inject adds the span context to the headers of an outgoing request.
+func Inject(req *http.Request, sc SpanContext) {
+	if sc.IsValid() {
+		req.Header.Set(TraceParentHeader, sc.TraceParent())
+	}
+}
+
+// Extract returns the span context in the headers of an incoming request, if any.
+func Extract(req *http.Request) SpanContext {
+	sc, _ := ParseTraceParent(req.Header.Get(TraceParentHeader))
+	return sc
+}
+
+// FromEnvironment returns the span context the process was started with, if any.
+func FromEnvironment() SpanContext {
+	sc, _ := ParseTraceParent(os.Getenv(TraceParentEnv))
+	return sc
+}
+
+// StartSpan starts a span as a child of the given parent, or of a new trace if the parent isn't valid.
+func StartSpan(name string, parent SpanContext) *Span {
+	span := &Span{
+		Name:       name,
+		StartTime:  time.Now(),
+		Attributes: make(map[string]string),
+	}
+
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+	}
+
+	rand.Read(span.Context.SpanID[:])
+
+	return span
+}
+
+// SetAttribute sets an attribute of the span.
+func (span *Span) SetAttribute(key string, value string) {
+	span.mutex.Lock()
+	span.Attributes[key] = value
+	span.mutex.Unlock()
+}
+
+// End ends the span, failed if err isn't nil, and queues it for export.
+func (span *Span) End(err error) {
+	span.mutex.Lock()
+	if !span.EndTime.IsZero() {
+		span.mutex.Unlock()
+		return
+	}
+
+	span.EndTime = time.Now()
+	if err != nil {
+		span.ErrorMessage = err.Error()
+	}
+	span.mutex.Unlock()
+
+	queue(span)
+}
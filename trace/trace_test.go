@@ -0,0 +1,78 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceParent(t *testing.T) {
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	sc, err := ParseTraceParent(traceParent)
+	if err != nil {
+		t.Fatalf("ParseTraceParent failed: %v", err)
+	}
+
+	if sc.TraceParent() != traceParent {
+		t.Errorf("Unexpected traceparent %s", sc.TraceParent())
+	}
+
+	for _, invalid := range []string{"", "00-4bf92f3577b34da6a3ce929d0e0e4736-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		if _, err := ParseTraceParent(invalid); err == nil {
+			t.Errorf("ParseTraceParent succeeded for %q", invalid)
+		}
+	}
+}
+
+func TestExportSpans(t *testing.T) {
+	var request otlpRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tracesPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		json.NewDecoder(r.Body).Decode(&request)
+	}))
+	defer server.Close()
+
+	SetExporter("test", &otlpExporter{url: server.URL + tracesPath, client: http.DefaultClient})
+	defer SetExporter("", nil)
+
+	parent := StartSpan("parent", SpanContext{})
+	child := StartSpan("child", parent.Context)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	Inject(req, child.Context)
+	if Extract(req) != child.Context {
+		t.Errorf("Extracted span context doesn't match the injected one")
+	}
+
+	child.SetAttribute("key", "value")
+	child.End(errors.New("failed"))
+	parent.End(nil)
+
+	if err := Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Unexpected number of spans exported %d", len(spans))
+	}
+
+	if spans[0].TraceID != spans[1].TraceID || spans[0].ParentSpanID != spans[1].SpanID {
+		t.Errorf("Child span isn't part of the parent span %+v", spans)
+	}
+
+	if spans[0].Status.Code != statusCodeError || spans[0].Attributes[0].Value.StringValue != "value" {
+		t.Errorf("Unexpected child span %+v", spans[0])
+	}
+}